@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/trusch/deadman-switch/pkg/agent"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// runAgent registers the local systemd timers at a server and keeps them in sync
+func runAgent(args []string) {
+	flags := pflag.NewFlagSet("agent", pflag.ExitOnError)
+	var (
+		cfg       agent.Config
+		logLevel  = flags.String("log-level", "info", "log level")
+		logFormat = flags.String("log-format", "json", "log format ('json' or 'console')")
+		grace     = flags.Duration("grace", 5*time.Minute, "grace period added to the timer intervals")
+	)
+	flags.StringVar(&cfg.Server, "server", "http://localhost:8080", "deadman-switch server URL")
+	flags.StringVar(&cfg.Username, "username", "admin", "admin username of the server")
+	flags.StringVar(&cfg.Password, "password", os.Getenv("DEADMAN_SWITCH_PASSWORD"), "admin password of the server (default $DEADMAN_SWITCH_PASSWORD)")
+	flags.DurationVar(&cfg.Interval, "interval", time.Minute, "how often to look for timers")
+	flags.StringVar(&cfg.Systemd.Pattern, "pattern", "*.timer", "glob pattern selecting the timer units")
+	flags.StringVar(&cfg.Systemd.Prefix, "prefix", "", "service ID prefix (default systemd/<hostname>)")
+	flags.StringVar(&cfg.Systemd.Token, "token", "", "token of newly registered services")
+	flags.BoolVar(&cfg.Systemd.Prune, "prune", false, "delete services and drop-ins of removed timers")
+	flags.BoolVar(&cfg.InstallHooks, "install-hooks", true, "install ExecStartPost drop-ins which ping after successful runs")
+	flags.StringVar(&cfg.DropInDir, "drop-in-dir", "/etc/systemd/system", "directory for the generated drop-ins")
+	flags.StringVar(&cfg.Binary, "binary", "", "deadman-switch binary used by the drop-ins (default this binary)")
+	once := flags.Bool("once", false, "sync once and exit")
+	flags.Parse(args)
+
+	setupLogging(*logLevel, *logFormat)
+	cfg.Systemd.Grace = config.Duration(*grace)
+
+	a, err := agent.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to setup agent")
+	}
+	ctx := signalContext()
+	if *once {
+		err = a.Sync(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to sync systemd timers")
+		}
+		return
+	}
+	log.Info().Str("server", cfg.Server).Msg("start syncing systemd timers")
+	err = a.Run(ctx)
+	if err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("agent stopped unexpectedly")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/trusch/deadman-switch/pkg/bench"
+)
+
+// runBench generates load against a server and reports the latencies and the checker lag
+func runBench(args []string) {
+	flags := pflag.NewFlagSet("bench", pflag.ExitOnError)
+	var (
+		cfg       bench.Config
+		pingRate  = flags.String("ping-rate", "100/s", "pings per second (500/s), minute (500/m) or hour (500/h)")
+		asJSON    = flags.Bool("json", false, "print the report as JSON")
+		logLevel  = flags.String("log-level", "info", "log level")
+		logFormat = flags.String("log-format", "console", "log format ('json' or 'console')")
+	)
+	flags.StringVar(&cfg.Server, "server", "http://localhost:8080", "deadman-switch server URL")
+	flags.StringVar(&cfg.Username, "username", "admin", "admin username of the server")
+	flags.StringVar(&cfg.Password, "password", os.Getenv("DEADMAN_SWITCH_PASSWORD"), "admin password of the server (default $DEADMAN_SWITCH_PASSWORD)")
+	flags.IntVar(&cfg.Services, "services", 1000, "number of pinged services")
+	flags.DurationVar(&cfg.Duration, "duration", time.Minute, "how long to ping")
+	flags.IntVar(&cfg.Concurrency, "concurrency", 50, "requests in flight at most")
+	flags.StringVar(&cfg.Prefix, "prefix", "bench/", "service ID prefix of the synthetic services")
+	flags.DurationVar(&cfg.Timeout, "timeout", 0, "timeout of the pinged services (default 3 times the time between their pings, at least 1m)")
+	flags.IntVar(&cfg.Expiring, "expiring", 100, "number of services which are never pinged again, their alerts measure the checker lag")
+	flags.DurationVar(&cfg.ExpiringTimeout, "expiring-timeout", 10*time.Second, "timeout of the expiring services")
+	flags.StringVar(&cfg.WebhookListen, "webhook-listen", ":9099", "address the alert webhooks are received on")
+	flags.StringVar(&cfg.WebhookURL, "webhook-url", "", "URL the server reaches the webhook address with (default http://localhost:<port>)")
+	flags.DurationVar(&cfg.LagWait, "lag-wait", time.Minute, "time to wait for alerts after the deadlines of the expiring services")
+	flags.BoolVar(&cfg.Cleanup, "cleanup", true, "delete the services at the end")
+	flags.Parse(args)
+
+	setupLogging(*logLevel, *logFormat)
+	rate, err := bench.ParseRate(*pingRate)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid --ping-rate")
+	}
+	cfg.PingRate = rate
+	if cfg.WebhookURL == "" && cfg.Expiring > 0 {
+		_, port, err := net.SplitHostPort(cfg.WebhookListen)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid --webhook-listen")
+		}
+		cfg.WebhookURL = "http://localhost:" + port
+	}
+
+	// an interrupted run still reports what it measured
+	report, err := bench.Run(signalContext(), cfg)
+	if err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("benchmark failed")
+	}
+	if *asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(report)
+		return
+	}
+	report.Print(os.Stdout)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/trusch/deadman-switch/pkg/client"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/discovery"
+)
+
+// runImport creates services for the checks of Healthchecks.io, the monitors of Cronitor or the series and recording rules of Prometheus
+func runImport(args []string) {
+	flags := pflag.NewFlagSet("import", pflag.ExitOnError)
+	var (
+		cfg          config.ImportConfig
+		from         = flags.String("from", "", "service to import from ('healthchecks', 'cronitor' or 'prometheus')")
+		server       = flags.String("server", "http://localhost:8080", "deadman-switch server URL")
+		username     = flags.String("username", "admin", "admin username of the server")
+		password     = flags.String("password", os.Getenv("DEADMAN_SWITCH_PASSWORD"), "admin password of the server (default $DEADMAN_SWITCH_PASSWORD)")
+		grace        = flags.Duration("grace", 5*time.Minute, "grace period added to schedules of checks without an own grace period and to the interval of recording rules, the timeout of prometheus series")
+		integrations = flags.String("integrations", "", "YAML file mapping integration names or kinds onto notifications")
+		dryRun       = flags.Bool("dry-run", false, "print the service configs instead of creating them")
+		logLevel     = flags.String("log-level", "info", "log level")
+		logFormat    = flags.String("log-format", "console", "log format ('json' or 'console')")
+	)
+	flags.StringVar(&cfg.APIKey, "api-key", "", "API key of the service to import from, a bearer token for prometheus")
+	flags.StringVar(&cfg.URL, "url", "", "API URL of the service to import from (default the public service)")
+	flags.StringVar(&cfg.Prefix, "prefix", "", "service ID prefix of the imported services")
+	flags.StringVar(&cfg.Query, "query", "", "prometheus query whose series become services, one per job and instance (default up)")
+	flags.StringVar(&cfg.Rules, "rules", "", "prometheus rules file whose recording rules become services instead")
+	flags.Parse(args)
+
+	setupLogging(*logLevel, *logFormat)
+	cfg.Grace = config.Duration(*grace)
+	if cfg.APIKey == "" && *from != "prometheus" {
+		log.Fatal().Msg("--api-key is required")
+	}
+	if *integrations != "" {
+		bs, err := ioutil.ReadFile(*integrations)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to read integrations")
+		}
+		err = yaml.Unmarshal(bs, &cfg.Integrations)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse integrations")
+		}
+	}
+
+	var source discovery.Source
+	switch *from {
+	case "healthchecks":
+		source = discovery.NewHealthchecksSource(cfg)
+	case "cronitor":
+		source = discovery.NewCronitorSource(cfg)
+	case "prometheus":
+		if cfg.URL == "" && cfg.Rules == "" {
+			log.Fatal().Msg("--url or --rules is required")
+		}
+		source = discovery.NewPrometheusSource(cfg)
+	default:
+		log.Fatal().Str("from", *from).Msg("--from must be 'healthchecks', 'cronitor' or 'prometheus'")
+	}
+
+	ctx := signalContext()
+	if *dryRun {
+		services, err := source.Discover(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to fetch checks")
+		}
+		bs, err := yaml.Marshal(map[string][]config.ServiceConfig{"services": services})
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to encode service configs")
+		}
+		fmt.Print(string(bs))
+		return
+	}
+	// existing services are updated, but keep the fields the import doesn't set
+	syncer := discovery.NewSyncer(client.New(*server, *username, *password), nil, 0)
+	err := syncer.Sync(ctx, source)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to import checks")
+	}
+}
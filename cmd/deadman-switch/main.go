@@ -3,22 +3,45 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/ghodss/yaml"
-	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
+	"github.com/trusch/deadman-switch/pkg/actions"
+	"github.com/trusch/deadman-switch/pkg/archival"
+	"github.com/trusch/deadman-switch/pkg/auth"
+	"github.com/trusch/deadman-switch/pkg/canary"
+	"github.com/trusch/deadman-switch/pkg/chatops"
 	"github.com/trusch/deadman-switch/pkg/checker"
+	"github.com/trusch/deadman-switch/pkg/clock"
 	"github.com/trusch/deadman-switch/pkg/concurrency"
 	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/debug"
+	"github.com/trusch/deadman-switch/pkg/discovery"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/execnotifier"
+	"github.com/trusch/deadman-switch/pkg/incidents"
+	"github.com/trusch/deadman-switch/pkg/links"
 	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/plugins"
+	"github.com/trusch/deadman-switch/pkg/profiling"
 	"github.com/trusch/deadman-switch/pkg/queue"
+	"github.com/trusch/deadman-switch/pkg/replication"
+	"github.com/trusch/deadman-switch/pkg/selfcheck"
 	"github.com/trusch/deadman-switch/pkg/server"
+	"github.com/trusch/deadman-switch/pkg/slackapp"
 	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/usage"
+	"github.com/trusch/deadman-switch/pkg/watchdog"
+	"github.com/trusch/deadman-switch/pkg/webpush"
 	"go.etcd.io/etcd/clientv3"
 )
 
@@ -27,11 +50,33 @@ var (
 	showVersion     = pflag.BoolP("version", "v", false, "show version")
 	logLevel        = pflag.String("log-level", "info", "log level")
 	logFormat       = pflag.String("log-format", "json", "log format ('json' or 'console')")
+	readOnlyFlag    = pflag.Bool("read-only", false, "run as read-only replica, which serves the read APIs and accepts pings, but never checks deadlines or sends notifications")
 	Version, Commit string
 )
 
 func main() {
-	ctx := context.Background()
+	// subcommands, everything else starts the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "ping":
+			runPing(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "vapid-keys":
+			runVAPIDKeys()
+			return
+		}
+	}
+
+	ctx := signalContext()
 
 	pflag.Parse()
 
@@ -40,19 +85,7 @@ func main() {
 		os.Exit(0)
 	}
 
-	lvl, err := zerolog.ParseLevel(*logLevel)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to parse --log-level flag")
-	}
-	zerolog.SetGlobalLevel(lvl)
-
-	switch *logFormat {
-	case "json":
-	case "console":
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	default:
-		log.Fatal().Str("format", *logFormat).Msg("unknown log format")
-	}
+	setupLogging(*logLevel, *logFormat)
 
 	cfg, err := loadConfig()
 	if err != nil {
@@ -61,6 +94,120 @@ func main() {
 			Str("file", *configFile).
 			Msg("failed to load config")
 	}
+	if *readOnlyFlag && cfg.ReadOnly == nil {
+		cfg.ReadOnly = &config.ReadOnlyConfig{}
+	}
+	readOnly := cfg.ReadOnly != nil
+	if readOnly {
+		err = cfg.ReadOnly.Validate(cfg.Storage.Type)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid read-only config")
+		}
+		log.Info().Str("primary", cfg.ReadOnly.Primary).Msg("running as read-only replica, the primary checks the deadlines and sends the notifications")
+	}
+	// the egress policy limits where notifications may connect to
+	if cfg.Egress != nil {
+		err = cfg.Egress.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid egress policy")
+		}
+	}
+	egressPolicy := egress.New(cfg.Egress)
+	err = plugins.Register(ctx, cfg.Plugins, egressPolicy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load the plugins")
+	}
+	err = execnotifier.Register(cfg.Exec)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid exec config")
+	}
+	err = notifier.RegisterGCPCredentials(cfg.GCPCredentials)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid gcp credentials")
+	}
+	err = cfg.Approvals.Validate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid approvals config")
+	}
+	plans := make(map[string]bool)
+	for _, plan := range cfg.ActionPlans {
+		err = plan.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid action plan")
+		}
+		if plan.Destructive && (cfg.Approvals.URL == "" || cfg.Approvals.Secret == "") {
+			log.Fatal().Str("plan", plan.Name).Msg("destructive action plans need the approvals url and secret")
+		}
+		for _, notification := range plan.ApprovalNotifications {
+			err = notifier.ValidateNotification(notification)
+			if err != nil {
+				log.Fatal().Err(err).Str("plan", plan.Name).Msg("invalid approval notification")
+			}
+		}
+		plans[plan.Name] = true
+	}
+	escalations := make(map[string]bool)
+	for _, policy := range cfg.Escalations {
+		err = policy.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid escalation policy")
+		}
+		for _, tier := range policy.Tiers {
+			for _, notification := range append(append([]config.NotificationConfig{}, tier.AlertNotifications...), tier.RecoveryNotifications...) {
+				err = notifier.ValidateNotification(notification)
+				if err != nil {
+					log.Fatal().Err(err).Str("policy", policy.Name).Msg("invalid escalation notification")
+				}
+			}
+		}
+		if escalations[policy.Name] {
+			log.Fatal().Str("policy", policy.Name).Msg("duplicate escalation policy")
+		}
+		escalations[policy.Name] = true
+	}
+	silences := make(map[string]bool)
+	for _, silence := range cfg.Silences {
+		err = silence.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid silence")
+		}
+		if silences[silence.Name] {
+			log.Fatal().Str("silence", silence.Name).Msg("duplicate silence")
+		}
+		silences[silence.Name] = true
+	}
+	for _, defaults := range cfg.Defaults {
+		if defaults.EscalationPolicy != "" && !escalations[defaults.EscalationPolicy] {
+			log.Fatal().Str("prefix", defaults.Prefix).Str("policy", defaults.EscalationPolicy).Msg("unknown escalation policy")
+		}
+		if defaults.ActiveHours != nil {
+			err = defaults.ActiveHours.Validate()
+			if err != nil {
+				log.Fatal().Err(err).Str("prefix", defaults.Prefix).Msg("invalid defaults")
+			}
+		}
+	}
+	for _, svc := range cfg.Services {
+		// the services of the file pass the same checks as the ones created through the API
+		err = server.ValidateServiceConfig(svc)
+		if err != nil {
+			log.Fatal().Err(err).Str("service", svc.ID).Msg("invalid service config")
+		}
+		err = server.ValidateNotifications(svc)
+		if err != nil {
+			log.Fatal().Err(err).Str("service", svc.ID).Msg("invalid notification")
+		}
+		if svc.OneShot != nil {
+			// they would come back on every start after they were archived
+			log.Fatal().Str("service", svc.ID).Msg("one-shot services are created through the API")
+		}
+		if svc.ActionPlan != "" && !plans[svc.ActionPlan] {
+			log.Fatal().Str("service", svc.ID).Str("plan", svc.ActionPlan).Msg("unknown action plan")
+		}
+		if svc.EscalationPolicy != "" && !escalations[svc.EscalationPolicy] {
+			log.Fatal().Str("service", svc.ID).Str("policy", svc.EscalationPolicy).Msg("unknown escalation policy")
+		}
+	}
 
 	var (
 		store             storage.Storage
@@ -69,13 +216,46 @@ func main() {
 	)
 	switch cfg.Storage.Type {
 	case config.StorageTypeMemory:
-		store = storage.NewMemoryStorage(cfg)
+		store, err = storage.NewMemoryStorage(ctx, cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to setup memory storage")
+		}
+		// single node, so we are always the leader and send notifications directly
+		concurrencyClient = concurrency.NewLocalClient()
+		var memCfg config.MemoryStorageConfig
+		err = config.Decode(cfg.Storage.Config, &memCfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load memory storage config")
+		}
+		if memCfg.QueueFile != "" {
+			queueClient, err = queue.NewLevelDBQueue(memCfg.QueueFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to open queue file")
+			}
+		}
 	case config.StorageTypeFile:
 		store, err = storage.NewFileStorage(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open storage file")
+		}
+		// single node, so we are always the leader, but keep the notifications in a local queue so they survive restarts
+		concurrencyClient = concurrency.NewLocalClient()
+		var fileCfg config.FileStorageConfig
+		err = config.Decode(cfg.Storage.Config, &fileCfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load file storage config")
+		}
+		if fileCfg.QueueFile == "" {
+			fileCfg.QueueFile = fileCfg.File + ".queue"
+		}
+		queueClient, err = queue.NewLevelDBQueue(fileCfg.QueueFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open queue file")
+		}
 	case config.StorageTypeEtcd:
 		// parse connection config
 		var etcdConfig config.EtcdStorageConfig
-		err := mapstructure.Decode(cfg.Storage.Config, &etcdConfig)
+		err := config.Decode(cfg.Storage.Config, &etcdConfig)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to load etcd endpoints")
 		}
@@ -93,23 +273,25 @@ func main() {
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to connect to etcd")
 		}
-		// make local service configs globally available
-		for _, svc := range cfg.Services {
-			err := s.SaveServiceConfig(ctx, svc)
-			if err != nil {
-				log.Fatal().Err(err).Msg("failed to save local configs to etcd")
+		// make local service configs globally available, read-only replicas leave that to the primary
+		if !readOnly {
+			for _, svc := range cfg.Services {
+				err := s.SaveServiceConfig(ctx, svc)
+				if err != nil {
+					log.Fatal().Err(err).Msg("failed to save local configs to etcd")
+				}
 			}
 		}
 		store = s
 
 		// setup concurrency client
-		concurrencyClient, err = concurrency.NewEtcdClient(ctx, cli)
+		concurrencyClient, err = concurrency.NewEtcdClient(ctx, cli, etcdConfig.Election)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to setup concurrency client")
 		}
 
 		// setup queue client
-		queueClient, err = queue.NewEtcdQueue(ctx, cli, "/deadman-switch/queue")
+		queueClient, err = queue.NewEtcdQueue(ctx, cli, "/deadman-switch/queue", etcdConfig.Election)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to setup queue client")
 		}
@@ -117,21 +299,331 @@ func main() {
 		log.Fatal().Msg("unknown storage type configured")
 	}
 
-	notifier := notifier.NewNotifier(ctx, store, queueClient)
-	_ = notifier
+	// validate the stored configs before anything reads them, a single corrupt one would be skipped on every check.
+	// Only the leader checks, so the instances of a cluster don't quarantine and migrate the same configs.
+	if !cfg.Storage.Check.Disabled && !readOnly && checksStoredConfigs(ctx, concurrencyClient) {
+		// the stored configs may leave fields to the defaults, like the timeout
+		validate := func(svc config.ServiceConfig) error {
+			return server.ValidateServiceConfig(svc.WithDefaults(cfg.Defaults))
+		}
+		check, err := storage.CheckServiceConfigs(ctx, store, validate, cfg.Storage.Check)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to check the stored service configs")
+		}
+		log.Info().
+			Int("valid", check.Valid).
+			Int("invalid", len(check.Invalid)).
+			Int("old-format", len(check.Migrated)).
+			Int("unknown-fields", len(check.UnknownFields)).
+			Bool("quarantine", cfg.Storage.Check.Quarantine).
+			Bool("migrate", cfg.Storage.Check.Migrate).
+			Msg("checked stored service configs")
+	}
+
+	// record every change of a service config, so it can be rolled back
+	store = storage.WithConfigVersions(store)
+
+	// setup service discovery, it works on the raw configs without defaults applied
+	var sources []discovery.Source
+	if cfg.Discovery.Kubernetes != nil {
+		source, err := discovery.NewKubernetesSource(*cfg.Discovery.Kubernetes)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to setup kubernetes discovery")
+		}
+		sources = append(sources, source)
+	}
+	if cfg.Discovery.Consul != nil {
+		source, err := discovery.NewConsulSource(*cfg.Discovery.Consul)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to setup consul discovery")
+		}
+		sources = append(sources, source)
+	}
+	if cfg.Discovery.Nomad != nil {
+		source, err := discovery.NewNomadSource(*cfg.Discovery.Nomad)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to setup nomad discovery")
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) > 0 && !readOnly {
+		interval := time.Duration(cfg.Discovery.Interval)
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		syncer := discovery.NewSyncer(store, concurrencyClient, interval, sources...)
+		log.Info().Int("sources", len(sources)).Msg("start service discovery")
+		go syncer.Backend(ctx)
+	}
+
+	// apply per-prefix defaults and escalation policies to all service configs
+	store = storage.WithDefaults(store, cfg.Defaults, cfg.Escalations)
+
+	// make the statically configured contacts available
+	for _, contact := range cfg.Contacts {
+		err = contact.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid contact")
+		}
+		err = store.SaveContact(ctx, contact)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to save contact")
+		}
+	}
+	if !readOnly {
+		err = storage.SaveConfigSilences(ctx, store, cfg.Silences, time.Now())
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to save silences")
+		}
+	}
+
+	for _, quorum := range cfg.Quorums {
+		err = quorum.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid quorum")
+		}
+	}
+
+	// the records of this server in the shared storage are kept under its node ID
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		nodeID, err = os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to get the host name, set nodeID")
+		}
+	}
+
+	var clk clock.Clock = clock.Real
+	if cfg.SimulatedClock {
+		log.Warn().Msg("using a simulated clock, time only moves through the /clock API")
+		clk = clock.NewSimulated(time.Now())
+	}
+
+	// register the self check service of this node and ping it through our own API
+	if cfg.SelfCheck != nil && !cfg.SelfCheck.Disabled && !readOnly {
+		err = cfg.SelfCheck.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid self check config")
+		}
+		err = selfcheck.Register(ctx, store, *cfg.SelfCheck, time.Duration(cfg.CheckInterval), clk, nodeID)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to register self check service")
+		}
+		pinger, err := selfcheck.NewPinger(cfg.HTTPListenAddress, *cfg.SelfCheck, nodeID)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to setup self check")
+		}
+		go pinger.Backend(ctx)
+	}
+
+	if len(cfg.CircuitBreaker.Notifications) == 0 {
+		cfg.CircuitBreaker.Notifications = cfg.MetaAlerts.Notifications
+	}
+	// the slack app provides the tokens of the connected workspaces
+	var slackApp *slackapp.App
+	var slackTokens notifier.SlackApp
+	if cfg.SlackApp != nil {
+		if cfg.SlackApp.ClientID == "" || cfg.SlackApp.ClientSecret == "" || cfg.SlackApp.URL == "" {
+			log.Fatal().Msg("the slack app needs a client ID, a client secret and the URL of the server")
+		}
+		slackApp = slackapp.NewApp(*cfg.SlackApp, store)
+		slackTokens = slackApp
+	}
+	// short links in notifications open the read-only page of the service
+	var shortLinks *links.Links
+	var notificationLinks notifier.Links
+	if cfg.Links != nil {
+		err = cfg.Links.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid links config")
+		}
+		shortLinks = links.New(*cfg.Links, store)
+		notificationLinks = shortLinks
+		go shortLinks.Backend(ctx)
+	}
+	// the app subscribes browsers to push notifications
+	var pusher *webpush.Pusher
+	var notificationPush notifier.WebPush
+	if cfg.WebPush != nil {
+		err = cfg.WebPush.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid web push config")
+		}
+		pusher, err = webpush.NewPusher(*cfg.WebPush, store, egressPolicy)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid web push config")
+		}
+		notificationPush = pusher
+	}
+	// tenants share the deployment within their quotas
+	var meter *usage.Meter
+	var notificationMeter notifier.Meter
+	if len(cfg.Tenants) > 0 {
+		err = config.ValidateTenants(cfg.Tenants)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid tenants config")
+		}
+		meter = usage.NewMeter(cfg.Tenants, store, clk, nodeID)
+		notificationMeter = meter
+		go meter.Backend(ctx)
+	}
+	notifier := notifier.NewNotifier(ctx, store, queueClient, cfg.ReadOnly != nil, cfg.ContactChannels, cfg.CircuitBreaker, slackTokens, notificationLinks, notificationPush, notificationMeter, egressPolicy, clk)
+
+	emitter := events.NewEmitter(ctx, cfg.LifecycleWebhooks)
+	if cfg.EventStream != nil {
+		err = cfg.EventStream.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid event stream config")
+		}
+		emitter = events.Multi{emitter, events.NewKafkaEmitter(ctx, *cfg.EventStream)}
+	}
+	if cfg.Incidents != nil {
+		emitter = events.Multi{emitter, incidents.NewManager(ctx, store, concurrencyClient, *cfg.Incidents)}
+	}
+	if len(cfg.ActionPlans) > 0 {
+		emitter = events.Multi{emitter, actions.NewRunner(ctx, store, concurrencyClient, notifier, cfg.ActionPlans, cfg.Approvals, clk)}
+	}
 
 	// setup checker which will check for deadlines and send out notifications if needed
-	checker := checker.NewChecker(store, concurrencyClient, notifier, time.Duration(cfg.CheckInterval))
-	log.Info().Str("backend", string(cfg.Storage.Type)).Msg("start checking deadlines")
-	go checker.Backend(ctx)
+	checker := checker.NewChecker(store, concurrencyClient, notifier, time.Duration(cfg.CheckInterval), cfg.InhibitRules, cfg.Quorums, emitter, clk)
+	if !readOnly {
+		log.Info().Str("backend", string(cfg.Storage.Type)).Msg("start checking deadlines")
+		go checker.Backend(ctx)
+	}
+
+	// profile the instance through a private listener
+	if cfg.Profiling != nil {
+		if cfg.Profiling.Listen == "" {
+			log.Fatal().Msg("profiling needs the listen address")
+		}
+		go func() {
+			err := profiling.Serve(ctx, *cfg.Profiling, checker)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to serve profiling")
+			}
+		}()
+	}
+
+	// dump the in-memory state on SIGUSR1 and at /debug/state, to diagnose why alerts didn't fire
+	dumper := debug.NewDumper(Version, Commit)
+	dumper.Register("cluster", func(ctx context.Context) (interface{}, error) {
+		return concurrencyClient.Status(), nil
+	})
+	dumper.Register("services", debug.Services(store))
+	dumper.Register("checker", func(ctx context.Context) (interface{}, error) {
+		return checker.State(), nil
+	})
+	dumper.Register("breakers", func(ctx context.Context) (interface{}, error) {
+		return notifier.Breakers(), nil
+	})
+	if queueClient != nil {
+		dumper.Register("queue", func(ctx context.Context) (interface{}, error) {
+			return notifier.QueueState(ctx)
+		})
+	}
+	go dumper.HandleSignal(ctx)
+
+	// watch the checker of this instance, the leader watches the storage and the queue consumer
+	if len(cfg.MetaAlerts.Notifications) > 0 && !readOnly {
+		var progress watchdog.Checker = checker
+		if cfg.SimulatedClock {
+			// the checker only runs when the simulated clock is moved
+			progress = nil
+		}
+		dog := watchdog.NewWatchdog(store, queueClient, concurrencyClient, progress, time.Duration(cfg.CheckInterval), notifier, cfg.MetaAlerts)
+		dumper.Register("watchdog", func(ctx context.Context) (interface{}, error) {
+			state := map[string]interface{}{"failing": dog.Failing()}
+			if cfg.MetaAlerts.Backend != nil {
+				roundTrip, err := dog.BackendProbe()
+				state["backendRoundTrip"], state["backendError"] = config.Duration(roundTrip), err
+			}
+			return state, nil
+		})
+		go dog.Backend(ctx)
+	}
+
+	// archive services which neither pinged nor changed for a long time
+	if cfg.Archival.StaleAfter > 0 && !readOnly {
+		go archival.NewArchiver(store, concurrencyClient, notifier, cfg.Archival, clk).Backend(ctx)
+	}
+
+	// send test notifications through the canary channels
+	var canaryChecks *canary.Canary
+	if len(cfg.Canary.Channels) > 0 && !readOnly {
+		err = cfg.Canary.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid canary config")
+		}
+		if len(cfg.Canary.Notifications) == 0 {
+			cfg.Canary.Notifications = cfg.MetaAlerts.Notifications
+		}
+		canaryChecks = canary.NewCanary(notifier, cfg.Canary)
+		go canaryChecks.Backend(ctx)
+	}
+
+	// the admin API authenticates its callers through a chain of providers per route group
+	authChains, err := auth.NewChains(cfg.Auth, cfg.Username, cfg.Password)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid auth config")
+	}
+	if cfg.TLS != nil {
+		err = cfg.TLS.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid tls config")
+		}
+	}
+	for _, provider := range cfg.Auth.Providers {
+		if provider.Type == "mtls" && (cfg.TLS == nil || cfg.TLS.ClientCAFile == "") {
+			log.Fatal().Str("provider", provider.Name).Msg("the mtls auth provider needs tls with a client CA")
+		}
+	}
 
 	// setup server for the HTTP API (including admin endpoints and the ping endpoint)
-	srv, err := server.New(ctx, cfg.HTTPListenAddress, cfg.Username, cfg.Password, store, notifier)
+	srv, err := server.New(ctx, cfg.HTTPListenAddress, cfg.TLS, authChains, store, notifier, queueClient, concurrencyClient, emitter, clk, cfg.InhibitRules, cfg.Approvals, cfg.Healthchecks, cfg.Cronitor, cfg.Policies, egressPolicy, canaryChecks, slackApp, shortLinks, pusher, meter, dumper, cfg.ReadOnly)
 	if err != nil {
 		log.Fatal().
 			Err(err).
 			Msg("failed to initialize server")
 	}
+
+	// mirror the service configs and silences of the peer deployment, it works on the raw configs which are
+	// checked like the ones created through the API
+	if cfg.Replication != nil && !readOnly {
+		if cfg.Replication.Peer == "" {
+			log.Fatal().Msg("the replication needs the URL of the peer")
+		}
+		replicator := replication.NewReplicator(*cfg.Replication, storage.Unwrap(store), concurrencyClient, srv.PrepareReplicatedConfig, clk)
+		dumper.Register("replication", func(ctx context.Context) (interface{}, error) {
+			return replicator.State(), nil
+		})
+		log.Info().Str("peer", cfg.Replication.Peer).Msg("start replication")
+		go replicator.Backend(ctx)
+	}
+
+	// answer commands in chat rooms with the same commands as the slack slash command
+	if cfg.ChatOps.Matrix != nil && !readOnly {
+		err = cfg.ChatOps.Matrix.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid chatops config")
+		}
+		go chatops.NewMatrixBot(*cfg.ChatOps.Matrix, cfg.ChatOps.Prefix, srv.ChatCommand, concurrencyClient).Backend(ctx)
+	}
+	if cfg.ChatOps.Discord != nil && !readOnly {
+		err = cfg.ChatOps.Discord.Validate()
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid chatops config")
+		}
+		go chatops.NewDiscordBot(*cfg.ChatOps.Discord, cfg.ChatOps.Prefix, srv.ChatCommand, concurrencyClient).Backend(ctx)
+	}
+	// jobs which emit completion events to kafka don't need to ping as well
+	if cfg.KafkaHeartbeats != nil && !readOnly {
+		err = cfg.KafkaHeartbeats.Validate(cfg.EventStream)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid kafka heartbeats config")
+		}
+		go srv.ConsumeKafkaHeartbeats(ctx, *cfg.KafkaHeartbeats)
+	}
+
 	log.Info().Str("address", cfg.HTTPListenAddress).Msg("start listening for service heatbeats")
 	err = srv.Listen(ctx)
 	if err != nil {
@@ -140,6 +632,67 @@ func main() {
 			Msg("server stopped unexpectedly")
 	}
 
+	// hand over the leadership and the locks before the storage goes away
+	if closer, ok := concurrencyClient.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to resign leadership")
+		}
+	}
+	if closer, ok := store.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to close storage")
+		}
+	}
+	if closer, ok := queueClient.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to close queue")
+		}
+	}
+}
+
+// signalContext returns a context which is canceled on SIGINT and SIGTERM for a graceful shutdown
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Info().Str("signal", sig.String()).Msg("shutting down")
+		cancel()
+	}()
+	return ctx
+}
+
+// checksStoredConfigs reports whether this instance leads the cluster and checks the stored service configs
+func checksStoredConfigs(ctx context.Context, client concurrency.Client) bool {
+	leader, err := client.IsLeader(ctx, "/deadman-switch/check-leader")
+	if err != nil && err != context.DeadlineExceeded {
+		log.Error().Err(err).Msg("failed to check the leadership, skip the check of the stored service configs")
+		return false
+	}
+	if !leader {
+		log.Info().Msg("the leader checks the stored service configs")
+	}
+	return leader
+}
+
+func setupLogging(level, format string) {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse --log-level flag")
+	}
+	zerolog.SetGlobalLevel(lvl)
+
+	switch format {
+	case "json":
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	default:
+		log.Fatal().Str("format", format).Msg("unknown log format")
+	}
 }
 
 func loadConfig() (cfg config.ServerConfig, err error) {
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/trusch/deadman-switch/pkg/client"
+)
+
+// runPing sends a single heartbeat, e.g. from an ExecStartPost hook
+func runPing(args []string) {
+	flags := pflag.NewFlagSet("ping", pflag.ExitOnError)
+	server := flags.String("server", "http://localhost:8080", "deadman-switch server URL")
+	token := flags.String("token", "", "token of the service")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ping [flags] <service-id>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	err := client.New(*server, "", "").Ping(ctx, flags.Arg(0), *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to ping: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/trusch/deadman-switch/pkg/webpush"
+)
+
+// runVAPIDKeys prints a new key pair for the webPush config
+func runVAPIDKeys() {
+	publicKey, privateKey, err := webpush.GenerateKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("webPush:\n  publicKey: %s\n  privateKey: %s\n  subject: mailto:ops@example.com\n", publicKey, privateKey)
+}
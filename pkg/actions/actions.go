@@ -0,0 +1,338 @@
+// Package actions runs the action plans of services, e.g. to fail over to a standby
+// when a service stops sending heartbeats and to switch back once it recovers.
+//
+// The Runner is an events.Emitter and starts the plans from the alarm lifecycle events.
+package actions
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/approvals"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultTimeout        = 30 * time.Second
+	defaultApprovalWindow = 15 * time.Minute
+	approvalPollInterval  = 2 * time.Second
+	queueSize             = 256
+	lockPrefix            = "/deadman-switch/actions"
+)
+
+type Runner struct {
+	store       storage.Storage
+	concurrency concurrency.Client
+	notifier    notifier.Notifier
+	plans       map[string]config.ActionPlanConfig
+	approvals   config.ApprovalsConfig
+	clock       clock.Clock
+	events      chan events.Event
+
+	mutex sync.Mutex
+	// pending are the events of the services which have a worker, in the order they were emitted
+	pending map[string][]events.Event
+}
+
+func NewRunner(ctx context.Context, store storage.Storage, concurrency concurrency.Client, notifier notifier.Notifier, plans []config.ActionPlanConfig, approvals config.ApprovalsConfig, clock clock.Clock) *Runner {
+	r := &Runner{
+		store:       store,
+		concurrency: concurrency,
+		notifier:    notifier,
+		plans:       make(map[string]config.ActionPlanConfig),
+		approvals:   approvals,
+		clock:       clock,
+		events:      make(chan events.Event, queueSize),
+		pending:     make(map[string][]events.Event),
+	}
+	for _, plan := range plans {
+		r.plans[plan.Name] = plan
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Emit implements events.Emitter
+func (r *Runner) Emit(ctx context.Context, event events.Event) {
+	if event.Alarm == nil || (event.Type != events.AlarmCreated && event.Type != events.AlarmResolved) {
+		return
+	}
+	select {
+	case r.events <- event:
+	default:
+		log.Error().Str("event", string(event.Type)).Msg("action queue is full, drop event")
+	}
+}
+
+func (r *Runner) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-r.events:
+			r.dispatch(ctx, event)
+		}
+	}
+}
+
+// dispatch hands the event to the worker of its service. Plans may take a while, so every service gets
+// its own worker, which handles the events of the service in order, e.g. the rollback after the plan.
+func (r *Runner) dispatch(ctx context.Context, event events.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	service := event.Alarm.Service
+	queued, busy := r.pending[service]
+	r.pending[service] = append(queued, event)
+	if !busy {
+		go r.work(ctx, service)
+	}
+}
+
+// work handles the events of a service until there are no more
+func (r *Runner) work(ctx context.Context, service string) {
+	for {
+		r.mutex.Lock()
+		queued := r.pending[service]
+		if len(queued) == 0 {
+			delete(r.pending, service)
+			r.mutex.Unlock()
+			return
+		}
+		event := queued[0]
+		r.pending[service] = queued[1:]
+		r.mutex.Unlock()
+		err := r.handle(ctx, event)
+		if err != nil {
+			log.Error().Err(err).Str("event", string(event.Type)).Str("service", service).Msg("failed to run action plan")
+		}
+	}
+}
+
+func (r *Runner) handle(ctx context.Context, event events.Event) error {
+	// the rollback must not start before the plan of the same alarm is finished, also on other instances
+	lockCtx, unlock := context.WithCancel(ctx)
+	defer unlock()
+	if r.concurrency != nil {
+		err := r.concurrency.Lock(lockCtx, path.Join(lockPrefix, event.Alarm.Service))
+		if err != nil {
+			return err
+		}
+	}
+	switch event.Type {
+	case events.AlarmCreated:
+		return r.alarmCreated(ctx, event)
+	case events.AlarmResolved:
+		return r.alarmResolved(ctx, event)
+	}
+	return nil
+}
+
+func (r *Runner) alarmCreated(ctx context.Context, event events.Event) error {
+	svc, err := r.store.GetServiceConfig(ctx, event.Alarm.Service)
+	if err != nil {
+		return err
+	}
+	if svc.ActionPlan == "" {
+		return nil
+	}
+	plan, ok := r.plans[svc.ActionPlan]
+	if !ok {
+		return fmt.Errorf("unknown action plan %q", svc.ActionPlan)
+	}
+	run := storage.ActionRun{
+		Service:   svc.ID,
+		Plan:      plan.Name,
+		Status:    storage.ActionRunStatusRunning,
+		StartedAt: r.clock.Now().UTC(),
+	}
+	if plan.Destructive {
+		approved, err := r.awaitApproval(ctx, svc, plan, &run)
+		if err != nil || !approved {
+			return err
+		}
+	}
+	err = r.store.SaveActionRun(ctx, run)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("service", svc.ID).Str("plan", plan.Name).Msg("start action plan")
+	steps, succeeded := r.runSteps(ctx, svc.ID, plan.Steps)
+	run.Steps = steps
+	run.Status = storage.ActionRunStatusSucceeded
+	if !succeeded {
+		run.Status = storage.ActionRunStatusFailed
+	}
+	finishedAt := r.clock.Now().UTC()
+	run.FinishedAt = &finishedAt
+	log.Info().Str("service", svc.ID).Str("plan", plan.Name).Str("status", string(run.Status)).Msg("finished action plan")
+	return r.store.SaveActionRun(ctx, run)
+}
+
+func (r *Runner) alarmResolved(ctx context.Context, event events.Event) error {
+	run, err := r.store.GetActionRun(ctx, event.Alarm.Service)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// only roll back what was done for this alarm, and only once
+	if run.Status == storage.ActionRunStatusRolledBack || run.Rollback != nil || len(run.Steps) == 0 ||
+		run.StartedAt.Before(event.Alarm.ActiveSince) {
+		return nil
+	}
+	plan, ok := r.plans[run.Plan]
+	if !ok {
+		return fmt.Errorf("unknown action plan %q", run.Plan)
+	}
+	if len(plan.Rollback) == 0 {
+		return nil
+	}
+	log.Info().Str("service", run.Service).Str("plan", plan.Name).Msg("start rollback of action plan")
+	rollback, succeeded := r.runSteps(ctx, run.Service, plan.Rollback)
+	run.Rollback = rollback
+	if succeeded {
+		run.Status = storage.ActionRunStatusRolledBack
+	} else {
+		run.Status = storage.ActionRunStatusFailed
+	}
+	finishedAt := r.clock.Now().UTC()
+	run.FinishedAt = &finishedAt
+	log.Info().Str("service", run.Service).Str("plan", plan.Name).Str("status", string(run.Status)).Msg("finished rollback of action plan")
+	return r.store.SaveActionRun(ctx, run)
+}
+
+// runSteps runs the steps in order and stops at the first failed one unless it may continue on error.
+// It reports whether all steps which ran succeeded.
+func (r *Runner) runSteps(ctx context.Context, service string, steps []config.ActionStepConfig) ([]storage.ActionResult, bool) {
+	results := []storage.ActionResult{}
+	succeeded := true
+	for _, step := range steps {
+		result := runStep(ctx, r.clock, service, step)
+		results = append(results, result)
+		if result.Success {
+			continue
+		}
+		succeeded = false
+		log.Error().Str("service", service).Str("step", step.Name).Str("error", result.Error).Msg("action failed")
+		if !step.ContinueOnError {
+			break
+		}
+	}
+	return results, succeeded
+}
+
+// awaitApproval sends the approval link and waits until it was clicked, it expired or the alarm was resolved.
+// It reports whether the plan may run and records the outcome in run otherwise.
+func (r *Runner) awaitApproval(ctx context.Context, svc config.ServiceConfig, plan config.ActionPlanConfig, run *storage.ActionRun) (bool, error) {
+	window := time.Duration(plan.ApprovalWindow)
+	if window <= 0 {
+		window = defaultApprovalWindow
+	}
+	now := r.clock.Now().UTC()
+	id, err := ids.Sortable(now)
+	if err != nil {
+		return false, err
+	}
+	approval := storage.Approval{
+		ID:          id,
+		Service:     svc.ID,
+		Plan:        plan.Name,
+		Status:      storage.ApprovalStatusPending,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(window),
+	}
+	err = r.store.SaveApproval(ctx, approval)
+	if err != nil {
+		return false, err
+	}
+	run.Approval = approval.ID
+	run.Status = storage.ActionRunStatusAwaitingApproval
+	err = r.store.SaveActionRun(ctx, *run)
+	if err != nil {
+		return false, err
+	}
+	details := fmt.Sprintf("the destructive plan %s waits for an approval until %s: %s",
+		plan.Name, approval.ExpiresAt.Format(time.RFC3339), approvals.Link(r.approvals, approval))
+	err = r.notifier.SendApprovalRequest(ctx, svc, plan.ApprovalNotifications, details)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to send approval request")
+	}
+	log.Info().Str("service", svc.ID).Str("plan", plan.Name).Str("approval", approval.ID).Msg("wait for approval of action plan")
+
+	// the link may be clicked on any server of a cluster, so watch the stored approval
+	ticker := r.clock.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C():
+		}
+		approval, err = r.store.GetApproval(ctx, approval.ID)
+		if err != nil {
+			return false, err
+		}
+		if approval.Status == storage.ApprovalStatusApproved {
+			log.Info().Str("service", svc.ID).Str("approval", approval.ID).Str("by", approval.ApprovedBy).Msg("action plan approved")
+			run.Status = storage.ActionRunStatusRunning
+			return true, nil
+		}
+		_, err = r.store.GetAlarmActiveSince(ctx, svc.ID)
+		resolved := err == storage.ErrNotFound
+		if err != nil && !resolved {
+			return false, err
+		}
+		expired := r.clock.Now().After(approval.ExpiresAt)
+		if !resolved && !expired {
+			continue
+		}
+		return r.closeApproval(ctx, svc, approval, resolved, run)
+	}
+}
+
+// closeApproval expires or cancels an approval, unless it was approved in the meantime.
+// It holds the lock of the approval, so an approver can't decide at the same time.
+func (r *Runner) closeApproval(ctx context.Context, svc config.ServiceConfig, approval storage.Approval, resolved bool, run *storage.ActionRun) (bool, error) {
+	lockCtx, unlock := context.WithCancel(ctx)
+	defer unlock()
+	if r.concurrency != nil {
+		err := r.concurrency.Lock(lockCtx, approvals.LockKey(approval.ID))
+		if err != nil {
+			return false, err
+		}
+	}
+	approval, err := r.store.GetApproval(ctx, approval.ID)
+	if err != nil {
+		return false, err
+	}
+	if approval.Status == storage.ApprovalStatusApproved {
+		log.Info().Str("service", svc.ID).Str("approval", approval.ID).Str("by", approval.ApprovedBy).Msg("action plan approved")
+		run.Status = storage.ActionRunStatusRunning
+		return true, nil
+	}
+	decidedAt := r.clock.Now().UTC()
+	approval.DecidedAt = &decidedAt
+	approval.Status = storage.ApprovalStatusExpired
+	if resolved {
+		approval.Status = storage.ApprovalStatusCancelled
+	}
+	log.Info().Str("service", svc.ID).Str("approval", approval.ID).Str("status", string(approval.Status)).Msg("action plan not approved")
+	err = r.store.SaveApproval(ctx, approval)
+	if err != nil {
+		return false, err
+	}
+	run.Status = storage.ActionRunStatusNotApproved
+	run.FinishedAt = &decidedAt
+	return false, r.store.SaveActionRun(ctx, *run)
+}
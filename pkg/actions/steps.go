@@ -0,0 +1,174 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// maxOutput limits how much of the output of a step is kept in the run
+const maxOutput = 4096
+
+var httpClient = &http.Client{}
+
+func runStep(ctx context.Context, clock clock.Clock, service string, step config.ActionStepConfig) storage.ActionResult {
+	timeout := time.Duration(step.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := storage.ActionResult{
+		Name:      step.Name,
+		StartedAt: clock.Now().UTC(),
+	}
+	log.Info().Str("service", service).Str("step", step.Name).Str("type", string(step.Type)).Msg("run action")
+	var (
+		output string
+		err    error
+	)
+	switch step.Type {
+	case config.ActionTypeWebhook:
+		output, err = runWebhook(ctx, service, step)
+	case config.ActionTypeExec:
+		output, err = runExec(ctx, service, step)
+	case config.ActionTypeSSH:
+		output, err = runSSH(ctx, service, step)
+	default:
+		err = fmt.Errorf("unknown action type %q", step.Type)
+	}
+	if err == nil && step.Check != nil && step.Check.OutputContains != "" && !strings.Contains(output, step.Check.OutputContains) {
+		err = fmt.Errorf("output doesn't contain %q", step.Check.OutputContains)
+	}
+	if len(output) > maxOutput {
+		output = output[:maxOutput]
+	}
+	result.Output = output
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.FinishedAt = clock.Now().UTC()
+	return result
+}
+
+func runWebhook(ctx context.Context, service string, step config.ActionStepConfig) (string, error) {
+	cfg, err := step.GetWebhookConfig()
+	if err != nil {
+		return "", err
+	}
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, cfg.URL, strings.NewReader(cfg.Body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if cfg.Headers != nil {
+		req.Header = cfg.Headers
+	}
+	req.Header.Set("X-Deadman-Service", service)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	bs, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOutput+1))
+	if err != nil {
+		return "", err
+	}
+	if !expectedStatus(step.Check, resp.StatusCode) {
+		return string(bs), fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return string(bs), nil
+}
+
+func expectedStatus(check *config.ActionCheckConfig, status int) bool {
+	if check == nil || len(check.StatusCodes) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, expected := range check.StatusCodes {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func runExec(ctx context.Context, service string, step config.ActionStepConfig) (string, error) {
+	cfg, err := step.GetExecConfig()
+	if err != nil {
+		return "", err
+	}
+	if len(cfg.Command) == 0 {
+		return "", errors.New("command is missing")
+	}
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = append(append(os.Environ(), cfg.Env...), "DEADMAN_SERVICE="+service)
+	return runCommand(ctx, cmd, step.Check)
+}
+
+func runSSH(ctx context.Context, service string, step config.ActionStepConfig) (string, error) {
+	cfg, err := step.GetSSHConfig()
+	if err != nil {
+		return "", err
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if cfg.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(cfg.Port))
+	}
+	if cfg.IdentityFile != "" {
+		args = append(args, "-i", cfg.IdentityFile)
+	}
+	host := cfg.Host
+	if cfg.User != "" {
+		host = cfg.User + "@" + host
+	}
+	args = append(args, host, cfg.Command)
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Env = append(os.Environ(), "DEADMAN_SERVICE="+service)
+	return runCommand(ctx, cmd, step.Check)
+}
+
+// runCommand runs cmd and checks its exit code, which defaults to 0
+func runCommand(ctx context.Context, cmd *exec.Cmd, check *config.ActionCheckConfig) (string, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return output.String(), ctx.Err()
+	}
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return output.String(), err
+	}
+	expected := 0
+	if check != nil {
+		expected = check.ExitCode
+	}
+	if exitCode != expected {
+		return output.String(), fmt.Errorf("unexpected exit code %d", exitCode)
+	}
+	return output.String(), nil
+}
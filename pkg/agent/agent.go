@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/client"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/discovery"
+)
+
+// dropInName is the file name of the generated drop-ins
+const dropInName = "deadman-switch.conf"
+
+type Config struct {
+	// Server is the base URL of the deadman-switch server
+	Server             string
+	Username, Password string
+	Interval           time.Duration
+	Systemd            config.SystemdDiscoveryConfig
+	// InstallHooks generates ExecStartPost drop-ins, so the units ping after every successful run
+	InstallHooks bool
+	// DropInDir is the systemd unit directory the drop-ins are written to
+	DropInDir string
+	// Binary is the deadman-switch binary called by the drop-ins
+	Binary string
+}
+
+// Agent registers the systemd timers of the local host at a deadman-switch server
+type Agent struct {
+	cfg    Config
+	client *client.Client
+	source *discovery.SystemdSource
+	syncer *discovery.Syncer
+}
+
+func New(cfg Config) (*Agent, error) {
+	if cfg.DropInDir == "" {
+		cfg.DropInDir = "/etc/systemd/system"
+	}
+	if cfg.Binary == "" {
+		binary, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Binary = binary
+	}
+	source, err := discovery.NewSystemdSource(cfg.Systemd)
+	if err != nil {
+		return nil, err
+	}
+	cli := client.New(cfg.Server, cfg.Username, cfg.Password)
+	return &Agent{
+		cfg:    cfg,
+		client: cli,
+		source: source,
+		// there is only one agent per host, so no concurrency client is needed
+		syncer: discovery.NewSyncer(cli, nil, cfg.Interval, source),
+	}, nil
+}
+
+func (a *Agent) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		err := a.Sync(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to sync systemd timers")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync registers all timers at the server and installs the ping hooks
+func (a *Agent) Sync(ctx context.Context) error {
+	err := a.syncer.Sync(ctx, a.source)
+	if err != nil {
+		return err
+	}
+	if !a.cfg.InstallHooks {
+		return nil
+	}
+	timers, err := a.source.Timers(ctx)
+	if err != nil {
+		return err
+	}
+	changed := false
+	installed := make(map[string]bool)
+	for _, timer := range timers {
+		file := filepath.Join(a.cfg.DropInDir, timer.Unit+".d", dropInName)
+		installed[file] = true
+		ok, err := a.writeDropIn(file, timer)
+		if err != nil {
+			return err
+		}
+		changed = changed || ok
+	}
+	if a.cfg.Systemd.Prune {
+		files, err := filepath.Glob(filepath.Join(a.cfg.DropInDir, "*.d", dropInName))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if installed[file] {
+				continue
+			}
+			log.Info().Str("file", file).Msg("remove stale drop-in")
+			err = os.Remove(file)
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+	if changed {
+		out, err := exec.CommandContext(ctx, "systemctl", "daemon-reload").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to reload systemd: %v: %s", err, out)
+		}
+	}
+	return nil
+}
+
+// writeDropIn writes the drop-in for the timer's unit and reports whether it changed
+func (a *Agent) writeDropIn(file string, timer discovery.SystemdTimer) (bool, error) {
+	args := []string{a.cfg.Binary, "ping", "--server", a.cfg.Server}
+	if a.cfg.Systemd.Token != "" {
+		args = append(args, "--token", a.cfg.Systemd.Token)
+	}
+	args = append(args, timer.ServiceID)
+	for idx, arg := range args {
+		args[idx] = quote(arg)
+	}
+	content := []byte(fmt.Sprintf(
+		"# generated by the deadman-switch agent for %s, changes will be overwritten\n[Service]\nExecStartPost=%s\n",
+		timer.Name,
+		strings.Join(args, " "),
+	))
+	existing, err := ioutil.ReadFile(file)
+	if err == nil && bytes.Equal(existing, content) {
+		return false, nil
+	}
+	log.Info().Str("file", file).Str("unit", timer.Unit).Msg("install ping drop-in")
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return false, err
+	}
+	// the drop-in may contain the service token
+	return true, ioutil.WriteFile(file, content, 0600)
+}
+
+// quote quotes a word for a systemd command line
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, `%`, `%%`)
+	s = strings.ReplaceAll(s, `$`, `$$`)
+	return `"` + s + `"`
+}
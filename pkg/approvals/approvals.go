@@ -0,0 +1,63 @@
+// Package approvals signs and verifies the approval links of destructive action plans.
+//
+// A link is only valid with the signature of the shared secret and until it expires,
+// so it can be sent through chat or email without giving out the admin credentials.
+package approvals
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("approval link expired")
+)
+
+// LockKey is the lock which makes deciding an approval atomic, the approver and the expiry hold it
+// while they load, check and save the approval
+func LockKey(id string) string {
+	return "/deadman-switch/approvals/" + id
+}
+
+// Link returns the signed link which approves the approval
+func Link(cfg config.ApprovalsConfig, approval storage.Approval) string {
+	expires := strconv.FormatInt(approval.ExpiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("sig", sign(cfg.Secret, approval.ID, expires))
+	return fmt.Sprintf("%s/approve/%s?%s", strings.TrimSuffix(cfg.URL, "/"), url.PathEscape(approval.ID), query.Encode())
+}
+
+// Verify checks the signature and expiry of the query of an approval link
+func Verify(secret, id string, query url.Values, now time.Time) error {
+	expires := query.Get("expires")
+	expected := sign(secret, id, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("sig"))) {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if now.After(time.Unix(unix, 0)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func sign(secret, id, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,159 @@
+// Package archival archives services which neither pinged nor changed for a long time, so the configs of
+// decommissioned services don't pile up in long-lived deployments. Services with an active alarm are kept,
+// their outage stays open until it is resolved.
+package archival
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const defaultInterval = time.Hour
+
+// Archiver searches for stale services in an interval and archives them
+type Archiver struct {
+	store       storage.Storage
+	concurrency concurrency.Client
+	notifier    notifier.Notifier
+	cfg         config.ArchivalConfig
+	clock       clock.Clock
+}
+
+func NewArchiver(store storage.Storage, concurrency concurrency.Client, notifier notifier.Notifier, cfg config.ArchivalConfig, clock clock.Clock) *Archiver {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	return &Archiver{
+		store:       store,
+		concurrency: concurrency,
+		notifier:    notifier,
+		cfg:         cfg,
+		clock:       clock,
+	}
+}
+
+func (a *Archiver) Backend(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(a.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		a.archiveIfLeader(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Archiver) archiveIfLeader(ctx context.Context) {
+	if a.concurrency != nil {
+		isLeader, err := a.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
+		if err != nil {
+			if err != context.DeadlineExceeded {
+				log.Error().Err(err).Msg("failed to check leadership for the archival")
+			}
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	err := a.ArchiveStale(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to archive stale services")
+	}
+}
+
+// ArchiveStale archives all selected services which are stale
+func (a *Archiver) ArchiveStale(ctx context.Context) error {
+	// collect the services first, the archival deletes them from the storage
+	var stale []config.ServiceConfig
+	configs, errs := a.store.GetServiceConfigs(ctx)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+		case svc, ok := <-configs:
+			if !ok {
+				break loop
+			}
+			if !a.cfg.Services.Matches(svc) {
+				continue
+			}
+			// archiving an alarming service would silently drop its open outage
+			_, err := a.store.GetAlarmActiveSince(ctx, svc.ID)
+			if err == nil {
+				continue
+			}
+			if err != storage.ErrNotFound {
+				log.Error().Str("service", svc.ID).Err(err).Msg("failed to get the alarm state")
+				continue
+			}
+			lastActivity, err := LastActivity(ctx, a.store, svc.ID)
+			if err != nil {
+				log.Error().Str("service", svc.ID).Err(err).Msg("failed to get the last activity")
+				continue
+			}
+			// without any activity on record, e.g. for configs of older versions without history, the age is unknown
+			if lastActivity.IsZero() {
+				continue
+			}
+			if a.clock.Now().Sub(lastActivity) > time.Duration(a.cfg.StaleAfter) {
+				stale = append(stale, svc)
+			}
+		}
+	}
+	for _, svc := range stale {
+		reason := fmt.Sprintf("neither pinged nor changed for %s", time.Duration(a.cfg.StaleAfter))
+		_, err := storage.Archive(ctx, a.store, svc.ID, reason, a.clock.Now())
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to archive stale service")
+			continue
+		}
+		if len(a.cfg.Notifications) > 0 {
+			err = a.notifier.SendArchived(ctx, svc, a.cfg.Notifications, reason)
+			if err != nil {
+				log.Error().Str("service", svc.ID).Err(err).Msg("failed to send archived notifications")
+			}
+		}
+	}
+	return nil
+}
+
+// LastActivity returns the latest of the last heartbeat and the last change of the config of a service
+func LastActivity(ctx context.Context, store storage.Storage, id string) (time.Time, error) {
+	var last time.Time
+	heartbeat, err := store.GetLastHeartbeat(ctx, id)
+	if err != nil && err != storage.ErrNotFound {
+		return last, err
+	}
+	last = latest(last, heartbeat)
+	history, err := store.GetServiceConfigHistory(ctx, id)
+	if err != nil && err != storage.ErrNotFound {
+		return last, err
+	}
+	if version, ok := history.Latest(); ok {
+		last = latest(last, version.CreatedAt)
+	}
+	return last, nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
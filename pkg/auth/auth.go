@@ -0,0 +1,164 @@
+// Package auth authenticates the requests of the admin API. Every route group has a chain of providers,
+// the first provider which authenticates the request lets it through.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// ErrNoCredentials is returned by providers if the request carries no credentials of their kind
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// Name identifies the caller, like a username or the common name of a client certificate
+	Name string `json:"name"`
+	// Provider is the name of the provider which authenticated the caller
+	Provider string `json:"provider"`
+}
+
+// Provider authenticates requests by one kind of credentials
+type Provider interface {
+	// Authenticate returns the identity of the caller, or ErrNoCredentials if the request carries no credentials
+	// of the kind of the provider
+	Authenticate(r *http.Request) (Identity, error)
+	// Challenge is the WWW-Authenticate header asking for the credentials, it may be empty
+	Challenge() string
+}
+
+// Factory creates a provider from its config
+type Factory func(cfg interface{}) (Provider, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = map[string]Factory{
+		"basic": NewBasicProvider,
+		"token": NewTokenProvider,
+		"oidc":  NewOIDCProvider,
+		"mtls":  NewMTLSProvider,
+	}
+)
+
+// RegisterProvider makes a provider type available for the auth config, so embedders can plug in their own.
+// The built-in types can't be replaced.
+func RegisterProvider(providerType string, factory Factory) error {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if _, ok := factories[providerType]; ok {
+		return fmt.Errorf("auth provider type %s is already registered", providerType)
+	}
+	factories[providerType] = factory
+	return nil
+}
+
+func getFactory(providerType string) (Factory, bool) {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	factory, ok := factories[providerType]
+	return factory, ok
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity of an authenticated request
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// WithIdentity returns a context carrying the identity
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+type namedProvider struct {
+	name     string
+	provider Provider
+}
+
+// Chains holds the chain of providers of every route group
+type Chains struct {
+	groups map[string][]namedProvider
+}
+
+// NewChains creates the providers of the config. The username and password are the provider "admin",
+// which is the default chain unless the config sets one.
+func NewChains(cfg config.AuthConfig, username, password string) (*Chains, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	providers := map[string]Provider{
+		config.AuthProviderAdmin: &basicProvider{users: map[string]string{username: password}},
+	}
+	for _, providerCfg := range cfg.Providers {
+		factory, ok := getFactory(providerCfg.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown auth provider type %s", providerCfg.Type)
+		}
+		provider, err := factory(providerCfg.Config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth provider %s: %w", providerCfg.Name, err)
+		}
+		providers[providerCfg.Name] = provider
+	}
+	chains := &Chains{groups: make(map[string][]namedProvider)}
+	routes := map[string][]string{config.AuthRouteDefault: {config.AuthProviderAdmin}}
+	for group, names := range cfg.Routes {
+		routes[group] = names
+	}
+	for group, names := range routes {
+		for _, name := range names {
+			chains.groups[group] = append(chains.groups[group], namedProvider{name: name, provider: providers[name]})
+		}
+	}
+	return chains, nil
+}
+
+// Middleware authenticates the requests by the chain of their route group, the first segment of their path
+func (c *Chains) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain := c.chain(r.URL.Path)
+		var lastErr error
+		for _, p := range chain {
+			identity, err := p.provider.Authenticate(r)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			identity.Provider = p.name
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+			return
+		}
+		if lastErr != nil {
+			log.Warn().Str("path", r.URL.Path).Err(lastErr).Msg("failed to authenticate request")
+		}
+		challenges := make(map[string]bool)
+		for _, p := range chain {
+			if challenge := p.provider.Challenge(); challenge != "" && !challenges[challenge] {
+				challenges[challenge] = true
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func (c *Chains) chain(path string) []namedProvider {
+	group := "/" + strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if chain, ok := c.groups[group]; ok {
+		return chain
+	}
+	return c.groups[config.AuthRouteDefault]
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// BasicConfig configures a provider checking HTTP basic auth
+type BasicConfig struct {
+	// Users maps the usernames onto their passwords
+	Users map[string]string `json:"users"`
+}
+
+type basicProvider struct {
+	users map[string]string
+}
+
+func NewBasicProvider(cfg interface{}) (Provider, error) {
+	var basic BasicConfig
+	err := mapstructure.Decode(cfg, &basic)
+	if err != nil {
+		return nil, err
+	}
+	if len(basic.Users) == 0 {
+		return nil, errors.New("basic auth needs users")
+	}
+	return &basicProvider{users: basic.Users}, nil
+}
+
+func (p *basicProvider) Authenticate(r *http.Request) (Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	want, known := p.users[username]
+	if !known || subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+		return Identity{}, errors.New("wrong username or password")
+	}
+	return Identity{Name: username}, nil
+}
+
+func (p *basicProvider) Challenge() string {
+	return `Basic realm="deadman-switch"`
+}
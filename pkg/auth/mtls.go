@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// MTLSConfig configures a provider accepting the client certificates the TLS handshake verified against the client CA
+type MTLSConfig struct {
+	// Subjects maps the common names or email addresses of the certificates onto the names of the callers.
+	// Without them every verified certificate is accepted with its common name.
+	Subjects map[string]string `json:"subjects"`
+}
+
+type mtlsProvider struct {
+	subjects map[string]string
+}
+
+func NewMTLSProvider(cfg interface{}) (Provider, error) {
+	var mtls MTLSConfig
+	err := mapstructure.Decode(cfg, &mtls)
+	if err != nil {
+		return nil, err
+	}
+	return &mtlsProvider{subjects: mtls.Subjects}, nil
+}
+
+func (p *mtlsProvider) Authenticate(r *http.Request) (Identity, error) {
+	// the server only asks for certificates with a client CA, which verifies them
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if len(p.subjects) == 0 {
+		if cert.Subject.CommonName == "" {
+			return Identity{}, errors.New("the client certificate has no common name")
+		}
+		return Identity{Name: cert.Subject.CommonName}, nil
+	}
+	if name, ok := p.subjects[cert.Subject.CommonName]; ok && cert.Subject.CommonName != "" {
+		return Identity{Name: name}, nil
+	}
+	for _, email := range cert.EmailAddresses {
+		if name, ok := p.subjects[email]; ok {
+			return Identity{Name: name}, nil
+		}
+	}
+	return Identity{}, errors.New("the client certificate of " + cert.Subject.CommonName + " is not mapped onto a caller")
+}
+
+func (p *mtlsProvider) Challenge() string {
+	return ""
+}
@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// jwksRefreshInterval is the time the keys of the issuer are cached
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits the refreshes for tokens signed by unknown keys
+	jwksMinRefreshInterval = time.Minute
+	// clockSkew is tolerated for the expiry and the not-before time of tokens
+	clockSkew = time.Minute
+)
+
+// OIDCConfig configures a provider accepting the ID or access tokens (JWTs) of an OpenID Connect issuer as bearer tokens
+type OIDCConfig struct {
+	// Issuer is the URL of the issuer, its keys are found through <issuer>/.well-known/openid-configuration
+	Issuer string `json:"issuer"`
+	// Audience must be in the aud claim of the tokens, like the client ID
+	Audience string `json:"audience"`
+	// Claim is the claim naming the caller, defaults to sub
+	Claim string `json:"claim"`
+	// Allowed restricts the callers to these values of the claim, without them every caller of the issuer is accepted
+	Allowed []string `json:"allowed"`
+}
+
+type oidcProvider struct {
+	cfg     OIDCConfig
+	allowed map[string]bool
+	cli     *http.Client
+	now     func() time.Time
+
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func NewOIDCProvider(cfg interface{}) (Provider, error) {
+	var oidc OIDCConfig
+	err := mapstructure.Decode(cfg, &oidc)
+	if err != nil {
+		return nil, err
+	}
+	if oidc.Issuer == "" || oidc.Audience == "" {
+		return nil, errors.New("oidc needs the issuer and the audience")
+	}
+	if oidc.Claim == "" {
+		oidc.Claim = "sub"
+	}
+	p := &oidcProvider{
+		cfg: oidc,
+		cli: &http.Client{Timeout: 10 * time.Second},
+		now: time.Now,
+	}
+	if len(oidc.Allowed) > 0 {
+		p.allowed = make(map[string]bool)
+		for _, value := range oidc.Allowed {
+			p.allowed[value] = true
+		}
+	}
+	return p, nil
+}
+
+func (p *oidcProvider) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := bearerToken(r)
+	// other bearer tokens are left to the other providers
+	if !ok || strings.Count(token, ".") != 2 {
+		return Identity{}, ErrNoCredentials
+	}
+	claims, err := p.verify(r.Context(), token)
+	if err != nil {
+		return Identity{}, err
+	}
+	name, _ := claims[p.cfg.Claim].(string)
+	if name == "" {
+		return Identity{}, fmt.Errorf("the token has no %s claim", p.cfg.Claim)
+	}
+	if p.allowed != nil && !p.allowed[name] {
+		return Identity{}, fmt.Errorf("%s is not allowed", name)
+	}
+	return Identity{Name: name}, nil
+}
+
+func (p *oidcProvider) Challenge() string {
+	return `Bearer realm="deadman-switch"`
+}
+
+// verify checks the signature, the issuer, the audience and the validity period of the token and returns its claims
+func (p *oidcProvider) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+	err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return nil, fmt.Errorf("the token is issued by %s", iss)
+	}
+	if !hasAudience(claims["aud"], p.cfg.Audience) {
+		return nil, errors.New("the token is meant for another audience")
+	}
+	now := p.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("the token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("the token is not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, target)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm %s", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			err := rsa.VerifyPKCS1v15(key, hash, digest, signature)
+			if err != nil {
+				return errors.New("invalid token signature")
+			}
+			return nil
+		case "PS":
+			err := rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			if err != nil {
+				return errors.New("invalid token signature")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if !ecdsa.Verify(key, digest, r, s) {
+				return errors.New("invalid token signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("token algorithm %s doesn't match the key", alg)
+}
+
+// key returns the key of the issuer with the ID. The keys are fetched again after an hour,
+// or after a minute if the token is signed by an unknown key, like after a key rotation.
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key, ok := p.keys[kid]
+	age := p.now().Sub(p.fetchedAt)
+	if ok && age < jwksRefreshInterval {
+		return key, nil
+	}
+	if p.keys == nil || age >= jwksMinRefreshInterval {
+		keys, err := p.fetchKeys(ctx)
+		if err != nil && ok {
+			// the cached key stays in use while the issuer is unreachable, the next attempt is in a minute
+			p.fetchedAt = p.now().Add(jwksMinRefreshInterval - jwksRefreshInterval)
+			return key, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the keys of the issuer: %w", err)
+		}
+		p.keys, p.fetchedAt = keys, p.now()
+		key, ok = p.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("the token is signed by the unknown key %q", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *oidcProvider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("the issuer has no jwks_uri")
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = p.getJSON(ctx, discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// keys of unsupported types can't sign the tokens we accept anyway
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		bs, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(bs), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
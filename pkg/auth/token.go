@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// TokenConfig configures a provider checking static bearer tokens, like the tokens of CI pipelines
+type TokenConfig struct {
+	Tokens []NamedToken `json:"tokens"`
+}
+
+// NamedToken is a token and the name of the caller it identifies
+type NamedToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+type tokenProvider struct {
+	tokens []NamedToken
+}
+
+func NewTokenProvider(cfg interface{}) (Provider, error) {
+	var token TokenConfig
+	err := mapstructure.Decode(cfg, &token)
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Tokens) == 0 {
+		return nil, errors.New("token auth needs tokens")
+	}
+	for _, t := range token.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, errors.New("every token needs a name and the token")
+		}
+	}
+	return &tokenProvider{tokens: token.Tokens}, nil
+}
+
+func (p *tokenProvider) Authenticate(r *http.Request) (Identity, error) {
+	given, ok := bearerToken(r)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	// all tokens are compared, so the time doesn't tell which one matched
+	name := ""
+	for _, t := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(t.Token)) == 1 {
+			name = t.Name
+		}
+	}
+	if name == "" {
+		return Identity{}, errors.New("unknown token")
+	}
+	return Identity{Name: name}, nil
+}
+
+func (p *tokenProvider) Challenge() string {
+	return `Bearer realm="deadman-switch"`
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
@@ -0,0 +1,222 @@
+// Package aws calls AWS APIs without the SDK. It signs requests with Signature Version 4 and finds the
+// credentials like the SDK does: static keys, the environment, a web identity token like on EKS, the
+// container credentials of ECS and the role of an EC2 instance.
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshBefore renews temporary credentials before they expire
+	refreshBefore = 5 * time.Minute
+	imdsURL       = "http://169.254.169.254"
+	ecsURL        = "http://169.254.170.2"
+)
+
+// ErrNoCredentials is returned if no source has credentials
+var ErrNoCredentials = errors.New("no aws credentials found in the config, the environment, a web identity token, the ecs container or the ec2 instance")
+
+// Credentials sign the requests, temporary ones have a session token and expire
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+func (c Credentials) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.Add(refreshBefore).After(c.Expires)
+}
+
+// Provider finds the credentials of the environment and caches them until they expire
+type Provider struct {
+	cli *http.Client
+
+	mutex  sync.Mutex
+	cached Credentials
+}
+
+// NewProvider creates a provider, the client is used to fetch temporary credentials
+func NewProvider(cli *http.Client) *Provider {
+	return &Provider{cli: cli}
+}
+
+// Credentials returns the cached credentials or looks for new ones
+func (p *Provider) Credentials(ctx context.Context, region string) (Credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	if p.cached.AccessKeyID != "" && !p.cached.expired(now) {
+		return p.cached, nil
+	}
+	creds, err := p.find(ctx, region)
+	if err != nil {
+		return Credentials{}, err
+	}
+	p.cached = creds
+	return creds, nil
+}
+
+func (p *Provider) find(ctx context.Context, region string) (Credentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if file, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); file != "" && role != "" {
+		return p.webIdentity(ctx, region, file, role)
+	}
+	if relative, full := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); relative != "" || full != "" {
+		endpoint := full
+		if relative != "" {
+			endpoint = ecsURL + relative
+		}
+		return p.container(ctx, endpoint)
+	}
+	if os.Getenv("AWS_EC2_METADATA_DISABLED") != "true" {
+		creds, err := p.instance(ctx)
+		if err == nil {
+			return creds, nil
+		}
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+// webIdentity exchanges the token, e.g. the one of the service account of an EKS pod, for role credentials
+func (p *Provider) webIdentity(ctx context.Context, region, file, role string) (Credentials, error) {
+	token, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Credentials{}, err
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = "deadman-switch"
+	}
+	endpoint := "https://sts.amazonaws.com/"
+	if region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := p.do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to assume the role %s: %w", role, err)
+	}
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	err = xml.Unmarshal(body, &resp)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("malformed sts response: %w", err)
+	}
+	c := resp.Credentials
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+// container fetches the credentials of the task role of an ECS container
+func (p *Provider) container(ctx context.Context, endpoint string) (Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return p.roleCredentials(req)
+}
+
+// instance fetches the credentials of the role of the EC2 instance through IMDSv2
+func (p *Provider) instance(ctx context.Context) (Credentials, error) {
+	// the metadata service answers quickly or not at all, e.g. outside of EC2
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsURL+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := p.do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	base := imdsURL + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, base, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	role, err := p.do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, base+strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]), nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	return p.roleCredentials(req)
+}
+
+// roleCredentials reads the JSON credentials the ECS and EC2 metadata services answer
+func (p *Provider) roleCredentials(req *http.Request) (Credentials, error) {
+	body, err := p.do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("malformed credentials: %w", err)
+	}
+	if resp.AccessKeyID == "" {
+		return Credentials{}, errors.New("the metadata service returned no credentials")
+	}
+	return Credentials{AccessKeyID: resp.AccessKeyID, SecretAccessKey: resp.SecretAccessKey, SessionToken: resp.Token, Expires: resp.Expiration}, nil
+}
+
+func (p *Provider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
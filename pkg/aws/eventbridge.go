@@ -0,0 +1,92 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is put on an EventBridge bus, Detail is a JSON object
+type Event struct {
+	EventBusName string    `json:"EventBusName,omitempty"`
+	Source       string    `json:"Source"`
+	DetailType   string    `json:"DetailType"`
+	Detail       string    `json:"Detail"`
+	Time         time.Time `json:"-"`
+}
+
+// EventBridgeEndpoint returns the endpoint of EventBridge in the region
+func EventBridgeEndpoint(region string) string {
+	return "https://events." + region + ".amazonaws.com"
+}
+
+// PutEvents puts the events on their buses and fails if EventBridge rejected any of them
+func PutEvents(ctx context.Context, cli *http.Client, endpoint, region string, creds Credentials, events []Event) error {
+	type entry struct {
+		Event
+		Time int64 `json:"Time,omitempty"`
+	}
+	entries := make([]entry, len(events))
+	for i, event := range events {
+		entries[i] = entry{Event: event}
+		if !event.Time.IsZero() {
+			entries[i].Time = event.Time.Unix()
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"Entries": entries})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	Sign(req, body, creds, region, "events", time.Now())
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Type != "" {
+			return fmt.Errorf("eventbridge answered %d: %s: %s", resp.StatusCode, apiErr.Type, apiErr.Message)
+		}
+		return fmt.Errorf("eventbridge answered %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var result struct {
+		FailedEntryCount int `json:"FailedEntryCount"`
+		Entries          []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"Entries"`
+	}
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return fmt.Errorf("malformed eventbridge response: %w", err)
+	}
+	if result.FailedEntryCount > 0 {
+		for _, e := range result.Entries {
+			if e.ErrorCode != "" {
+				return fmt.Errorf("eventbridge rejected %d of %d events: %s: %s", result.FailedEntryCount, len(events), e.ErrorCode, e.ErrorMessage)
+			}
+		}
+		return fmt.Errorf("eventbridge rejected %d of %d events", result.FailedEntryCount, len(events))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSign signs requests of the Signature Version 4 test suite of AWS
+func TestSign(t *testing.T) {
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for name, test := range map[string]struct {
+		method        string
+		contentType   string
+		body          string
+		sessionToken  string
+		signedHeaders string
+		signature     string
+	}{
+		"get-vanilla":  {method: http.MethodGet, signedHeaders: "host;x-amz-date", signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		"post-vanilla": {method: http.MethodPost, signedHeaders: "host;x-amz-date", signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		"post-x-www-form-urlencoded": {
+			method:        http.MethodPost,
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		"post-sts-header-before": {
+			method:        http.MethodPost,
+			sessionToken:  "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA==",
+			signedHeaders: "host;x-amz-date;x-amz-security-token",
+			signature:     "85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead",
+		},
+	} {
+		req, err := http.NewRequest(test.method, "https://example.amazonaws.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		creds := creds
+		creds.SessionToken = test.sessionToken
+		Sign(req, []byte(test.body), creds, "us-east-1", "service", now)
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" + test.signedHeaders + ", Signature=" + test.signature
+		if got := req.Header.Get("Authorization"); got != expected {
+			t.Errorf("%s: want\n%s\ngot\n%s", name, expected, got)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: want the date 20150830T123600Z, got %s", name, got)
+		}
+	}
+}
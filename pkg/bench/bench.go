@@ -0,0 +1,346 @@
+// Package bench generates load against a server to validate its capacity. It provisions synthetic services,
+// pings them at a fixed rate and measures the latency of the API and how late the checker alarms.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/client"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// Config describes a benchmark run
+type Config struct {
+	Server, Username, Password string
+	Services                   int
+	// PingRate is the number of pings per second, spread evenly over the services
+	PingRate float64
+	Duration time.Duration
+	// Concurrency is the number of requests in flight at most
+	Concurrency int
+	Prefix      string
+	// Timeout is the timeout of the pinged services, without it the timeout is three times the time between
+	// the pings of a service, but at least a minute
+	Timeout time.Duration
+	// Expiring services are pinged once and then left alone, their alerts measure the checker lag
+	Expiring        int
+	ExpiringTimeout time.Duration
+	// WebhookListen is the address the alerts are received on, WebhookURL the URL the server reaches it with
+	WebhookListen string
+	WebhookURL    string
+	// LagWait is the time to wait for the alerts of the expiring services after their deadlines
+	LagWait time.Duration
+	// Cleanup deletes the services at the end
+	Cleanup bool
+}
+
+// ParseRate parses a rate like 500/s, 100/m or 500 (per second) into a rate per second
+func ParseRate(rate string) (float64, error) {
+	count, unit := rate, "s"
+	if i := strings.Index(rate, "/"); i >= 0 {
+		count, unit = rate[:i], rate[i+1:]
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	switch unit {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	}
+	return 0, fmt.Errorf("invalid rate %q, the unit must be s, m or h", rate)
+}
+
+// Report is the result of a benchmark run
+type Report struct {
+	Services     int           `json:"services"`
+	Timeout      time.Duration `json:"timeout"`
+	Provisioning Latencies     `json:"provisioning"`
+	Pings        Latencies     `json:"pings"`
+	// PingRate is the achieved rate of pings per second, it is lower than the configured one if the server can't keep up
+	PingRate float64 `json:"pingRate"`
+	// CheckerLag is the time from the deadline of an expiring service until its alert arrived
+	CheckerLag    Latencies `json:"checkerLag"`
+	MissingAlerts int       `json:"missingAlerts"`
+	// UnexpectedAlerts are alerts of services which were pinged in time
+	UnexpectedAlerts int `json:"unexpectedAlerts"`
+}
+
+// Latencies summarizes the durations of a kind of request
+type Latencies struct {
+	Count  int           `json:"count"`
+	Errors int           `json:"errors"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+}
+
+type bench struct {
+	cfg      Config
+	cli      *client.Client
+	pinged   []string
+	expiring []string
+
+	provisioning recorder
+	pings        recorder
+	lag          recorder
+
+	mutex      sync.Mutex
+	deadlines  map[string]time.Time
+	alerted    map[string]bool
+	unexpected int
+}
+
+// Run provisions the services, pings them for the duration of the run and waits for the alerts of the expiring services
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if cfg.Services <= 0 || cfg.PingRate <= 0 {
+		return Report{}, errors.New("the number of services and the ping rate must be positive")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	period := time.Duration(float64(cfg.Services) / cfg.PingRate * float64(time.Second))
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 3 * period
+		if cfg.Timeout < time.Minute {
+			cfg.Timeout = time.Minute
+		}
+	}
+	if cfg.Timeout <= period {
+		return Report{}, fmt.Errorf("every service is pinged every %s, which is longer than the timeout of %s", period.Round(time.Millisecond), cfg.Timeout)
+	}
+	b := &bench{
+		cfg: cfg,
+		cli: client.NewWithHTTPClient(cfg.Server, cfg.Username, cfg.Password, &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        cfg.Concurrency,
+				MaxIdleConnsPerHost: cfg.Concurrency,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}),
+		deadlines: make(map[string]time.Time),
+		alerted:   make(map[string]bool),
+	}
+	for i := 0; i < cfg.Services; i++ {
+		b.pinged = append(b.pinged, fmt.Sprintf("%s%d", cfg.Prefix, i))
+	}
+	for i := 0; i < cfg.Expiring; i++ {
+		b.expiring = append(b.expiring, fmt.Sprintf("%sexpiring-%d", cfg.Prefix, i))
+	}
+
+	if cfg.WebhookURL != "" {
+		listener, err := net.Listen("tcp", cfg.WebhookListen)
+		if err != nil {
+			return Report{}, err
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(b.handleAlert)}
+		go srv.Serve(listener)
+		defer srv.Close()
+	} else if cfg.Expiring > 0 {
+		return Report{}, errors.New("measuring the checker lag needs the webhook URL")
+	}
+	if cfg.Cleanup {
+		defer b.cleanup()
+	}
+
+	log.Info().Int("services", cfg.Services+cfg.Expiring).Msg("provisioning services")
+	b.provision(ctx)
+	log.Info().Float64("rate", cfg.PingRate).Dur("timeout", cfg.Timeout).Msg("pinging services")
+	start := time.Now()
+	b.ping(ctx)
+	elapsed := time.Since(start)
+
+	report := Report{
+		Services:     cfg.Services,
+		Timeout:      cfg.Timeout,
+		Provisioning: b.provisioning.summarize(),
+		Pings:        b.pings.summarize(),
+		PingRate:     float64(b.pings.count()) / elapsed.Seconds(),
+		CheckerLag:   b.lag.summarize(),
+	}
+	b.mutex.Lock()
+	report.MissingAlerts = cfg.Expiring - len(b.alerted)
+	report.UnexpectedAlerts = b.unexpected
+	b.mutex.Unlock()
+	return report, ctx.Err()
+}
+
+// provision creates the services and pings every one once, the expiring services start to run into their timeout
+func (b *bench) provision(ctx context.Context) {
+	b.parallel(ctx, append(append([]string{}, b.pinged...), b.expiring...), func(ctx context.Context, id string) {
+		svc := config.ServiceConfig{
+			ID:      id,
+			Timeout: config.Duration(b.cfg.Timeout),
+			Labels:  map[string]string{"bench": "true"},
+		}
+		if b.cfg.WebhookURL != "" {
+			svc.AlertNotifications = []config.NotificationConfig{{
+				Type: config.NotificationTypeWebhook,
+				Config: map[string]interface{}{
+					"url":    strings.TrimSuffix(b.cfg.WebhookURL, "/") + "/" + id,
+					"method": http.MethodPost,
+				},
+			}}
+		}
+		expiring := strings.HasPrefix(id, b.cfg.Prefix+"expiring-")
+		if expiring {
+			svc.Timeout = config.Duration(b.cfg.ExpiringTimeout)
+		}
+		start := time.Now()
+		err := b.cli.SaveServiceConfig(ctx, svc)
+		b.provisioning.record(time.Since(start), err)
+		if err != nil {
+			log.Debug().Str("service", id).Err(err).Msg("failed to create service")
+			return
+		}
+		err = b.cli.Ping(ctx, id, "")
+		if err != nil {
+			log.Debug().Str("service", id).Err(err).Msg("failed to ping new service")
+			return
+		}
+		if expiring {
+			b.mutex.Lock()
+			b.deadlines[id] = time.Now().Add(b.cfg.ExpiringTimeout)
+			b.mutex.Unlock()
+		}
+	})
+}
+
+// ping pings the services round robin at the configured rate. After the duration it keeps pinging while
+// alerts of expiring services are outstanding, so the pinged services don't expire while waiting.
+func (b *bench) ping(ctx context.Context) {
+	jobs := make(chan string, b.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < b.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				_ = b.pingOnce(ctx, id)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	interval := time.Duration(float64(time.Second) / b.cfg.PingRate)
+	start := time.Now()
+	for i := 0; ; i++ {
+		next := start.Add(time.Duration(i) * interval)
+		if b.done(next.Sub(start)) {
+			return
+		}
+		if wait := time.Until(next); wait > time.Millisecond {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case jobs <- b.pinged[i%len(b.pinged)]:
+		}
+	}
+}
+
+func (b *bench) pingOnce(ctx context.Context, id string) error {
+	start := time.Now()
+	err := b.cli.Ping(ctx, id, "")
+	if ctx.Err() == nil {
+		b.pings.record(time.Since(start), err)
+	}
+	if err != nil {
+		log.Debug().Str("service", id).Err(err).Msg("failed to ping")
+	}
+	return err
+}
+
+// done reports whether the run is over, after its duration and once the alerts arrived or the wait for them is over
+func (b *bench) done(elapsed time.Duration) bool {
+	if elapsed < b.cfg.Duration {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for id, deadline := range b.deadlines {
+		if !b.alerted[id] && time.Now().Before(deadline.Add(b.cfg.LagWait)) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleAlert receives the alert webhooks of the services at /<service-id>
+func (b *bench) handleAlert(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	w.WriteHeader(http.StatusOK)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	deadline, expiring := b.deadlines[id]
+	if !expiring {
+		b.unexpected++
+		log.Warn().Str("service", id).Msg("unexpected alert")
+		return
+	}
+	if b.alerted[id] {
+		return
+	}
+	b.alerted[id] = true
+	b.lag.record(now.Sub(deadline), nil)
+}
+
+// cleanup deletes the services, even if the run was canceled
+func (b *bench) cleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	log.Info().Msg("deleting services")
+	b.parallel(ctx, append(append([]string{}, b.pinged...), b.expiring...), func(ctx context.Context, id string) {
+		err := b.cli.DeleteServiceConfig(ctx, id)
+		if err != nil && err != storage.ErrNotFound {
+			log.Warn().Str("service", id).Err(err).Msg("failed to delete service")
+		}
+	})
+}
+
+func (b *bench) parallel(ctx context.Context, ids []string, fn func(ctx context.Context, id string)) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < b.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				fn(ctx, id)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+	for _, id := range ids {
+		select {
+		case <-ctx.Done():
+			return
+		case jobs <- id:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recorder collects the durations of a kind of request
+type recorder struct {
+	mutex     sync.Mutex
+	durations []time.Duration
+	errors    int
+}
+
+func (r *recorder) record(d time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.errors++
+		return
+	}
+	r.durations = append(r.durations, d)
+}
+
+func (r *recorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.durations) + r.errors
+}
+
+func (r *recorder) summarize() Latencies {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	l := Latencies{Count: len(r.durations) + r.errors, Errors: r.errors}
+	if len(r.durations) == 0 {
+		return l
+	}
+	sorted := append([]time.Duration{}, r.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	l.P50, l.P90, l.P99, l.Max = percentile(0.5), percentile(0.9), percentile(0.99), sorted[len(sorted)-1]
+	return l
+}
+
+// Print writes the report in a human readable form
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "services:      %d (timeout %s)\n", r.Services, r.Timeout)
+	printLatencies(w, "provisioning:", r.Provisioning)
+	printLatencies(w, "pings:", r.Pings)
+	fmt.Fprintf(w, "ping rate:     %.1f/s\n", r.PingRate)
+	if r.CheckerLag.Count > 0 || r.MissingAlerts > 0 {
+		printLatencies(w, "checker lag:", r.CheckerLag)
+		fmt.Fprintf(w, "missing:       %d alerts\n", r.MissingAlerts)
+	}
+	fmt.Fprintf(w, "unexpected:    %d alerts\n", r.UnexpectedAlerts)
+}
+
+func printLatencies(w io.Writer, name string, l Latencies) {
+	fmt.Fprintf(w, "%-14s %d (%d errors), p50 %s, p90 %s, p99 %s, max %s\n", name, l.Count, l.Errors,
+		l.P50.Round(time.Microsecond), l.P90.Round(time.Microsecond), l.P99.Round(time.Microsecond), l.Max.Round(time.Microsecond))
+}
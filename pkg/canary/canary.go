@@ -0,0 +1,247 @@
+// Package canary sends test notifications through notification channels in an interval and verifies
+// that they arrive. A channel which breaks, e.g. because its token expired, is reported through the
+// meta notifications before a real incident needs it.
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+const (
+	defaultInterval = time.Hour
+	defaultTimeout  = 5 * time.Minute
+	// slackPollInterval is the interval in which a slack channel is read while a test message is pending
+	slackPollInterval = 10 * time.Second
+)
+
+// ChannelState is the result of the last check of a canary channel
+type ChannelState struct {
+	Name        string    `json:"name"`
+	OK          bool      `json:"ok"`
+	LastCheck   time.Time `json:"lastCheck,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	// Failures is the number of failed checks since the last success
+	Failures int `json:"failures"`
+
+	// nonce of the pending test notification, arrived is closed when it is echoed
+	nonce   string
+	arrived chan struct{}
+}
+
+// Canary checks the configured channels
+type Canary struct {
+	notifier notifier.Notifier
+	cfg      config.CanaryConfig
+	mutex    sync.Mutex
+	states   map[string]*ChannelState
+}
+
+func NewCanary(notifier notifier.Notifier, cfg config.CanaryConfig) *Canary {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(defaultTimeout)
+	}
+	states := make(map[string]*ChannelState)
+	for _, channel := range cfg.Channels {
+		states[channel.Name] = &ChannelState{Name: channel.Name}
+	}
+	return &Canary{
+		notifier: notifier,
+		cfg:      cfg,
+		states:   states,
+	}
+}
+
+// Backend checks all channels right after the start and then in the interval until the context is done
+func (c *Canary) Backend(ctx context.Context) {
+	// give the server a moment to start listening for the echoes
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		var wg sync.WaitGroup
+		for _, channel := range c.cfg.Channels {
+			wg.Add(1)
+			go func(channel config.CanaryChannelConfig) {
+				defer wg.Done()
+				c.report(ctx, channel.Name, c.check(ctx, channel), time.Now())
+			}(channel)
+		}
+		wg.Wait()
+		timer.Reset(time.Duration(c.cfg.Interval))
+	}
+}
+
+// States returns the results of the last checks ordered by name
+func (c *Canary) States() []ChannelState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	states := make([]ChannelState, 0, len(c.states))
+	for _, state := range c.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Echo marks the pending test notification of a channel as arrived, it returns false if the nonce isn't pending
+func (c *Canary) Echo(name, nonce string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.states[name]
+	if !ok || state.nonce == "" || state.nonce != nonce {
+		return false
+	}
+	close(state.arrived)
+	state.nonce = ""
+	return true
+}
+
+// check sends a test notification through the channel and waits until it arrived
+func (c *Canary) check(ctx context.Context, channel config.CanaryChannelConfig) error {
+	nonce, err := ids.Random(16)
+	if err != nil {
+		return err
+	}
+	arrived := c.expect(channel.Name, nonce)
+	defer c.expect(channel.Name, "")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.cfg.Timeout))
+	defer cancel()
+	sentAt := time.Now()
+	err = c.notifier.SendCanary(ctx, channel.Notification, channel.Name, nonce)
+	if err != nil {
+		return fmt.Errorf("failed to send the test notification: %w", err)
+	}
+	if channel.Verify.Type == config.CanaryVerifySlack {
+		return c.verifySlack(ctx, channel, nonce, sentAt)
+	}
+	select {
+	case <-arrived:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("the test notification wasn't echoed within %s", time.Duration(c.cfg.Timeout))
+	}
+}
+
+// expect sets the pending nonce of a channel, an empty nonce clears it
+func (c *Canary) expect(name, nonce string) chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state := c.states[name]
+	state.nonce = nonce
+	state.arrived = make(chan struct{})
+	return state.arrived
+}
+
+// verifySlack reads the slack channel until a message contains the nonce
+func (c *Canary) verifySlack(ctx context.Context, channel config.CanaryChannelConfig, nonce string, sentAt time.Time) error {
+	token := channel.Verify.Token
+	if token == "" {
+		cfg, err := channel.Notification.GetSlackConfig()
+		if err != nil {
+			return errors.New("the slack verification needs a token")
+		}
+		token = cfg.Token
+	}
+	api := slack.New(token)
+	// slack timestamps are unix seconds, a second earlier covers clock skew
+	oldest := fmt.Sprintf("%d", sentAt.Unix()-1)
+	ticker := time.NewTicker(slackPollInterval)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		history, err := api.GetConversationHistoryContext(ctx, &slack.GetConversationHistoryParameters{
+			ChannelID: channel.Verify.Channel,
+			Oldest:    oldest,
+			Limit:     100,
+		})
+		if err == nil {
+			for _, msg := range history.Messages {
+				if containsNonce(msg, nonce) {
+					return nil
+				}
+			}
+		} else {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("the slack channel can't be read: %w", lastErr)
+			}
+			return fmt.Errorf("the test message didn't show up in the slack channel within %s", time.Duration(c.cfg.Timeout))
+		case <-ticker.C:
+		}
+	}
+}
+
+func containsNonce(msg slack.Message, nonce string) bool {
+	if strings.Contains(msg.Text, nonce) {
+		return true
+	}
+	for _, attachment := range msg.Attachments {
+		if strings.Contains(attachment.Text, nonce) {
+			return true
+		}
+		for _, field := range attachment.Fields {
+			if strings.Contains(field.Value, nonce) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// report records the result of a check and sends a meta alert when a channel breaks and a recovery when it works again
+func (c *Canary) report(ctx context.Context, name string, err error, now time.Time) {
+	c.mutex.Lock()
+	state := c.states[name]
+	failing := state.Failures > 0
+	state.LastCheck = now
+	state.OK = err == nil
+	if err != nil {
+		state.Error = err.Error()
+		state.Failures++
+	} else {
+		state.Error = ""
+		state.Failures = 0
+		state.LastSuccess = now
+	}
+	c.mutex.Unlock()
+
+	problem := "canary/" + name
+	if err != nil {
+		log.Error().Str("channel", name).Err(err).Msg("canary check failed")
+		if failing {
+			return
+		}
+		_ = c.notifier.SendMetaNotifications(ctx, c.cfg.Notifications, problem, false, fmt.Sprintf("the notification channel %s is broken: %v", name, err))
+		return
+	}
+	log.Debug().Str("channel", name).Msg("canary check succeeded")
+	if !failing {
+		return
+	}
+	log.Info().Str("channel", name).Msg("canary channel works again")
+	_ = c.notifier.SendMetaNotifications(ctx, c.cfg.Notifications, problem, true, fmt.Sprintf("the notification channel %s works again", name))
+}
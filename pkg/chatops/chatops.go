@@ -0,0 +1,105 @@
+// Package chatops connects the deadman switch to chat rooms, so the on-call engineer can query the status of
+// services and acknowledge or silence alarms without leaving the chat.
+//
+// The bots only transport the messages: a message starting with the command prefix is passed to a Handler,
+// which runs the same commands as the Slack slash command, and the answer is posted back into the room.
+package chatops
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+)
+
+const (
+	DefaultPrefix = "!deadman"
+	// minBackoff and maxBackoff limit the time between reconnects of a bot
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Answer is the answer to a command. Public answers announce a change to the room, the others answer a question.
+type Answer struct {
+	Text   string
+	Public bool
+}
+
+// Handler runs a command of a user. Users who aren't operators may only run read-only commands.
+type Handler func(ctx context.Context, user string, args []string, operator bool) Answer
+
+// command returns the arguments of a message which starts with the prefix
+func command(prefix, message string) ([]string, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || fields[0] != prefix {
+		return nil, false
+	}
+	return fields[1:], true
+}
+
+// isOperator reports whether the ID of a user is listed as an operator. Names are not matched, users can
+// change them or pick the name of someone else.
+func isOperator(operators []string, userID string) bool {
+	for _, operator := range operators {
+		if userID != "" && operator == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// answers reports whether this instance answers commands. Every instance of a cluster listens,
+// but only the leader answers, so a command is answered once.
+func answers(ctx context.Context, client concurrency.Client) bool {
+	if client == nil {
+		return true
+	}
+	isLeader, err := client.IsLeader(ctx, "/deadman-switch/check-leader")
+	if err != nil {
+		if err != context.DeadlineExceeded {
+			log.Error().Err(err).Msg("failed to check leadership for chatops")
+		}
+		return false
+	}
+	return isLeader
+}
+
+// reconnect runs connect until the context is done and waits with an exponential backoff between the attempts
+func reconnect(ctx context.Context, name string, connect func(ctx context.Context) error) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error().Err(err).Str("bot", name).Dur("backoff", backoff).Msg("chatops bot disconnected")
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// truncate cuts a message to the maximum length of a chat message
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	cut := max - len("…")
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
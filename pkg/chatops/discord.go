@@ -0,0 +1,238 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	discordAPI     = "https://discord.com/api/v10"
+	discordGateway = "wss://gateway.discord.gg/?v=10&encoding=json"
+	// discordIntents are the guild messages and their content
+	discordIntents = 1<<9 | 1<<15
+	// maxDiscordMessageSize is the length limit of a Discord message
+	maxDiscordMessageSize = 2000
+)
+
+// gateway opcodes, see https://discord.com/developers/docs/topics/opcodes-and-status-codes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+)
+
+// DiscordBot answers commands in a Discord channel. It receives the messages through the gateway and answers through the REST API.
+type DiscordBot struct {
+	cfg         config.DiscordBotConfig
+	prefix      string
+	handler     Handler
+	concurrency concurrency.Client
+	cli         *http.Client
+	gateway     string
+	api         string
+}
+
+func NewDiscordBot(cfg config.DiscordBotConfig, prefix string, handler Handler, concurrency concurrency.Client) *DiscordBot {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &DiscordBot{
+		cfg:         cfg,
+		prefix:      prefix,
+		handler:     handler,
+		concurrency: concurrency,
+		cli: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		gateway: discordGateway,
+		api:     discordAPI,
+	}
+}
+
+// Backend connects to the gateway and answers commands until the context is done
+func (b *DiscordBot) Backend(ctx context.Context) {
+	reconnect(ctx, "discord", b.run)
+}
+
+type gatewayPayload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+type discordMessage struct {
+	ChannelID string `json:"channel_id"`
+	Content   string `json:"content"`
+	Author    struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Bot      bool   `json:"bot"`
+	} `json:"author"`
+}
+
+func (b *DiscordBot) run(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, b.gateway, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var hello gatewayPayload
+	err = conn.ReadJSON(&hello)
+	if err != nil {
+		return err
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if hello.Op != opHello || json.Unmarshal(hello.Data, &helloData) != nil || helloData.HeartbeatInterval <= 0 {
+		return fmt.Errorf("unexpected first gateway message with op %d", hello.Op)
+	}
+	// the connection is written by the heartbeats and the identify, reads happen in this goroutine only
+	var writeMutex sync.Mutex
+	write := func(op int, data interface{}) error {
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return conn.WriteJSON(gatewayPayload{Op: op, Data: bs})
+	}
+	err = write(opIdentify, map[string]interface{}{
+		"token":   b.cfg.Token,
+		"intents": discordIntents,
+		"properties": map[string]string{
+			"os":      runtime.GOOS,
+			"browser": "deadman-switch",
+			"device":  "deadman-switch",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var sequenceMutex sync.Mutex
+	var sequence *int64
+	go func() {
+		ticker := time.NewTicker(time.Duration(helloData.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			sequenceMutex.Lock()
+			last := sequence
+			sequenceMutex.Unlock()
+			if err := write(opHeartbeat, last); err != nil {
+				log.Error().Err(err).Msg("failed to send discord heartbeat")
+				cancel()
+				return
+			}
+		}
+	}()
+
+	for {
+		var payload gatewayPayload
+		err = conn.ReadJSON(&payload)
+		if err != nil {
+			return err
+		}
+		if payload.Sequence != nil {
+			sequenceMutex.Lock()
+			sequence = payload.Sequence
+			sequenceMutex.Unlock()
+		}
+		switch payload.Op {
+		case opHeartbeat:
+			sequenceMutex.Lock()
+			last := sequence
+			sequenceMutex.Unlock()
+			err = write(opHeartbeat, last)
+			if err != nil {
+				return err
+			}
+		case opReconnect:
+			return fmt.Errorf("the gateway asked to reconnect")
+		case opInvalidSession:
+			return fmt.Errorf("the gateway invalidated the session")
+		case opDispatch:
+			switch payload.Type {
+			case "READY":
+				log.Info().Str("channel", b.cfg.ChannelID).Msg("discord bot connected")
+			case "MESSAGE_CREATE":
+				var msg discordMessage
+				err = json.Unmarshal(payload.Data, &msg)
+				if err != nil {
+					log.Error().Err(err).Msg("failed to decode discord message")
+					continue
+				}
+				go b.handle(ctx, msg)
+			}
+		}
+	}
+}
+
+func (b *DiscordBot) handle(ctx context.Context, msg discordMessage) {
+	if msg.ChannelID != b.cfg.ChannelID || msg.Author.Bot {
+		return
+	}
+	args, ok := command(b.prefix, msg.Content)
+	if !ok || !answers(ctx, b.concurrency) {
+		return
+	}
+	operator := isOperator(b.cfg.Operators, msg.Author.ID)
+	answer := b.handler(ctx, msg.Author.Username, args, operator)
+	err := b.send(ctx, answer.Text)
+	if err != nil {
+		log.Error().Err(err).Str("channel", b.cfg.ChannelID).Msg("failed to answer in the discord channel")
+	}
+}
+
+func (b *DiscordBot) send(ctx context.Context, text string) error {
+	bs, err := json.Marshal(map[string]interface{}{
+		"content": truncate(text, maxDiscordMessageSize),
+		// answers quote user input, so they must not ping anyone
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.api+"/channels/"+b.cfg.ChannelID+"/messages", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+b.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("discord answered %d", resp.StatusCode)
+	}
+	return nil
+}
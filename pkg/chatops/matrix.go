@@ -0,0 +1,188 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	// matrixSyncTimeout is the time the homeserver holds a sync request open without new events
+	matrixSyncTimeout = 30 * time.Second
+	// maxMatrixMessageSize keeps answers well below the event size limit of 64KiB
+	maxMatrixMessageSize = 16 << 10
+)
+
+// MatrixBot answers commands in a Matrix room through the client-server API
+type MatrixBot struct {
+	cfg         config.MatrixBotConfig
+	prefix      string
+	handler     Handler
+	concurrency concurrency.Client
+	cli         *http.Client
+	userID      string
+	roomID      string
+	txn         int64
+}
+
+func NewMatrixBot(cfg config.MatrixBotConfig, prefix string, handler Handler, concurrency concurrency.Client) *MatrixBot {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &MatrixBot{
+		cfg:         cfg,
+		prefix:      prefix,
+		handler:     handler,
+		concurrency: concurrency,
+		cli: &http.Client{
+			Timeout: matrixSyncTimeout + 10*time.Second,
+		},
+		txn: time.Now().UnixNano(),
+	}
+}
+
+// Backend joins the room and answers commands until the context is done
+func (b *MatrixBot) Backend(ctx context.Context) {
+	reconnect(ctx, "matrix", b.run)
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+func (b *MatrixBot) run(ctx context.Context) error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	err := b.call(ctx, http.MethodGet, "/account/whoami", nil, &whoami)
+	if err != nil {
+		return err
+	}
+	b.userID = whoami.UserID
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	err = b.call(ctx, http.MethodPost, "/join/"+url.PathEscape(b.cfg.Room), struct{}{}, &joined)
+	if err != nil {
+		return fmt.Errorf("failed to join %s: %w", b.cfg.Room, err)
+	}
+	b.roomID = joined.RoomID
+	log.Info().Str("user", b.userID).Str("room", b.roomID).Msg("matrix bot joined the room")
+
+	filter, _ := json.Marshal(map[string]interface{}{
+		"room": map[string]interface{}{
+			"rooms":    []string{b.roomID},
+			"timeline": map[string]interface{}{"limit": 50, "types": []string{"m.room.message"}},
+		},
+		"presence":     map[string]interface{}{"types": []string{}},
+		"account_data": map[string]interface{}{"types": []string{}},
+	})
+	// the first sync only fetches the position, commands sent while the bot was away are not answered
+	since := ""
+	for {
+		query := url.Values{}
+		query.Set("filter", string(filter))
+		if since != "" {
+			query.Set("since", since)
+			query.Set("timeout", strconv.Itoa(int(matrixSyncTimeout/time.Millisecond)))
+		}
+		var sync matrixSync
+		err = b.call(ctx, http.MethodGet, "/sync?"+query.Encode(), nil, &sync)
+		if err != nil {
+			return err
+		}
+		if since != "" {
+			for _, event := range sync.Rooms.Join[b.roomID].Timeline.Events {
+				b.handle(ctx, event)
+			}
+		}
+		since = sync.NextBatch
+	}
+}
+
+func (b *MatrixBot) handle(ctx context.Context, event matrixEvent) {
+	if event.Type != "m.room.message" || event.Sender == b.userID || event.Content.MsgType != "m.text" {
+		return
+	}
+	args, ok := command(b.prefix, event.Content.Body)
+	if !ok || !answers(ctx, b.concurrency) {
+		return
+	}
+	answer := b.handler(ctx, event.Sender, args, isOperator(b.cfg.Operators, event.Sender))
+	err := b.send(ctx, answer.Text)
+	if err != nil {
+		log.Error().Err(err).Str("room", b.roomID).Msg("failed to answer in the matrix room")
+	}
+}
+
+// send posts a notice, the message type of bots which other bots don't react to
+func (b *MatrixBot) send(ctx context.Context, text string) error {
+	b.txn++
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%d", url.PathEscape(b.roomID), b.txn)
+	return b.call(ctx, http.MethodPut, path, map[string]string{
+		"msgtype": "m.notice",
+		"body":    truncate(text, maxMatrixMessageSize),
+	}, nil)
+}
+
+func (b *MatrixBot) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bs)
+	}
+	endpoint := strings.TrimSuffix(b.cfg.Homeserver, "/") + "/_matrix/client/v3" + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.cfg.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var matrixErr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&matrixErr)
+		return fmt.Errorf("matrix answered %d: %s %s", resp.StatusCode, matrixErr.ErrCode, matrixErr.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -7,18 +7,47 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
 	"github.com/trusch/deadman-switch/pkg/concurrency"
 	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/hooks"
 	"github.com/trusch/deadman-switch/pkg/notifier"
 	"github.com/trusch/deadman-switch/pkg/storage"
 )
 
 type Checker struct {
-	store       storage.Storage
-	concurrency concurrency.Client
-	notifier    notifier.Notifier
-	interval    time.Duration
-	cli         *http.Client
+	store        storage.Storage
+	concurrency  concurrency.Client
+	notifier     notifier.Notifier
+	interval     time.Duration
+	inhibitRules []config.InhibitRule
+	quorums      []config.QuorumConfig
+	events       events.Emitter
+	clock        clock.Clock
+	cli          *http.Client
+
+	mutex sync.Mutex
+	// lastCycle is the wall clock time the last check cycle completed
+	lastCycle time.Time
+	// lastCheck is the wall clock time this instance checked the deadlines as the leader, lastCheckDuration
+	// the time the check took
+	lastCheck         time.Time
+	lastCheckDuration time.Duration
+	lastError         string
+	lastErrorAt       time.Time
+}
+
+// State is the progress of the checker of this instance
+type State struct {
+	Interval config.Duration `json:"interval"`
+	// LastCycle is the last cycle which completed without an error, LastCheck the last one which checked
+	// the deadlines because this instance was the leader
+	LastCycle         *time.Time      `json:"lastCycle,omitempty"`
+	LastCheck         *time.Time      `json:"lastCheck,omitempty"`
+	LastCheckDuration config.Duration `json:"lastCheckDuration"`
+	LastError         string          `json:"lastError,omitempty"`
+	LastErrorAt       *time.Time      `json:"lastErrorAt,omitempty"`
 }
 
 func NewChecker(
@@ -26,8 +55,22 @@ func NewChecker(
 	concurrency concurrency.Client,
 	notifier notifier.Notifier,
 	interval time.Duration,
+	inhibitRules []config.InhibitRule,
+	quorums []config.QuorumConfig,
+	events events.Emitter,
+	clock clock.Clock,
 ) *Checker {
-	return &Checker{store, concurrency, notifier, interval, &http.Client{Timeout: 5 * time.Second}}
+	return &Checker{
+		store:        store,
+		concurrency:  concurrency,
+		notifier:     notifier,
+		interval:     interval,
+		inhibitRules: inhibitRules,
+		quorums:      quorums,
+		events:       events,
+		clock:        clock,
+		cli:          &http.Client{Timeout: 5 * time.Second},
+	}
 }
 
 func (c *Checker) Backend(ctx context.Context) error {
@@ -36,17 +79,24 @@ func (c *Checker) Backend(ctx context.Context) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		ticker := time.NewTicker(c.interval)
+		ticker := c.clock.NewTicker(c.interval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
+			case <-ticker.C():
 				err := c.checkDeadlinesIfLeader(ctx)
 				if err != nil {
 					log.Error().Err(err).Msg("error while checking deadlines")
+					c.mutex.Lock()
+					c.lastError, c.lastErrorAt = err.Error(), time.Now()
+					c.mutex.Unlock()
+					continue
 				}
+				c.mutex.Lock()
+				c.lastCycle = time.Now()
+				c.mutex.Unlock()
 			}
 		}
 	}()
@@ -55,6 +105,30 @@ func (c *Checker) Backend(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// LastCycle returns the wall clock time the last check cycle completed without an error, zero before the first one
+func (c *Checker) LastCycle() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastCycle
+}
+
+// State returns the progress of the checker
+func (c *Checker) State() State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state := State{Interval: config.Duration(c.interval), LastCheckDuration: config.Duration(c.lastCheckDuration), LastError: c.lastError}
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	state.LastCycle = optional(c.lastCycle)
+	state.LastCheck = optional(c.lastCheck)
+	state.LastErrorAt = optional(c.lastErrorAt)
+	return state
+}
+
 func (c *Checker) checkDeadlinesIfLeader(ctx context.Context) error {
 	if c.concurrency != nil {
 		isLeader, err := c.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
@@ -67,13 +141,31 @@ func (c *Checker) checkDeadlinesIfLeader(ctx context.Context) error {
 		if !isLeader {
 			return nil
 		}
-		return c.checkDeadlines(ctx)
 	}
-	return c.checkDeadlines(ctx)
+	start := time.Now()
+	err := c.checkDeadlines(ctx)
+	if err == nil {
+		c.mutex.Lock()
+		c.lastCheck = time.Now()
+		c.lastCheckDuration = c.lastCheck.Sub(start)
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// LastCheckDuration returns the time the last check of the deadlines by this instance took
+func (c *Checker) LastCheckDuration() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastCheckDuration
 }
 
 func (c *Checker) checkDeadlines(ctx context.Context) error {
+	// first find all overdue services, so we know which alarms are firing before sending anything
+	var all, overdue []config.ServiceConfig
+	silences := c.loadSilences(ctx)
 	configs, errorChannel := c.store.GetServiceConfigs(ctx)
+loop:
 	for {
 		select {
 		case <-ctx.Done():
@@ -84,40 +176,139 @@ func (c *Checker) checkDeadlines(ctx context.Context) error {
 			}
 		case svc, ok := <-configs:
 			if !ok {
-				return nil
+				break loop
 			}
-			err := c.checkDeadlineOfService(ctx, svc)
+			all = append(all, svc)
+			isOverdue, err := c.checkDeadlineOfService(ctx, svc)
 			if err != nil {
 				log.Error().Str("service", svc.ID).Err(err).Msg("failed to check deadline")
 			}
+			if isOverdue {
+				overdue = append(overdue, svc)
+				continue
+			}
+			err = c.checkEarlyWarning(ctx, svc)
+			if err != nil {
+				log.Error().Str("service", svc.ID).Err(err).Msg("failed to check early warning")
+			}
+			err = c.checkCountdown(ctx, svc, silences)
+			if err != nil {
+				log.Error().Str("service", svc.ID).Err(err).Msg("failed to check countdown")
+			}
 		}
 	}
+	overdue = append(overdue, c.checkQuorums(ctx, all)...)
+
+	for _, svc := range overdue {
+		err := c.alert(ctx, svc, overdue, silences)
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to send alerts")
+			continue
+		}
+		// a one-shot service alarms once, failed alerts are retried in the next cycle
+		if svc.OneShot != nil {
+			c.archiveOneShot(ctx, svc, "missed its deadline")
+		}
+	}
+	return nil
 }
 
-func (c *Checker) checkDeadlineOfService(ctx context.Context, svc config.ServiceConfig) error {
+// alert sends the alerts of an overdue service, unless they are inhibited, silenced, outside the active hours
+// or the alarm is acknowledged
+func (c *Checker) alert(ctx context.Context, svc config.ServiceConfig, overdue []config.ServiceConfig, silences []storage.Silence) error {
+	if source, ok := c.inhibitedBy(svc, overdue); ok {
+		log.Info().Str("service", svc.ID).Str("inhibited-by", source).Msg("alerts are inhibited")
+		return nil
+	}
+	if silence, ok := silencedBy(svc, silences, c.clock.Now()); ok {
+		log.Info().Str("service", svc.ID).Str("silence", silence).Msg("alerts are silenced")
+		return nil
+	}
+	if svc.ActiveHours != nil && !svc.ActiveHours.Contains(c.clock.Now()) {
+		log.Info().Str("service", svc.ID).Msg("alerts wait for the active hours")
+		return nil
+	}
+	if ack, err := c.store.GetAlarmAcknowledgement(ctx, svc.ID); err == nil {
+		log.Info().Str("service", svc.ID).Str("acknowledged-by", ack.By).Msg("alarm is acknowledged")
+		return nil
+	}
+	return c.notifier.SendAlerts(ctx, svc)
+}
+
+// checkDeadlineOfService reports whether the service is overdue and marks its alarm as active
+func (c *Checker) checkDeadlineOfService(ctx context.Context, svc config.ServiceConfig) (bool, error) {
+	if svc.OneShot != nil {
+		return c.checkOneShot(ctx, svc)
+	}
 	t, err := c.store.GetLastHeartbeat(ctx, svc.ID)
 	if err != nil {
 		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get last heartbeat")
 	}
-	timeSinceLastHeartbeat := time.Since(t)
-	if timeSinceLastHeartbeat > time.Duration(svc.Timeout) {
+	now := c.clock.Now()
+	overdue := now.After(svc.Deadline(t))
+	overdue, hookErr := hooks.Alarm(ctx, svc, now, t, overdue)
+	if hookErr != nil {
+		log.Error().Str("service", svc.ID).Err(hookErr).Msg("failed to run alarm hook")
+	}
+	if overdue {
 		log.Info().Str("service", svc.ID).Msg("service is overdue")
-		_, err := c.store.GetAlarmActiveSince(ctx, svc.ID)
-		if err == storage.ErrNotFound {
-			err = c.store.SetAlarmActiveSince(ctx, svc.ID, time.Now())
-			if err != nil {
-				log.Error().Str("service", svc.ID).Err(err).Msg("failed to set alarm active state")
-			}
+		details := "no heartbeat received"
+		if !t.IsZero() {
+			details = "last heartbeat at " + t.UTC().Format(time.RFC3339)
 		}
-		err = c.notifier.SendAlerts(ctx, svc)
-		if err != nil {
-			return err
+		if hookErr != nil {
+			details = "the alarm hook failed: " + hookErr.Error()
 		}
+		c.activateAlarm(ctx, svc, now, details)
+		return true, nil
+	}
+	log.Info().
+		Str("service", svc.ID).
+		Time("last_heartbeat", t).
+		Msg("service is considered alive")
+	return false, nil
+}
+
+// activateAlarm marks the alarm of the service as active, unless it already is, and records why in its event log
+func (c *Checker) activateAlarm(ctx context.Context, svc config.ServiceConfig, now time.Time, details string) {
+	_, err := c.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err != storage.ErrNotFound {
+		return
+	}
+	err = c.store.SetAlarmActiveSince(ctx, svc.ID, now)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to set alarm active state")
+		return
+	}
+	err = c.store.AppendServiceEvent(ctx, storage.ServiceEvent{
+		Time:    now,
+		Service: svc.ID,
+		Type:    storage.ServiceEventHeartbeatMissed,
+		Details: details,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to record service event")
+	}
+	event, err := events.NewAlarmEvent(events.AlarmCreated, now, events.Alarm{
+		Service:     svc.ID,
+		Labels:      svc.Labels,
+		ActiveSince: now,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
 	} else {
-		log.Info().
-			Str("service", svc.ID).
-			Time("last_heartbeat", time.Now().Add(-timeSinceLastHeartbeat)).
-			Msg("service is considered alive")
+		c.events.Emit(ctx, event)
 	}
-	return nil
+}
+
+// inhibitedBy returns the ID of an alarming service which suppresses the alerts of svc
+func (c *Checker) inhibitedBy(svc config.ServiceConfig, alarming []config.ServiceConfig) (string, bool) {
+	for _, rule := range c.inhibitRules {
+		for _, source := range alarming {
+			if rule.Inhibits(source, svc) {
+				return source.ID, true
+			}
+		}
+	}
+	return "", false
 }
@@ -0,0 +1,51 @@
+package checker
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// checkCountdown warns once per lead time when the deadline of a service which isn't overdue yet comes close
+func (c *Checker) checkCountdown(ctx context.Context, svc config.ServiceConfig, silences []storage.Silence) error {
+	if svc.Countdown == nil || svc.OneShot != nil {
+		return nil
+	}
+	last, err := c.store.GetLastHeartbeat(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	now := c.clock.Now()
+	deadline := svc.Deadline(last)
+	remaining := deadline.Sub(now)
+	lead, ok := svc.Countdown.Due(remaining)
+	if !ok {
+		return nil
+	}
+	// a heartbeat moves the deadline, so the warnings of the previous deadline don't count
+	sent, err := c.store.GetCountdown(ctx, svc.ID)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	if err == nil && sent.Deadline.Equal(deadline) && sent.LeadTime <= lead {
+		return nil
+	}
+	if silence, ok := silencedBy(svc, silences, now); ok {
+		log.Info().Str("service", svc.ID).Str("silence", silence).Msg("countdown is silenced")
+	} else {
+		err = c.notifier.SendCountdown(ctx, svc, remaining)
+		if err != nil {
+			return err
+		}
+	}
+	return c.store.SaveCountdown(ctx, storage.Countdown{
+		Service:  svc.ID,
+		Deadline: deadline,
+		LeadTime: lead,
+	})
+}
@@ -0,0 +1,66 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// checkEarlyWarning sends the early warning of a service which isn't overdue yet but misses too many heartbeats
+func (c *Checker) checkEarlyWarning(ctx context.Context, svc config.ServiceConfig) error {
+	if svc.EarlyWarning == nil || svc.EarlyWarning.Interval <= 0 {
+		return nil
+	}
+	warning := svc.EarlyWarning.WithDefaults()
+	history, err := c.store.GetHeartbeatHistory(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	missed, ok := missedHeartbeats(history, warning, c.clock.Now())
+	if !ok {
+		return nil
+	}
+	_, err = c.store.GetEarlyWarningActiveSince(ctx, svc.ID)
+	warned := err == nil
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	switch {
+	case missed >= warning.Threshold && !warned:
+		log.Info().Str("service", svc.ID).Int("missed", missed).Int("window", warning.Window).Msg("service is missing heartbeats")
+		err = c.store.SetEarlyWarningActiveSince(ctx, svc.ID, c.clock.Now())
+		if err != nil {
+			return err
+		}
+		return c.notifier.SendEarlyWarning(ctx, svc, fmt.Sprintf("%d of the last %d expected heartbeats are missing", missed, warning.Window))
+	case missed < warning.Threshold && warned:
+		return c.store.ClearEarlyWarning(ctx, svc.ID)
+	}
+	return nil
+}
+
+// missedHeartbeats returns how many of the last Window expected heartbeats are missing.
+// It reports false as long as the history doesn't cover the whole window.
+func missedHeartbeats(history storage.HeartbeatHistory, warning config.EarlyWarningConfig, now time.Time) (int, bool) {
+	start := now.Add(-warning.Retention())
+	if history.Since.After(start) {
+		return 0, false
+	}
+	received := 0
+	for _, t := range history.Heartbeats {
+		if t.After(start) {
+			received++
+		}
+	}
+	if received >= warning.Window {
+		return 0, true
+	}
+	return warning.Window - received, true
+}
@@ -0,0 +1,39 @@
+package checker
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// checkOneShot reports whether a one-shot service missed its deadline and marks its alarm as active.
+// A service which got its heartbeat is archived right away.
+func (c *Checker) checkOneShot(ctx context.Context, svc config.ServiceConfig) (bool, error) {
+	t, err := c.store.GetLastHeartbeat(ctx, svc.ID)
+	if err != nil && err != storage.ErrNotFound {
+		return false, err
+	}
+	now := c.clock.Now()
+	if err == nil && t.After(svc.OneShot.CreatedAt) {
+		log.Info().Str("service", svc.ID).Time("heartbeat", t).Msg("one-shot service completed")
+		c.archiveOneShot(ctx, svc, "completed")
+		return false, nil
+	}
+	if now.Before(svc.OneShot.Deadline) {
+		log.Info().Str("service", svc.ID).Time("deadline", svc.OneShot.Deadline).Msg("one-shot service is waiting for its heartbeat")
+		return false, nil
+	}
+	log.Info().Str("service", svc.ID).Msg("one-shot service missed its deadline")
+	c.activateAlarm(ctx, svc, now, "missed the deadline at "+svc.OneShot.Deadline.UTC().Format(time.RFC3339))
+	return true, nil
+}
+
+func (c *Checker) archiveOneShot(ctx context.Context, svc config.ServiceConfig, reason string) {
+	_, err := storage.Archive(ctx, c.store, svc.ID, reason, c.clock.Now())
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to archive one-shot service")
+	}
+}
@@ -0,0 +1,93 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// checkQuorums activates or resolves the alarms of the quorums.
+// It returns the services of the quorums which aren't reached, so their alerts are sent with the overdue services.
+func (c *Checker) checkQuorums(ctx context.Context, services []config.ServiceConfig) []config.ServiceConfig {
+	if len(c.quorums) == 0 {
+		return nil
+	}
+	heartbeats, err := c.store.GetLastHeartbeats(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get last heartbeats for the quorums")
+		return nil
+	}
+	now := c.clock.Now()
+	var failed []config.ServiceConfig
+	for _, quorum := range c.quorums {
+		svc := quorum.Service()
+		alive, total := aliveServices(quorum, services, heartbeats, now)
+		if alive < quorum.Min {
+			log.Info().Str("quorum", quorum.ID).Int("alive", alive).Int("services", total).Int("min", quorum.Min).Msg("quorum is not reached")
+			c.activateAlarm(ctx, svc, now, fmt.Sprintf("%d of %d services alive, %d needed", alive, total, quorum.Min))
+			failed = append(failed, svc)
+			continue
+		}
+		err = c.resolveAlarm(ctx, svc, now)
+		if err != nil {
+			log.Error().Str("quorum", quorum.ID).Err(err).Msg("failed to resolve quorum alarm")
+		}
+	}
+	return failed
+}
+
+// aliveServices counts the selected services and the ones of them which pinged within the window of the quorum
+func aliveServices(quorum config.QuorumConfig, services []config.ServiceConfig, heartbeats map[string]time.Time, now time.Time) (alive, total int) {
+	for _, svc := range services {
+		if svc.ID == quorum.ID || !quorum.Services.Matches(svc) {
+			continue
+		}
+		total++
+		if t, ok := heartbeats[svc.ID]; ok && now.Sub(t) <= time.Duration(quorum.Window) {
+			alive++
+		}
+	}
+	return alive, total
+}
+
+// resolveAlarm clears an active alarm and sends the recovery notifications,
+// like a heartbeat does for a regular service
+func (c *Checker) resolveAlarm(ctx context.Context, svc config.ServiceConfig, now time.Time) error {
+	activeSince, err := c.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = c.store.ClearAlarm(ctx, svc.ID)
+	if err != nil {
+		return err
+	}
+	err = c.store.ClearAlarmAcknowledgement(ctx, svc.ID)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to clear alarm acknowledgement")
+	}
+	resolvedAt := now.UTC()
+	event, err := events.NewAlarmEvent(events.AlarmResolved, resolvedAt, events.Alarm{
+		Service:     svc.ID,
+		Labels:      svc.Labels,
+		ActiveSince: activeSince,
+		ResolvedAt:  &resolvedAt,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+	} else {
+		c.events.Emit(ctx, event)
+	}
+	lastMessage, err := c.store.GetLastMessageSendTimestamp(ctx, svc.ID)
+	if err == storage.ErrNotFound || (err == nil && lastMessage.Before(activeSince)) {
+		return nil
+	}
+	return c.notifier.SendRecoveryNotifications(ctx, svc)
+}
@@ -0,0 +1,47 @@
+package checker
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// silenceRetention is the time ended silences are kept for reference before they are pruned
+const silenceRetention = 24 * time.Hour
+
+// loadSilences returns the silences which are active now and prunes the ones which ended long ago
+func (c *Checker) loadSilences(ctx context.Context) []storage.Silence {
+	silences, err := c.store.GetSilences(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load silences")
+		return nil
+	}
+	now := c.clock.Now()
+	var active []storage.Silence
+	for _, silence := range silences {
+		if silence.Ended(now) && now.Sub(silence.EndsAt) > silenceRetention {
+			err = c.store.DeleteSilence(ctx, silence.ID)
+			if err != nil && err != storage.ErrNotFound {
+				log.Error().Str("silence", silence.ID).Err(err).Msg("failed to prune silence")
+			}
+			continue
+		}
+		if silence.Active(now) {
+			active = append(active, silence)
+		}
+	}
+	return active
+}
+
+// silencedBy returns the ID of an active silence which suppresses the alerts of svc
+func silencedBy(svc config.ServiceConfig, silences []storage.Silence, now time.Time) (string, bool) {
+	for _, silence := range silences {
+		if silence.Silences(svc, now) {
+			return silence.ID, true
+		}
+	}
+	return "", false
+}
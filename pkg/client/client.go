@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// Client talks to the HTTP API of a deadman-switch server
+type Client struct {
+	baseURL            string
+	username, password string
+	cli                *http.Client
+}
+
+// New creates a client, username and password are only needed for the admin endpoints
+func New(baseURL, username, password string) *Client {
+	return NewWithHTTPClient(baseURL, username, password, &http.Client{
+		Timeout: 10 * time.Second,
+	})
+}
+
+// NewWithHTTPClient creates a client which sends its requests through cli
+func NewWithHTTPClient(baseURL, username, password string, cli *http.Client) *Client {
+	return &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+		cli:      cli,
+	}
+}
+
+// Ping sends a heartbeat for the service
+func (c *Client) Ping(ctx context.Context, id, token string) error {
+	u := c.baseURL + "/ping/" + id
+	if token != "" {
+		u += "?token=" + url.QueryEscape(token)
+	}
+	resp, err := c.do(ctx, http.MethodGet, u, nil, false)
+	if err != nil {
+		return err
+	}
+	// the connection is only reused once the response is read
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// ListServiceConfigs returns all service configs matching the pattern.
+// With raw set the per-prefix defaults of the server are not applied.
+func (c *Client) ListServiceConfigs(ctx context.Context, match string, raw bool) ([]config.ServiceConfig, error) {
+	query := url.Values{}
+	if match != "" {
+		query.Set("match", match)
+	}
+	if raw {
+		query.Set("raw", "true")
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.baseURL+"/config?"+query.Encode(), nil, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var configs []config.ServiceConfig
+	err = json.NewDecoder(resp.Body).Decode(&configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// GetServiceConfig returns the raw config of a single service or storage.ErrNotFound
+func (c *Client) GetServiceConfig(ctx context.Context, id string) (config.ServiceConfig, error) {
+	configs, err := c.ListServiceConfigs(ctx, id, true)
+	if err != nil {
+		return config.ServiceConfig{}, err
+	}
+	for _, svc := range configs {
+		if svc.ID == id {
+			return svc, nil
+		}
+	}
+	return config.ServiceConfig{}, storage.ErrNotFound
+}
+
+// GetServiceConfigs streams all raw service configs like storage.Storage does.
+// The server sends them as NDJSON, so the configs are passed on while they are read.
+func (c *Client) GetServiceConfigs(ctx context.Context) (chan config.ServiceConfig, chan error) {
+	configChannel := make(chan config.ServiceConfig, 32)
+	errorChannel := make(chan error, 1)
+	go func() {
+		defer close(configChannel)
+		defer close(errorChannel)
+		resp, err := c.do(ctx, http.MethodGet, c.baseURL+"/config?raw=true&format=ndjson", nil, true)
+		if err != nil {
+			errorChannel <- err
+			return
+		}
+		defer resp.Body.Close()
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			var svc config.ServiceConfig
+			err = decoder.Decode(&svc)
+			if err != nil {
+				errorChannel <- err
+				return
+			}
+			select {
+			case <-ctx.Done():
+				errorChannel <- ctx.Err()
+				return
+			case configChannel <- svc:
+			}
+		}
+	}()
+	return configChannel, errorChannel
+}
+
+// SaveServiceConfig creates or updates a service
+func (c *Client) SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error {
+	bs, err := json.Marshal(svc)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.baseURL+"/config", bs, true)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteServiceConfig deletes a service, it returns storage.ErrNotFound for unknown services
+func (c *Client) DeleteServiceConfig(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.baseURL+"/config/"+id, nil, true)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body []byte, admin bool) (*http.Response, error) {
+	r, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if admin {
+		r.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.cli.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, storage.ErrNotFound
+		}
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
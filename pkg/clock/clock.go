@@ -0,0 +1,126 @@
+// Package clock abstracts the time source, so the checker, the notifier and the server
+// can run on a simulated clock in integration tests and simulations.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the clock users need
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Since returns the time elapsed since t on the given clock
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Simulated is a clock which only moves when it is told to.
+// Like time.Ticker, its tickers drop ticks when the receiver falls behind.
+type Simulated struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers map[*simulatedTicker]struct{}
+}
+
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{
+		now:     start,
+		tickers: make(map[*simulatedTicker]struct{}),
+	}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.now
+}
+
+// Advance moves the clock forward by d and fires all due tickers
+func (s *Simulated) Advance(d time.Duration) {
+	s.Set(s.Now().Add(d))
+}
+
+// Set moves the clock to t and fires all due tickers. The clock never moves backwards.
+func (s *Simulated) Set(t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t.Before(s.now) {
+		return
+	}
+	s.now = t
+	for ticker := range s.tickers {
+		if ticker.next.After(t) {
+			continue
+		}
+		select {
+		case ticker.c <- t:
+		default:
+		}
+		for !ticker.next.After(t) {
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+func (s *Simulated) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ticker := &simulatedTicker{
+		clock:  s,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   s.now.Add(d),
+	}
+	s.tickers[ticker] = struct{}{}
+	return ticker
+}
+
+type simulatedTicker struct {
+	clock  *Simulated
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *simulatedTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *simulatedTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	delete(t.clock.tickers, t)
+}
@@ -5,4 +5,17 @@ import "context"
 type Client interface {
 	IsLeader(ctx context.Context, id string) (bool, error)
 	Lock(ctx context.Context, key string) error
+	// Status reports the leadership of this node and the health of its connection to the cluster
+	Status() Status
+}
+
+// Status of a concurrency client
+type Status struct {
+	// Leader is the result of the last leader check
+	Leader bool `json:"leader"`
+	// Healthy is false while the node can't take part in elections and locks
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	// Renewals counts the sessions which had to be recreated after they were lost
+	Renewals int `json:"renewals"`
 }
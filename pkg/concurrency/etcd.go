@@ -2,58 +2,148 @@ package concurrency
 
 import (
 	"context"
+	"math"
+	"sync"
 	"time"
 
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+
 	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/clientv3/concurrency"
 )
 
-func NewEtcdClient(ctx context.Context, cli *clientv3.Client) (Client, error) {
-	lease, err := cli.Grant(ctx, 5)
-	if err != nil {
-		return nil, err
+const (
+	defaultLeaseTTL        = 5 * time.Second
+	defaultCampaignTimeout = time.Second
+	electionPrefix         = "/deadman-switch-leader"
+)
+
+func NewEtcdClient(ctx context.Context, cli *clientv3.Client, cfg config.ElectionConfig) (Client, error) {
+	ttl := defaultLeaseTTL
+	if cfg.LeaseTTL > 0 {
+		ttl = time.Duration(cfg.LeaseTTL)
+	}
+	campaignTimeout := defaultCampaignTimeout
+	if cfg.CampaignTimeout > 0 {
+		campaignTimeout = time.Duration(cfg.CampaignTimeout)
 	}
-	ch, err := cli.KeepAlive(ctx, lease.ID)
+	c := &etcdClient{
+		cli:             cli,
+		ttl:             ttl,
+		campaignTimeout: campaignTimeout,
+	}
+	session, err := c.newSession(ctx)
 	if err != nil {
 		return nil, err
 	}
-	go func() {
+	c.setSession(session)
+	go c.keepSession(ctx)
+	return c, nil
+}
+
+type etcdClient struct {
+	cli             *clientv3.Client
+	ttl             time.Duration
+	campaignTimeout time.Duration
+
+	mutex    sync.RWMutex
+	session  *concurrency.Session
+	election *concurrency.Election
+	status   Status
+}
+
+func (c *etcdClient) newSession(ctx context.Context) (*concurrency.Session, error) {
+	// etcd leases have a granularity of seconds
+	return concurrency.NewSession(c.cli,
+		concurrency.WithTTL(int(math.Ceil(c.ttl.Seconds()))),
+		concurrency.WithContext(ctx),
+	)
+}
+
+func (c *etcdClient) setSession(session *concurrency.Session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.session = session
+	c.election = concurrency.NewElection(session, electionPrefix)
+	c.status.Healthy = true
+	c.status.Error = ""
+}
+
+func (c *etcdClient) current() (*concurrency.Session, *concurrency.Election) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.session, c.election
+}
+
+// keepSession replaces the session when its lease is lost, e.g. after a network partition longer than the TTL.
+// The leadership and all locks are gone with the lease, so the node has to campaign again.
+func (c *etcdClient) keepSession(ctx context.Context) {
+	for {
+		session, _ := c.current()
+		select {
+		case <-ctx.Done():
+			return
+		case <-session.Done():
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Msg("lost etcd session, leadership and locks are gone")
+		c.mutex.Lock()
+		c.status.Leader = false
+		c.status.Healthy = false
+		c.mutex.Unlock()
+
+		backoff := time.Second
 		for {
+			session, err := c.newSession(ctx)
+			if err == nil {
+				c.setSession(session)
+				c.mutex.Lock()
+				c.status.Renewals++
+				c.mutex.Unlock()
+				log.Info().Msg("created new etcd session")
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			c.mutex.Lock()
+			c.status.Error = err.Error()
+			c.mutex.Unlock()
+			log.Error().Err(err).Dur("backoff", backoff).Msg("failed to create etcd session")
 			select {
 			case <-ctx.Done():
 				return
-			case <-ch:
-				continue
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > c.ttl {
+				backoff = c.ttl
 			}
 		}
-	}()
-	session, err := concurrency.NewSession(cli, concurrency.WithLease(lease.ID))
-	if err != nil {
-		return nil, err
 	}
-	election := concurrency.NewElection(session, "/deadman-switch-leader")
-	return &etcdClient{
-		cli:      cli,
-		lease:    lease.ID,
-		session:  session,
-		election: election,
-	}, nil
-}
-
-type etcdClient struct {
-	cli      *clientv3.Client
-	lease    clientv3.LeaseID
-	session  *concurrency.Session
-	election *concurrency.Election
 }
 
 func (c *etcdClient) IsLeader(ctx context.Context, id string) (bool, error) {
-	if c.election.Key() == id {
-		return true, nil
+	session, election := c.current()
+	select {
+	case <-session.Done():
+		// keepSession is replacing the session
+		return false, nil
+	default:
 	}
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	// campaigning again is cheap while we are the leader, it returns right away
+	ctx, cancel := context.WithTimeout(ctx, c.campaignTimeout)
 	defer cancel()
-	err := c.election.Campaign(ctx, id)
+	err := election.Campaign(ctx, id)
+	leader := err == nil
+	c.mutex.Lock()
+	if election == c.election {
+		c.status.Leader = leader
+	}
+	c.mutex.Unlock()
 	if err != nil {
 		if err == context.Canceled {
 			return false, nil
@@ -64,7 +154,8 @@ func (c *etcdClient) IsLeader(ctx context.Context, id string) (bool, error) {
 }
 
 func (c *etcdClient) Lock(ctx context.Context, key string) error {
-	mutex := concurrency.NewMutex(c.session, key)
+	session, _ := c.current()
+	mutex := concurrency.NewMutex(session, key)
 	err := mutex.Lock(ctx)
 	if err != nil {
 		return err
@@ -75,3 +166,24 @@ func (c *etcdClient) Lock(ctx context.Context, key string) error {
 	}()
 	return nil
 }
+
+func (c *etcdClient) Status() Status {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.status
+}
+
+// Close resigns from the election and revokes the lease, so on a graceful shutdown another node
+// takes over and gets the locks right away instead of waiting for the lease to expire.
+// It doesn't use the context of the client, which is usually canceled on shutdown already.
+func (c *etcdClient) Close() error {
+	session, election := c.current()
+	ctx, cancel := context.WithTimeout(context.Background(), c.ttl)
+	defer cancel()
+	err := election.Resign(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to resign from leader election")
+	}
+	_, err = c.cli.Revoke(ctx, session.Lease())
+	return err
+}
@@ -0,0 +1,58 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+)
+
+// NewLocalClient returns a client for single node deployments. The local
+// node is always the leader and locks are only held within this process.
+func NewLocalClient() Client {
+	return &localClient{
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+type localClient struct {
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (c *localClient) IsLeader(ctx context.Context, id string) (bool, error) {
+	return true, nil
+}
+
+func (c *localClient) Status() Status {
+	return Status{Leader: true, Healthy: true}
+}
+
+func (c *localClient) Lock(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	mutex, ok := c.locks[key]
+	if !ok {
+		mutex = &sync.Mutex{}
+		c.locks[key] = mutex
+	}
+	c.mutex.Unlock()
+
+	locked := make(chan struct{})
+	go func() {
+		mutex.Lock()
+		close(locked)
+	}()
+	select {
+	case <-ctx.Done():
+		// release the lock as soon as we eventually get it
+		go func() {
+			<-locked
+			mutex.Unlock()
+		}()
+		return ctx.Err()
+	case <-locked:
+	}
+	go func() {
+		<-ctx.Done()
+		mutex.Unlock()
+	}()
+	return nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ActionPlanConfig is an ordered list of actions which is run when a service alarms.
+// The rollback steps are run when the service recovers.
+type ActionPlanConfig struct {
+	Name     string             `json:"name"`
+	Steps    []ActionStepConfig `json:"steps"`
+	Rollback []ActionStepConfig `json:"rollback"`
+	// Destructive plans only run after somebody clicked the approval link sent to the approval notifications
+	Destructive bool `json:"destructive"`
+	// ApprovalWindow is how long the approval link is valid, defaults to 15m
+	ApprovalWindow        Duration             `json:"approvalWindow"`
+	ApprovalNotifications []NotificationConfig `json:"approvalNotifications"`
+}
+
+// ApprovalsConfig configures the approval links of destructive action plans
+type ApprovalsConfig struct {
+	// URL is the external base URL of the server which is used in the links
+	URL string `json:"url"`
+	// Secret signs the links, all servers of a cluster need the same one
+	Secret string `json:"secret"`
+	// TrustedProxies are the CIDRs of reverse proxies, the X-Forwarded-For header of their requests is recorded
+	// as the address of the approver. Without them the address of the connection is recorded.
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+func (c ApprovalsConfig) Validate() error {
+	for _, network := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q, expected a CIDR like 10.0.0.0/8", network)
+		}
+	}
+	return nil
+}
+
+type ActionType string
+
+const (
+	ActionTypeWebhook ActionType = "webhook"
+	ActionTypeExec    ActionType = "exec"
+	ActionTypeSSH     ActionType = "ssh"
+)
+
+type ActionStepConfig struct {
+	Name string     `json:"name"`
+	Type ActionType `json:"type"`
+	// Timeout of the step, defaults to 30s
+	Timeout Duration `json:"timeout"`
+	// Check decides whether the step succeeded, by default a webhook needs a 2xx status and a command exit code 0
+	Check *ActionCheckConfig `json:"check"`
+	// ContinueOnError runs the next steps even if this one failed
+	ContinueOnError bool        `json:"continueOnError"`
+	Config          interface{} `json:"config"`
+}
+
+type ActionCheckConfig struct {
+	StatusCodes    []int  `json:"statusCodes"`
+	ExitCode       int    `json:"exitCode"`
+	OutputContains string `json:"outputContains"`
+}
+
+type ExecActionConfig struct {
+	Command []string `json:"command"`
+	Dir     string   `json:"dir"`
+	Env     []string `json:"env"`
+}
+
+// SSHActionConfig runs a command on a remote host with the ssh client of the system
+type SSHActionConfig struct {
+	Host         string `json:"host"`
+	User         string `json:"user"`
+	Port         int    `json:"port"`
+	IdentityFile string `json:"identityFile"`
+	Command      string `json:"command"`
+}
+
+func (s ActionStepConfig) GetWebhookConfig() (cfg WebhookConfig, err error) {
+	if s.Type != ActionTypeWebhook {
+		return cfg, errors.New("this is not a webhook action")
+	}
+	err = mapstructure.Decode(s.Config, &cfg)
+	return cfg, err
+}
+
+func (s ActionStepConfig) GetExecConfig() (cfg ExecActionConfig, err error) {
+	if s.Type != ActionTypeExec {
+		return cfg, errors.New("this is not an exec action")
+	}
+	err = mapstructure.Decode(s.Config, &cfg)
+	return cfg, err
+}
+
+func (s ActionStepConfig) GetSSHConfig() (cfg SSHActionConfig, err error) {
+	if s.Type != ActionTypeSSH {
+		return cfg, errors.New("this is not a ssh action")
+	}
+	err = mapstructure.Decode(s.Config, &cfg)
+	return cfg, err
+}
+
+// Validate checks that the plan has a name and all steps are complete
+func (p ActionPlanConfig) Validate() error {
+	if p.Name == "" {
+		return errors.New("action plan has no name")
+	}
+	if p.Destructive && len(p.ApprovalNotifications) == 0 {
+		return fmt.Errorf("action plan %s is destructive but has no approval notifications", p.Name)
+	}
+	for _, step := range append(append([]ActionStepConfig{}, p.Steps...), p.Rollback...) {
+		var err error
+		switch step.Type {
+		case ActionTypeWebhook:
+			var cfg WebhookConfig
+			cfg, err = step.GetWebhookConfig()
+			if err == nil && cfg.URL == "" {
+				err = errors.New("url is missing")
+			}
+		case ActionTypeExec:
+			var cfg ExecActionConfig
+			cfg, err = step.GetExecConfig()
+			if err == nil && len(cfg.Command) == 0 {
+				err = errors.New("command is missing")
+			}
+		case ActionTypeSSH:
+			var cfg SSHActionConfig
+			cfg, err = step.GetSSHConfig()
+			if err == nil && (cfg.Host == "" || cfg.Command == "") {
+				err = errors.New("host or command is missing")
+			}
+		default:
+			err = fmt.Errorf("unknown action type %q", step.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("action plan %s, step %s: %v", p.Name, step.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxActiveHoursDays bounds the search for a deadline, it is only reached with timeouts of years
+const maxActiveHoursDays = 4000
+
+// locations caches the loaded timezones by name, time.LoadLocation reads from disk every time
+var locations sync.Map
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ActiveHours are the times a service is expected to work, like weekdays from 08:00 to 18:00. Only the time
+// within them counts toward the timeout, and alerts are only sent within them.
+type ActiveHours struct {
+	// Timezone of the ranges like Europe/Berlin, defaults to UTC
+	Timezone string             `json:"timezone"`
+	Ranges   []ActiveHoursRange `json:"ranges"`
+}
+
+// ActiveHoursRange is a daily time range on some weekdays. A range ending before its start ends on the next day,
+// one without start and end lasts the whole day.
+type ActiveHoursRange struct {
+	// Days are weekdays like mon or ranges like mon-fri, all days if empty
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+func (h ActiveHours) Validate() error {
+	if len(h.Ranges) == 0 {
+		return errors.New("active hours need at least one range")
+	}
+	if _, err := h.location(); err != nil {
+		return fmt.Errorf("invalid active hours timezone: %w", err)
+	}
+	for i, r := range h.Ranges {
+		if _, _, _, err := r.parse(); err != nil {
+			return fmt.Errorf("active hours range %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether t is within the active hours
+func (h ActiveHours) Contains(t time.Time) bool {
+	loc, err := h.location()
+	if err != nil {
+		return true
+	}
+	t = t.In(loc)
+	for _, interval := range h.intervals(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)) {
+		if !t.Before(interval[0]) && t.Before(interval[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deadline returns the time at which the active hours since from add up to timeout
+func (h ActiveHours) Deadline(from time.Time, timeout time.Duration) time.Time {
+	loc, err := h.location()
+	if err != nil {
+		return from.Add(timeout)
+	}
+	remaining := timeout
+	t := from.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for day := 0; day < maxActiveHoursDays; day++ {
+		for _, interval := range h.intervals(midnight.AddDate(0, 0, day)) {
+			start, end := interval[0], interval[1]
+			if !end.After(from) {
+				continue
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if length := end.Sub(start); remaining > length {
+				remaining -= length
+				continue
+			}
+			return start.Add(remaining)
+		}
+	}
+	return from.Add(timeout)
+}
+
+// intervals returns the merged active intervals of the day starting at midnight, including the ones of the
+// ranges of the day before which end on this day
+func (h ActiveHours) intervals(midnight time.Time) [][2]time.Time {
+	next := midnight.AddDate(0, 0, 1)
+	// the wall clock time, days with a daylight saving time change are shorter or longer
+	at := func(day time.Time, offset time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, day.Location())
+	}
+	var intervals [][2]time.Time
+	for _, r := range h.Ranges {
+		days, start, end, err := r.parse()
+		if err != nil {
+			continue
+		}
+		switch {
+		case start < end:
+			if days[midnight.Weekday()] {
+				intervals = append(intervals, [2]time.Time{at(midnight, start), at(midnight, end)})
+			}
+		default:
+			// the range wraps around midnight or lasts the whole day
+			if days[midnight.Weekday()] {
+				intervals = append(intervals, [2]time.Time{at(midnight, start), next})
+			}
+			if previous := midnight.AddDate(0, 0, -1); days[previous.Weekday()] && end > 0 {
+				intervals = append(intervals, [2]time.Time{midnight, at(midnight, end)})
+			}
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0].Before(intervals[j][0]) })
+	var merged [][2]time.Time
+	for _, interval := range intervals {
+		if n := len(merged); n > 0 && !interval[0].After(merged[n-1][1]) {
+			if interval[1].After(merged[n-1][1]) {
+				merged[n-1][1] = interval[1]
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// location returns the timezone of the active hours, it is loaded once on validation or first use and cached
+func (h ActiveHours) location() (*time.Location, error) {
+	if h.Timezone == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(h.Timezone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(h.Timezone, loc)
+	return loc, nil
+}
+
+// parse returns the weekdays of the range and its start and end as durations since midnight
+func (r ActiveHoursRange) parse() (days [7]bool, start, end time.Duration, err error) {
+	if len(r.Days) == 0 {
+		for i := range days {
+			days[i] = true
+		}
+	}
+	for _, spec := range r.Days {
+		parts := strings.SplitN(strings.ToLower(strings.TrimSpace(spec)), "-", 2)
+		first, ok := weekdays[parts[0]]
+		if !ok {
+			return days, 0, 0, fmt.Errorf("invalid weekday %q, expected mon, tue, ... or a range like mon-fri", spec)
+		}
+		last := first
+		if len(parts) == 2 {
+			last, ok = weekdays[parts[1]]
+			if !ok {
+				return days, 0, 0, fmt.Errorf("invalid weekday %q, expected mon, tue, ... or a range like mon-fri", spec)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	if r.Start != "" {
+		start, err = parseTimeOfDay(r.Start)
+		if err != nil {
+			return days, 0, 0, err
+		}
+	}
+	if r.End != "" {
+		end, err = parseTimeOfDay(r.End)
+		if err != nil {
+			return days, 0, 0, err
+		}
+	}
+	return days, start, end, nil
+}
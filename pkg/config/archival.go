@@ -0,0 +1,14 @@
+package config
+
+// ArchivalConfig archives services which neither pinged nor changed for StaleAfter, so the configs of
+// decommissioned services don't pile up. Services with an active alarm are never archived.
+type ArchivalConfig struct {
+	// StaleAfter enables the archival, e.g. 2160h for 90 days
+	StaleAfter Duration `json:"staleAfter"`
+	// Interval between two searches for stale services, defaults to 1h
+	Interval Duration `json:"interval"`
+	// Services limits the archival to the selected services, it defaults to all services
+	Services Selector `json:"services"`
+	// Notifications are told about every archived service
+	Notifications []NotificationConfig `json:"notifications"`
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuthProviderAdmin is the name of the provider checking the username and password of the server config
+const AuthProviderAdmin = "admin"
+
+// AuthRouteDefault is the route group of all admin routes without a chain of their own
+const AuthRouteDefault = "default"
+
+// AuthConfig configures how the admin API authenticates its callers
+type AuthConfig struct {
+	// Providers are the named providers, the username and password of the server config are the provider "admin"
+	Providers []AuthProviderConfig `json:"providers"`
+	// Routes maps route groups onto the names of the providers which are tried in order. A group is the first
+	// segment of the path like "/config", "default" applies to all groups without a chain of their own
+	// and defaults to the "admin" provider.
+	Routes map[string][]string `json:"routes"`
+}
+
+// AuthProviderConfig configures a provider of a type like basic, token, oidc or mtls, or of a registered type
+type AuthProviderConfig struct {
+	Name   string      `json:"name"`
+	Type   string      `json:"type"`
+	Config interface{} `json:"config"`
+}
+
+// TLSConfig lets the server listen with TLS, with a client CA it asks for client certificates
+type TLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile verifies the client certificates, which are optional for the TLS handshake
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+func (cfg AuthConfig) Validate() error {
+	names := map[string]bool{AuthProviderAdmin: true}
+	for _, provider := range cfg.Providers {
+		if provider.Name == "" || provider.Type == "" {
+			return errors.New("auth providers need a name and a type")
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("the auth provider name %s is used twice", provider.Name)
+		}
+		names[provider.Name] = true
+	}
+	for group, providers := range cfg.Routes {
+		if group != AuthRouteDefault && (!strings.HasPrefix(group, "/") || strings.Count(group, "/") != 1) {
+			return fmt.Errorf("the auth route group %s must be the first segment of a path like /config", group)
+		}
+		if len(providers) == 0 {
+			return fmt.Errorf("the auth route group %s needs providers", group)
+		}
+		for _, name := range providers {
+			if !names[name] {
+				return fmt.Errorf("the auth route group %s refers to the unknown provider %s", group, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (cfg TLSConfig) Validate() error {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return errors.New("tls needs the cert and the key file")
+	}
+	return nil
+}
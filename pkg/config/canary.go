@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CanaryConfig sends test notifications through notification channels in an interval and alerts when
+// they don't arrive, so a broken channel like an expired token is noticed before a real incident
+type CanaryConfig struct {
+	// Interval between two rounds of test notifications, defaults to 1h
+	Interval Duration `json:"interval"`
+	// Timeout is the time a test notification may take to arrive, defaults to 5m
+	Timeout  Duration              `json:"timeout"`
+	Channels []CanaryChannelConfig `json:"channels"`
+	// Notifications receive the canary alerts, they default to the notifications of the meta alerts
+	Notifications []NotificationConfig `json:"notifications"`
+}
+
+// CanaryChannelConfig is a channel the canary checks
+type CanaryChannelConfig struct {
+	Name         string             `json:"name"`
+	Notification NotificationConfig `json:"notification"`
+	Verify       CanaryVerifyConfig `json:"verify"`
+}
+
+// Canary verification types
+const (
+	// CanaryVerifyEcho waits until the target posts the nonce back to /canary/<name>/<nonce>
+	CanaryVerifyEcho = "echo"
+	// CanaryVerifySlack reads the channel until a message with the nonce shows up
+	CanaryVerifySlack = "slack"
+)
+
+// CanaryVerifyConfig configures how the arrival of a test notification is verified
+type CanaryVerifyConfig struct {
+	// Type is echo or slack, defaults to echo
+	Type string `json:"type"`
+	// Token and Channel of slack verifications, Channel is the channel ID and Token defaults to the token of the notification
+	Token   string `json:"token"`
+	Channel string `json:"channel"`
+}
+
+func (c CanaryConfig) Validate() error {
+	names := make(map[string]bool)
+	for _, channel := range c.Channels {
+		if channel.Name == "" || strings.Contains(channel.Name, "/") {
+			return fmt.Errorf("canary channel %q needs a name without slashes", channel.Name)
+		}
+		if names[channel.Name] {
+			return fmt.Errorf("canary channel %s is configured twice", channel.Name)
+		}
+		names[channel.Name] = true
+		if channel.Notification.Type == "" {
+			return fmt.Errorf("canary channel %s needs a notification", channel.Name)
+		}
+		switch channel.Verify.Type {
+		case "", CanaryVerifyEcho:
+		case CanaryVerifySlack:
+			if channel.Verify.Channel == "" {
+				return fmt.Errorf("canary channel %s needs the ID of the slack channel to verify", channel.Name)
+			}
+		default:
+			return fmt.Errorf("canary channel %s has the unknown verification %q", channel.Name, channel.Verify.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ChatOpsConfig configures the bots which answer commands in chat rooms
+type ChatOpsConfig struct {
+	// Prefix starts a command in a message, defaults to !deadman
+	Prefix  string            `json:"prefix"`
+	Matrix  *MatrixBotConfig  `json:"matrix"`
+	Discord *DiscordBotConfig `json:"discord"`
+}
+
+// MatrixBotConfig lets a Matrix user join a room and answer commands there
+type MatrixBotConfig struct {
+	// Homeserver is the base URL of the homeserver, e.g. https://matrix.example.com
+	Homeserver  string `json:"homeserver"`
+	AccessToken string `json:"accessToken"`
+	// Room is the ID or alias of the room
+	Room string `json:"room"`
+	// Operators are the Matrix user IDs which may acknowledge and silence alarms, everyone in the room may query
+	Operators []string `json:"operators"`
+}
+
+func (c MatrixBotConfig) Validate() error {
+	if c.Homeserver == "" || c.AccessToken == "" || c.Room == "" {
+		return errors.New("the matrix bot needs a homeserver, an access token and a room")
+	}
+	return nil
+}
+
+// DiscordBotConfig lets a Discord bot answer commands in a channel. The bot needs the message content intent.
+type DiscordBotConfig struct {
+	Token     string `json:"token"`
+	ChannelID string `json:"channelID"`
+	// Operators are the Discord user IDs which may acknowledge and silence alarms, everyone in the channel may query
+	Operators []string `json:"operators"`
+}
+
+func (c DiscordBotConfig) Validate() error {
+	if c.Token == "" || c.ChannelID == "" {
+		return errors.New("the discord bot needs a token and a channel ID")
+	}
+	for _, operator := range c.Operators {
+		if _, err := strconv.ParseUint(operator, 10, 64); err != nil {
+			return fmt.Errorf("invalid operator %q, expected a Discord user ID", operator)
+		}
+	}
+	return nil
+}
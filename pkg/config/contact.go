@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Contact is a person which can be notified about the services it is responsible for
+type Contact struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	SlackID string `json:"slackID"`
+	Email   string `json:"email"`
+	// Phone is the number in E.164 format like +49123456789 which receives text messages
+	Phone string `json:"phone"`
+	// PreferredChannels are used in order to notify the contact
+	PreferredChannels []ContactChannel `json:"preferredChannels"`
+	// QuietHours are the times the contact doesn't want to be notified
+	QuietHours *QuietHours `json:"quietHours"`
+}
+
+type ContactChannel string
+
+const (
+	ContactChannelSlack ContactChannel = "slack"
+	ContactChannelEmail ContactChannel = "email"
+	ContactChannelSMS   ContactChannel = "sms"
+)
+
+// QuietHours is a daily time range like 22:00 - 07:00 in the given timezone
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// ContactChannelsConfig holds the global settings used to reach contacts on their channels
+type ContactChannelsConfig struct {
+	Slack *SlackContactChannelConfig `json:"slack"`
+	Email *EmailContactChannelConfig `json:"email"`
+	SMS   *SMSContactChannelConfig   `json:"sms"`
+}
+
+type SlackContactChannelConfig struct {
+	// Token of the slack app which sends the direct messages
+	Token string `json:"token"`
+	// Workspace the Slack app is installed in, it replaces the token
+	Workspace string `json:"workspace"`
+}
+
+// EmailContactChannelConfig is the SMTP server which sends the emails to contacts, see EmailConfig
+type EmailContactChannelConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TLS      string `json:"tls"`
+	From     string `json:"from"`
+}
+
+// SMSContactChannelConfig is the Twilio account which sends the text messages to contacts, see TwilioConfig
+type SMSContactChannelConfig struct {
+	AccountSID string `json:"accountSid"`
+	AuthToken  string `json:"authToken"`
+	From       string `json:"from"`
+	URL        string `json:"url"`
+}
+
+// Contains reports whether t is within the quiet hours
+func (q QuietHours) Contains(t time.Time) (bool, error) {
+	loc := time.UTC
+	if q.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(q.Timezone)
+		if err != nil {
+			return false, err
+		}
+	}
+	start, err := parseTimeOfDay(q.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseTimeOfDay(q.End)
+	if err != nil {
+		return false, err
+	}
+	t = t.In(loc)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if start <= end {
+		return now >= start && now < end, nil
+	}
+	// the range wraps around midnight
+	return now >= start || now < end, nil
+}
+
+// parseTimeOfDay parses "15:04" into the duration since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Validate checks the contact for errors
+func (c Contact) Validate() error {
+	if c.ID == "" || strings.ContainsAny(c.ID, "/*?[]\\") {
+		return fmt.Errorf("invalid contact id %q", c.ID)
+	}
+	if c.Email != "" {
+		if _, err := mail.ParseAddress(c.Email); err != nil {
+			return fmt.Errorf("contact %s has an invalid email %q", c.ID, c.Email)
+		}
+	}
+	if c.Phone != "" && !phoneNumber.MatchString(c.Phone) {
+		return fmt.Errorf("contact %s has an invalid phone %q, expected the E.164 format like +49123456789", c.ID, c.Phone)
+	}
+	for _, channel := range c.PreferredChannels {
+		switch channel {
+		case ContactChannelSlack:
+			if c.SlackID == "" {
+				return fmt.Errorf("contact %s prefers slack but has no slackID", c.ID)
+			}
+		case ContactChannelEmail:
+			if c.Email == "" {
+				return fmt.Errorf("contact %s prefers email but has no email", c.ID)
+			}
+		case ContactChannelSMS:
+			if c.Phone == "" {
+				return fmt.Errorf("contact %s prefers sms but has no phone", c.ID)
+			}
+		default:
+			return fmt.Errorf("contact %s has unknown channel %q", c.ID, channel)
+		}
+	}
+	if c.QuietHours != nil {
+		if _, err := c.QuietHours.Contains(time.Now()); err != nil {
+			return fmt.Errorf("contact %s has invalid quiet hours: %v", c.ID, err)
+		}
+	}
+	return nil
+}
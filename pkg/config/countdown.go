@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CountdownConfig warns before a service which hasn't pinged reaches its timeout, so its owners can fix it before they are paged
+type CountdownConfig struct {
+	// LeadTimes are the times before the deadline at which a warning is sent, e.g. [1h, 15m]
+	LeadTimes []Duration `json:"leadTimes"`
+	// Notifications receive the warnings, usually a lower priority channel than the alerts
+	Notifications []NotificationConfig `json:"notifications"`
+}
+
+func (c CountdownConfig) Validate(timeout Duration) error {
+	if len(c.LeadTimes) == 0 {
+		return errors.New("the countdown needs at least one lead time")
+	}
+	if len(c.Notifications) == 0 {
+		return errors.New("the countdown needs notifications")
+	}
+	for _, lead := range c.LeadTimes {
+		if lead <= 0 || (timeout > 0 && lead >= timeout) {
+			return fmt.Errorf("the countdown lead time %s must be positive and shorter than the timeout", time.Duration(lead))
+		}
+	}
+	return nil
+}
+
+// Due returns the shortest lead time which is reached when the deadline is remaining away
+func (c CountdownConfig) Due(remaining time.Duration) (time.Duration, bool) {
+	var due time.Duration
+	found := false
+	for _, lead := range c.LeadTimes {
+		if remaining <= time.Duration(lead) && (!found || time.Duration(lead) < due) {
+			due = time.Duration(lead)
+			found = true
+		}
+	}
+	return due, found
+}
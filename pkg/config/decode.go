@@ -0,0 +1,40 @@
+package config
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Decode decodes a loosely typed config section (as read from yaml or json) into target.
+// Durations may be given as strings like "30s".
+func Decode(input interface{}, target interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       durationDecodeHook,
+		WeaklyTypedInput: true,
+		Result:           target,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
+func durationDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(Duration(0)) {
+		return data, nil
+	}
+	switch value := data.(type) {
+	case string:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return Duration(d), nil
+	case float64:
+		return Duration(value), nil
+	default:
+		return data, nil
+	}
+}
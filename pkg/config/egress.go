@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// EgressConfig limits where notifications may connect to, so the URLs of services created through the API
+// can't be used to reach the internal network. Server-declared notifications are checked when they are sent too,
+// allow their endpoints explicitly.
+type EgressConfig struct {
+	// AllowedURLs are patterns the endpoints of notifications, callbacks and forwards must match if any are given,
+	// like https://hooks.example.com/** or kafka://*.kafka.internal:9092. A * matches anything but a slash,
+	// ** matches anything. Endpoints which aren't URLs are matched as smtp://, kafka://, zabbix:// and nsca:// URLs.
+	AllowedURLs []string `json:"allowedURLs"`
+	// DeniedNetworks are CIDRs no notification may connect to
+	DeniedNetworks []string `json:"deniedNetworks"`
+	// DenyPrivateNetworks denies loopback, private, link-local (like the cloud metadata services) and
+	// unique local addresses
+	DenyPrivateNetworks bool `json:"denyPrivateNetworks"`
+	// AllowedNetworks are CIDRs which may be connected to even though they are denied
+	AllowedNetworks []string `json:"allowedNetworks"`
+	// DisableExec rejects exec notifications, even of commands declared by the server
+	DisableExec bool `json:"disableExec"`
+}
+
+func (c EgressConfig) Validate() error {
+	for _, pattern := range c.AllowedURLs {
+		if !strings.Contains(pattern, "://") {
+			return fmt.Errorf("invalid allowed url %q, expected a pattern like https://hooks.example.com/**", pattern)
+		}
+	}
+	for _, network := range append(append([]string{}, c.DeniedNetworks...), c.AllowedNetworks...) {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid network %q, expected a CIDR like 10.0.0.0/8", network)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Escalation widens the notification scope of an alarm the longer it stays unacknowledged,
+// like from the team channel to the department channel to the incident bridge
+type Escalation []EscalationTier
+
+// EscalationTier is notified once the alarm is active and unacknowledged for After
+type EscalationTier struct {
+	After Duration `json:"after"`
+	// Contacts are the IDs of the contacts which are notified on their preferred channels
+	Contacts              []string             `json:"contacts"`
+	AlertNotifications    []NotificationConfig `json:"alertNotifications"`
+	RecoveryNotifications []NotificationConfig `json:"recoveryNotifications"`
+}
+
+// Reached returns the tiers which are reached when the alarm is active for the given duration, the earliest first
+func (e Escalation) Reached(active time.Duration) []EscalationTier {
+	tiers := append([]EscalationTier{}, e...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].After < tiers[j].After
+	})
+	for i, tier := range tiers {
+		if time.Duration(tier.After) > active {
+			return tiers[:i]
+		}
+	}
+	return tiers
+}
+
+// Validate checks the tiers, a tier without after is notified together with the first alert
+func (e Escalation) Validate() error {
+	for i, tier := range e {
+		if tier.After < 0 {
+			return fmt.Errorf("escalation tier %d has a negative after duration", i+1)
+		}
+	}
+	return nil
+}
+
+// EscalationPolicyConfig is a named escalation which services share by referring to it with escalationPolicy
+type EscalationPolicyConfig struct {
+	Name  string     `json:"name"`
+	Tiers Escalation `json:"tiers"`
+}
+
+func (p EscalationPolicyConfig) Validate() error {
+	if p.Name == "" {
+		return errors.New("escalation policies need a name")
+	}
+	if len(p.Tiers) == 0 {
+		return fmt.Errorf("escalation policy %q has no tiers", p.Name)
+	}
+	err := p.Tiers.Validate()
+	if err != nil {
+		return fmt.Errorf("escalation policy %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// WithEscalationPolicy returns the service config with the tiers of its escalation policy, unless it has tiers itself.
+// The tiers stay empty if the policy is unknown.
+func (svc ServiceConfig) WithEscalationPolicy(policies []EscalationPolicyConfig) ServiceConfig {
+	if len(svc.Escalation) > 0 || svc.EscalationPolicy == "" {
+		return svc
+	}
+	for _, policy := range policies {
+		if policy.Name == svc.EscalationPolicy {
+			svc.Escalation = policy.Tiers
+			break
+		}
+	}
+	return svc
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// EventStreamConfig publishes the lifecycle events and every recorded heartbeat to Kafka topics, e.g. for
+// reliability analytics. Event groups without a topic are not published.
+type EventStreamConfig struct {
+	// Brokers are host:port addresses to bootstrap from
+	Brokers []string `json:"brokers"`
+	// AlarmTopic receives the alarm.created, alarm.acknowledged and alarm.resolved events
+	AlarmTopic string `json:"alarmTopic"`
+	// SilenceTopic receives the silence.created events
+	SilenceTopic string `json:"silenceTopic"`
+	// HeartbeatTopic receives a heartbeat.received event per recorded heartbeat
+	HeartbeatTopic string `json:"heartbeatTopic"`
+	// Serialization is json (default) or avro, SchemaRegistry is the URL of a Confluent schema registry for avro
+	Serialization  string `json:"serialization"`
+	SchemaRegistry string `json:"schemaRegistry"`
+	TLS            bool   `json:"tls"`
+	// Username and Password authenticate with SASL PLAIN
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c EventStreamConfig) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("the event stream needs at least one broker")
+	}
+	for _, broker := range c.Brokers {
+		if _, port, err := net.SplitHostPort(broker); err != nil || port == "" {
+			return fmt.Errorf("invalid broker %q, expected an address like kafka:9092", broker)
+		}
+	}
+	if c.AlarmTopic == "" && c.SilenceTopic == "" && c.HeartbeatTopic == "" {
+		return errors.New("the event stream needs at least one topic")
+	}
+	for _, topic := range []string{c.AlarmTopic, c.SilenceTopic, c.HeartbeatTopic} {
+		if topic != "" && !kafkaTopic.MatchString(topic) {
+			return fmt.Errorf("invalid topic %q, expected letters, digits, '.', '_' and '-'", topic)
+		}
+	}
+	switch c.Serialization {
+	case "", "json":
+		if c.SchemaRegistry != "" {
+			return errors.New("the schema registry is only used for avro")
+		}
+	case "avro":
+		if c.SchemaRegistry != "" {
+			if errs := checkURL("schemaRegistry", c.SchemaRegistry); errs != nil {
+				return errs
+			}
+		}
+	default:
+		return fmt.Errorf("invalid serialization %q, expected json or avro", c.Serialization)
+	}
+	if c.Password != "" && c.Username == "" {
+		return errors.New("the event stream password needs a username")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ForwardConfig forwards every heartbeat of a service with its payload to a URL, so the deadman switch can
+// fan out job telemetry. The heartbeats are posted in batches as a JSON array, see package forward.
+type ForwardConfig struct {
+	URL string `json:"url"`
+	// Method defaults to POST
+	Method  string              `json:"method"`
+	Headers map[string][]string `json:"headers"`
+	// BatchSize is the maximum number of heartbeats per request, defaults to 100
+	BatchSize int `json:"batchSize"`
+	// BatchInterval is the longest time a heartbeat waits for its batch, defaults to 5s
+	BatchInterval Duration `json:"batchInterval"`
+}
+
+func (f ForwardConfig) Validate() error {
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("forward needs an absolute http or https url, got %q", f.URL)
+	}
+	if f.BatchSize < 0 || f.BatchInterval < 0 {
+		return fmt.Errorf("forward needs a positive batch size and interval")
+	}
+	return nil
+}
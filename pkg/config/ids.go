@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Service IDs are hierarchical and slash separated like `team/app/job`.
+// This matches the key layout in the storage backends, so a service `team/app/job`
+// is stored at `services/team/app/job`.
+
+// ValidateServiceID checks that id is a valid, possibly hierarchical service ID
+func ValidateServiceID(id string) error {
+	if id == "" {
+		return errors.New("service id must not be empty")
+	}
+	for _, segment := range strings.Split(id, "/") {
+		switch {
+		case segment == "":
+			return fmt.Errorf("service id %q contains an empty segment", id)
+		case segment == "." || segment == "..":
+			return fmt.Errorf("service id %q must not contain relative segments", id)
+		case strings.ContainsAny(segment, "*?[]\\"):
+			return fmt.Errorf("service id %q must not contain wildcard characters", id)
+		}
+	}
+	return nil
+}
+
+// MatchServiceID reports whether the service id matches the pattern.
+// Patterns are matched segment by segment using path.Match, so `*` matches
+// within one segment (`team/*/job`). The special segment `**` matches any number
+// of segments (`team/**`). An empty pattern matches everything.
+func MatchServiceID(pattern, id string) bool {
+	if pattern == "" {
+		return true
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(id, "/"))
+}
+
+func matchSegments(pattern, id []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(id); i++ {
+				if matchSegments(pattern[1:], id[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(id) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], id[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, id = pattern[1:], id[1:]
+	}
+	return len(id) == 0
+}
+
+// HasServiceIDPrefix reports whether id is prefix itself or is located below it in the hierarchy.
+// An empty prefix matches all services.
+func HasServiceIDPrefix(id, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || id == prefix || strings.HasPrefix(id, prefix+"/")
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	DefaultKafkaHeartbeatsGroup  = "deadman-switch"
+	DefaultKafkaHeartbeatsMaxAge = Duration(5 * time.Minute)
+)
+
+// KafkaHeartbeatsConfig consumes heartbeats from a Kafka topic, the key of a message is the ID of the service
+// and the value is handled like the body of a ping
+type KafkaHeartbeatsConfig struct {
+	// Brokers are host:port addresses to bootstrap from
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+	// Group is the consumer group the offsets are committed for, deadman-switch by default
+	Group string `json:"group"`
+	// StartFrom is latest (default) or earliest, where a group without committed offsets starts
+	StartFrom string `json:"startFrom"`
+	// MaxAge skips messages which are older, e.g. after a long downtime, 5m by default
+	MaxAge   Duration `json:"maxAge"`
+	TLS      bool     `json:"tls"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+}
+
+// Validate checks the config, the event stream must not publish to the topic, or every recorded heartbeat would
+// be consumed as a heartbeat again
+func (c KafkaHeartbeatsConfig) Validate(eventStream *EventStreamConfig) error {
+	if len(c.Brokers) == 0 {
+		return errors.New("the kafka heartbeats need at least one broker")
+	}
+	for _, broker := range c.Brokers {
+		if _, port, err := net.SplitHostPort(broker); err != nil || port == "" {
+			return fmt.Errorf("invalid broker %q, expected an address like kafka:9092", broker)
+		}
+	}
+	if !kafkaTopic.MatchString(c.Topic) {
+		return fmt.Errorf("invalid topic %q, expected letters, digits, '.', '_' and '-'", c.Topic)
+	}
+	if eventStream != nil {
+		for _, topic := range []string{eventStream.AlarmTopic, eventStream.SilenceTopic, eventStream.HeartbeatTopic} {
+			if topic == c.Topic {
+				return fmt.Errorf("the event stream publishes to the kafka heartbeats topic %q", c.Topic)
+			}
+		}
+	}
+	switch c.StartFrom {
+	case "", "latest", "earliest":
+	default:
+		return fmt.Errorf("invalid startFrom %q, expected latest or earliest", c.StartFrom)
+	}
+	if c.MaxAge < 0 {
+		return errors.New("maxAge must not be negative")
+	}
+	if c.Password != "" && c.Username == "" {
+		return errors.New("the kafka heartbeats password needs a username")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultLinkTTL is the lifetime of short links if none is configured
+const DefaultLinkTTL = Duration(24 * time.Hour)
+
+// LinksConfig adds short links to notifications, they open a read-only page of the service without the admin credentials
+type LinksConfig struct {
+	// URL is the external base URL of the server, the links are <url>/a/<id>
+	URL string `json:"url"`
+	// Secret signs the link IDs, all servers of a cluster need the same one
+	Secret string `json:"secret"`
+	// TTL is the time a link stays valid, defaults to 24h
+	TTL Duration `json:"ttl"`
+}
+
+func (cfg LinksConfig) Validate() error {
+	if cfg.URL == "" || cfg.Secret == "" {
+		return errors.New("links need the url and the secret")
+	}
+	if cfg.TTL < 0 {
+		return errors.New("the ttl of links must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// phoneNumber is a phone number in the E.164 format
+	phoneNumber = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	// twilioSID is the ID of a Twilio resource, two letters for its type and 32 hex digits
+	twilioSID = regexp.MustCompile(`^[A-Z]{2}[0-9a-f]{32}$`)
+	// kafkaTopic is a legal name of a Kafka topic
+	kafkaTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+	// awsRegion is the name of an AWS region like eu-central-1 or us-gov-west-1
+	awsRegion = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	// pubSubTopic is the full name of a Pub/Sub topic
+	pubSubTopic = regexp.MustCompile(`^projects/[a-z][a-z0-9-]{4,28}[a-z0-9]/topics/[a-zA-Z][a-zA-Z0-9._~%+-]{2,254}$`)
+)
+
+// FieldError is an invalid field of a config, Field is its path like alertNotifications[0].config.url
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// FieldErrors are all invalid fields of a config
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + ": " + field.Error
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Prefixed returns the errors with the fields prefixed by the path of their parent
+func (e FieldErrors) Prefixed(prefix string) FieldErrors {
+	prefixed := make(FieldErrors, len(e))
+	for i, field := range e {
+		prefixed[i] = FieldError{prefix, field.Error}
+		if field.Field != "" {
+			prefixed[i].Field += "." + field.Field
+		}
+	}
+	return prefixed
+}
+
+// Normalize checks the config of a built-in notification type field by field and returns the notification
+// with the config in its canonical form, which the notifier can always decode.
+// Other types are returned unchanged, see notifier.NormalizeNotification.
+func (n NotificationConfig) Normalize() (NotificationConfig, FieldErrors) {
+	var (
+		typed  interface{}
+		checks func() FieldErrors
+	)
+	switch n.Type {
+	case NotificationTypeWebhook:
+		var cfg WebhookConfig
+		typed, checks = &cfg, func() FieldErrors {
+			errs := checkTemplate("url", cfg.URL)
+			if errs == nil {
+				errs = checkURL("url", cfg.URL)
+			}
+			for key, values := range cfg.Headers {
+				for i, value := range values {
+					errs = append(errs, checkTemplate(fmt.Sprintf("headers.%s[%d]", key, i), value)...)
+				}
+			}
+			switch cfg.Format {
+			case "":
+				if cfg.Source != "" {
+					errs = append(errs, FieldError{"source", "is only used for cloudevents"})
+				}
+			case WebhookFormatCloudEvents:
+				if cfg.Body != "" {
+					errs = append(errs, FieldError{"body", "can't be used with cloudevents, the event is the body"})
+				}
+			default:
+				errs = append(errs, FieldError{"format", "must be cloudevents or empty"})
+			}
+			return append(errs, checkTemplate("body", cfg.Body)...)
+		}
+	case NotificationTypeSlack:
+		var cfg SlackConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if cfg.Token == "" && cfg.Workspace == "" {
+				errs = append(errs, FieldError{"token", "is required without a workspace"})
+			}
+			if cfg.Channel == "" {
+				errs = append(errs, FieldError{"channel", "is required"})
+			}
+			errs = append(errs, checkTemplate("text", cfg.Text)...)
+			for i, field := range cfg.MessageFields {
+				errs = append(errs, checkTemplate(fmt.Sprintf("messageFields[%d].value", i), field.Value)...)
+			}
+			return errs
+		}
+	case NotificationTypePagerDuty:
+		var cfg PagerDutyConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if cfg.RoutingKey == "" {
+				errs = append(errs, FieldError{"routingKey", "is required"})
+			}
+			switch cfg.Severity {
+			case "", "critical", "error", "warning", "info":
+			default:
+				errs = append(errs, FieldError{"severity", "must be critical, error, warning or info"})
+			}
+			if cfg.URL != "" {
+				errs = append(errs, checkURL("url", cfg.URL)...)
+			}
+			return errs
+		}
+	case NotificationTypeOpsgenie:
+		var cfg OpsgenieConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if cfg.APIKey == "" {
+				errs = append(errs, FieldError{"apiKey", "is required"})
+			}
+			switch cfg.Priority {
+			case "", "P1", "P2", "P3", "P4", "P5":
+			default:
+				errs = append(errs, FieldError{"priority", "must be P1, P2, P3, P4 or P5"})
+			}
+			for i, responder := range cfg.Responders {
+				field := fmt.Sprintf("responders[%d]", i)
+				switch responder.Type {
+				case "team", "escalation", "schedule":
+					if responder.ID == "" && responder.Name == "" {
+						errs = append(errs, FieldError{field, "needs an id or a name"})
+					}
+				case "user":
+					if responder.ID == "" && responder.Username == "" {
+						errs = append(errs, FieldError{field, "needs an id or a username"})
+					}
+				default:
+					errs = append(errs, FieldError{field + ".type", "must be team, user, escalation or schedule"})
+				}
+			}
+			if cfg.URL != "" {
+				errs = append(errs, checkURL("url", cfg.URL)...)
+			}
+			return errs
+		}
+	case NotificationTypeEmail:
+		var cfg EmailConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if cfg.Host == "" {
+				errs = append(errs, FieldError{"host", "is required"})
+			}
+			if cfg.Port < 0 || cfg.Port > 65535 {
+				errs = append(errs, FieldError{"port", "must be between 1 and 65535"})
+			}
+			switch cfg.TLS {
+			case "", "starttls", "tls", "none":
+			default:
+				errs = append(errs, FieldError{"tls", "must be starttls, tls or none"})
+			}
+			if _, err := mail.ParseAddress(cfg.From); err != nil {
+				errs = append(errs, FieldError{"from", "must be a mail address"})
+			}
+			if len(cfg.To) == 0 {
+				errs = append(errs, FieldError{"to", "needs at least one recipient"})
+			}
+			for i, to := range cfg.To {
+				if _, err := mail.ParseAddress(to); err != nil {
+					errs = append(errs, FieldError{fmt.Sprintf("to[%d]", i), "must be a mail address"})
+				}
+			}
+			errs = append(errs, checkTemplate("subject", cfg.Subject)...)
+			return append(errs, checkTemplate("body", cfg.Body)...)
+		}
+	case NotificationTypeWebPush:
+		var cfg WebPushNotificationConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			for i, user := range cfg.Users {
+				if user == "" {
+					errs = append(errs, FieldError{fmt.Sprintf("users[%d]", i), "must not be empty"})
+				}
+			}
+			if cfg.TTL < 0 {
+				errs = append(errs, FieldError{"ttl", "must not be negative"})
+			}
+			return errs
+		}
+	case NotificationTypeDiscord:
+		var cfg DiscordConfig
+		typed, checks = &cfg, func() FieldErrors {
+			errs := checkURL("url", cfg.URL)
+			for i, role := range cfg.Roles {
+				if _, err := strconv.ParseUint(role, 10, 64); err != nil {
+					errs = append(errs, FieldError{fmt.Sprintf("roles[%d]", i), "must be the ID of a role"})
+				}
+			}
+			return errs
+		}
+	case NotificationTypeTwilio:
+		var cfg TwilioConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if !twilioSID.MatchString(cfg.AccountSID) || !strings.HasPrefix(cfg.AccountSID, "AC") {
+				errs = append(errs, FieldError{"accountSid", "must be the SID of an account like AC..."})
+			}
+			if cfg.AuthToken == "" {
+				errs = append(errs, FieldError{"authToken", "is required"})
+			}
+			if !phoneNumber.MatchString(cfg.From) && !(twilioSID.MatchString(cfg.From) && strings.HasPrefix(cfg.From, "MG")) {
+				errs = append(errs, FieldError{"from", "must be a phone number like +15005550006 or the SID of a messaging service"})
+			}
+			if len(cfg.To) == 0 {
+				errs = append(errs, FieldError{"to", "needs at least one phone number"})
+			}
+			for i, to := range cfg.To {
+				if !phoneNumber.MatchString(to) {
+					errs = append(errs, FieldError{fmt.Sprintf("to[%d]", i), "must be a phone number like +15005550006"})
+				}
+			}
+			if cfg.URL != "" {
+				errs = append(errs, checkURL("url", cfg.URL)...)
+			}
+			return append(errs, checkTemplate("body", cfg.Body)...)
+		}
+	case NotificationTypeMQTT:
+		var cfg MQTTConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			u, err := url.Parse(cfg.Broker)
+			switch {
+			case cfg.Broker == "":
+				errs = append(errs, FieldError{"broker", "is required"})
+			case err != nil || u.Hostname() == "":
+				errs = append(errs, FieldError{"broker", "must be a URL like mqtt://host:1883"})
+			case u.Scheme != "mqtt" && u.Scheme != "mqtts" && u.Scheme != "tcp" && u.Scheme != "ssl" && u.Scheme != "tls":
+				errs = append(errs, FieldError{"broker", "must be an mqtt or mqtts URL"})
+			}
+			if cfg.Topic == "" {
+				errs = append(errs, FieldError{"topic", "is required"})
+			} else if strings.ContainsAny(cfg.Topic, "+#") {
+				errs = append(errs, FieldError{"topic", "must not contain wildcards"})
+			}
+			if cfg.QoS != nil && *cfg.QoS != 0 && *cfg.QoS != 1 {
+				errs = append(errs, FieldError{"qos", "must be 0 or 1"})
+			}
+			if cfg.Password != "" && cfg.Username == "" {
+				errs = append(errs, FieldError{"password", "needs a username"})
+			}
+			return errs
+		}
+	case NotificationTypeKafka:
+		var cfg KafkaConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if len(cfg.Brokers) == 0 {
+				errs = append(errs, FieldError{"brokers", "needs at least one broker"})
+			}
+			for i, broker := range cfg.Brokers {
+				if _, port, err := net.SplitHostPort(broker); err != nil || port == "" {
+					errs = append(errs, FieldError{fmt.Sprintf("brokers[%d]", i), "must be an address like kafka:9092"})
+				}
+			}
+			if !kafkaTopic.MatchString(cfg.Topic) {
+				errs = append(errs, FieldError{"topic", "must be a topic name of letters, digits, '.', '_' and '-'"})
+			}
+			switch cfg.Serialization {
+			case "", "json":
+				if cfg.SchemaRegistry != "" {
+					errs = append(errs, FieldError{"schemaRegistry", "is only used for avro"})
+				}
+			case "avro":
+				if cfg.SchemaRegistry != "" {
+					errs = append(errs, checkURL("schemaRegistry", cfg.SchemaRegistry)...)
+				}
+			default:
+				errs = append(errs, FieldError{"serialization", "must be json or avro"})
+			}
+			if cfg.Password != "" && cfg.Username == "" {
+				errs = append(errs, FieldError{"password", "needs a username"})
+			}
+			return errs
+		}
+	case NotificationTypeNATS:
+		var cfg NATSConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			u, err := url.Parse(cfg.URL)
+			switch {
+			case cfg.URL == "":
+				errs = append(errs, FieldError{"url", "is required"})
+			case err != nil || u.Hostname() == "":
+				errs = append(errs, FieldError{"url", "must be a URL like nats://host:4222"})
+			case u.Scheme != "nats" && u.Scheme != "tls":
+				errs = append(errs, FieldError{"url", "must be a nats or tls URL"})
+			}
+			switch {
+			case cfg.Subject == "":
+				errs = append(errs, FieldError{"subject", "is required"})
+			case strings.ContainsAny(cfg.Subject, "*> \t\r\n"):
+				errs = append(errs, FieldError{"subject", "must not contain wildcards or whitespace"})
+			case strings.HasPrefix(cfg.Subject, ".") || strings.HasSuffix(cfg.Subject, ".") || strings.Contains(cfg.Subject, ".."):
+				errs = append(errs, FieldError{"subject", "must not contain empty tokens"})
+			}
+			return errs
+		}
+	case NotificationTypeZabbix:
+		var cfg ZabbixConfig
+		typed, checks = &cfg, func() FieldErrors {
+			errs := checkAddress("server", cfg.Server, "zabbix:10051")
+			if cfg.Host == "" {
+				errs = append(errs, FieldError{"host", "is required"})
+			}
+			return errs
+		}
+	case NotificationTypeNSCA:
+		var cfg NSCAConfig
+		typed, checks = &cfg, func() FieldErrors {
+			errs := checkAddress("server", cfg.Server, "nagios:5667")
+			if cfg.Host == "" {
+				errs = append(errs, FieldError{"host", "is required"})
+			}
+			switch cfg.Encryption {
+			case "", "none":
+				if cfg.Password != "" {
+					errs = append(errs, FieldError{"password", "is only used for xor"})
+				}
+			case "xor":
+			default:
+				errs = append(errs, FieldError{"encryption", "must be none or xor"})
+			}
+			return errs
+		}
+	case NotificationTypeEventBridge:
+		var cfg EventBridgeConfig
+		typed, checks = &cfg, func() FieldErrors {
+			var errs FieldErrors
+			if !awsRegion.MatchString(cfg.Region) {
+				errs = append(errs, FieldError{"region", "must be a region like eu-central-1"})
+			}
+			if (cfg.AccessKeyID == "") != (cfg.SecretAccessKey == "") {
+				errs = append(errs, FieldError{"secretAccessKey", "must be set together with the accessKeyId"})
+			}
+			if cfg.Endpoint != "" {
+				errs = append(errs, checkURL("endpoint", cfg.Endpoint)...)
+			}
+			return errs
+		}
+	case NotificationTypePubSub:
+		var cfg PubSubConfig
+		typed, checks = &cfg, func() FieldErrors {
+			if !pubSubTopic.MatchString(cfg.Topic) {
+				return FieldErrors{{"topic", "must be a topic name like projects/<project>/topics/<topic>"}}
+			}
+			return nil
+		}
+	case NotificationTypeCallback:
+		var cfg CallbackConfig
+		typed, checks = &cfg, func() FieldErrors {
+			return checkURL("url", cfg.URL)
+		}
+	case "":
+		return n, FieldErrors{{"type", "is required"}}
+	default:
+		return n, nil
+	}
+	err := decodeStrict(n.Config, typed)
+	if err != nil {
+		return n, FieldErrors{decodeFieldError(err)}.Prefixed("config")
+	}
+	if errs := checks(); len(errs) > 0 {
+		return n, errs.Prefixed("config")
+	}
+	// the canonical form is the typed config as a map, like the notifier gets it from the storage
+	bs, err := json.Marshal(typed)
+	if err != nil {
+		return n, FieldErrors{{"config", err.Error()}}
+	}
+	var canonical map[string]interface{}
+	err = json.Unmarshal(bs, &canonical)
+	if err != nil {
+		return n, FieldErrors{{"config", err.Error()}}
+	}
+	n.Config = canonical
+	return n, nil
+}
+
+// decodeStrict decodes a loosely typed config into target and fails on unknown fields
+func decodeStrict(input interface{}, target interface{}) error {
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+func decodeFieldError(err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return FieldError{typeErr.Field, fmt.Sprintf("must be %s, not %s", typeErr.Type, typeErr.Value)}
+	}
+	// the decoder reports unknown fields as `json: unknown field "name"`
+	if name := strings.TrimPrefix(err.Error(), "json: unknown field "); name != err.Error() {
+		return FieldError{strings.Trim(name, `"`), "is unknown"}
+	}
+	return FieldError{"", err.Error()}
+}
+
+// checkAddress checks an address like host:port, the port is optional
+func checkAddress(field, value, example string) FieldErrors {
+	if value == "" {
+		return FieldErrors{{field, "is required"}}
+	}
+	host := value
+	if h, port, err := net.SplitHostPort(value); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return FieldErrors{{field, "has an invalid port"}}
+		}
+		host = h
+	}
+	if host == "" || strings.ContainsAny(host, "/: ") {
+		return FieldErrors{{field, "must be an address like " + example}}
+	}
+	return nil
+}
+
+func checkURL(field, value string) FieldErrors {
+	if value == "" {
+		return FieldErrors{{field, "is required"}}
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return FieldErrors{{field, err.Error()}}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return FieldErrors{{field, "must be an absolute http or https URL"}}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// OneShotConfig makes a service expect a single heartbeat before a deadline, e.g. to confirm that a deploy
+// finished in time. The service is archived once it got the heartbeat or alarmed.
+type OneShotConfig struct {
+	// Deadline of the heartbeat, the server sets it from TTL if it is empty
+	Deadline time.Time `json:"deadline"`
+	// TTL is the time from the creation until the deadline
+	TTL Duration `json:"ttl,omitempty"`
+	// CreatedAt is set by the server, only heartbeats after it complete the service
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (o OneShotConfig) Validate() error {
+	if o.Deadline.IsZero() && o.TTL <= 0 {
+		return errors.New("a one-shot service needs a deadline or a ttl")
+	}
+	return nil
+}
+
+// Resolve sets the creation time and the deadline of a new one-shot service
+func (o OneShotConfig) Resolve(now time.Time) OneShotConfig {
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = now.UTC()
+	}
+	if o.Deadline.IsZero() {
+		o.Deadline = o.CreatedAt.Add(time.Duration(o.TTL))
+	}
+	return o
+}
@@ -0,0 +1,21 @@
+package config
+
+import "errors"
+
+// PingAuthConfig requires credentials for the pings of a service, in addition to its token.
+// Clients send them with basic auth or as bearer token, whatever they support.
+type PingAuthConfig struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	BearerToken string `json:"bearerToken"`
+}
+
+func (c PingAuthConfig) Validate() error {
+	if c.Username == "" && c.Password == "" && c.BearerToken == "" {
+		return errors.New("ping auth needs a username and password or a bearer token")
+	}
+	if (c.Username == "") != (c.Password == "") {
+		return errors.New("ping auth needs both a username and a password")
+	}
+	return nil
+}
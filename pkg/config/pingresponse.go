@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// PingResponseConfig customizes the response to a successful ping
+type PingResponseConfig struct {
+	// Format is "text" (default) or "json"
+	Format      string `json:"format"`
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+	// Body is a go template which overrides the format, it gets the ID, Now and NextDeadline of the service
+	Body string `json:"body"`
+}
+
+// Validate checks the format, the status code and the body template
+func (c PingResponseConfig) Validate() error {
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown ping response format %q", c.Format)
+	}
+	if c.StatusCode != 0 && (c.StatusCode < 200 || c.StatusCode > 299) {
+		return fmt.Errorf("ping response status code %d is not a success status", c.StatusCode)
+	}
+	if c.Body != "" {
+		_, err := template.New("ping").Parse(c.Body)
+		if err != nil {
+			return fmt.Errorf("invalid ping response body: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// PolicyConfig limits what the services below Prefix may configure through the API.
+// All policies with a matching prefix apply, so a team policy can only tighten a global one.
+type PolicyConfig struct {
+	Prefix string `json:"prefix"`
+	// MinTimeout and MaxTimeout bound the timeout, zero means no bound
+	MinTimeout Duration `json:"minTimeout"`
+	MaxTimeout Duration `json:"maxTimeout"`
+	// MinDebounce and MaxDebounce bound the debounce, zero means no bound
+	MinDebounce Duration `json:"minDebounce"`
+	MaxDebounce Duration `json:"maxDebounce"`
+	// RequireRecoveryNotifications rejects services without recovery notifications or contacts
+	RequireRecoveryNotifications bool `json:"requireRecoveryNotifications"`
+	// ForbiddenNotificationTypes may not be used in any notification of the service
+	ForbiddenNotificationTypes []NotificationType `json:"forbiddenNotificationTypes"`
+}
+
+// CheckPolicies checks the service against all policies which apply to it, svc should have its defaults applied
+func CheckPolicies(policies []PolicyConfig, svc ServiceConfig) error {
+	for _, policy := range policies {
+		if !HasServiceIDPrefix(svc.ID, policy.Prefix) {
+			continue
+		}
+		err := policy.Check(svc)
+		if err != nil {
+			return fmt.Errorf("policy of %q: %w", policy.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// Check checks the service against the policy, regardless of its prefix
+func (p PolicyConfig) Check(svc ServiceConfig) error {
+	err := checkBounds("timeout", svc.Timeout, p.MinTimeout, p.MaxTimeout)
+	if err != nil {
+		return err
+	}
+	err = checkBounds("debounce", svc.Debounce, p.MinDebounce, p.MaxDebounce)
+	if err != nil {
+		return err
+	}
+	// contacts are notified about the recovery as well
+	if p.RequireRecoveryNotifications && len(svc.RecoveryNotifications) == 0 && len(svc.Contacts) == 0 {
+		return fmt.Errorf("recovery notifications or contacts are required")
+	}
+	for _, typ := range p.ForbiddenNotificationTypes {
+		for _, notification := range svc.notifications() {
+			if notification.Type == typ {
+				return fmt.Errorf("notification type %q is forbidden", typ)
+			}
+		}
+	}
+	return nil
+}
+
+func checkBounds(name string, value, min, max Duration) error {
+	if min > 0 && value < min {
+		return fmt.Errorf("%s %s is below the minimum of %s", name, time.Duration(value), time.Duration(min))
+	}
+	if max > 0 && value > max {
+		return fmt.Errorf("%s %s is above the maximum of %s", name, time.Duration(value), time.Duration(max))
+	}
+	return nil
+}
+
+// notifications returns all notifications the service configures itself
+func (svc ServiceConfig) notifications() []NotificationConfig {
+	notifications := append(append([]NotificationConfig{}, svc.AlertNotifications...), svc.RecoveryNotifications...)
+	for _, tier := range svc.Escalation {
+		notifications = append(append(notifications, tier.AlertNotifications...), tier.RecoveryNotifications...)
+	}
+	if svc.EarlyWarning != nil {
+		notifications = append(notifications, svc.EarlyWarning.Notifications...)
+	}
+	if svc.Countdown != nil {
+		notifications = append(notifications, svc.Countdown.Notifications...)
+	}
+	if svc.Callback != nil {
+		notifications = append(notifications, NotificationConfig{Type: NotificationTypeCallback})
+	}
+	return notifications
+}
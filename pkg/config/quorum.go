@@ -0,0 +1,46 @@
+package config
+
+import "fmt"
+
+// QuorumConfig alarms when fewer than Min of the selected services pinged within Window,
+// like redundant job runners of which a few may fail without harm.
+// The alarm of the quorum is handled like the alarm of a service with the ID of the quorum.
+type QuorumConfig struct {
+	ID       string            `json:"id"`
+	Services Selector          `json:"services"`
+	Min      int               `json:"min"`
+	Window   Duration          `json:"window"`
+	Debounce Duration          `json:"debounce"`
+	Labels   map[string]string `json:"labels"`
+	// Contacts are the IDs of the contacts which are notified on their preferred channels
+	Contacts              []string             `json:"contacts"`
+	AlertNotifications    []NotificationConfig `json:"alertNotifications"`
+	RecoveryNotifications []NotificationConfig `json:"recoveryNotifications"`
+}
+
+func (q QuorumConfig) Validate() error {
+	err := ValidateServiceID(q.ID)
+	if err != nil {
+		return fmt.Errorf("invalid quorum: %v", err)
+	}
+	if q.Min < 1 {
+		return fmt.Errorf("quorum %s needs a min of at least 1", q.ID)
+	}
+	if q.Window <= 0 {
+		return fmt.Errorf("quorum %s needs a window", q.ID)
+	}
+	return nil
+}
+
+// Service returns the service which represents the quorum to the notifier
+func (q QuorumConfig) Service() ServiceConfig {
+	return ServiceConfig{
+		ID:                    q.ID,
+		Timeout:               q.Window,
+		Debounce:              q.Debounce,
+		Labels:                q.Labels,
+		Contacts:              q.Contacts,
+		AlertNotifications:    q.AlertNotifications,
+		RecoveryNotifications: q.RecoveryNotifications,
+	}
+}
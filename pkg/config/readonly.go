@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ReadOnlyConfig runs an instance which serves the read APIs and accepts pings, but never checks the deadlines
+// or sends notifications, to take query load off the primary deployment, e.g. in another region.
+type ReadOnlyConfig struct {
+	// Primary is the URL of the deployment the pings are forwarded to like https://deadman-switch.example.com.
+	// Without it the pings are recorded in the storage, which must be shared with the primary, and their
+	// notifications are queued for the primary.
+	Primary string `json:"primary"`
+}
+
+// Validate checks the config for the storage of the instance
+func (c ReadOnlyConfig) Validate(storage StorageType) error {
+	if c.Primary == "" {
+		if storage != StorageTypeEtcd {
+			return errors.New("a read-only replica without primary needs the etcd storage shared with the primary")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.Primary)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("the primary needs an absolute http or https url, got %q", c.Primary)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import "fmt"
+
+// ReplicasConfig makes a service expect heartbeats of several replicas, which ping /ping/<service>/<replica>.
+// The service only counts as alive while at least Min replicas pinged within its timeout.
+type ReplicasConfig struct {
+	// Expected is the number of replicas which normally ping
+	Expected int `json:"expected"`
+	// Min is the number of replicas which must be alive, it defaults to Expected
+	Min int `json:"min"`
+}
+
+// Minimum returns the number of replicas which must be alive
+func (c ReplicasConfig) Minimum() int {
+	if c.Min > 0 {
+		return c.Min
+	}
+	return c.Expected
+}
+
+func (c ReplicasConfig) Validate() error {
+	if c.Expected < 1 {
+		return fmt.Errorf("expected replicas must be at least 1, got %d", c.Expected)
+	}
+	if c.Min < 0 || c.Min > c.Expected {
+		return fmt.Errorf("min replicas must be between 1 and the %d expected replicas, got %d", c.Expected, c.Min)
+	}
+	return nil
+}
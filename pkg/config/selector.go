@@ -0,0 +1,45 @@
+package config
+
+// Selector selects services by ID pattern and labels.
+// An empty selector matches all services.
+type Selector struct {
+	// Match is a service ID pattern as understood by MatchServiceID
+	Match string `json:"match"`
+	// Labels must all be present on the service with the given values
+	Labels map[string]string `json:"labels"`
+}
+
+// Matches reports whether the service is selected
+func (s Selector) Matches(svc ServiceConfig) bool {
+	if !MatchServiceID(s.Match, svc.ID) {
+		return false
+	}
+	for key, value := range s.Labels {
+		if svc.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// InhibitRule suppresses the alerts of all Target services while a Source service is alarming.
+// This works like the inhibition rules of the prometheus alertmanager.
+type InhibitRule struct {
+	Source Selector `json:"source"`
+	Target Selector `json:"target"`
+	// Equal lists labels which must have the same value on the source and the target
+	Equal []string `json:"equal"`
+}
+
+// Inhibits reports whether the alarming source service suppresses the alerts of target
+func (r InhibitRule) Inhibits(source, target ServiceConfig) bool {
+	if source.ID == target.ID || !r.Source.Matches(source) || !r.Target.Matches(target) {
+		return false
+	}
+	for _, label := range r.Equal {
+		if source.Labels[label] != target.Labels[label] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// SilenceConfig is a silence of the config file, like a weekly maintenance window. It is stored with the
+// ID config-<name>, so it can be listed and expired through the API like the others.
+type SilenceConfig struct {
+	Name     string   `json:"name"`
+	Services Selector `json:"services"`
+	// StartsAt and EndsAt limit the silence, a recurring silence without EndsAt recurs until it is removed
+	StartsAt   time.Time          `json:"startsAt"`
+	EndsAt     time.Time          `json:"endsAt"`
+	Recurrence *SilenceRecurrence `json:"recurrence"`
+	Comment    string             `json:"comment"`
+}
+
+func (s SilenceConfig) Validate() error {
+	if s.Name == "" {
+		return errors.New("silences need a name")
+	}
+	if s.Recurrence == nil {
+		if !s.EndsAt.After(s.StartsAt) {
+			return fmt.Errorf("silence %q needs an end after its start or a recurrence", s.Name)
+		}
+		return nil
+	}
+	if !s.EndsAt.IsZero() && !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("silence %q needs an end after its start", s.Name)
+	}
+	err := s.Recurrence.Validate()
+	if err != nil {
+		return fmt.Errorf("silence %q: %w", s.Name, err)
+	}
+	return nil
+}
+
+// SilenceRecurrence makes a silence active for Duration from every activation of the cron schedule,
+// like "0 2 * * 6" and 4h for a maintenance window every Saturday from 2 to 6 o'clock
+type SilenceRecurrence struct {
+	Schedule string   `json:"schedule"`
+	Duration Duration `json:"duration"`
+	// Timezone of the schedule like Europe/Berlin, defaults to UTC
+	Timezone string `json:"timezone"`
+}
+
+func (r SilenceRecurrence) Validate() error {
+	_, err := r.schedule()
+	if err != nil {
+		return fmt.Errorf("invalid recurrence schedule: %w", err)
+	}
+	if r.Duration <= 0 {
+		return errors.New("the recurrence needs a duration")
+	}
+	return nil
+}
+
+// Window returns the start of the window t is within, if any
+func (r SilenceRecurrence) Window(t time.Time) (time.Time, bool) {
+	schedule, err := r.schedule()
+	if err != nil {
+		return time.Time{}, false
+	}
+	// the first activation after the start of a window which would contain t
+	start := schedule.Next(t.Add(-time.Duration(r.Duration)))
+	return start, !start.After(t)
+}
+
+func (r SilenceRecurrence) schedule() (cron.Schedule, error) {
+	spec := r.Schedule
+	if r.Timezone != "" {
+		spec = "CRON_TZ=" + r.Timezone + " " + spec
+	}
+	return cron.ParseStandard(spec)
+}
@@ -0,0 +1,14 @@
+package config
+
+// SlackAppConfig enables the installation of a Slack app into workspaces through OAuth, so Slack notifications
+// can refer to a workspace instead of carrying a token
+type SlackAppConfig struct {
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	// URL is the external base URL of the server, the redirect URL of the app is <url>/slack/oauth
+	URL string `json:"url"`
+	// Scopes of the bot token, defaults to chat:write, chat:write.public, channels:read, groups:read and channels:history
+	Scopes []string `json:"scopes"`
+	// SigningSecret enables the /deadman slash command at <url>/slack/commands, Slack signs its requests with it
+	SigningSecret string `json:"signingSecret"`
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"encoding/json"
+	"text/template"
+)
+
+// templateFuncs are available in the templates of notification payloads, json quotes a value for JSON bodies
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	},
+}
+
+// ParseTemplate parses a template of a notification payload
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// checkTemplate reports a field which is no valid template
+func checkTemplate(field, text string) FieldErrors {
+	if _, err := ParseTemplate(field, text); err != nil {
+		return FieldErrors{{field, err.Error()}}
+	}
+	return nil
+}
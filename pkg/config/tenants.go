@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenantConfig is a tenant of a shared deployment, it owns the services below its prefix
+type TenantConfig struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// MaxServices limits the number of services which can be created through the API, zero means no limit
+	MaxServices int `json:"maxServices"`
+	// MaxPingsPerMinute limits the pings of all services of the tenant, zero means no limit
+	MaxPingsPerMinute int `json:"maxPingsPerMinute"`
+}
+
+// ValidateTenants checks the tenants, their names must be unique
+func ValidateTenants(tenants []TenantConfig) error {
+	names := make(map[string]bool)
+	for _, tenant := range tenants {
+		if tenant.Name == "" || strings.Contains(tenant.Name, "/") {
+			return fmt.Errorf("tenant name %q must not be empty or contain a slash", tenant.Name)
+		}
+		if names[tenant.Name] {
+			return fmt.Errorf("tenant %s is configured twice", tenant.Name)
+		}
+		names[tenant.Name] = true
+		if strings.Trim(tenant.Prefix, "/") == "" {
+			return fmt.Errorf("tenant %s needs a prefix", tenant.Name)
+		}
+		if tenant.MaxServices < 0 || tenant.MaxPingsPerMinute < 0 {
+			return fmt.Errorf("the quotas of tenant %s must not be negative", tenant.Name)
+		}
+	}
+	return nil
+}
+
+// TenantOf returns the tenant which owns the service, the one with the longest prefix if several match
+func TenantOf(tenants []TenantConfig, id string) (TenantConfig, bool) {
+	var owner TenantConfig
+	found := false
+	for _, tenant := range tenants {
+		if !HasServiceIDPrefix(id, tenant.Prefix) {
+			continue
+		}
+		if !found || len(strings.TrimSuffix(tenant.Prefix, "/")) > len(strings.TrimSuffix(owner.Prefix, "/")) {
+			owner, found = tenant, true
+		}
+	}
+	return owner, found
+}
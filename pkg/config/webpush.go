@@ -0,0 +1,25 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// WebPushConfig enables the app of the server, browsers which install it can subscribe to push notifications
+type WebPushConfig struct {
+	// PublicKey and PrivateKey are the VAPID key pair, `deadman-switch vapid-keys` generates one
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+	// Subject is a mailto: or https: URL push services can use to contact the operator
+	Subject string `json:"subject"`
+}
+
+func (cfg WebPushConfig) Validate() error {
+	if cfg.PublicKey == "" || cfg.PrivateKey == "" {
+		return errors.New("web push needs the public and the private key")
+	}
+	if !strings.HasPrefix(cfg.Subject, "mailto:") && !strings.HasPrefix(cfg.Subject, "https://") {
+		return errors.New("the web push subject must be a mailto: or https: URL")
+	}
+	return nil
+}
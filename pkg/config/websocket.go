@@ -0,0 +1,10 @@
+package config
+
+// WebSocketConfig configures how the server treats the heartbeat connections of a service
+type WebSocketConfig struct {
+	// AlarmOnDisconnect raises the alarm when the last connection of the service drops without a close frame,
+	// instead of waiting for the timeout
+	AlarmOnDisconnect bool `json:"alarmOnDisconnect"`
+	// Grace is the time the service has to reconnect or send a heartbeat before the alarm is raised
+	Grace Duration `json:"grace"`
+}
@@ -0,0 +1,174 @@
+// Package debug dumps the in-memory state of the components of this instance, like the leadership, the queue
+// consumer and the last checker cycle. It answers why alerts didn't fire, see the /debug endpoints and SIGUSR1.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// sourceTimeout limits the time a source may take, so a hanging backend doesn't block the dump
+const sourceTimeout = 10 * time.Second
+
+// Source returns the state of a component, it must be encodable as JSON
+type Source func(ctx context.Context) (interface{}, error)
+
+// Dumper collects the state of the registered sources
+type Dumper struct {
+	mutex   sync.RWMutex
+	sources map[string]Source
+	started time.Time
+}
+
+// NewDumper returns a dumper which reports the process itself as the section "process"
+func NewDumper(version, commit string) *Dumper {
+	d := &Dumper{
+		sources: make(map[string]Source),
+		started: time.Now(),
+	}
+	d.Register("process", func(ctx context.Context) (interface{}, error) {
+		hostname, _ := os.Hostname()
+		return map[string]interface{}{
+			"version":    version,
+			"commit":     commit,
+			"hostname":   hostname,
+			"pid":        os.Getpid(),
+			"startedAt":  d.started,
+			"uptime":     config.Duration(time.Since(d.started).Round(time.Second)),
+			"goroutines": runtime.NumGoroutine(),
+		}, nil
+	})
+	return d
+}
+
+// Register adds a section to the dumps, a section registered twice is replaced
+func (d *Dumper) Register(name string, source Source) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.sources[name] = source
+}
+
+// Sections returns the names of the registered sections
+func (d *Dumper) Sections() []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	names := make([]string, 0, len(d.sources))
+	for name := range d.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Section returns the state of one section, ok is false for unknown sections
+func (d *Dumper) Section(ctx context.Context, name string) (state interface{}, ok bool, err error) {
+	d.mutex.RLock()
+	source, ok := d.sources[name]
+	d.mutex.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, sourceTimeout)
+	defer cancel()
+	state, err = source(ctx)
+	return state, true, err
+}
+
+// Dump returns the state of all sections. A section which fails is reported by its error, so one broken
+// component doesn't hide the state of the others.
+func (d *Dumper) Dump(ctx context.Context) map[string]interface{} {
+	names := d.Sections()
+	dump := make(map[string]interface{}, len(names)+1)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			state, _, err := d.Section(ctx, name)
+			if err != nil {
+				state = map[string]string{"error": err.Error()}
+			}
+			mutex.Lock()
+			dump[name] = state
+			mutex.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	dump["dumpedAt"] = time.Now()
+	return dump
+}
+
+// HandleSignal logs a dump on every SIGUSR1 until the context is done
+func (d *Dumper) HandleSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			bs, err := json.Marshal(d.Dump(ctx))
+			if err != nil {
+				log.Error().Err(err).Msg("failed to encode state dump")
+				continue
+			}
+			log.Info().RawJSON("state", bs).Msg("state dump")
+		}
+	}
+}
+
+// ServiceState is the stored state of a service
+type ServiceState struct {
+	ID               string          `json:"id"`
+	Timeout          config.Duration `json:"timeout"`
+	LastHeartbeat    *time.Time      `json:"lastHeartbeat,omitempty"`
+	AlarmActiveSince *time.Time      `json:"alarmActiveSince,omitempty"`
+}
+
+// Services returns a source listing the services the checker loads from the storage, with their last
+// heartbeat and active alarm
+func Services(store storage.Storage) Source {
+	return func(ctx context.Context) (interface{}, error) {
+		heartbeats, err := store.GetLastHeartbeats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		alarms, err := store.GetActiveAlarms(ctx)
+		if err != nil {
+			return nil, err
+		}
+		services := []ServiceState{}
+		configs, errs := store.GetServiceConfigs(ctx)
+		for svc := range configs {
+			state := ServiceState{ID: svc.ID, Timeout: svc.Timeout}
+			if t, ok := heartbeats[svc.ID]; ok {
+				state.LastHeartbeat = &t
+			}
+			if t, ok := alarms[svc.ID]; ok {
+				state.AlarmActiveSince = &t
+			}
+			services = append(services, state)
+		}
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+		sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+		return map[string]interface{}{
+			"count":    len(services),
+			"services": services,
+		}, nil
+	}
+}
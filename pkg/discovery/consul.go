@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	// metaTimeout in the service metadata overrides the configured timeout
+	metaTimeout = "deadman-switch-timeout"
+
+	defaultConsulTag     = "deadman-switch"
+	defaultConsulPrefix  = "consul"
+	defaultConsulTimeout = 5 * time.Minute
+)
+
+// NewConsulSource creates a source which turns every consul service with the configured tag into a service.
+// The service ID is `<prefix>/<name>`.
+func NewConsulSource(cfg config.ConsulDiscoveryConfig) (Source, error) {
+	if cfg.Address == "" {
+		cfg.Address = "http://localhost:8500"
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = defaultConsulTag
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultConsulPrefix
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = config.Duration(defaultConsulTimeout)
+	}
+	return &consulSource{
+		cfg: cfg,
+		cli: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type consulSource struct {
+	cfg config.ConsulDiscoveryConfig
+	cli *http.Client
+}
+
+// consulCatalogService is the subset of a consul catalog entry we need
+type consulCatalogService struct {
+	ServiceName string
+	ServiceTags []string
+	ServiceMeta map[string]string
+}
+
+func (s *consulSource) Name() string {
+	return "consul"
+}
+
+func (s *consulSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *consulSource) Prune() bool {
+	return s.cfg.Prune
+}
+
+func (s *consulSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	headers := map[string]string{"X-Consul-Token": s.cfg.Token}
+	base := strings.TrimSuffix(s.cfg.Address, "/")
+	query := url.Values{}
+	if s.cfg.Datacenter != "" {
+		query.Set("dc", s.cfg.Datacenter)
+	}
+
+	var catalog map[string][]string
+	err := getJSON(ctx, s.cli, base+"/v1/catalog/services?"+query.Encode(), headers, &catalog)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(catalog))
+	for name, tags := range catalog {
+		if contains(tags, s.cfg.Tag) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	services := make([]config.ServiceConfig, 0, len(names))
+	for _, name := range names {
+		query := url.Values{"tag": []string{s.cfg.Tag}}
+		if s.cfg.Datacenter != "" {
+			query.Set("dc", s.cfg.Datacenter)
+		}
+		var instances []consulCatalogService
+		err := getJSON(ctx, s.cli, base+"/v1/catalog/service/"+url.PathEscape(name)+"?"+query.Encode(), headers, &instances)
+		if err != nil {
+			return nil, err
+		}
+		if len(instances) == 0 {
+			continue
+		}
+		svc, err := s.service(instances[0])
+		if err != nil {
+			log.Warn().Str("consul-service", name).Err(err).Msg("skip consul service")
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+func (s *consulSource) service(instance consulCatalogService) (config.ServiceConfig, error) {
+	svc := config.ServiceConfig{
+		ID:      path.Join(s.cfg.Prefix, instance.ServiceName),
+		Timeout: s.cfg.Timeout,
+	}
+	if value, ok := instance.ServiceMeta[metaTimeout]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return svc, err
+		}
+		svc.Timeout = config.Duration(timeout)
+	}
+	data := templateData{
+		ID:   svc.ID,
+		Name: instance.ServiceName,
+		Tags: instance.ServiceTags,
+		Meta: instance.ServiceMeta,
+	}
+	var err error
+	svc.AlertNotifications, err = renderNotifications(s.cfg.AlertNotifications, data)
+	if err != nil {
+		return svc, err
+	}
+	svc.RecoveryNotifications, err = renderNotifications(s.cfg.RecoveryNotifications, data)
+	if err != nil {
+		return svc, err
+	}
+	return svc, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
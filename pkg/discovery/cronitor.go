@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const defaultCronitorURL = "https://cronitor.io"
+
+// cronitorInterval matches schedules like "every 5 minutes"
+var cronitorInterval = regexp.MustCompile(`^every (\d+) (second|minute|hour|day|week)s?$`)
+
+var cronitorUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// NewCronitorSource creates a source which turns the job and heartbeat monitors of Cronitor into services.
+// The service ID is the monitor key, so the telemetry URLs only need a new host.
+func NewCronitorSource(cfg config.ImportConfig) Source {
+	if cfg.URL == "" {
+		cfg.URL = defaultCronitorURL
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultImportGrace)
+	}
+	return &cronitorSource{
+		cfg: cfg,
+		cli: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type cronitorSource struct {
+	cfg config.ImportConfig
+	cli *http.Client
+}
+
+// cronitorMonitor is the subset of a Cronitor monitor we need
+type cronitorMonitor struct {
+	Key          string   `json:"key"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Schedule     string   `json:"schedule"`
+	Timezone     string   `json:"timezone"`
+	GraceSeconds int64    `json:"grace_seconds"`
+	Tags         []string `json:"tags"`
+	Notify       []string `json:"notify"`
+}
+
+func (s *cronitorSource) Name() string {
+	return "cronitor"
+}
+
+func (s *cronitorSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *cronitorSource) Prune() bool {
+	return false
+}
+
+func (s *cronitorSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	// the API key is the user name of basic auth
+	headers := map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(s.cfg.APIKey+":")),
+	}
+	base := strings.TrimSuffix(s.cfg.URL, "/")
+
+	var monitors []cronitorMonitor
+	for page := 1; ; page++ {
+		var list struct {
+			Monitors          []cronitorMonitor `json:"monitors"`
+			TotalMonitorCount int               `json:"total_monitor_count"`
+		}
+		err := getJSON(ctx, s.cli, base+"/api/monitors?page="+strconv.Itoa(page), headers, &list)
+		if err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, list.Monitors...)
+		if len(list.Monitors) == 0 || len(monitors) >= list.TotalMonitorCount {
+			break
+		}
+	}
+
+	now := time.Now()
+	services := make([]config.ServiceConfig, 0, len(monitors))
+	for _, monitor := range monitors {
+		if monitor.Type != "job" && monitor.Type != "heartbeat" {
+			log.Info().Str("monitor", monitor.Key).Str("type", monitor.Type).Msg("skip cronitor monitor which is no job or heartbeat")
+			continue
+		}
+		svc, err := s.service(monitor, now)
+		if err != nil {
+			log.Warn().Str("monitor", monitor.Key).Err(err).Msg("skip cronitor monitor")
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+func (s *cronitorSource) service(monitor cronitorMonitor, now time.Time) (config.ServiceConfig, error) {
+	svc := config.ServiceConfig{
+		ID: path.Join(s.cfg.Prefix, monitor.Key),
+		Labels: map[string]string{
+			config.LabelCronitorName: monitor.Name,
+			config.LabelCronitorTags: strings.Join(monitor.Tags, " "),
+		},
+	}
+	dropEmptyLabels(svc.Labels)
+	if err := config.ValidateServiceID(svc.ID); err != nil {
+		return svc, err
+	}
+	grace := time.Duration(monitor.GraceSeconds) * time.Second
+	if grace == 0 {
+		grace = time.Duration(s.cfg.Grace)
+	}
+	schedule := strings.ToLower(strings.TrimSpace(monitor.Schedule))
+	switch match := cronitorInterval.FindStringSubmatch(schedule); {
+	case schedule == "":
+		return svc, fmt.Errorf("monitor has no schedule")
+	case match != nil:
+		n, _ := strconv.Atoi(match[1])
+		svc.Timeout = config.Duration(time.Duration(n)*cronitorUnits[match[2]] + grace)
+	default:
+		timeout, err := timeoutForSchedule(monitor.Schedule, monitor.Timezone, grace, now)
+		if err != nil {
+			return svc, err
+		}
+		svc.Timeout = config.Duration(timeout)
+	}
+	for _, key := range monitor.Notify {
+		notifications := mappedIntegration(s.cfg.Integrations, key)
+		if notifications == nil {
+			log.Warn().Str("monitor", monitor.Key).Str("integration", key).Msg("integration is not mapped")
+			continue
+		}
+		svc.AlertNotifications = append(svc.AlertNotifications, notifications...)
+		svc.RecoveryNotifications = append(svc.RecoveryNotifications, notifications...)
+	}
+	return svc, nil
+}
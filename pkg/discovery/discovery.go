@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// Source discovers services from an external system like kubernetes
+type Source interface {
+	// Name is used for logging
+	Name() string
+	// Prefix is the part of the ID hierarchy owned by this source
+	Prefix() string
+	// Prune reports whether services below Prefix which are not discovered anymore should be deleted
+	Prune() bool
+	// Discover returns the currently expected services
+	Discover(ctx context.Context) ([]config.ServiceConfig, error)
+}
+
+// Store is the subset of storage.Storage the syncer needs. It is also implemented by
+// the API client, so discovered services can be synced to a remote server.
+type Store interface {
+	GetServiceConfigs(ctx context.Context) (chan config.ServiceConfig, chan error)
+	GetServiceConfig(ctx context.Context, id string) (config.ServiceConfig, error)
+	SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error
+	DeleteServiceConfig(ctx context.Context, id string) error
+}
+
+// Syncer periodically reconciles the services of all sources into the storage
+type Syncer struct {
+	store       Store
+	concurrency concurrency.Client
+	sources     []Source
+	interval    time.Duration
+}
+
+func NewSyncer(store Store, concurrency concurrency.Client, interval time.Duration, sources ...Source) *Syncer {
+	return &Syncer{store, concurrency, sources, interval}
+}
+
+func (s *Syncer) Backend(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.syncIfLeader(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) syncIfLeader(ctx context.Context) {
+	if s.concurrency != nil {
+		isLeader, err := s.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
+		if err != nil {
+			if err != context.DeadlineExceeded {
+				log.Error().Err(err).Msg("failed to check leadership for service discovery")
+			}
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	for _, source := range s.sources {
+		err := s.Sync(ctx, source)
+		if err != nil {
+			log.Error().Str("source", source.Name()).Err(err).Msg("failed to sync discovered services")
+		}
+	}
+}
+
+// Sync creates or updates all services discovered by source and prunes vanished ones if configured
+func (s *Syncer) Sync(ctx context.Context, source Source) error {
+	discovered, err := source.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, svc := range discovered {
+		seen[svc.ID] = true
+		existing, err := s.store.GetServiceConfig(ctx, svc.ID)
+		switch {
+		case err == storage.ErrNotFound:
+			log.Info().Str("source", source.Name()).Str("service", svc.ID).Msg("discovered new service")
+		case err != nil:
+			return err
+		default:
+			svc = merge(existing, svc)
+			if equal(existing, svc) {
+				continue
+			}
+			log.Info().Str("source", source.Name()).Str("service", svc.ID).Msg("update discovered service")
+		}
+		err = s.store.SaveServiceConfig(ctx, svc)
+		if err != nil {
+			return err
+		}
+	}
+	if !source.Prune() {
+		return nil
+	}
+	var vanished []string
+	configs, errs := s.store.GetServiceConfigs(ctx)
+	for svc := range configs {
+		if config.HasServiceIDPrefix(svc.ID, source.Prefix()) && !seen[svc.ID] {
+			vanished = append(vanished, svc.ID)
+		}
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	for _, id := range vanished {
+		log.Info().Str("source", source.Name()).Str("service", id).Msg("prune vanished service")
+		err := s.store.DeleteServiceConfig(ctx, id)
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge keeps all manual changes of an existing service which the discovered one doesn't set
+func merge(existing, discovered config.ServiceConfig) config.ServiceConfig {
+	if discovered.Token == "" {
+		discovered.Token = existing.Token
+	}
+	if discovered.Timeout == 0 {
+		discovered.Timeout = existing.Timeout
+	}
+	if discovered.Debounce == 0 {
+		discovered.Debounce = existing.Debounce
+	}
+	if len(discovered.Labels) == 0 {
+		discovered.Labels = existing.Labels
+	}
+	if len(discovered.Contacts) == 0 {
+		discovered.Contacts = existing.Contacts
+	}
+	if discovered.EarlyWarning == nil {
+		discovered.EarlyWarning = existing.EarlyWarning
+	}
+	if discovered.Countdown == nil {
+		discovered.Countdown = existing.Countdown
+	}
+	if discovered.Hooks == nil {
+		discovered.Hooks = existing.Hooks
+	}
+	if discovered.PingResponse == nil {
+		discovered.PingResponse = existing.PingResponse
+	}
+	if discovered.Callback == nil {
+		discovered.Callback = existing.Callback
+	}
+	if discovered.EscalationPolicy == "" {
+		discovered.EscalationPolicy = existing.EscalationPolicy
+	}
+	if discovered.ActionPlan == "" {
+		discovered.ActionPlan = existing.ActionPlan
+	}
+	if len(discovered.AlertNotifications) == 0 {
+		discovered.AlertNotifications = existing.AlertNotifications
+	}
+	if len(discovered.RecoveryNotifications) == 0 {
+		discovered.RecoveryNotifications = existing.RecoveryNotifications
+	}
+	return discovered
+}
+
+func equal(a, b config.ServiceConfig) bool {
+	bsA, errA := json.Marshal(a)
+	bsB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(bsA, bsB)
+}
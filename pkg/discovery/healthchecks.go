@@ -0,0 +1,175 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultHealthchecksURL = "https://healthchecks.io"
+	defaultImportGrace     = 5 * time.Minute
+)
+
+// NewHealthchecksSource creates a source which turns the checks of a Healthchecks.io project into services.
+// The service ID is the check UUID, so existing clients keep pinging the same ID.
+func NewHealthchecksSource(cfg config.ImportConfig) Source {
+	if cfg.URL == "" {
+		cfg.URL = defaultHealthchecksURL
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultImportGrace)
+	}
+	return &healthchecksSource{
+		cfg: cfg,
+		cli: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type healthchecksSource struct {
+	cfg config.ImportConfig
+	cli *http.Client
+}
+
+// healthchecksCheck is the subset of a Healthchecks.io check we need
+type healthchecksCheck struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Tags     string `json:"tags"`
+	Desc     string `json:"desc"`
+	Timeout  int64  `json:"timeout"`
+	Grace    int64  `json:"grace"`
+	Schedule string `json:"schedule"`
+	Tz       string `json:"tz"`
+	Channels string `json:"channels"`
+}
+
+type healthchecksChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+func (s *healthchecksSource) Name() string {
+	return "healthchecks"
+}
+
+func (s *healthchecksSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *healthchecksSource) Prune() bool {
+	return false
+}
+
+func (s *healthchecksSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	headers := map[string]string{"X-Api-Key": s.cfg.APIKey}
+	base := strings.TrimSuffix(s.cfg.URL, "/")
+
+	var checks struct {
+		Checks []healthchecksCheck `json:"checks"`
+	}
+	err := getJSON(ctx, s.cli, base+"/api/v3/checks/", headers, &checks)
+	if err != nil {
+		return nil, err
+	}
+	// the channels are only readable with a full access key, so only ask for them if they are mapped
+	channels := make(map[string]healthchecksChannel)
+	if len(s.cfg.Integrations) > 0 {
+		var list struct {
+			Channels []healthchecksChannel `json:"channels"`
+		}
+		err = getJSON(ctx, s.cli, base+"/api/v3/channels/", headers, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range list.Channels {
+			channels[channel.ID] = channel
+		}
+	}
+
+	now := time.Now()
+	services := make([]config.ServiceConfig, 0, len(checks.Checks))
+	for _, check := range checks.Checks {
+		svc, err := s.service(check, channels, now)
+		if err != nil {
+			log.Warn().Str("check", check.Name).Err(err).Msg("skip healthchecks check")
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+func (s *healthchecksSource) service(check healthchecksCheck, channels map[string]healthchecksChannel, now time.Time) (config.ServiceConfig, error) {
+	// read only API keys don't reveal the UUID
+	id := check.UUID
+	if id == "" {
+		id = check.Slug
+	}
+	svc := config.ServiceConfig{
+		ID: path.Join(s.cfg.Prefix, id),
+		Labels: map[string]string{
+			config.LabelHealthchecksName:  check.Name,
+			config.LabelHealthchecksTags:  check.Tags,
+			config.LabelHealthchecksDesc:  check.Desc,
+			config.LabelHealthchecksGrace: strconv.FormatInt(check.Grace, 10),
+		},
+	}
+	dropEmptyLabels(svc.Labels)
+	if err := config.ValidateServiceID(svc.ID); err != nil {
+		return svc, err
+	}
+	grace := time.Duration(check.Grace) * time.Second
+	if check.Schedule != "" {
+		if grace == 0 {
+			grace = time.Duration(s.cfg.Grace)
+		}
+		timeout, err := timeoutForSchedule(check.Schedule, check.Tz, grace, now)
+		if err != nil {
+			return svc, err
+		}
+		svc.Timeout = config.Duration(timeout)
+	} else {
+		svc.Timeout = config.Duration(time.Duration(check.Timeout)*time.Second + grace)
+	}
+	for _, id := range strings.Split(check.Channels, ",") {
+		channel, ok := channels[strings.TrimSpace(id)]
+		if !ok {
+			continue
+		}
+		notifications := mappedIntegration(s.cfg.Integrations, channel.Name, channel.Kind)
+		if notifications == nil {
+			log.Warn().Str("check", check.Name).Str("integration", channel.Name).Str("kind", channel.Kind).Msg("integration is not mapped")
+			continue
+		}
+		svc.AlertNotifications = append(svc.AlertNotifications, notifications...)
+		svc.RecoveryNotifications = append(svc.RecoveryNotifications, notifications...)
+	}
+	return svc, nil
+}
+
+func dropEmptyLabels(labels map[string]string) {
+	for key, value := range labels {
+		if value == "" {
+			delete(labels, key)
+		}
+	}
+}
+
+// mappedIntegration returns the notifications of the first mapped key
+func mappedIntegration(integrations map[string][]config.NotificationConfig, keys ...string) []config.NotificationConfig {
+	for _, key := range keys {
+		if notifications, ok := integrations[key]; ok {
+			return notifications
+		}
+	}
+	return nil
+}
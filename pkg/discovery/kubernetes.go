@@ -0,0 +1,177 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// annotationIgnore excludes a cronjob from discovery when set to "true"
+	annotationIgnore = "deadman-switch/ignore"
+	// annotationTimeout overrides the timeout derived from the schedule
+	annotationTimeout = "deadman-switch/timeout"
+
+	defaultKubernetesPrefix = "kubernetes"
+	defaultKubernetesGrace  = 5 * time.Minute
+)
+
+// NewKubernetesSource creates a source which turns every CronJob of the configured namespaces into a service.
+// The service ID is `<prefix>/<namespace>/<name>`, the timeout is derived from the schedule.
+func NewKubernetesSource(cfg config.KubernetesDiscoveryConfig) (Source, error) {
+	if cfg.APIServer == "" {
+		cfg.APIServer = "https://kubernetes.default.svc"
+	}
+	if cfg.TokenFile == "" {
+		cfg.TokenFile = inClusterTokenFile
+	}
+	if cfg.CAFile == "" {
+		cfg.CAFile = inClusterCAFile
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultKubernetesPrefix
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultKubernetesGrace)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca, err := ioutil.ReadFile(cfg.CAFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &kubernetesSource{
+		cfg: cfg,
+		cli: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+		},
+	}, nil
+}
+
+type kubernetesSource struct {
+	cfg config.KubernetesDiscoveryConfig
+	cli *http.Client
+}
+
+// cronJobList is the subset of the kubernetes CronJobList we need
+type cronJobList struct {
+	Items []cronJob `json:"items"`
+}
+
+type cronJob struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Schedule    string  `json:"schedule"`
+		TimeZone    *string `json:"timeZone"`
+		Suspend     *bool   `json:"suspend"`
+		JobTemplate struct {
+			Spec struct {
+				ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+}
+
+func (s *kubernetesSource) Name() string {
+	return "kubernetes"
+}
+
+func (s *kubernetesSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *kubernetesSource) Prune() bool {
+	return s.cfg.Prune
+}
+
+func (s *kubernetesSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	var jobs []cronJob
+	if len(s.cfg.Namespaces) == 0 {
+		list, err := s.listCronJobs(ctx, "/apis/batch/v1/cronjobs")
+		if err != nil {
+			return nil, err
+		}
+		jobs = list
+	}
+	for _, ns := range s.cfg.Namespaces {
+		list, err := s.listCronJobs(ctx, path.Join("/apis/batch/v1/namespaces", ns, "cronjobs"))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, list...)
+	}
+
+	now := time.Now()
+	services := make([]config.ServiceConfig, 0, len(jobs))
+	for _, job := range jobs {
+		id := path.Join(s.cfg.Prefix, job.Metadata.Namespace, job.Metadata.Name)
+		if job.Metadata.Annotations[annotationIgnore] == "true" {
+			continue
+		}
+		if job.Spec.Suspend != nil && *job.Spec.Suspend {
+			continue
+		}
+		timeout, err := s.timeout(job, now)
+		if err != nil {
+			log.Warn().Str("service", id).Err(err).Msg("skip cronjob with invalid schedule")
+			continue
+		}
+		services = append(services, config.ServiceConfig{
+			ID:      id,
+			Timeout: config.Duration(timeout),
+		})
+	}
+	return services, nil
+}
+
+func (s *kubernetesSource) timeout(job cronJob, now time.Time) (time.Duration, error) {
+	if value, ok := job.Metadata.Annotations[annotationTimeout]; ok {
+		return time.ParseDuration(value)
+	}
+	timezone := ""
+	if job.Spec.TimeZone != nil {
+		timezone = *job.Spec.TimeZone
+	}
+	grace := time.Duration(s.cfg.Grace)
+	// the job may run until its deadline before it is able to ping
+	if deadline := job.Spec.JobTemplate.Spec.ActiveDeadlineSeconds; deadline != nil {
+		grace += time.Duration(*deadline) * time.Second
+	}
+	return timeoutForSchedule(job.Spec.Schedule, timezone, grace, now)
+}
+
+func (s *kubernetesSource) listCronJobs(ctx context.Context, apiPath string) ([]cronJob, error) {
+	// the token is read on every request, because kubernetes rotates projected tokens
+	headers := map[string]string{}
+	token, err := ioutil.ReadFile(s.cfg.TokenFile)
+	if err == nil {
+		headers["Authorization"] = "Bearer " + strings.TrimSpace(string(token))
+	} else {
+		log.Debug().Err(err).Str("file", s.cfg.TokenFile).Msg("no kubernetes token available")
+	}
+	var list cronJobList
+	err = getJSON(ctx, s.cli, strings.TrimSuffix(s.cfg.APIServer, "/")+apiPath, headers, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
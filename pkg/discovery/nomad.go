@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	// metaIgnore in the job metadata excludes a job from discovery when set to "true"
+	metaIgnore = "deadman-switch-ignore"
+
+	defaultNomadPrefix = "nomad"
+	defaultNomadGrace  = 5 * time.Minute
+)
+
+// NewNomadSource creates a source which turns every periodic nomad job into a service.
+// The service ID is `<prefix>/<namespace>/<job>`, the timeout is derived from the cron spec.
+func NewNomadSource(cfg config.NomadDiscoveryConfig) (Source, error) {
+	if cfg.Address == "" {
+		cfg.Address = "http://localhost:4646"
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = "*"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultNomadPrefix
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultNomadGrace)
+	}
+	return &nomadSource{
+		cfg: cfg,
+		cli: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type nomadSource struct {
+	cfg config.NomadDiscoveryConfig
+	cli *http.Client
+}
+
+// nomadJobStub is the subset of a nomad job list entry we need
+type nomadJobStub struct {
+	ID               string
+	Name             string
+	Namespace        string
+	Periodic         bool
+	ParameterizedJob bool
+	Stop             bool
+}
+
+// nomadJob is the subset of a nomad job we need
+type nomadJob struct {
+	Meta     map[string]string
+	Periodic *struct {
+		Enabled  bool
+		Spec     string
+		SpecType string
+		TimeZone string
+	}
+}
+
+func (s *nomadSource) Name() string {
+	return "nomad"
+}
+
+func (s *nomadSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *nomadSource) Prune() bool {
+	return s.cfg.Prune
+}
+
+func (s *nomadSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	headers := map[string]string{"X-Nomad-Token": s.cfg.Token}
+	base := strings.TrimSuffix(s.cfg.Address, "/")
+
+	var stubs []nomadJobStub
+	err := getJSON(ctx, s.cli, base+"/v1/jobs?namespace="+url.QueryEscape(s.cfg.Namespace), headers, &stubs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	services := make([]config.ServiceConfig, 0, len(stubs))
+	for _, stub := range stubs {
+		if !stub.Periodic || stub.ParameterizedJob || stub.Stop {
+			continue
+		}
+		var job nomadJob
+		err := getJSON(ctx, s.cli, base+"/v1/job/"+url.PathEscape(stub.ID)+"?namespace="+url.QueryEscape(stub.Namespace), headers, &job)
+		if err != nil {
+			return nil, err
+		}
+		if job.Periodic == nil || !job.Periodic.Enabled || job.Meta[metaIgnore] == "true" {
+			continue
+		}
+		svc, err := s.service(stub, job, now)
+		if err != nil {
+			log.Warn().Str("job", stub.ID).Err(err).Msg("skip nomad job")
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+func (s *nomadSource) service(stub nomadJobStub, job nomadJob, now time.Time) (config.ServiceConfig, error) {
+	svc := config.ServiceConfig{
+		ID: path.Join(s.cfg.Prefix, stub.Namespace, stub.ID),
+	}
+	if value, ok := job.Meta[metaTimeout]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return svc, err
+		}
+		svc.Timeout = config.Duration(timeout)
+	} else {
+		timeout, err := timeoutForSchedule(job.Periodic.Spec, job.Periodic.TimeZone, time.Duration(s.cfg.Grace), now)
+		if err != nil {
+			return svc, err
+		}
+		svc.Timeout = config.Duration(timeout)
+	}
+	data := templateData{
+		ID:   svc.ID,
+		Name: stub.Name,
+		Meta: job.Meta,
+	}
+	var err error
+	svc.AlertNotifications, err = renderNotifications(s.cfg.AlertNotifications, data)
+	if err != nil {
+		return svc, err
+	}
+	svc.RecoveryNotifications, err = renderNotifications(s.cfg.RecoveryNotifications, data)
+	if err != nil {
+		return svc, err
+	}
+	return svc, nil
+}
@@ -0,0 +1,201 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultPrometheusQuery = "up"
+	// defaultEvaluationInterval is the evaluation interval of Prometheus for groups without an own one
+	defaultEvaluationInterval = time.Minute
+)
+
+// NewPrometheusSource creates a source which turns the series of a Prometheus query, one service per job and
+// instance, or the recording rules of a rules file into services. The API key is sent as bearer token.
+func NewPrometheusSource(cfg config.ImportConfig) Source {
+	if cfg.Query == "" {
+		cfg.Query = defaultPrometheusQuery
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultImportGrace)
+	}
+	return &prometheusSource{
+		cfg: cfg,
+		cli: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type prometheusSource struct {
+	cfg config.ImportConfig
+	cli *http.Client
+}
+
+// prometheusRules is the subset of a Prometheus rules file we need
+type prometheusRules struct {
+	Groups []struct {
+		Name     string `json:"name"`
+		Interval string `json:"interval"`
+		Rules    []struct {
+			Record string            `json:"record"`
+			Alert  string            `json:"alert"`
+			Expr   string            `json:"expr"`
+			Labels map[string]string `json:"labels"`
+		} `json:"rules"`
+	} `json:"groups"`
+}
+
+func (s *prometheusSource) Name() string {
+	return "prometheus"
+}
+
+func (s *prometheusSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *prometheusSource) Prune() bool {
+	return false
+}
+
+func (s *prometheusSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	if s.cfg.Rules != "" {
+		return s.discoverRules()
+	}
+	if s.cfg.URL == "" {
+		return nil, errors.New("the prometheus import needs the URL of prometheus or a rules file")
+	}
+	var headers map[string]string
+	if s.cfg.APIKey != "" {
+		headers = map[string]string{"Authorization": "Bearer " + s.cfg.APIKey}
+	}
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric map[string]string `json:"metric"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	endpoint := strings.TrimSuffix(s.cfg.URL, "/") + "/api/v1/query?query=" + url.QueryEscape(s.cfg.Query)
+	err := getJSON(ctx, s.cli, endpoint, headers, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("the query returned a %s instead of an instant vector", resp.Data.ResultType)
+	}
+	services := make([]config.ServiceConfig, 0, len(resp.Data.Result))
+	seen := make(map[string]bool)
+	for _, series := range resp.Data.Result {
+		job, instance := series.Metric["job"], series.Metric["instance"]
+		if job == "" || instance == "" {
+			log.Warn().Interface("series", series.Metric).Msg("skip series without job or instance")
+			continue
+		}
+		svc := config.ServiceConfig{
+			ID:      path.Join(s.cfg.Prefix, idSegment(job), idSegment(instance)),
+			Timeout: s.cfg.Grace,
+			Labels:  make(map[string]string, len(series.Metric)),
+		}
+		for key, value := range series.Metric {
+			if key != "__name__" {
+				svc.Labels[key] = value
+			}
+		}
+		if err := config.ValidateServiceID(svc.ID); err != nil {
+			log.Warn().Str("job", job).Str("instance", instance).Err(err).Msg("skip series")
+			continue
+		}
+		// a query like up without aggregation returns one series per job and instance, others may not
+		if seen[svc.ID] {
+			continue
+		}
+		seen[svc.ID] = true
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+	return services, nil
+}
+
+// discoverRules turns every recording rule into a service which expects a heartbeat per evaluation
+func (s *prometheusSource) discoverRules() ([]config.ServiceConfig, error) {
+	bs, err := ioutil.ReadFile(s.cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var rules prometheusRules
+	err = yaml.Unmarshal(bs, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules file: %w", err)
+	}
+	var services []config.ServiceConfig
+	for _, group := range rules.Groups {
+		interval := defaultEvaluationInterval
+		if group.Interval != "" {
+			interval, err = parsePrometheusDuration(group.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: invalid interval: %w", group.Name, err)
+			}
+		}
+		for _, rule := range group.Rules {
+			if rule.Record == "" {
+				continue
+			}
+			svc := config.ServiceConfig{
+				ID:      path.Join(s.cfg.Prefix, idSegment(group.Name), idSegment(rule.Record)),
+				Timeout: config.Duration(interval + time.Duration(s.cfg.Grace)),
+				Labels: map[string]string{
+					config.LabelPrometheusGroup: group.Name,
+					config.LabelPrometheusExpr:  strings.TrimSpace(rule.Expr),
+				},
+			}
+			for key, value := range rule.Labels {
+				svc.Labels[key] = value
+			}
+			dropEmptyLabels(svc.Labels)
+			if err := config.ValidateServiceID(svc.ID); err != nil {
+				log.Warn().Str("group", group.Name).Str("record", rule.Record).Err(err).Msg("skip recording rule")
+				continue
+			}
+			services = append(services, svc)
+		}
+	}
+	return services, nil
+}
+
+// idSegment turns a label value like localhost:9100 into a segment of a service ID
+func idSegment(value string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("/*?[]\\", r) {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// parsePrometheusDuration parses durations like 30s or 1h30m, and the units d, w and y of Prometheus
+func parsePrometheusDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleSamples is the number of upcoming activations inspected to find the longest gap
+const scheduleSamples = 32
+
+// timeoutForSchedule returns the longest gap between two upcoming activations of the cron schedule
+// plus the given grace period. This is the time a job on that schedule may be silent without being late.
+func timeoutForSchedule(schedule string, timezone string, grace time.Duration, now time.Time) (time.Duration, error) {
+	if timezone != "" {
+		schedule = "CRON_TZ=" + timezone + " " + schedule
+	}
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return 0, err
+	}
+	var maxGap time.Duration
+	last := sched.Next(now)
+	for i := 0; i < scheduleSamples; i++ {
+		next := sched.Next(last)
+		if gap := next.Sub(last); gap > maxGap {
+			maxGap = gap
+		}
+		last = next
+	}
+	return maxGap + grace, nil
+}
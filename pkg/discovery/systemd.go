@@ -0,0 +1,232 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultSystemdGrace = 5 * time.Minute
+	// calendarSamples is the number of upcoming elapses inspected to find the longest gap
+	calendarSamples = 32
+)
+
+// SystemdTimer is a timer unit found on the local host
+type SystemdTimer struct {
+	// Name of the timer unit, e.g. `backup.timer`
+	Name string
+	// Unit is the unit activated by the timer, e.g. `backup.service`
+	Unit      string
+	ServiceID string
+	Timeout   time.Duration
+}
+
+// SystemdSource discovers the systemd timers of the local host
+type SystemdSource struct {
+	cfg config.SystemdDiscoveryConfig
+}
+
+// NewSystemdSource creates a source which turns every systemd timer matching the pattern into a service.
+// The service ID is `<prefix>/<timer name>`, the timeout is derived from the timer settings.
+func NewSystemdSource(cfg config.SystemdDiscoveryConfig) (*SystemdSource, error) {
+	if cfg.Pattern == "" {
+		cfg.Pattern = "*.timer"
+	}
+	if _, err := path.Match(cfg.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid timer pattern %q: %v", cfg.Pattern, err)
+	}
+	if cfg.Prefix == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Prefix = path.Join("systemd", hostname)
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = config.Duration(defaultSystemdGrace)
+	}
+	return &SystemdSource{cfg}, nil
+}
+
+func (s *SystemdSource) Name() string {
+	return "systemd"
+}
+
+func (s *SystemdSource) Prefix() string {
+	return s.cfg.Prefix
+}
+
+func (s *SystemdSource) Prune() bool {
+	return s.cfg.Prune
+}
+
+func (s *SystemdSource) Discover(ctx context.Context) ([]config.ServiceConfig, error) {
+	timers, err := s.Timers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]config.ServiceConfig, 0, len(timers))
+	for _, timer := range timers {
+		services = append(services, config.ServiceConfig{
+			ID:      timer.ServiceID,
+			Token:   s.cfg.Token,
+			Timeout: config.Duration(timer.Timeout),
+		})
+	}
+	return services, nil
+}
+
+// Timers lists all timers matching the pattern
+func (s *SystemdSource) Timers(ctx context.Context) ([]SystemdTimer, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "list-units", "--type=timer", "--all", "--plain", "--no-legend", "--no-pager").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list timers: %v", err)
+	}
+	var timers []SystemdTimer
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "●"))
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if ok, _ := path.Match(s.cfg.Pattern, name); !ok {
+			continue
+		}
+		timer, err := s.inspect(ctx, name)
+		if err != nil {
+			log.Warn().Str("timer", name).Err(err).Msg("skip timer")
+			continue
+		}
+		timers = append(timers, timer)
+	}
+	return timers, nil
+}
+
+var (
+	calendarRegexp  = regexp.MustCompile(`OnCalendar=([^;]+);`)
+	monotonicRegexp = regexp.MustCompile(`OnUnit(?:Active|Inactive)USec=([^;]+);`)
+)
+
+func (s *SystemdSource) inspect(ctx context.Context, name string) (SystemdTimer, error) {
+	timer := SystemdTimer{
+		Name:      name,
+		ServiceID: path.Join(s.cfg.Prefix, strings.TrimSuffix(name, ".timer")),
+	}
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "--no-pager", "--property=Unit,TimersCalendar,TimersMonotonic", name).Output()
+	if err != nil {
+		return timer, err
+	}
+	var maxGap time.Duration
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Unit="):
+			timer.Unit = strings.TrimPrefix(line, "Unit=")
+		case strings.HasPrefix(line, "TimersCalendar="):
+			for _, match := range calendarRegexp.FindAllStringSubmatch(line, -1) {
+				gap, err := calendarGap(ctx, strings.TrimSpace(match[1]))
+				if err != nil {
+					return timer, err
+				}
+				if gap > maxGap {
+					maxGap = gap
+				}
+			}
+		case strings.HasPrefix(line, "TimersMonotonic="):
+			for _, match := range monotonicRegexp.FindAllStringSubmatch(line, -1) {
+				gap, err := parseTimespan(strings.TrimSpace(match[1]))
+				if err != nil {
+					return timer, err
+				}
+				if gap > maxGap {
+					maxGap = gap
+				}
+			}
+		}
+	}
+	if timer.Unit == "" {
+		return timer, fmt.Errorf("timer %s activates no unit", name)
+	}
+	if maxGap == 0 {
+		return timer, fmt.Errorf("timer %s has no recurring trigger", name)
+	}
+	timer.Timeout = maxGap + time.Duration(s.cfg.Grace)
+	return timer, nil
+}
+
+// calendarGap asks systemd-analyze for the next elapses of a calendar expression and returns the longest gap
+func calendarGap(ctx context.Context, expr string) (time.Duration, error) {
+	out, err := exec.CommandContext(ctx, "systemd-analyze", "calendar", "--iterations="+strconv.Itoa(calendarSamples), expr).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to analyze calendar expression %q: %v", expr, err)
+	}
+	var (
+		maxGap time.Duration
+		last   time.Time
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "Next elapse:") && !strings.HasPrefix(line, "Iter. #") {
+			continue
+		}
+		t, err := time.Parse("Mon 2006-01-02 15:04:05 MST", strings.TrimSpace(line[strings.Index(line, ":")+1:]))
+		if err != nil {
+			return 0, err
+		}
+		if !last.IsZero() && t.Sub(last) > maxGap {
+			maxGap = t.Sub(last)
+		}
+		last = t
+	}
+	return maxGap, nil
+}
+
+var timespanRegexp = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*([a-zA-Z]*)`)
+
+var timespanUnits = map[string]time.Duration{
+	"us": time.Microsecond, "usec": time.Microsecond,
+	"ms": time.Millisecond, "msec": time.Millisecond,
+	"": time.Second, "s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"M": 2629800 * time.Second, "month": 2629800 * time.Second, "months": 2629800 * time.Second,
+	"y": 31557600 * time.Second, "year": 31557600 * time.Second, "years": 31557600 * time.Second,
+}
+
+// parseTimespan parses systemd time spans like `1h 30min`
+func parseTimespan(value string) (time.Duration, error) {
+	matches := timespanRegexp.FindAllStringSubmatch(value, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("invalid time span %q", value)
+	}
+	var d time.Duration
+	for _, match := range matches {
+		n, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		unit, ok := timespanUnits[match[2]]
+		if !ok {
+			return 0, fmt.Errorf("invalid unit %q in time span %q", match[2], value)
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	return d, nil
+}
@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// templateData is available in the notification templates of discovered services
+type templateData struct {
+	ID   string
+	Name string
+	Tags []string
+	Meta map[string]string
+}
+
+// renderNotifications renders every string in the notification configs as a go template,
+// so e.g. a slack channel can be taken from the service metadata: `{{ .Meta.team }}-alerts`
+func renderNotifications(templates []config.NotificationConfig, data templateData) ([]config.NotificationConfig, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	result := make([]config.NotificationConfig, 0, len(templates))
+	for _, notification := range templates {
+		cfg, err := renderValue(notification.Config, data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, config.NotificationConfig{
+			Type:   notification.Type,
+			Config: cfg,
+		})
+	}
+	return result, nil
+}
+
+func renderValue(value interface{}, data templateData) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		tmpl, err := template.New("").Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, data)
+		if err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			rendered, err := renderValue(val, data)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, val := range v {
+			rendered, err := renderValue(val, data)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, rendered)
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,349 @@
+// Package egress enforces the egress policy of the server on the endpoints of notifications, callbacks and
+// forwards, so the URLs of services created through the API can't reach the internal network.
+//
+// The configs are checked when they are created, which catches literal addresses and URLs outside of the
+// allowed patterns. Host names are resolved and checked again when a notification is sent, and the HTTP
+// clients check the addresses they connect to, so a name which resolves differently later or a redirect
+// can't get around the policy.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// privateNetworks are denied with DenyPrivateNetworks
+var privateNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// resolveTimeout bounds the lookup of the hosts before a notification is sent
+const resolveTimeout = 5 * time.Second
+
+// Policy checks endpoints against the egress config, a nil policy allows everything
+type Policy struct {
+	urls        []*regexp.Regexp
+	allowed     []*net.IPNet
+	denied      []*net.IPNet
+	disableExec bool
+}
+
+// New compiles the validated config, it returns nil without a config
+func New(cfg *config.EgressConfig) *Policy {
+	if cfg == nil {
+		return nil
+	}
+	p := &Policy{
+		urls:        make([]*regexp.Regexp, 0, len(cfg.AllowedURLs)),
+		allowed:     parseNetworks(cfg.AllowedNetworks),
+		denied:      parseNetworks(cfg.DeniedNetworks),
+		disableExec: cfg.DisableExec,
+	}
+	if cfg.DenyPrivateNetworks {
+		p.denied = append(p.denied, parseNetworks(privateNetworks)...)
+	}
+	for _, pattern := range cfg.AllowedURLs {
+		p.urls = append(p.urls, compilePattern(pattern))
+	}
+	return p
+}
+
+func parseNetworks(networks []string) []*net.IPNet {
+	parsed := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			parsed = append(parsed, ipNet)
+		}
+	}
+	return parsed
+}
+
+// compilePattern turns a URL pattern into a regexp, * matches anything but a slash and ** anything.
+// A trailing /** matches the URL without a path as well.
+func compilePattern(pattern string) *regexp.Regexp {
+	suffix := ""
+	if strings.HasSuffix(pattern, "/**") {
+		pattern = strings.TrimSuffix(pattern, "/**")
+		suffix = "(/.*)?"
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(suffix)
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// CheckService checks the notifications, the callback and the forward of a service without resolving hosts,
+// svc should have its defaults applied
+func (p *Policy) CheckService(svc config.ServiceConfig) error {
+	if p == nil {
+		return nil
+	}
+	notifications := append(append([]config.NotificationConfig{}, svc.AlertNotifications...), svc.RecoveryNotifications...)
+	for _, tier := range svc.Escalation {
+		notifications = append(append(notifications, tier.AlertNotifications...), tier.RecoveryNotifications...)
+	}
+	if svc.EarlyWarning != nil {
+		notifications = append(notifications, svc.EarlyWarning.Notifications...)
+	}
+	if svc.Countdown != nil {
+		notifications = append(notifications, svc.Countdown.Notifications...)
+	}
+	for _, notification := range notifications {
+		err := p.check(context.Background(), notification, false)
+		if err != nil {
+			return err
+		}
+	}
+	if svc.Callback != nil && svc.Callback.URL != "" {
+		err := p.checkEndpoint(context.Background(), svc.Callback.URL, false)
+		if err != nil {
+			return fmt.Errorf("callback: %w", err)
+		}
+	}
+	if svc.Forward != nil {
+		err := p.checkEndpoint(context.Background(), svc.Forward.URL, false)
+		if err != nil {
+			return fmt.Errorf("forward: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckNotification checks a notification before it is sent, the hosts of its endpoints are resolved
+func (p *Policy) CheckNotification(ctx context.Context, notification config.NotificationConfig) error {
+	if p == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return p.check(ctx, notification, true)
+}
+
+// CheckURL checks a URL before it is called, e.g. after it was rendered from a template
+func (p *Policy) CheckURL(ctx context.Context, rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return p.checkEndpoint(ctx, rawURL, true)
+}
+
+func (p *Policy) check(ctx context.Context, notification config.NotificationConfig, resolve bool) error {
+	if notification.Type == config.NotificationTypeExec && p.disableExec {
+		return errors.New("exec notifications are disabled by the egress policy")
+	}
+	endpoints, err := endpoints(notification)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		// templates are checked after rendering
+		if strings.Contains(endpoint, "{{") {
+			continue
+		}
+		err = p.checkEndpoint(ctx, endpoint, resolve)
+		if err != nil {
+			return fmt.Errorf("%s notification: %w", notification.Type, err)
+		}
+	}
+	return nil
+}
+
+// endpoints returns the URLs a notification connects to, the default URLs of the APIs are left out
+func endpoints(notification config.NotificationConfig) ([]string, error) {
+	var urls []string
+	add := func(urlPrefix string, values ...string) {
+		for _, value := range values {
+			if value != "" {
+				urls = append(urls, urlPrefix+value)
+			}
+		}
+	}
+	var err error
+	switch notification.Type {
+	case config.NotificationTypeWebhook:
+		var cfg config.WebhookConfig
+		cfg, err = notification.GetWebhookConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeCallback:
+		var cfg config.CallbackConfig
+		cfg, err = notification.GetCallbackConfig()
+		add("", cfg.URL)
+	case config.NotificationTypePagerDuty:
+		var cfg config.PagerDutyConfig
+		cfg, err = notification.GetPagerDutyConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeOpsgenie:
+		var cfg config.OpsgenieConfig
+		cfg, err = notification.GetOpsgenieConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeDiscord:
+		var cfg config.DiscordConfig
+		cfg, err = notification.GetDiscordConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeTwilio:
+		var cfg config.TwilioConfig
+		cfg, err = notification.GetTwilioConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeEmail:
+		var cfg config.EmailConfig
+		cfg, err = notification.GetEmailConfig()
+		if cfg.Host != "" && cfg.Port != 0 {
+			add("smtp://", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+		} else {
+			add("smtp://", cfg.Host)
+		}
+	case config.NotificationTypeMQTT:
+		var cfg config.MQTTConfig
+		cfg, err = notification.GetMQTTConfig()
+		add("", cfg.Broker)
+	case config.NotificationTypeKafka:
+		var cfg config.KafkaConfig
+		cfg, err = notification.GetKafkaConfig()
+		add("kafka://", cfg.Brokers...)
+		add("", cfg.SchemaRegistry)
+	case config.NotificationTypeNATS:
+		var cfg config.NATSConfig
+		cfg, err = notification.GetNATSConfig()
+		add("", cfg.URL)
+	case config.NotificationTypeZabbix:
+		var cfg config.ZabbixConfig
+		cfg, err = notification.GetZabbixConfig()
+		add("zabbix://", cfg.Server)
+	case config.NotificationTypeNSCA:
+		var cfg config.NSCAConfig
+		cfg, err = notification.GetNSCAConfig()
+		add("nsca://", cfg.Server)
+	case config.NotificationTypeEventBridge:
+		var cfg config.EventBridgeConfig
+		cfg, err = notification.GetEventBridgeConfig()
+		add("", cfg.Endpoint)
+	}
+	return urls, err
+}
+
+// checkEndpoint matches the URL against the allowed patterns and checks the addresses of its host
+func (p *Policy) checkEndpoint(ctx context.Context, rawURL string, resolve bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q", rawURL)
+	}
+	if len(p.urls) > 0 {
+		// credentials, the query and the fragment don't matter
+		normalized := u.Scheme + "://" + u.Host + u.EscapedPath()
+		allowed := false
+		for _, pattern := range p.urls {
+			if pattern.MatchString(normalized) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s isn't allowed by the egress policy", normalized)
+		}
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return p.CheckIP(net.IPv6loopback)
+	}
+	if !resolve {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		// the connection fails as well
+		return nil
+	}
+	for _, addr := range addrs {
+		err = p.CheckIP(addr.IP)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// CheckIP checks an address against the networks, the allowed networks take precedence over the denied ones
+func (p *Policy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	for _, network := range p.denied {
+		if network.Contains(ip) {
+			return fmt.Errorf("the address %s is denied by the egress policy", ip)
+		}
+	}
+	return nil
+}
+
+// Control rejects connections to addresses the policy denies, it is meant for net.Dialer.Control and
+// allows everything without a policy
+func (p *Policy) Control(network, address string, _ syscall.RawConn) error {
+	if p == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	return p.CheckIP(net.ParseIP(host))
+}
+
+// Transport returns an HTTP transport which only connects to addresses the policy allows, nil without a policy
+func (p *Policy) Transport() http.RoundTripper {
+	if p == nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.Control,
+	}
+	transport.DialContext = dialer.DialContext
+	// a proxy would connect for us
+	transport.Proxy = nil
+	return transport
+}
@@ -0,0 +1,131 @@
+package egress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+func TestCheckURL(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		cfg     config.EgressConfig
+		url     string
+		allowed bool
+	}{
+		{"no rules", config.EgressConfig{}, "http://10.0.0.1/hook", true},
+		{"matching pattern", config.EgressConfig{AllowedURLs: []string{"https://hooks.example.com/**"}}, "https://hooks.example.com/team/ops?token=secret", true},
+		{"pattern without path", config.EgressConfig{AllowedURLs: []string{"https://hooks.example.com/**"}}, "https://hooks.example.com", true},
+		{"other host", config.EgressConfig{AllowedURLs: []string{"https://hooks.example.com/**"}}, "https://hooks.example.com.evil.test/", false},
+		{"other scheme", config.EgressConfig{AllowedURLs: []string{"https://hooks.example.com/**"}}, "http://hooks.example.com/", false},
+		{"credentials don't match the host", config.EgressConfig{AllowedURLs: []string{"https://hooks.example.com/**"}}, "https://hooks.example.com@10.0.0.1/", false},
+		{"single star stops at slashes", config.EgressConfig{AllowedURLs: []string{"https://*.example.com/hooks/*"}}, "https://a.example.com/hooks/x/y", false},
+		{"single star", config.EgressConfig{AllowedURLs: []string{"https://*.example.com/hooks/*"}}, "https://a.example.com/hooks/x", true},
+		{"private address", config.EgressConfig{DenyPrivateNetworks: true}, "http://192.168.1.10/", false},
+		{"metadata service", config.EgressConfig{DenyPrivateNetworks: true}, "http://169.254.169.254/latest/meta-data/", false},
+		{"loopback", config.EgressConfig{DenyPrivateNetworks: true}, "http://127.0.0.1:8080/", false},
+		{"ipv6 loopback", config.EgressConfig{DenyPrivateNetworks: true}, "http://[::1]:8080/", false},
+		{"localhost", config.EgressConfig{DenyPrivateNetworks: true}, "http://localhost:8080/", false},
+		{"public address", config.EgressConfig{DenyPrivateNetworks: true}, "https://93.184.216.34/", true},
+		{"denied network", config.EgressConfig{DeniedNetworks: []string{"93.184.216.0/24"}}, "https://93.184.216.34/", false},
+		{"allowed network wins", config.EgressConfig{DenyPrivateNetworks: true, AllowedNetworks: []string{"10.1.0.0/16"}}, "http://10.1.2.3/", true},
+		{"no host", config.EgressConfig{}, "/relative", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg
+			err := New(&cfg).CheckURL(context.Background(), test.url)
+			if test.allowed != (err == nil) {
+				t.Fatalf("expected allowed %v, got %v", test.allowed, err)
+			}
+		})
+	}
+}
+
+func TestCheckService(t *testing.T) {
+	policy := New(&config.EgressConfig{
+		AllowedURLs:         []string{"https://hooks.example.com/**", "kafka://*.kafka.example.com:9092"},
+		DenyPrivateNetworks: true,
+		DisableExec:         true,
+	})
+	webhook := func(url string) config.NotificationConfig {
+		return config.NotificationConfig{Type: config.NotificationTypeWebhook, Config: map[string]interface{}{"url": url}}
+	}
+	for _, test := range []struct {
+		name    string
+		svc     config.ServiceConfig
+		allowed bool
+	}{
+		{"allowed webhook", config.ServiceConfig{AlertNotifications: []config.NotificationConfig{webhook("https://hooks.example.com/ops")}}, true},
+		{"templates are checked after rendering", config.ServiceConfig{AlertNotifications: []config.NotificationConfig{webhook("https://{{.Labels.host}}/ops")}}, true},
+		{"recovery webhook", config.ServiceConfig{RecoveryNotifications: []config.NotificationConfig{webhook("http://169.254.169.254/")}}, false},
+		{"escalation tier", config.ServiceConfig{Escalation: config.Escalation{{AlertNotifications: []config.NotificationConfig{webhook("https://evil.test/")}}}}, false},
+		{"early warning", config.ServiceConfig{EarlyWarning: &config.EarlyWarningConfig{Notifications: []config.NotificationConfig{webhook("https://evil.test/")}}}, false},
+		{"callback", config.ServiceConfig{Callback: &config.CallbackConfig{URL: "http://10.0.0.1/"}}, false},
+		{"forward", config.ServiceConfig{Forward: &config.ForwardConfig{URL: "https://evil.test/"}}, false},
+		{"kafka brokers", config.ServiceConfig{AlertNotifications: []config.NotificationConfig{{
+			Type:   config.NotificationTypeKafka,
+			Config: map[string]interface{}{"brokers": []interface{}{"a.kafka.example.com:9092", "10.0.0.5:9092"}, "topic": "alerts"},
+		}}}, false},
+		{"email server", config.ServiceConfig{AlertNotifications: []config.NotificationConfig{{
+			Type:   config.NotificationTypeEmail,
+			Config: map[string]interface{}{"host": "smtp.example.com", "port": 587},
+		}}}, false},
+		{"exec", config.ServiceConfig{AlertNotifications: []config.NotificationConfig{{
+			Type:   config.NotificationTypeExec,
+			Config: map[string]interface{}{"command": "page"},
+		}}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.CheckService(test.svc)
+			if test.allowed != (err == nil) {
+				t.Fatalf("expected allowed %v, got %v", test.allowed, err)
+			}
+		})
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var policy *Policy
+	if err := policy.CheckURL(context.Background(), "http://127.0.0.1/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.CheckIP(net.ParseIP("169.254.169.254")); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Transport() != nil {
+		t.Fatal("expected the default transport without a policy")
+	}
+}
+
+// TestTransport checks the addresses the HTTP client connects to, which catches names and redirects
+// to denied addresses
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	for _, test := range []struct {
+		name    string
+		cfg     config.EgressConfig
+		allowed bool
+	}{
+		{"denied", config.EgressConfig{DenyPrivateNetworks: true}, false},
+		{"allowed network", config.EgressConfig{DenyPrivateNetworks: true, AllowedNetworks: []string{"127.0.0.0/8"}}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg
+			client := &http.Client{Transport: New(&cfg).Transport()}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if test.allowed != (err == nil) {
+				t.Fatalf("expected allowed %v, got %v", test.allowed, err)
+			}
+		})
+	}
+}
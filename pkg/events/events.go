@@ -0,0 +1,121 @@
+// Package events emits machine readable alarm lifecycle events.
+//
+// Unlike notifications, which are meant for humans and configured per service, lifecycle
+// events are sent for every service to globally configured webhooks or Kafka topics, e.g. to feed a
+// data warehouse.
+// The JSON format is versioned by SchemaVersion and only changes in backwards compatible ways
+// within one version.
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/ids"
+)
+
+// SchemaVersion is the version of the JSON format of Event
+const SchemaVersion = "1"
+
+type Type string
+
+const (
+	AlarmCreated      Type = "alarm.created"
+	AlarmAcknowledged Type = "alarm.acknowledged"
+	AlarmResolved     Type = "alarm.resolved"
+	SilenceCreated    Type = "silence.created"
+	// HeartbeatReceived is emitted for every recorded heartbeat, webhooks only get it if they ask for it
+	HeartbeatReceived Type = "heartbeat.received"
+)
+
+type Event struct {
+	SchemaVersion string     `json:"schemaVersion"`
+	ID            string     `json:"id"`
+	Type          Type       `json:"type"`
+	Time          time.Time  `json:"time"`
+	Alarm         *Alarm     `json:"alarm,omitempty"`
+	Silence       *Silence   `json:"silence,omitempty"`
+	Heartbeat     *Heartbeat `json:"heartbeat,omitempty"`
+}
+
+// Alarm describes the alarm of a single service
+type Alarm struct {
+	Service     string            `json:"service"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	ActiveSince time.Time         `json:"activeSince"`
+	// AcknowledgedBy is set for alarm.acknowledged events, Comment for them and for alarms forced or cleared
+	// through the API
+	AcknowledgedBy string `json:"acknowledgedBy,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+	// ResolvedAt is set for alarm.resolved events
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Silence describes a silence of the alerts of the matching services
+type Silence struct {
+	ID        string            `json:"id"`
+	Match     string            `json:"match,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	StartsAt  time.Time         `json:"startsAt"`
+	EndsAt    time.Time         `json:"endsAt"`
+	CreatedBy string            `json:"createdBy"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+// Heartbeat describes a recorded heartbeat, its time is the one of the event
+type Heartbeat struct {
+	Service string            `json:"service"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Emitter sends events. Emit must not block on slow receivers.
+type Emitter interface {
+	Emit(ctx context.Context, event Event)
+}
+
+// New creates an event with a random ID
+func New(eventType Type, t time.Time) (Event, error) {
+	id, err := ids.Random(16)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		SchemaVersion: SchemaVersion,
+		ID:            id,
+		Type:          eventType,
+		Time:          t.UTC(),
+	}, nil
+}
+
+// NewAlarmEvent creates an event about the alarm of a service
+func NewAlarmEvent(eventType Type, t time.Time, alarm Alarm) (Event, error) {
+	event, err := New(eventType, t)
+	event.Alarm = &alarm
+	return event, err
+}
+
+// NewSilenceEvent creates an event about a silence
+func NewSilenceEvent(eventType Type, t time.Time, silence Silence) (Event, error) {
+	event, err := New(eventType, t)
+	event.Silence = &silence
+	return event, err
+}
+
+// NewHeartbeatEvent creates an event about a heartbeat
+func NewHeartbeatEvent(t time.Time, heartbeat Heartbeat) (Event, error) {
+	event, err := New(HeartbeatReceived, t)
+	event.Heartbeat = &heartbeat
+	return event, err
+}
+
+// Multi sends every event to all emitters
+type Multi []Emitter
+
+func (m Multi) Emit(ctx context.Context, event Event) {
+	for _, emitter := range m {
+		emitter.Emit(ctx, event)
+	}
+}
+
+// Discard drops all events
+var Discard Emitter = Multi(nil)
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/kafka"
+)
+
+const (
+	kafkaQueueSize = 10000
+	// kafkaBatchSize and kafkaLinger bound the events which are produced together
+	kafkaBatchSize = 500
+	kafkaLinger    = time.Second
+	kafkaAttempts  = 5
+	kafkaTimeout   = 10 * time.Second
+)
+
+// kafkaAvroSchema is the schema of the events with avro serialization, the fields match Event
+const kafkaAvroSchema = `{"type":"record","name":"LifecycleEvent","namespace":"io.deadmanswitch","fields":[` +
+	`{"name":"schemaVersion","type":"string"},` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"type","type":"string"},` +
+	`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"alarm","type":["null",{"type":"record","name":"Alarm","fields":[` +
+	`{"name":"service","type":"string"},` +
+	`{"name":"labels","type":{"type":"map","values":"string"}},` +
+	`{"name":"activeSince","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"acknowledgedBy","type":"string"},` +
+	`{"name":"comment","type":"string"},` +
+	`{"name":"resolvedAt","type":["null",{"type":"long","logicalType":"timestamp-millis"}],"default":null}]}],"default":null},` +
+	`{"name":"silence","type":["null",{"type":"record","name":"Silence","fields":[` +
+	`{"name":"id","type":"string"},` +
+	`{"name":"match","type":"string"},` +
+	`{"name":"labels","type":{"type":"map","values":"string"}},` +
+	`{"name":"startsAt","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"endsAt","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"createdBy","type":"string"},` +
+	`{"name":"comment","type":"string"}]}],"default":null},` +
+	`{"name":"heartbeat","type":["null",{"type":"record","name":"Heartbeat","fields":[` +
+	`{"name":"service","type":"string"},` +
+	`{"name":"labels","type":{"type":"map","values":"string"}}]}],"default":null}]}`
+
+// NewKafkaEmitter creates an emitter which produces the events to the Kafka topics of their group in the
+// background. The events are produced in batches, failed batches are retried with backoff and events are
+// dropped if Kafka can't keep up.
+func NewKafkaEmitter(ctx context.Context, cfg config.EventStreamConfig) Emitter {
+	k := &kafkaEmitter{
+		cfg:    cfg,
+		events: make(chan Event, kafkaQueueSize),
+		cli:    &http.Client{Timeout: 5 * time.Second},
+		opts: kafka.Options{
+			Brokers:  cfg.Brokers,
+			ClientID: "deadman-switch",
+			Username: cfg.Username,
+			Password: cfg.Password,
+		},
+	}
+	if cfg.TLS {
+		k.opts.TLSConfig = &tls.Config{}
+	}
+	go k.run(ctx)
+	return k
+}
+
+type kafkaEmitter struct {
+	cfg    config.EventStreamConfig
+	events chan Event
+	cli    *http.Client
+	opts   kafka.Options
+}
+
+func (k *kafkaEmitter) Emit(ctx context.Context, event Event) {
+	if k.topic(event.Type) == "" {
+		return
+	}
+	select {
+	case k.events <- event:
+	default:
+		log.Error().Str("event", string(event.Type)).Msg("event stream queue is full, drop event")
+	}
+}
+
+// topic returns the topic of the group of the event type, empty if the group isn't published
+func (k *kafkaEmitter) topic(eventType Type) string {
+	switch {
+	case eventType == HeartbeatReceived:
+		return k.cfg.HeartbeatTopic
+	case strings.HasPrefix(string(eventType), "alarm."):
+		return k.cfg.AlarmTopic
+	case strings.HasPrefix(string(eventType), "silence."):
+		return k.cfg.SilenceTopic
+	}
+	return ""
+}
+
+func (k *kafkaEmitter) run(ctx context.Context) {
+	batch := make([]Event, 0, kafkaBatchSize)
+	linger := time.NewTimer(kafkaLinger)
+	defer linger.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-k.events:
+			batch = append(batch, event)
+			if len(batch) < kafkaBatchSize {
+				continue
+			}
+		case <-linger.C:
+			linger.Reset(kafkaLinger)
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		k.deliver(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (k *kafkaEmitter) deliver(ctx context.Context, batch []Event) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := k.produce(ctx, batch)
+		if err == nil {
+			return
+		}
+		if attempt == kafkaAttempts {
+			log.Error().Err(err).Int("events", len(batch)).Msg("failed to produce events to the event stream, giving up")
+			return
+		}
+		log.Warn().Err(err).Int("events", len(batch)).Msg("failed to produce events to the event stream, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (k *kafkaEmitter) produce(ctx context.Context, batch []Event) error {
+	ctx, cancel := context.WithTimeout(ctx, kafkaTimeout)
+	defer cancel()
+	msgs := make([]kafka.Message, 0, len(batch))
+	for _, event := range batch {
+		topic := k.topic(event.Type)
+		value, err := k.encode(ctx, topic, event)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{Topic: topic, Key: []byte(event.key()), Value: value, Time: event.Time})
+	}
+	return kafka.ProduceBatch(ctx, k.opts, msgs)
+}
+
+func (k *kafkaEmitter) encode(ctx context.Context, topic string, event Event) ([]byte, error) {
+	if k.cfg.Serialization != "avro" {
+		return json.Marshal(event)
+	}
+	value := event.avro()
+	if k.cfg.SchemaRegistry == "" {
+		return value, nil
+	}
+	id, err := kafka.RegisterSchema(ctx, k.cli, k.cfg.SchemaRegistry, topic+"-value", kafkaAvroSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register the avro schema: %w", err)
+	}
+	return kafka.WireFormat(id, value), nil
+}
+
+// key is the service of the event, or the ID of the silence, so the events of a service stay in order
+func (e Event) key() string {
+	switch {
+	case e.Alarm != nil:
+		return e.Alarm.Service
+	case e.Heartbeat != nil:
+		return e.Heartbeat.Service
+	case e.Silence != nil:
+		return e.Silence.ID
+	}
+	return e.ID
+}
+
+// avro encodes the event in the avro binary encoding of kafkaAvroSchema
+func (e Event) avro() []byte {
+	var b []byte
+	long := func(v int64) {
+		var buf [binary.MaxVarintLen64]byte
+		b = append(b, buf[:binary.PutVarint(buf[:], v)]...)
+	}
+	str := func(s string) {
+		long(int64(len(s)))
+		b = append(b, s...)
+	}
+	millis := func(t time.Time) {
+		long(t.UnixNano() / int64(time.Millisecond))
+	}
+	labels := func(labels map[string]string) {
+		if len(labels) > 0 {
+			keys := make([]string, 0, len(labels))
+			for key := range labels {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			long(int64(len(keys)))
+			for _, key := range keys {
+				str(key)
+				str(labels[key])
+			}
+		}
+		long(0) // end of the map
+	}
+	str(e.SchemaVersion)
+	str(e.ID)
+	str(string(e.Type))
+	millis(e.Time)
+	if a := e.Alarm; a != nil {
+		long(1)
+		str(a.Service)
+		labels(a.Labels)
+		millis(a.ActiveSince)
+		str(a.AcknowledgedBy)
+		str(a.Comment)
+		if a.ResolvedAt != nil {
+			long(1)
+			millis(*a.ResolvedAt)
+		} else {
+			long(0)
+		}
+	} else {
+		long(0)
+	}
+	if s := e.Silence; s != nil {
+		long(1)
+		str(s.ID)
+		str(s.Match)
+		labels(s.Labels)
+		millis(s.StartsAt)
+		millis(s.EndsAt)
+		str(s.CreatedBy)
+		str(s.Comment)
+	} else {
+		long(0)
+	}
+	if h := e.Heartbeat; h != nil {
+		long(1)
+		str(h.Service)
+		labels(h.Labels)
+	} else {
+		long(0)
+	}
+	return b
+}
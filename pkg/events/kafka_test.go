@@ -0,0 +1,45 @@
+package events
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestAvro compares the encoding with one derived by hand from the avro spec and kafkaAvroSchema
+func TestAvro(t *testing.T) {
+	t0 := time.Unix(1600000000, 0).UTC()
+	resolved := t0.Add(2 * time.Second)
+	for _, test := range []struct {
+		event    Event
+		expected string
+	}{
+		{
+			event: Event{SchemaVersion: "1", ID: "id", Type: AlarmResolved, Time: resolved,
+				Alarm: &Alarm{Service: "db", Labels: map[string]string{"env": "prod"}, ActiveSince: t0, ResolvedAt: &resolved}},
+			expected: "0231" + "046964" + "1c616c61726d2e7265736f6c766564" + "a09ff4f6905d" +
+				"02" + "046462" + "02" + "06656e76" + "0870726f64" + "00" + "8080f4f6905d" + "00" + "00" + "02" + "a09ff4f6905d" + // alarm
+				"00" + // no silence
+				"00", // no heartbeat
+		},
+		{
+			event: Event{SchemaVersion: "1", ID: "id2", Type: SilenceCreated, Time: t0,
+				Silence: &Silence{ID: "sil", Match: "db*", StartsAt: t0, EndsAt: t0.Add(time.Hour), CreatedBy: "ops", Comment: "deploy"}},
+			expected: "0231" + "06696432" + "1e73696c656e63652e63726561746564" + "8080f4f6905d" +
+				"00" +
+				"02" + "0673696c" + "0664622a" + "00" + "8080f4f6905d" + "80baabfa905d" + "066f7073" + "0c6465706c6f79" +
+				"00",
+		},
+		{
+			event: Event{SchemaVersion: "1", ID: "id3", Type: HeartbeatReceived, Time: t0,
+				Heartbeat: &Heartbeat{Service: "db", Labels: map[string]string{"env": "prod"}}},
+			expected: "0231" + "06696433" + "246865617274626561742e7265636569766564" + "8080f4f6905d" +
+				"00" + "00" +
+				"02" + "046462" + "02" + "06656e76" + "0870726f64" + "00",
+		},
+	} {
+		if got := hex.EncodeToString(test.event.avro()); got != test.expected {
+			t.Errorf("%s: want\n%s\ngot\n%s", test.event.Type, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	webhookQueueSize = 256
+	webhookAttempts  = 5
+)
+
+// NewEmitter creates an emitter which sends the events to all configured lifecycle webhooks
+func NewEmitter(ctx context.Context, cfgs []config.LifecycleWebhookConfig) Emitter {
+	emitters := make(Multi, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		emitters = append(emitters, NewWebhookEmitter(ctx, cfg))
+	}
+	return emitters
+}
+
+// NewWebhookEmitter creates an emitter which posts the events to the webhook in the background.
+// Failed requests are retried with backoff, events are dropped if the webhook can't keep up.
+func NewWebhookEmitter(ctx context.Context, cfg config.LifecycleWebhookConfig) Emitter {
+	w := &webhookEmitter{
+		cfg:    cfg,
+		events: make(chan Event, webhookQueueSize),
+		cli: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+	go w.run(ctx)
+	return w
+}
+
+type webhookEmitter struct {
+	cfg    config.LifecycleWebhookConfig
+	events chan Event
+	cli    *http.Client
+}
+
+func (w *webhookEmitter) Emit(ctx context.Context, event Event) {
+	if !w.wants(event.Type) {
+		return
+	}
+	select {
+	case w.events <- event:
+	default:
+		log.Error().Str("url", w.cfg.URL).Str("event", string(event.Type)).Msg("lifecycle webhook queue is full, drop event")
+	}
+}
+
+func (w *webhookEmitter) wants(eventType Type) bool {
+	// heartbeats are far more frequent than the other events
+	if len(w.cfg.Events) == 0 {
+		return eventType != HeartbeatReceived
+	}
+	for _, t := range w.cfg.Events {
+		if Type(t) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *webhookEmitter) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.events:
+			w.deliver(ctx, event)
+		}
+	}
+}
+
+func (w *webhookEmitter) deliver(ctx context.Context, event Event) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := w.post(ctx, event)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Error().Err(err).Str("url", w.cfg.URL).Str("event", string(event.Type)).Msg("failed to send lifecycle event, giving up")
+			return
+		}
+		log.Warn().Err(err).Str("url", w.cfg.URL).Str("event", string(event.Type)).Msg("failed to send lifecycle event, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhookEmitter) post(ctx context.Context, event Event) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	for key, values := range w.cfg.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
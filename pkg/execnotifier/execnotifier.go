@@ -0,0 +1,130 @@
+// Package execnotifier implements the exec notification type, which runs a local command for every notification.
+//
+// The commands are declared in the server config and notifications refer to them by name, so the API can't run
+// arbitrary commands. A command gets the event in its environment
+//
+//	SERVICE_ID, EVENT (alert, recovery, warning, ...), LAST_HEARTBEAT (RFC 3339, empty without heartbeat), DETAILS, LINK
+//
+// and the message as JSON on stdin. A non-zero exit code fails the notification.
+package execnotifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// maxOutputLength limits the output of a failed command in the error
+	maxOutputLength = 1024
+)
+
+// Register registers the exec notification type if commands are declared
+func Register(cfg config.ExecConfig) error {
+	if len(cfg.Commands) == 0 {
+		return nil
+	}
+	sender := &Sender{commands: make(map[string]config.ExecCommandConfig)}
+	for _, command := range cfg.Commands {
+		if command.Name == "" || len(command.Command) == 0 {
+			return errors.New("exec commands need a name and a command")
+		}
+		if _, ok := sender.commands[command.Name]; ok {
+			return fmt.Errorf("the exec command %s is declared twice", command.Name)
+		}
+		if command.Timeout <= 0 {
+			command.Timeout = config.Duration(defaultTimeout)
+		}
+		sender.commands[command.Name] = command
+	}
+	return notifier.RegisterSender(config.NotificationTypeExec, sender)
+}
+
+// Sender implements notifier.Sender by running the declared commands
+type Sender struct {
+	commands map[string]config.ExecCommandConfig
+}
+
+func (s *Sender) Validate(cfg interface{}) error {
+	_, err := s.command(cfg)
+	return err
+}
+
+func (s *Sender) Send(ctx context.Context, msg notifier.Message) error {
+	command, err := s.command(msg.Config)
+	if err != nil {
+		return err
+	}
+	msg.Config = nil
+	// commands have no business with the ping token
+	msg.Service.Token = ""
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	lastHeartbeat := ""
+	if msg.LastHeartbeat != nil {
+		lastHeartbeat = msg.LastHeartbeat.UTC().Format(time.RFC3339)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(command.Timeout))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command.Command[0], command.Command[1:]...)
+	cmd.Dir = command.Dir
+	cmd.Env = append(append(os.Environ(), command.Env...),
+		"SERVICE_ID="+msg.Service.ID,
+		"EVENT="+msg.Kind,
+		"LAST_HEARTBEAT="+lastHeartbeat,
+		"DETAILS="+msg.Details,
+		"LINK="+msg.Link,
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("exec command %s timed out after %s", command.Name, time.Duration(command.Timeout))
+	}
+	if err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxOutputLength {
+			out = out[len(out)-maxOutputLength:]
+		}
+		return fmt.Errorf("exec command %s failed: %v: %s", command.Name, err, out)
+	}
+	return nil
+}
+
+// command returns the declared command the notification config refers to
+func (s *Sender) command(cfg interface{}) (config.ExecCommandConfig, error) {
+	bs, err := json.Marshal(cfg)
+	if err != nil {
+		return config.ExecCommandConfig{}, err
+	}
+	var notification config.ExecNotificationConfig
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&notification)
+	if err != nil {
+		return config.ExecCommandConfig{}, err
+	}
+	if notification.Command == "" {
+		return config.ExecCommandConfig{}, errors.New("command is required")
+	}
+	command, ok := s.commands[notification.Command]
+	if !ok {
+		return config.ExecCommandConfig{}, fmt.Errorf("unknown command %q, it must be declared in the exec config of the server", notification.Command)
+	}
+	return command, nil
+}
@@ -0,0 +1,254 @@
+// Package forward posts the heartbeats of services to the URLs of their forward configs.
+//
+// Every service and URL gets its own queue and worker, which collects the heartbeats into batches.
+// Forwarding never blocks the heartbeat: a heartbeat is dropped when its queue is full, and a slow
+// or failing URL only delays its own batches.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/egress"
+)
+
+const (
+	queueSize            = 1024
+	defaultBatchSize     = 100
+	defaultBatchInterval = 5 * time.Second
+	attempts             = 3
+	// idleTimeout stops the worker of a URL which didn't get heartbeats for a while
+	idleTimeout = 5 * time.Minute
+)
+
+// Heartbeat is a forwarded heartbeat. A JSON payload is embedded as it is, any other payload is sent as a string.
+type Heartbeat struct {
+	Service string          `json:"service"`
+	Replica string          `json:"replica,omitempty"`
+	Time    time.Time       `json:"time"`
+	Query   url.Values      `json:"query,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Body    string          `json:"body,omitempty"`
+}
+
+// NewHeartbeat creates a heartbeat with the payload
+func NewHeartbeat(service, replica string, t time.Time, query url.Values, payload []byte) Heartbeat {
+	hb := Heartbeat{
+		Service: service,
+		Replica: replica,
+		Time:    t.UTC(),
+		Query:   query,
+	}
+	if len(bytes.TrimSpace(payload)) == 0 {
+		return hb
+	}
+	if json.Valid(payload) {
+		hb.Payload = payload
+	} else {
+		hb.Body = string(payload)
+	}
+	return hb
+}
+
+// Stats are the counters of a service since the start of this instance
+type Stats struct {
+	Service   string `json:"service"`
+	Forwarded int    `json:"forwarded"`
+	Failed    int    `json:"failed"`
+	Dropped   int    `json:"dropped"`
+}
+
+// Forwarder manages the workers of all forwarded services
+type Forwarder struct {
+	ctx     context.Context
+	cli     *http.Client
+	mutex   sync.Mutex
+	workers map[string]*worker
+	stats   map[string]*Stats
+}
+
+// NewForwarder creates the forwarder, it only connects where the egress policy allows, which may be nil
+func NewForwarder(ctx context.Context, egress *egress.Policy) *Forwarder {
+	return &Forwarder{
+		ctx: ctx,
+		cli: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: egress.Transport(),
+		},
+		workers: make(map[string]*worker),
+		stats:   make(map[string]*Stats),
+	}
+}
+
+// Forward queues the heartbeat for the forward URL of the service, it never blocks
+func (f *Forwarder) Forward(cfg config.ForwardConfig, hb Heartbeat) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := hb.Service + " " + cfg.URL
+	w, ok := f.workers[key]
+	if !ok {
+		w = &worker{
+			key:        key,
+			heartbeats: make(chan Heartbeat, queueSize),
+		}
+		f.workers[key] = w
+		go f.run(w)
+	}
+	// the latest config of the service applies to the next batch
+	w.cfg = cfg
+	select {
+	case w.heartbeats <- hb:
+	default:
+		f.count(hb.Service, func(s *Stats) { s.Dropped++ })
+		log.Error().Str("service", hb.Service).Str("url", cfg.URL).Msg("forward queue is full, drop heartbeat")
+	}
+}
+
+// Stats returns the counters of all services which forwarded heartbeats, ordered by service
+func (f *Forwarder) Stats() []Stats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	stats := make([]Stats, 0, len(f.stats))
+	for _, s := range f.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Service < stats[j].Service })
+	return stats
+}
+
+// count updates the stats of a service, the caller holds the mutex
+func (f *Forwarder) count(service string, update func(s *Stats)) {
+	s, ok := f.stats[service]
+	if !ok {
+		s = &Stats{Service: service}
+		f.stats[service] = s
+	}
+	update(s)
+}
+
+type worker struct {
+	key        string
+	cfg        config.ForwardConfig
+	heartbeats chan Heartbeat
+}
+
+// run collects the heartbeats of a worker into batches until it is idle
+func (f *Forwarder) run(w *worker) {
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-idle.C:
+			f.mutex.Lock()
+			if len(w.heartbeats) == 0 {
+				delete(f.workers, w.key)
+				f.mutex.Unlock()
+				return
+			}
+			f.mutex.Unlock()
+		case hb := <-w.heartbeats:
+			f.collect(w, hb)
+		}
+		idle.Reset(idleTimeout)
+	}
+}
+
+// collect fills a batch starting with hb until it is full or the batch interval passed, and sends it
+func (f *Forwarder) collect(w *worker, hb Heartbeat) {
+	f.mutex.Lock()
+	cfg := w.cfg
+	f.mutex.Unlock()
+	size := cfg.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	interval := time.Duration(cfg.BatchInterval)
+	if interval <= 0 {
+		interval = defaultBatchInterval
+	}
+	batch := []Heartbeat{hb}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+collect:
+	for len(batch) < size {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-timer.C:
+			break collect
+		case hb := <-w.heartbeats:
+			batch = append(batch, hb)
+		}
+	}
+	err := f.deliver(cfg, batch)
+	f.mutex.Lock()
+	f.count(hb.Service, func(s *Stats) {
+		if err != nil {
+			s.Failed += len(batch)
+		} else {
+			s.Forwarded += len(batch)
+		}
+	})
+	f.mutex.Unlock()
+	if err != nil {
+		log.Error().Err(err).Str("service", hb.Service).Str("url", cfg.URL).Int("heartbeats", len(batch)).Msg("failed to forward heartbeats, giving up")
+	}
+}
+
+func (f *Forwarder) deliver(cfg config.ForwardConfig, batch []Heartbeat) error {
+	bs, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = f.post(cfg, bs)
+		if err == nil || attempt == attempts {
+			return err
+		}
+		log.Warn().Err(err).Str("url", cfg.URL).Msg("failed to forward heartbeats, retrying")
+		select {
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (f *Forwarder) post(cfg config.ForwardConfig, body []byte) error {
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(f.ctx, method, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range cfg.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package gcp calls Google Cloud APIs without the client libraries. Access tokens come from a service account
+// key, like the one GOOGLE_APPLICATION_CREDENTIALS points to, or from the metadata server on GCE, GKE and Cloud Run.
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshBefore renews the tokens before they expire
+	refreshBefore    = 5 * time.Minute
+	defaultTokenURI  = "https://oauth2.googleapis.com/token"
+	metadataHost     = "metadata.google.internal"
+	tokenLifetime    = time.Hour
+	jwtBearerGrant   = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	metadataTokenURL = "/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// serviceAccountKey is the part of the JSON key of a service account we need
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// TokenSource returns access tokens for a scope and caches them until they expire
+type TokenSource struct {
+	cli *http.Client
+	// credentialsFile is a service account key, without one GOOGLE_APPLICATION_CREDENTIALS or the metadata server are used
+	credentialsFile string
+	scope           string
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewTokenSource creates a token source for the scope like https://www.googleapis.com/auth/pubsub
+func NewTokenSource(cli *http.Client, credentialsFile, scope string) *TokenSource {
+	return &TokenSource{cli: cli, credentialsFile: credentialsFile, scope: scope}
+}
+
+// Token returns the cached token or fetches a new one
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.token != "" && now.Add(refreshBefore).Before(s.expires) {
+		return s.token, nil
+	}
+	file := s.credentialsFile
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	var (
+		token     string
+		expiresIn int
+		err       error
+	)
+	if file != "" {
+		token, expiresIn, err = s.serviceAccountToken(ctx, file, now)
+	} else {
+		token, expiresIn, err = s.metadataToken(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, now.Add(time.Duration(expiresIn)*time.Second)
+	return token, nil
+}
+
+// serviceAccountToken exchanges a JWT signed with the key of the service account for an access token
+func (s *TokenSource) serviceAccountToken(ctx context.Context, file string, now time.Time) (string, int, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", 0, err
+	}
+	var key serviceAccountKey
+	err = json.Unmarshal(bs, &key)
+	if err != nil {
+		// the error of the decoder could quote the file
+		return "", 0, fmt.Errorf("%s is no valid service account key", file)
+	}
+	if key.Type != "service_account" {
+		return "", 0, fmt.Errorf("%s is a %q key, only service account keys are supported", file, key.Type)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = defaultTokenURI
+	}
+	assertion, err := signJWT(key, s.scope, now)
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{"grant_type": {jwtBearerGrant}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.fetchToken(req)
+}
+
+// metadataToken fetches the token of the service account the workload runs as
+func (s *TokenSource) metadataToken(ctx context.Context) (string, int, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = metadataHost
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+metadataTokenURL+"?scopes="+url.QueryEscape(s.scope), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, expiresIn, err := s.fetchToken(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("no credentials file and the metadata server failed: %w", err)
+	}
+	return token, expiresIn, nil
+}
+
+func (s *TokenSource) fetchToken(req *http.Request) (string, int, error) {
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s answered %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("the token response has no access token")
+	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
+
+// signJWT returns the assertion of the service account for the scope, signed with RS256
+func signJWT(key serviceAccountKey, scope string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New("the service account key has no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("invalid private key: %w", err)
+		}
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key is not an RSA key")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": scope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
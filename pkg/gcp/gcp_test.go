@@ -0,0 +1,157 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestServiceAccountToken exchanges a JWT assertion (RFC 7523) for a token and verifies its RS256 signature
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if grant := r.FormValue("grant_type"); grant != jwtBearerGrant {
+			t.Errorf("unexpected grant type %q", grant)
+		}
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("invalid assertion %q", r.FormValue("assertion"))
+			return
+		}
+		var header struct {
+			Alg string `json:"alg"`
+			Typ string `json:"typ"`
+			Kid string `json:"kid"`
+		}
+		var claims struct {
+			Iss   string `json:"iss"`
+			Scope string `json:"scope"`
+			Aud   string `json:"aud"`
+			Iat   int64  `json:"iat"`
+			Exp   int64  `json:"exp"`
+		}
+		for i, v := range []interface{}{&header, &claims} {
+			bs, err := base64.RawURLEncoding.DecodeString(parts[i])
+			if err != nil || json.Unmarshal(bs, v) != nil {
+				t.Errorf("invalid part %q of the assertion", parts[i])
+				return
+			}
+		}
+		if header.Alg != "RS256" || header.Typ != "JWT" || header.Kid != "key-1" {
+			t.Errorf("unexpected header %+v", header)
+		}
+		if claims.Iss != "deadman@example.iam.gserviceaccount.com" || claims.Scope != PubSubScope || claims.Aud != srv.URL+"/token" || claims.Exp-claims.Iat != 3600 {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("invalid signature: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"ya29.token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer srv.Close()
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "key.json")
+	bs, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "deadman@example.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		"token_uri":      srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(file, bs, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenSource(srv.Client(), file, PubSubScope)
+	for i := 0; i < 2; i++ {
+		token, err := tokens.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "ya29.token" {
+			t.Fatalf("want the token ya29.token, got %q", token)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("want the token to be cached, got %d requests", requests)
+	}
+}
+
+// TestServiceAccountTokenInvalidKey doesn't quote the file in the error
+func TestServiceAccountTokenInvalidKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.json")
+	err := ioutil.WriteFile(file, []byte("secret: not json"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewTokenSource(http.DefaultClient, file, PubSubScope).Token(context.Background())
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("want an error without the content of the file, got %v", err)
+	}
+}
+
+// TestPublish checks the request of the publish call of the REST API
+func TestPublish(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/topics/t:publish" || r.Header.Get("Authorization") != "Bearer ya29.token" {
+			t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Messages []struct {
+				Data       string            `json:"data"`
+				Attributes map[string]string `json:"attributes"`
+			} `json:"messages"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || len(body.Messages) != 1 {
+			t.Errorf("unexpected body %+v (%v)", body, err)
+			return
+		}
+		// the data is base64 encoded in the JSON of the REST API
+		if body.Messages[0].Data != base64.StdEncoding.EncodeToString([]byte(`{"service":"backup"}`)) || body.Messages[0].Attributes["kind"] != "alert" {
+			t.Errorf("unexpected message %+v", body.Messages[0])
+		}
+		w.Write([]byte(`{"messageIds":["42"]}`))
+	}))
+	defer srv.Close()
+	ids, err := Publish(context.Background(), srv.Client(), srv.URL, "ya29.token", "projects/p/topics/t", []Message{
+		{Data: []byte(`{"service":"backup"}`), Attributes: map[string]string{"kind": "alert"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "42" {
+		t.Fatalf("want the message ID 42, got %v", ids)
+	}
+}
@@ -0,0 +1,79 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// PubSubScope is the OAuth scope of the Pub/Sub API
+	PubSubScope           = "https://www.googleapis.com/auth/pubsub"
+	defaultPubSubEndpoint = "https://pubsub.googleapis.com"
+)
+
+// Message is published to a topic
+type Message struct {
+	Data       []byte            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// PubSubEndpoint returns the endpoint of the API, the one of the emulator if PUBSUB_EMULATOR_HOST is set
+func PubSubEndpoint() (endpoint string, emulator bool) {
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		return "http://" + host, true
+	}
+	return defaultPubSubEndpoint, false
+}
+
+// Publish publishes the messages to the topic and returns their IDs. Without token the request isn't
+// authenticated, which only the emulator accepts.
+func Publish(ctx context.Context, cli *http.Client, endpoint, token, topic string, messages []Message) ([]string, error) {
+	body, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/v1/"+topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("pubsub answered %d: %s: %s", resp.StatusCode, apiErr.Error.Status, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("pubsub answered %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var result struct {
+		MessageIDs []string `json:"messageIds"`
+	}
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return nil, fmt.Errorf("malformed pubsub response: %w", err)
+	}
+	return result.MessageIDs, nil
+}
@@ -0,0 +1,378 @@
+// Package hooks runs user defined tengo scripts (https://github.com/d5/tengo) at key points
+// of the processing of a service, for logic which can't be expressed in the static config.
+//
+// Every hook gets the service config as the variable `service` and reports its decision
+// by assigning a predeclared variable:
+//
+//   - heartbeat: gets `heartbeat` ({time, query, headers}) and may set `accept = false` to reject it
+//   - alarm: gets `now`, `last_heartbeat` and `overdue` and may set `alarm` to override the decision
+//   - notification: gets `kind` ("alert", "recovery" or "warning") and `notification` ({type, config}),
+//     it may modify `notification` or set `send = false` to drop it
+//
+// The scripts don't see the credentials: the token and the ping auth of the service, the secrets of the
+// notification configs and the Authorization headers are blank or left out.
+//
+// The scripts may import the tengo standard library except for the os module. A failing script
+// fails closed: the heartbeat is rejected, the service alarms and the notification isn't sent.
+package hooks
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	timeout   = time.Second
+	maxAllocs = 100000
+	// cacheSize bounds the compiled scripts which are kept, the least recently used ones are dropped
+	cacheSize = 1000
+)
+
+// secretHeaders carry the credentials of the ping and of the notification endpoints, they are kept from the scripts
+var secretHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// secretKeys are the config fields which hold credentials, like the token of a service or the API key of Opsgenie.
+// The scripts see them blank.
+var secretKeys = map[string]bool{
+	"token":           true,
+	"password":        true,
+	"bearerToken":     true,
+	"secret":          true,
+	"authToken":       true,
+	"routingKey":      true,
+	"apiKey":          true,
+	"secretAccessKey": true,
+	"credentials":     true,
+}
+
+var modules = stdlib.GetModuleMap("math", "text", "times", "rand", "fmt", "json", "base64", "hex", "enum")
+
+// HeartbeatInfo describes a received heartbeat
+type HeartbeatInfo struct {
+	Time    time.Time
+	Query   url.Values
+	Headers http.Header
+}
+
+// Heartbeat runs the heartbeat hook of the service and reports whether the heartbeat is accepted,
+// it is rejected if the hook fails
+func Heartbeat(ctx context.Context, svc config.ServiceConfig, heartbeat HeartbeatInfo) (bool, error) {
+	if svc.Hooks == nil || svc.Hooks.Heartbeat == "" {
+		return true, nil
+	}
+	headers := flatten(heartbeat.Headers)
+	for _, name := range secretHeaders {
+		delete(headers, name)
+	}
+	query := flatten(heartbeat.Query)
+	delete(query, "token")
+	vars, err := run(ctx, svc, svc.Hooks.Heartbeat, map[string]interface{}{
+		"heartbeat": map[string]interface{}{
+			"time":    heartbeat.Time,
+			"query":   query,
+			"headers": headers,
+		},
+		"accept": true,
+	})
+	if err != nil {
+		return false, err
+	}
+	return vars.Get("accept").Bool(), nil
+}
+
+// Alarm runs the alarm hook of the service and reports whether the service is alarming,
+// it alarms if the hook fails
+func Alarm(ctx context.Context, svc config.ServiceConfig, now, lastHeartbeat time.Time, overdue bool) (bool, error) {
+	if svc.Hooks == nil || svc.Hooks.Alarm == "" {
+		return overdue, nil
+	}
+	vars, err := run(ctx, svc, svc.Hooks.Alarm, map[string]interface{}{
+		"now":            now,
+		"last_heartbeat": lastHeartbeat,
+		"overdue":        overdue,
+		"alarm":          overdue,
+	})
+	if err != nil {
+		return true, err
+	}
+	return vars.Get("alarm").Bool(), nil
+}
+
+// Notification runs the notification hook of the service. It returns the possibly modified
+// notification and whether it should be sent at all, a notification isn't sent if the hook fails.
+// The hook sees the secrets of the config blank, they are put back if it keeps the type and leaves them blank.
+// The returned notification is checked like a configured one.
+func Notification(ctx context.Context, svc config.ServiceConfig, kind string, notification config.NotificationConfig) (config.NotificationConfig, bool, error) {
+	if svc.Hooks == nil || svc.Hooks.Notification == "" {
+		return notification, true, nil
+	}
+	original, err := toPlain(notification.Config)
+	if err != nil {
+		return notification, false, err
+	}
+	cfg, err := toPlain(notification.Config)
+	if err != nil {
+		return notification, false, err
+	}
+	redact(cfg)
+	vars, err := run(ctx, svc, svc.Hooks.Notification, map[string]interface{}{
+		"kind": kind,
+		"notification": map[string]interface{}{
+			"type":   string(notification.Type),
+			"config": cfg,
+		},
+		"send": true,
+	})
+	if err != nil {
+		return notification, false, err
+	}
+	if !vars.Get("send").Bool() {
+		return notification, false, nil
+	}
+	result, ok := vars.Get("notification").Value().(map[string]interface{})
+	if !ok {
+		return notification, false, errors.New("hook didn't leave a notification map")
+	}
+	notificationType, _ := result["type"].(string)
+	if notificationType == string(notification.Type) {
+		restore(original, result["config"])
+	}
+	modified, errs := config.NotificationConfig{
+		Type:   config.NotificationType(notificationType),
+		Config: result["config"],
+		Direct: notification.Direct,
+	}.Normalize()
+	if len(errs) > 0 {
+		return notification, false, fmt.Errorf("hook left an invalid notification: %v", errs)
+	}
+	return modified, true, nil
+}
+
+// Validate compiles all hooks of the service
+func Validate(svc config.ServiceConfig) error {
+	if svc.Hooks == nil {
+		return nil
+	}
+	for name, script := range map[string]string{
+		"heartbeat":    svc.Hooks.Heartbeat,
+		"alarm":        svc.Hooks.Alarm,
+		"notification": svc.Hooks.Notification,
+	} {
+		if script == "" {
+			continue
+		}
+		_, err := compile(script)
+		if err != nil {
+			return fmt.Errorf("invalid %s hook: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// variables are the variables of a finished script run
+type variables interface {
+	Get(name string) *tengo.Variable
+}
+
+func run(ctx context.Context, svc config.ServiceConfig, script string, vars map[string]interface{}) (variables, error) {
+	compiled, err := compile(script)
+	if err != nil {
+		return nil, err
+	}
+	svc.Token = ""
+	svc.PingAuth = nil
+	service, err := toPlain(svc)
+	if err != nil {
+		return nil, err
+	}
+	redact(service)
+	compiled = compiled.Clone()
+	vars["service"] = service
+	for name, value := range vars {
+		err = compiled.Set(name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = compiled.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// cachedScript is an element of the LRU list of compiled scripts
+type cachedScript struct {
+	script   string
+	compiled *tengo.Compiled
+}
+
+var (
+	cacheMutex sync.Mutex
+	cache      = make(map[string]*list.Element)
+	// cacheLRU holds the cached scripts, the most recently used first
+	cacheLRU = list.New()
+)
+
+// compile returns the compiled script, scripts are only compiled once while they are in the cache
+func compile(script string) (*tengo.Compiled, error) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if element, ok := cache[script]; ok {
+		cacheLRU.MoveToFront(element)
+		return element.Value.(*cachedScript).compiled, nil
+	}
+	s := tengo.NewScript([]byte(script))
+	s.SetImports(modules)
+	s.SetMaxAllocs(maxAllocs)
+	for _, name := range []string{"service", "heartbeat", "accept", "now", "last_heartbeat", "overdue", "alarm", "kind", "notification", "send"} {
+		err := s.Add(name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	compiled, err := s.Compile()
+	if err != nil {
+		return nil, err
+	}
+	cache[script] = cacheLRU.PushFront(&cachedScript{script: script, compiled: compiled})
+	if cacheLRU.Len() > cacheSize {
+		oldest := cacheLRU.Back()
+		cacheLRU.Remove(oldest)
+		delete(cache, oldest.Value.(*cachedScript).script)
+	}
+	return compiled, nil
+}
+
+// toPlain converts v into maps, slices and primitives via its JSON representation
+func toPlain(v interface{}) (interface{}, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var plain interface{}
+	err = json.Unmarshal(bs, &plain)
+	if err != nil {
+		return nil, errors.New("failed to convert value for hook")
+	}
+	return plain, nil
+}
+
+// redact blanks the secret keys and drops the secret headers in a value made by toPlain.
+// Labels are left alone, their keys are chosen freely.
+func redact(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			switch {
+			case key == "labels":
+			case key == "headers":
+				if headers, ok := value.(map[string]interface{}); ok {
+					for name := range headers {
+						if isSecretHeader(name) {
+							delete(headers, name)
+						}
+					}
+				}
+			case secretKeys[key]:
+				if _, ok := value.(string); ok {
+					v[key] = ""
+				}
+			default:
+				redact(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			redact(value)
+		}
+	}
+}
+
+// restore puts the secrets of original back into the result of a hook where the hook left them blank.
+// Secrets which belong to a URL the hook pointed to another host aren't put back, so they can't be sent elsewhere.
+func restore(original, result interface{}) {
+	switch original := original.(type) {
+	case map[string]interface{}:
+		modified, ok := result.(map[string]interface{})
+		if !ok {
+			return
+		}
+		originalURL, _ := original["url"].(string)
+		modifiedURL, _ := modified["url"].(string)
+		sameEndpoint := origin(originalURL) == origin(modifiedURL)
+		for key, value := range original {
+			switch {
+			case key == "labels":
+			case !sameEndpoint && (key == "headers" || secretKeys[key]):
+			case key == "headers":
+				headers, ok := value.(map[string]interface{})
+				modifiedHeaders, isMap := modified[key].(map[string]interface{})
+				if !ok || !isMap {
+					continue
+				}
+				for name, values := range headers {
+					if _, set := modifiedHeaders[name]; isSecretHeader(name) && !set {
+						modifiedHeaders[name] = values
+					}
+				}
+			case secretKeys[key]:
+				if blank, ok := modified[key].(string); ok && blank == "" {
+					modified[key] = value
+				}
+			default:
+				restore(value, modified[key])
+			}
+		}
+	case []interface{}:
+		modified, ok := result.([]interface{})
+		if !ok || len(modified) != len(original) {
+			return
+		}
+		for i, value := range original {
+			restore(value, modified[i])
+		}
+	}
+}
+
+// origin returns the scheme and the host of a URL, the URL itself if it can't be parsed
+func origin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func isSecretHeader(name string) bool {
+	for _, secret := range secretHeaders {
+		if http.CanonicalHeaderKey(name) == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten keeps the first value of every key
+func flatten(values map[string][]string) map[string]interface{} {
+	flat := make(map[string]interface{}, len(values))
+	for key, v := range values {
+		if len(v) > 0 {
+			flat[key] = v[0]
+		}
+	}
+	return flat
+}
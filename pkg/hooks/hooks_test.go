@@ -0,0 +1,178 @@
+package hooks
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// secretService holds a credential in every place a script must not see it
+func secretService(hooks config.HooksConfig) config.ServiceConfig {
+	return config.ServiceConfig{
+		ID:       "backup",
+		Token:    "service-token",
+		PingAuth: &config.PingAuthConfig{Username: "agent", Password: "ping-password", BearerToken: "ping-bearer"},
+		Labels:   map[string]string{"token": "a label"},
+		AlertNotifications: []config.NotificationConfig{{
+			Type:   config.NotificationTypeOpsgenie,
+			Config: map[string]interface{}{"apiKey": "opsgenie-key"},
+		}},
+		Callback: &config.CallbackConfig{
+			URL:     "https://backup.example.com/overdue",
+			Headers: map[string][]string{"Authorization": {"Bearer callback-token"}},
+		},
+		Hooks: &hooks,
+	}
+}
+
+func TestHeartbeatHidesSecrets(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		accept bool
+	}{
+		{"service token", `accept = service.token == ""`, true},
+		{"ping auth", `accept = is_undefined(service.pingAuth)`, true},
+		{"notification secret", `accept = service.alertNotifications[0].Config.apiKey == ""`, true},
+		{"callback authorization", `accept = is_undefined(service.callback.headers.Authorization)`, true},
+		{"labels are kept", `accept = service.labels.token == "a label"`, true},
+		{"query token", `accept = is_undefined(heartbeat.query.token) && heartbeat.query.run == "42"`, true},
+		{"authorization header", `accept = is_undefined(heartbeat.headers.Authorization) && heartbeat.headers["X-Agent"] == "cron"`, true},
+		{"failing script", `accept = 1 / 0`, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			svc := secretService(config.HooksConfig{Heartbeat: test.script})
+			accept, err := Heartbeat(context.Background(), svc, HeartbeatInfo{
+				Time:    time.Now(),
+				Query:   url.Values{"token": {"service-token"}, "run": {"42"}},
+				Headers: http.Header{"Authorization": {"Basic c2VjcmV0"}, "X-Agent": {"cron"}},
+			})
+			if accept != test.accept {
+				t.Fatalf("expected accept %v, got %v (%v)", test.accept, accept, err)
+			}
+		})
+	}
+}
+
+func TestNotification(t *testing.T) {
+	webhook := config.NotificationConfig{
+		Type: config.NotificationTypeWebhook,
+		Config: map[string]interface{}{
+			"url":     "https://hooks.example.com/alert",
+			"headers": map[string]interface{}{"Authorization": []interface{}{"Bearer webhook-token"}},
+		},
+	}
+	opsgenie := config.NotificationConfig{
+		Type:   config.NotificationTypeOpsgenie,
+		Config: map[string]interface{}{"apiKey": "opsgenie-key", "priority": "P3"},
+	}
+	for _, test := range []struct {
+		name         string
+		script       string
+		notification config.NotificationConfig
+		send         bool
+		fails        bool
+		// check is the expected config, unchecked if nil
+		check map[string]interface{}
+	}{
+		{
+			name:         "secrets are blank and put back",
+			script:       `send = notification.config.apiKey == ""; notification.config.priority = "P1"`,
+			notification: opsgenie,
+			send:         true,
+			check:        map[string]interface{}{"apiKey": "opsgenie-key", "priority": "P1"},
+		},
+		{
+			name:         "secret headers are left out and put back",
+			script:       `send = is_undefined(notification.config.headers.Authorization); notification.config.body = "overdue"`,
+			notification: webhook,
+			send:         true,
+			check: map[string]interface{}{
+				"url":     "https://hooks.example.com/alert",
+				"headers": map[string]interface{}{"Authorization": []interface{}{"Bearer webhook-token"}},
+				"body":    "overdue",
+			},
+		},
+		{
+			name:         "secrets are kept for a changed path",
+			script:       `notification.config.url += "?kind=" + kind`,
+			notification: webhook,
+			send:         true,
+			check: map[string]interface{}{
+				"url":     "https://hooks.example.com/alert?kind=alert",
+				"headers": map[string]interface{}{"Authorization": []interface{}{"Bearer webhook-token"}},
+			},
+		},
+		{
+			name:         "secrets aren't sent to another host",
+			script:       `notification.config.url = "https://elsewhere.example.com/"`,
+			notification: webhook,
+			send:         true,
+			check: map[string]interface{}{
+				"url":     "https://elsewhere.example.com/",
+				"headers": map[string]interface{}{},
+			},
+		},
+		{
+			name:         "a replaced config gets the secrets back",
+			script:       `notification.type = "opsgenie"; notification.config = {apiKey: ""}`,
+			notification: opsgenie,
+			send:         true,
+			check:        map[string]interface{}{"apiKey": "opsgenie-key"},
+		},
+		{
+			name:         "a changed type is checked",
+			script:       `notification.type = "pagerduty"`,
+			notification: opsgenie,
+			fails:        true,
+		},
+		{
+			name:         "an invalid config is rejected",
+			script:       `notification.config.url = "not a url"`,
+			notification: webhook,
+			fails:        true,
+		},
+		{
+			name:         "an unknown field is rejected",
+			script:       `notification.config.bogus = true`,
+			notification: opsgenie,
+			fails:        true,
+		},
+		{
+			name:         "dropped",
+			script:       `send = false`,
+			notification: opsgenie,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			svc := secretService(config.HooksConfig{Notification: test.script})
+			notification, send, err := Notification(context.Background(), svc, "alert", test.notification)
+			if test.fails {
+				if err == nil || send {
+					t.Fatalf("expected the hook to fail closed, got send %v and %v", send, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if send != test.send {
+				t.Fatalf("expected send %v, got %v", test.send, send)
+			}
+			if test.check == nil {
+				return
+			}
+			cfg, _ := notification.Config.(map[string]interface{})
+			for key, expected := range test.check {
+				if !reflect.DeepEqual(cfg[key], expected) {
+					t.Errorf("expected %s to be %#v, got %#v", key, expected, cfg[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Package ids generates the random IDs of the stored objects, events and connections.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// sortableLayout makes IDs which start with the time sort by it
+const sortableLayout = "20060102T150405Z"
+
+// Random returns n random bytes, hex encoded
+func Random(n int) (string, error) {
+	bs := make([]byte, n)
+	_, err := rand.Read(bs)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// Sortable returns a random ID which sorts by the time t, like 20200601T100000Z-8f3a2b1c4d5e6f70
+func Sortable(t time.Time) (string, error) {
+	suffix, err := Random(8)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(sortableLayout) + "-" + suffix, nil
+}
@@ -0,0 +1,254 @@
+// Package incidents groups the alarms of related services into incidents,
+// so one infrastructure failure shows up as one incident instead of dozens of alarms.
+//
+// The Manager is an events.Emitter and builds the incidents from the alarm lifecycle events.
+package incidents
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultWindow    = 5 * time.Minute
+	defaultRetention = 30 * 24 * time.Hour
+	pruneInterval    = time.Hour
+	queueSize        = 256
+	lockKey          = "/deadman-switch/incidents"
+)
+
+type Manager struct {
+	store       storage.Storage
+	concurrency concurrency.Client
+	window      time.Duration
+	retention   time.Duration
+	groupBy     []string
+	events      chan events.Event
+}
+
+func NewManager(ctx context.Context, store storage.Storage, concurrency concurrency.Client, cfg config.IncidentsConfig) *Manager {
+	m := &Manager{
+		store:       store,
+		concurrency: concurrency,
+		window:      time.Duration(cfg.Window),
+		retention:   time.Duration(cfg.Retention),
+		groupBy:     cfg.GroupBy,
+		events:      make(chan events.Event, queueSize),
+	}
+	if m.window <= 0 {
+		m.window = defaultWindow
+	}
+	if m.retention <= 0 {
+		m.retention = defaultRetention
+	}
+	go m.run(ctx)
+	return m
+}
+
+// Emit implements events.Emitter
+func (m *Manager) Emit(ctx context.Context, event events.Event) {
+	if event.Alarm == nil {
+		return
+	}
+	select {
+	case m.events <- event:
+	default:
+		log.Error().Str("event", string(event.Type)).Msg("incident queue is full, drop event")
+	}
+}
+
+func (m *Manager) run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := m.prune(ctx, time.Now())
+			if err != nil {
+				log.Error().Err(err).Msg("failed to prune incidents")
+			}
+		case event := <-m.events:
+			err := m.handle(ctx, event)
+			if err != nil {
+				log.Error().Err(err).Str("event", string(event.Type)).Str("service", event.Alarm.Service).Msg("failed to update incidents")
+			}
+		}
+	}
+}
+
+// lock serializes the changes of the incidents in the cluster until unlock is called
+func (m *Manager) lock(ctx context.Context) (unlock func(), err error) {
+	lockCtx, unlock := context.WithCancel(ctx)
+	if m.concurrency != nil {
+		err = m.concurrency.Lock(lockCtx, lockKey)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	return unlock, nil
+}
+
+// prune deletes the incidents which were resolved longer than the retention ago
+func (m *Manager) prune(ctx context.Context, now time.Time) error {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	all, err := m.store.GetIncidents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, incident := range all {
+		if incident.Status != storage.IncidentStatusResolved || incident.ResolvedAt == nil || now.Sub(*incident.ResolvedAt) < m.retention {
+			continue
+		}
+		err = m.store.DeleteIncident(ctx, incident.ID)
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		log.Info().Str("incident", incident.ID).Msg("pruned resolved incident")
+	}
+	return nil
+}
+
+func (m *Manager) handle(ctx context.Context, event events.Event) error {
+	// alarms are created by the leader but resolved by whoever receives the ping, so serialize the updates
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	switch event.Type {
+	case events.AlarmCreated:
+		return m.alarmCreated(ctx, event)
+	case events.AlarmAcknowledged:
+		return m.updateIncidentOf(ctx, event, func(incident *storage.Incident) {
+			if incident.Status == storage.IncidentStatusOpen {
+				incident.Status = storage.IncidentStatusAcknowledged
+			}
+		})
+	case events.AlarmResolved:
+		return m.updateIncidentOf(ctx, event, func(incident *storage.Incident) {
+			incident.Alarms[event.Alarm.Service] = false
+			for _, active := range incident.Alarms {
+				if active {
+					return
+				}
+			}
+			incident.Status = storage.IncidentStatusResolved
+			incident.ResolvedAt = &event.Time
+		})
+	}
+	return nil
+}
+
+func (m *Manager) alarmCreated(ctx context.Context, event events.Event) error {
+	open, err := m.openIncidents(ctx)
+	if err != nil {
+		return err
+	}
+	labels := m.groupLabels(event.Alarm.Labels)
+	var incident *storage.Incident
+	for idx := range open {
+		if equalLabels(open[idx].Labels, labels) && event.Time.Sub(lastAlarm(open[idx])) <= m.window {
+			incident = &open[idx]
+			break
+		}
+	}
+	if incident == nil {
+		id, err := ids.Sortable(event.Time)
+		if err != nil {
+			return err
+		}
+		incident = &storage.Incident{
+			ID:        id,
+			Status:    storage.IncidentStatusOpen,
+			Labels:    labels,
+			Alarms:    make(map[string]bool),
+			CreatedAt: event.Time,
+		}
+		log.Info().Str("incident", incident.ID).Str("service", event.Alarm.Service).Msg("opened incident")
+	}
+	incident.Alarms[event.Alarm.Service] = true
+	incident.Timeline = append(incident.Timeline, timelineEntry(event))
+	return m.store.SaveIncident(ctx, *incident)
+}
+
+// updateIncidentOf applies fn to the open incident containing the alarm of the event's service
+func (m *Manager) updateIncidentOf(ctx context.Context, event events.Event, fn func(*storage.Incident)) error {
+	open, err := m.openIncidents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, incident := range open {
+		if !incident.Alarms[event.Alarm.Service] {
+			continue
+		}
+		fn(&incident)
+		incident.Timeline = append(incident.Timeline, timelineEntry(event))
+		return m.store.SaveIncident(ctx, incident)
+	}
+	return nil
+}
+
+func (m *Manager) openIncidents(ctx context.Context) ([]storage.Incident, error) {
+	return m.store.GetOpenIncidents(ctx)
+}
+
+func (m *Manager) groupLabels(labels map[string]string) map[string]string {
+	group := make(map[string]string, len(m.groupBy))
+	for _, key := range m.groupBy {
+		group[key] = labels[key]
+	}
+	return group
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// lastAlarm returns the time the last alarm was added to the incident
+func lastAlarm(incident storage.Incident) time.Time {
+	last := incident.CreatedAt
+	for _, entry := range incident.Timeline {
+		if entry.Type == string(events.AlarmCreated) && entry.Time.After(last) {
+			last = entry.Time
+		}
+	}
+	return last
+}
+
+func timelineEntry(event events.Event) storage.IncidentTimelineEntry {
+	entry := storage.IncidentTimelineEntry{
+		Time:    event.Time,
+		Type:    string(event.Type),
+		Service: event.Alarm.Service,
+	}
+	if event.Type == events.AlarmAcknowledged {
+		entry.Message = fmt.Sprintf("acknowledged by %s", event.Alarm.AcknowledgedBy)
+		if event.Alarm.Comment != "" {
+			entry.Message += ": " + event.Alarm.Comment
+		}
+	}
+	return entry
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTime is the time of the records in the recorded batches
+var testTime = time.Unix(0, 1600000000000*int64(time.Millisecond))
+
+// request is a request received by the fake broker
+type request struct {
+	apiKey, apiVersion int16
+	correlationID      int32
+	clientID           string
+	body               []byte
+}
+
+// fakeBroker answers the requests on all its connections with the responses of handle
+type fakeBroker struct {
+	t      *testing.T
+	l      net.Listener
+	host   string
+	port   int32
+	handle func(req request) []byte
+
+	mutex    sync.Mutex
+	requests []request
+}
+
+func newFakeBroker(t *testing.T, handle func(req request) []byte) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	b := &fakeBroker{t: t, l: l, host: host, port: int32(p), handle: handle}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return b
+}
+
+func (b *fakeBroker) address() string {
+	return b.l.Addr().String()
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			return
+		}
+		d := &decoder{b: frame}
+		req := request{apiKey: d.int16(), apiVersion: d.int16(), correlationID: d.int32(), clientID: d.nullableString()}
+		req.body = d.b
+		b.mutex.Lock()
+		b.requests = append(b.requests, req)
+		b.mutex.Unlock()
+		var resp encoder
+		resp.int32(0)
+		resp.int32(req.correlationID)
+		resp.raw(b.handle(req))
+		binary.BigEndian.PutUint32(resp.b, uint32(len(resp.b)-4))
+		if _, err := conn.Write(resp.b); err != nil {
+			return
+		}
+	}
+}
+
+// metadata answers a metadata request (v4) with the broker as leader of the partitions of the topic
+func (b *fakeBroker) metadata(topic string, partitions int) []byte {
+	var e encoder
+	e.int32(0) // throttle time
+	e.int32(1)
+	e.int32(1) // node ID
+	e.string(b.host)
+	e.int32(b.port)
+	e.int16(-1) // rack
+	e.int16(-1) // cluster ID
+	e.int32(1)  // controller ID
+	e.int32(1)
+	e.int16(0)
+	e.string(topic)
+	e.int8(0) // is internal
+	e.int32(int32(partitions))
+	for p := 0; p < partitions; p++ {
+		e.int16(0)
+		e.int32(int32(p))
+		e.int32(1) // leader
+		e.int32(1) // replicas
+		e.int32(1) //
+		e.int32(1) // in-sync replicas
+		e.int32(1) //
+	}
+	return e.b
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,531 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	apiFetch           = 1
+	apiListOffsets     = 2
+	apiOffsetCommit    = 8
+	apiOffsetFetch     = 9
+	apiFindCoordinator = 10
+
+	// fetchMaxBytes limits the records of a partition per fetch, a larger batch is still returned whole
+	fetchMaxBytes = 1 << 20
+
+	offsetLatest   = -1
+	offsetEarliest = -2
+)
+
+// Record is a message consumed from a partition
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string][]byte
+	Time      time.Time
+}
+
+// Consumer reads all partitions of a topic and commits its offsets to Kafka under a group ID.
+// It doesn't take part in the group protocol but assigns all partitions to itself, like the manually assigned
+// consumers of the Java client, so only one consumer of a group must run at a time.
+type Consumer struct {
+	opts  Options
+	topic string
+	group string
+	// earliest starts partitions without a committed offset at their first record instead of the next one
+	earliest bool
+
+	// the fields below are set up by the first poll and reset after an error
+	coordinator *conn
+	leaders     map[string]*conn
+	partitions  map[string][]int32
+	offsets     map[int32]int64
+	committed   map[int32]int64
+}
+
+// NewConsumer creates a consumer of the topic, it connects with the first call of Poll
+func NewConsumer(opts Options, topic, group string, earliest bool) *Consumer {
+	return &Consumer{opts: opts, topic: topic, group: group, earliest: earliest}
+}
+
+// Poll returns the next records of all partitions, it waits up to maxWait for new ones.
+// The connections are closed after an error, the next call reconnects and continues at the last polled offsets.
+func (c *Consumer) Poll(ctx context.Context, maxWait time.Duration) ([]Record, error) {
+	records, err := c.poll(ctx, maxWait)
+	if err != nil {
+		c.Close()
+	}
+	return records, err
+}
+
+func (c *Consumer) poll(ctx context.Context, maxWait time.Duration) ([]Record, error) {
+	deadline := time.Now().Add(maxWait + defaultTimeout)
+	if c.leaders == nil {
+		err := c.connect(ctx, deadline)
+		if err != nil {
+			return nil, err
+		}
+	}
+	type result struct {
+		records    []Record
+		outOfRange []int32
+		err        error
+	}
+	results := make(chan result, len(c.leaders))
+	var wg sync.WaitGroup
+	for address, leader := range c.leaders {
+		offsets := make(map[int32]int64)
+		for _, p := range c.partitions[address] {
+			offsets[p] = c.offsets[p]
+		}
+		wg.Add(1)
+		go func(leader *conn, offsets map[int32]int64) {
+			defer wg.Done()
+			err := leader.SetDeadline(deadline)
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			records, outOfRange, err := leader.fetch(c.topic, offsets, maxWait)
+			results <- result{records, outOfRange, err}
+		}(leader, offsets)
+	}
+	wg.Wait()
+	close(results)
+	var (
+		records []Record
+		reset   bool
+	)
+	for r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		records = append(records, r.records...)
+		for _, p := range r.outOfRange {
+			// the records were deleted by the retention, the next poll looks the offset up again
+			c.offsets[p] = -1
+			reset = true
+		}
+	}
+	if reset {
+		c.Close()
+	}
+	for _, record := range records {
+		if record.Offset >= c.offsets[record.Partition] {
+			c.offsets[record.Partition] = record.Offset + 1
+		}
+	}
+	return records, nil
+}
+
+// connect finds the leaders of the partitions and the coordinator of the group and looks up the offsets to start at
+func (c *Consumer) connect(ctx context.Context, deadline time.Time) error {
+	if len(c.opts.Brokers) == 0 {
+		return errors.New("no brokers given")
+	}
+	var (
+		bootstrap *conn
+		err       error
+	)
+	for _, broker := range c.opts.Brokers {
+		bootstrap, err = dial(ctx, c.opts, broker, deadline)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to the brokers: %w", err)
+	}
+	conns := map[string]*conn{bootstrap.address: bootstrap}
+	c.leaders = make(map[string]*conn)
+	get := func(address string) (*conn, error) {
+		if cn, ok := conns[address]; ok {
+			return cn, nil
+		}
+		cn, err := dial(ctx, c.opts, address, deadline)
+		if err != nil {
+			return nil, err
+		}
+		conns[address] = cn
+		return cn, nil
+	}
+	defer func() {
+		// close the connections which are neither leader nor coordinator
+		for address, cn := range conns {
+			if c.leaders[address] != cn && c.coordinator != cn {
+				cn.Close()
+			}
+		}
+	}()
+
+	brokers, partitions, err := bootstrap.metadata(c.topic)
+	if err != nil {
+		return fmt.Errorf("failed to get the metadata of %s: %w", c.topic, err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("the topic %s has no partitions", c.topic)
+	}
+	c.partitions = make(map[string][]int32)
+	for _, p := range partitions {
+		address := brokers[p.leader]
+		if address == "" {
+			return fmt.Errorf("partition %d of %s: %w", p.index, c.topic, Error(5))
+		}
+		leader, err := get(address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to the leader %s: %w", address, err)
+		}
+		c.leaders[address] = leader
+		c.partitions[address] = append(c.partitions[address], p.index)
+	}
+
+	address, err := bootstrap.findCoordinator(c.group)
+	if err != nil {
+		return fmt.Errorf("failed to find the coordinator of %s: %w", c.group, err)
+	}
+	c.coordinator, err = get(address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the coordinator %s: %w", address, err)
+	}
+	if c.offsets == nil {
+		c.offsets, err = c.coordinator.offsetFetch(c.group, c.topic, partitions)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the offsets of %s: %w", c.group, err)
+		}
+		c.committed = make(map[int32]int64)
+		for p, offset := range c.offsets {
+			c.committed[p] = offset
+		}
+	}
+	start := int64(offsetLatest)
+	if c.earliest {
+		start = offsetEarliest
+	}
+	for address, leader := range c.leaders {
+		missing := make(map[int32]int64)
+		for _, p := range c.partitions[address] {
+			if offset, ok := c.offsets[p]; !ok || offset < 0 {
+				missing[p] = start
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		offsets, err := leader.listOffsets(c.topic, missing)
+		if err != nil {
+			return fmt.Errorf("failed to list the offsets of %s: %w", c.topic, err)
+		}
+		for p, offset := range offsets {
+			c.offsets[p] = offset
+		}
+	}
+	return nil
+}
+
+// Commit commits the offsets after the polled records, unless they were committed already
+func (c *Consumer) Commit(ctx context.Context) error {
+	if c.coordinator == nil {
+		return nil
+	}
+	offsets := make(map[int32]int64)
+	for p, offset := range c.offsets {
+		if offset >= 0 && c.committed[p] != offset {
+			offsets[p] = offset
+		}
+	}
+	if len(offsets) == 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	err := c.coordinator.SetDeadline(deadline)
+	if err == nil {
+		err = c.coordinator.offsetCommit(c.group, c.topic, offsets)
+	}
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("failed to commit the offsets of %s: %w", c.group, err)
+	}
+	for p, offset := range offsets {
+		c.committed[p] = offset
+	}
+	return nil
+}
+
+// Close closes the connections, the consumer can be polled again afterwards
+func (c *Consumer) Close() error {
+	for _, leader := range c.leaders {
+		if leader != c.coordinator {
+			leader.Close()
+		}
+	}
+	if c.coordinator != nil {
+		c.coordinator.Close()
+	}
+	c.leaders, c.coordinator = nil, nil
+	return nil
+}
+
+// findCoordinator returns the address of the coordinator of the group
+func (c *conn) findCoordinator(group string) (string, error) {
+	var req encoder
+	req.string(group)
+	req.int8(0) // the key is a group
+	d, err := c.roundTrip(apiFindCoordinator, 1, req.b)
+	if err != nil {
+		return "", err
+	}
+	d.int32() // throttle time
+	code := d.int16()
+	d.nullableString() // error message
+	d.int32()          // node ID
+	host, port := d.string(), d.int32()
+	if d.err != nil {
+		return "", d.err
+	}
+	if code != 0 {
+		return "", Error(code)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// offsetFetch returns the committed offsets of the group, -1 for partitions without one
+func (c *conn) offsetFetch(group, topic string, partitions []partition) (map[int32]int64, error) {
+	var req encoder
+	req.string(group)
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		req.int32(p.index)
+	}
+	d, err := c.roundTrip(apiOffsetFetch, 1, req.b)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[int32]int64)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			p, offset := d.int32(), d.int64()
+			d.nullableString() // metadata
+			if code := d.int16(); code != 0 {
+				return nil, Error(code)
+			}
+			offsets[p] = offset
+		}
+	}
+	return offsets, d.err
+}
+
+// offsetCommit commits the offsets as a consumer outside of the group protocol
+func (c *conn) offsetCommit(group, topic string, offsets map[int32]int64) error {
+	var req encoder
+	req.string(group)
+	req.int32(-1)  // generation
+	req.string("") // member ID
+	req.int64(-1)  // retention time as configured on the broker
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(offsets)))
+	for p, offset := range offsets {
+		req.int32(p)
+		req.int64(offset)
+		req.string("") // metadata
+	}
+	d, err := c.roundTrip(apiOffsetCommit, 2, req.b)
+	if err != nil {
+		return err
+	}
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				return Error(code)
+			}
+		}
+	}
+	return d.err
+}
+
+// listOffsets returns the offsets of the partitions at the given times, offsetLatest or offsetEarliest
+func (c *conn) listOffsets(topic string, times map[int32]int64) (map[int32]int64, error) {
+	var req encoder
+	req.int32(-1) // replica ID of consumers
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(times)))
+	for p, t := range times {
+		req.int32(p)
+		req.int64(t)
+	}
+	d, err := c.roundTrip(apiListOffsets, 1, req.b)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[int32]int64)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			p, code := d.int32(), d.int16()
+			d.int64() // timestamp
+			offset := d.int64()
+			if code != 0 {
+				return nil, Error(code)
+			}
+			offsets[p] = offset
+		}
+	}
+	return offsets, d.err
+}
+
+// fetch returns the records of the partitions from the given offsets on and the partitions whose offset
+// is out of range
+func (c *conn) fetch(topic string, offsets map[int32]int64, maxWait time.Duration) ([]Record, []int32, error) {
+	var req encoder
+	req.int32(-1) // replica ID of consumers
+	req.int32(int32(maxWait / time.Millisecond))
+	req.int32(1) // min bytes
+	req.int32(int32(len(offsets)) * fetchMaxBytes)
+	req.int8(0) // read uncommitted
+	req.int32(1)
+	req.string(topic)
+	req.int32(int32(len(offsets)))
+	for p, offset := range offsets {
+		req.int32(p)
+		req.int64(offset)
+		req.int32(fetchMaxBytes)
+	}
+	d, err := c.roundTrip(apiFetch, 4, req.b)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.int32() // throttle time
+	var (
+		records    []Record
+		outOfRange []int32
+	)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			p, code := d.int32(), d.int16()
+			d.int64() // high watermark
+			d.int64() // last stable offset
+			if n := d.int32(); n > 0 {
+				d.next(int(n) * 16) // aborted transactions
+			}
+			set := d.next(int(d.int32()))
+			if code == 1 {
+				outOfRange = append(outOfRange, p)
+				continue
+			}
+			if code != 0 {
+				return nil, nil, fmt.Errorf("partition %d of %s: %w", p, topic, Error(code))
+			}
+			partitionRecords, err := decodeRecords(topic, p, set, offsets[p])
+			if err != nil {
+				return nil, nil, fmt.Errorf("partition %d of %s: %w", p, topic, err)
+			}
+			records = append(records, partitionRecords...)
+		}
+	}
+	return records, outOfRange, d.err
+}
+
+// decodeRecords decodes the record batches of a partition, skipping the records before offset.
+// The last batch may be cut off by the size limit of the fetch, it is left for the next one.
+func decodeRecords(topic string, partition int32, set []byte, offset int64) ([]Record, error) {
+	var records []Record
+	d := &decoder{b: set}
+	for len(d.b) >= 12 {
+		baseOffset, length := d.int64(), d.int32()
+		if int(length) > len(d.b) {
+			break
+		}
+		batch := &decoder{b: d.next(int(length))}
+		batch.int32() // partition leader epoch
+		if magic := batch.int8(); magic != 2 {
+			return nil, fmt.Errorf("unsupported message format %d, Kafka 0.11 or later is needed", magic)
+		}
+		crc := uint32(batch.int32())
+		if batch.err == nil && crc32.Checksum(batch.b, castagnoli) != crc {
+			return nil, errors.New("corrupt record batch")
+		}
+		attributes := batch.int16()
+		batch.int32() // last offset delta
+		firstTimestamp := batch.int64()
+		batch.int64() // max timestamp
+		batch.int64() // producer ID
+		batch.int16() // producer epoch
+		batch.int32() // base sequence
+		count := batch.int32()
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		// control batches mark the ends of transactions
+		if attributes&0x20 != 0 {
+			continue
+		}
+		body := batch.b
+		switch attributes & 0x07 {
+		case 0:
+		case 1:
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			body, err = ioutil.ReadAll(zr)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported compression %d, only gzip is supported", attributes&0x07)
+		}
+		rd := &decoder{b: body}
+		for i := int32(0); i < count && rd.err == nil; i++ {
+			rd.varint() // length
+			rd.int8()   // attributes
+			timestampDelta := rd.varint()
+			offsetDelta := rd.varint()
+			record := Record{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    baseOffset + offsetDelta,
+				Key:       rd.varintBytes(),
+				Value:     rd.varintBytes(),
+				Time:      time.Unix(0, (firstTimestamp+timestampDelta)*int64(time.Millisecond)),
+			}
+			for h := rd.varint(); h > 0 && rd.err == nil; h-- {
+				if record.Headers == nil {
+					record.Headers = make(map[string][]byte)
+				}
+				key := string(rd.varintBytes())
+				record.Headers[key] = rd.varintBytes()
+			}
+			if rd.err == nil && record.Offset >= offset {
+				records = append(records, record)
+			}
+		}
+		if rd.err != nil {
+			return nil, fmt.Errorf("corrupt records: %w", rd.err)
+		}
+	}
+	return records, d.err
+}
@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fetchedBatch is a gzip compressed batch at the base offset 40 with the records backup (with the header
+// source: cron) and db one second later
+const fetchedBatch = "000000000000002800000072ffffffff026f1ad18100010000000100000174876e800000000174876e83e8ffffffffffffffffffffffffffff000000021f8b080000000000020373606060e0494a4cce2e2d10a856cacf56b232ac65e229ce2f2d4a4ee5482ecacf1363b8c0cfc49292c4525dcb0000a741827e2d000000"
+
+func TestDecodeRecords(t *testing.T) {
+	set := mustHex(t, fetchedBatch)
+	records, err := decodeRecords("heartbeats", 3, set, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("want 2 records, got %+v", records)
+	}
+	first, second := records[0], records[1]
+	if first.Topic != "heartbeats" || first.Partition != 3 || first.Offset != 40 || string(first.Key) != "backup" ||
+		string(first.Value) != `{"ok":1}` || string(first.Headers["source"]) != "cron" || !first.Time.Equal(testTime) {
+		t.Errorf("unexpected first record %+v", first)
+	}
+	if second.Offset != 41 || string(second.Key) != "db" || string(second.Value) != "{}" || second.Headers != nil ||
+		!second.Time.Equal(testTime.Add(time.Second)) {
+		t.Errorf("unexpected second record %+v", second)
+	}
+
+	// the records before the offset were consumed already
+	records, err = decodeRecords("heartbeats", 3, set, 41)
+	if err != nil || len(records) != 1 || records[0].Offset != 41 {
+		t.Errorf("want the record at offset 41, got %+v, %v", records, err)
+	}
+	// a batch cut off by the size limit of the fetch is left for the next one
+	records, err = decodeRecords("heartbeats", 3, set[:len(set)-10], 40)
+	if err != nil || len(records) != 0 {
+		t.Errorf("want no records of the partial batch, got %+v, %v", records, err)
+	}
+	// a flipped bit fails the checksum
+	corrupt := append([]byte{}, set...)
+	corrupt[len(corrupt)-20] ^= 1
+	if _, err = decodeRecords("heartbeats", 3, corrupt, 40); err == nil {
+		t.Error("want an error for a corrupt batch")
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req request) []byte {
+		var e encoder
+		switch req.apiKey {
+		case apiMetadata:
+			return broker.metadata("heartbeats", 1)
+		case apiFindCoordinator:
+			e.int32(0)
+			e.int16(0)
+			e.int16(-1)
+			e.int32(1)
+			e.string(broker.host)
+			e.int32(broker.port)
+		case apiOffsetFetch:
+			e.int32(1)
+			e.string("heartbeats")
+			e.int32(1)
+			e.int32(0)
+			e.int64(-1) // no committed offset
+			e.int16(-1)
+			e.int16(0)
+		case apiListOffsets:
+			e.int32(1)
+			e.string("heartbeats")
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(-1)
+			e.int64(40)
+		case apiFetch:
+			set, _ := hex.DecodeString(fetchedBatch)
+			e.int32(0)
+			e.int32(1)
+			e.string("heartbeats")
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(42) // high watermark
+			e.int64(42) // last stable offset
+			e.int32(0)  // aborted transactions
+			e.bytes(set)
+		case apiOffsetCommit:
+			e.int32(1)
+			e.string("heartbeats")
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+		}
+		return e.b
+	})
+	c := NewConsumer(Options{Brokers: []string{broker.address()}}, "heartbeats", "dms", true)
+	defer c.Close()
+	records, err := c.Poll(context.Background(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Offset != 40 || records[1].Offset != 41 {
+		t.Fatalf("want the records at offsets 40 and 41, got %+v", records)
+	}
+	err = c.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing new to commit
+	err = c.Commit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	var apis []int16
+	bodies := make(map[int16]string)
+	for _, req := range broker.requests {
+		apis = append(apis, req.apiKey)
+		bodies[req.apiKey] = hex.EncodeToString(req.body)
+	}
+	expectedAPIs := []int16{apiMetadata, apiFindCoordinator, apiOffsetFetch, apiListOffsets, apiFetch, apiOffsetCommit}
+	if len(apis) != len(expectedAPIs) {
+		t.Fatalf("want the requests %v, got %v", expectedAPIs, apis)
+	}
+	for i := range apis {
+		if apis[i] != expectedAPIs[i] {
+			t.Fatalf("want the requests %v, got %v", expectedAPIs, apis)
+		}
+	}
+	topic := "000a" + hex.EncodeToString([]byte("heartbeats"))
+	for api, expected := range map[int16]string{
+		apiFindCoordinator: "0003" + hex.EncodeToString([]byte("dms")) + "00",
+		apiOffsetFetch:     "0003" + hex.EncodeToString([]byte("dms")) + "00000001" + topic + "00000001" + "00000000",
+		// the earliest offset of partition 0
+		apiListOffsets: "ffffffff" + "00000001" + topic + "00000001" + "00000000" + "fffffffffffffffe",
+		// max wait 100ms, min bytes 1, from offset 40
+		apiFetch: "ffffffff" + "00000064" + "00000001" + "00100000" + "00" + "00000001" + topic + "00000001" +
+			"00000000" + "0000000000000028" + "00100000",
+		// no generation and member, the offset after the polled records
+		apiOffsetCommit: "0003" + hex.EncodeToString([]byte("dms")) + "ffffffff" + "0000" + "ffffffffffffffff" +
+			"00000001" + topic + "00000001" + "00000000" + "000000000000002a" + "0000",
+	} {
+		if bodies[api] != expected {
+			t.Errorf("API %d: want the body %s, got %s", api, expected, bodies[api])
+		}
+	}
+}
+
+func TestRegisterSchema(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var body map[string]string
+		user, pass, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/subjects/dms%2Falerts-value/versions" ||
+			r.Header.Get("Content-Type") != "application/vnd.schemaregistry.v1+json" || user != "u" || pass != "p" ||
+			json.NewDecoder(r.Body).Decode(&body) != nil || body["schema"] != `"string"` {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"id":17}`))
+	}))
+	defer srv.Close()
+	registry := "http://u:p@" + srv.Listener.Addr().String()
+	for i := 0; i < 2; i++ {
+		id, err := RegisterSchema(context.Background(), srv.Client(), registry, "dms/alerts-value", `"string"`)
+		if err != nil || id != 17 {
+			t.Fatalf("want the ID 17, got %d, %v", id, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("want the ID to be cached, got %d requests", requests)
+	}
+	if got := hex.EncodeToString(WireFormat(17, []byte{0x06})); got != "000000001106" {
+		t.Errorf("want the magic byte and the schema ID before the value, got %s", got)
+	}
+}
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errShortResponse = errors.New("response too short")
+
+// encoder appends the primitive types of the protocol
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) raw(b []byte) { e.b = append(e.b, b...) }
+func (e *encoder) int8(v int8)  { e.b = append(e.b, byte(v)) }
+func (e *encoder) int16(v int16) {
+	e.b = append(e.b, byte(uint16(v)>>8), byte(v))
+}
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.b[len(e.b)-4:], uint32(v))
+}
+func (e *encoder) int64(v int64) {
+	e.b = append(e.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.b[len(e.b)-8:], uint64(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// varint appends a zigzag encoded varint as used in records
+func (e *encoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b = append(e.b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// varintBytes appends bytes with a varint length, nil is encoded as -1
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads the primitive types of the protocol, the first error sticks and zero values are returned after it
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortResponse
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *decoder) nullableString() string {
+	n := d.int16()
+	if n == -1 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) int32Array() {
+	n := d.int32()
+	if n > 0 {
+		d.next(int(n) * 4)
+	}
+}
+
+// varint reads a zigzag encoded varint as used in records
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errShortResponse
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// varintBytes reads bytes with a varint length, -1 is nil
+func (d *decoder) varintBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
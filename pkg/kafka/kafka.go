@@ -0,0 +1,455 @@
+// Package kafka produces and consumes messages of Kafka topics. It implements the part of the Kafka protocol
+// a producer needs: metadata, produce with record batches (Kafka 0.11 and later) and SASL PLAIN, with a connection
+// per batch of messages, which is plenty for notifications and events. Consumers fetch all partitions of a topic
+// and commit their offsets to the group coordinator, see Consumer.
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// maxResponseSize protects against reading garbage as the size of a response
+	maxResponseSize = 16 << 20
+
+	apiProduce          = 0
+	apiMetadata         = 3
+	apiSaslHandshake    = 17
+	apiSaslAuthenticate = 36
+)
+
+// errorNames are the codes of the errors a producer or consumer can run into
+var errorNames = map[int16]string{
+	1:  "offset out of range",
+	2:  "corrupt message",
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	14: "coordinator load in progress",
+	15: "coordinator not available",
+	16: "not coordinator",
+	19: "not enough replicas",
+	20: "not enough replicas after append",
+	22: "illegal generation",
+	25: "unknown member ID",
+	29: "topic authorization failed",
+	30: "group authorization failed",
+	31: "cluster authorization failed",
+	33: "unsupported SASL mechanism",
+	35: "unsupported version",
+	58: "SASL authentication failed",
+	87: "invalid record",
+}
+
+// Error is an error code returned by a broker
+type Error int16
+
+func (e Error) Error() string {
+	if name, ok := errorNames[int16(e)]; ok {
+		return fmt.Sprintf("kafka error %d: %s", int16(e), name)
+	}
+	return fmt.Sprintf("kafka error %d", int16(e))
+}
+
+// Options of the connections to the brokers
+type Options struct {
+	// Brokers are the host:port addresses to bootstrap from, they are tried in order
+	Brokers  []string
+	ClientID string
+	// TLSConfig enables TLS if set
+	TLSConfig *tls.Config
+	// Username and Password authenticate with SASL PLAIN if set
+	Username string
+	Password string
+	// Control is called with the resolved address before connecting, see net.Dialer, it may be nil
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Message is produced to a topic. Messages with the same key go to the same partition, chosen like the
+// default partitioner of the Java client does.
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	// Time defaults to now
+	Time time.Time
+}
+
+// Produce sends the message to the leader of its partition and waits until all in-sync replicas have it
+func Produce(ctx context.Context, opts Options, msg Message) error {
+	return ProduceBatch(ctx, opts, []Message{msg})
+}
+
+// ProduceBatch sends the messages, which may go to different topics, with one request per partition leader and
+// waits until all in-sync replicas have them. The messages of a partition keep their order.
+func ProduceBatch(ctx context.Context, opts Options, msgs []Message) error {
+	if len(opts.Brokers) == 0 {
+		return errors.New("no brokers given")
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	var (
+		bootstrap *conn
+		err       error
+	)
+	for _, broker := range opts.Brokers {
+		bootstrap, err = dial(ctx, opts, broker, deadline)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to the brokers: %w", err)
+	}
+	defer bootstrap.Close()
+
+	// the messages by leader, topic and partition
+	batches := make(map[string]map[string]map[int32][]Message)
+	topics := make(map[string][]partition)
+	for _, msg := range msgs {
+		partitions, ok := topics[msg.Topic]
+		if !ok {
+			var brokers map[int32]string
+			brokers, partitions, err = bootstrap.metadata(msg.Topic)
+			if err != nil {
+				return fmt.Errorf("failed to get the metadata of %s: %w", msg.Topic, err)
+			}
+			if len(partitions) == 0 {
+				return fmt.Errorf("the topic %s has no partitions", msg.Topic)
+			}
+			for i, p := range partitions {
+				partitions[i].address = brokers[p.leader]
+			}
+			topics[msg.Topic] = partitions
+		}
+		p := partitions[partitionFor(msg.Key, len(partitions))]
+		if p.address == "" {
+			return fmt.Errorf("partition %d of %s: %w", p.index, msg.Topic, Error(5))
+		}
+		if batches[p.address] == nil {
+			batches[p.address] = make(map[string]map[int32][]Message)
+		}
+		if batches[p.address][msg.Topic] == nil {
+			batches[p.address][msg.Topic] = make(map[int32][]Message)
+		}
+		batches[p.address][msg.Topic][p.index] = append(batches[p.address][msg.Topic][p.index], msg)
+	}
+	for leader, batch := range batches {
+		c := bootstrap
+		if leader != bootstrap.address {
+			c, err = dial(ctx, opts, leader, deadline)
+			if err != nil {
+				return fmt.Errorf("failed to connect to the leader %s: %w", leader, err)
+			}
+			defer c.Close()
+		}
+		err = c.produce(batch, deadline)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type partition struct {
+	index  int32
+	leader int32
+	// address of the leader
+	address string
+}
+
+// conn is a connection to a broker
+type conn struct {
+	net.Conn
+	address       string
+	r             *bufio.Reader
+	clientID      string
+	correlationID int32
+}
+
+func dial(ctx context.Context, opts Options, address string, deadline time.Time) (*conn, error) {
+	dialer := &net.Dialer{Deadline: deadline, Control: opts.Control}
+	var (
+		nc  net.Conn
+		err error
+	)
+	if opts.TLSConfig != nil {
+		tlsConfig := opts.TLSConfig
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, address: address, r: bufio.NewReader(nc), clientID: opts.ClientID}
+	err = nc.SetDeadline(deadline)
+	if err == nil && opts.Username != "" {
+		err = c.authenticate(opts.Username, opts.Password)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// roundTrip sends a request and returns the body of the response
+func (c *conn) roundTrip(apiKey, apiVersion int16, body []byte) (*decoder, error) {
+	c.correlationID++
+	var req encoder
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.string(c.clientID)
+	req.raw(body)
+	binary.BigEndian.PutUint32(req.b, uint32(len(req.b)-4))
+	_, err := c.Write(req.b)
+	if err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	_, err = io.ReadFull(c.r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > maxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size-4)
+	_, err = io.ReadFull(c.r, resp)
+	if err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != c.correlationID {
+		return nil, fmt.Errorf("got the response to request %d instead of %d", id, c.correlationID)
+	}
+	return &decoder{b: resp}, nil
+}
+
+func (c *conn) authenticate(username, password string) error {
+	var handshake encoder
+	handshake.string("PLAIN")
+	d, err := c.roundTrip(apiSaslHandshake, 1, handshake.b)
+	if err != nil {
+		return err
+	}
+	if code := d.int16(); code != 0 {
+		return Error(code)
+	}
+	var auth encoder
+	auth.bytes([]byte("\x00" + username + "\x00" + password))
+	d, err = c.roundTrip(apiSaslAuthenticate, 0, auth.b)
+	if err != nil {
+		return err
+	}
+	code, message := d.int16(), d.nullableString()
+	if d.err != nil {
+		return d.err
+	}
+	if code != 0 {
+		if message != "" {
+			return fmt.Errorf("%w: %s", Error(code), message)
+		}
+		return Error(code)
+	}
+	return nil
+}
+
+// metadata returns the addresses of the brokers by node ID and the partitions of the topic sorted by index
+func (c *conn) metadata(topic string) (map[int32]string, []partition, error) {
+	var req encoder
+	req.int32(1)
+	req.string(topic)
+	req.int8(1) // let the brokers create the topic if they are configured to
+	d, err := c.roundTrip(apiMetadata, 4, req.b)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.int32() // throttle time
+	brokers := make(map[int32]string)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		node, host, port := d.int32(), d.string(), d.int32()
+		d.nullableString() // rack
+		brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster ID
+	d.int32()          // controller ID
+	var partitions []partition
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		code, name := d.int16(), d.string()
+		d.int8() // is internal
+		if code != 0 && name == topic {
+			return nil, nil, Error(code)
+		}
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int16() // error code, a partition without leader fails when it's used
+			p := partition{index: d.int32(), leader: d.int32()}
+			d.int32Array() // replicas
+			d.int32Array() // in-sync replicas
+			if name == topic {
+				partitions = append(partitions, p)
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].index < partitions[j].index })
+	return brokers, partitions, nil
+}
+
+// produce sends the messages by topic and partition to the leader of the partitions
+func (c *conn) produce(batch map[string]map[int32][]Message, deadline time.Time) error {
+	topics := make([]string, 0, len(batch))
+	for topic := range batch {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	var req encoder
+	req.int16(-1) // no transactional ID
+	req.int16(-1) // acks from all in-sync replicas
+	req.int32(int32(time.Until(deadline) / time.Millisecond))
+	req.int32(int32(len(topics)))
+	for _, topic := range topics {
+		req.string(topic)
+		req.int32(int32(len(batch[topic])))
+		for partition, msgs := range batch[topic] {
+			req.int32(partition)
+			req.bytes(recordBatch(msgs))
+		}
+	}
+	d, err := c.roundTrip(apiProduce, 3, req.b)
+	if err != nil {
+		return err
+	}
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int32() // partition
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 {
+				return Error(code)
+			}
+		}
+	}
+	return d.err
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes the messages of a partition as a record batch (magic 2)
+func recordBatch(msgs []Message) []byte {
+	timestamps := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		t := msg.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		timestamps[i] = t.UnixNano() / int64(time.Millisecond)
+	}
+	first, max := timestamps[0], timestamps[0]
+	var records encoder
+	for i, msg := range msgs {
+		if timestamps[i] > max {
+			max = timestamps[i]
+		}
+		var record encoder
+		record.int8(0) // attributes
+		record.varint(timestamps[i] - first)
+		record.varint(int64(i)) // offset delta
+		record.varintBytes(msg.Key)
+		record.varintBytes(msg.Value)
+		record.varint(0) // headers
+		records.varint(int64(len(record.b)))
+		records.raw(record.b)
+	}
+
+	// the checksum covers everything from the attributes on
+	var checked encoder
+	checked.int16(0) // attributes: no compression, create time
+	checked.int32(int32(len(msgs) - 1))
+	checked.int64(first)
+	checked.int64(max)
+	checked.int64(-1) // producer ID
+	checked.int16(-1) // producer epoch
+	checked.int32(-1) // base sequence
+	checked.int32(int32(len(msgs)))
+	checked.raw(records.b)
+
+	var batch encoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + len(checked.b)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(checked.b, castagnoli)))
+	batch.raw(checked.b)
+	return batch.b
+}
+
+// partitionFor returns the partition of a key like the murmur2 partitioner of the Java client
+func partitionFor(key []byte, partitions int) int {
+	return int(murmur2(key)&0x7fffffff) % partitions
+}
+
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
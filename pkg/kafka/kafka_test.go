@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestMurmur2 checks the hash against the test cases of the Java client, so keys go to the same partitions
+func TestMurmur2(t *testing.T) {
+	for key, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := int32(murmur2([]byte(key))); got != expected {
+			t.Errorf("%s: want %d, got %d", key, expected, got)
+		}
+	}
+}
+
+// TestRecordBatch compares a batch with a batch encoded independently from the spec of the message format v2
+func TestRecordBatch(t *testing.T) {
+	batch := recordBatch([]Message{
+		{Key: []byte("k"), Value: []byte("v"), Time: testTime},
+		{Value: []byte("w"), Time: testTime.Add(5 * time.Millisecond)},
+	})
+	expected := mustHex(t, "0000000000000000"+ // base offset
+		"00000042"+ // length
+		"ffffffff"+ // partition leader epoch
+		"02"+ // magic
+		"36fee9f4"+ // CRC-32C
+		"0000"+ // attributes
+		"00000001"+ // last offset delta
+		"00000174876e8000"+ // first timestamp
+		"00000174876e8005"+ // max timestamp
+		"ffffffffffffffff"+ // producer ID
+		"ffff"+ // producer epoch
+		"ffffffff"+ // base sequence
+		"00000002"+ // records
+		"10"+"00"+"00"+"00"+"026b"+"0276"+"00"+ // length, attributes, timestamp and offset delta, key k, value v, headers
+		"0e"+"00"+"0a"+"02"+"01"+"0277"+"00") // the second record after 5ms, without key
+	if !bytes.Equal(batch, expected) {
+		t.Fatalf("want\n%x\ngot\n%x", expected, batch)
+	}
+}
+
+func TestProduce(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req request) []byte {
+		var e encoder
+		switch req.apiKey {
+		case apiSaslHandshake:
+			e.int16(0)
+			e.int32(1)
+			e.string("PLAIN")
+		case apiSaslAuthenticate:
+			e.int16(0)
+			e.int16(-1) // error message
+			e.bytes(nil)
+		case apiMetadata:
+			return broker.metadata("alerts", 1)
+		case apiProduce:
+			e.int32(1)
+			e.string("alerts")
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(7)  // base offset
+			e.int64(-1) // log append time
+			e.int32(0)  // throttle time
+		}
+		return e.b
+	})
+	opts := Options{Brokers: []string{broker.address()}, ClientID: "deadman-switch", Username: "dms", Password: "secret"}
+	err := Produce(context.Background(), opts, Message{Topic: "alerts", Key: []byte("k"), Value: []byte("v"), Time: testTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if len(broker.requests) != 4 {
+		t.Fatalf("want the SASL handshake and authentication, metadata and produce requests, got %d requests", len(broker.requests))
+	}
+	for i, expected := range []struct {
+		apiKey, apiVersion int16
+		body               string
+	}{
+		{apiSaslHandshake, 1, "0005" + hex.EncodeToString([]byte("PLAIN"))},
+		{apiSaslAuthenticate, 0, "0000000b" + "00" + hex.EncodeToString([]byte("dms")) + "00" + hex.EncodeToString([]byte("secret"))},
+		// one topic, allow auto creation
+		{apiMetadata, 4, "00000001" + "0006" + hex.EncodeToString([]byte("alerts")) + "01"},
+	} {
+		req := broker.requests[i]
+		if req.apiKey != expected.apiKey || req.apiVersion != expected.apiVersion || req.correlationID != int32(i+1) || req.clientID != "deadman-switch" {
+			t.Errorf("request %d: want API %d v%d, got %+v", i, expected.apiKey, expected.apiVersion, req)
+		}
+		if got := hex.EncodeToString(req.body); got != expected.body {
+			t.Errorf("request %d: want the body %s, got %s", i, expected.body, got)
+		}
+	}
+
+	produce := broker.requests[3]
+	if produce.apiKey != apiProduce || produce.apiVersion != 3 || produce.correlationID != 4 {
+		t.Fatalf("want a produce request v3, got %+v", produce)
+	}
+	d := &decoder{b: produce.body}
+	transactionalID, acks, timeout := d.int16(), d.int16(), d.int32()
+	if transactionalID != -1 || acks != -1 || timeout <= 0 || timeout > int32(defaultTimeout/time.Millisecond) {
+		t.Errorf("want no transactional ID, acks from all replicas and the timeout, got %d, %d, %d", transactionalID, acks, timeout)
+	}
+	topics, topic, partitions, partition := d.int32(), d.string(), d.int32(), d.int32()
+	batch := d.next(int(d.int32()))
+	if d.err != nil || topics != 1 || topic != "alerts" || partitions != 1 || partition != 0 || len(d.b) != 0 {
+		t.Fatalf("unexpected produce request %x", produce.body)
+	}
+	if expected := recordBatch([]Message{{Key: []byte("k"), Value: []byte("v"), Time: testTime}}); !bytes.Equal(batch, expected) {
+		t.Errorf("want the batch %x, got %x", expected, batch)
+	}
+}
+
+func TestProduceError(t *testing.T) {
+	var broker *fakeBroker
+	broker = newFakeBroker(t, func(req request) []byte {
+		if req.apiKey == apiMetadata {
+			return broker.metadata("alerts", 1)
+		}
+		var e encoder
+		e.int32(1)
+		e.string("alerts")
+		e.int32(1)
+		e.int32(0)
+		e.int16(19) // not enough replicas
+		e.int64(-1)
+		e.int64(-1)
+		e.int32(0)
+		return e.b
+	})
+	err := Produce(context.Background(), Options{Brokers: []string{broker.address()}}, Message{Topic: "alerts", Value: []byte("v")})
+	if err != Error(19) {
+		t.Fatalf("want the error not enough replicas, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// schemaIDs caches the IDs of the registered schemas by registry, subject and schema
+var schemaIDs sync.Map
+
+// RegisterSchema registers the avro schema for the subject in a Confluent schema registry and returns its ID.
+// Registering an existing schema again returns its ID, the IDs are cached. Credentials in the registry URL are
+// sent as basic auth.
+func RegisterSchema(ctx context.Context, cli *http.Client, registry, subject, schema string) (int32, error) {
+	endpoint := strings.TrimSuffix(registry, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	cacheKey := endpoint + "\n" + schema
+	if id, ok := schemaIDs.Load(cacheKey); ok {
+		return id.(int32), nil
+	}
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := cli.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("the schema registry answered %d: %s", resp.StatusCode, strings.TrimSpace(string(bs)))
+	}
+	var result struct {
+		ID int32 `json:"id"`
+	}
+	err = json.Unmarshal(bs, &result)
+	if err != nil {
+		return 0, err
+	}
+	schemaIDs.Store(cacheKey, result.ID)
+	return result.ID, nil
+}
+
+// WireFormat prefixes the value with a zero byte and the schema ID, which the Confluent deserializers expect
+func WireFormat(schemaID int32, value []byte) []byte {
+	header := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], uint32(schemaID))
+	return append(header, value...)
+}
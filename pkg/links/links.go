@@ -0,0 +1,128 @@
+// Package links creates the short links of notifications which open the read-only page of a service.
+//
+// A link ID is the signature of the service and the time window the link was created in, so it can't be
+// guessed without the secret and notifications of the same window share one link. The storage maps the ID
+// back to the service until the link expires.
+package links
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	pruneInterval = time.Hour
+	// idSize is the number of signature bytes in an ID, 96 bits are plenty for links which expire
+	idSize = 12
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid link")
+	ErrExpired     = errors.New("link expired")
+)
+
+// Links creates and resolves the short links
+type Links struct {
+	cfg   config.LinksConfig
+	store storage.Storage
+}
+
+func New(cfg config.LinksConfig, store storage.Storage) *Links {
+	if cfg.TTL <= 0 {
+		cfg.TTL = config.DefaultLinkTTL
+	}
+	return &Links{
+		cfg:   cfg,
+		store: store,
+	}
+}
+
+// Create returns the link to the page of the service. A new link is created every half TTL,
+// so a link stays valid for at least half the TTL after it was sent.
+func (l *Links) Create(ctx context.Context, service string, now time.Time) (string, error) {
+	window := now.Truncate(time.Duration(l.cfg.TTL) / 2).UTC()
+	id := l.sign(service, window)
+	_, err := l.store.GetLink(ctx, id)
+	if err == storage.ErrNotFound {
+		err = l.store.SaveLink(ctx, storage.Link{
+			ID:        id,
+			Service:   service,
+			CreatedAt: window,
+			ExpiresAt: window.Add(time.Duration(l.cfg.TTL)),
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/a/%s", strings.TrimSuffix(l.cfg.URL, "/"), id), nil
+}
+
+// Resolve returns the link with the ID if it is valid
+func (l *Links) Resolve(ctx context.Context, id string, now time.Time) (storage.Link, error) {
+	link, err := l.store.GetLink(ctx, id)
+	if err == storage.ErrNotFound {
+		return link, ErrInvalidLink
+	}
+	if err != nil {
+		return link, err
+	}
+	// links of a former secret are invalid
+	if !hmac.Equal([]byte(id), []byte(l.sign(link.Service, link.CreatedAt))) {
+		return link, ErrInvalidLink
+	}
+	if now.After(link.ExpiresAt) {
+		return link, ErrExpired
+	}
+	return link, nil
+}
+
+// Backend deletes expired links in an interval until the context is done
+func (l *Links) Backend(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.prune(ctx, time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to prune expired links")
+		}
+	}
+}
+
+func (l *Links) prune(ctx context.Context, now time.Time) error {
+	links, err := l.store.GetLinks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if now.Before(link.ExpiresAt) {
+			continue
+		}
+		err = l.store.DeleteLink(ctx, link.ID)
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Links) sign(service string, window time.Time) string {
+	mac := hmac.New(sha256.New, []byte(l.cfg.Secret))
+	mac.Write([]byte(service + "\n" + strconv.FormatInt(window.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:idSize])
+}
@@ -0,0 +1,82 @@
+package links
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func TestLinks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := storage.NewMemoryStorage(ctx, config.ServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LinksConfig{URL: "https://deadman.example.com/", Secret: "link-secret", TTL: config.Duration(2 * time.Hour)}
+	links := New(cfg, store)
+	t0 := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	link, err := links.Create(ctx, "backup", t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://deadman.example.com/a/") {
+		t.Fatalf("unexpected link %s", link)
+	}
+	id := strings.TrimPrefix(link, "https://deadman.example.com/a/")
+	same, err := links.Create(ctx, "backup", t0.Add(59*time.Minute))
+	if err != nil || same != link {
+		t.Fatalf("expected the link of the same window, got %s and %v", same, err)
+	}
+	next, err := links.Create(ctx, "backup", t0.Add(time.Hour))
+	if err != nil || next == link {
+		t.Fatalf("expected a new link in the next window, got %s and %v", next, err)
+	}
+	other, err := links.Create(ctx, "database", t0)
+	if err != nil || other == link {
+		t.Fatalf("expected another link for another service, got %s and %v", other, err)
+	}
+
+	changed := id[:len(id)-1] + "A"
+	if changed == id {
+		changed = id[:len(id)-1] + "B"
+	}
+	rotated := New(config.LinksConfig{URL: cfg.URL, Secret: "new-secret", TTL: cfg.TTL}, store)
+	for _, test := range []struct {
+		name  string
+		links *Links
+		id    string
+		at    time.Time
+		err   error
+	}{
+		{"valid", links, id, t0.Add(time.Hour), nil},
+		{"valid until it expires", links, id, t0.Add(2 * time.Hour), nil},
+		{"expired", links, id, t0.Add(2*time.Hour + time.Second), ErrExpired},
+		{"unknown", links, "AAAAAAAAAAAAAAAA", t0, ErrInvalidLink},
+		{"changed", links, changed, t0, ErrInvalidLink},
+		{"former secret", rotated, id, t0, ErrInvalidLink},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resolved, err := test.links.Resolve(ctx, test.id, test.at)
+			if err != test.err {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err == nil && resolved.Service != "backup" {
+				t.Fatalf("expected the link to open backup, got %s", resolved.Service)
+			}
+		})
+	}
+
+	err = links.prune(ctx, t0.Add(2*time.Hour+time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = links.Resolve(ctx, id, t0)
+	if err != ErrInvalidLink {
+		t.Fatalf("expected the expired link to be pruned, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package mqtt publishes messages to an MQTT broker. It implements the part of MQTT 3.1.1 a publisher needs:
+// a connection per publish with QoS 0 or 1, which is plenty for notifications.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	keepAlive      = 30 // seconds
+
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetDisconnect = 14
+)
+
+// connectErrors are the return codes of a refused connection
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Options of the connection to the broker
+type Options struct {
+	// Broker is a URL like mqtt://host:1883 or mqtts://host:8883, tcp, ssl and tls are accepted as schemes as well
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TLSConfig is used for mqtts, it may be nil
+	TLSConfig *tls.Config
+	// Control is called with the resolved address before connecting, see net.Dialer, it may be nil
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Message is published to a topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	// QoS is 0 (at most once) or 1 (at least once, acknowledged by the broker)
+	QoS    byte
+	Retain bool
+}
+
+// ParseBroker returns the address and whether TLS is used for a broker URL
+func ParseBroker(broker string) (address string, useTLS bool, err error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, err
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		useTLS, port = true, "8883"
+	default:
+		return "", false, fmt.Errorf("unsupported scheme %q, use mqtt or mqtts", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", false, errors.New("the broker URL has no host")
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// Publish connects to the broker, publishes the message and disconnects
+func Publish(ctx context.Context, opts Options, msg Message) error {
+	if msg.QoS > 1 {
+		return errors.New("only QoS 0 and 1 are supported")
+	}
+	address, useTLS, err := ParseBroker(opts.Broker)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline, Control: opts.Control}
+	var conn net.Conn
+	if useTLS {
+		tlsConfig := opts.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+
+	_, err = conn.Write(connectPacket(opts))
+	if err != nil {
+		return err
+	}
+	packetType, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to read the connack: %w", err)
+	}
+	if packetType != packetConnAck || len(body) != 2 {
+		return fmt.Errorf("the broker answered the connect with packet type %d", packetType)
+	}
+	if code := body[1]; code != 0 {
+		reason, ok := connectErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", code)
+		}
+		return fmt.Errorf("the broker refused the connection: %s", reason)
+	}
+
+	const packetID = 1
+	_, err = conn.Write(publishPacket(msg, packetID))
+	if err != nil {
+		return err
+	}
+	if msg.QoS == 1 {
+		packetType, body, err = readPacket(r)
+		if err != nil {
+			return fmt.Errorf("failed to read the puback: %w", err)
+		}
+		if packetType != packetPubAck || len(body) != 2 || binary.BigEndian.Uint16(body) != packetID {
+			return fmt.Errorf("the broker answered the publish with packet type %d", packetType)
+		}
+	}
+	_, err = conn.Write([]byte{packetDisconnect << 4, 0})
+	return err
+}
+
+func connectPacket(opts Options) []byte {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4, flags, 0, keepAlive)
+	return packet(packetConnect<<4, append(body, payload...))
+}
+
+func publishPacket(msg Message, packetID uint16) []byte {
+	header := byte(packetPublish<<4) | msg.QoS<<1
+	if msg.Retain {
+		header |= 0x01
+	}
+	var body []byte
+	body = appendString(body, msg.Topic)
+	if msg.QoS > 0 {
+		body = append(body, byte(packetID>>8), byte(packetID))
+	}
+	return packet(header, append(body, msg.Payload...))
+}
+
+// packet prepends the fixed header with the remaining length
+func packet(header byte, body []byte) []byte {
+	out := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		out = append(out, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header >> 4, body, err
+}
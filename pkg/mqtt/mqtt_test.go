@@ -0,0 +1,155 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// exchange is a packet the client must send and the answer of the broker
+type exchange struct {
+	expect string
+	reply  string
+}
+
+// fakeBroker accepts one connection and plays the exchanges, the packets are hex encoded
+func fakeBroker(t *testing.T, exchanges []exchange) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		for _, e := range exchanges {
+			expected, _ := hex.DecodeString(strings.ReplaceAll(e.expect, " ", ""))
+			got := make([]byte, len(expected))
+			_, err = io.ReadFull(conn, got)
+			if err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(got, expected) {
+				done <- &mismatch{expected, got}
+				return
+			}
+			reply, _ := hex.DecodeString(strings.ReplaceAll(e.reply, " ", ""))
+			_, err = conn.Write(reply)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	return "mqtt://" + l.Addr().String(), done
+}
+
+type mismatch struct {
+	expected, got []byte
+}
+
+func (m *mismatch) Error() string {
+	return "want packet " + hex.EncodeToString(m.expected) + ", got " + hex.EncodeToString(m.got)
+}
+
+func TestPublishQoS1(t *testing.T) {
+	broker, done := fakeBroker(t, []exchange{
+		// CONNECT: protocol MQTT level 4, user name, password and clean session, keep alive 30s,
+		// client ID dms, user u, password p
+		{"10 15 0004 4d515454 04 c2 001e 0003 646d73 0001 75 0001 70", "20 02 00 00"},
+		// PUBLISH with QoS 1 and retain to a/b with the packet ID 1
+		{"33 09 0003 612f62 0001 6869", "40 02 0001"},
+		// DISCONNECT
+		{"e0 00", ""},
+	})
+	err := Publish(context.Background(), Options{Broker: broker, ClientID: "dms", Username: "u", Password: "p"}, Message{Topic: "a/b", Payload: []byte("hi"), QoS: 1, Retain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishQoS0(t *testing.T) {
+	broker, done := fakeBroker(t, []exchange{
+		{"10 0f 0004 4d515454 04 02 001e 0003 646d73", "20 02 00 00"},
+		// no packet ID and no acknowledgement
+		{"30 07 0003 612f62 6869", ""},
+		{"e0 00", ""},
+	})
+	err := Publish(context.Background(), Options{Broker: broker, ClientID: "dms"}, Message{Topic: "a/b", Payload: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishRefused(t *testing.T) {
+	broker, _ := fakeBroker(t, []exchange{
+		{"10 0f 0004 4d515454 04 02 001e 0003 646d73", "20 02 00 05"},
+	})
+	err := Publish(context.Background(), Options{Broker: broker, ClientID: "dms"}, Message{Topic: "a/b", Payload: []byte("hi")})
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Fatalf("want the connection to be refused as not authorized, got %v", err)
+	}
+}
+
+// TestRemainingLength encodes the examples of the MQTT 3.1.1 spec, section 2.2.3
+func TestRemainingLength(t *testing.T) {
+	for length, expected := range map[int]string{
+		0:       "00",
+		127:     "7f",
+		128:     "8001",
+		16383:   "ff7f",
+		16384:   "808001",
+		2097151: "ffff7f",
+		2097152: "80808001",
+	} {
+		p := packet(0x30, make([]byte, length))
+		got := hex.EncodeToString(p[1 : 1+len(expected)/2])
+		if got != expected {
+			t.Errorf("remaining length %d: want %s, got %s", length, expected, got)
+		}
+		_, body, err := readPacket(bufio.NewReader(bytes.NewReader(p)))
+		if err != nil || len(body) != length {
+			t.Errorf("remaining length %d: read %d bytes, %v", length, len(body), err)
+		}
+	}
+}
+
+func TestParseBroker(t *testing.T) {
+	for broker, expected := range map[string]struct {
+		address string
+		useTLS  bool
+	}{
+		"mqtt://broker":        {"broker:1883", false},
+		"tcp://broker:1884":    {"broker:1884", false},
+		"mqtts://broker":       {"broker:8883", true},
+		"ssl://[::1]:8884":     {"[::1]:8884", true},
+		"tls://broker.example": {"broker.example:8883", true},
+	} {
+		address, useTLS, err := ParseBroker(broker)
+		if err != nil || address != expected.address || useTLS != expected.useTLS {
+			t.Errorf("%s: want %s with TLS %v, got %s with TLS %v (%v)", broker, expected.address, expected.useTLS, address, useTLS, err)
+		}
+	}
+	if _, _, err := ParseBroker("http://broker"); err == nil {
+		t.Error("want an error for the scheme http")
+	}
+}
@@ -0,0 +1,160 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const testInfo = `INFO {"server_id":"NCXMJZYQEWUDJFLYLSTTE745I2WUNCVG3LJJ3NRKSFJXEG6RGK7753DJ","version":"2.9.0","proto":1,"headers":true,"max_payload":1048576}` + "\r\n"
+
+// fakeServer accepts one connection, greets with INFO and hands the connection to serve
+func fakeServer(t *testing.T, serve func(r *bufio.Reader, w io.Writer) error) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		_, err = io.WriteString(conn, testInfo)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- serve(bufio.NewReader(conn), conn)
+	}()
+	return l.Addr().String(), done
+}
+
+// expect reads the next line and compares it without the CRLF
+func expect(r *bufio.Reader, expected string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return fmt.Errorf("the line %q doesn't end with CRLF", line)
+	}
+	if line = strings.TrimSuffix(line, "\r\n"); line != expected {
+		return fmt.Errorf("want %q, got %q", expected, line)
+	}
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	address, done := fakeServer(t, func(r *bufio.Reader, w io.Writer) error {
+		for _, line := range []string{
+			`CONNECT {"lang":"go","name":"deadman-switch","pass":"secret","pedantic":false,"protocol":1,"user":"dms","verbose":false}`,
+			"PUB alerts.backup 5",
+			"hello",
+			"PING",
+		} {
+			if err := expect(r, line); err != nil {
+				return err
+			}
+		}
+		// a ping of the server is answered before the pong arrives
+		_, err := io.WriteString(w, "PING\r\nPONG\r\n")
+		if err != nil {
+			return err
+		}
+		return expect(r, "PONG")
+	})
+	err := Publish(context.Background(), Options{Server: "nats://dms:secret@" + address, Name: "deadman-switch"}, Message{Subject: "alerts.backup", Data: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	address, _ := fakeServer(t, func(r *bufio.Reader, w io.Writer) error {
+		if err := expect(r, `CONNECT {"auth_token":"t0ken","lang":"go","name":"","pedantic":false,"protocol":1,"verbose":false}`); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "-ERR 'Authorization Violation'\r\n")
+		return err
+	})
+	err := Publish(context.Background(), Options{Server: "nats://t0ken@" + address}, Message{Subject: "alerts", Data: []byte("hello")})
+	if err == nil || err.Error() != "the server answered Authorization Violation" {
+		t.Fatalf("want the authorization violation, got %v", err)
+	}
+}
+
+// jetStreamServer answers the publish to the inbox of the SUB with the message
+func jetStreamServer(t *testing.T, reply func(inbox string) string) (string, <-chan error) {
+	return fakeServer(t, func(r *bufio.Reader, w io.Writer) error {
+		err := expect(r, `CONNECT {"headers":true,"lang":"go","name":"","no_responders":true,"pedantic":false,"protocol":1,"verbose":false}`)
+		if err != nil {
+			return err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		var inbox string
+		if _, err = fmt.Sscanf(line, "SUB %s 1\r\n", &inbox); err != nil || !strings.HasPrefix(inbox, "_INBOX.") {
+			return fmt.Errorf("want a SUB to an inbox, got %q", line)
+		}
+		for _, line := range []string{"PUB events " + inbox + " 2", "{}"} {
+			if err := expect(r, line); err != nil {
+				return err
+			}
+		}
+		_, err = io.WriteString(w, reply(inbox))
+		return err
+	})
+}
+
+func TestPublishJetStream(t *testing.T) {
+	address, done := jetStreamServer(t, func(inbox string) string {
+		ack := `{"stream":"EVENTS","seq":7}`
+		return fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(ack), ack)
+	})
+	ack, err := PublishJetStream(context.Background(), Options{Server: "nats://" + address}, Message{Subject: "events", Data: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Stream != "EVENTS" || ack.Sequence != 7 {
+		t.Fatalf("unexpected acknowledgement %+v", ack)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishJetStreamNoResponders(t *testing.T) {
+	address, _ := jetStreamServer(t, func(inbox string) string {
+		headers := "NATS/1.0 503\r\n\r\n"
+		return fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", inbox, len(headers), len(headers), headers)
+	})
+	_, err := PublishJetStream(context.Background(), Options{Server: "nats://" + address}, Message{Subject: "events", Data: []byte("{}")})
+	if err != ErrNoResponders {
+		t.Fatalf("want ErrNoResponders, got %v", err)
+	}
+}
+
+func TestPublishJetStreamError(t *testing.T) {
+	address, _ := jetStreamServer(t, func(inbox string) string {
+		ack := `{"error":{"code":503,"description":"insufficient resources"}}`
+		return fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(ack), ack)
+	})
+	_, err := PublishJetStream(context.Background(), Options{Server: "nats://" + address}, Message{Subject: "events", Data: []byte("{}")})
+	if err == nil || err.Error() != "jetstream error 503: insufficient resources" {
+		t.Fatalf("want the jetstream error, got %v", err)
+	}
+}
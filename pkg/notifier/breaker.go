@@ -0,0 +1,299 @@
+package notifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCoolDown = 5 * time.Minute
+	// halfOpenRetryDelay is the delay of the notifications which arrive while the trial of a half-open breaker is running
+	halfOpenRetryDelay = firstRetryDelay
+)
+
+// breakerStatus is the state of a circuit breaker
+type breakerStatus string
+
+const (
+	// breakerClosed lets all notifications through
+	breakerClosed breakerStatus = "closed"
+	// breakerOpen rejects all notifications until the cool-down is over
+	breakerOpen breakerStatus = "open"
+	// breakerHalfOpen lets a single trial through, which closes or opens the breaker again
+	breakerHalfOpen breakerStatus = "half-open"
+)
+
+// BreakerState is the circuit breaker of one notification target since the process started
+type BreakerState struct {
+	Target              string        `json:"target"`
+	State               breakerStatus `json:"state"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastError           string        `json:"lastError,omitempty"`
+	OpenedAt            *time.Time    `json:"openedAt,omitempty"`
+	// RetryAt is the end of the cool-down of an open breaker
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+	// Opened counts how often the breaker opened, Rejected the notifications which weren't attempted
+	Opened   uint64 `json:"opened"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// circuitOpenError is returned for notifications to a target with an open breaker
+type circuitOpenError struct {
+	target  string
+	retryAt time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open until %s", e.target, e.retryAt.Format(time.RFC3339))
+}
+
+// breakers keeps a circuit breaker per notification target, the notifier passes the time of its clock
+type breakers struct {
+	cfg      config.CircuitBreakerConfig
+	mutex    sync.Mutex
+	byTarget map[string]*BreakerState
+}
+
+func newBreakers(cfg config.CircuitBreakerConfig) *breakers {
+	if cfg.Failures <= 0 {
+		cfg.Failures = defaultBreakerFailures
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = config.Duration(defaultBreakerCoolDown)
+	}
+	return &breakers{cfg: cfg, byTarget: make(map[string]*BreakerState)}
+}
+
+// allow returns a *circuitOpenError if the notification must not be attempted now
+func (b *breakers) allow(target string, now time.Time) error {
+	if b.cfg.Disabled {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state, ok := b.byTarget[target]
+	if !ok {
+		return nil
+	}
+	switch state.State {
+	case breakerOpen:
+		if now.Before(*state.RetryAt) {
+			state.Rejected++
+			return &circuitOpenError{target, *state.RetryAt}
+		}
+		state.State = breakerHalfOpen
+		log.Info().Str("target", target).Msg("circuit breaker is half-open, trying a notification")
+	case breakerHalfOpen:
+		state.Rejected++
+		return &circuitOpenError{target, now.Add(halfOpenRetryDelay)}
+	}
+	return nil
+}
+
+// record counts the result of an attempt, it returns the new status if the breaker opened or closed
+func (b *breakers) record(target string, err error, now time.Time) (changed bool, state BreakerState) {
+	if b.cfg.Disabled {
+		return false, state
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	current, ok := b.byTarget[target]
+	if !ok {
+		if err == nil {
+			return false, state
+		}
+		current = &BreakerState{Target: target, State: breakerClosed}
+		b.byTarget[target] = current
+	}
+	if err == nil {
+		changed = current.State != breakerClosed
+		current.State = breakerClosed
+		current.ConsecutiveFailures = 0
+		current.OpenedAt = nil
+		current.RetryAt = nil
+		return changed, *current
+	}
+	current.ConsecutiveFailures++
+	current.LastError = err.Error()
+	retryAt := now.Add(time.Duration(b.cfg.CoolDown))
+	switch {
+	case current.State == breakerHalfOpen:
+		// the trial failed, the breaker is still broken and was reported already
+		current.State = breakerOpen
+		current.RetryAt = &retryAt
+	case current.State == breakerClosed && current.ConsecutiveFailures >= b.cfg.Failures:
+		changed = true
+		current.State = breakerOpen
+		current.OpenedAt = &now
+		current.RetryAt = &retryAt
+		current.Opened++
+	}
+	return changed, *current
+}
+
+func (b *breakers) list() []BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	result := make([]BreakerState, 0, len(b.byTarget))
+	for _, state := range b.byTarget {
+		result = append(result, *state)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Target < result[j].Target
+	})
+	return result
+}
+
+// reset closes the breaker of a target, it returns false if there is none
+func (b *breakers) reset(target string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state, ok := b.byTarget[target]
+	if !ok {
+		return false
+	}
+	state.State = breakerClosed
+	state.ConsecutiveFailures = 0
+	state.OpenedAt = nil
+	state.RetryAt = nil
+	return true
+}
+
+// breakerTarget identifies the endpoint of a notification. URLs are reduced to their host,
+// so they can be shown without the secrets they often contain.
+func breakerTarget(notification config.NotificationConfig) string {
+	switch notification.Type {
+	case config.NotificationTypeWebhook:
+		cfg, err := notification.GetWebhookConfig()
+		if err == nil {
+			return targetHost(notification.Type, cfg.URL)
+		}
+	case config.NotificationTypeCallback:
+		cfg, err := notification.GetCallbackConfig()
+		if err == nil {
+			return targetHost(notification.Type, cfg.URL)
+		}
+	case config.NotificationTypeSlack:
+		cfg, err := notification.GetSlackConfig()
+		if err == nil {
+			// channels of different workspaces share their names and IDs
+			workspace := cfg.Workspace
+			if workspace == "" {
+				workspace = tokenFingerprint(cfg.Token)
+			}
+			return string(notification.Type) + ":" + workspace + ":" + cfg.Channel
+		}
+	case config.NotificationTypePagerDuty:
+		cfg, err := notification.GetPagerDutyConfig()
+		if err == nil && cfg.URL != "" {
+			return targetHost(notification.Type, cfg.URL)
+		}
+	case config.NotificationTypeOpsgenie:
+		cfg, err := notification.GetOpsgenieConfig()
+		if err == nil && cfg.URL != "" {
+			return targetHost(notification.Type, cfg.URL)
+		}
+	case config.NotificationTypeEmail:
+		cfg, err := notification.GetEmailConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.Host
+		}
+	case config.NotificationTypeDiscord:
+		cfg, err := notification.GetDiscordConfig()
+		if err == nil {
+			if id := discordWebhookID(cfg.URL); id != "" {
+				return string(notification.Type) + ":" + id
+			}
+		}
+	case config.NotificationTypeMQTT:
+		cfg, err := notification.GetMQTTConfig()
+		if err == nil {
+			return targetHost(notification.Type, cfg.Broker)
+		}
+	case config.NotificationTypeNATS:
+		cfg, err := notification.GetNATSConfig()
+		if err == nil {
+			return targetHost(notification.Type, cfg.URL)
+		}
+	case config.NotificationTypeZabbix:
+		cfg, err := notification.GetZabbixConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.Server
+		}
+	case config.NotificationTypeNSCA:
+		cfg, err := notification.GetNSCAConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.Server
+		}
+	case config.NotificationTypeEventBridge:
+		cfg, err := notification.GetEventBridgeConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.Region
+		}
+	case config.NotificationTypePubSub:
+		cfg, err := notification.GetPubSubConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.Topic
+		}
+	case config.NotificationTypeKafka:
+		cfg, err := notification.GetKafkaConfig()
+		if err == nil && len(cfg.Brokers) > 0 {
+			// the brokers of a cluster fail together
+			return string(notification.Type) + ":" + cfg.Brokers[0]
+		}
+	case config.NotificationTypeTwilio:
+		cfg, err := notification.GetTwilioConfig()
+		if err == nil {
+			return string(notification.Type) + ":" + cfg.AccountSID
+		}
+	}
+	return string(notification.Type)
+}
+
+// tokenFingerprint tells tokens apart without showing them
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+func targetHost(typ config.NotificationType, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return string(typ)
+	}
+	return string(typ) + ":" + u.Host
+}
+
+// notifyBreakerChange reports an opened or closed breaker through the meta notifications
+func (n *defaultNotifierType) notifyBreakerChange(ctx context.Context, state BreakerState) {
+	resolved := true
+	details := fmt.Sprintf("notifications to %s work again", state.Target)
+	if state.State == breakerOpen {
+		resolved = false
+		details = fmt.Sprintf("notifications to %s failed %d times in a row, pausing them until %s: %s",
+			state.Target, state.ConsecutiveFailures, state.RetryAt.Format(time.RFC3339), state.LastError)
+		log.Error().Str("target", state.Target).Str("error", state.LastError).Time("retryAt", *state.RetryAt).Msg("circuit breaker opened")
+	} else {
+		log.Info().Str("target", state.Target).Msg("circuit breaker closed")
+	}
+	// failures are logged by SendMetaNotifications, there is nothing else to do about them
+	_ = n.SendMetaNotifications(ctx, n.breakers.cfg.Notifications, "notifications", resolved, details)
+}
+
+func (n *defaultNotifierType) Breakers() []BreakerState {
+	return n.breakers.list()
+}
+
+func (n *defaultNotifierType) ResetBreaker(target string) bool {
+	return n.breakers.reset(target)
+}
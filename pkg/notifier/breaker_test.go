@@ -0,0 +1,153 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func TestBreakers(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	failure := errors.New("503")
+	type step struct {
+		// at is the offset from t0, err the result of the attempt if it is allowed
+		at      time.Duration
+		err     error
+		allowed bool
+		changed bool
+		state   breakerStatus
+	}
+	for _, test := range []struct {
+		name  string
+		cfg   config.CircuitBreakerConfig
+		steps []step
+	}{
+		{
+			name: "opens after the failures in a row",
+			cfg:  config.CircuitBreakerConfig{Failures: 2, CoolDown: config.Duration(time.Minute)},
+			steps: []step{
+				{at: 0, err: failure, allowed: true, state: breakerClosed},
+				{at: time.Second, err: failure, allowed: true, changed: true, state: breakerOpen},
+				{at: 2 * time.Second, allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name: "a success resets the count",
+			cfg:  config.CircuitBreakerConfig{Failures: 2, CoolDown: config.Duration(time.Minute)},
+			steps: []step{
+				{at: 0, err: failure, allowed: true, state: breakerClosed},
+				{at: time.Second, allowed: true, state: breakerClosed},
+				{at: 2 * time.Second, err: failure, allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name: "a successful trial after the cool-down closes it",
+			cfg:  config.CircuitBreakerConfig{Failures: 1, CoolDown: config.Duration(time.Minute)},
+			steps: []step{
+				{at: 0, err: failure, allowed: true, changed: true, state: breakerOpen},
+				{at: 59 * time.Second, allowed: false, state: breakerOpen},
+				{at: time.Minute, allowed: true, changed: true, state: breakerClosed},
+			},
+		},
+		{
+			name: "a failed trial opens it again without a report",
+			cfg:  config.CircuitBreakerConfig{Failures: 1, CoolDown: config.Duration(time.Minute)},
+			steps: []step{
+				{at: 0, err: failure, allowed: true, changed: true, state: breakerOpen},
+				{at: time.Minute, err: failure, allowed: true, state: breakerOpen},
+				{at: time.Minute + 59*time.Second, allowed: false, state: breakerOpen},
+				{at: 2 * time.Minute, allowed: true, changed: true, state: breakerClosed},
+			},
+		},
+		{
+			name: "disabled",
+			cfg:  config.CircuitBreakerConfig{Disabled: true, Failures: 1},
+			steps: []step{
+				{at: 0, err: failure, allowed: true},
+				{at: time.Second, err: failure, allowed: true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBreakers(test.cfg)
+			for i, step := range test.steps {
+				now := t0.Add(step.at)
+				err := b.allow("webhook:example.com", now)
+				if allowed := err == nil; allowed != step.allowed {
+					t.Fatalf("step %d: expected allowed %v, got %v", i, step.allowed, err)
+				}
+				if err != nil {
+					var open *circuitOpenError
+					if !errors.As(err, &open) {
+						t.Fatalf("step %d: expected a *circuitOpenError, got %T", i, err)
+					}
+				} else {
+					changed, state := b.record("webhook:example.com", step.err, now)
+					if changed != step.changed {
+						t.Fatalf("step %d: expected changed %v, got %v", i, step.changed, changed)
+					}
+					if test.cfg.Disabled {
+						continue
+					}
+					if state.State != step.state && !(state.State == "" && step.state == breakerClosed) {
+						t.Fatalf("step %d: expected state %q, got %q", i, step.state, state.State)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestWebhookBreaker checks that a webhook answering 5xx fails and opens its breaker on the notifier clock
+func TestWebhookBreaker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("try again later"))
+	}))
+	defer server.Close()
+	store, err := storage.NewMemoryStorage(ctx, config.ServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulated := clock.NewSimulated(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC))
+	breakerCfg := config.CircuitBreakerConfig{Failures: 2, CoolDown: config.Duration(time.Hour)}
+	n := NewNotifier(ctx, store, nil, false, config.ContactChannelsConfig{}, breakerCfg, nil, nil, nil, nil, nil, simulated).(*defaultNotifierType)
+	service := config.ServiceConfig{ID: "backup"}
+	notification := config.NotificationConfig{
+		Type:   config.NotificationTypeWebhook,
+		Config: map[string]interface{}{"url": server.URL, "method": http.MethodPost},
+	}
+
+	for i := 0; i < 2; i++ {
+		err = n.sendNotification(ctx, service, notification, messageKindAlert, "")
+		if err == nil || !strings.Contains(err.Error(), "503: try again later") {
+			t.Fatalf("attempt %d: expected the status and the body in the error, got %v", i, err)
+		}
+	}
+	err = n.sendNotification(ctx, service, notification, messageKindAlert, "")
+	var open *circuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("expected an open breaker, got %v", err)
+	}
+	if !open.retryAt.Equal(simulated.Now().Add(time.Hour)) {
+		t.Fatalf("expected the cool-down to end an hour after the simulated time, got %s", open.retryAt)
+	}
+
+	status = http.StatusNoContent
+	simulated.Advance(time.Hour)
+	err = n.sendNotification(ctx, service, notification, messageKindAlert, "")
+	if err != nil {
+		t.Fatalf("expected the trial after the simulated cool-down to pass, got %v", err)
+	}
+}
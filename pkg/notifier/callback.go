@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+type callbackEvent struct {
+	Service string    `json:"service"`
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+}
+
+// callbackNotifications returns the notification for the callback of the service, if it has one
+func callbackNotifications(service config.ServiceConfig) []config.NotificationConfig {
+	if service.Callback == nil || service.Callback.URL == "" {
+		return nil
+	}
+	return []config.NotificationConfig{{
+		Type: config.NotificationTypeCallback,
+		Config: map[string]interface{}{
+			"url":     service.Callback.URL,
+			"method":  service.Callback.Method,
+			"headers": service.Callback.Headers,
+		},
+	}}
+}
+
+func (n *defaultNotifierType) sendToCallback(ctx context.Context, service config.ServiceConfig, cfg config.CallbackConfig, kind messageKind) error {
+	event := "overdue"
+	switch kind {
+	case messageKindRecovery:
+		event = "recovered"
+	case messageKindCanary:
+		event = "canary"
+	case messageKindArchived:
+		event = "archived"
+	case messageKindWarning, messageKindCountdown:
+		// the service isn't overdue yet, there is nothing to react on
+		return nil
+	}
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	log.Info().
+		Str("service", service.ID).
+		Str("method", method).
+		Str("url", cfg.URL).
+		Str("event", event).
+		Msg("calling service callback")
+	bs, err := json.Marshal(callbackEvent{
+		Service: service.ID,
+		Event:   event,
+		Time:    n.clock.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, method, cfg.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	for key, values := range cfg.Headers {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := n.egressClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
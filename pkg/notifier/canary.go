@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// CanaryHeader carries the canary channel and nonce of a test notification to webhooks as <name>/<nonce>
+const CanaryHeader = "X-Deadman-Switch-Canary"
+
+// CanaryServiceID returns the service a test notification of a canary channel is sent for,
+// the nonce is its last segment, so every channel type carries it
+func CanaryServiceID(name, nonce string) string {
+	return metaServicePrefix + "canary/" + name + "/" + nonce
+}
+
+func (n *defaultNotifierType) SendCanary(ctx context.Context, notification config.NotificationConfig, name, nonce string) error {
+	service := config.ServiceConfig{ID: CanaryServiceID(name, nonce)}
+	if notification.Type == config.NotificationTypeWebhook {
+		cfg, err := notification.GetWebhookConfig()
+		if err != nil {
+			return err
+		}
+		headers := make(http.Header)
+		for key, values := range cfg.Headers {
+			headers[key] = values
+		}
+		headers.Set(CanaryHeader, name+"/"+nonce)
+		cfg.Headers = headers
+		return n.sendToWebhook(ctx, service, cfg, messageKindCanary, "nonce "+nonce)
+	}
+	return n.deliver(ctx, service, notification, messageKindCanary, "nonce "+nonce)
+}
@@ -0,0 +1,161 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/aws"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/gcp"
+)
+
+// cloudCredentials caches the credentials of the cloud providers between notifications
+type cloudCredentials struct {
+	mutex  sync.Mutex
+	aws    *aws.Provider
+	pubSub map[string]*gcp.TokenSource
+}
+
+var (
+	gcpCredentialsMutex sync.RWMutex
+	// gcpCredentials are the files of the declared service account keys by name
+	gcpCredentials = make(map[string]string)
+)
+
+// RegisterGCPCredentials declares the service account keys the pubsub notifications may refer to
+func RegisterGCPCredentials(credentials []config.GCPCredentialsConfig) error {
+	gcpCredentialsMutex.Lock()
+	defer gcpCredentialsMutex.Unlock()
+	for _, c := range credentials {
+		if c.Name == "" || c.File == "" {
+			return errors.New("gcp credentials need a name and a file")
+		}
+		if _, ok := gcpCredentials[c.Name]; ok {
+			return fmt.Errorf("the gcp credentials %s are declared twice", c.Name)
+		}
+		gcpCredentials[c.Name] = c.File
+	}
+	return nil
+}
+
+// gcpCredentialsFile returns the file of the declared credentials, empty for the default credentials
+func gcpCredentialsFile(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	gcpCredentialsMutex.RLock()
+	defer gcpCredentialsMutex.RUnlock()
+	file, ok := gcpCredentials[name]
+	if !ok {
+		return "", fmt.Errorf("unknown gcp credentials %q", name)
+	}
+	return file, nil
+}
+
+func (n *defaultNotifierType) awsProvider() *aws.Provider {
+	n.cloud.mutex.Lock()
+	defer n.cloud.mutex.Unlock()
+	if n.cloud.aws == nil {
+		n.cloud.aws = aws.NewProvider(n.httpClient)
+	}
+	return n.cloud.aws
+}
+
+// pubSubTokens returns the token source of the credentials file, the default credentials if it is empty
+func (n *defaultNotifierType) pubSubTokens(credentialsFile string) *gcp.TokenSource {
+	n.cloud.mutex.Lock()
+	defer n.cloud.mutex.Unlock()
+	if n.cloud.pubSub == nil {
+		n.cloud.pubSub = make(map[string]*gcp.TokenSource)
+	}
+	source, ok := n.cloud.pubSub[credentialsFile]
+	if !ok {
+		source = gcp.NewTokenSource(n.httpClient, credentialsFile, gcp.PubSubScope)
+		n.cloud.pubSub[credentialsFile] = source
+	}
+	return source
+}
+
+// cloudEventPayload returns the payload of the MQTT messages with the CloudEvents type of the message
+func (n *defaultNotifierType) cloudEventPayload(ctx context.Context, service config.ServiceConfig, kind messageKind, details string) (string, []byte, error) {
+	data := n.templateData(ctx, service, kind, details)
+	payload := newCloudEventData(data)
+	payload.Time = &data.Time
+	bs, err := json.Marshal(payload)
+	return cloudEventType(kind), bs, err
+}
+
+// sendToEventBridge puts the message as event on the bus, the detail type is the CloudEvents type of the message
+func (n *defaultNotifierType) sendToEventBridge(ctx context.Context, service config.ServiceConfig, cfg config.EventBridgeConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("region", cfg.Region).
+		Str("bus", cfg.EventBus).
+		Msg("putting eventbridge event")
+	typ, detail, err := n.cloudEventPayload(ctx, service, kind, details)
+	if err != nil {
+		return err
+	}
+	creds := aws.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey}
+	if creds.AccessKeyID == "" {
+		creds, err = n.awsProvider().Credentials(ctx, cfg.Region)
+		if err != nil {
+			return err
+		}
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = aws.EventBridgeEndpoint(cfg.Region)
+	}
+	source := cfg.Source
+	if source == "" {
+		source = defaultCloudEventSource
+	}
+	return aws.PutEvents(ctx, n.egressClient, endpoint, cfg.Region, creds, []aws.Event{{
+		EventBusName: cfg.EventBus,
+		Source:       source,
+		DetailType:   typ,
+		Detail:       string(detail),
+		Time:         n.clock.Now(),
+	}})
+}
+
+// sendToPubSub publishes the message to the topic, the attributes carry the service, the kind and the CloudEvents
+// type so subscriptions can filter on them
+func (n *defaultNotifierType) sendToPubSub(ctx context.Context, service config.ServiceConfig, cfg config.PubSubConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("topic", cfg.Topic).
+		Msg("publishing pubsub message")
+	typ, data, err := n.cloudEventPayload(ctx, service, kind, details)
+	if err != nil {
+		return err
+	}
+	endpoint, emulator := gcp.PubSubEndpoint()
+	var token string
+	if !emulator {
+		file, err := gcpCredentialsFile(cfg.Credentials)
+		if err != nil {
+			return err
+		}
+		token, err = n.pubSubTokens(file).Token(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	ids, err := gcp.Publish(ctx, n.egressClient, endpoint, token, cfg.Topic, []gcp.Message{{
+		Data:       data,
+		Attributes: map[string]string{"service": service.ID, "kind": string(kind), "type": typ},
+	}})
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("service", service.ID).Strs("ids", ids).Msg("pubsub accepted the message")
+	return nil
+}
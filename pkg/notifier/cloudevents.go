@@ -0,0 +1,94 @@
+package notifier
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultCloudEventSource = "deadman-switch"
+	cloudEventContentType   = "application/cloudevents+json"
+)
+
+// cloudEventTypes are the CloudEvents types of the message kinds
+var cloudEventTypes = map[messageKind]string{
+	messageKindAlert:        "io.deadman.alarm.fired",
+	messageKindRecovery:     "io.deadman.alarm.resolved",
+	messageKindWarning:      "io.deadman.service.warning",
+	messageKindCountdown:    "io.deadman.service.countdown",
+	messageKindApproval:     "io.deadman.action.approval",
+	messageKindArchived:     "io.deadman.service.archived",
+	messageKindMetaAlert:    "io.deadman.meta.alarm.fired",
+	messageKindMetaRecovery: "io.deadman.meta.alarm.resolved",
+	messageKindCanary:       "io.deadman.canary",
+}
+
+// cloudEvent is a CloudEvent 1.0 in the structured JSON mode
+type cloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            cloudEventData `json:"data"`
+}
+
+// cloudEventData is the payload of the events, like the one of the MQTT messages
+type cloudEventData struct {
+	Service          string            `json:"service"`
+	Kind             string            `json:"kind"`
+	Summary          string            `json:"summary"`
+	Details          string            `json:"details,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	LastHeartbeat    *time.Time        `json:"lastHeartbeat,omitempty"`
+	AlarmActiveSince *time.Time        `json:"alarmActiveSince,omitempty"`
+	Link             string            `json:"link,omitempty"`
+	// Time is left out of CloudEvents, which carry it themselves
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// cloudEventType returns the CloudEvents type of the message kind
+func cloudEventType(kind messageKind) string {
+	if typ, ok := cloudEventTypes[kind]; ok {
+		return typ
+	}
+	return "io.deadman." + string(kind)
+}
+
+func newCloudEventData(data templateData) cloudEventData {
+	return cloudEventData{
+		Service:          data.Service.ID,
+		Kind:             data.Kind,
+		Summary:          data.Summary,
+		Details:          data.Details,
+		Labels:           data.Labels,
+		LastHeartbeat:    data.LastHeartbeat,
+		AlarmActiveSince: data.AlarmActiveSince,
+		Link:             data.Link,
+	}
+}
+
+// cloudEventBody returns the event of the message, the service ID is its subject
+func cloudEventBody(cfg config.WebhookConfig, data templateData) (string, error) {
+	source := cfg.Source
+	if source == "" {
+		source = defaultCloudEventSource
+	}
+	event := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          source,
+		Type:            cloudEventType(messageKind(data.Kind)),
+		Subject:         data.Service.ID,
+		Time:            data.Time,
+		DataContentType: "application/json",
+		Data:            newCloudEventData(data),
+	}
+	bs, err := json.Marshal(event)
+	return string(bs), err
+}
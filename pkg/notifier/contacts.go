@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// contactNotifications resolves the contacts of the service into notifications on their preferred channels.
+// Contacts in their quiet hours are skipped.
+func (n *defaultNotifierType) contactNotifications(ctx context.Context, service config.ServiceConfig, now time.Time) []config.NotificationConfig {
+	return n.notificationsForContacts(ctx, service, service.Contacts, now)
+}
+
+func (n *defaultNotifierType) notificationsForContacts(ctx context.Context, service config.ServiceConfig, ids []string, now time.Time) []config.NotificationConfig {
+	var notifications []config.NotificationConfig
+	for _, id := range ids {
+		contact, err := n.store.GetContact(ctx, id)
+		if err != nil {
+			log.Error().Str("service", service.ID).Str("contact", id).Err(err).Msg("failed to load contact")
+			continue
+		}
+		notifications = append(notifications, n.notificationsForContact(service, contact, now)...)
+	}
+	return notifications
+}
+
+func (n *defaultNotifierType) notificationsForContact(service config.ServiceConfig, contact config.Contact, now time.Time) []config.NotificationConfig {
+	if contact.QuietHours != nil {
+		quiet, err := contact.QuietHours.Contains(now)
+		if err != nil {
+			log.Error().Str("contact", contact.ID).Err(err).Msg("invalid quiet hours")
+		}
+		if quiet {
+			log.Info().Str("service", service.ID).Str("contact", contact.ID).Msg("contact is in quiet hours")
+			return nil
+		}
+	}
+	var notifications []config.NotificationConfig
+	for _, channel := range contact.PreferredChannels {
+		switch channel {
+		case config.ContactChannelSlack:
+			if n.contactChannels.Slack == nil {
+				log.Warn().Str("contact", contact.ID).Msg("slack contact channel is not configured")
+				continue
+			}
+			notifications = append(notifications, config.NotificationConfig{
+				Type: config.NotificationTypeSlack,
+				Config: map[string]interface{}{
+					"token":     n.contactChannels.Slack.Token,
+					"workspace": n.contactChannels.Slack.Workspace,
+					"channel":   contact.SlackID,
+				},
+			})
+		case config.ContactChannelEmail:
+			email := n.contactChannels.Email
+			if email == nil {
+				log.Warn().Str("contact", contact.ID).Msg("email contact channel is not configured")
+				continue
+			}
+			notifications = append(notifications, config.NotificationConfig{
+				Type: config.NotificationTypeEmail,
+				Config: map[string]interface{}{
+					"host":     email.Host,
+					"port":     email.Port,
+					"username": email.Username,
+					"password": email.Password,
+					"tls":      email.TLS,
+					"from":     email.From,
+					"to":       []string{contact.Email},
+				},
+			})
+		case config.ContactChannelSMS:
+			sms := n.contactChannels.SMS
+			if sms == nil {
+				log.Warn().Str("contact", contact.ID).Msg("sms contact channel is not configured")
+				continue
+			}
+			notifications = append(notifications, config.NotificationConfig{
+				Type: config.NotificationTypeTwilio,
+				Config: map[string]interface{}{
+					"accountSid": sms.AccountSID,
+					"authToken":  sms.AuthToken,
+					"from":       sms.From,
+					"to":         []string{contact.Phone},
+					"url":        sms.URL,
+				},
+			})
+		default:
+			log.Warn().Str("contact", contact.ID).Str("channel", string(channel)).Msg("unknown contact channel")
+		}
+	}
+	return notifications
+}
@@ -0,0 +1,171 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// limits of Discord messages, see https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	maxDiscordTitleLength       = 256
+	maxDiscordDescriptionLength = 4096
+	maxDiscordFields            = 25
+	maxDiscordFieldValueLength  = 1024
+)
+
+// colors of the embeds
+const (
+	discordRed    = 0xED4245
+	discordGreen  = 0x57F287
+	discordYellow = 0xFEE75C
+	discordGrey   = 0x95A5A6
+)
+
+type discordWebhookMessage struct {
+	Content         string                 `json:"content,omitempty"`
+	Username        string                 `json:"username,omitempty"`
+	Embeds          []discordEmbed         `json:"embeds"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	URL         string              `json:"url,omitempty"`
+	Color       int                 `json:"color"`
+	Timestamp   string              `json:"timestamp"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordAllowedMentions restricts the mentions to the configured roles, service IDs and details must not ping anyone
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// sendToDiscord posts a message with an embed colored by the kind of the message. The roles are mentioned in alerts only.
+func (n *defaultNotifierType) sendToDiscord(ctx context.Context, service config.ServiceConfig, cfg config.DiscordConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Msg("sending discord message")
+	title, color := discordStyle(kind)
+	embed := discordEmbed{
+		Title:       title,
+		Description: truncate(messageSummary(service, kind, details), maxDiscordDescriptionLength),
+		URL:         n.link(ctx, service, kind),
+		Color:       color,
+		Timestamp:   n.clock.Now().UTC().Format(time.RFC3339),
+	}
+	// the deadman switch itself sends no heartbeats
+	if kind != messageKindMetaAlert && kind != messageKindMetaRecovery && kind != messageKindCanary {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: "service", Value: truncate(service.ID, maxDiscordFieldValueLength), Inline: true})
+		if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+			// Discord shows the time in the time zone of the reader
+			embed.Fields = append(embed.Fields, discordEmbedField{Name: "last heartbeat", Value: fmt.Sprintf("<t:%d:R>", lastHeartbeat.Unix()), Inline: true})
+		}
+		keys := make([]string, 0, len(service.Labels))
+		for key := range service.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if len(embed.Fields) == maxDiscordFields {
+				break
+			}
+			embed.Fields = append(embed.Fields, discordEmbedField{
+				Name:   truncate(key, maxDiscordTitleLength),
+				Value:  truncate(service.Labels[key], maxDiscordFieldValueLength),
+				Inline: true,
+			})
+		}
+	}
+	msg := discordWebhookMessage{
+		Username:        cfg.Username,
+		Embeds:          []discordEmbed{embed},
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}
+	if (kind == messageKindAlert || kind == messageKindMetaAlert) && len(cfg.Roles) > 0 {
+		mentions := make([]string, len(cfg.Roles))
+		for i, role := range cfg.Roles {
+			mentions[i] = "<@&" + role + ">"
+		}
+		msg.Content = strings.Join(mentions, " ")
+		msg.AllowedMentions.Roles = cfg.Roles
+	}
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.egressClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// discordStyle returns the title and the color of the embed of a message
+func discordStyle(kind messageKind) (string, int) {
+	switch kind {
+	case messageKindRecovery:
+		return "RECOVERY", discordGreen
+	case messageKindWarning:
+		return "WARNING", discordYellow
+	case messageKindCountdown:
+		return "COUNTDOWN", discordYellow
+	case messageKindApproval:
+		return "APPROVAL REQUIRED", discordYellow
+	case messageKindArchived:
+		return "ARCHIVED", discordGrey
+	case messageKindMetaAlert:
+		return "DEADMAN SWITCH PROBLEM", discordRed
+	case messageKindMetaRecovery:
+		return "DEADMAN SWITCH RECOVERED", discordGreen
+	case messageKindCanary:
+		return "CANARY", discordGrey
+	}
+	return "ALERT", discordRed
+}
+
+// discordWebhookID returns the ID of the webhook from its URL, the token after it is a secret
+func discordWebhookID(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "webhooks" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultEmailSubject = `[deadman-switch] {{.Summary}}`
+	defaultEmailBody    = `{{.Summary}}
+{{with .Details}}
+{{.}}
+{{end}}
+service: {{.Service.ID}}
+{{- with .LastHeartbeat}}
+last heartbeat: {{.Format "2006-01-02 15:04:05 MST"}}
+{{- end}}
+{{- range $key, $value := .Service.Labels}}
+{{$key}}: {{$value}}
+{{- end}}
+{{- with .Link}}
+
+{{.}}
+{{- end}}
+`
+	emailTimeout = 10 * time.Second
+)
+
+// sendToEmail renders the subject and the body and sends the mail to all recipients through the SMTP server
+func (n *defaultNotifierType) sendToEmail(ctx context.Context, service config.ServiceConfig, cfg config.EmailConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("host", cfg.Host).
+		Strs("to", cfg.To).
+		Msg("sending email")
+	subject, body, err := renderEmail(cfg, n.templateData(ctx, service, kind, details))
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	to := make([]*mail.Address, 0, len(cfg.To))
+	for _, recipient := range cfg.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return err
+		}
+		to = append(to, address)
+	}
+	msg, err := composeEmail(from, to, subject, body, n.clock.Now())
+	if err != nil {
+		return err
+	}
+	return n.sendMail(ctx, cfg, from, to, msg)
+}
+
+// renderEmail renders the subject and the body with the default templates for the unset ones
+func renderEmail(cfg config.EmailConfig, data templateData) (subject, body string, err error) {
+	subjectTemplate, bodyTemplate := cfg.Subject, cfg.Body
+	if subjectTemplate == "" {
+		subjectTemplate = defaultEmailSubject
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = defaultEmailBody
+	}
+	subject, err = render("subject", subjectTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render the subject: %w", err)
+	}
+	body, err = render("body", bodyTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render the body: %w", err)
+	}
+	return subject, body, nil
+}
+
+// composeEmail builds a plain text mail, the subject is folded into one line so it can't inject headers
+func composeEmail(from *mail.Address, to []*mail.Address, subject, body string, now time.Time) ([]byte, error) {
+	subject = strings.Join(strings.Fields(subject), " ")
+	recipients := make([]string, len(to))
+	for i, address := range to {
+		recipients[i] = address.String()
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	_, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+	err = qp.Close()
+	return buf.Bytes(), err
+}
+
+func (n *defaultNotifierType) sendMail(ctx context.Context, cfg config.EmailConfig, from *mail.Address, to []*mail.Address, msg []byte) error {
+	port := cfg.Port
+	if port == 0 {
+		switch cfg.TLS {
+		case "tls":
+			port = 465
+		case "none":
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	dialer := &net.Dialer{Control: n.egress.Control}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	if cfg.TLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if cfg.TLS == "" || cfg.TLS == "starttls" {
+		// without TLS the credentials would be sent in plain text, so the STARTTLS extension is required
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS", address)
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient.Address)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
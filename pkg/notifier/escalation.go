@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// escalate notifies the escalation tiers which the alarm reached since the last alerts right away, regardless of the debounce.
+// It returns the tiers which were notified before, they get the regular alerts from now on.
+func (n *defaultNotifierType) escalate(ctx context.Context, service config.ServiceConfig, now time.Time) ([]config.EscalationTier, error) {
+	if len(service.Escalation) == 0 {
+		return nil, nil
+	}
+	activeSince, err := n.store.GetAlarmActiveSince(ctx, service.ID)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state, err := n.store.GetEscalation(ctx, service.ID)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	if !state.AlarmActiveSince.Equal(activeSince) {
+		state = storage.Escalation{AlarmActiveSince: activeSince}
+	}
+	active := now.Sub(activeSince)
+	reached := service.Escalation.Reached(active)
+	if len(reached) <= state.Level {
+		return reached, nil
+	}
+	log.Info().Str("service", service.ID).Int("level", len(reached)).Msg("escalate alarm")
+	details := fmt.Sprintf("unacknowledged for %s", active.Round(time.Second))
+	err = n.send(ctx, service, n.tierAlertNotifications(ctx, service, reached[state.Level:], now), messageKindAlert, details)
+	if err != nil {
+		return nil, err
+	}
+	notified := reached[:state.Level]
+	state.Level = len(reached)
+	return notified, n.store.SetEscalation(ctx, service.ID, state)
+}
+
+// escalatedTiers returns the tiers which were notified during the last alarm, it forgets them for the next one
+func (n *defaultNotifierType) escalatedTiers(ctx context.Context, service config.ServiceConfig) []config.EscalationTier {
+	if len(service.Escalation) == 0 {
+		return nil
+	}
+	state, err := n.store.GetEscalation(ctx, service.ID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to get escalation")
+		return nil
+	}
+	err = n.store.ClearEscalation(ctx, service.ID)
+	if err != nil {
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to clear escalation")
+	}
+	tiers := service.Escalation.Reached(math.MaxInt64)
+	if state.Level < len(tiers) {
+		tiers = tiers[:state.Level]
+	}
+	return tiers
+}
+
+func (n *defaultNotifierType) tierAlertNotifications(ctx context.Context, service config.ServiceConfig, tiers []config.EscalationTier, now time.Time) []config.NotificationConfig {
+	var notifications []config.NotificationConfig
+	for _, tier := range tiers {
+		notifications = append(notifications, tier.AlertNotifications...)
+		notifications = append(notifications, n.notificationsForContacts(ctx, service, tier.Contacts, now)...)
+	}
+	return notifications
+}
+
+func (n *defaultNotifierType) tierRecoveryNotifications(ctx context.Context, service config.ServiceConfig, tiers []config.EscalationTier, now time.Time) []config.NotificationConfig {
+	var notifications []config.NotificationConfig
+	for _, tier := range tiers {
+		notifications = append(notifications, tier.RecoveryNotifications...)
+		notifications = append(notifications, n.notificationsForContacts(ctx, service, tier.Contacts, now)...)
+	}
+	return notifications
+}
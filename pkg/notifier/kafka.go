@@ -0,0 +1,139 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/kafka"
+)
+
+// kafkaAvroSchema is the schema of the events with avro serialization, the fields match kafkaEvent
+const kafkaAvroSchema = `{"type":"record","name":"Event","namespace":"io.deadmanswitch","fields":[` +
+	`{"name":"service","type":"string"},` +
+	`{"name":"kind","type":"string"},` +
+	`{"name":"summary","type":"string"},` +
+	`{"name":"details","type":"string"},` +
+	`{"name":"labels","type":{"type":"map","values":"string"}},` +
+	`{"name":"lastHeartbeat","type":["null",{"type":"long","logicalType":"timestamp-millis"}],"default":null},` +
+	`{"name":"link","type":"string"},` +
+	`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}}]}`
+
+// kafkaEvent is the value of the produced messages
+type kafkaEvent struct {
+	Service       string            `json:"service"`
+	Kind          string            `json:"kind"`
+	Summary       string            `json:"summary"`
+	Details       string            `json:"details,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	LastHeartbeat *time.Time        `json:"lastHeartbeat,omitempty"`
+	Link          string            `json:"link,omitempty"`
+	Time          time.Time         `json:"time"`
+}
+
+// sendToKafka produces the event to the topic with the service ID as key, so the events of a service stay in order
+func (n *defaultNotifierType) sendToKafka(ctx context.Context, service config.ServiceConfig, cfg config.KafkaConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("topic", cfg.Topic).
+		Msg("producing kafka event")
+	event := kafkaEvent{
+		Service: service.ID,
+		Kind:    string(kind),
+		Summary: messageSummary(service, kind, ""),
+		Details: details,
+		Labels:  service.Labels,
+		Link:    n.link(ctx, service, kind),
+		Time:    n.clock.Now().UTC(),
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		event.LastHeartbeat = &lastHeartbeat
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.httpClient.Timeout)
+	defer cancel()
+	var (
+		value []byte
+		err   error
+	)
+	if cfg.Serialization == "avro" {
+		value = event.avro()
+		if cfg.SchemaRegistry != "" {
+			var id int32
+			id, err = kafka.RegisterSchema(ctx, n.egressClient, cfg.SchemaRegistry, cfg.Topic+"-value", kafkaAvroSchema)
+			if err != nil {
+				return fmt.Errorf("failed to register the avro schema: %w", err)
+			}
+			value = kafka.WireFormat(id, value)
+		}
+	} else {
+		value, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+	}
+	opts := kafka.Options{
+		Brokers:  cfg.Brokers,
+		ClientID: "deadman-switch",
+		Username: cfg.Username,
+		Password: cfg.Password,
+		Control:  n.egress.Control,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{}
+	}
+	return kafka.Produce(ctx, opts, kafka.Message{
+		Topic: cfg.Topic,
+		Key:   []byte(service.ID),
+		Value: value,
+		Time:  event.Time,
+	})
+}
+
+// avro encodes the event in the avro binary encoding of kafkaAvroSchema
+func (e kafkaEvent) avro() []byte {
+	var b []byte
+	long := func(v int64) {
+		var buf [binary.MaxVarintLen64]byte
+		b = append(b, buf[:binary.PutVarint(buf[:], v)]...)
+	}
+	str := func(s string) {
+		long(int64(len(s)))
+		b = append(b, s...)
+	}
+	millis := func(t time.Time) int64 {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	str(e.Service)
+	str(e.Kind)
+	str(e.Summary)
+	str(e.Details)
+	if len(e.Labels) > 0 {
+		keys := make([]string, 0, len(e.Labels))
+		for key := range e.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		long(int64(len(keys)))
+		for _, key := range keys {
+			str(key)
+			str(e.Labels[key])
+		}
+	}
+	long(0) // end of the map
+	if e.LastHeartbeat != nil {
+		long(1)
+		long(millis(*e.LastHeartbeat))
+	} else {
+		long(0)
+	}
+	str(e.Link)
+	long(millis(e.Time))
+	return b
+}
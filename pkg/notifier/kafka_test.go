@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestKafkaEventAvro compares the encoding with one derived by hand from the avro spec and kafkaAvroSchema
+func TestKafkaEventAvro(t *testing.T) {
+	t0 := time.Unix(1600000000, 0).UTC()
+	for _, test := range []struct {
+		event    kafkaEvent
+		expected string
+	}{
+		{
+			event: kafkaEvent{
+				Service:       "db",
+				Kind:          "alert",
+				Summary:       "s",
+				Labels:        map[string]string{"team": "ops", "env": "prod"},
+				LastHeartbeat: &t0,
+				Time:          t0.Add(time.Second),
+			},
+			expected: "046462" + // service
+				"0a616c657274" + // kind
+				"0273" + // summary
+				"00" + // details
+				"04" + "06656e76" + "0870726f64" + "087465616d" + "066f7073" + "00" + // labels sorted by key
+				"02" + "8080f4f6905d" + // the last heartbeat, the second branch of the union
+				"00" + // link
+				"d08ff4f6905d", // time
+		},
+		{
+			event:    kafkaEvent{Service: "db", Kind: "recovery", Time: t0},
+			expected: "046462" + "107265636f7665727900" + "00" + "00" + "00" + "00" + "8080f4f6905d",
+		},
+	} {
+		if got := hex.EncodeToString(test.event.avro()); got != test.expected {
+			t.Errorf("%s: want\n%s\ngot\n%s", test.event.Kind, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// link returns the short link to the page of the service. It is empty if links are not configured
+// and for messages which are not about the state of the service.
+func (n *defaultNotifierType) link(ctx context.Context, service config.ServiceConfig, kind messageKind) string {
+	if n.links == nil {
+		return ""
+	}
+	switch kind {
+	case messageKindAlert, messageKindRecovery, messageKindWarning, messageKindCountdown:
+	default:
+		return ""
+	}
+	link, err := n.links.Create(ctx, service.ID, n.clock.Now())
+	if err != nil {
+		// the message is more important than the link
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to create link")
+		return ""
+	}
+	return link
+}
@@ -0,0 +1,29 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// metaServicePrefix is prepended to the problem to form the service the meta notifications are sent for
+const metaServicePrefix = "deadman-switch/"
+
+func (n *defaultNotifierType) SendMetaNotifications(ctx context.Context, notifications []config.NotificationConfig, problem string, resolved bool, details string) error {
+	kind := messageKindMetaAlert
+	if resolved {
+		kind = messageKindMetaRecovery
+	}
+	service := config.ServiceConfig{ID: metaServicePrefix + problem}
+	var failed error
+	for _, notification := range notifications {
+		err := n.deliver(ctx, service, notification, kind, details)
+		if err != nil {
+			log.Error().Str("problem", problem).Str("type", string(notification.Type)).Err(err).Msg("failed to send meta notification")
+			failed = errors.New("failed to send some meta notifications")
+		}
+	}
+	return failed
+}
@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/mqtt"
+)
+
+// mqttMessage is the JSON payload of the published messages
+type mqttMessage struct {
+	Service       string            `json:"service"`
+	Kind          string            `json:"kind"`
+	Summary       string            `json:"summary"`
+	Details       string            `json:"details,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	LastHeartbeat *time.Time        `json:"lastHeartbeat,omitempty"`
+	Link          string            `json:"link,omitempty"`
+	Time          time.Time         `json:"time"`
+}
+
+// sendToMQTT publishes the message as JSON to the topic, with {service} and {kind} replaced
+func (n *defaultNotifierType) sendToMQTT(ctx context.Context, service config.ServiceConfig, cfg config.MQTTConfig, kind messageKind, details string) error {
+	topic := strings.NewReplacer("{service}", service.ID, "{kind}", string(kind)).Replace(cfg.Topic)
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("topic", topic).
+		Msg("publishing mqtt message")
+	msg := mqttMessage{
+		Service: service.ID,
+		Kind:    string(kind),
+		Summary: messageSummary(service, kind, ""),
+		Details: details,
+		Labels:  service.Labels,
+		Link:    n.link(ctx, service, kind),
+		Time:    n.clock.Now().UTC(),
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		msg.LastHeartbeat = &lastHeartbeat
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var qos byte = 1
+	if cfg.QoS != nil {
+		qos = byte(*cfg.QoS)
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		// brokers drop the older connection of a client ID, so concurrent sends need their own
+		suffix, err := ids.Random(6)
+		if err != nil {
+			return err
+		}
+		clientID = "deadman-switch-" + suffix
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.httpClient.Timeout)
+	defer cancel()
+	return mqtt.Publish(ctx, mqtt.Options{
+		Broker:   cfg.Broker,
+		ClientID: clientID,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		Control:  n.egress.Control,
+	}, mqtt.Message{
+		Topic:   topic,
+		Payload: payload,
+		QoS:     qos,
+		Retain:  cfg.Retain,
+	})
+}
@@ -0,0 +1,67 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/nats"
+)
+
+// natsMessage is the JSON payload of the published messages
+type natsMessage struct {
+	Service       string            `json:"service"`
+	Kind          string            `json:"kind"`
+	Summary       string            `json:"summary"`
+	Details       string            `json:"details,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	LastHeartbeat *time.Time        `json:"lastHeartbeat,omitempty"`
+	Link          string            `json:"link,omitempty"`
+	Time          time.Time         `json:"time"`
+}
+
+// sendToNATS publishes the message as JSON to the subject, with {service} and {kind} replaced
+func (n *defaultNotifierType) sendToNATS(ctx context.Context, service config.ServiceConfig, cfg config.NATSConfig, kind messageKind, details string) error {
+	subject := strings.NewReplacer("{service}", service.ID, "{kind}", string(kind)).Replace(cfg.Subject)
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("subject", subject).
+		Bool("jetstream", cfg.JetStream).
+		Msg("publishing nats message")
+	msg := natsMessage{
+		Service: service.ID,
+		Kind:    string(kind),
+		Summary: messageSummary(service, kind, ""),
+		Details: details,
+		Labels:  service.Labels,
+		Link:    n.link(ctx, service, kind),
+		Time:    n.clock.Now().UTC(),
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		msg.LastHeartbeat = &lastHeartbeat
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.httpClient.Timeout)
+	defer cancel()
+	opts := nats.Options{Server: cfg.URL, Name: "deadman-switch", Control: n.egress.Control}
+	if !cfg.JetStream {
+		return nats.Publish(ctx, opts, nats.Message{Subject: subject, Data: data})
+	}
+	ack, err := nats.PublishJetStream(ctx, opts, nats.Message{Subject: subject, Data: data})
+	if err != nil {
+		return err
+	}
+	log.Debug().
+		Str("service", service.ID).
+		Str("stream", ack.Stream).
+		Uint64("sequence", ack.Sequence).
+		Msg("jetstream acknowledged the nats message")
+	return nil
+}
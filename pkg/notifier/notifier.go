@@ -4,53 +4,139 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/slack-go/slack"
+	"github.com/trusch/deadman-switch/pkg/clock"
 	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/hooks"
 	"github.com/trusch/deadman-switch/pkg/queue"
 	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/webpush"
 )
 
 type Notifier interface {
 	SendAlerts(ctx context.Context, service config.ServiceConfig) error
 	SendRecoveryNotifications(ctx context.Context, service config.ServiceConfig) error
+	// SendEarlyWarning sends the early warning notifications of the service, details describe the reason
+	SendEarlyWarning(ctx context.Context, service config.ServiceConfig, details string) error
+	// SendCountdown sends the countdown notifications of a service which will alarm in remaining
+	SendCountdown(ctx context.Context, service config.ServiceConfig, remaining time.Duration) error
+	// SendApprovalRequest asks for the approval of an action plan, details contain the approval link
+	SendApprovalRequest(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, details string) error
+	// SendArchived tells that the service was archived, details contain the reason
+	SendArchived(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, details string) error
+	// SendMetaNotifications reports a problem of the deadman switch itself, or that it is resolved. They are sent
+	// directly, past the queue, the storage and the circuit breakers, so the broken part can't swallow them.
+	SendMetaNotifications(ctx context.Context, notifications []config.NotificationConfig, problem string, resolved bool, details string) error
+	// SendCanary sends a test notification of a canary channel directly through the notification, see CanaryServiceID
+	SendCanary(ctx context.Context, notification config.NotificationConfig, name, nonce string) error
+	// PlanAlerts and PlanRecoveryNotifications return the notifications which would be sent at the given time, without sending them
+	PlanAlerts(ctx context.Context, service config.ServiceConfig, at time.Time) []config.NotificationConfig
+	PlanRecoveryNotifications(ctx context.Context, service config.ServiceConfig, at time.Time) []config.NotificationConfig
+	// Throughput returns the number of enqueued, sent and failed notifications per type
+	Throughput() []Throughput
+	// Breakers returns the circuit breakers of the notification targets which failed since the start
+	Breakers() []BreakerState
+	// ResetBreaker closes the circuit breaker of a target, it returns false if the target has none
+	ResetBreaker(target string) bool
+	// QueueState returns the state of the queue consumer and the notifications which wait for a retry
+	QueueState(ctx context.Context) (QueueState, error)
 }
 
-func NewNotifier(ctx context.Context, store storage.Storage, queue queue.Queue) Notifier {
+// SlackApp provides the tokens and channel IDs of the workspaces the Slack app is installed in, see package slackapp
+type SlackApp interface {
+	Token(ctx context.Context, workspace string) (string, error)
+	ChannelID(ctx context.Context, workspace, channel string) (string, error)
+}
+
+// Links creates the short links to the read-only page of a service, see package links
+type Links interface {
+	Create(ctx context.Context, service string, now time.Time) (string, error)
+}
+
+// WebPush pushes notifications to the browsers which subscribed through the app, see package webpush
+type WebPush interface {
+	Push(ctx context.Context, users []string, notification webpush.Notification, ttl time.Duration) error
+}
+
+// Meter counts the notifications of the tenants, see package usage
+type Meter interface {
+	CountNotification(service string)
+}
+
+// NewNotifier creates the notifier, slackApp, links, webPush, meter and egress may be nil if they are not configured.
+// A read-only notifier only enqueues the notifications, even the direct ones, and leaves them to the consumers of the
+// other instances.
+func NewNotifier(ctx context.Context, store storage.Storage, queue queue.Queue, readOnly bool, contactChannels config.ContactChannelsConfig, breakerCfg config.CircuitBreakerConfig, slackApp SlackApp, links Links, webPush WebPush, meter Meter, egress *egress.Policy, clock clock.Clock) Notifier {
 	notifier := &defaultNotifierType{
-		store: store,
-		queue: queue,
+		store:           store,
+		queue:           queue,
+		readOnly:        readOnly,
+		contactChannels: contactChannels,
+		clock:           clock,
+		breakers:        newBreakers(breakerCfg),
+		slackApp:        slackApp,
+		links:           links,
+		webPush:         webPush,
+		meter:           meter,
+		egress:          egress,
+		// httpClient only fetches the credentials of the server, e.g. from the metadata services of the clouds
 		httpClient: &http.Client{
 			Timeout: 5 * time.Second,
 		},
+		// notifications call the URLs of the services, they may only connect where the policy allows
+		egressClient: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: egress.Transport(),
+		},
 	}
-	if notifier.queue != nil {
-		go func() {
-			err := notifier.getAndProcessNotificationsFromQueue(ctx)
-			if err != nil {
-				log.Error().Err(err).Msg("stopped reading notification tasks from queue")
-			}
-		}()
+	if notifier.queue != nil && !readOnly {
+		go notifier.superviseQueueConsumer(ctx)
 	}
 
 	return notifier
 }
 
 type defaultNotifierType struct {
-	queue      queue.Queue
-	store      storage.Storage
-	httpClient *http.Client
+	queue           queue.Queue
+	readOnly        bool
+	store           storage.Storage
+	contactChannels config.ContactChannelsConfig
+	clock           clock.Clock
+	httpClient      *http.Client
+	egressClient    *http.Client
+	egress          *egress.Policy
+	throughput      throughputCounter
+	breakers        *breakers
+	slackApp        SlackApp
+	links           Links
+	webPush         WebPush
+	meter           Meter
+	consumer        consumerTracker
+	cloud           cloudCredentials
 }
 
 func (n *defaultNotifierType) SendAlerts(ctx context.Context, service config.ServiceConfig) (err error) {
-	if service.Debounce > 0 {
+	escalated, err := n.escalate(ctx, service, n.clock.Now())
+	if err != nil {
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to escalate alarm")
+	}
+	repeats, due, err := n.alertRepeats(ctx, service, n.clock.Now())
+	if err != nil || !due {
+		return err
+	}
+	// the debounce doesn't hold back the reminders of an alarm
+	if service.Debounce > 0 && (repeats == nil || repeats.Repeats == 0) {
 		lastMessageSend, err := n.store.GetLastMessageSendTimestamp(ctx, service.ID)
 		if err == nil {
-			if time.Now().Add(-time.Duration(service.Debounce)).Before(lastMessageSend) {
+			if n.clock.Now().Add(-time.Duration(service.Debounce)).Before(lastMessageSend) {
 				log.Info().Str("service", service.ID).Msg("don't enqueue alert messages because of debouncing")
 				return nil
 			}
@@ -58,152 +144,480 @@ func (n *defaultNotifierType) SendAlerts(ctx context.Context, service config.Ser
 	}
 
 	log.Info().Str("service", service.ID).Msg("send out alert messages")
-	for _, notification := range service.AlertNotifications {
-		if n.queue != nil {
-			log.Debug().
-				Str("service", service.ID).
-				Msg("enqueuing notification call")
-			err = n.queue.Enqueue(ctx, notificationWrapper{
-				Service:      service,
-				Notification: notification,
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			// no queue, direct calling
-			switch notification.Type {
-			case config.NotificationTypeWebhook:
-				cfg, err := notification.GetWebhookConfig()
-				if err != nil {
-					return err
-				}
-				err = n.sendAlertToWebhook(ctx, service, cfg)
-			case config.NotificationTypeSlack:
-				cfg, err := notification.GetSlackConfig()
-				if err != nil {
-					return err
-				}
-				err = n.sendAlertToSlack(ctx, service, cfg)
-			default:
-				return errors.New("unimplemented notification type")
-			}
-			if err != nil {
-				return err
-			}
-		}
+	var details string
+	if repeats != nil && repeats.Repeats > 0 {
+		details = reminderDetails(service, *repeats, n.clock.Now())
 	}
+	notifications := n.alertNotifications(ctx, service, n.clock.Now())
+	notifications = append(notifications, n.tierAlertNotifications(ctx, service, escalated, n.clock.Now())...)
+	err = n.send(ctx, service, notifications, messageKindAlert, details)
+	if err != nil {
+		return err
+	}
+	n.recordSent(ctx, service, storage.ServiceEventAlertSent, notifications, details)
 
-	err = n.store.SetLastMessageSendTimestamp(ctx, service.ID, time.Now())
+	err = n.store.SetLastMessageSendTimestamp(ctx, service.ID, n.clock.Now())
 	if err != nil {
 		return err
 	}
+	if repeats != nil {
+		return n.store.SetAlertRepeats(ctx, service.ID, *repeats)
+	}
 
 	return nil
 }
 
+func (n *defaultNotifierType) alertNotifications(ctx context.Context, service config.ServiceConfig, now time.Time) []config.NotificationConfig {
+	notifications := append(append([]config.NotificationConfig{}, service.AlertNotifications...), n.contactNotifications(ctx, service, now)...)
+	return append(notifications, callbackNotifications(service)...)
+}
+
+func (n *defaultNotifierType) recoveryNotifications(ctx context.Context, service config.ServiceConfig, now time.Time) []config.NotificationConfig {
+	notifications := append(append([]config.NotificationConfig{}, service.RecoveryNotifications...), n.contactNotifications(ctx, service, now)...)
+	return append(notifications, callbackNotifications(service)...)
+}
+
 func (n *defaultNotifierType) SendRecoveryNotifications(ctx context.Context, service config.ServiceConfig) (err error) {
 	log.Info().Str("service", service.ID).Msg("send out recovery messages")
-	for _, notification := range service.RecoveryNotifications {
+	if service.RepeatInterval > 0 {
+		err = n.store.ClearAlertRepeats(ctx, service.ID)
+		if err != nil {
+			log.Error().Str("service", service.ID).Err(err).Msg("failed to clear alert repeats")
+		}
+	}
+	notifications := n.recoveryNotifications(ctx, service, n.clock.Now())
+	notifications = append(notifications, n.tierRecoveryNotifications(ctx, service, n.escalatedTiers(ctx, service), n.clock.Now())...)
+	err = n.send(ctx, service, notifications, messageKindRecovery, "")
+	if err != nil {
+		return err
+	}
+	n.recordSent(ctx, service, storage.ServiceEventRecoverySent, notifications, "")
+	err = n.store.SetLastMessageSendTimestamp(ctx, service.ID, n.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// recordSent records the sent notifications in the event log of the service, if there were any
+func (n *defaultNotifierType) recordSent(ctx context.Context, service config.ServiceConfig, typ storage.ServiceEventType, notifications []config.NotificationConfig, details string) {
+	if len(notifications) == 0 {
+		return
+	}
+	summary := fmt.Sprintf("notifications: %d", len(notifications))
+	if details != "" {
+		summary += ", " + details
+	}
+	err := n.store.AppendServiceEvent(ctx, storage.ServiceEvent{
+		Time:    n.clock.Now(),
+		Service: service.ID,
+		Type:    typ,
+		Details: summary,
+	})
+	if err != nil {
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to record service event")
+	}
+}
+
+func (n *defaultNotifierType) SendEarlyWarning(ctx context.Context, service config.ServiceConfig, details string) error {
+	log.Info().Str("service", service.ID).Msg("send out early warning messages")
+	var notifications []config.NotificationConfig
+	if service.EarlyWarning != nil {
+		notifications = append(notifications, service.EarlyWarning.Notifications...)
+	}
+	notifications = append(notifications, n.contactNotifications(ctx, service, n.clock.Now())...)
+	return n.send(ctx, service, notifications, messageKindWarning, details)
+}
+
+func (n *defaultNotifierType) SendCountdown(ctx context.Context, service config.ServiceConfig, remaining time.Duration) error {
+	log.Info().Str("service", service.ID).Dur("remaining", remaining).Msg("send out countdown messages")
+	if service.Countdown == nil {
+		return nil
+	}
+	details := fmt.Sprintf("it will alarm in %s", remaining.Round(time.Second))
+	return n.send(ctx, service, service.Countdown.Notifications, messageKindCountdown, details)
+}
+
+func (n *defaultNotifierType) SendApprovalRequest(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, details string) error {
+	log.Info().Str("service", service.ID).Msg("send out approval requests")
+	return n.send(ctx, service, notifications, messageKindApproval, details)
+}
+
+func (n *defaultNotifierType) SendArchived(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, details string) error {
+	log.Info().Str("service", service.ID).Msg("send out archived notifications")
+	return n.send(ctx, service, notifications, messageKindArchived, details)
+}
+
+// directSendTimeout limits a direct notification, a failed one is retried through the queue
+const directSendTimeout = 10 * time.Second
+
+// messageKind tells the notification channels which kind of message to send
+type messageKind string
+
+const (
+	messageKindAlert    messageKind = "alert"
+	messageKindRecovery messageKind = "recovery"
+	messageKindWarning  messageKind = "warning"
+	// messageKindCountdown warns that a service will alarm soon
+	messageKindCountdown messageKind = "countdown"
+	messageKindApproval  messageKind = "approval"
+	messageKindArchived  messageKind = "archived"
+	// messageKindMetaAlert and messageKindMetaRecovery report problems of the deadman switch itself
+	messageKindMetaAlert    messageKind = "meta-alert"
+	messageKindMetaRecovery messageKind = "meta-recovery"
+	// messageKindCanary is a test notification which verifies a channel
+	messageKindCanary messageKind = "canary"
+)
+
+// send enqueues the notifications or, without a queue or when marked as direct, sends them directly.
+// A failed send or enqueue doesn't stop the others, e.g. an open circuit breaker only skips its own target.
+func (n *defaultNotifierType) send(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, kind messageKind, details string) error {
+	var failed []string
+	for _, notification := range notifications {
+		notification, ok, err := hooks.Notification(ctx, service, string(kind), notification)
+		if err != nil {
+			log.Error().Str("service", service.ID).Err(err).Msg("failed to run notification hook")
+			failed = append(failed, string(notification.Type)+": notification hook: "+err.Error())
+			continue
+		}
+		if !ok {
+			log.Info().Str("service", service.ID).Msg("notification dropped by hook")
+			continue
+		}
+		if notification.Direct && n.queue != nil && !n.readOnly {
+			err = n.sendDirect(ctx, service, notification, kind, details)
+			if err != nil {
+				failed = append(failed, string(notification.Type)+": "+err.Error())
+			}
+			continue
+		}
 		if n.queue != nil {
 			log.Debug().
 				Str("service", service.ID).
 				Msg("enqueuing notification call")
-			err = n.queue.Enqueue(ctx, notificationWrapper{
+			err := n.queue.Enqueue(ctx, notificationWrapper{
 				Service:           service,
 				Notification:      notification,
-				IsRecoveryMessage: true,
+				IsRecoveryMessage: kind == messageKindRecovery,
+				Kind:              kind,
+				Details:           details,
 			})
 			if err != nil {
-				return err
-			}
-		} else {
-			// no queue, direct calling
-			switch notification.Type {
-			case config.NotificationTypeWebhook:
-				cfg, err := notification.GetWebhookConfig()
-				if err != nil {
-					return err
-				}
-				err = n.sendRecoveryToWebhook(ctx, service, cfg)
-			case config.NotificationTypeSlack:
-				cfg, err := notification.GetSlackConfig()
-				if err != nil {
-					return err
-				}
-				err = n.sendRecoveryToSlack(ctx, service, cfg)
-			default:
-				return errors.New("unimplemented notification type")
-			}
-			if err != nil {
-				return err
+				log.Error().Str("service", service.ID).Str("type", string(notification.Type)).Err(err).Msg("failed to enqueue notification")
+				failed = append(failed, string(notification.Type)+": "+err.Error())
+				continue
 			}
+			n.throughput.count(notification.Type, func(t *Throughput) { t.Enqueued++ })
+			continue
+		}
+		if n.readOnly {
+			return errors.New("a read-only instance can't send notifications without a queue")
+		}
+		// no queue, direct calling
+		err = n.sendNotification(ctx, service, notification, kind, details)
+		if err != nil {
+			log.Warn().Str("service", service.ID).Str("type", string(notification.Type)).Err(err).Msg("failed to send notification")
+			failed = append(failed, string(notification.Type)+": "+err.Error())
 		}
 	}
-	err = n.store.SetLastMessageSendTimestamp(ctx, service.ID, time.Now())
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send %d of %d notifications: %s", len(failed), len(notifications), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// sendDirect sends a direct notification synchronously, so it doesn't depend on a healthy queue.
+// The send is limited to directSendTimeout, so a slow endpoint doesn't hold up the checks of the other services.
+// Only a failed send is handed to the queue for the retries, if that fails too the error is returned,
+// so the caller keeps the notification pending.
+func (n *defaultNotifierType) sendDirect(ctx context.Context, service config.ServiceConfig, notification config.NotificationConfig, kind messageKind, details string) error {
+	log.Debug().
+		Str("service", service.ID).
+		Str("type", string(notification.Type)).
+		Msg("sending direct notification")
+	sendCtx, cancel := context.WithTimeout(ctx, directSendTimeout)
+	err := n.sendNotification(sendCtx, service, notification, kind, details)
+	cancel()
+	if err == nil {
+		return nil
+	}
+	sendErr := err
+	err = n.retry(ctx, notificationWrapper{
+		Service:           service,
+		Notification:      notification,
+		IsRecoveryMessage: kind == messageKindRecovery,
+		Kind:              kind,
+		Details:           details,
+	}, err)
+	if err != nil {
+		log.Error().Str("service", service.ID).Err(err).Msg("failed to enqueue the retry of a direct notification")
+		return fmt.Errorf("%v, and the retry couldn't be enqueued: %w", sendErr, err)
+	}
+	return nil
+}
+
+func (n *defaultNotifierType) Throughput() []Throughput {
+	return n.throughput.list()
+}
+
+// sendNotification sends a notification unless the circuit breaker of its target is open
+func (n *defaultNotifierType) sendNotification(ctx context.Context, service config.ServiceConfig, notification config.NotificationConfig, kind messageKind, details string) (err error) {
+	target := breakerTarget(notification)
+	err = n.breakers.allow(target, n.clock.Now())
 	if err != nil {
 		return err
 	}
+	defer n.throughput.count(notification.Type, func(t *Throughput) {
+		if err != nil {
+			t.Failed++
+		} else {
+			t.Sent++
+		}
+	})
+	if n.meter != nil {
+		defer func() {
+			if err == nil {
+				n.meter.CountNotification(service.ID)
+			}
+		}()
+	}
+	err = n.deliver(ctx, service, notification, kind, details)
+	if changed, state := n.breakers.record(target, err, n.clock.Now()); changed {
+		go n.notifyBreakerChange(context.Background(), state)
+	}
+	return err
+}
 
-	return nil
+// deliver sends a notification through its channel
+func (n *defaultNotifierType) deliver(ctx context.Context, service config.ServiceConfig, notification config.NotificationConfig, kind messageKind, details string) error {
+	err := n.egress.CheckNotification(ctx, notification)
+	if err != nil {
+		return err
+	}
+	switch notification.Type {
+	case config.NotificationTypeWebhook:
+		cfg, err := notification.GetWebhookConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToWebhook(ctx, service, cfg, kind, details)
+	case config.NotificationTypeSlack:
+		cfg, err := notification.GetSlackConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToSlack(ctx, service, cfg, kind, details)
+	case config.NotificationTypePagerDuty:
+		cfg, err := notification.GetPagerDutyConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToPagerDuty(ctx, service, cfg, kind, details)
+	case config.NotificationTypeOpsgenie:
+		cfg, err := notification.GetOpsgenieConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToOpsgenie(ctx, service, cfg, kind, details)
+	case config.NotificationTypeEmail:
+		cfg, err := notification.GetEmailConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToEmail(ctx, service, cfg, kind, details)
+	case config.NotificationTypeWebPush:
+		cfg, err := notification.GetWebPushConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToWebPush(ctx, service, cfg, kind, details)
+	case config.NotificationTypeDiscord:
+		cfg, err := notification.GetDiscordConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToDiscord(ctx, service, cfg, kind, details)
+	case config.NotificationTypeTwilio:
+		cfg, err := notification.GetTwilioConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToTwilio(ctx, service, cfg, kind, details)
+	case config.NotificationTypeMQTT:
+		cfg, err := notification.GetMQTTConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToMQTT(ctx, service, cfg, kind, details)
+	case config.NotificationTypeKafka:
+		cfg, err := notification.GetKafkaConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToKafka(ctx, service, cfg, kind, details)
+	case config.NotificationTypeNATS:
+		cfg, err := notification.GetNATSConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToNATS(ctx, service, cfg, kind, details)
+	case config.NotificationTypeZabbix:
+		cfg, err := notification.GetZabbixConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToZabbix(ctx, service, cfg, kind, details)
+	case config.NotificationTypeNSCA:
+		cfg, err := notification.GetNSCAConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToNSCA(ctx, service, cfg, kind, details)
+	case config.NotificationTypeEventBridge:
+		cfg, err := notification.GetEventBridgeConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToEventBridge(ctx, service, cfg, kind, details)
+	case config.NotificationTypePubSub:
+		cfg, err := notification.GetPubSubConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToPubSub(ctx, service, cfg, kind, details)
+	case config.NotificationTypeCallback:
+		cfg, err := notification.GetCallbackConfig()
+		if err != nil {
+			return err
+		}
+		return n.sendToCallback(ctx, service, cfg, kind)
+	default:
+		sender, ok := getSender(notification.Type)
+		if !ok {
+			return errors.New("unimplemented notification type")
+		}
+		msg := Message{
+			Service: service,
+			Kind:    string(kind),
+			Details: details,
+			Link:    n.link(ctx, service, kind),
+			Config:  notification.Config,
+		}
+		if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+			msg.LastHeartbeat = &lastHeartbeat
+		}
+		return sender.Send(ctx, msg)
+	}
 }
 
-func (n *defaultNotifierType) sendAlertToWebhook(ctx context.Context, service config.ServiceConfig, cfg config.WebhookConfig) error {
+func (n *defaultNotifierType) sendToWebhook(ctx context.Context, service config.ServiceConfig, cfg config.WebhookConfig, kind messageKind, details string) error {
+	req, err := renderWebhook(cfg, n.templateData(ctx, service, kind, details))
+	if err != nil {
+		return err
+	}
 	log.Info().
 		Str("service", service.ID).
-		Str("method", cfg.Method).
-		Str("url", cfg.URL).
+		Str("method", req.Method).
+		Str("url", req.URL).
 		Msg("calling webhook")
-	r, _ := http.NewRequest(cfg.Method, cfg.URL, strings.NewReader(cfg.Body))
-	r = r.WithContext(ctx)
-	if cfg.Headers != nil {
-		r.Header = cfg.Headers
+	err = n.egress.CheckURL(ctx, req.URL)
+	if err != nil {
+		return err
 	}
-	_, err := n.httpClient.Do(r)
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.URL, strings.NewReader(req.Body))
 	if err != nil {
 		return err
 	}
+	r.Header = req.Headers
+	resp, err := n.egressClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
 
-	return err
+// renderWebhook renders the url, the headers and the body of a webhook call
+func renderWebhook(cfg config.WebhookConfig, data templateData) (Rendered, error) {
+	endpoint, err := render("url", cfg.URL, data)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to render the url: %w", err)
+	}
+	body, err := render("body", cfg.Body, data)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to render the body: %w", err)
+	}
+	headers := make(http.Header)
+	for key, values := range cfg.Headers {
+		for _, value := range values {
+			value, err = render(key, value, data)
+			if err != nil {
+				return Rendered{}, fmt.Errorf("failed to render the header %s: %w", key, err)
+			}
+			headers.Add(key, value)
+		}
+	}
+	// warnings and alerts may share a body, the headers tell them apart
+	if headers.Get("X-Deadman-Event") == "" {
+		headers.Set("X-Deadman-Event", data.Event)
+	}
+	if headers.Get("X-Deadman-Severity") == "" {
+		headers.Set("X-Deadman-Severity", data.Severity)
+	}
+	method := cfg.Method
+	if cfg.Format == config.WebhookFormatCloudEvents {
+		body, err = cloudEventBody(cfg, data)
+		if err != nil {
+			return Rendered{}, err
+		}
+		if headers.Get("Content-Type") == "" {
+			headers.Set("Content-Type", cloudEventContentType)
+		}
+		if method == "" {
+			method = http.MethodPost
+		}
+	}
+	return Rendered{Method: method, URL: endpoint, Headers: headers, Body: body}, nil
 }
 
-func (n *defaultNotifierType) sendAlertToSlack(ctx context.Context, service config.ServiceConfig, cfg config.SlackConfig) error {
+func (n *defaultNotifierType) sendToSlack(ctx context.Context, service config.ServiceConfig, cfg config.SlackConfig, kind messageKind, details string) error {
 	log.Info().
 		Str("service", service.ID).
 		Str("channel", cfg.Channel).
 		Msg("sending slack message")
-
-	attachment := slack.Attachment{
-		Title: "ALERT",
-		Color: "danger",
-		Text:  fmt.Sprintf("The service %s has stopped sending heartbeats", service.ID),
-		Fields: []slack.AttachmentField{
-			slack.AttachmentField{
-				Title: "service",
-				Value: service.ID,
-			},
-		},
-	}
-
-	lastHearbeat, err := n.store.GetLastHeartbeat(ctx, service.ID)
-	if err == nil {
-		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
-			Title: "last heartbeat",
-			Value: fmt.Sprintf("%s", lastHearbeat.Format(time.RFC3339)),
-		})
-	} else {
-		log.Error().Str("service", service.ID).Err(err).Msg("can't load last heartbeat")
+	data := n.templateData(ctx, service, kind, details)
+	// the deadman switch itself sends no heartbeats
+	if data.LastHeartbeat == nil && kind != messageKindMetaAlert && kind != messageKindMetaRecovery && kind != messageKindCanary {
+		log.Error().Str("service", service.ID).Msg("can't load last heartbeat")
 	}
-	for _, field := range cfg.MessageFields {
-		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
-			Title: field.Key,
-			Value: field.Value,
-		})
+	attachment, err := slackAttachment(service, cfg, kind, data)
+	if err != nil {
+		return err
 	}
 
-	api := slack.New(cfg.Token)
+	token, channel := cfg.Token, cfg.Channel
+	if cfg.Workspace != "" {
+		if n.slackApp == nil {
+			return errors.New("slack workspaces need the slack app to be configured")
+		}
+		var err error
+		token, err = n.slackApp.Token(ctx, cfg.Workspace)
+		if err != nil {
+			return err
+		}
+		channel, err = n.slackApp.ChannelID(ctx, cfg.Workspace, cfg.Channel)
+		if err != nil {
+			return err
+		}
+	}
+	api := slack.New(token)
 	_, _, err = api.PostMessage(
-		cfg.Channel,
+		channel,
 		slack.MsgOptionAsUser(true),
 		slack.MsgOptionAttachments(attachment),
 	)
@@ -214,70 +628,97 @@ func (n *defaultNotifierType) sendAlertToSlack(ctx context.Context, service conf
 	return nil
 }
 
-func (n *defaultNotifierType) sendRecoveryToWebhook(ctx context.Context, service config.ServiceConfig, cfg config.WebhookConfig) error {
-	log.Info().
-		Str("service", service.ID).
-		Str("method", cfg.Method).
-		Str("url", cfg.URL).
-		Msg("calling webhook")
-	r, _ := http.NewRequest(cfg.Method, cfg.URL, strings.NewReader(cfg.Body))
-	r = r.WithContext(ctx)
-	if cfg.Headers != nil {
-		r.Header = cfg.Headers
+// slackAttachment builds the attachment of a slack message, the last heartbeat and the link are taken from the data
+func slackAttachment(service config.ServiceConfig, cfg config.SlackConfig, kind messageKind, data templateData) (slack.Attachment, error) {
+	var attachment slack.Attachment
+	switch kind {
+	case messageKindRecovery:
+		attachment = slack.Attachment{
+			Title: "RECOVERY",
+			Color: "good",
+			Text:  fmt.Sprintf("The service %s started sending heartbeats again", service.ID),
+		}
+	case messageKindWarning:
+		attachment = slack.Attachment{
+			Title: "WARNING",
+			Color: "warning",
+			Text:  fmt.Sprintf("The service %s is missing heartbeats", service.ID),
+		}
+	case messageKindCountdown:
+		attachment = slack.Attachment{
+			Title: "COUNTDOWN",
+			Color: "warning",
+			Text:  fmt.Sprintf("The service %s has not pinged", service.ID),
+		}
+	case messageKindApproval:
+		attachment = slack.Attachment{
+			Title: "APPROVAL REQUIRED",
+			Color: "warning",
+			Text:  fmt.Sprintf("An action plan for the service %s needs your approval", service.ID),
+		}
+	case messageKindArchived:
+		attachment = slack.Attachment{
+			Title: "ARCHIVED",
+			Text:  fmt.Sprintf("The service %s was archived", service.ID),
+		}
+	case messageKindMetaAlert:
+		attachment = slack.Attachment{
+			Title: "DEADMAN SWITCH PROBLEM",
+			Color: "danger",
+			Text:  "The deadman switch can't do its job",
+		}
+	case messageKindMetaRecovery:
+		attachment = slack.Attachment{
+			Title: "DEADMAN SWITCH RECOVERED",
+			Color: "good",
+			Text:  "The deadman switch works again",
+		}
+	case messageKindCanary:
+		attachment = slack.Attachment{
+			Title: "CANARY",
+			Text:  "Test message of the deadman switch, no action needed",
+		}
+	default:
+		attachment = slack.Attachment{
+			Title: "ALERT",
+			Color: "danger",
+			Text:  fmt.Sprintf("The service %s has stopped sending heartbeats", service.ID),
+		}
 	}
-	_, err := n.httpClient.Do(r)
-	if err != nil {
-		return err
+	if data.Details != "" {
+		attachment.Text += ": " + data.Details
 	}
-
-	return err
-}
-
-func (n *defaultNotifierType) sendRecoveryToSlack(ctx context.Context, service config.ServiceConfig, cfg config.SlackConfig) error {
-	log.Info().
-		Str("service", service.ID).
-		Str("channel", cfg.Channel).
-		Msg("sending slack message")
-
-	attachment := slack.Attachment{
-		Title: "RECOVERY",
-		Color: "good",
-		Text:  fmt.Sprintf("The service %s started sending heartbeats again", service.ID),
-		Fields: []slack.AttachmentField{
-			slack.AttachmentField{
-				Title: "service",
-				Value: service.ID,
-			},
+	if cfg.Text != "" {
+		text, err := render("text", cfg.Text, data)
+		if err != nil {
+			return attachment, fmt.Errorf("failed to render the text: %w", err)
+		}
+		attachment.Text = text
+	}
+	attachment.Fields = []slack.AttachmentField{
+		slack.AttachmentField{
+			Title: "service",
+			Value: service.ID,
 		},
 	}
-
-	lastHearbeat, err := n.store.GetLastHeartbeat(ctx, service.ID)
-	if err == nil {
+	if data.LastHeartbeat != nil {
 		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
 			Title: "last heartbeat",
-			Value: fmt.Sprintf("%s", lastHearbeat.Format(time.RFC3339)),
+			Value: data.LastHeartbeat.Format(time.RFC3339),
 		})
-	} else {
-		log.Error().Str("service", service.ID).Err(err).Msg("can't load last heartbeat")
 	}
+	attachment.TitleLink = data.Link
 	for _, field := range cfg.MessageFields {
+		value, err := render(field.Key, field.Value, data)
+		if err != nil {
+			return attachment, fmt.Errorf("failed to render the message field %s: %w", field.Key, err)
+		}
 		attachment.Fields = append(attachment.Fields, slack.AttachmentField{
 			Title: field.Key,
-			Value: field.Value,
+			Value: value,
 		})
 	}
-
-	api := slack.New(cfg.Token)
-	_, _, err = api.PostMessage(
-		cfg.Channel,
-		slack.MsgOptionAsUser(true),
-		slack.MsgOptionAttachments(attachment),
-	)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return attachment, nil
 }
 
 func (n *defaultNotifierType) getAndProcessNotificationsFromQueue(ctx context.Context) error {
@@ -288,35 +729,40 @@ func (n *defaultNotifierType) getAndProcessNotificationsFromQueue(ctx context.Co
 		default:
 			var task notificationWrapper
 			err := n.queue.Dequeue(ctx, &task)
+			var decodeErr *queue.DecodeError
+			if errors.As(err, &decodeErr) {
+				err = n.deadLetter(ctx, storage.DeadLetter{Error: decodeErr.Error()}, decodeErr.Data)
+			}
 			if err != nil {
 				return err
 			}
-			switch task.Notification.Type {
-			case config.NotificationTypeWebhook:
-				cfg, err := task.Notification.GetWebhookConfig()
-				if err != nil {
-					return err
-				}
+			n.consumer.dequeued()
+			if decodeErr != nil {
+				continue
+			}
+			kind := task.Kind
+			if kind == "" {
+				// enqueued by an older version
+				kind = messageKindAlert
 				if task.IsRecoveryMessage {
-					err = n.sendRecoveryToWebhook(ctx, task.Service, cfg)
-				} else {
-					err = n.sendAlertToWebhook(ctx, task.Service, cfg)
+					kind = messageKindRecovery
 				}
-			case config.NotificationTypeSlack:
-				cfg, err := task.Notification.GetSlackConfig()
+			}
+			err = n.sendNotification(ctx, task.Service, task.Notification, kind, task.Details)
+			var open *circuitOpenError
+			if errors.As(err, &open) {
+				// not an attempt, so it doesn't count towards the dead letters
+				err = n.queue.EnqueueAt(ctx, task, open.retryAt)
 				if err != nil {
 					return err
 				}
-				if task.IsRecoveryMessage {
-					err = n.sendRecoveryToSlack(ctx, task.Service, cfg)
-				} else {
-					err = n.sendAlertToSlack(ctx, task.Service, cfg)
-				}
-			default:
-				return errors.New("unimplemented notification type")
+				continue
 			}
 			if err != nil {
-				return err
+				err = n.retry(ctx, task, err)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -326,4 +772,9 @@ type notificationWrapper struct {
 	Service           config.ServiceConfig      `json:"service"`
 	Notification      config.NotificationConfig `json:"notification"`
 	IsRecoveryMessage bool                      `json:"isRecoveryMessage"`
+	Kind              messageKind               `json:"kind"`
+	Details           string                    `json:"details"`
+	// Attempts is the number of failed attempts to send the notification, Errors their errors
+	Attempts int      `json:"attempts,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
 }
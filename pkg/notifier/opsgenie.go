@@ -0,0 +1,129 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultOpsgenieURL = "https://api.opsgenie.com"
+	// limits of the Alert API
+	maxOpsgenieMessageLength = 130
+	maxOpsgenieAliasLength   = 512
+)
+
+// opsgenieAlert is the request of the Alert API which creates an alert
+type opsgenieAlert struct {
+	Message     string                     `json:"message"`
+	Alias       string                     `json:"alias"`
+	Description string                     `json:"description,omitempty"`
+	Responders  []config.OpsgenieResponder `json:"responders,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Details     map[string]string          `json:"details,omitempty"`
+	Entity      string                     `json:"entity,omitempty"`
+	Source      string                     `json:"source"`
+	Priority    string                     `json:"priority,omitempty"`
+}
+
+// opsgenieClose is the request of the Alert API which closes an alert
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// sendToOpsgenie creates an alert when the service alarms and closes it on recovery. Both use an alias derived
+// from the service ID, so Opsgenie deduplicates repeated alerts and the recovery closes the alert of the alarm.
+// Messages which need no action, like warnings, create P5 alerts with an alias of their own.
+func (n *defaultNotifierType) sendToOpsgenie(ctx context.Context, service config.ServiceConfig, cfg config.OpsgenieConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Msg("sending opsgenie alert")
+	base := strings.TrimSuffix(cfg.URL, "/")
+	if base == "" {
+		base = defaultOpsgenieURL
+	}
+	alias := opsgenieAlias(service.ID)
+	switch kind {
+	case messageKindRecovery, messageKindMetaRecovery:
+		endpoint := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", base, url.PathEscape(alias))
+		return n.postOpsgenie(ctx, cfg.APIKey, endpoint, opsgenieClose{
+			Source: "deadman-switch",
+			Note:   messageSummary(service, kind, details),
+		})
+	}
+
+	alert := opsgenieAlert{
+		Message:     truncate(messageSummary(service, kind, ""), maxOpsgenieMessageLength),
+		Alias:       alias,
+		Description: details,
+		Responders:  cfg.Responders,
+		Tags:        cfg.Tags,
+		Details: map[string]string{
+			"service": service.ID,
+		},
+		Entity:   service.ID,
+		Source:   "deadman-switch",
+		Priority: cfg.Priority,
+	}
+	for key, value := range service.Labels {
+		alert.Details["label:"+key] = value
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		alert.Details["lastHeartbeat"] = lastHeartbeat.UTC().Format(time.RFC3339)
+	}
+	if link := n.link(ctx, service, kind); link != "" {
+		alert.Details["link"] = link
+	}
+	if kind != messageKindAlert && kind != messageKindMetaAlert {
+		alert.Alias = opsgenieAlias(service.ID + "/" + string(kind))
+		alert.Priority = "P5"
+	}
+	return n.postOpsgenie(ctx, cfg.APIKey, base+"/v2/alerts", alert)
+}
+
+// opsgenieAlias derives the alias from the service ID, IDs which are too long are hashed
+func opsgenieAlias(serviceID string) string {
+	alias := "deadman-switch/" + serviceID
+	if len(alias) <= maxOpsgenieAliasLength {
+		return alias
+	}
+	sum := sha256.Sum256([]byte(serviceID))
+	return "deadman-switch/" + hex.EncodeToString(sum[:])
+}
+
+func (n *defaultNotifierType) postOpsgenie(ctx context.Context, apiKey, endpoint string, body interface{}) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "GenieKey "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.egressClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("opsgenie answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com"
+	// maxDedupKeyLength is the limit of the Events API for dedup keys
+	maxDedupKeyLength = 255
+)
+
+// pagerDutyEvent is an alert event of the Events API v2
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity,omitempty"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
+	Component     string                 `json:"component,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDutyChange is a change event, it shows up in the timeline of the PagerDuty service without paging anyone
+type pagerDutyChange struct {
+	RoutingKey string           `json:"routing_key"`
+	Payload    pagerDutyPayload `json:"payload"`
+	Links      []pagerDutyLink  `json:"links,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// sendToPagerDuty triggers an incident for alerts and resolves it on recovery. Both use a dedup key derived
+// from the service ID, so the recovery resolves the incident of the alert and repeated alerts don't open new ones.
+// Messages which need no action, like warnings, are sent as change events.
+func (n *defaultNotifierType) sendToPagerDuty(ctx context.Context, service config.ServiceConfig, cfg config.PagerDutyConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Msg("sending pagerduty event")
+	base := strings.TrimSuffix(cfg.URL, "/")
+	if base == "" {
+		base = defaultPagerDutyURL
+	}
+	payload := pagerDutyPayload{
+		Summary:   pagerDutySummary(service, kind, details),
+		Source:    "deadman-switch",
+		Timestamp: n.clock.Now().UTC().Format(time.RFC3339),
+		Component: service.ID,
+		CustomDetails: map[string]interface{}{
+			"service": service.ID,
+		},
+	}
+	if len(service.Labels) > 0 {
+		payload.CustomDetails["labels"] = service.Labels
+	}
+	if details != "" {
+		payload.CustomDetails["details"] = details
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		payload.CustomDetails["lastHeartbeat"] = lastHeartbeat.UTC().Format(time.RFC3339)
+	}
+
+	switch kind {
+	case messageKindAlert, messageKindMetaAlert:
+		payload.Severity = cfg.Severity
+		if payload.Severity == "" {
+			payload.Severity = "critical"
+		}
+		return n.postPagerDuty(ctx, base+"/v2/enqueue", pagerDutyEvent{
+			RoutingKey:  cfg.RoutingKey,
+			EventAction: "trigger",
+			DedupKey:    pagerDutyDedupKey(service.ID),
+			Payload:     &payload,
+			Links:       n.pagerDutyLinks(ctx, service, kind),
+		})
+	case messageKindRecovery, messageKindMetaRecovery:
+		return n.postPagerDuty(ctx, base+"/v2/enqueue", pagerDutyEvent{
+			RoutingKey:  cfg.RoutingKey,
+			EventAction: "resolve",
+			DedupKey:    pagerDutyDedupKey(service.ID),
+		})
+	default:
+		return n.postPagerDuty(ctx, base+"/v2/change/enqueue", pagerDutyChange{
+			RoutingKey: cfg.RoutingKey,
+			Payload:    payload,
+			Links:      n.pagerDutyLinks(ctx, service, kind),
+		})
+	}
+}
+
+func pagerDutySummary(service config.ServiceConfig, kind messageKind, details string) string {
+	summary := messageSummary(service, kind, details)
+	// the Events API truncates the summary at 1024 characters
+	if len(summary) > 1024 {
+		summary = summary[:1024]
+	}
+	return summary
+}
+
+func (n *defaultNotifierType) pagerDutyLinks(ctx context.Context, service config.ServiceConfig, kind messageKind) []pagerDutyLink {
+	link := n.link(ctx, service, kind)
+	if link == "" {
+		return nil
+	}
+	return []pagerDutyLink{{Href: link, Text: "Service status"}}
+}
+
+// pagerDutyDedupKey derives the dedup key from the service ID, IDs which are too long are hashed
+func pagerDutyDedupKey(serviceID string) string {
+	key := "deadman-switch/" + serviceID
+	if len(key) <= maxDedupKeyLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(serviceID))
+	return "deadman-switch/" + hex.EncodeToString(sum[:])
+}
+
+func (n *defaultNotifierType) postPagerDuty(ctx context.Context, url string, event interface{}) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.egressClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pagerduty answered %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
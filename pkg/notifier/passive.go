@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/nsca"
+	"github.com/trusch/deadman-switch/pkg/zabbix"
+)
+
+const (
+	defaultZabbixKey = "deadman-switch.state"
+	// maxPassiveOutput is the longest plugin output NSCA accepts, without the terminating zero byte
+	maxPassiveOutput = 511
+)
+
+// passiveCheckCode maps the kind of a message to the return code of a Nagios check, which Zabbix gets as well.
+// Approvals say nothing about the state of the service and aren't sent.
+func passiveCheckCode(kind messageKind) (int, bool) {
+	switch kind {
+	case messageKindAlert, messageKindMetaAlert:
+		return nsca.Critical, true
+	case messageKindWarning, messageKindCountdown:
+		return nsca.Warning, true
+	case messageKindRecovery, messageKindMetaRecovery, messageKindCanary:
+		return nsca.OK, true
+	case messageKindArchived:
+		return nsca.Unknown, true
+	default:
+		return 0, false
+	}
+}
+
+// sendToZabbix sends the code of the state to the trapper item, and the summary to the message item if there is one
+func (n *defaultNotifierType) sendToZabbix(ctx context.Context, service config.ServiceConfig, cfg config.ZabbixConfig, kind messageKind, details string) error {
+	code, ok := passiveCheckCode(kind)
+	if !ok {
+		log.Debug().Str("service", service.ID).Str("kind", string(kind)).Msg("skip message without state for zabbix")
+		return nil
+	}
+	replacer := strings.NewReplacer("{service}", service.ID)
+	host, key := replacer.Replace(cfg.Host), replacer.Replace(cfg.Key)
+	if key == "" {
+		key = defaultZabbixKey
+	}
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("host", host).
+		Str("key", key).
+		Msg("sending zabbix value")
+	clock := n.clock.Now().Unix()
+	values := []zabbix.Value{{Host: host, Key: key, Value: strconv.Itoa(code), Clock: clock}}
+	if cfg.MessageKey != "" {
+		values = append(values, zabbix.Value{
+			Host:  host,
+			Key:   replacer.Replace(cfg.MessageKey),
+			Value: messageSummary(service, kind, details),
+			Clock: clock,
+		})
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.httpClient.Timeout)
+	defer cancel()
+	return zabbix.Send(ctx, cfg.Server, values, n.egress.Control)
+}
+
+// sendToNSCA submits the state as passive check result with the summary as output
+func (n *defaultNotifierType) sendToNSCA(ctx context.Context, service config.ServiceConfig, cfg config.NSCAConfig, kind messageKind, details string) error {
+	code, ok := passiveCheckCode(kind)
+	if !ok {
+		log.Debug().Str("service", service.ID).Str("kind", string(kind)).Msg("skip message without state for nsca")
+		return nil
+	}
+	replacer := strings.NewReplacer("{service}", service.ID)
+	result := nsca.Result{
+		Host:    replacer.Replace(cfg.Host),
+		Service: replacer.Replace(cfg.Service),
+		Code:    code,
+		Output:  truncate(messageSummary(service, kind, details), maxPassiveOutput),
+	}
+	if result.Service == "" {
+		result.Service = service.ID
+	}
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Str("host", result.Host).
+		Str("nagiosService", result.Service).
+		Msg("submitting nsca check result")
+	ctx, cancel := context.WithTimeout(ctx, n.httpClient.Timeout)
+	defer cancel()
+	return nsca.Send(ctx, nsca.Options{Server: cfg.Server, Encryption: cfg.Encryption, Password: cfg.Password, Control: n.egress.Control}, result)
+}
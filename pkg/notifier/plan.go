@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/hooks"
+)
+
+func (n *defaultNotifierType) PlanAlerts(ctx context.Context, service config.ServiceConfig, at time.Time) []config.NotificationConfig {
+	return n.plan(ctx, service, n.alertNotifications(ctx, service, at), messageKindAlert)
+}
+
+func (n *defaultNotifierType) PlanRecoveryNotifications(ctx context.Context, service config.ServiceConfig, at time.Time) []config.NotificationConfig {
+	return n.plan(ctx, service, n.recoveryNotifications(ctx, service, at), messageKindRecovery)
+}
+
+// plan runs the notification hooks like send does, so dropped and rewritten notifications show up as they would be sent
+func (n *defaultNotifierType) plan(ctx context.Context, service config.ServiceConfig, notifications []config.NotificationConfig, kind messageKind) []config.NotificationConfig {
+	planned := []config.NotificationConfig{}
+	for _, notification := range notifications {
+		notification, ok, err := hooks.Notification(ctx, service, string(kind), notification)
+		if err != nil {
+			log.Error().Str("service", service.ID).Err(err).Msg("failed to run notification hook")
+		}
+		if ok {
+			planned = append(planned, notification)
+		}
+	}
+	return planned
+}
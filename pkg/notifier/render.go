@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// ErrRenderUnsupported is returned by Render for the notification types without a preview
+var ErrRenderUnsupported = errors.New("rendering is supported for webhook, slack, email and twilio notifications")
+
+// RenderState is the synthetic state of the service a notification is rendered with
+type RenderState struct {
+	// Kind of the message like alert or recovery, defaults to alert
+	Kind             string     `json:"kind"`
+	Details          string     `json:"details"`
+	LastHeartbeat    *time.Time `json:"lastHeartbeat"`
+	AlarmActiveSince *time.Time `json:"alarmActiveSince"`
+	Link             string     `json:"link"`
+	// Time of the message, defaults to now
+	Time time.Time `json:"time"`
+}
+
+// Rendered is a notification as it would be sent, the fields depend on the type
+type Rendered struct {
+	Type string `json:"type"`
+	// Method, URL and Headers of webhook calls
+	Method  string      `json:"method,omitempty"`
+	URL     string      `json:"url,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	// Subject of emails
+	Subject string `json:"subject,omitempty"`
+	// Body of webhook calls, emails and text messages
+	Body string `json:"body,omitempty"`
+	// Attachments of slack messages
+	Attachments []slack.Attachment `json:"attachments,omitempty"`
+}
+
+// Render renders the notification for the service and the state without sending it, so templates can be tried out
+func Render(service config.ServiceConfig, notification config.NotificationConfig, state RenderState) (Rendered, error) {
+	kind := messageKind(state.Kind)
+	switch kind {
+	case "":
+		kind = messageKindAlert
+	case messageKindAlert, messageKindRecovery, messageKindWarning, messageKindCountdown, messageKindApproval, messageKindArchived, messageKindMetaAlert, messageKindMetaRecovery, messageKindCanary:
+	default:
+		return Rendered{}, fmt.Errorf("unknown message kind %q", state.Kind)
+	}
+	now := state.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	data := templateData{
+		Service:          service,
+		Event:            string(kind),
+		Kind:             string(kind),
+		Summary:          messageSummary(service, kind, ""),
+		Details:          state.Details,
+		Labels:           service.Labels,
+		LastHeartbeat:    state.LastHeartbeat,
+		AlarmActiveSince: state.AlarmActiveSince,
+		Link:             state.Link,
+		Time:             now.UTC(),
+	}
+	var (
+		rendered Rendered
+		err      error
+	)
+	switch notification.Type {
+	case config.NotificationTypeWebhook:
+		var cfg config.WebhookConfig
+		cfg, err = notification.GetWebhookConfig()
+		if err == nil {
+			rendered, err = renderWebhook(cfg, data)
+		}
+	case config.NotificationTypeSlack:
+		var cfg config.SlackConfig
+		cfg, err = notification.GetSlackConfig()
+		if err == nil {
+			var attachment slack.Attachment
+			attachment, err = slackAttachment(service, cfg, kind, data)
+			rendered.Attachments = []slack.Attachment{attachment}
+		}
+	case config.NotificationTypeEmail:
+		var cfg config.EmailConfig
+		cfg, err = notification.GetEmailConfig()
+		if err == nil {
+			rendered.Subject, rendered.Body, err = renderEmail(cfg, data)
+		}
+	case config.NotificationTypeTwilio:
+		var cfg config.TwilioConfig
+		cfg, err = notification.GetTwilioConfig()
+		if err == nil {
+			rendered.Body, err = twilioBody(service, cfg, kind, data)
+		}
+	default:
+		return Rendered{}, ErrRenderUnsupported
+	}
+	if err != nil {
+		return Rendered{}, err
+	}
+	rendered.Type = string(notification.Type)
+	return rendered, nil
+}
@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// alertRepeats returns whether the alert of a service with a repeat interval is due and the repeats to record once
+// it was sent. The first alert of an alarm is due at once, the reminders every repeat interval up to the maximum.
+// It returns no repeats for services without repeat interval or without active alarm.
+func (n *defaultNotifierType) alertRepeats(ctx context.Context, service config.ServiceConfig, now time.Time) (*storage.AlertRepeats, bool, error) {
+	if service.RepeatInterval <= 0 {
+		return nil, true, nil
+	}
+	activeSince, err := n.store.GetAlarmActiveSince(ctx, service.ID)
+	if err == storage.ErrNotFound {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	state, err := n.store.GetAlertRepeats(ctx, service.ID)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, false, err
+	}
+	if err == storage.ErrNotFound || !state.AlarmActiveSince.Equal(activeSince) {
+		return &storage.AlertRepeats{AlarmActiveSince: activeSince, LastAlertAt: now}, true, nil
+	}
+	if service.MaxRepeats > 0 && state.Repeats >= service.MaxRepeats {
+		log.Info().Str("service", service.ID).Int("repeats", state.Repeats).Msg("don't repeat alert messages, the maximum is reached")
+		return nil, false, nil
+	}
+	if now.Sub(state.LastAlertAt) < time.Duration(service.RepeatInterval) {
+		log.Info().Str("service", service.ID).Msg("don't repeat alert messages before the repeat interval")
+		return nil, false, nil
+	}
+	state.LastAlertAt = now
+	state.Repeats++
+	return &state, true, nil
+}
+
+// reminderDetails describes a repeated alert
+func reminderDetails(service config.ServiceConfig, repeats storage.AlertRepeats, now time.Time) string {
+	active := now.Sub(repeats.AlarmActiveSince).Round(time.Second)
+	if service.MaxRepeats > 0 {
+		return fmt.Sprintf("reminder %d of %d, alarm active for %s", repeats.Repeats, service.MaxRepeats, active)
+	}
+	return fmt.Sprintf("reminder %d, alarm active for %s", repeats.Repeats, active)
+}
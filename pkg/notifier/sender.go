@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// Message is a single notification about a service which is handed to a Sender
+type Message struct {
+	Service config.ServiceConfig `json:"service"`
+	// Kind is "alert", "recovery" or "warning"
+	Kind    string `json:"kind"`
+	Details string `json:"details,omitempty"`
+	// LastHeartbeat is the time of the last heartbeat of the service, if it sent one
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	// Link opens the read-only page of the service, it is only set if links are configured
+	Link string `json:"link,omitempty"`
+	// Config is the config of the notification
+	Config interface{} `json:"config"`
+}
+
+// Sender delivers the notifications of a custom notification type
+type Sender interface {
+	// Validate checks the config of a notification
+	Validate(cfg interface{}) error
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	sendersMutex sync.RWMutex
+	senders      = make(map[config.NotificationType]Sender)
+)
+
+// RegisterSender makes a sender available for the given notification type.
+// The built-in types can't be replaced.
+func RegisterSender(notificationType config.NotificationType, sender Sender) error {
+	switch notificationType {
+	case config.NotificationTypeWebhook, config.NotificationTypeSlack, config.NotificationTypePagerDuty, config.NotificationTypeOpsgenie, config.NotificationTypeEmail, config.NotificationTypeWebPush, config.NotificationTypeDiscord, config.NotificationTypeTwilio, config.NotificationTypeMQTT, config.NotificationTypeKafka, config.NotificationTypeNATS, config.NotificationTypeZabbix, config.NotificationTypeNSCA, config.NotificationTypeEventBridge, config.NotificationTypePubSub, config.NotificationTypeCallback:
+		return fmt.Errorf("notification type %s is built-in", notificationType)
+	}
+	sendersMutex.Lock()
+	defer sendersMutex.Unlock()
+	if _, ok := senders[notificationType]; ok {
+		return fmt.Errorf("notification type %s is already registered", notificationType)
+	}
+	senders[notificationType] = sender
+	return nil
+}
+
+func getSender(notificationType config.NotificationType) (Sender, bool) {
+	sendersMutex.RLock()
+	defer sendersMutex.RUnlock()
+	sender, ok := senders[notificationType]
+	return sender, ok
+}
+
+// ValidateNotification checks that the notification type is known and its config is valid
+func ValidateNotification(notification config.NotificationConfig) error {
+	_, errs := NormalizeNotification(notification)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// NormalizeNotification checks the notification field by field and returns it with the config in its
+// canonical form. The configs of registered senders like exec are checked by the sender and returned unchanged.
+func NormalizeNotification(notification config.NotificationConfig) (config.NotificationConfig, config.FieldErrors) {
+	switch notification.Type {
+	case config.NotificationTypeWebhook, config.NotificationTypeSlack, config.NotificationTypePagerDuty, config.NotificationTypeOpsgenie, config.NotificationTypeEmail, config.NotificationTypeWebPush, config.NotificationTypeDiscord, config.NotificationTypeTwilio, config.NotificationTypeMQTT, config.NotificationTypeKafka, config.NotificationTypeNATS, config.NotificationTypeZabbix, config.NotificationTypeNSCA, config.NotificationTypeEventBridge, config.NotificationTypePubSub, config.NotificationTypeCallback, "":
+		normalized, errs := notification.Normalize()
+		if len(errs) == 0 && notification.Type == config.NotificationTypePubSub {
+			cfg, _ := normalized.GetPubSubConfig()
+			if _, err := gcpCredentialsFile(cfg.Credentials); err != nil {
+				errs = config.FieldErrors{{Field: "config.credentials", Error: "must name gcp credentials of the server config"}}
+			}
+		}
+		return normalized, errs
+	}
+	sender, ok := getSender(notification.Type)
+	if !ok {
+		return notification, config.FieldErrors{{Field: "type", Error: fmt.Sprintf("unknown notification type %q", notification.Type)}}
+	}
+	err := sender.Validate(notification.Config)
+	if err != nil {
+		return notification, config.FieldErrors{{Field: "config", Error: err.Error()}}
+	}
+	return notification, nil
+}
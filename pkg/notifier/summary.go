@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// messageSummary describes a message in one line for the notification types without a layout of their own
+func messageSummary(service config.ServiceConfig, kind messageKind, details string) string {
+	var summary string
+	switch kind {
+	case messageKindRecovery:
+		summary = fmt.Sprintf("The service %s started sending heartbeats again", service.ID)
+	case messageKindWarning:
+		summary = fmt.Sprintf("The service %s is missing heartbeats", service.ID)
+	case messageKindCountdown:
+		summary = fmt.Sprintf("The service %s has not pinged", service.ID)
+	case messageKindApproval:
+		summary = fmt.Sprintf("An action plan for the service %s needs your approval", service.ID)
+	case messageKindArchived:
+		summary = fmt.Sprintf("The service %s was archived", service.ID)
+	case messageKindMetaAlert:
+		summary = "The deadman switch can't do its job"
+	case messageKindMetaRecovery:
+		summary = "The deadman switch works again"
+	case messageKindCanary:
+		summary = "Test message of the deadman switch, no action needed"
+	default:
+		summary = fmt.Sprintf("The service %s has stopped sending heartbeats", service.ID)
+	}
+	if details != "" {
+		summary += ": " + details
+	}
+	return summary
+}
+
+// messageSeverity tells receivers how urgent a message is, so a warning isn't mistaken for an alert
+func messageSeverity(kind messageKind) string {
+	switch kind {
+	case messageKindAlert, messageKindMetaAlert:
+		return "critical"
+	case messageKindWarning, messageKindCountdown:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// truncate cuts the text at the limit in bytes without splitting a character
+func truncate(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
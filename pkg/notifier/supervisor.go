@@ -0,0 +1,202 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	minConsumerBackoff = time.Second
+	maxConsumerBackoff = time.Minute
+
+	// maxSendAttempts is the number of attempts to send a queued notification before it goes to the dead letters
+	maxSendAttempts = 5
+	firstRetryDelay = 10 * time.Second
+)
+
+// superviseQueueConsumer restarts the queue consumer with an exponential backoff until the context is done,
+// so a broken backend doesn't stop the notifications for good
+func (n *defaultNotifierType) superviseQueueConsumer(ctx context.Context) {
+	backoff := minConsumerBackoff
+	for {
+		started := time.Now()
+		n.consumer.started(started)
+		err := n.getAndProcessNotificationsFromQueue(ctx)
+		if ctx.Err() != nil {
+			n.consumer.stopped(nil)
+			return
+		}
+		n.consumer.stopped(err)
+		// a consumer which ran for a while was healthy, so start over with the short backoff
+		if time.Since(started) > maxConsumerBackoff {
+			backoff = minConsumerBackoff
+		}
+		log.Error().Err(err).Dur("backoff", backoff).Msg("notification queue consumer stopped, restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConsumerBackoff {
+			backoff = maxConsumerBackoff
+		}
+	}
+}
+
+// retry enqueues a notification which failed to send again with an exponential delay,
+// after maxSendAttempts it goes to the dead letters
+func (n *defaultNotifierType) retry(ctx context.Context, task notificationWrapper, sendErr error) error {
+	task.Attempts++
+	task.Errors = append(task.Errors, sendErr.Error())
+	if task.Attempts >= maxSendAttempts {
+		data, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		return n.deadLetter(ctx, storage.DeadLetter{
+			Service:          task.Service.ID,
+			NotificationType: string(task.Notification.Type),
+			Error:            sendErr.Error(),
+			Errors:           task.Errors,
+			Attempts:         task.Attempts,
+		}, data)
+	}
+	delay := firstRetryDelay << (task.Attempts - 1)
+	log.Warn().
+		Str("service", task.Service.ID).
+		Str("type", string(task.Notification.Type)).
+		Int("attempts", task.Attempts).
+		Dur("delay", delay).
+		Err(sendErr).
+		Msg("failed to send notification, retrying")
+	return n.queue.EnqueueAt(ctx, task, time.Now().Add(delay))
+}
+
+// deadLetter quarantines a queue item which is given up on, the letter carries the failure metadata
+func (n *defaultNotifierType) deadLetter(ctx context.Context, letter storage.DeadLetter, item []byte) error {
+	if !json.Valid(item) {
+		item, _ = json.Marshal(string(item))
+	}
+	letter.ID = uuid.New().String()
+	letter.Item = item
+	letter.FailedAt = time.Now()
+	log.Error().
+		Str("id", letter.ID).
+		Str("service", letter.Service).
+		Str("error", letter.Error).
+		Int("attempts", letter.Attempts).
+		Msg("moving notification to the dead letters")
+	return n.store.SaveDeadLetter(ctx, letter)
+}
+
+// ConsumerState is the state of the queue consumer of this instance
+type ConsumerState struct {
+	Running bool `json:"running"`
+	// StartedAt is the time the consumer was started last, Restarts counts the restarts after it stopped
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	Restarts  int        `json:"restarts"`
+	LastError string     `json:"lastError,omitempty"`
+	// StoppedAt is the time the consumer stopped last
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+	// LastDequeue is the time the consumer took the last notification from the queue, Dequeued counts them
+	LastDequeue *time.Time `json:"lastDequeue,omitempty"`
+	Dequeued    uint64     `json:"dequeued"`
+}
+
+// PendingRetry is a queued notification which failed before or waits for an open circuit breaker
+type PendingRetry struct {
+	ID       string    `json:"id"`
+	Service  string    `json:"service"`
+	Type     string    `json:"type"`
+	Kind     string    `json:"kind"`
+	Attempts int       `json:"attempts"`
+	Errors   []string  `json:"errors,omitempty"`
+	DueAt    time.Time `json:"dueAt"`
+}
+
+// QueueState is the state of the queue consumer and of the queued notifications
+type QueueState struct {
+	Consumer ConsumerState `json:"consumer"`
+	// Due counts the notifications which wait for the consumer
+	Due            int            `json:"due"`
+	PendingRetries []PendingRetry `json:"pendingRetries"`
+}
+
+// QueueState returns the state of the queue consumer and the notifications which wait for a retry
+func (n *defaultNotifierType) QueueState(ctx context.Context) (QueueState, error) {
+	if n.queue == nil {
+		return QueueState{}, errors.New("notifications are sent without a queue")
+	}
+	state := QueueState{Consumer: n.consumer.state(), PendingRetries: []PendingRetry{}}
+	items, err := n.queue.List(ctx)
+	if err != nil {
+		return state, err
+	}
+	now := time.Now()
+	for _, item := range items {
+		if !item.DueAt.After(now) {
+			state.Due++
+			continue
+		}
+		retry := PendingRetry{ID: item.ID, DueAt: item.DueAt}
+		var task notificationWrapper
+		if json.Unmarshal(item.Data, &task) == nil {
+			retry.Service = task.Service.ID
+			retry.Type = string(task.Notification.Type)
+			retry.Kind = string(task.Kind)
+			retry.Attempts = task.Attempts
+			retry.Errors = task.Errors
+		}
+		state.PendingRetries = append(state.PendingRetries, retry)
+	}
+	return state, nil
+}
+
+// consumerTracker records the state of the queue consumer for QueueState
+type consumerTracker struct {
+	mutex sync.Mutex
+	ConsumerState
+}
+
+func (t *consumerTracker) started(at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.StartedAt != nil {
+		t.Restarts++
+	}
+	t.Running = true
+	t.StartedAt = &at
+}
+
+func (t *consumerTracker) stopped(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.Running = false
+	t.StoppedAt = &now
+	if err != nil {
+		t.LastError = err.Error()
+	}
+}
+
+func (t *consumerTracker) dequeued() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.LastDequeue = &now
+	t.Dequeued++
+}
+
+func (t *consumerTracker) state() ConsumerState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.ConsumerState
+}
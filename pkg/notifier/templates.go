@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// templateData is passed to the templates of the notification payloads
+type templateData struct {
+	Service config.ServiceConfig
+	// Event is the kind of the message like alert or recovery, Kind is the same for older templates
+	Event string
+	Kind  string
+	// Severity is critical for alerts, warning for early warnings and countdowns and info otherwise
+	Severity string
+	Summary  string
+	Details  string
+	// Labels are the labels of the service
+	Labels           map[string]string
+	LastHeartbeat    *time.Time
+	AlarmActiveSince *time.Time
+	Link             string
+	Time             time.Time
+}
+
+// templateData collects what the templates may use, the heartbeat and the alarm are left out for the
+// messages of the deadman switch itself
+func (n *defaultNotifierType) templateData(ctx context.Context, service config.ServiceConfig, kind messageKind, details string) templateData {
+	data := templateData{
+		Service:  service,
+		Event:    string(kind),
+		Kind:     string(kind),
+		Severity: messageSeverity(kind),
+		Summary:  messageSummary(service, kind, ""),
+		Details:  details,
+		Labels:   service.Labels,
+		Link:     n.link(ctx, service, kind),
+		Time:     n.clock.Now().UTC(),
+	}
+	if kind == messageKindMetaAlert || kind == messageKindMetaRecovery || kind == messageKindCanary {
+		return data
+	}
+	if lastHeartbeat, err := n.store.GetLastHeartbeat(ctx, service.ID); err == nil {
+		data.LastHeartbeat = &lastHeartbeat
+	}
+	if since, err := n.store.GetAlarmActiveSince(ctx, service.ID); err == nil && !since.IsZero() {
+		data.AlarmActiveSince = &since
+	}
+	return data
+}
+
+// render executes a payload template, texts without actions are returned as they are
+func render(name, text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := config.ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
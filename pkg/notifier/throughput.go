@@ -0,0 +1,48 @@
+package notifier
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// Throughput counts the notifications of one type since the process started
+type Throughput struct {
+	Type     config.NotificationType `json:"type"`
+	Enqueued uint64                  `json:"enqueued"`
+	Sent     uint64                  `json:"sent"`
+	Failed   uint64                  `json:"failed"`
+}
+
+type throughputCounter struct {
+	mutex  sync.Mutex
+	byType map[config.NotificationType]*Throughput
+}
+
+func (c *throughputCounter) count(typ config.NotificationType, fn func(t *Throughput)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.byType == nil {
+		c.byType = make(map[config.NotificationType]*Throughput)
+	}
+	t, ok := c.byType[typ]
+	if !ok {
+		t = &Throughput{Type: typ}
+		c.byType[typ] = t
+	}
+	fn(t)
+}
+
+func (c *throughputCounter) list() []Throughput {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := make([]Throughput, 0, len(c.byType))
+	for _, t := range c.byType {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+	return result
+}
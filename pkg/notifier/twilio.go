@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	defaultTwilioURL = "https://api.twilio.com"
+	// maxTwilioBodyLength is the limit of the Messages API, longer texts are split into segments anyway
+	maxTwilioBodyLength = 1600
+)
+
+// sendToTwilio sends the summary of the message and the link to the service as a text message to every number.
+// All numbers are tried, a failed one fails the notification, so its retry reaches the others again.
+func (n *defaultNotifierType) sendToTwilio(ctx context.Context, service config.ServiceConfig, cfg config.TwilioConfig, kind messageKind, details string) error {
+	log.Info().
+		Str("service", service.ID).
+		Str("kind", string(kind)).
+		Int("recipients", len(cfg.To)).
+		Msg("sending twilio text message")
+	body, err := twilioBody(service, cfg, kind, n.templateData(ctx, service, kind, details))
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(cfg.URL, "/")
+	if base == "" {
+		base = defaultTwilioURL
+	}
+	endpoint := base + "/2010-04-01/Accounts/" + url.PathEscape(cfg.AccountSID) + "/Messages.json"
+	var failed []string
+	for _, to := range cfg.To {
+		err := n.postTwilioMessage(ctx, endpoint, cfg, to, body)
+		if err != nil {
+			log.Warn().Str("service", service.ID).Str("to", to).Err(err).Msg("failed to send twilio text message")
+			failed = append(failed, to+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send text messages to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// twilioBody renders the text of the message, it fits into the limit of the API
+func twilioBody(service config.ServiceConfig, cfg config.TwilioConfig, kind messageKind, data templateData) (string, error) {
+	if cfg.Body != "" {
+		body, err := render("body", cfg.Body, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to render the body: %w", err)
+		}
+		return truncate(body, maxTwilioBodyLength), nil
+	}
+	body := messageSummary(service, kind, data.Details)
+	if data.Link != "" {
+		// the link must not be cut off
+		return truncate(body, maxTwilioBodyLength-len(data.Link)-1) + "\n" + data.Link, nil
+	}
+	return truncate(body, maxTwilioBodyLength), nil
+}
+
+func (n *defaultNotifierType) postTwilioMessage(ctx context.Context, endpoint string, cfg config.TwilioConfig, to, body string) error {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(cfg.From, "MG") {
+		form.Set("MessagingServiceSid", cfg.From)
+	} else {
+		form.Set("From", cfg.From)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(cfg.AccountSID, cfg.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.egressClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		// errors of the API carry a code and a message, like 21211 for an invalid number
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(bs, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("twilio answered %d: %s (code %d)", resp.StatusCode, apiErr.Message, apiErr.Code)
+		}
+		return fmt.Errorf("twilio answered %d: %s", resp.StatusCode, strings.TrimSpace(string(bs)))
+	}
+	return nil
+}
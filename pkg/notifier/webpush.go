@@ -0,0 +1,36 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/webpush"
+)
+
+// sendToWebPush pushes the message to the subscriptions of the configured users. The notifications of a service
+// share a tag, so the recovery replaces the alert on the devices.
+func (n *defaultNotifierType) sendToWebPush(ctx context.Context, service config.ServiceConfig, cfg config.WebPushNotificationConfig, kind messageKind, details string) error {
+	if n.webPush == nil {
+		return errors.New("webpush notifications need the webPush config")
+	}
+	log.Info().
+		Str("service", service.ID).
+		Strs("users", cfg.Users).
+		Msg("pushing notification")
+	// the body is kept well within the single record of a push message
+	notification := webpush.Notification{
+		Title:  strings.ToUpper(string(kind)) + " " + service.ID,
+		Body:   truncate(messageSummary(service, kind, details), 1024),
+		URL:    n.link(ctx, service, kind),
+		Tag:    "deadman-switch/" + service.ID,
+		Urgent: kind == messageKindAlert || kind == messageKindMetaAlert,
+	}
+	if notification.URL == "" {
+		notification.URL = "/app/"
+	}
+	return n.webPush.Push(ctx, cfg.Users, notification, time.Duration(cfg.TTL))
+}
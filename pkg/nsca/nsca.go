@@ -0,0 +1,154 @@
+// Package nsca submits passive check results to a Nagios NSCA daemon, like send_nsca does. It implements the
+// version 3 packets with the encryption methods none and xor.
+package nsca
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// DefaultPort is the port of the NSCA daemon
+	DefaultPort = "5667"
+
+	ivSize        = 128
+	initSize      = ivSize + 4
+	hostSize      = 64
+	serviceSize   = 128
+	outputSize    = 512
+	packetVersion = 3
+	// packetSize is the size of the C struct of the daemon, including the padding
+	packetSize = 720
+)
+
+// Encryption methods of the daemon, the numbers of its config
+const (
+	EncryptionNone = "none"
+	EncryptionXOR  = "xor"
+)
+
+// Return codes of the check results
+const (
+	OK       = 0
+	Warning  = 1
+	Critical = 2
+	Unknown  = 3
+)
+
+// Result is a passive check result of a service on a host
+type Result struct {
+	Host    string
+	Service string
+	Code    int
+	Output  string
+}
+
+// Options of the connection to the daemon
+type Options struct {
+	// Server is the address of the daemon like nagios:5667
+	Server string
+	// Encryption is none or xor, it must match the decryption_method of the daemon
+	Encryption string
+	Password   string
+	// Control is called with the resolved address before connecting, see net.Dialer, it may be nil
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// Address returns the server address with the default port if it has none
+func Address(server string) (string, error) {
+	if server == "" {
+		return "", errors.New("the server address is empty")
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// no port
+		host, port = server, DefaultPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid server address %q", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Send submits the result. The daemon doesn't answer, it silently drops results it can't decrypt or whose host
+// or service are unknown to Nagios.
+func Send(ctx context.Context, opts Options, result Result) error {
+	if opts.Encryption != "" && opts.Encryption != EncryptionNone && opts.Encryption != EncryptionXOR {
+		return fmt.Errorf("unsupported encryption %q, use none or xor", opts.Encryption)
+	}
+	if result.Host == "" {
+		return errors.New("the host is empty")
+	}
+	address, err := Address(opts.Server)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline, Control: opts.Control}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	// the daemon sends the initialization vector and its time, which the packet must carry
+	init := make([]byte, initSize)
+	_, err = io.ReadFull(conn, init)
+	if err != nil {
+		return fmt.Errorf("no initialization packet from the daemon: %w", err)
+	}
+	packet := encode(result, binary.BigEndian.Uint32(init[ivSize:]))
+	if opts.Encryption == EncryptionXOR {
+		xor(packet, init[:ivSize], []byte(opts.Password))
+	}
+	_, err = conn.Write(packet)
+	return err
+}
+
+// encode returns the packet of the result with its checksum
+func encode(result Result, timestamp uint32) []byte {
+	packet := make([]byte, packetSize)
+	binary.BigEndian.PutUint16(packet[0:], packetVersion)
+	binary.BigEndian.PutUint32(packet[8:], timestamp)
+	binary.BigEndian.PutUint16(packet[12:], uint16(int16(result.Code)))
+	offset := 14
+	for _, field := range []struct {
+		value string
+		size  int
+	}{{result.Host, hostSize}, {result.Service, serviceSize}, {result.Output, outputSize}} {
+		value := field.value
+		// the strings are terminated by a zero byte
+		if len(value) > field.size-1 {
+			value = value[:field.size-1]
+		}
+		copy(packet[offset:], value)
+		offset += field.size
+	}
+	binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE(packet))
+	return packet
+}
+
+// xor encrypts the packet with the initialization vector and the password
+func xor(packet, iv, password []byte) {
+	for i := range packet {
+		packet[i] ^= iv[i%len(iv)]
+	}
+	if len(password) == 0 {
+		return
+	}
+	for i := range packet {
+		packet[i] ^= password[i%len(password)]
+	}
+}
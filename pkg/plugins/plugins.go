@@ -0,0 +1,350 @@
+// Package plugins implements notification types as WASM plugins. The plugins run in an embedded WebAssembly
+// runtime (wazero), so neither a rebuild of deadman-switch nor an external runtime is needed.
+//
+// A plugin is a WASI reactor module, e.g. built with `GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared`,
+// TinyGo or Rust for wasm32-wasi. ABI version 1: the module exports
+//
+//	memory
+//	deadman_abi() i32                       returns the ABI version, 1
+//	deadman_alloc(size i32) i32             returns a buffer of size bytes for the input of a call
+//	deadman_validate(ptr i32, len i32) i32  checks the config of a notification, given as JSON
+//	deadman_send(ptr i32, len i32) i32      delivers a message, given as JSON like notifier.Message
+//
+// The calls return 0 on success and any other value on failure, the reason may be set with deadman.error
+// before. The module may import these functions from the module "deadman":
+//
+//	error(ptr i32, len i32)                 sets the error message of the current call
+//	log(ptr i32, len i32)                   logs a message
+//	http_request(ptr i32, len i32) i32      sends an HTTP request given as JSON {"method", "url", "headers", "body"}
+//	                                        and returns the status code, or -1 if it failed
+//	http_response(ptr i32, len i32) i32     copies up to len bytes of the body of the last response, or the error
+//	                                        of the failed request, to ptr and returns the full length
+//
+// Every call runs in a fresh instance, limited in memory and by the timeout of the plugin. The instances have
+// no file system, no environment and no network except for http_request, which follows the egress policy.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+// ABIVersion is the version of the plugin ABI
+const ABIVersion = 1
+
+const (
+	defaultTimeout = 10 * time.Second
+	// memoryLimitPages limits the memory of an instance to 128 MiB
+	memoryLimitPages = 2048
+	// maxResponseSize limits the response bodies handed to the plugins
+	maxResponseSize = 1 << 20
+	// maxOutputLength limits the output of a failed call in the error
+	maxOutputLength = 1024
+)
+
+// exports are the functions every plugin must export
+var exports = []string{"deadman_abi", "deadman_alloc", "deadman_validate", "deadman_send"}
+
+// Register compiles the configured notifier plugins and registers a notifier.Sender for each of them
+func Register(ctx context.Context, cfg config.PluginsConfig, policy *egress.Policy) error {
+	if len(cfg.Notifiers) == 0 {
+		return nil
+	}
+	runtime, err := newRuntime(ctx)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: policy.Transport()}
+	for _, pluginCfg := range cfg.Notifiers {
+		if pluginCfg.Type == "" {
+			return fmt.Errorf("notifier plugin %s has no type", pluginCfg.File)
+		}
+		sender, err := load(ctx, runtime, pluginCfg, policy, client)
+		if err != nil {
+			return fmt.Errorf("notifier plugin %s: %w", pluginCfg.File, err)
+		}
+		err = notifier.RegisterSender(pluginCfg.Type, sender)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("type", string(pluginCfg.Type)).Str("file", pluginCfg.File).Msg("loaded notifier plugin")
+	}
+	return nil
+}
+
+// newRuntime creates the runtime with WASI and the host functions of the plugins, it is closed when the
+// context is done
+func newRuntime(ctx context.Context) (wazero.Runtime, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(memoryLimitPages).
+		WithCloseOnContextDone(true))
+	go func() {
+		<-ctx.Done()
+		runtime.Close(context.Background())
+	}()
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, runtime)
+	if err != nil {
+		return nil, err
+	}
+	_, err = runtime.NewHostModuleBuilder("deadman").
+		NewFunctionBuilder().WithFunc(hostError).Export("error").
+		NewFunctionBuilder().WithFunc(hostLog).Export("log").
+		NewFunctionBuilder().WithFunc(hostHTTPRequest).Export("http_request").
+		NewFunctionBuilder().WithFunc(hostHTTPResponse).Export("http_response").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return runtime, nil
+}
+
+// load compiles a plugin and checks its exports and ABI version
+func load(ctx context.Context, runtime wazero.Runtime, cfg config.NotifierPluginConfig, policy *egress.Policy, client *http.Client) (*Sender, error) {
+	code, err := ioutil.ReadFile(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range exports {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			return nil, fmt.Errorf("the module doesn't export %s", name)
+		}
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	sender := &Sender{
+		runtime:  runtime,
+		compiled: compiled,
+		name:     string(cfg.Type),
+		timeout:  timeout,
+		policy:   policy,
+		client:   client,
+	}
+	var version uint64
+	err = sender.run(ctx, func(ctx context.Context, mod api.Module) error {
+		results, err := mod.ExportedFunction("deadman_abi").Call(ctx)
+		if err != nil {
+			return err
+		}
+		version = results[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if version != ABIVersion {
+		return nil, fmt.Errorf("the module implements ABI version %d, expected %d", version, ABIVersion)
+	}
+	return sender, nil
+}
+
+// Sender implements notifier.Sender by calling a WASM plugin
+type Sender struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	name     string
+	timeout  time.Duration
+	policy   *egress.Policy
+	client   *http.Client
+}
+
+func (s *Sender) Validate(cfg interface{}) error {
+	input, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return s.call(context.Background(), "deadman_validate", input)
+}
+
+func (s *Sender) Send(ctx context.Context, msg notifier.Message) error {
+	// plugins have no business with the credentials of the pings
+	msg.Service.Token = ""
+	msg.Service.PingAuth = nil
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "deadman_send", input)
+}
+
+// call hands the input to an exported function of a fresh instance
+func (s *Sender) call(ctx context.Context, function string, input []byte) error {
+	return s.run(ctx, func(ctx context.Context, mod api.Module) error {
+		results, err := mod.ExportedFunction("deadman_alloc").Call(ctx, uint64(len(input)))
+		if err != nil {
+			return err
+		}
+		ptr := uint32(results[0])
+		if !mod.Memory().Write(ptr, input) {
+			return errors.New("deadman_alloc returned a buffer outside of the memory")
+		}
+		results, err = mod.ExportedFunction(function).Call(ctx, uint64(ptr), uint64(len(input)))
+		if err != nil {
+			return err
+		}
+		if status := uint32(results[0]); status != 0 {
+			c := currentCall(ctx)
+			if c.err != "" {
+				return errors.New(c.err)
+			}
+			return fmt.Errorf("%s failed with %d", function, status)
+		}
+		return nil
+	})
+}
+
+// run instantiates the plugin for a single call, which is limited to the timeout of the plugin
+func (s *Sender) run(ctx context.Context, fn func(ctx context.Context, mod api.Module) error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	c := &call{sender: s}
+	ctx = context.WithValue(ctx, callKey{}, c)
+	mod, err := s.runtime.InstantiateModule(ctx, s.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(&c.output).
+		WithStderr(&c.output).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader))
+	if err == nil {
+		defer mod.Close(context.Background())
+		err = fn(ctx, mod)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("plugin %s timed out after %s", s.name, s.timeout)
+	}
+	if err != nil {
+		out := strings.TrimSpace(c.output.String())
+		if len(out) > maxOutputLength {
+			out = out[len(out)-maxOutputLength:]
+		}
+		if out != "" {
+			return fmt.Errorf("plugin %s failed: %v: %s", s.name, err, out)
+		}
+		return fmt.Errorf("plugin %s failed: %v", s.name, err)
+	}
+	return nil
+}
+
+// call is the state of a single call, the host functions find it in their context
+type call struct {
+	sender *Sender
+	output bytes.Buffer
+	err    string
+	// response is the body of the last response or the error of the last request
+	response []byte
+}
+
+type callKey struct{}
+
+func currentCall(ctx context.Context) *call {
+	return ctx.Value(callKey{}).(*call)
+}
+
+// httpRequest is the JSON form of a request of a plugin
+type httpRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+func read(mod api.Module, ptr, size uint32) ([]byte, bool) {
+	bs, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, bs...), true
+}
+
+func hostError(ctx context.Context, mod api.Module, ptr, size uint32) {
+	if bs, ok := read(mod, ptr, size); ok {
+		currentCall(ctx).err = string(bs)
+	}
+}
+
+func hostLog(ctx context.Context, mod api.Module, ptr, size uint32) {
+	if bs, ok := read(mod, ptr, size); ok {
+		log.Info().Str("plugin", currentCall(ctx).sender.name).Msg(string(bs))
+	}
+}
+
+func hostHTTPRequest(ctx context.Context, mod api.Module, ptr, size uint32) int32 {
+	c := currentCall(ctx)
+	status, err := c.httpRequest(ctx, mod, ptr, size)
+	if err != nil {
+		c.response = []byte(err.Error())
+		return -1
+	}
+	return int32(status)
+}
+
+func (c *call) httpRequest(ctx context.Context, mod api.Module, ptr, size uint32) (int, error) {
+	bs, ok := read(mod, ptr, size)
+	if !ok {
+		return 0, errors.New("the request is outside of the memory")
+	}
+	var req httpRequest
+	err := json.Unmarshal(bs, &req)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request: %v", err)
+	}
+	if req.Method == "" {
+		req.Method = http.MethodPost
+	}
+	err = c.sender.policy.CheckURL(ctx, req.URL)
+	if err != nil {
+		return 0, err
+	}
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.URL, strings.NewReader(req.Body))
+	if err != nil {
+		return 0, err
+	}
+	if req.Headers != nil {
+		r.Header = req.Headers
+	}
+	resp, err := c.sender.client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	c.response, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
+
+func hostHTTPResponse(ctx context.Context, mod api.Module, ptr, size uint32) uint32 {
+	response := currentCall(ctx).response
+	n := uint32(len(response))
+	if size < n {
+		n = size
+	}
+	mod.Memory().Write(ptr, response[:n])
+	return uint32(len(response))
+}
@@ -0,0 +1,155 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+// buildEcho builds the plugin of testdata/echo, the test is skipped if the toolchain can't build wasip1 modules
+func buildEcho(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "echo.wasm")
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", file, ".")
+	cmd.Dir = filepath.Join("testdata", "echo")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Skipf("can't build the echo plugin: %v: %s", err, out)
+	}
+	return file
+}
+
+func TestPlugin(t *testing.T) {
+	file := buildEcho(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runtime, err := newRuntime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan notifier.Message, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "no capacity", http.StatusServiceUnavailable)
+			return
+		}
+		var msg notifier.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- msg
+	}))
+	defer server.Close()
+	load := func(policy *egress.Policy, timeout time.Duration) *Sender {
+		sender, err := load(ctx, runtime, config.NotifierPluginConfig{Type: "echo", File: file, Timeout: config.Duration(timeout)}, policy, &http.Client{Transport: policy.Transport()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sender
+	}
+	sender := load(nil, 0)
+	denied := load(egress.New(&config.EgressConfig{DenyPrivateNetworks: true}), 0)
+	short := load(nil, 200*time.Millisecond)
+
+	t.Run("validate", func(t *testing.T) {
+		for _, test := range []struct {
+			name string
+			cfg  interface{}
+			err  string
+		}{
+			{"valid", map[string]interface{}{"url": server.URL}, ""},
+			{"missing url", map[string]interface{}{}, "url is required"},
+			{"invalid type", map[string]interface{}{"url": 1}, "cannot unmarshal"},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				err := sender.Validate(test.cfg)
+				if test.err == "" && err != nil {
+					t.Fatalf("expected a valid config, got %v", err)
+				}
+				if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Fatalf("expected an error with %q, got %v", test.err, err)
+				}
+			})
+		}
+	})
+
+	t.Run("send", func(t *testing.T) {
+		for _, test := range []struct {
+			name   string
+			sender *Sender
+			cfg    map[string]interface{}
+			err    string
+		}{
+			{"delivered", sender, map[string]interface{}{"url": server.URL + "/ok"}, ""},
+			{"failed response", sender, map[string]interface{}{"url": server.URL + "/fail"}, "answered 503: no capacity"},
+			{"denied by the egress policy", denied, map[string]interface{}{"url": server.URL + "/ok"}, "denied"},
+			{"timeout", short, map[string]interface{}{"url": server.URL + "/ok", "loop": true}, "timed out"},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				err := test.sender.Send(ctx, notifier.Message{
+					Service: config.ServiceConfig{ID: "backup", Token: "secret", PingAuth: &config.PingAuthConfig{}},
+					Kind:    "alert",
+					Config:  test.cfg,
+				})
+				if test.err != "" {
+					if err == nil || !strings.Contains(err.Error(), test.err) {
+						t.Fatalf("expected an error with %q, got %v", test.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg := <-received
+				if msg.Service.ID != "backup" || msg.Kind != "alert" {
+					t.Fatalf("unexpected message %+v", msg)
+				}
+				if msg.Service.Token != "" || msg.Service.PingAuth != nil {
+					t.Fatal("expected the credentials of the pings to be hidden from the plugin")
+				}
+			})
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runtime, err := newRuntime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// an empty module which exports nothing
+	file := filepath.Join(dir, "empty.wasm")
+	err = ioutil.WriteFile(file, []byte("\x00asm\x01\x00\x00\x00"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = load(ctx, runtime, config.NotifierPluginConfig{Type: "empty", File: file}, nil, http.DefaultClient)
+	if err == nil || !strings.Contains(err.Error(), "doesn't export deadman_abi") {
+		t.Fatalf("expected the missing exports to be reported, got %v", err)
+	}
+}
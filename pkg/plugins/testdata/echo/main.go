@@ -0,0 +1,97 @@
+// Command echo is a notifier plugin for the tests. It posts the messages to the url of its config.
+// Build it with GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"unsafe"
+)
+
+//go:wasmimport deadman error
+func hostError(ptr unsafe.Pointer, size uint32)
+
+//go:wasmimport deadman http_request
+func httpRequest(ptr unsafe.Pointer, size uint32) int32
+
+//go:wasmimport deadman http_response
+func httpResponse(ptr unsafe.Pointer, size uint32) uint32
+
+type config struct {
+	URL  string `json:"url"`
+	Loop bool   `json:"loop"`
+}
+
+type request struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+}
+
+func fail(msg string) uint32 {
+	hostError(unsafe.Pointer(unsafe.StringData(msg)), uint32(len(msg)))
+	return 1
+}
+
+func input(ptr unsafe.Pointer, size uint32) []byte {
+	return unsafe.Slice((*byte)(ptr), size)
+}
+
+// buffers keeps the inputs alive until the call is done
+var buffers [][]byte
+
+//go:wasmexport deadman_abi
+func abi() uint32 { return 1 }
+
+//go:wasmexport deadman_alloc
+func alloc(size uint32) unsafe.Pointer {
+	b := make([]byte, size+1)
+	buffers = append(buffers, b)
+	return unsafe.Pointer(unsafe.SliceData(b))
+}
+
+//go:wasmexport deadman_validate
+func validate(ptr unsafe.Pointer, size uint32) uint32 {
+	var cfg config
+	if err := json.Unmarshal(input(ptr, size), &cfg); err != nil {
+		return fail(err.Error())
+	}
+	if cfg.URL == "" {
+		return fail("url is required")
+	}
+	return 0
+}
+
+//go:wasmexport deadman_send
+func send(ptr unsafe.Pointer, size uint32) uint32 {
+	var msg struct {
+		Config config `json:"config"`
+	}
+	if err := json.Unmarshal(input(ptr, size), &msg); err != nil {
+		return fail(err.Error())
+	}
+	for msg.Config.Loop {
+	}
+	req, _ := json.Marshal(request{
+		Method:  "POST",
+		URL:     msg.Config.URL,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+		Body:    string(input(ptr, size)),
+	})
+	status := httpRequest(unsafe.Pointer(unsafe.SliceData(req)), uint32(len(req)))
+	body := make([]byte, 256)
+	n := httpResponse(unsafe.Pointer(unsafe.SliceData(body)), uint32(len(body)))
+	if n > uint32(len(body)) {
+		n = uint32(len(body))
+	}
+	if status < 0 {
+		return fail(string(body[:n]))
+	}
+	if status >= 300 {
+		return fail("answered " + strconv.Itoa(int(status)) + ": " + string(body[:n]))
+	}
+	return 0
+}
+
+func main() {}
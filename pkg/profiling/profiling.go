@@ -0,0 +1,96 @@
+// Package profiling serves net/http/pprof and the Go runtime metrics on a private listener, to profile
+// a production instance without exposing the profiles through the API.
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// Checker reports the time the last check of the deadlines took, see checker.Checker
+type Checker interface {
+	LastCheckDuration() time.Duration
+}
+
+// Serve listens on the address of the config until the context is done, checker may be nil
+func Serve(ctx context.Context, cfg config.ProfilingConfig, checker Checker) error {
+	runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", string(expfmt.FmtText))
+		writeRuntimeMetrics(w)
+		if checker != nil {
+			fmt.Fprintln(w, "# HELP deadman_switch_checker_duration_seconds Time the last check of the deadlines by this instance took.")
+			fmt.Fprintln(w, "# TYPE deadman_switch_checker_duration_seconds gauge")
+			fmt.Fprintf(w, "deadman_switch_checker_duration_seconds %g\n", checker.LastCheckDuration().Seconds())
+		}
+	})
+
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: mux,
+	}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	log.Info().Str("address", cfg.Listen).Msg("serving pprof and runtime metrics")
+	select {
+	case err := <-listenErr:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// writeRuntimeMetrics writes the metrics of the Go runtime in the Prometheus text format, named like the
+// ones of the Prometheus client library
+func writeRuntimeMetrics(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	metric := func(name, typ, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		fmt.Fprintf(w, "%s %g\n", name, value)
+	}
+	fmt.Fprintln(w, "# HELP go_info Information about the Go environment.")
+	fmt.Fprintln(w, "# TYPE go_info gauge")
+	fmt.Fprintf(w, "go_info{version=%q} 1\n", runtime.Version())
+	metric("go_goroutines", "gauge", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	metric("go_threads", "gauge", "Number of OS threads created.", float64(runtimepprof.Lookup("threadcreate").Count()))
+	metric("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", float64(stats.Alloc))
+	metric("go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", float64(stats.TotalAlloc))
+	metric("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", float64(stats.Sys))
+	metric("go_memstats_mallocs_total", "counter", "Total number of mallocs.", float64(stats.Mallocs))
+	metric("go_memstats_frees_total", "counter", "Total number of frees.", float64(stats.Frees))
+	metric("go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.", float64(stats.HeapAlloc))
+	metric("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", float64(stats.HeapInuse))
+	metric("go_memstats_heap_idle_bytes", "gauge", "Number of heap bytes waiting to be used.", float64(stats.HeapIdle))
+	metric("go_memstats_heap_released_bytes", "gauge", "Number of heap bytes released to OS.", float64(stats.HeapReleased))
+	metric("go_memstats_heap_objects", "gauge", "Number of allocated objects.", float64(stats.HeapObjects))
+	metric("go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.", float64(stats.StackInuse))
+	metric("go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.", float64(stats.NextGC))
+	metric("go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.", float64(stats.LastGC)/1e9)
+	metric("go_memstats_gc_cpu_fraction", "gauge", "The fraction of this program's available CPU time used by the GC since the program started.", stats.GCCPUFraction)
+	metric("go_gc_cycles_total", "counter", "Number of completed garbage collection cycles.", float64(stats.NumGC))
+	metric("go_gc_pause_seconds_total", "counter", "Total time the garbage collection stopped the world.", float64(stats.PauseTotalNs)/1e9)
+}
@@ -0,0 +1,169 @@
+// Package pushmetrics handles Prometheus metrics which are pushed with heartbeats, like the Pushgateway does.
+//
+// Pushed metrics are labeled with the service and kept until the next push of the same service.
+package pushmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// ServiceLabel is added to all pushed metrics
+const ServiceLabel = "service"
+
+const (
+	// MaxBodySize limits the body of a push, the parsed metrics are kept in memory and in the storage
+	MaxBodySize = 1 << 20
+	// MaxSeries limits the series of a push
+	MaxSeries = 10000
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Format returns the format of pushed metrics or false if the request doesn't contain metrics.
+// Plain text bodies are only treated as metrics with the version of the Prometheus text format,
+// so heartbeats which carry logs keep working.
+func Format(header http.Header) (expfmt.Format, bool) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return expfmt.FmtUnknown, false
+	}
+	switch {
+	case mediaType == "text/plain" && params["version"] == expfmt.TextVersion:
+		return expfmt.FmtText, true
+	case mediaType == expfmt.ProtoType && params["proto"] == expfmt.ProtoProtocol && params["encoding"] == "delimited":
+		return expfmt.FmtProtoDelim, true
+	}
+	return expfmt.FmtUnknown, false
+}
+
+// Parse decodes the pushed metrics and returns them labeled with the service in the text format.
+// It fails for more than MaxSeries series.
+func Parse(r io.Reader, format expfmt.Format, svc config.ServiceConfig) (string, error) {
+	labels := serviceLabels(svc)
+	decoder := expfmt.NewDecoder(r, format)
+	var buf bytes.Buffer
+	series := 0
+	for {
+		var family dto.MetricFamily
+		err := decoder.Decode(&family)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		series += len(family.Metric)
+		if series > MaxSeries {
+			return "", fmt.Errorf("more than %d series", MaxSeries)
+		}
+		for _, metric := range family.Metric {
+			metric.Label = withLabels(metric.Label, labels)
+		}
+		_, err = expfmt.MetricFamilyToText(&buf, &family)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// serviceLabels returns the labels added to the metrics of the service.
+// The label names of the service are sanitized, because they may contain characters Prometheus doesn't allow.
+func serviceLabels(svc config.ServiceConfig) map[string]string {
+	labels := make(map[string]string)
+	for name, value := range svc.Labels {
+		name = invalidLabelChars.ReplaceAllString(name, "_")
+		if name == "" || strings.HasPrefix(name, "__") || (name[0] >= '0' && name[0] <= '9') {
+			continue
+		}
+		labels[name] = value
+	}
+	labels[ServiceLabel] = svc.ID
+	return labels
+}
+
+// withLabels adds the labels, pushed labels win except for the service label
+func withLabels(pairs []*dto.LabelPair, labels map[string]string) []*dto.LabelPair {
+	existing := make(map[string]bool)
+	result := pairs[:0]
+	for _, pair := range pairs {
+		if pair.GetName() == ServiceLabel {
+			continue
+		}
+		existing[pair.GetName()] = true
+		result = append(result, pair)
+	}
+	for name, value := range labels {
+		if existing[name] {
+			continue
+		}
+		result = append(result, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+	return result
+}
+
+// Write merges the metrics of all services and writes them in the text format.
+// A gauge with the time of the last push is added for every service.
+func Write(w io.Writer, pushed []storage.ServiceMetrics) error {
+	families := make(map[string]*dto.MetricFamily)
+	pushTime := &dto.MetricFamily{
+		Name: proto.String("deadman_switch_push_time_seconds"),
+		Help: proto.String("Last time metrics were pushed with a heartbeat of the service"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, m := range pushed {
+		var parser expfmt.TextParser
+		parsed, err := parser.TextToMetricFamilies(strings.NewReader(m.Text))
+		if err != nil {
+			log.Error().Str("service", m.Service).Err(err).Msg("failed to parse stored metrics")
+			continue
+		}
+		for name, family := range parsed {
+			existing, ok := families[name]
+			if !ok {
+				families[name] = family
+				continue
+			}
+			if existing.GetType() != family.GetType() {
+				log.Warn().Str("service", m.Service).Str("metric", name).Msg("metric was pushed with different types, skip it")
+				continue
+			}
+			existing.Metric = append(existing.Metric, family.Metric...)
+		}
+		pushTime.Metric = append(pushTime.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String(ServiceLabel), Value: proto.String(m.Service)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(float64(m.PushedAt.UnixNano()) / float64(time.Second))},
+		})
+	}
+	if len(pushTime.Metric) > 0 {
+		families[pushTime.GetName()] = pushTime
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := expfmt.MetricFamilyToText(w, families[name])
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
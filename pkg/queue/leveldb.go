@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	levelDBItemsPrefix   = "items/"
+	levelDBDelayedPrefix = "delayed/"
+)
+
+// NewLevelDBQueue creates a queue in a local LevelDB database, so queued items survive restarts of single node deployments
+func NewLevelDBQueue(file string) (Queue, error) {
+	db, err := leveldb.OpenFile(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	q := &levelDBQueue{
+		db:       db,
+		enqueued: make(chan struct{}, 1),
+	}
+	// continue after the last item of a previous run
+	iterator := db.NewIterator(util.BytesPrefix([]byte(levelDBItemsPrefix)), nil)
+	if iterator.Last() {
+		seq := strings.SplitN(strings.TrimPrefix(string(iterator.Key()), levelDBItemsPrefix), "-", 2)[0]
+		q.seq, err = strconv.ParseUint(seq, 10, 64)
+	}
+	iterator.Release()
+	if err == nil {
+		err = iterator.Error()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+type levelDBQueue struct {
+	db    *leveldb.DB
+	mutex sync.Mutex
+	seq   uint64
+	// enqueued wakes up a waiting Dequeue
+	enqueued chan struct{}
+}
+
+func (q *levelDBQueue) Enqueue(ctx context.Context, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	log.Debug().Interface("obj", obj).Msg("enqueue stuff")
+	q.mutex.Lock()
+	q.seq++
+	// zero padded, so the keys sort in insertion order, the time is kept for List
+	err = q.db.Put([]byte(fmt.Sprintf("%s%020d-%d", levelDBItemsPrefix, q.seq, time.Now().UnixNano())), data, nil)
+	q.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case q.enqueued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *levelDBQueue) EnqueueAt(ctx context.Context, obj interface{}, notBefore time.Time) error {
+	if !notBefore.After(time.Now()) {
+		return q.Enqueue(ctx, obj)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	log.Debug().Interface("obj", obj).Time("notBefore", notBefore).Msg("enqueue delayed stuff")
+	q.mutex.Lock()
+	q.seq++
+	err = q.db.Put([]byte(levelDBDelayedPrefix+delayedKey(notBefore, fmt.Sprintf("%020d", q.seq))), data, nil)
+	q.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case q.enqueued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *levelDBQueue) Dequeue(ctx context.Context, target interface{}) error {
+	for {
+		ok, wait, err := q.dequeue(target)
+		if err != nil || ok {
+			return err
+		}
+		// wait for new items or until the next delayed item is due
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.enqueued:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// dequeue removes the first due item, it returns false and the time until the next delayed item is due if there is none
+func (q *levelDBQueue) dequeue(target interface{}) (bool, time.Duration, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	// due delayed items go first, they have been waiting the longest
+	delayed := q.db.NewIterator(util.BytesPrefix([]byte(levelDBDelayedPrefix)), nil)
+	defer delayed.Release()
+	var wait time.Duration
+	if delayed.First() {
+		wait = time.Until(delayedDue(strings.TrimPrefix(string(delayed.Key()), levelDBDelayedPrefix)))
+		if wait <= 0 {
+			return true, 0, q.take(delayed, target)
+		}
+	} else if err := delayed.Error(); err != nil {
+		return false, 0, err
+	}
+	items := q.db.NewIterator(util.BytesPrefix([]byte(levelDBItemsPrefix)), nil)
+	defer items.Release()
+	if !items.First() {
+		return false, wait, items.Error()
+	}
+	return true, 0, q.take(items, target)
+}
+
+// take removes the current item of the iterator before decoding it, so a broken item doesn't block the queue
+func (q *levelDBQueue) take(it iterator.Iterator, target interface{}) error {
+	err := q.db.Delete(it.Key(), nil)
+	if err != nil {
+		return err
+	}
+	return decode(append([]byte(nil), it.Value()...), target)
+}
+
+func (q *levelDBQueue) List(ctx context.Context) ([]Item, error) {
+	var items []Item
+	for _, prefix := range []string{levelDBDelayedPrefix, levelDBItemsPrefix} {
+		it := q.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for it.Next() {
+			key := string(it.Key())
+			item := Item{
+				ID:   key,
+				Data: append(json.RawMessage(nil), it.Value()...),
+			}
+			if prefix == levelDBDelayedPrefix {
+				item.DueAt = delayedDue(strings.TrimPrefix(key, prefix))
+			} else if parts := strings.SplitN(strings.TrimPrefix(key, prefix), "-", 2); len(parts) == 2 {
+				nanos, _ := strconv.ParseInt(parts[1], 10, 64)
+				item.DueAt = time.Unix(0, nanos)
+			}
+			items = append(items, item)
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+	}
+	sortItems(items)
+	return items, nil
+}
+
+func (q *levelDBQueue) Remove(ctx context.Context, id string) error {
+	if !strings.HasPrefix(id, levelDBItemsPrefix) && !strings.HasPrefix(id, levelDBDelayedPrefix) {
+		return ErrNotFound
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ok, err := q.db.Has([]byte(id), nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return q.db.Delete([]byte(id), nil)
+}
+
+// Close closes the underlying database
+func (q *levelDBQueue) Close() error {
+	return q.db.Close()
+}
@@ -4,26 +4,75 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/rs/zerolog/log"
 	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
 	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/mvcc/mvccpb"
 )
 
 var (
 	ErrQueueEmpty error = errors.New("queue is empty")
+	ErrNotFound   error = errors.New("queue item not found")
 )
 
 type Queue interface {
 	Enqueue(ctx context.Context, data interface{}) error
+	// EnqueueAt adds an item which is not dequeued before notBefore
+	EnqueueAt(ctx context.Context, data interface{}, notBefore time.Time) error
 	Dequeue(ctx context.Context, data interface{}) error
+	// List returns all queued items ordered by the time they are due, without removing them
+	List(ctx context.Context) ([]Item, error)
+	// Remove removes a queued item, it returns ErrNotFound for unknown items
+	Remove(ctx context.Context, id string) error
+}
+
+// Item is a queued item as returned by List
+type Item struct {
+	ID string `json:"id"`
+	// DueAt is the time the item was enqueued or, for delayed items, the time it is due
+	DueAt time.Time       `json:"dueAt"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// DecodeError is returned by Dequeue for an item which can't be decoded, the item is removed from the queue nonetheless
+type DecodeError struct {
+	Data []byte
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return "failed to decode queue item: " + e.Err.Error()
+}
+
+func decode(data []byte, target interface{}) error {
+	err := json.Unmarshal(data, target)
+	if err != nil {
+		return &DecodeError{Data: data, Err: err}
+	}
+	return nil
+}
+
+// sortItems sorts items by the time they are due, items with an unknown time first
+func sortItems(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DueAt.Before(items[j].DueAt)
+	})
 }
 
-func NewEtcdQueue(ctx context.Context, cli *clientv3.Client, prefix string) (Queue, error) {
-	concurrencyClient, err := concurrency.NewEtcdClient(ctx, cli)
+func NewEtcdQueue(ctx context.Context, cli *clientv3.Client, prefix string, election config.ElectionConfig) (Queue, error) {
+	concurrencyClient, err := concurrency.NewEtcdClient(ctx, cli, election)
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +105,19 @@ func (q *etcdQueue) Enqueue(ctx context.Context, obj interface{}) error {
 	return nil
 }
 
+func (q *etcdQueue) EnqueueAt(ctx context.Context, obj interface{}, notBefore time.Time) error {
+	if !notBefore.After(time.Now()) {
+		return q.Enqueue(ctx, obj)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	log.Debug().Interface("obj", obj).Time("notBefore", notBefore).Msg("enqueue delayed stuff")
+	_, err = q.cli.Put(ctx, filepath.Join(q.prefix, "delayed", delayedKey(notBefore, uuid.New().String())), string(data))
+	return err
+}
+
 func (q *etcdQueue) Dequeue(ctx context.Context, target interface{}) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -63,32 +125,133 @@ func (q *etcdQueue) Dequeue(ctx context.Context, target interface{}) error {
 	if err != nil {
 		return err
 	}
-	key := filepath.Join(q.prefix, "items")
-	var kv *mvccpb.KeyValue
-	resp, err := q.cli.KV.Get(ctx, key, append(clientv3.WithFirstKey(), clientv3.WithPrefix())...)
-	if err != nil {
-		return err
-	}
-	if len(resp.Kvs) > 0 {
-		kv = resp.Kvs[0]
-	} else {
-		ctx, cancel := context.WithCancel(ctx)
-		ch := q.cli.Watch(ctx, key, clientv3.WithPrefix())
-		watchResp, ok := <-ch
-		if !ok {
-			cancel()
+	items := filepath.Join(q.prefix, "items") + "/"
+	delayed := filepath.Join(q.prefix, "delayed") + "/"
+	for {
+		// due delayed items go first, they have been waiting the longest
+		resp, err := q.cli.KV.Get(ctx, delayed, append(clientv3.WithFirstKey(), clientv3.WithPrefix())...)
+		if err != nil {
+			return err
+		}
+		revision := resp.Header.Revision
+		var (
+			kv   *mvccpb.KeyValue
+			wait time.Duration
+		)
+		if len(resp.Kvs) > 0 {
+			wait = time.Until(delayedDue(strings.TrimPrefix(string(resp.Kvs[0].Key), delayed)))
+			if wait <= 0 {
+				kv = resp.Kvs[0]
+			}
+		}
+		if kv == nil {
+			resp, err = q.cli.KV.Get(ctx, items, append(clientv3.WithFirstKey(), clientv3.WithPrefix())...)
+			if err != nil {
+				return err
+			}
+			if len(resp.Kvs) > 0 {
+				kv = resp.Kvs[0]
+			}
+		}
+		if kv != nil {
+			// remove the item before decoding it, so a broken item doesn't block the queue
+			_, err = q.cli.KV.Delete(ctx, string(kv.Key))
+			if err != nil {
+				return err
+			}
+			return decode(kv.Value, target)
+		}
+
+		// wait for new items or until the next delayed item is due
+		var (
+			waitCtx    context.Context
+			cancelWait context.CancelFunc
+		)
+		if wait > 0 {
+			waitCtx, cancelWait = context.WithTimeout(ctx, wait)
+		} else {
+			waitCtx, cancelWait = context.WithCancel(ctx)
+		}
+		itemEvents := q.cli.Watch(waitCtx, items, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+		delayedEvents := q.cli.Watch(waitCtx, delayed, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+		var closed bool
+		select {
+		case _, ok := <-itemEvents:
+			closed = !ok
+		case _, ok := <-delayedEvents:
+			closed = !ok
+		case <-waitCtx.Done():
+		}
+		// the watches are also closed when the next delayed item is due
+		due := waitCtx.Err() != nil
+		cancelWait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if closed && !due {
 			return ErrQueueEmpty
 		}
-		cancel()
-		kv = watchResp.Events[0].Kv
 	}
-	err = json.Unmarshal(kv.Value, target)
-	if err != nil {
-		return err
+}
+
+func (q *etcdQueue) List(ctx context.Context) ([]Item, error) {
+	var items []Item
+	for _, dir := range []string{"delayed", "items"} {
+		prefix := filepath.Join(q.prefix, dir) + "/"
+		resp, err := q.cli.KV.Get(ctx, prefix, clientv3.WithPrefix())
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range resp.Kvs {
+			key := strings.TrimPrefix(string(kv.Key), prefix)
+			item := Item{
+				ID:   path.Join(dir, key),
+				Data: kv.Value,
+			}
+			if dir == "delayed" {
+				item.DueAt = delayedDue(key)
+			} else {
+				item.DueAt, _ = time.Parse(time.RFC3339Nano, key)
+			}
+			items = append(items, item)
+		}
+	}
+	sortItems(items)
+	return items, nil
+}
+
+func (q *etcdQueue) Remove(ctx context.Context, id string) error {
+	if path.Clean(id) != id || !strings.HasPrefix(id, "items/") && !strings.HasPrefix(id, "delayed/") {
+		return ErrNotFound
 	}
-	_, err = q.cli.KV.Delete(ctx, string(kv.Key))
+	resp, err := q.cli.KV.Delete(ctx, filepath.Join(q.prefix, id))
 	if err != nil {
 		return err
 	}
+	if resp.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Close releases the lock of a running Dequeue right away
+func (q *etcdQueue) Close() error {
+	if closer, ok := q.concurrency.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
+
+// delayedKey sorts delayed items by the time they are due
+func delayedKey(notBefore time.Time, id string) string {
+	return fmt.Sprintf("%020d-%s", notBefore.UnixNano(), id)
+}
+
+// delayedDue returns the time a delayed item is due, unparseable keys are due immediately
+func delayedDue(key string) time.Time {
+	nanos, err := strconv.ParseInt(strings.SplitN(key, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,328 @@
+// Package replication mirrors the service configs and silences between two independent deployments, e.g. in
+// different regions, so a regional failure doesn't lose the monitoring definitions. Heartbeats and alarms are
+// not replicated, every deployment checks the services on its own.
+//
+// Every deployment serves a snapshot of its configs and silences with their modification times, and its leader
+// pulls the snapshot of the peer in an interval. The newer version of a config or silence wins. Deleted configs
+// are known from the config versions, silences are never deleted but expired.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	// SnapshotPath is where a deployment serves its snapshot to the peer
+	SnapshotPath = "/replication/snapshot"
+)
+
+// Snapshot holds everything a deployment replicates
+type Snapshot struct {
+	Services []Service         `json:"services"`
+	Silences []storage.Silence `json:"silences"`
+}
+
+// Service is the latest version of a service config
+type Service struct {
+	ID string `json:"id"`
+	// ModifiedAt is zero if the config was never changed through the API, like the ones of the config file
+	ModifiedAt time.Time `json:"modifiedAt"`
+	// Deleted services have no config
+	Deleted bool                  `json:"deleted,omitempty"`
+	Config  *config.ServiceConfig `json:"config,omitempty"`
+}
+
+// Result counts the changes applied from a snapshot
+type Result struct {
+	Saved    int `json:"saved"`
+	Deleted  int `json:"deleted"`
+	Silences int `json:"silences"`
+	// Invalid are the IDs of the configs which were rejected by the checks of the API
+	Invalid []string `json:"invalid,omitempty"`
+}
+
+// Collect returns the snapshot of the store, which must return the configs as they are stored, without defaults
+func Collect(ctx context.Context, store storage.Storage) (Snapshot, error) {
+	histories, err := store.GetServiceConfigHistories(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	services := make(map[string]Service)
+	configs, errs := store.GetServiceConfigs(ctx)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			return Snapshot{}, ctx.Err()
+		case err := <-errs:
+			if err != nil {
+				return Snapshot{}, err
+			}
+		case svc, ok := <-configs:
+			if !ok {
+				break loop
+			}
+			service := Service{ID: svc.ID, Config: &svc}
+			// a config of the config file may differ from the latest version, then its age is unknown
+			if latest, ok := histories[svc.ID].Latest(); ok && !latest.Deleted && sameConfig(latest.Config, &svc) {
+				service.ModifiedAt = latest.CreatedAt
+			}
+			services[svc.ID] = service
+		}
+	}
+	for id, history := range histories {
+		if _, ok := services[id]; ok {
+			continue
+		}
+		if latest, ok := history.Latest(); ok && latest.Deleted {
+			services[id] = Service{ID: id, ModifiedAt: latest.CreatedAt, Deleted: true}
+		}
+	}
+	snapshot := Snapshot{Services: make([]Service, 0, len(services))}
+	for _, service := range services {
+		snapshot.Services = append(snapshot.Services, service)
+	}
+	sort.Slice(snapshot.Services, func(i, j int) bool { return snapshot.Services[i].ID < snapshot.Services[j].ID })
+	snapshot.Silences, err = store.GetSilences(ctx)
+	return snapshot, err
+}
+
+// Apply applies the changes of the snapshot which are newer than the local ones. The store must record the
+// config versions, see storage.WithConfigVersions, the replicated versions keep the time of the peer.
+// prepare checks a replicated config and returns it in the form it is saved in, like the API does.
+func Apply(ctx context.Context, store storage.Storage, snapshot Snapshot, prepare func(context.Context, config.ServiceConfig) (config.ServiceConfig, error), now time.Time) (Result, error) {
+	var result Result
+	local, err := Collect(ctx, store)
+	if err != nil {
+		return result, err
+	}
+	services := make(map[string]Service, len(local.Services))
+	for _, service := range local.Services {
+		services[service.ID] = service
+	}
+	for _, remote := range snapshot.Services {
+		current, known := services[remote.ID]
+		if known && !remote.ModifiedAt.After(current.ModifiedAt) {
+			continue
+		}
+		if remote.Deleted {
+			if !known || current.Deleted {
+				continue
+			}
+			err = store.DeleteServiceConfig(ctx, remote.ID)
+			if err != nil && err != storage.ErrNotFound {
+				return result, err
+			}
+			log.Info().Str("service", remote.ID).Msg("deleted replicated service config")
+			result.Deleted++
+		} else {
+			if remote.Config == nil || remote.Config.ID != remote.ID {
+				continue
+			}
+			if known && !current.Deleted && sameConfig(current.Config, remote.Config) {
+				continue
+			}
+			prepared, err := prepare(ctx, *remote.Config)
+			if err != nil {
+				log.Warn().Str("service", remote.ID).Err(err).Msg("rejected invalid replicated service config")
+				result.Invalid = append(result.Invalid, remote.ID)
+				continue
+			}
+			err = store.SaveServiceConfig(ctx, prepared)
+			if err != nil {
+				return result, err
+			}
+			log.Info().Str("service", remote.ID).Msg("saved replicated service config")
+			result.Saved++
+		}
+		err = keepModificationTime(ctx, store, remote)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	silences := make(map[string]storage.Silence, len(local.Silences))
+	for _, silence := range local.Silences {
+		silences[silence.ID] = silence
+	}
+	for _, remote := range snapshot.Silences {
+		current, known := silences[remote.ID]
+		if known && !remote.Modified().After(current.Modified()) {
+			continue
+		}
+		// the ended ones are about to be pruned
+		if !known && remote.Ended(now) {
+			continue
+		}
+		err = store.SaveSilence(ctx, remote)
+		if err != nil {
+			return result, err
+		}
+		result.Silences++
+	}
+	return result, nil
+}
+
+// keepModificationTime sets the time of the peer on the version which was just recorded, so both deployments
+// agree on the age of the config and don't replicate it back and forth
+func keepModificationTime(ctx context.Context, store storage.Storage, remote Service) error {
+	if remote.ModifiedAt.IsZero() {
+		return nil
+	}
+	history, err := store.GetServiceConfigHistory(ctx, remote.ID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(history.Versions) == 0 {
+		return nil
+	}
+	history.Versions[len(history.Versions)-1].CreatedAt = remote.ModifiedAt
+	return store.SaveServiceConfigHistory(ctx, remote.ID, history)
+}
+
+func sameConfig(a, b *config.ServiceConfig) bool {
+	bsA, errA := json.Marshal(a)
+	bsB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(bsA, bsB)
+}
+
+// State is the outcome of the pulls from the peer
+type State struct {
+	Peer        string     `json:"peer"`
+	LastPull    *time.Time `json:"lastPull,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastResult  *Result    `json:"lastResult,omitempty"`
+}
+
+// Replicator pulls the snapshot of the peer in an interval and applies it
+type Replicator struct {
+	cfg         config.ReplicationConfig
+	store       storage.Storage
+	concurrency concurrency.Client
+	prepare     func(context.Context, config.ServiceConfig) (config.ServiceConfig, error)
+	clock       clock.Clock
+	cli         *http.Client
+
+	mutex sync.Mutex
+	state State
+}
+
+func NewReplicator(cfg config.ReplicationConfig, store storage.Storage, concurrency concurrency.Client, prepare func(context.Context, config.ServiceConfig) (config.ServiceConfig, error), clock clock.Clock) *Replicator {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	return &Replicator{
+		cfg:         cfg,
+		store:       store,
+		concurrency: concurrency,
+		prepare:     prepare,
+		clock:       clock,
+		cli:         &http.Client{Timeout: time.Minute},
+		state:       State{Peer: cfg.Peer},
+	}
+}
+
+func (r *Replicator) Backend(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(r.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		r.pullIfLeader(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Replicator) pullIfLeader(ctx context.Context) {
+	if r.concurrency != nil {
+		isLeader, err := r.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
+		if err != nil {
+			if err != context.DeadlineExceeded {
+				log.Error().Err(err).Msg("failed to check leadership for the replication")
+			}
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	now := r.clock.Now()
+	result, err := r.Pull(ctx)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.LastPull = &now
+	if err != nil {
+		log.Error().Str("peer", r.cfg.Peer).Err(err).Msg("failed to replicate from the peer")
+		r.state.LastError = err.Error()
+		return
+	}
+	r.state.LastSuccess, r.state.LastError, r.state.LastResult = &now, "", &result
+	if result.Saved > 0 || result.Deleted > 0 || result.Silences > 0 {
+		log.Info().
+			Str("peer", r.cfg.Peer).
+			Int("saved", result.Saved).
+			Int("deleted", result.Deleted).
+			Int("silences", result.Silences).
+			Msg("replicated changes from the peer")
+	}
+}
+
+// Pull fetches the snapshot of the peer and applies it
+func (r *Replicator) Pull(ctx context.Context) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(r.cfg.Peer, "/")+SnapshotPath, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if r.cfg.Username != "" {
+		req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+	}
+	resp, err := r.cli.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Result{}, fmt.Errorf("the peer answered %d: %s", resp.StatusCode, strings.TrimSpace(string(bs)))
+	}
+	var snapshot Snapshot
+	err = json.NewDecoder(resp.Body).Decode(&snapshot)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if snapshot.Services == nil {
+		return Result{}, errors.New("invalid snapshot: no services")
+	}
+	return Apply(ctx, r.store, snapshot, r.prepare, r.clock.Now())
+}
+
+// State returns the outcome of the pulls
+func (r *Replicator) State() State {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
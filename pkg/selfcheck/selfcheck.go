@@ -0,0 +1,148 @@
+// Package selfcheck implements a service which the server pings through its own HTTP API.
+// The heartbeats take the same path through the server, the storage and the checker as
+// every other heartbeat, so an alert is sent if any part of that pipeline breaks.
+// Every node pings its own service, so one healthy node doesn't hide a broken one.
+package selfcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// ServiceIDPrefix is the parent of the self check services of all nodes
+const ServiceIDPrefix = "deadman-switch-selfcheck"
+
+const defaultInterval = time.Minute
+
+// ServiceID returns the ID of the self check service of a node
+func ServiceID(node string) string {
+	return path.Join(ServiceIDPrefix, node)
+}
+
+// Service returns the config of the self check service of a node
+func Service(cfg config.SelfCheckConfig, checkInterval time.Duration, node string) config.ServiceConfig {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = 3 * interval(cfg)
+		if 3*checkInterval > timeout {
+			timeout = 3 * checkInterval
+		}
+	}
+	return config.ServiceConfig{
+		ID:                    ServiceID(node),
+		Token:                 cfg.Token,
+		Timeout:               config.Duration(timeout),
+		AlertNotifications:    cfg.AlertNotifications,
+		RecoveryNotifications: cfg.RecoveryNotifications,
+	}
+}
+
+// Store is the subset of storage.Storage needed to register the self check service
+type Store interface {
+	SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error
+	GetLastHeartbeat(ctx context.Context, key string) (time.Time, error)
+	SetLastHeartbeat(ctx context.Context, key string, t time.Time) error
+}
+
+// Register saves the self check service of the node. A first heartbeat is recorded for a new service,
+// so it doesn't alert before the first self ping got through.
+func Register(ctx context.Context, store Store, cfg config.SelfCheckConfig, checkInterval time.Duration, clock clock.Clock, node string) error {
+	svc := Service(cfg, checkInterval, node)
+	err := config.ValidateServiceID(svc.ID)
+	if err != nil {
+		return err
+	}
+	err = store.SaveServiceConfig(ctx, svc)
+	if err != nil {
+		return err
+	}
+	_, err = store.GetLastHeartbeat(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		return store.SetLastHeartbeat(ctx, svc.ID, clock.Now())
+	}
+	return err
+}
+
+// Pinger periodically pings the self check service of the node
+type Pinger struct {
+	url      string
+	interval time.Duration
+	cli      *http.Client
+}
+
+func NewPinger(listenAddress string, cfg config.SelfCheckConfig, node string) (*Pinger, error) {
+	base := cfg.URL
+	if base == "" {
+		_, port, err := net.SplitHostPort(listenAddress)
+		if err != nil {
+			return nil, err
+		}
+		base = "http://" + net.JoinHostPort("localhost", port)
+	}
+	pingURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	pingURL.Path += "/ping/" + ServiceID(node)
+	if cfg.Token != "" {
+		pingURL.RawQuery = url.Values{"token": {cfg.Token}}.Encode()
+	}
+	return &Pinger{
+		url:      pingURL.String(),
+		interval: interval(cfg),
+		cli: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}, nil
+}
+
+func (p *Pinger) Backend(ctx context.Context) error {
+	// give the server a moment to start listening before the first ping
+	timer := time.NewTimer(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		err := p.ping(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("url", p.url).Msg("failed to ping self check service")
+		}
+		timer.Reset(p.interval)
+	}
+}
+
+func (p *Pinger) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func interval(cfg config.SelfCheckConfig) time.Duration {
+	if cfg.Interval > 0 {
+		return time.Duration(cfg.Interval)
+	}
+	return defaultInterval
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// handleAck acknowledges the active alarm of a service.
+// No further alerts are sent for an acknowledged alarm, the acknowledgement is cleared when the service recovers.
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	serviceID := chi.URLParam(r, "*")
+	svc, err := s.store.GetServiceConfig(r.Context(), serviceID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	activeSince, err := s.store.GetAlarmActiveSince(r.Context(), serviceID)
+	if err == storage.ErrNotFound {
+		http.Error(w, "service has no active alarm", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", serviceID).Err(err).Msg("failed to get alarm state")
+		return
+	}
+
+	var ack storage.Acknowledgement
+	if r.ContentLength != 0 {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&ack)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			log.Error().Err(err).Msg("failed to decode acknowledgement")
+			return
+		}
+	}
+	if ack.By == "" {
+		ack.By = caller(r)
+	}
+	err = s.acknowledge(r.Context(), svc, activeSince, ack)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", serviceID).Err(err).Msg("failed to save acknowledgement")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acknowledge saves the acknowledgement of the active alarm of a service, records it in the event log of the
+// service and emits an alarm.acknowledged event
+func (s *Server) acknowledge(ctx context.Context, svc config.ServiceConfig, activeSince time.Time, ack storage.Acknowledgement) error {
+	ack.Time = s.clock.Now().UTC()
+	err := s.store.SetAlarmAcknowledgement(ctx, svc.ID, ack)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("service", svc.ID).Str("by", ack.By).Msg("alarm acknowledged")
+	err = s.store.AppendServiceEvent(ctx, storage.ServiceEvent{
+		Time:    ack.Time,
+		Service: svc.ID,
+		Type:    storage.ServiceEventAcknowledged,
+		By:      ack.By,
+		Details: ack.Comment,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to record service event")
+	}
+	event, err := events.NewAlarmEvent(events.AlarmAcknowledged, ack.Time, events.Alarm{
+		Service:        svc.ID,
+		Labels:         svc.Labels,
+		ActiveSince:    activeSince,
+		AcknowledgedBy: ack.By,
+		Comment:        ack.Comment,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+	} else {
+		s.events.Emit(ctx, event)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func (s *Server) handleListActionRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.store.GetActionRuns(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list action runs")
+		return
+	}
+	// ?match=team/** restricts the list to matching service IDs
+	pattern := r.URL.Query().Get("match")
+	filtered := []storage.ActionRun{}
+	for _, run := range runs {
+		if config.MatchServiceID(pattern, run.Service) {
+			filtered = append(filtered, run)
+		}
+	}
+	s.writeList(w, r, filtered)
+}
+
+func (s *Server) handleGetActionRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.store.GetActionRun(r.Context(), chi.URLParam(r, "*"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get action run")
+		return
+	}
+	err = json.NewEncoder(w).Encode(run)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send action run")
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	auditAlarmForced  = "alarm.forced"
+	auditAlarmCleared = "alarm.cleared"
+	// maxAuditEntries limits the audit log to the latest entries
+	maxAuditEntries = 1000
+)
+
+// alarmRequest is the body of the requests which force or clear an alarm
+type alarmRequest struct {
+	// Reason is required, it goes into the audit log
+	Reason string `json:"reason"`
+	// Note is recorded next to the authenticated caller, e.g. on whose behalf the caller acted
+	Note string `json:"note"`
+	// By is the former name of Note, the entry is always recorded with the authenticated caller
+	By string `json:"by"`
+	// ResetHeartbeat counts the clearing as a heartbeat, so an overdue service doesn't alarm again on the next check
+	ResetHeartbeat bool `json:"resetHeartbeat"`
+	// Notify sends the recovery notifications of the cleared alarm
+	Notify bool `json:"notify"`
+}
+
+// handleForceAlarm raises the alarm of a service and sends its alerts, e.g. to exercise the notification chain.
+// The alarm is resolved by the next heartbeat or by clearing it.
+func (s *Server) handleForceAlarm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	svc, req, ok := s.alarmRequest(w, r)
+	if !ok {
+		return
+	}
+	_, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == nil {
+		http.Error(w, "the alarm of the service is already active", http.StatusConflict)
+		return
+	}
+	if err != storage.ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get alarm state")
+		return
+	}
+	now := s.clock.Now().UTC()
+	err = s.store.SetAlarmActiveSince(ctx, svc.ID, now)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to set alarm active state")
+		return
+	}
+	s.dedup.forget(svc.ID)
+	entry, err := s.audit(ctx, auditAlarmForced, svc.ID, req)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to record audit entry")
+	}
+	event, err := events.NewAlarmEvent(events.AlarmCreated, now, events.Alarm{
+		Service:     svc.ID,
+		Labels:      svc.Labels,
+		ActiveSince: now,
+		Comment:     req.Reason,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+	} else {
+		s.events.Emit(ctx, event)
+	}
+	err = s.notifier.SendAlerts(ctx, svc)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to send alerts")
+	}
+	s.writeJSON(w, http.StatusCreated, entry)
+}
+
+// handleClearAlarm resolves the alarm of a service without a heartbeat, e.g. a stuck one after fixing its data.
+// The recovery notifications are only sent on request.
+func (s *Server) handleClearAlarm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	svc, req, ok := s.alarmRequest(w, r)
+	if !ok {
+		return
+	}
+	activeSince, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		http.Error(w, "service has no active alarm", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get alarm state")
+		return
+	}
+	now := s.clock.Now().UTC()
+	if req.ResetHeartbeat {
+		err = s.store.SetLastHeartbeat(ctx, svc.ID, now)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to update timestamp")
+			return
+		}
+		s.dedup.forget(svc.ID)
+	}
+	err = s.store.ClearAlarm(ctx, svc.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to clear alarm timestamp")
+		return
+	}
+	err = s.store.ClearAlarmAcknowledgement(ctx, svc.ID)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to clear alarm acknowledgement")
+	}
+	entry, err := s.audit(ctx, auditAlarmCleared, svc.ID, req)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to record audit entry")
+	}
+	event, err := events.NewAlarmEvent(events.AlarmResolved, now, events.Alarm{
+		Service:     svc.ID,
+		Labels:      svc.Labels,
+		ActiveSince: activeSince,
+		ResolvedAt:  &now,
+		Comment:     req.Reason,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+	} else {
+		s.events.Emit(ctx, event)
+	}
+	if req.Notify {
+		err = s.notifier.SendRecoveryNotifications(ctx, svc)
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to send recovery notifications")
+		}
+	}
+	s.writeJSON(w, http.StatusOK, entry)
+}
+
+// alarmRequest loads the service and decodes the request, it writes the error response if it fails
+func (s *Server) alarmRequest(w http.ResponseWriter, r *http.Request) (config.ServiceConfig, alarmRequest, bool) {
+	var req alarmRequest
+	svc, err := s.store.GetServiceConfig(r.Context(), chi.URLParam(r, "*"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return svc, req, false
+	}
+	if r.ContentLength != 0 {
+		defer r.Body.Close()
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusUnprocessableEntity)
+			return svc, req, false
+		}
+	}
+	if req.Reason == "" {
+		http.Error(w, "a reason is required", http.StatusUnprocessableEntity)
+		return svc, req, false
+	}
+	if req.Note == "" {
+		req.Note = req.By
+	}
+	// only the authenticated caller can be trusted
+	req.By = caller(r)
+	return svc, req, true
+}
+
+// audit records a manual intervention and drops the oldest entries beyond maxAuditEntries
+func (s *Server) audit(ctx context.Context, action, service string, req alarmRequest) (storage.AuditEntry, error) {
+	now := s.clock.Now().UTC()
+	id, err := ids.Sortable(now)
+	if err != nil {
+		return storage.AuditEntry{}, err
+	}
+	entry := storage.AuditEntry{
+		ID:      id,
+		Time:    now,
+		By:      req.By,
+		Action:  action,
+		Service: service,
+		Reason:  req.Reason,
+		Note:    req.Note,
+	}
+	log.Info().
+		Str("service", service).
+		Str("action", action).
+		Str("by", entry.By).
+		Str("reason", entry.Reason).
+		Str("note", entry.Note).
+		Msg("audit")
+	err = s.store.SaveAuditEntry(ctx, entry)
+	if err != nil {
+		return entry, err
+	}
+	entries, err := s.store.GetAuditEntries(ctx)
+	if err != nil {
+		return entry, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	for i := 0; i < len(entries)-maxAuditEntries; i++ {
+		err = s.store.DeleteAuditEntry(ctx, entries[i].ID)
+		if err != nil && err != storage.ErrNotFound {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// handleListAudit returns the audit log, the newest entry first. ?service= restricts it to a service.
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.store.GetAuditEntries(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list audit entries")
+		return
+	}
+	if service := r.URL.Query().Get("service"); service != "" {
+		filtered := []storage.AuditEntry{}
+		for _, entry := range entries {
+			if entry.Service == service {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	s.writeList(w, r, entries)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+)
+
+// appPage lists the services and subscribes the browser to push notifications. It uses the admin API
+// with the credentials the browser asked for when it opened the page.
+const appPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>deadman-switch</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="theme-color" content="#b71c1c">
+<link rel="manifest" href="manifest.webmanifest">
+<link rel="icon" href="icon-192.png">
+<style>
+body { font-family: sans-serif; margin: 0; padding: 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .4em; border-bottom: 1px solid #ddd; }
+.alarm { color: #b71c1c; font-weight: bold; }
+.ok { color: #1b5e20; }
+.unknown { color: #757575; }
+input, button { font-size: 1em; padding: .3em; }
+#push { margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>deadman-switch</h1>
+<form id="search"><input id="q" placeholder="search, e.g. state:alarm" size="30"> <button>search</button></form>
+<table>
+<thead><tr><th>service</th><th>state</th><th>last heartbeat</th></tr></thead>
+<tbody id="services"></tbody>
+</table>
+<div id="push">
+<h2>Push notifications</h2>
+<p id="push-state"></p>
+<label>Your name <input id="user"></label>
+<button id="subscribe">Subscribe this device</button>
+<button id="unsubscribe">Unsubscribe</button>
+<button id="test">Send a test notification</button>
+</div>
+<script>
+const $ = id => document.getElementById(id);
+
+async function load() {
+  const response = await fetch('../services/?q=' + encodeURIComponent($('q').value));
+  if (!response.ok) return;
+  const rows = (await response.json()).map(status => {
+    const tr = document.createElement('tr');
+    for (const [text, cls] of [[status.service], [status.state, status.state], [status.lastHeartbeat ? new Date(status.lastHeartbeat).toLocaleString() : '-']]) {
+      const td = document.createElement('td');
+      td.textContent = text;
+      if (cls) td.className = cls;
+      tr.appendChild(td);
+    }
+    return tr;
+  });
+  $('services').replaceChildren(...rows);
+}
+$('search').onsubmit = e => { e.preventDefault(); load(); };
+load();
+setInterval(load, 15000);
+
+function urlBase64ToUint8Array(text) {
+  const raw = atob((text + '='.repeat((4 - text.length % 4) % 4)).replace(/-/g, '+').replace(/_/g, '/'));
+  return Uint8Array.from(raw, c => c.charCodeAt(0));
+}
+
+async function showState() {
+  const id = localStorage.getItem('subscription');
+  $('push-state').textContent = id ? 'This device is subscribed as ' + localStorage.getItem('user') + '.' : 'This device is not subscribed.';
+  $('user').value = localStorage.getItem('user') || '';
+}
+
+if ('serviceWorker' in navigator && 'PushManager' in window) {
+  navigator.serviceWorker.register('sw.js');
+  showState();
+  $('subscribe').onclick = async () => {
+    const user = $('user').value.trim();
+    if (!user) { alert('Enter your name first'); return; }
+    if (await Notification.requestPermission() !== 'granted') { alert('Notifications are blocked'); return; }
+    const registration = await navigator.serviceWorker.ready;
+    const key = await (await fetch('../push/key')).json();
+    const subscription = await registration.pushManager.subscribe({userVisibleOnly: true, applicationServerKey: urlBase64ToUint8Array(key.publicKey)});
+    const response = await fetch('../push/subscriptions/', {method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(Object.assign({user}, subscription.toJSON()))});
+    if (!response.ok) { alert('Failed to subscribe: ' + await response.text()); return; }
+    localStorage.setItem('subscription', (await response.json()).id);
+    localStorage.setItem('user', user);
+    showState();
+  };
+  $('unsubscribe').onclick = async () => {
+    const registration = await navigator.serviceWorker.ready;
+    const subscription = await registration.pushManager.getSubscription();
+    if (subscription) await subscription.unsubscribe();
+    const id = localStorage.getItem('subscription');
+    if (id) await fetch('../push/subscriptions/' + id, {method: 'DELETE'});
+    localStorage.removeItem('subscription');
+    showState();
+  };
+  $('test').onclick = async () => {
+    const id = localStorage.getItem('subscription');
+    if (!id) { alert('Subscribe this device first'); return; }
+    const response = await fetch('../push/subscriptions/' + id + '/test', {method: 'POST'});
+    if (!response.ok) alert('Failed to send: ' + await response.text());
+  };
+} else {
+  $('push-state').textContent = 'This browser does not support push notifications.';
+}
+</script>
+</body>
+</html>
+`
+
+const appManifest = `{
+  "name": "deadman-switch",
+  "short_name": "deadman",
+  "start_url": "./",
+  "scope": "./",
+  "display": "standalone",
+  "background_color": "#ffffff",
+  "theme_color": "#b71c1c",
+  "icons": [
+    {"src": "icon-192.png", "sizes": "192x192", "type": "image/png"},
+    {"src": "icon-512.png", "sizes": "512x512", "type": "image/png"}
+  ]
+}
+`
+
+// appServiceWorker shows the pushed notifications and opens their link on a click
+const appServiceWorker = `self.addEventListener('push', event => {
+  const message = event.data ? event.data.json() : {title: 'deadman-switch', body: ''};
+  event.waitUntil(self.registration.showNotification(message.title, {
+    body: message.body,
+    tag: message.tag,
+    renotify: !!message.tag,
+    requireInteraction: !!message.urgent,
+    icon: 'icon-192.png',
+    data: {url: message.url || './'},
+  }));
+});
+
+self.addEventListener('notificationclick', event => {
+  event.notification.close();
+  event.waitUntil(clients.openWindow(event.notification.data.url));
+});
+`
+
+func (s *Server) handleAppPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(appPage))
+}
+
+func (s *Server) handleAppManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Write([]byte(appManifest))
+}
+
+func (s *Server) handleAppServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(appServiceWorker))
+}
+
+// handleAppIcon draws the icon of the app in the requested size, a white ring on red
+func (s *Server) handleAppIcon(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.Atoi(chi.URLParam(r, "size"))
+	if err != nil || (size != 192 && size != 512) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	red, white := color.RGBA{0xb7, 0x1c, 0x1c, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}
+	center, outer, inner := size/2, size*3/10, size*2/10
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := (x-center)*(x-center) + (y-center)*(y-center)
+			if d <= outer*outer && d >= inner*inner {
+				img.Set(x, y, white)
+			} else {
+				img.Set(x, y, red)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to encode app icon")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(buf.Bytes())
+}
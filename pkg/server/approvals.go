@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/approvals"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// approvalPage asks for a confirmation, so link previews of chat tools don't approve by fetching the link
+var approvalPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Approve action plan</title></head>
+<body>
+<h1>Approve action plan {{.Plan}}</h1>
+<p>The destructive action plan <b>{{.Plan}}</b> for the service <b>{{.Service}}</b> waits for an approval until {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}.</p>
+<form method="post">
+<label>Your name <input name="name"></label>
+<button type="submit">Approve</button>
+</form>
+</body>
+</html>
+`))
+
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	approvals, err := s.store.GetApprovals(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list approvals")
+		return
+	}
+	s.writeList(w, r, approvals)
+}
+
+func (s *Server) handleGetApproval(w http.ResponseWriter, r *http.Request) {
+	approval, err := s.store.GetApproval(r.Context(), chi.URLParam(r, "approvalID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get approval")
+		return
+	}
+	err = json.NewEncoder(w).Encode(approval)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send approval")
+	}
+}
+
+func (s *Server) handleApprovalPage(w http.ResponseWriter, r *http.Request) {
+	approval, ok := s.pendingApproval(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := approvalPage.Execute(w, approval)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to render approval page")
+	}
+}
+
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	// the expiry and other approvers decide under the same lock, so only one decision is saved
+	lockCtx, unlock := context.WithCancel(r.Context())
+	defer unlock()
+	if s.concurrency != nil {
+		err := s.concurrency.Lock(lockCtx, approvals.LockKey(chi.URLParam(r, "approvalID")))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to lock approval")
+			return
+		}
+	}
+	approval, ok := s.pendingApproval(w, r)
+	if !ok {
+		return
+	}
+	now := s.clock.Now().UTC()
+	if now.After(approval.ExpiresAt) {
+		http.Error(w, approvals.ErrExpired.Error(), http.StatusGone)
+		return
+	}
+	approvedBy := s.approverAddress(r)
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		approvedBy = fmt.Sprintf("%s (%s)", name, approvedBy)
+	}
+	approval.Status = storage.ApprovalStatusApproved
+	approval.DecidedAt = &now
+	approval.ApprovedBy = approvedBy
+	err := s.store.SaveApproval(r.Context(), approval)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to save approval")
+		return
+	}
+	log.Info().Str("service", approval.Service).Str("approval", approval.ID).Str("by", approvedBy).Msg("approved action plan")
+	w.Write([]byte("the action plan is approved and will run shortly"))
+}
+
+// approverAddress returns the address of the approver, the X-Forwarded-For header only counts for requests
+// of trusted proxies
+func (s *Server) approverAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	forwarded := r.Header.Get("X-Forwarded-For")
+	ip := net.ParseIP(host)
+	if forwarded == "" || ip == nil {
+		return r.RemoteAddr
+	}
+	for _, proxy := range s.approvals.TrustedProxies {
+		_, network, err := net.ParseCIDR(proxy)
+		if err == nil && network.Contains(ip) {
+			return forwarded
+		}
+	}
+	return r.RemoteAddr
+}
+
+// pendingApproval verifies the signed link and loads the approval, it writes the error response otherwise
+func (s *Server) pendingApproval(w http.ResponseWriter, r *http.Request) (storage.Approval, bool) {
+	id := chi.URLParam(r, "approvalID")
+	err := approvals.Verify(s.approvals.Secret, id, r.URL.Query(), s.clock.Now())
+	if s.approvals.Secret == "" || err == approvals.ErrInvalidSignature {
+		log.Warn().Str("approval", id).Msg("invalid approval link")
+		http.Error(w, "invalid approval link", http.StatusForbidden)
+		return storage.Approval{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return storage.Approval{}, false
+	}
+	approval, err := s.store.GetApproval(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return approval, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get approval")
+		return approval, false
+	}
+	if approval.Status != storage.ApprovalStatusPending {
+		http.Error(w, fmt.Sprintf("the approval is already %s", approval.Status), http.StatusConflict)
+		return approval, false
+	}
+	return approval, true
+}
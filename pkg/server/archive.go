@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// handleListArchive returns the services which were archived
+func (s *Server) handleListArchive(w http.ResponseWriter, r *http.Request) {
+	services, err := s.store.GetArchivedServices(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list archived services")
+		return
+	}
+	s.writeList(w, r, services)
+}
+
+func (s *Server) handleGetArchived(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	archived, err := s.store.GetArchivedService(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get archived service")
+		return
+	}
+	err = json.NewEncoder(w).Encode(archived)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send archived service")
+	}
+}
+
+// handleRestoreArchived moves an archived service back into the checks. It gets a heartbeat,
+// so it has its timeout to ping again before it alarms.
+func (s *Server) handleRestoreArchived(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	archived, err := s.store.GetArchivedService(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get archived service")
+		return
+	}
+	if archived.Config.OneShot != nil {
+		http.Error(w, "one-shot services can't be restored, create a new one", http.StatusUnprocessableEntity)
+		return
+	}
+	_, err = s.store.GetServiceConfig(r.Context(), id)
+	if err == nil {
+		http.Error(w, "a service with this ID exists already", http.StatusConflict)
+		return
+	}
+	if err != storage.ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get service config")
+		return
+	}
+	// the current policies apply to the restored config
+	cfg, err := s.prepareServiceConfig(archived.Config)
+	if err != nil {
+		s.writeConfigError(w, err)
+		return
+	}
+	err = s.checkServiceQuota(r.Context(), cfg.ID)
+	if err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+	err = s.store.SaveServiceConfig(r.Context(), cfg)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to save restored service config")
+		return
+	}
+	err = s.store.SetLastHeartbeat(r.Context(), id, s.clock.Now())
+	if err != nil {
+		log.Error().Str("service", id).Err(err).Msg("failed to record heartbeat of restored service")
+	}
+	err = s.store.DeleteArchivedService(r.Context(), id)
+	if err != nil {
+		log.Error().Str("service", id).Err(err).Msg("failed to delete restored service from the archive")
+	}
+	log.Info().Str("service", id).Msg("restored archived service")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleDeleteArchived(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	err := s.store.DeleteArchivedService(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to delete archived service")
+		return
+	}
+	log.Info().Str("service", id).Msg("deleted archived service")
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/trusch/deadman-switch/pkg/auth"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// caller returns the name of the authenticated caller of an admin request
+func caller(r *http.Request) string {
+	identity, _ := auth.FromContext(r.Context())
+	return identity.Name
+}
+
+// serverTLSConfig asks for client certificates if a client CA is configured, they are optional for the handshake,
+// so the routes without the mtls provider stay reachable without one
+func serverTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("the client CA file contains no certificates")
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
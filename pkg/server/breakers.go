@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+// handleListBreakers returns the circuit breakers of the notification targets of this instance
+func (s *Server) handleListBreakers(w http.ResponseWriter, r *http.Request) {
+	s.writeList(w, r, s.notifier.Breakers())
+}
+
+// handleResetBreaker closes the breaker of a target, so its notifications are attempted again right away
+func (s *Server) handleResetBreaker(w http.ResponseWriter, r *http.Request) {
+	target := chi.URLParam(r, "target")
+	if !s.notifier.ResetBreaker(target) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	log.Info().Str("target", target).Msg("reset circuit breaker")
+}
+
+// writeBreakerMetrics writes the circuit breakers in the Prometheus text format
+func writeBreakerMetrics(w io.Writer, breakers []notifier.BreakerState) {
+	if len(breakers) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_notification_breaker_open Whether the circuit breaker of a notification target is open or half-open.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_notification_breaker_open gauge")
+	for _, b := range breakers {
+		fmt.Fprintf(w, "deadman_switch_notification_breaker_open{target=%q} %d\n", b.Target, boolToInt(b.State != "closed"))
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_notification_breaker_failures Consecutive failures of a notification target.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_notification_breaker_failures gauge")
+	for _, b := range breakers {
+		fmt.Fprintf(w, "deadman_switch_notification_breaker_failures{target=%q} %d\n", b.Target, b.ConsecutiveFailures)
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_notification_breaker_opened_total Times the circuit breaker of a notification target opened since the start of this instance.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_notification_breaker_opened_total counter")
+	for _, b := range breakers {
+		fmt.Fprintf(w, "deadman_switch_notification_breaker_opened_total{target=%q} %d\n", b.Target, b.Opened)
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_notification_breaker_rejected_total Notifications which weren't attempted because of an open circuit breaker.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_notification_breaker_rejected_total counter")
+	for _, b := range breakers {
+		fmt.Fprintf(w, "deadman_switch_notification_breaker_rejected_total{target=%q} %d\n", b.Target, b.Rejected)
+	}
+}
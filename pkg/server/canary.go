@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/trusch/deadman-switch/pkg/canary"
+)
+
+// handleListCanaryChannels returns the results of the last checks of the canary channels
+func (s *Server) handleListCanaryChannels(w http.ResponseWriter, r *http.Request) {
+	s.writeList(w, r, s.canary.States())
+}
+
+// handleCanaryEcho serves /canary/<name>/<nonce>, the echo of a test notification.
+// The nonce is only known to the receivers of the notification, so it doesn't need the admin credentials.
+func (s *Server) handleCanaryEcho(w http.ResponseWriter, r *http.Request) {
+	if !s.canary.Echo(chi.URLParam(r, "name"), chi.URLParam(r, "nonce")) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+}
+
+// writeCanaryMetrics writes the results of the canary checks in the Prometheus text format
+func writeCanaryMetrics(w io.Writer, states []canary.ChannelState) {
+	if len(states) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_canary_ok Whether the last test notification of a canary channel arrived.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_canary_ok gauge")
+	for _, state := range states {
+		fmt.Fprintf(w, "deadman_switch_canary_ok{channel=%q} %d\n", state.Name, boolToInt(state.OK))
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_canary_failures Failed checks of a canary channel since its last success.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_canary_failures gauge")
+	for _, state := range states {
+		fmt.Fprintf(w, "deadman_switch_canary_failures{channel=%q} %d\n", state.Name, state.Failures)
+	}
+}
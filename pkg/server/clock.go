@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+type clockState struct {
+	Now time.Time `json:"now"`
+}
+
+type clockAdvanceRequest struct {
+	// Duration moves the clock forward
+	Duration config.Duration `json:"duration"`
+	// Time moves the clock to an absolute time
+	Time *time.Time `json:"time"`
+}
+
+func (s *Server) handleGetClock(w http.ResponseWriter, r *http.Request) {
+	err := json.NewEncoder(w).Encode(clockState{Now: s.clock.Now()})
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send clock")
+	}
+}
+
+// handleAdvanceClock moves the simulated clock, it is only available if the server runs on one
+func (s *Server) handleAdvanceClock(w http.ResponseWriter, r *http.Request) {
+	simulated, ok := s.clock.(*clock.Simulated)
+	if !ok {
+		http.Error(w, "the server doesn't run on a simulated clock", http.StatusConflict)
+		return
+	}
+	var req clockAdvanceRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Error().Err(err).Msg("failed to decode clock request")
+		return
+	}
+	if req.Time != nil {
+		simulated.Set(*req.Time)
+	} else {
+		simulated.Advance(time.Duration(req.Duration))
+	}
+	s.handleGetClock(w, r)
+}
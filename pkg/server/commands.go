@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/chatops"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// maxStatusLines keeps the status answer readable for large patterns
+const maxStatusLines = 30
+
+const commandHelp = "Usage:\n" +
+	"• `status [service|pattern]` shows the state of the services, e.g. `status backups/**`\n" +
+	"• `ack <service> [comment]` acknowledges the alarm of a service\n" +
+	"• `silence <service|pattern> <duration> [comment]` silences the alerts, e.g. `silence backups 2h`\n" +
+	"• `silences` lists the active silences\n" +
+	"A service ID without wildcards also selects the services below it."
+
+// reply answers a question, announce announces a change to the room
+func reply(format string, args ...interface{}) chatops.Answer {
+	return chatops.Answer{Text: fmt.Sprintf(format, args...)}
+}
+
+func announce(format string, args ...interface{}) chatops.Answer {
+	return chatops.Answer{Text: fmt.Sprintf(format, args...), Public: true}
+}
+
+// ChatCommand runs a command of a chat user, it is the handler of the chatops bots.
+// Users who aren't operators may only query.
+func (s *Server) ChatCommand(ctx context.Context, user string, args []string, operator bool) chatops.Answer {
+	if len(args) == 0 {
+		return reply("%s", commandHelp)
+	}
+	switch args[0] {
+	case "status":
+		return s.commandStatus(ctx, args[1:])
+	case "ack":
+		if !operator {
+			return reply("Only operators may acknowledge alarms.")
+		}
+		return s.commandAck(ctx, user, args[1:])
+	case "silence":
+		if !operator {
+			return reply("Only operators may silence alarms.")
+		}
+		return s.commandSilence(ctx, user, args[1:])
+	case "silences":
+		return s.commandSilences(ctx)
+	case "help":
+		return reply("%s", commandHelp)
+	default:
+		return reply("Unknown command `%s`.\n%s", args[0], commandHelp)
+	}
+}
+
+func (s *Server) commandStatus(ctx context.Context, args []string) chatops.Answer {
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+	configs, err := s.serviceConfigs(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list service configs")
+		return reply("Failed to read the services.")
+	}
+	pattern, selected := selectServices(configs, target)
+	if len(selected) == 0 {
+		return reply("No service matches `%s`.", target)
+	}
+	heartbeats, err := s.store.GetLastHeartbeats(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load heartbeats")
+		return reply("Failed to read the heartbeats.")
+	}
+	alarms, err := s.store.GetActiveAlarms(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load alarms")
+		return reply("Failed to read the alarms.")
+	}
+	silences := s.activeSilences(ctx)
+
+	now := s.clock.Now()
+	counts := map[serviceState]int{}
+	var lines []string
+	for _, svc := range selected {
+		var lastHeartbeat, activeSince *time.Time
+		if t, ok := heartbeats[svc.ID]; ok {
+			lastHeartbeat = &t
+		}
+		if t, ok := alarms[svc.ID]; ok {
+			activeSince = &t
+		}
+		status := newServiceStatus(svc, lastHeartbeat, activeSince, now)
+		counts[status.State]++
+		line := fmt.Sprintf("%s `%s` %s", stateEmoji(status.State), svc.ID, status.State)
+		switch {
+		case activeSince != nil:
+			line += fmt.Sprintf(" since %s", ago(now, *activeSince))
+			if ack, err := s.store.GetAlarmAcknowledgement(ctx, svc.ID); err == nil {
+				line += ", acknowledged by " + ack.By
+			}
+		case lastHeartbeat != nil:
+			line += fmt.Sprintf(", last heartbeat %s", ago(now, *lastHeartbeat))
+		}
+		for _, silence := range silences {
+			if silence.Silences(svc, now) {
+				line += fmt.Sprintf(", silenced until %s", silence.ActiveUntil(now).Format(time.RFC3339))
+				break
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > maxStatusLines {
+		more := len(lines) - maxStatusLines
+		lines = append(lines[:maxStatusLines], fmt.Sprintf("… and %d more", more))
+	}
+	header := fmt.Sprintf("`%s`: %d ok, %d alarm, %d unknown", pattern, counts[serviceStateOK], counts[serviceStateAlarm], counts[serviceStateUnknown])
+	return reply("%s\n%s", header, strings.Join(lines, "\n"))
+}
+
+func (s *Server) commandAck(ctx context.Context, user string, args []string) chatops.Answer {
+	if len(args) == 0 {
+		return reply("Usage: `ack <service> [comment]`")
+	}
+	svc, err := s.store.GetServiceConfig(ctx, args[0])
+	if err != nil {
+		return reply("There is no service `%s`.", args[0])
+	}
+	activeSince, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		return reply("`%s` has no active alarm.", svc.ID)
+	}
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get alarm state")
+		return reply("Failed to read the alarm of `%s`.", svc.ID)
+	}
+	err = s.acknowledge(ctx, svc, activeSince, storage.Acknowledgement{
+		By:      user,
+		Comment: strings.Join(args[1:], " "),
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to save acknowledgement")
+		return reply("Failed to acknowledge the alarm of `%s`.", svc.ID)
+	}
+	return announce("%s acknowledged the alarm of `%s`.", user, svc.ID)
+}
+
+func (s *Server) commandSilence(ctx context.Context, user string, args []string) chatops.Answer {
+	if len(args) < 2 {
+		return reply("Usage: `silence <service|pattern> <duration> [comment]`")
+	}
+	duration, err := time.ParseDuration(args[1])
+	if err != nil || duration <= 0 {
+		return reply("`%s` is no valid duration, use e.g. `30m` or `2h`.", args[1])
+	}
+	configs, err := s.serviceConfigs(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list service configs")
+		return reply("Failed to read the services.")
+	}
+	pattern, selected := selectServices(configs, args[0])
+	if len(selected) == 0 {
+		return reply("No service matches `%s`.", args[0])
+	}
+	now := s.clock.Now()
+	silence, err := s.createSilence(ctx, storage.Silence{
+		Services:  config.Selector{Match: pattern},
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: user,
+		Comment:   strings.Join(args[2:], " "),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save silence")
+		return reply("Failed to save the silence.")
+	}
+	services := "1 service"
+	if len(selected) != 1 {
+		services = fmt.Sprintf("%d services", len(selected))
+	}
+	return announce("%s silenced `%s` (%s) until %s.", user, pattern, services, silence.EndsAt.Format(time.RFC3339))
+}
+
+func (s *Server) commandSilences(ctx context.Context) chatops.Answer {
+	silences := s.activeSilences(ctx)
+	if len(silences) == 0 {
+		return reply("There are no active silences.")
+	}
+	now := s.clock.Now()
+	lines := make([]string, len(silences))
+	for i, silence := range silences {
+		lines[i] = fmt.Sprintf("`%s` until %s by %s", silence.Services.Match, silence.ActiveUntil(now).Format(time.RFC3339), silence.CreatedBy)
+		if silence.Comment != "" {
+			lines[i] += ": " + silence.Comment
+		}
+	}
+	return reply("%s", strings.Join(lines, "\n"))
+}
+
+// activeSilences returns the silences which are active now ordered by their end
+func (s *Server) activeSilences(ctx context.Context) []storage.Silence {
+	silences, err := s.store.GetSilences(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load silences")
+		return nil
+	}
+	now := s.clock.Now()
+	var active []storage.Silence
+	for _, silence := range silences {
+		if silence.Active(now) {
+			active = append(active, silence)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ActiveUntil(now).Before(active[j].ActiveUntil(now)) })
+	return active
+}
+
+// selectServices returns the services matching the target ordered by ID. A target without wildcards which
+// isn't a service itself selects the services below it, the returned pattern is the one which was used.
+func selectServices(configs []config.ServiceConfig, target string) (string, []config.ServiceConfig) {
+	pattern := target
+	selected := matchingServices(configs, pattern)
+	if len(selected) == 0 && target != "" && !strings.ContainsAny(target, "*?[") {
+		pattern = strings.TrimSuffix(target, "/") + "/**"
+		selected = matchingServices(configs, pattern)
+	}
+	if pattern == "" {
+		pattern = "**"
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].ID < selected[j].ID })
+	return pattern, selected
+}
+
+func matchingServices(configs []config.ServiceConfig, pattern string) []config.ServiceConfig {
+	var selected []config.ServiceConfig
+	for _, svc := range configs {
+		if config.MatchServiceID(pattern, svc.ID) {
+			selected = append(selected, svc)
+		}
+	}
+	return selected
+}
+
+func stateEmoji(state serviceState) string {
+	switch state {
+	case serviceStateOK:
+		return "🟢"
+	case serviceStateAlarm:
+		return "🔴"
+	default:
+		return "⚪"
+	}
+}
+
+// ago formats the time since t in whole seconds
+func ago(now, t time.Time) string {
+	return now.Sub(t).Truncate(time.Second).String() + " ago"
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func (s *Server) handleListContacts(w http.ResponseWriter, r *http.Request) {
+	contacts, err := s.store.GetContacts(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list contacts")
+		return
+	}
+	s.writeList(w, r, contacts)
+}
+
+func (s *Server) handleGetContact(w http.ResponseWriter, r *http.Request) {
+	contact, err := s.store.GetContact(r.Context(), chi.URLParam(r, "contactID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load contact")
+		return
+	}
+	err = json.NewEncoder(w).Encode(contact)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send contact")
+	}
+}
+
+// handleSaveContact creates or replaces a contact
+func (s *Server) handleSaveContact(w http.ResponseWriter, r *http.Request) {
+	var contact config.Contact
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&contact)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Error().Err(err).Msg("failed to decode contact")
+		return
+	}
+	err = contact.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	err = s.store.SaveContact(r.Context(), contact)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to save contact")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleDeleteContact(w http.ResponseWriter, r *http.Request) {
+	err := s.store.DeleteContact(r.Context(), chi.URLParam(r, "contactID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to delete contact")
+	}
+}
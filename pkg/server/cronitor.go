@@ -0,0 +1,82 @@
+package server
+
+// Emulation of the Cronitor telemetry API, so jobs monitored by Cronitor can be moved over
+// by changing the host of their telemetry URL. The monitor key is the service ID.
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *Server) handleCronitorPing(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(chi.URLParam(r, "apiKey")), []byte(s.cronitor.APIKey)) != 1 {
+		log.Warn().Msg("invalid cronitor api key")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	serviceID := chi.URLParam(r, "*")
+	svcConfig, err := s.store.GetServiceConfig(r.Context(), serviceID)
+	if err != nil {
+		log.Error().Str("service", serviceID).Err(err).Msg("failed to load service config")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("nice to meet you stranger"))
+		return
+	}
+	if !authorizePing(w, r, svcConfig) {
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	// the series pairs the run and complete pings of one execution
+	rid := query.Get("series")
+	log.Info().
+		Str("service", serviceID).
+		Str("state", state).
+		Str("series", rid).
+		Str("message", query.Get("message")).
+		Str("status_code", query.Get("status_code")).
+		Str("env", query.Get("env")).
+		Str("host", query.Get("host")).
+		Interface("metrics", parseCronitorMetrics(serviceID, query["metric"])).
+		Msg("received cronitor telemetry")
+
+	now := s.clock.Now()
+	switch state {
+	case "run":
+		s.startRun(svcConfig, rid, now)
+		w.Write([]byte("OK"))
+	case "", "complete", "ok":
+		s.acceptHeartbeat(w, r, svcConfig, "", now, rid)
+	case "fail":
+		s.finishRun(serviceID, rid, now)
+		s.failService(r.Context(), svcConfig, now)
+		w.Write([]byte("OK"))
+	default:
+		http.Error(w, "unknown state "+strconv.Quote(state), http.StatusBadRequest)
+	}
+}
+
+// parseCronitorMetrics parses metric parameters like count:10 or duration:1.5
+func parseCronitorMetrics(service string, params []string) map[string]float64 {
+	metrics := make(map[string]float64)
+	for _, param := range params {
+		idx := strings.Index(param, ":")
+		if idx <= 0 {
+			log.Warn().Str("service", service).Str("metric", param).Msg("invalid cronitor metric")
+			continue
+		}
+		value, err := strconv.ParseFloat(param[idx+1:], 64)
+		if err != nil {
+			log.Warn().Str("service", service).Str("metric", param).Msg("invalid cronitor metric")
+			continue
+		}
+		metrics[param[:idx]] = value
+	}
+	return metrics
+}
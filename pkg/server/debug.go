@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+)
+
+// handleDebugState returns the state of all sections of this instance
+func (s *Server) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.dumper.Dump(r.Context()))
+}
+
+// handleDebugSection returns the state of one section like checker or queue
+func (s *Server) handleDebugSection(w http.ResponseWriter, r *http.Request) {
+	state, ok, err := s.dumper.Section(r.Context(), chi.URLParam(r, "section"))
+	if !ok {
+		http.Error(w, "unknown section, known are "+strings.Join(s.dumper.Sections(), ", "), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("section", chi.URLParam(r, "section")).Msg("failed to dump state")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, state)
+}
+
+// debugState reports the state the server keeps in memory: the runs in progress and the open heartbeat sockets
+func (s *Server) debugState(ctx context.Context) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	runs := make(map[string]time.Time, len(s.runStarts))
+	for id, start := range s.runStarts {
+		runs[id] = start
+	}
+	sockets := make(map[string]int, len(s.sockets))
+	for id, count := range s.sockets {
+		sockets[id] = count
+	}
+	return map[string]interface{}{
+		"runsInProgress": runs,
+		"sockets":        sockets,
+		// heartbeats which were not stored because of the minimum heartbeat interval
+		"deduplicatedHeartbeats": s.dedup.dropped(),
+	}, nil
+}
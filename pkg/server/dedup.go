@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// dedupFlushInterval is how often the latest dropped heartbeats are checked for being stored
+const dedupFlushInterval = time.Second
+
+// heartbeatDedup drops the heartbeats of a service which arrive within its minimum interval after the last stored
+// one, so agents pinging in tight loops don't write to the storage on every ping. The latest dropped heartbeat is
+// stored once the interval passed without another stored one, so it isn't lost when the pings stop.
+type heartbeatDedup struct {
+	mutex   sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	interval time.Duration
+	stored   time.Time
+	// pending is the latest dropped heartbeat, zero if there is none
+	pending time.Time
+	dropped uint64
+}
+
+func newHeartbeatDedup() *heartbeatDedup {
+	return &heartbeatDedup{entries: make(map[string]*dedupEntry)}
+}
+
+// drop returns whether the heartbeat arrived within the interval after the last stored one, otherwise the caller
+// stores it
+func (d *heartbeatDedup) drop(service string, interval time.Duration, now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entry, ok := d.entries[service]
+	if !ok {
+		entry = &dedupEntry{}
+		d.entries[service] = entry
+	}
+	entry.interval = interval
+	if !entry.stored.IsZero() && !now.Before(entry.stored) && now.Sub(entry.stored) < interval {
+		entry.pending = now
+		entry.dropped++
+		return true
+	}
+	entry.stored, entry.pending = now, time.Time{}
+	return false
+}
+
+// forget makes the next heartbeat of the service count in full, e.g. to resolve an alarm which was just raised
+func (d *heartbeatDedup) forget(service string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if entry, ok := d.entries[service]; ok {
+		entry.stored, entry.pending = time.Time{}, time.Time{}
+	}
+}
+
+// flush stores the latest dropped heartbeats of the services which stopped pinging. The heartbeats are taken
+// under the lock and written after releasing it, so pings don't wait for the storage.
+func (d *heartbeatDedup) flush(ctx context.Context, store storage.Storage, now time.Time) {
+	d.mutex.Lock()
+	due := make(map[string]time.Time)
+	for service, entry := range d.entries {
+		if entry.pending.IsZero() || now.Sub(entry.stored) < entry.interval {
+			continue
+		}
+		due[service] = entry.pending
+		entry.pending = time.Time{}
+	}
+	d.mutex.Unlock()
+	for service, pending := range due {
+		err := store.SetLastHeartbeat(ctx, service, pending)
+		d.mutex.Lock()
+		entry := d.entries[service]
+		if err != nil {
+			log.Error().Str("service", service).Err(err).Msg("failed to store deduplicated heartbeat")
+			// retry with the next flush unless a later heartbeat came in meanwhile
+			if entry.pending.IsZero() && entry.stored.Before(pending) {
+				entry.pending = pending
+			}
+			d.mutex.Unlock()
+			continue
+		}
+		// a heartbeat stored meanwhile may have been overwritten by the older one
+		stored := entry.stored
+		d.mutex.Unlock()
+		if stored.After(pending) {
+			err = store.SetLastHeartbeat(ctx, service, stored)
+			if err != nil {
+				log.Error().Str("service", service).Err(err).Msg("failed to restore the last heartbeat")
+			}
+		}
+	}
+}
+
+// dropped returns the number of dropped heartbeats per service
+func (d *heartbeatDedup) dropped() map[string]uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	dropped := make(map[string]uint64, len(d.entries))
+	for service, entry := range d.entries {
+		if entry.dropped > 0 {
+			dropped[service] = entry.dropped
+		}
+	}
+	return dropped
+}
+
+func (s *Server) flushDeduplicatedHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(dedupFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.dedup.flush(ctx, s.store, s.clock.Now())
+		}
+	}
+}
+
+// writeDedupMetrics writes the counters of the dropped heartbeats in the Prometheus text format
+func writeDedupMetrics(w io.Writer, dropped map[string]uint64) {
+	if len(dropped) == 0 {
+		return
+	}
+	services := make([]string, 0, len(dropped))
+	for service := range dropped {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	fmt.Fprintln(w, "# HELP deadman_switch_deduplicated_heartbeats_total Heartbeats which arrived within the minimum heartbeat interval and were not stored.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_deduplicated_heartbeats_total counter")
+	for _, service := range services {
+		fmt.Fprintf(w, "deadman_switch_deduplicated_heartbeats_total{service=%q} %d\n", service, dropped[service])
+	}
+}
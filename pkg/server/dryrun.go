@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/usage"
+)
+
+// configDiff is the difference between the stored and the desired service configs
+type configDiff struct {
+	Create    []string       `json:"create"`
+	Update    []configUpdate `json:"update"`
+	Delete    []string       `json:"delete"`
+	Unchanged int            `json:"unchanged"`
+	Errors    []configError  `json:"errors,omitempty"`
+	Applied   bool           `json:"applied"`
+}
+
+type configUpdate struct {
+	Service string `json:"service"`
+	// Fields are the top level fields of the config which change
+	Fields []string `json:"fields"`
+}
+
+type configError struct {
+	Service string `json:"service"`
+	Error   string `json:"error"`
+	// Fields are the invalid fields, if the error is about single fields
+	Fields config.FieldErrors `json:"fields,omitempty"`
+}
+
+// handleConfigDryRun compares the posted, complete set of service configs with the stored ones.
+// ?match=team/** restricts the set to matching service IDs, all other services stay untouched.
+// With ?apply=true the changes are applied in one step, if all configs are valid.
+func (s *Server) handleConfigDryRun(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("match")
+	apply := r.URL.Query().Get("apply") == "true"
+	var desired []config.ServiceConfig
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&desired)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// the desired configs are compared with the stored ones, without the per-prefix defaults
+	store := storage.Unwrap(s.store)
+	stored, err := readServiceConfigs(r.Context(), store)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	diff, save := diffServiceConfigs(stored, desired, pattern, s.prepareServiceConfig)
+	if s.meter != nil && len(diff.Errors) == 0 {
+		err = s.meter.CheckServices(r.Context(), diff.Create, diff.Delete)
+		var quotaErr *usage.QuotaError
+		if errors.As(err, &quotaErr) {
+			diff.Errors = append(diff.Errors, configError{Service: quotaErr.Service, Error: err.Error()})
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to check the service quotas")
+			return
+		}
+	}
+	if len(diff.Errors) > 0 {
+		s.writeJSON(w, http.StatusUnprocessableEntity, diff)
+		return
+	}
+	if apply && len(save)+len(diff.Delete) > 0 {
+		err = store.ApplyServiceConfigs(r.Context(), save, diff.Delete)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to apply service configs")
+			return
+		}
+		diff.Applied = true
+		log.Info().
+			Int("created", len(diff.Create)).
+			Int("updated", len(diff.Update)).
+			Int("deleted", len(diff.Delete)).
+			Msg("applied service configs")
+	}
+	s.writeJSON(w, http.StatusOK, diff)
+}
+
+// diffServiceConfigs returns the diff and the configs which need to be saved to get from stored to desired,
+// desired configs which can't be prepared for saving are reported as errors
+func diffServiceConfigs(stored, desired []config.ServiceConfig, pattern string, prepare func(config.ServiceConfig) (config.ServiceConfig, error)) (configDiff, []config.ServiceConfig) {
+	diff := configDiff{
+		Create: []string{},
+		Update: []configUpdate{},
+		Delete: []string{},
+	}
+	current := make(map[string]config.ServiceConfig)
+	for _, svc := range stored {
+		if config.MatchServiceID(pattern, svc.ID) {
+			current[svc.ID] = svc
+		}
+	}
+	var save []config.ServiceConfig
+	seen := make(map[string]bool)
+	for _, svc := range desired {
+		if seen[svc.ID] {
+			diff.Errors = append(diff.Errors, configError{Service: svc.ID, Error: "duplicate service id"})
+			continue
+		}
+		seen[svc.ID] = true
+		svc, err := prepare(svc)
+		if err == nil && !config.MatchServiceID(pattern, svc.ID) {
+			err = fmt.Errorf("service id doesn't match %q", pattern)
+		}
+		if err != nil {
+			fields, _ := err.(config.FieldErrors)
+			diff.Errors = append(diff.Errors, configError{Service: svc.ID, Error: err.Error(), Fields: fields})
+			continue
+		}
+		old, ok := current[svc.ID]
+		if !ok {
+			diff.Create = append(diff.Create, svc.ID)
+			save = append(save, svc)
+			continue
+		}
+		fields := changedFields(old, svc)
+		if len(fields) == 0 {
+			diff.Unchanged++
+			continue
+		}
+		diff.Update = append(diff.Update, configUpdate{svc.ID, fields})
+		save = append(save, svc)
+	}
+	for id := range current {
+		if !seen[id] {
+			diff.Delete = append(diff.Delete, id)
+		}
+	}
+	sort.Strings(diff.Create)
+	sort.Strings(diff.Delete)
+	sort.Slice(diff.Update, func(i, j int) bool {
+		return diff.Update[i].Service < diff.Update[j].Service
+	})
+	return diff, save
+}
+
+// changedFields compares the JSON representation of two configs field by field
+func changedFields(a, b config.ServiceConfig) []string {
+	fieldsA, fieldsB := jsonFields(a), jsonFields(b)
+	var changed []string
+	for name, value := range fieldsA {
+		if !bytes.Equal(value, fieldsB[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func jsonFields(svc config.ServiceConfig) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	bs, err := json.Marshal(svc)
+	if err == nil {
+		err = json.Unmarshal(bs, &fields)
+	}
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to compare service config")
+	}
+	return fields
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// notModified sets the ETag of the response and answers 304 Not Modified
+// if the client sent it in If-None-Match, so polling clients skip unchanged responses.
+func notModified(w http.ResponseWriter, r *http.Request, version string) bool {
+	etag := strconv.Quote(version)
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag writes v with an ETag derived from its encoding, for responses without a storage version
+func (s *Server) writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed encode response")
+		return
+	}
+	hash := fnv.New64a()
+	hash.Write(buf.Bytes())
+	if notModified(w, r, strconv.FormatUint(hash.Sum64(), 36)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		log.Error().Err(err).Msg("failed send response")
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/trusch/deadman-switch/pkg/forward"
+)
+
+// maxForwardPayload limits the payload of a heartbeat which is forwarded
+const maxForwardPayload = 1 << 20
+
+// readForwardPayload reads the body of a heartbeat which is forwarded and replaces it, so it can be read again
+func readForwardPayload(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, maxForwardPayload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxForwardPayload {
+		return nil, fmt.Errorf("the payload is larger than %d bytes", maxForwardPayload)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(payload))
+	return payload, nil
+}
+
+// writeForwardMetrics writes the counters of the forwarded heartbeats in the Prometheus text format
+func writeForwardMetrics(w io.Writer, stats []forward.Stats) {
+	if len(stats) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_forwarded_heartbeats_total Heartbeats which were forwarded, failed to be forwarded or were dropped because the forward queue was full.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_forwarded_heartbeats_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "deadman_switch_forwarded_heartbeats_total{service=%q,result=\"forwarded\"} %d\n", s.Service, s.Forwarded)
+		fmt.Fprintf(w, "deadman_switch_forwarded_heartbeats_total{service=%q,result=\"failed\"} %d\n", s.Service, s.Failed)
+		fmt.Fprintf(w, "deadman_switch_forwarded_heartbeats_total{service=%q,result=\"dropped\"} %d\n", s.Service, s.Dropped)
+	}
+}
@@ -0,0 +1,506 @@
+package server
+
+// Emulation of the Healthchecks.io ping and management API, so existing clients and
+// integrations work against deadman-switch. Checks are services, the check UUID is the service ID.
+// The management API only reaches the services it created, which carry the healthchecks.io/managed label.
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultCheckTimeout = 24 * time.Hour
+	defaultCheckGrace   = time.Hour
+	maxLogBody          = 10000
+)
+
+// splitHealthchecksSignal splits a ping path like <uuid>/start into the check and the signal
+func splitHealthchecksSignal(id string) (string, string, bool) {
+	idx := strings.LastIndex(id, "/")
+	if idx <= 0 {
+		return "", "", false
+	}
+	service, signal := id[:idx], id[idx+1:]
+	switch signal {
+	case "start", "fail", "log":
+		return service, signal, true
+	}
+	code, err := strconv.Atoi(signal)
+	if err != nil || code < 0 || code > 255 {
+		return "", "", false
+	}
+	return service, signal, true
+}
+
+func (s *Server) handleHealthchecksSignal(w http.ResponseWriter, r *http.Request, svc config.ServiceConfig, signal string, now time.Time) {
+	rid := r.URL.Query().Get("rid")
+	switch signal {
+	case "start":
+		log.Info().Str("service", svc.ID).Str("rid", rid).Msg("received start signal")
+		s.startRun(svc, rid, now)
+	case "log":
+		body, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxLogBody))
+		log.Info().Str("service", svc.ID).Str("rid", rid).Str("body", string(body)).Msg("received log signal")
+	default:
+		reason := "fail signal"
+		if signal != "fail" {
+			reason = "exit status " + signal
+		}
+		log.Info().Str("service", svc.ID).Str("rid", rid).Str("reason", reason).Msg("received failure signal")
+		s.finishRun(svc.ID, rid, now)
+		s.failService(r.Context(), svc, now)
+	}
+	w.Write([]byte("OK"))
+}
+
+func runKey(service, rid string) string {
+	return service + "\n" + rid
+}
+
+// startRun remembers the start of a run to log its duration when it finishes
+func (s *Server) startRun(svc config.ServiceConfig, rid string, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// forget runs which never finished
+	for key, started := range s.runStarts {
+		if strings.HasPrefix(key, runKey(svc.ID, "")) && now.Sub(started) > time.Duration(svc.Timeout) {
+			delete(s.runStarts, key)
+		}
+	}
+	s.runStarts[runKey(svc.ID, rid)] = now
+}
+
+// finishRun logs the duration of the run if it was started with a start signal
+func (s *Server) finishRun(service, rid string, now time.Time) {
+	s.mutex.Lock()
+	started, ok := s.runStarts[runKey(service, rid)]
+	delete(s.runStarts, runKey(service, rid))
+	s.mutex.Unlock()
+	if ok {
+		log.Info().Str("service", service).Str("rid", rid).Dur("duration", now.Sub(started)).Msg("run finished")
+	}
+}
+
+// failService raises the alarm of a service which reported a failure itself.
+// The alarm is resolved by the next successful heartbeat.
+func (s *Server) failService(ctx context.Context, svc config.ServiceConfig, now time.Time) {
+	s.dedup.forget(svc.ID)
+	_, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == nil {
+		log.Info().Str("service", svc.ID).Msg("alarm is already active")
+		return
+	}
+	if err != storage.ErrNotFound {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get alarm state")
+		return
+	}
+	err = s.store.SetAlarmActiveSince(ctx, svc.ID, now)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to set alarm active state")
+		return
+	}
+	event, err := events.NewAlarmEvent(events.AlarmCreated, now, events.Alarm{
+		Service:     svc.ID,
+		Labels:      svc.Labels,
+		ActiveSince: now,
+	})
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+	} else {
+		s.events.Emit(ctx, event)
+	}
+	err = s.notifier.SendAlerts(ctx, svc)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to send alerts")
+	}
+}
+
+// check is the Healthchecks.io representation of a service
+type check struct {
+	UUID      string     `json:"uuid"`
+	Name      string     `json:"name"`
+	Slug      string     `json:"slug"`
+	Tags      string     `json:"tags"`
+	Desc      string     `json:"desc"`
+	Timeout   int64      `json:"timeout"`
+	Grace     int64      `json:"grace"`
+	Status    string     `json:"status"`
+	Started   bool       `json:"started"`
+	LastPing  *time.Time `json:"last_ping"`
+	NextPing  *time.Time `json:"next_ping"`
+	PingURL   string     `json:"ping_url"`
+	UpdateURL string     `json:"update_url"`
+}
+
+// checkRequest is the body of the create and update requests, unset fields are kept
+type checkRequest struct {
+	Name     *string  `json:"name"`
+	Tags     *string  `json:"tags"`
+	Desc     *string  `json:"desc"`
+	Timeout  *int64   `json:"timeout"`
+	Grace    *int64   `json:"grace"`
+	Schedule *string  `json:"schedule"`
+	Unique   []string `json:"unique"`
+}
+
+// healthchecksRoutes serves the management API. Its key only reaches the checks created through it,
+// the services of the config file and the other APIs don't exist for it.
+func (s *Server) healthchecksRoutes(r chi.Router) {
+	r.Use(s.healthchecksAuth)
+	r.Get("/", s.handleListChecks)
+	r.Post("/", s.handleCreateCheck)
+	r.Get("/{checkID}", s.handleGetCheck)
+	r.Post("/{checkID}", s.handleUpdateCheck)
+	r.Delete("/{checkID}", s.handleDeleteCheck)
+}
+
+// managedCheck reports whether the service was created through the Healthchecks.io API
+func managedCheck(svc config.ServiceConfig) bool {
+	return svc.Labels[config.LabelHealthchecksManaged] == "true"
+}
+
+func (s *Server) healthchecksAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		if key == "" {
+			writeHealthchecksError(w, "missing api key", http.StatusUnauthorized)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.healthchecks.APIKey)) != 1 {
+			writeHealthchecksError(w, "wrong api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeHealthchecksError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func (s *Server) handleListChecks(w http.ResponseWriter, r *http.Request) {
+	configs, err := s.serviceConfigs(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	// ?tag=prod restricts the list to checks with all given tags
+	tags := r.URL.Query()["tag"]
+	checks := []check{}
+	for _, svc := range configs {
+		if !managedCheck(svc) || !hasTags(svc.Labels[config.LabelHealthchecksTags], tags) {
+			continue
+		}
+		checks = append(checks, s.toCheck(r.Context(), svc))
+	}
+	s.writeJSON(w, http.StatusOK, map[string][]check{"checks": checks})
+}
+
+func (s *Server) handleGetCheck(w http.ResponseWriter, r *http.Request) {
+	svc, ok := s.loadCheck(w, r)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.toCheck(r.Context(), svc))
+}
+
+func (s *Server) handleCreateCheck(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	if !decodeCheckRequest(w, r, &req) {
+		return
+	}
+	if len(req.Unique) > 0 {
+		existing, ok, err := s.findUniqueCheck(r.Context(), req)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to list service configs")
+			return
+		}
+		if ok {
+			s.saveCheck(w, r, existing, req, http.StatusOK)
+			return
+		}
+	}
+	svc := config.ServiceConfig{
+		ID:      uuid.New().String(),
+		Timeout: config.Duration(defaultCheckTimeout + defaultCheckGrace),
+		Labels: map[string]string{
+			config.LabelHealthchecksGrace:   strconv.FormatInt(int64(defaultCheckGrace/time.Second), 10),
+			config.LabelHealthchecksManaged: "true",
+		},
+	}
+	s.saveCheck(w, r, svc, req, http.StatusCreated)
+}
+
+func (s *Server) handleUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	svc, ok := s.loadCheck(w, r)
+	if !ok {
+		return
+	}
+	var req checkRequest
+	if !decodeCheckRequest(w, r, &req) {
+		return
+	}
+	s.saveCheck(w, r, svc, req, http.StatusOK)
+}
+
+func (s *Server) handleDeleteCheck(w http.ResponseWriter, r *http.Request) {
+	svc, ok := s.loadCheck(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.DeleteServiceConfig(r.Context(), svc.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to delete service config")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.toCheck(r.Context(), svc))
+}
+
+func (s *Server) loadCheck(w http.ResponseWriter, r *http.Request) (config.ServiceConfig, bool) {
+	svc, err := s.store.GetServiceConfig(r.Context(), chi.URLParam(r, "checkID"))
+	if err == storage.ErrNotFound || (err == nil && !managedCheck(svc)) {
+		writeHealthchecksError(w, "not found", http.StatusNotFound)
+		return svc, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load service config")
+		return svc, false
+	}
+	return svc, true
+}
+
+func decodeCheckRequest(w http.ResponseWriter, r *http.Request, req *checkRequest) bool {
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil && err != io.EOF {
+		writeHealthchecksError(w, "could not parse request body", http.StatusBadRequest)
+		return false
+	}
+	if req.Schedule != nil {
+		writeHealthchecksError(w, "cron schedules are not supported", http.StatusBadRequest)
+		return false
+	}
+	if (req.Timeout != nil && *req.Timeout < 60) || (req.Grace != nil && *req.Grace < 60) {
+		writeHealthchecksError(w, "timeout and grace must be at least 60 seconds", http.StatusBadRequest)
+		return false
+	}
+	for _, field := range req.Unique {
+		if field != "name" && field != "tags" && field != "timeout" && field != "grace" {
+			writeHealthchecksError(w, fmt.Sprintf("unique field %q is not supported", field), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// findUniqueCheck returns the existing check which matches the request in all unique fields
+func (s *Server) findUniqueCheck(ctx context.Context, req checkRequest) (config.ServiceConfig, bool, error) {
+	configs, err := s.serviceConfigs(ctx)
+	if err != nil {
+		return config.ServiceConfig{}, false, err
+	}
+	for _, svc := range configs {
+		if !managedCheck(svc) {
+			continue
+		}
+		c := s.toCheck(ctx, svc)
+		matches := true
+		for _, field := range req.Unique {
+			switch field {
+			case "name":
+				matches = matches && req.Name != nil && *req.Name == c.Name
+			case "tags":
+				matches = matches && req.Tags != nil && *req.Tags == c.Tags
+			case "timeout":
+				matches = matches && req.Timeout != nil && *req.Timeout == c.Timeout
+			case "grace":
+				matches = matches && req.Grace != nil && *req.Grace == c.Grace
+			}
+		}
+		if matches {
+			return svc, true, nil
+		}
+	}
+	return config.ServiceConfig{}, false, nil
+}
+
+// saveCheck applies the request to the service and saves it
+func (s *Server) saveCheck(w http.ResponseWriter, r *http.Request, svc config.ServiceConfig, req checkRequest, status int) {
+	labels := make(map[string]string)
+	for k, v := range svc.Labels {
+		labels[k] = v
+	}
+	grace, timeout := checkTimeouts(svc)
+	if req.Grace != nil {
+		grace = *req.Grace
+	}
+	if req.Timeout != nil {
+		timeout = *req.Timeout
+	}
+	labels[config.LabelHealthchecksGrace] = strconv.FormatInt(grace, 10)
+	svc.Timeout = config.Duration(time.Duration(timeout+grace) * time.Second)
+	for label, value := range map[string]*string{
+		config.LabelHealthchecksName: req.Name,
+		config.LabelHealthchecksTags: req.Tags,
+		config.LabelHealthchecksDesc: req.Desc,
+	} {
+		if value != nil {
+			labels[label] = *value
+		}
+	}
+	svc.Labels = labels
+	svc, err := s.prepareServiceConfig(svc)
+	if err != nil {
+		writeHealthchecksError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status == http.StatusCreated && s.meter != nil {
+		err = s.meter.CheckServices(r.Context(), []string{svc.ID}, nil)
+		if err != nil {
+			writeHealthchecksError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	err = s.store.SaveServiceConfig(r.Context(), svc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to save service config")
+		return
+	}
+	s.writeJSON(w, status, s.toCheck(r.Context(), svc))
+}
+
+// checkTimeouts returns the grace and timeout of the check in seconds, the timeout of the service is their sum
+func checkTimeouts(svc config.ServiceConfig) (grace, timeout int64) {
+	total := int64(time.Duration(svc.Timeout) / time.Second)
+	grace, err := strconv.ParseInt(svc.Labels[config.LabelHealthchecksGrace], 10, 64)
+	if err != nil || grace > total {
+		grace = 0
+	}
+	return grace, total - grace
+}
+
+func (s *Server) toCheck(ctx context.Context, svc config.ServiceConfig) check {
+	grace, timeout := checkTimeouts(svc)
+	name := svc.Labels[config.LabelHealthchecksName]
+	if name == "" {
+		name = svc.ID
+	}
+	base := strings.TrimSuffix(s.healthchecks.URL, "/")
+	c := check{
+		UUID:      svc.ID,
+		Name:      name,
+		Slug:      slugify(name),
+		Tags:      svc.Labels[config.LabelHealthchecksTags],
+		Desc:      svc.Labels[config.LabelHealthchecksDesc],
+		Timeout:   timeout,
+		Grace:     grace,
+		Status:    "new",
+		PingURL:   base + "/ping/" + svc.ID,
+		UpdateURL: base + "/api/v3/checks/" + svc.ID,
+	}
+	s.mutex.RLock()
+	for key := range s.runStarts {
+		if strings.HasPrefix(key, runKey(svc.ID, "")) {
+			c.Started = true
+		}
+	}
+	s.mutex.RUnlock()
+	lastPing, err := s.store.GetLastHeartbeat(ctx, svc.ID)
+	if err == nil {
+		nextPing := lastPing.Add(time.Duration(timeout) * time.Second)
+		c.LastPing, c.NextPing = &lastPing, &nextPing
+		c.Status = "up"
+		if s.clock.Now().After(nextPing) {
+			c.Status = "grace"
+		}
+	}
+	if _, err := s.store.GetAlarmActiveSince(ctx, svc.ID); err == nil {
+		c.Status = "down"
+	}
+	return c
+}
+
+func (s *Server) serviceConfigs(ctx context.Context) ([]config.ServiceConfig, error) {
+	return readServiceConfigs(ctx, s.store)
+}
+
+func readServiceConfigs(ctx context.Context, store storage.Storage) ([]config.ServiceConfig, error) {
+	var configs []config.ServiceConfig
+	configChan, errChan := store.GetServiceConfigs(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case cfg, ok := <-configChan:
+			if !ok {
+				return configs, nil
+			}
+			configs = append(configs, cfg)
+		case err := <-errChan:
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send response")
+	}
+}
+
+func hasTags(tags string, required []string) bool {
+	have := strings.Fields(tags)
+	for _, tag := range required {
+		found := false
+		for _, t := range have {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
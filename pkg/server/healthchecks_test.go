@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func TestHealthchecksAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := storage.NewMemoryStorage(ctx, config.ServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a service of the native API or the config file, which the key must not reach
+	err = store.SaveServiceConfig(ctx, config.ServiceConfig{ID: "backup", Timeout: config.Duration(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		store:        store,
+		clock:        clock.NewSimulated(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)),
+		healthchecks: config.HealthchecksConfig{APIKey: "hc-key"},
+		runStarts:    make(map[string]time.Time),
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v3/checks", s.healthchecksRoutes)
+	request := func(method, path, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(http.MethodPost, "/api/v3/checks/", "hc-key", `{"name": "nightly", "tags": "prod"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the check to be created, got %d: %s", w.Code, w.Body)
+	}
+	var created check
+	err = json.NewDecoder(w.Body).Decode(&created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := store.GetServiceConfig(ctx, created.UUID)
+	if err != nil || !managedCheck(svc) {
+		t.Fatalf("expected the created check to be marked, got %v and %v", svc.Labels, err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+	}{
+		{"missing key", http.MethodGet, "/api/v3/checks/", "", "", http.StatusUnauthorized},
+		{"wrong key", http.MethodGet, "/api/v3/checks/" + created.UUID, "admin", "", http.StatusUnauthorized},
+		{"get own check", http.MethodGet, "/api/v3/checks/" + created.UUID, "hc-key", "", http.StatusOK},
+		{"update own check", http.MethodPost, "/api/v3/checks/" + created.UUID, "hc-key", `{"desc": "runs at night"}`, http.StatusOK},
+		{"get other service", http.MethodGet, "/api/v3/checks/backup", "hc-key", "", http.StatusNotFound},
+		{"update other service", http.MethodPost, "/api/v3/checks/backup", "hc-key", `{"timeout": 60}`, http.StatusNotFound},
+		{"delete other service", http.MethodDelete, "/api/v3/checks/backup", "hc-key", "", http.StatusNotFound},
+		{"unique doesn't match other services", http.MethodPost, "/api/v3/checks/", "hc-key", `{"name": "backup", "unique": ["name"]}`, http.StatusCreated},
+		{"unique matches own check", http.MethodPost, "/api/v3/checks/", "hc-key", `{"name": "nightly", "unique": ["name"]}`, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := request(test.method, test.path, test.key, test.body)
+			if w.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+		})
+	}
+
+	svc, err = store.GetServiceConfig(ctx, "backup")
+	if err != nil || svc.Timeout != config.Duration(time.Hour) || len(svc.Labels) != 0 {
+		t.Fatalf("expected the other service to be untouched, got %+v and %v", svc, err)
+	}
+	w = request(http.MethodGet, "/api/v3/checks/", "hc-key", "")
+	var list map[string][]check
+	err = json.NewDecoder(w.Body).Decode(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range list["checks"] {
+		if c.UUID == "backup" {
+			t.Fatal("expected the other service not to be listed")
+		}
+	}
+	if len(list["checks"]) != 2 {
+		t.Fatalf("expected the two created checks, got %+v", list["checks"])
+	}
+	w = request(http.MethodDelete, "/api/v3/checks/"+created.UUID, "hc-key", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the own check to be deleted, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+func (s *Server) handleListIncidents(w http.ResponseWriter, r *http.Request) {
+	// ?status=open restricts the list to incidents with the given status
+	status := r.URL.Query().Get("status")
+	get := s.store.GetIncidents
+	if status == string(storage.IncidentStatusOpen) || status == string(storage.IncidentStatusAcknowledged) {
+		get = s.store.GetOpenIncidents
+	}
+	incidents, err := get(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list incidents")
+		return
+	}
+	if status != "" {
+		filtered := []storage.Incident{}
+		for _, incident := range incidents {
+			if string(incident.Status) == status {
+				filtered = append(filtered, incident)
+			}
+		}
+		incidents = filtered
+	}
+	s.writeList(w, r, incidents)
+}
+
+func (s *Server) handleGetIncident(w http.ResponseWriter, r *http.Request) {
+	incident, err := s.store.GetIncident(r.Context(), chi.URLParam(r, "incidentID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get incident")
+		return
+	}
+	err = json.NewEncoder(w).Encode(incident)
+	if err != nil {
+		log.Error().Err(err).Msg("failed encode and send incident")
+	}
+}
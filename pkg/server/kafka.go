@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/kafka"
+)
+
+const (
+	kafkaPollWait       = time.Second
+	kafkaCommitInterval = 5 * time.Second
+	kafkaRetryDelay     = 5 * time.Second
+)
+
+// ConsumeKafkaHeartbeats accepts the messages of the topic as heartbeats until ctx is done. Only the leader
+// consumes, the offsets are committed for the group, so the next leader continues where the last one stopped.
+func (s *Server) ConsumeKafkaHeartbeats(ctx context.Context, cfg config.KafkaHeartbeatsConfig) {
+	opts := kafka.Options{
+		Brokers:  cfg.Brokers,
+		ClientID: "deadman-switch",
+		Username: cfg.Username,
+		Password: cfg.Password,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{}
+	}
+	group := cfg.Group
+	if group == "" {
+		group = config.DefaultKafkaHeartbeatsGroup
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = config.DefaultKafkaHeartbeatsMaxAge
+	}
+	newConsumer := func() *kafka.Consumer {
+		return kafka.NewConsumer(opts, cfg.Topic, group, cfg.StartFrom == "earliest")
+	}
+	consumer := newConsumer()
+	defer func() {
+		consumer.Close()
+	}()
+	lastCommit := time.Now()
+	commit := func() {
+		lastCommit = time.Now()
+		err := consumer.Commit(ctx)
+		if err != nil {
+			log.Error().Str("topic", cfg.Topic).Err(err).Msg("failed to commit kafka offsets")
+		}
+	}
+	wait := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(kafkaRetryDelay):
+		}
+	}
+	consuming := false
+	for ctx.Err() == nil {
+		if !s.isLeader(ctx) {
+			if consuming {
+				log.Info().Str("topic", cfg.Topic).Msg("lost the leadership, stop consuming kafka heartbeats")
+				commit()
+				consumer.Close()
+				// the next leader moves the offsets on
+				consumer = newConsumer()
+				consuming = false
+			}
+			wait()
+			continue
+		}
+		if !consuming {
+			log.Info().Str("topic", cfg.Topic).Str("group", group).Msg("consuming kafka heartbeats")
+			consuming = true
+		}
+		records, err := consumer.Poll(ctx, kafkaPollWait)
+		if err != nil {
+			log.Error().Str("topic", cfg.Topic).Err(err).Msg("failed to poll kafka heartbeats")
+			wait()
+			continue
+		}
+		for _, record := range records {
+			s.acceptKafkaHeartbeat(ctx, record, time.Duration(maxAge))
+		}
+		if time.Since(lastCommit) >= kafkaCommitInterval {
+			commit()
+		}
+	}
+	commit()
+}
+
+// isLeader reports whether this node leads the cluster, a single node always does
+func (s *Server) isLeader(ctx context.Context) bool {
+	if s.concurrency == nil {
+		return true
+	}
+	leader, err := s.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the leadership")
+		return false
+	}
+	return leader
+}
+
+// acceptKafkaHeartbeat handles a message like a ping of the service in its key, the headers of the message are
+// the headers of the ping. Tokens and ping credentials aren't checked, the ACLs of the topic protect it.
+func (s *Server) acceptKafkaHeartbeat(ctx context.Context, record kafka.Record, maxAge time.Duration) {
+	id := string(record.Key)
+	// the producers stamp the messages with their wall clock, not the clock of the server
+	if maxAge > 0 && record.Time.Unix() > 0 && time.Since(record.Time) > maxAge {
+		log.Warn().Str("service", id).Time("sent", record.Time).Msg("skipped outdated kafka heartbeat")
+		return
+	}
+	if id == "" {
+		log.Warn().Str("topic", record.Topic).Int64("offset", record.Offset).Msg("skipped kafka heartbeat without key")
+		return
+	}
+	target, err := s.resolvePing(ctx, id)
+	if err != nil {
+		log.Warn().Str("service", id).Err(err).Msg("skipped kafka heartbeat of an unknown service")
+		return
+	}
+	if target.state {
+		log.Warn().Str("service", id).Msg("skipped kafka heartbeat for the state of a service")
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/ping/"+id, bytes.NewReader(record.Value))
+	if err != nil {
+		log.Warn().Str("service", id).Err(err).Msg("skipped invalid kafka heartbeat")
+		return
+	}
+	req.RemoteAddr = fmt.Sprintf("kafka/%s/%d", record.Topic, record.Partition)
+	req.Header.Set("User-Agent", "kafka")
+	for key, value := range record.Headers {
+		req.Header.Set(key, string(value))
+	}
+	now := s.clock.Now()
+	response := newFrameResponse()
+	if target.signal != "" && target.signal != "0" {
+		s.handleHealthchecksSignal(response, req, target.svc, target.signal, now)
+	} else {
+		s.acceptHeartbeat(response, req, target.svc, target.replica, now, "")
+	}
+	if response.status >= 400 {
+		log.Warn().
+			Str("service", id).
+			Int("status", response.status).
+			Str("response", strings.TrimSpace(response.body.String())).
+			Msg("kafka heartbeat was rejected")
+	}
+}
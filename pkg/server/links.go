@@ -0,0 +1,128 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/links"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// servicePage is the read-only page of a service behind a short link, it shows no configs or heartbeat sources
+// because the link is shared through chat and mail
+var servicePage = template.Must(template.New("service").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Status.Service}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+<h1>{{.Status.Service}}</h1>
+<table>
+<tr><th align="left">state</th><td>{{.Status.State}}</td></tr>
+{{- with .Status.LastHeartbeat}}
+<tr><th align="left">last heartbeat</th><td>{{.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{- end}}
+{{- with .Status.AlarmActiveSince}}
+<tr><th align="left">alarm since</th><td>{{.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{- end}}
+{{- with .Ack}}
+<tr><th align="left">acknowledged</th><td>by {{.By}} at {{.Time.Format "2006-01-02 15:04:05 MST"}}{{with .Comment}}: {{.}}{{end}}</td></tr>
+{{- end}}
+{{- with .Silence}}
+<tr><th align="left">silenced</th><td>until {{(.ActiveUntil $.Now).Format "2006-01-02 15:04:05 MST"}} by {{.CreatedBy}}{{with .Comment}}: {{.}}{{end}}</td></tr>
+{{- end}}
+{{- with .Status.Replicas}}
+<tr><th align="left">replicas</th><td>{{.Alive}} of {{.Expected}} alive, {{.Min}} needed</td></tr>
+{{- end}}
+<tr><th align="left">timeout</th><td>{{.Timeout}}</td></tr>
+{{- range $key, $value := .Service.Labels}}
+<tr><th align="left">{{$key}}</th><td>{{$value}}</td></tr>
+{{- end}}
+</table>
+<p><small>Rendered at {{.Now.Format "2006-01-02 15:04:05 MST"}}, this link expires at {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}.</small></p>
+</body>
+</html>
+`))
+
+type servicePageData struct {
+	Service   config.ServiceConfig
+	Status    serviceStatus
+	Ack       *storage.Acknowledgement
+	Silence   *storage.Silence
+	Timeout   time.Duration
+	Now       time.Time
+	ExpiresAt time.Time
+}
+
+// handleLink renders the read-only page of the service behind a short link. The link ID is signed,
+// so it doesn't need the admin credentials.
+func (s *Server) handleLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "linkID")
+	now := s.clock.Now()
+	link, err := s.links.Resolve(r.Context(), id, now)
+	if err == links.ErrInvalidLink {
+		log.Warn().Str("link", id).Msg("invalid link")
+		http.Error(w, "invalid link", http.StatusNotFound)
+		return
+	}
+	if err == links.ErrExpired {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("link", id).Err(err).Msg("failed to resolve link")
+		return
+	}
+	svc, err := s.store.GetServiceConfig(r.Context(), link.Service)
+	if err == storage.ErrNotFound {
+		http.Error(w, "the service doesn't exist anymore", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", link.Service).Err(err).Msg("failed to load service config")
+		return
+	}
+	status, err := s.serviceStatus(r.Context(), svc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get service status")
+		return
+	}
+	data := servicePageData{
+		Service:   svc,
+		Status:    status,
+		Timeout:   time.Duration(svc.Timeout),
+		Now:       now,
+		ExpiresAt: link.ExpiresAt,
+	}
+	if status.AlarmActiveSince != nil {
+		if ack, err := s.store.GetAlarmAcknowledgement(r.Context(), svc.ID); err == nil {
+			data.Ack = &ack
+		}
+	}
+	silences, err := s.store.GetSilences(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load silences")
+	}
+	for i := range silences {
+		if silences[i].Silences(svc, now) {
+			data.Silence = &silences[i]
+			break
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the page is only valid for the holder of the link
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = servicePage.Execute(w, data)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to render service page")
+	}
+}
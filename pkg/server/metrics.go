@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/pushmetrics"
+)
+
+// handleMetrics exposes the metrics pushed with heartbeats, the queue, breaker and cluster metrics for Prometheus to scrape
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	pushed, err := s.store.GetServiceMetrics(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list pushed metrics")
+		return
+	}
+	// drop the metrics of deleted services
+	configs, err := s.serviceConfigs(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	exists := make(map[string]bool)
+	for _, svc := range configs {
+		exists[svc.ID] = true
+	}
+	filtered := pushed[:0]
+	for _, m := range pushed {
+		if exists[m.Service] {
+			filtered = append(filtered, m)
+		}
+	}
+	stats, err := s.queueStats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list queue items")
+		return
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	err = pushmetrics.Write(w, filtered)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write metrics")
+	}
+	writeQueueMetrics(w, stats, s.queue != nil)
+	writeBreakerMetrics(w, s.notifier.Breakers())
+	writeForwardMetrics(w, s.forwarder.Stats())
+	writeDedupMetrics(w, s.dedup.dropped())
+	if s.canary != nil {
+		writeCanaryMetrics(w, s.canary.States())
+	}
+	writeClusterMetrics(w, s.concurrency.Status())
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	// ndjsonFlushEvery is the number of lines after which a stream is flushed to the client
+	ndjsonFlushEvery = 100
+)
+
+// wantsNDJSON reports whether the client asked for newline delimited JSON with
+// ?format=ndjson or an Accept header, so it can process large lists line by line
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// ndjsonWriter writes one JSON document per line and flushes regularly,
+// so neither the server nor the client have to hold the whole list
+type ndjsonWriter struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+	lines   int
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	flusher, _ := w.(http.Flusher)
+	return &ndjsonWriter{enc: json.NewEncoder(w), flusher: flusher}
+}
+
+func (n *ndjsonWriter) Write(v interface{}) error {
+	err := n.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	n.lines++
+	if n.lines%ndjsonFlushEvery == 0 {
+		n.Flush()
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Flush() {
+	if n.flusher != nil {
+		n.flusher.Flush()
+	}
+}
+
+// writeList writes a slice as JSON array or, if the client asked for it, as NDJSON
+func (s *Server) writeList(w http.ResponseWriter, r *http.Request, list interface{}) {
+	if !wantsNDJSON(r) {
+		s.writeJSON(w, http.StatusOK, list)
+		return
+	}
+	out := newNDJSONWriter(w)
+	defer out.Flush()
+	items := reflect.ValueOf(list)
+	for i := 0; i < items.Len(); i++ {
+		err := out.Write(items.Index(i).Interface())
+		if err != nil {
+			log.Error().Err(err).Msg("failed encode and send list")
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// pingTarget is the service a ping path belongs to
+type pingTarget struct {
+	svc     config.ServiceConfig
+	replica string
+	signal  string
+	// state is set for /ping/<id>/state, which returns the state of the service instead of pinging it
+	state bool
+}
+
+type pingTargetKey struct{}
+
+// resolvePing finds the service of a ping path.
+// Replicas append their ID, Healthchecks.io clients a signal like /start or /fail to the check UUID.
+func (s *Server) resolvePing(ctx context.Context, id string) (pingTarget, error) {
+	svc, err := s.store.GetServiceConfig(ctx, id)
+	if err != storage.ErrNotFound {
+		return pingTarget{svc: svc}, err
+	}
+	if strings.HasSuffix(id, "/"+pingStateSuffix) {
+		svc, err := s.store.GetServiceConfig(ctx, strings.TrimSuffix(id, "/"+pingStateSuffix))
+		if err != storage.ErrNotFound {
+			return pingTarget{svc: svc, state: true}, err
+		}
+	}
+	if svc, replica, ok := s.splitReplica(ctx, id); ok {
+		return pingTarget{svc: svc, replica: replica}, nil
+	}
+	if id, signal, ok := splitHealthchecksSignal(id); ok {
+		svc, err = s.store.GetServiceConfig(ctx, id)
+		return pingTarget{svc: svc, signal: signal}, err
+	}
+	return pingTarget{}, err
+}
+
+// pingAuth resolves the pinged service and checks its credentials before the ping handler runs
+func (s *Server) pingAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceID := chi.URLParam(r, "*")
+		target, err := s.resolvePing(r.Context(), serviceID)
+		if err != nil {
+			log.Error().Str("service", serviceID).Err(err).Msg("failed to load service config")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("nice to meet you stranger"))
+			return
+		}
+		if !authorizePing(w, r, target.svc) {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pingTargetKey{}, target)))
+	})
+}
+
+// authorizePing validates the token and the ping credentials of the service, it writes the error response otherwise
+func authorizePing(w http.ResponseWriter, r *http.Request, svc config.ServiceConfig) bool {
+	if svc.Token != "" && !equal(r.URL.Query().Get("token"), svc.Token) {
+		log.Warn().Str("service", svc.ID).Msg("failed to validate token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("you might wish to supply a correct token for this request"))
+		return false
+	}
+	if svc.PingAuth != nil && !validPingCredentials(r, *svc.PingAuth) {
+		log.Warn().Str("service", svc.ID).Msg("failed to validate ping credentials")
+		if svc.PingAuth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="deadman-switch ping"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("you might wish to supply correct credentials for this request"))
+		return false
+	}
+	return true
+}
+
+func validPingCredentials(r *http.Request, auth config.PingAuthConfig) bool {
+	if username, password, ok := r.BasicAuth(); ok && auth.Username != "" {
+		return equal(username, auth.Username) && equal(password, auth.Password)
+	}
+	header := r.Header.Get("Authorization")
+	if auth.BearerToken != "" && strings.HasPrefix(header, "Bearer ") {
+		return equal(strings.TrimPrefix(header, "Bearer "), auth.BearerToken)
+	}
+	return false
+}
+
+// equal compares secrets in constant time
+func equal(given, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// pingResponseData is passed to the body templates of ping responses
+type pingResponseData struct {
+	ID           string
+	Now          time.Time
+	NextDeadline time.Time
+}
+
+type jsonPingResponse struct {
+	OK           bool      `json:"ok"`
+	Service      string    `json:"service"`
+	NextDeadline time.Time `json:"nextDeadline"`
+}
+
+// writePingResponse answers a successful ping as configured for the service.
+// alarmActiveSince is the start of the alarm the ping resolved, if any.
+func writePingResponse(w http.ResponseWriter, svc config.ServiceConfig, now, alarmActiveSince time.Time) {
+	data := pingResponseData{
+		ID:           svc.ID,
+		Now:          now.UTC(),
+		NextDeadline: svc.Deadline(now).UTC(),
+	}
+	cfg := config.PingResponseConfig{}
+	if svc.PingResponse != nil {
+		cfg = *svc.PingResponse
+	}
+
+	var (
+		body        []byte
+		contentType = "text/plain; charset=utf-8"
+		err         error
+	)
+	switch {
+	case cfg.Body != "":
+		body, err = renderPingResponse(cfg.Body, data)
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to render ping response")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case cfg.Format == "json":
+		contentType = "application/json"
+		body, err = json.Marshal(jsonPingResponse{OK: true, Service: svc.ID, NextDeadline: data.NextDeadline})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	default:
+		body = []byte(fmt.Sprintf("got it %s, you are still alive", svc.ID))
+	}
+	if cfg.ContentType != "" {
+		contentType = cfg.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	// smart clients can adapt their ping frequency and notice that the server considered them down
+	w.Header().Set("X-Deadman-Next-Deadline", data.NextDeadline.Format(time.RFC3339))
+	w.Header().Set("X-Deadman-Alarm-Active", strconv.FormatBool(!alarmActiveSince.IsZero()))
+	if !alarmActiveSince.IsZero() {
+		w.Header().Set("X-Deadman-Alarm-Active-Since", alarmActiveSince.UTC().Format(time.RFC3339))
+	}
+	if cfg.StatusCode != 0 {
+		w.WriteHeader(cfg.StatusCode)
+	}
+	w.Write(body)
+}
+
+func renderPingResponse(body string, data pingResponseData) ([]byte, error) {
+	tmpl, err := template.New("ping").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	return buf.Bytes(), err
+}
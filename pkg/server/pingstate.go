@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	// pingStateSuffix is appended to the ping path to read the state of the service, /ping/<id>/state
+	pingStateSuffix = "state"
+	// maxPingStateIncidents limits the incidents in the state to the latest ones
+	maxPingStateIncidents = 20
+)
+
+// pingState is what a service may learn about itself with its ping credentials,
+// so a job can find out that it was declared dead and heal itself
+type pingState struct {
+	serviceStatus
+	Acknowledgement    *storage.Acknowledgement `json:"acknowledgement,omitempty"`
+	LastNotificationAt *time.Time               `json:"lastNotificationAt,omitempty"`
+	// Incidents are the latest incidents of the service, newest first
+	Incidents []pingStateIncident `json:"incidents"`
+}
+
+// pingStateIncident is an incident with the timeline entries of the service only,
+// the other services of the incident are none of its business
+type pingStateIncident struct {
+	ID         string                          `json:"id"`
+	Status     storage.IncidentStatus          `json:"status"`
+	AlarmOpen  bool                            `json:"alarmOpen"`
+	CreatedAt  time.Time                       `json:"createdAt"`
+	ResolvedAt *time.Time                      `json:"resolvedAt,omitempty"`
+	Timeline   []storage.IncidentTimelineEntry `json:"timeline"`
+}
+
+// handlePingState returns the status, the alarm and the incidents of a service to the holders of its ping credentials
+func (s *Server) handlePingState(w http.ResponseWriter, r *http.Request, svc config.ServiceConfig) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	status, err := s.serviceStatus(ctx, svc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to get service status")
+		return
+	}
+	state := pingState{serviceStatus: status, Incidents: []pingStateIncident{}}
+	if ack, err := s.store.GetAlarmAcknowledgement(ctx, svc.ID); err == nil && status.AlarmActiveSince != nil {
+		state.Acknowledgement = &ack
+	}
+	if sent, err := s.store.GetLastMessageSendTimestamp(ctx, svc.ID); err == nil && !sent.IsZero() {
+		state.LastNotificationAt = &sent
+	}
+	incidents, err := s.store.GetIncidents(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to load incidents")
+		return
+	}
+	for _, incident := range incidents {
+		open, ok := incident.Alarms[svc.ID]
+		if !ok {
+			continue
+		}
+		timeline := []storage.IncidentTimelineEntry{}
+		for _, entry := range incident.Timeline {
+			if entry.Service == svc.ID {
+				timeline = append(timeline, entry)
+			}
+		}
+		state.Incidents = append(state.Incidents, pingStateIncident{
+			ID:         incident.ID,
+			Status:     incident.Status,
+			AlarmOpen:  open,
+			CreatedAt:  incident.CreatedAt,
+			ResolvedAt: incident.ResolvedAt,
+			Timeline:   timeline,
+		})
+	}
+	sort.Slice(state.Incidents, func(i, j int) bool {
+		return state.Incidents[i].CreatedAt.After(state.Incidents[j].CreatedAt)
+	})
+	if len(state.Incidents) > maxPingStateIncidents {
+		state.Incidents = state.Incidents[:maxPingStateIncidents]
+	}
+	s.writeJSONWithETag(w, r, state)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/webpush"
+)
+
+// pushSubscriptionRequest is the subscription of the browser, the keys are the ones of PushSubscription.toJSON()
+type pushSubscriptionRequest struct {
+	User     string `json:"user"`
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+func (s *Server) handlePushKey(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]string{"publicKey": s.webPush.PublicKey()})
+}
+
+// handleListPushSubscriptions returns the subscriptions ordered by user, ?user=<name> only returns the ones of the user
+func (s *Server) handleListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := s.store.GetPushSubscriptions(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list push subscriptions")
+		return
+	}
+	if user := r.URL.Query().Get("user"); user != "" {
+		selected := []storage.PushSubscription{}
+		for _, subscription := range subscriptions {
+			if subscription.User == user {
+				selected = append(selected, subscription)
+			}
+		}
+		subscriptions = selected
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].User != subscriptions[j].User {
+			return subscriptions[i].User < subscriptions[j].User
+		}
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	s.writeList(w, r, subscriptions)
+}
+
+// handleCreatePushSubscription stores the subscription of a browser, subscribing again replaces it
+func (s *Server) handleCreatePushSubscription(w http.ResponseWriter, r *http.Request) {
+	var req pushSubscriptionRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Error().Err(err).Msg("failed to decode push subscription")
+		return
+	}
+	if errs := validatePushSubscription(req); len(errs) > 0 {
+		s.writeConfigError(w, errs)
+		return
+	}
+	err = s.egress.CheckURL(r.Context(), req.Endpoint)
+	if err != nil {
+		s.writeConfigError(w, config.FieldErrors{{Field: "endpoint", Error: err.Error()}})
+		return
+	}
+	sum := sha256.Sum256([]byte(req.Endpoint))
+	subscription := storage.PushSubscription{
+		ID:        hex.EncodeToString(sum[:16]),
+		User:      req.User,
+		Endpoint:  req.Endpoint,
+		P256dh:    strings.TrimRight(req.Keys.P256dh, "="),
+		Auth:      strings.TrimRight(req.Keys.Auth, "="),
+		UserAgent: r.UserAgent(),
+		CreatedAt: s.clock.Now().UTC(),
+	}
+	err = s.store.SavePushSubscription(r.Context(), subscription)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to save push subscription")
+		return
+	}
+	log.Info().Str("user", subscription.User).Str("subscription", subscription.ID).Msg("created push subscription")
+	s.writeJSON(w, http.StatusCreated, subscription)
+}
+
+func validatePushSubscription(req pushSubscriptionRequest) config.FieldErrors {
+	var errs config.FieldErrors
+	if strings.TrimSpace(req.User) == "" {
+		errs = append(errs, config.FieldError{Field: "user", Error: "is required"})
+	}
+	if u, err := url.Parse(req.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs = append(errs, config.FieldError{Field: "endpoint", Error: "must be an absolute http or https URL"})
+	}
+	if key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Keys.P256dh, "=")); err != nil || len(key) != 65 {
+		errs = append(errs, config.FieldError{Field: "keys.p256dh", Error: "must be an uncompressed P-256 public key in base64url"})
+	}
+	if secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Keys.Auth, "=")); err != nil || len(secret) != 16 {
+		errs = append(errs, config.FieldError{Field: "keys.auth", Error: "must be 16 bytes in base64url"})
+	}
+	return errs
+}
+
+func (s *Server) handleDeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "subscriptionID")
+	err := s.store.DeletePushSubscription(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("subscription", id).Err(err).Msg("failed to delete push subscription")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTestPushSubscription pushes a test notification, so users can check their device right after subscribing
+func (s *Server) handleTestPushSubscription(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "subscriptionID")
+	subscription, err := s.store.GetPushSubscription(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("subscription", id).Err(err).Msg("failed to get push subscription")
+		return
+	}
+	err = s.webPush.Send(r.Context(), subscription, webpush.Notification{
+		Title: "deadman-switch",
+		Body:  "Push notifications work on this device",
+		URL:   "/app/",
+	}, 0)
+	if err == webpush.ErrGone {
+		_ = s.store.DeletePushSubscription(r.Context(), id)
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
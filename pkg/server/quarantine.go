@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// handleListQuarantine returns the stored service configs which were quarantined on start
+func (s *Server) handleListQuarantine(w http.ResponseWriter, r *http.Request) {
+	configs, err := s.store.GetQuarantinedConfigs(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list quarantined configs")
+		return
+	}
+	s.writeList(w, r, configs)
+}
+
+func (s *Server) handleDeleteQuarantined(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "*")
+	err := s.store.DeleteQuarantinedConfig(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to delete quarantined config")
+		return
+	}
+	log.Info().Str("service", id).Msg("deleted quarantined config")
+}
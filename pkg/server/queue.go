@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/queue"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const defaultQueueItemsLimit = 100
+
+type queueStats struct {
+	// Depth is the number of queued items, Delayed the number of items which are not due yet
+	Depth                int                   `json:"depth"`
+	Delayed              int                   `json:"delayed"`
+	DeadLetters          int                   `json:"deadLetters"`
+	OldestItemAgeSeconds float64               `json:"oldestItemAgeSeconds"`
+	Throughput           []notifier.Throughput `json:"throughput"`
+}
+
+func (s *Server) handleQueueStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.queueStats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list queue items")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, stats)
+}
+
+// handleListQueueItems returns the queued items without removing them, ?limit=100 caps the number of items
+func (s *Server) handleListQueueItems(w http.ResponseWriter, r *http.Request) {
+	limit := defaultQueueItemsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	items, err := s.queue.List(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list queue items")
+		return
+	}
+	if items == nil {
+		items = []queue.Item{}
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	s.writeList(w, r, items)
+}
+
+func (s *Server) handleRemoveQueueItem(w http.ResponseWriter, r *http.Request) {
+	err := s.queue.Remove(r.Context(), chi.URLParam(r, "*"))
+	if err == queue.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to remove queue item")
+		return
+	}
+	log.Info().Str("item", chi.URLParam(r, "*")).Msg("removed queue item")
+}
+
+// handlePurgeQueue removes all queued items and returns how many were removed
+func (s *Server) handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+	items, err := s.queue.List(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list queue items")
+		return
+	}
+	removed := 0
+	for _, item := range items {
+		err := s.queue.Remove(r.Context(), item.ID)
+		if err == queue.ErrNotFound {
+			// dequeued in the meantime
+			continue
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to remove queue item")
+			return
+		}
+		removed++
+	}
+	log.Info().Int("items", removed).Msg("purged queue")
+	s.writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.deadLetters(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list dead letters")
+		return
+	}
+	s.writeList(w, r, letters)
+}
+
+// handlePurgeDeadLetters deletes the dead letters, or only the ones of matching services with ?match=, and returns how many were deleted
+func (s *Server) handlePurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := s.deadLetters(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list dead letters")
+		return
+	}
+	removed := 0
+	for _, letter := range letters {
+		err := s.store.DeleteDeadLetter(r.Context(), letter.ID)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to delete dead letter")
+			return
+		}
+		removed++
+	}
+	log.Info().Int("letters", removed).Msg("purged dead letters")
+	s.writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+// deadLetters returns the dead letters, ?match=team/** restricts them to matching services
+func (s *Server) deadLetters(r *http.Request) ([]storage.DeadLetter, error) {
+	letters, err := s.store.GetDeadLetters(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	pattern := r.URL.Query().Get("match")
+	if pattern == "" {
+		return letters, nil
+	}
+	filtered := []storage.DeadLetter{}
+	for _, letter := range letters {
+		if config.MatchServiceID(pattern, letter.Service) {
+			filtered = append(filtered, letter)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *Server) handleGetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	letter, err := s.store.GetDeadLetter(r.Context(), chi.URLParam(r, "letterID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get dead letter")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, letter)
+}
+
+func (s *Server) handleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	err := s.store.DeleteDeadLetter(r.Context(), chi.URLParam(r, "letterID"))
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to delete dead letter")
+		return
+	}
+}
+
+func (s *Server) queueStats(ctx context.Context) (queueStats, error) {
+	stats := queueStats{Throughput: s.notifier.Throughput()}
+	if s.queue == nil {
+		return stats, nil
+	}
+	items, err := s.queue.List(ctx)
+	if err != nil {
+		return stats, err
+	}
+	letters, err := s.store.GetDeadLetters(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.DeadLetters = len(letters)
+	// the queue works on the wall clock, not the simulated one
+	now := time.Now()
+	stats.Depth = len(items)
+	for _, item := range items {
+		if item.DueAt.After(now) {
+			stats.Delayed++
+			continue
+		}
+		if !item.DueAt.IsZero() {
+			if age := now.Sub(item.DueAt).Seconds(); age > stats.OldestItemAgeSeconds {
+				stats.OldestItemAgeSeconds = age
+			}
+		}
+	}
+	return stats, nil
+}
+
+// writeQueueMetrics writes the queue stats in the Prometheus text format
+func writeQueueMetrics(w io.Writer, stats queueStats, withQueue bool) {
+	if withQueue {
+		fmt.Fprintln(w, "# HELP deadman_switch_queue_items Number of queued notifications.")
+		fmt.Fprintln(w, "# TYPE deadman_switch_queue_items gauge")
+		fmt.Fprintf(w, "deadman_switch_queue_items{state=\"due\"} %d\n", stats.Depth-stats.Delayed)
+		fmt.Fprintf(w, "deadman_switch_queue_items{state=\"delayed\"} %d\n", stats.Delayed)
+		fmt.Fprintln(w, "# HELP deadman_switch_queue_oldest_item_age_seconds Time the oldest due notification has been waiting.")
+		fmt.Fprintln(w, "# TYPE deadman_switch_queue_oldest_item_age_seconds gauge")
+		fmt.Fprintf(w, "deadman_switch_queue_oldest_item_age_seconds %g\n", stats.OldestItemAgeSeconds)
+		fmt.Fprintln(w, "# HELP deadman_switch_queue_dead_letters Number of notifications which were given up on.")
+		fmt.Fprintln(w, "# TYPE deadman_switch_queue_dead_letters gauge")
+		fmt.Fprintf(w, "deadman_switch_queue_dead_letters %d\n", stats.DeadLetters)
+	}
+	if len(stats.Throughput) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP deadman_switch_notifications_total Notifications by type and result since the start of this instance.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_notifications_total counter")
+	for _, t := range stats.Throughput {
+		fmt.Fprintf(w, "deadman_switch_notifications_total{type=%q,result=\"enqueued\"} %d\n", t.Type, t.Enqueued)
+		fmt.Fprintf(w, "deadman_switch_notifications_total{type=%q,result=\"sent\"} %d\n", t.Type, t.Sent)
+		fmt.Fprintf(w, "deadman_switch_notifications_total{type=%q,result=\"failed\"} %d\n", t.Type, t.Failed)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// heartbeatPrefixes are the paths of the pings, a read-only replica accepts them although they write
+var heartbeatPrefixes = []string{"/ping/", "/ws/", "/p/"}
+
+// newPrimaryProxy forwards the pings of a read-only replica to the primary
+func newPrimaryProxy(primary string) (*httputil.ReverseProxy, error) {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return nil, err
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// some deployments route by the host name
+		r.Host = u.Host
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Error().Str("primary", primary).Str("path", r.URL.Path).Err(err).Msg("failed to forward ping to the primary")
+		http.Error(w, "the primary is not reachable", http.StatusBadGateway)
+	}
+	return proxy, nil
+}
+
+// readOnlyMode lets a read-only replica serve the reads and the pings only. The pings are forwarded to the
+// primary if one is configured, otherwise they are recorded in the shared storage.
+func (s *Server) readOnlyMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range heartbeatPrefixes {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			if s.primary != nil {
+				s.primary.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			http.Error(w, "this instance is a read-only replica, send changes to the primary", http.StatusForbidden)
+		}
+	})
+}
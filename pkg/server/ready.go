@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	// healthCheckTimeout bounds every check, a probe should answer before the kubelet gives up
+	healthCheckTimeout = 2 * time.Second
+	// healthCheckKey is read to check the storage, it doesn't matter whether it exists
+	healthCheckKey = "deadman-switch/healthz"
+)
+
+// healthCheck is the outcome of a check of a backend
+type healthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthReport is the answer of /healthz and /readyz
+type healthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []healthCheck `json:"checks"`
+	// Leader and Renewals are the ones of the cluster status, see concurrency.Status, only /readyz reports them
+	Leader   *bool `json:"leader,omitempty"`
+	Renewals *int  `json:"renewals,omitempty"`
+}
+
+// handleHealth answers 503 if the storage or the notification queue fail, for liveness probes
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := s.checkHealth(r.Context())
+	s.writeHealthReport(w, report)
+}
+
+// handleReady answers 503 if the storage or the notification queue fail or while the node can't take part in
+// leader elections and locks, e.g. after it lost its etcd session
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := s.checkHealth(r.Context())
+	status := s.concurrency.Status()
+	check := healthCheck{Name: "cluster", Healthy: status.Healthy, Error: status.Error}
+	if !check.Healthy && check.Error == "" {
+		check.Error = "the node can't take part in leader elections"
+	}
+	report.Checks = append(report.Checks, check)
+	report.Healthy = report.Healthy && check.Healthy
+	report.Leader, report.Renewals = &status.Leader, &status.Renewals
+	s.writeHealthReport(w, report)
+}
+
+func (s *Server) writeHealthReport(w http.ResponseWriter, report healthReport) {
+	code := http.StatusOK
+	if !report.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, code, report)
+}
+
+// checkHealth checks that the storage answers and that the queue can be read and is consumed
+func (s *Server) checkHealth(ctx context.Context) healthReport {
+	report := healthReport{Healthy: true}
+	run := func(name string, check func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+		result := healthCheck{Name: name, Healthy: true}
+		if err := check(ctx); err != nil {
+			result.Healthy, result.Error = false, err.Error()
+			report.Healthy = false
+			log.Warn().Str("check", name).Err(err).Msg("health check failed")
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	run("storage", func(ctx context.Context) error {
+		_, err := s.store.GetLastHeartbeat(ctx, healthCheckKey)
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if s.queue != nil {
+		run("queue", func(ctx context.Context) error {
+			state, err := s.notifier.QueueState(ctx)
+			if err != nil {
+				return err
+			}
+			// the supervisor restarts a stopped consumer, it is unhealthy until then
+			if consumer := state.Consumer; !consumer.Running && consumer.StartedAt != nil {
+				if consumer.LastError != "" {
+					return fmt.Errorf("the queue consumer stopped: %s", consumer.LastError)
+				}
+				return errors.New("the queue consumer stopped")
+			}
+			return nil
+		})
+	}
+	return report
+}
+
+// writeClusterMetrics writes the leadership and session health in the Prometheus text format
+func writeClusterMetrics(w io.Writer, status concurrency.Status) {
+	fmt.Fprintln(w, "# HELP deadman_switch_leader Whether this instance is the leader which checks the deadlines.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_leader gauge")
+	fmt.Fprintf(w, "deadman_switch_leader %d\n", boolToInt(status.Leader))
+	fmt.Fprintln(w, "# HELP deadman_switch_cluster_healthy Whether this instance can take part in leader elections and locks.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_cluster_healthy gauge")
+	fmt.Fprintf(w, "deadman_switch_cluster_healthy %d\n", boolToInt(status.Healthy))
+	fmt.Fprintln(w, "# HELP deadman_switch_session_renewals_total Sessions which had to be recreated after they were lost.")
+	fmt.Fprintln(w, "# TYPE deadman_switch_session_renewals_total counter")
+	fmt.Fprintf(w, "deadman_switch_session_renewals_total %d\n", status.Renewals)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// renderRequest asks for the rendering of a notification of a service in a synthetic state
+type renderRequest struct {
+	Service      string                    `json:"service"`
+	Notification config.NotificationConfig `json:"notification"`
+	State        notifier.RenderState      `json:"state"`
+}
+
+// handleRender returns the notification as it would be sent for the service in the given state, without sending it
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	var req renderRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	svc, err := s.store.GetServiceConfig(r.Context(), req.Service)
+	if err == storage.ErrNotFound {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", req.Service).Err(err).Msg("failed to get service config")
+		return
+	}
+	notification, errs := notifier.NormalizeNotification(req.Notification)
+	if len(errs) > 0 {
+		s.writeConfigError(w, errs.Prefixed("notification"))
+		return
+	}
+	if req.State.Time.IsZero() {
+		req.State.Time = s.clock.Now()
+	}
+	rendered, err := notifier.Render(svc, notification, req.State)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, rendered)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+type replicasStatus struct {
+	Expected int             `json:"expected"`
+	Min      int             `json:"min"`
+	Alive    int             `json:"alive"`
+	Replicas []replicaStatus `json:"replicas"`
+}
+
+type replicaStatus struct {
+	Replica       string    `json:"replica"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	Alive         bool      `json:"alive"`
+}
+
+// splitReplica splits a ping path like <service>/<replica> of a service with replicas
+func (s *Server) splitReplica(ctx context.Context, id string) (config.ServiceConfig, string, bool) {
+	idx := strings.LastIndex(id, "/")
+	if idx <= 0 || idx == len(id)-1 {
+		return config.ServiceConfig{}, "", false
+	}
+	svc, err := s.store.GetServiceConfig(ctx, id[:idx])
+	if err != nil || svc.Replicas == nil {
+		return config.ServiceConfig{}, "", false
+	}
+	return svc, id[idx+1:], true
+}
+
+// recordReplicaHeartbeat records the heartbeat of a replica and returns the status of all replicas
+func (s *Server) recordReplicaHeartbeat(ctx context.Context, svc config.ServiceConfig, replica string, now time.Time) (replicasStatus, error) {
+	err := s.store.SetReplicaHeartbeat(ctx, svc.ID, replica, now)
+	if err != nil {
+		return replicasStatus{}, err
+	}
+	return s.replicasStatus(ctx, svc, now)
+}
+
+func (s *Server) replicasStatus(ctx context.Context, svc config.ServiceConfig, now time.Time) (replicasStatus, error) {
+	heartbeats, err := s.store.GetReplicaHeartbeats(ctx, svc.ID)
+	if err != nil {
+		return replicasStatus{}, err
+	}
+	status := replicasStatus{
+		Expected: svc.Replicas.Expected,
+		Min:      svc.Replicas.Minimum(),
+		Replicas: make([]replicaStatus, 0, len(heartbeats)),
+	}
+	for replica, t := range heartbeats {
+		alive := now.Sub(t) <= time.Duration(svc.Timeout)
+		if alive {
+			status.Alive++
+		}
+		status.Replicas = append(status.Replicas, replicaStatus{replica, t, alive})
+	}
+	sort.Slice(status.Replicas, func(i, j int) bool {
+		return status.Replicas[i].Replica < status.Replicas[j].Replica
+	})
+	return status, nil
+}
+
+// writeReplicaResponse answers the ping of a replica while too few replicas are alive
+func writeReplicaResponse(w http.ResponseWriter, replica string, status replicasStatus) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Deadman-Replicas-Alive", fmt.Sprintf("%d/%d", status.Alive, status.Min))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "got it %s, waiting for %d of %d replicas", replica, status.Min-status.Alive, status.Min)
+}
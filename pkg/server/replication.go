@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/replication"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// handleReplicationSnapshot serves the service configs and silences to the replicator of a peer deployment
+func (s *Server) handleReplicationSnapshot(w http.ResponseWriter, r *http.Request) {
+	// the peer gets the configs as they are stored, it applies its own defaults
+	snapshot, err := replication.Collect(r.Context(), storage.Unwrap(s.store))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to collect the replication snapshot")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, snapshot)
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// searchQuery is a parsed service search, all of its conditions must match.
+//
+// The query is a space separated list of terms:
+//
+//	team-a/             service ID starts with team-a/ (same as id:team-a/)
+//	match:team-a/**     service ID matches the pattern
+//	label:env=prod      label env is prod, label:env!=prod and label:env (label is set) work as well
+//	state:alarm,unknown service is in one of the states
+//	age:>1h             last heartbeat is more than an hour ago or missing, age:<5m for less than 5 minutes
+//	heartbeat:<2006-01-02T15:04:05Z  last heartbeat is before the time, heartbeat:> after it
+//	heartbeat:none      service never sent a heartbeat
+type searchQuery struct {
+	prefixes   []string
+	patterns   []string
+	labels     []labelCondition
+	states     [][]serviceState
+	conditions []func(lastHeartbeat *time.Time, now time.Time) bool
+}
+
+type labelCondition struct {
+	key, value string
+	// any matches every service which has the label
+	any    bool
+	negate bool
+}
+
+func (c labelCondition) matches(labels map[string]string) bool {
+	value, ok := labels[c.key]
+	if c.any {
+		return ok
+	}
+	return (ok && value == c.value) != c.negate
+}
+
+func parseSearchQuery(query string) (searchQuery, error) {
+	var q searchQuery
+	for _, term := range strings.Fields(query) {
+		i := strings.Index(term, ":")
+		if i < 0 {
+			q.prefixes = append(q.prefixes, term)
+			continue
+		}
+		key, value := term[:i], term[i+1:]
+		if value == "" {
+			return q, fmt.Errorf("missing value in %q", term)
+		}
+		switch key {
+		case "id":
+			q.prefixes = append(q.prefixes, value)
+		case "match":
+			q.patterns = append(q.patterns, value)
+		case "label":
+			q.labels = append(q.labels, parseLabelCondition(value))
+		case "state":
+			var states []serviceState
+			for _, state := range strings.Split(value, ",") {
+				switch serviceState(state) {
+				case serviceStateOK, serviceStateAlarm, serviceStateUnknown:
+					states = append(states, serviceState(state))
+				default:
+					return q, fmt.Errorf("unknown state %q", state)
+				}
+			}
+			q.states = append(q.states, states)
+		case "age":
+			condition, err := parseAgeCondition(value)
+			if err != nil {
+				return q, fmt.Errorf("invalid %q: %w", term, err)
+			}
+			q.conditions = append(q.conditions, condition)
+		case "heartbeat":
+			condition, err := parseHeartbeatCondition(value)
+			if err != nil {
+				return q, fmt.Errorf("invalid %q: %w", term, err)
+			}
+			q.conditions = append(q.conditions, condition)
+		default:
+			return q, fmt.Errorf("unknown search key %q", key)
+		}
+	}
+	return q, nil
+}
+
+func parseLabelCondition(value string) labelCondition {
+	if i := strings.Index(value, "!="); i >= 0 {
+		return labelCondition{key: value[:i], value: value[i+2:], negate: true}
+	}
+	if i := strings.Index(value, "="); i >= 0 {
+		return labelCondition{key: value[:i], value: value[i+1:]}
+	}
+	return labelCondition{key: value, any: true}
+}
+
+func parseAgeCondition(value string) (func(*time.Time, time.Time) bool, error) {
+	if len(value) < 2 || (value[0] != '<' && value[0] != '>') {
+		return nil, fmt.Errorf("use age:<duration or age:>duration")
+	}
+	age, err := time.ParseDuration(value[1:])
+	if err != nil {
+		return nil, err
+	}
+	if value[0] == '<' {
+		return func(lastHeartbeat *time.Time, now time.Time) bool {
+			return lastHeartbeat != nil && now.Sub(*lastHeartbeat) < age
+		}, nil
+	}
+	return func(lastHeartbeat *time.Time, now time.Time) bool {
+		return lastHeartbeat == nil || now.Sub(*lastHeartbeat) > age
+	}, nil
+}
+
+func parseHeartbeatCondition(value string) (func(*time.Time, time.Time) bool, error) {
+	if value == "none" {
+		return func(lastHeartbeat *time.Time, _ time.Time) bool {
+			return lastHeartbeat == nil
+		}, nil
+	}
+	if len(value) < 2 || (value[0] != '<' && value[0] != '>') {
+		return nil, fmt.Errorf("use heartbeat:none, heartbeat:<time or heartbeat:>time")
+	}
+	t, err := time.Parse(time.RFC3339, value[1:])
+	if err != nil {
+		return nil, err
+	}
+	before := value[0] == '<'
+	return func(lastHeartbeat *time.Time, _ time.Time) bool {
+		if lastHeartbeat == nil {
+			return false
+		}
+		if before {
+			return lastHeartbeat.Before(t)
+		}
+		return lastHeartbeat.After(t)
+	}, nil
+}
+
+// matchesConfig checks the conditions which only need the service config,
+// so the state is only derived for the remaining services
+func (q searchQuery) matchesConfig(svc config.ServiceConfig) bool {
+	for _, prefix := range q.prefixes {
+		if !strings.HasPrefix(svc.ID, prefix) {
+			return false
+		}
+	}
+	for _, pattern := range q.patterns {
+		if !config.MatchServiceID(pattern, svc.ID) {
+			return false
+		}
+	}
+	for _, label := range q.labels {
+		if !label.matches(svc.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q searchQuery) matchesStatus(status serviceStatus, now time.Time) bool {
+	for _, states := range q.states {
+		found := false
+		for _, state := range states {
+			found = found || status.State == state
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, condition := range q.conditions {
+		if !condition(status.LastHeartbeat, now) {
+			return false
+		}
+	}
+	return true
+}
+
+type searchResult struct {
+	serviceStatus
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// candidates returns the IDs of the services which can match the query, using the label index
+// and the active alarms. ok is false if the query doesn't narrow down the services this way.
+func (q searchQuery) candidates(ctx context.Context, store storage.Storage, alarms map[string]time.Time) (ids map[string]bool, ok bool, err error) {
+	narrow := func(found map[string]bool) {
+		if ids != nil {
+			for id := range ids {
+				if !found[id] {
+					delete(ids, id)
+				}
+			}
+			return
+		}
+		ids = found
+	}
+	for _, label := range q.labels {
+		if label.any || label.negate {
+			continue
+		}
+		labeled, err := store.GetServicesWithLabel(ctx, label.key, label.value)
+		if err != nil {
+			return nil, false, err
+		}
+		found := make(map[string]bool, len(labeled))
+		for _, id := range labeled {
+			found[id] = true
+		}
+		narrow(found)
+	}
+	for _, states := range q.states {
+		if len(states) != 1 || states[0] != serviceStateAlarm {
+			continue
+		}
+		found := make(map[string]bool, len(alarms))
+		for id := range alarms {
+			found[id] = true
+		}
+		narrow(found)
+	}
+	return ids, ids != nil, nil
+}
+
+// searchConfigs loads the configs of the candidates of the query, or of all services
+func (s *Server) searchConfigs(ctx context.Context, query searchQuery, alarms map[string]time.Time) ([]config.ServiceConfig, error) {
+	ids, ok, err := query.candidates(ctx, s.store, alarms)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.serviceConfigs(ctx)
+	}
+	configs := make([]config.ServiceConfig, 0, len(ids))
+	for id := range ids {
+		svc, err := s.store.GetServiceConfig(ctx, id)
+		if err == storage.ErrNotFound {
+			// alarms may outlive their service
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, svc)
+	}
+	return configs, nil
+}
+
+// handleSearch serves GET /services/?q=..., the services matching the query sorted by ID.
+// Like the status summary it reads all heartbeats and alarms at once, label and alarm
+// conditions only load the configs of the matching services.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := parseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	ctx := r.Context()
+	alarms, err := s.store.GetActiveAlarms(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load alarms")
+		return
+	}
+	configs, err := s.searchConfigs(ctx, query, alarms)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	heartbeats, err := s.store.GetLastHeartbeats(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load heartbeats")
+		return
+	}
+	now := s.clock.Now()
+	results := []searchResult{}
+	for _, svc := range configs {
+		if !query.matchesConfig(svc) {
+			continue
+		}
+		var lastHeartbeat, activeSince *time.Time
+		if t, ok := heartbeats[svc.ID]; ok {
+			lastHeartbeat = &t
+		}
+		if t, ok := alarms[svc.ID]; ok {
+			activeSince = &t
+		}
+		status := newServiceStatus(svc, lastHeartbeat, activeSince, now)
+		if !query.matchesStatus(status, now) {
+			continue
+		}
+		results = append(results, searchResult{status, svc.Labels})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Service < results[j].Service
+	})
+	s.writeList(w, r, results)
+}
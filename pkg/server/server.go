@@ -3,40 +3,116 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/auth"
+	"github.com/trusch/deadman-switch/pkg/canary"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
 	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/debug"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/forward"
+	"github.com/trusch/deadman-switch/pkg/hooks"
+	"github.com/trusch/deadman-switch/pkg/links"
 	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/pushmetrics"
+	"github.com/trusch/deadman-switch/pkg/queue"
+	"github.com/trusch/deadman-switch/pkg/replication"
+	"github.com/trusch/deadman-switch/pkg/slackapp"
 	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/usage"
+	"github.com/trusch/deadman-switch/pkg/webpush"
 )
 
 type Server struct {
-	listenAddress      string
-	username, password string
-	mutex              sync.RWMutex
-	lastHeartbeats     map[string]time.Time
-	cli                *http.Client
-	store              storage.Storage
-	notifier           notifier.Notifier
+	listenAddress  string
+	tls            *config.TLSConfig
+	auth           *auth.Chains
+	mutex          sync.RWMutex
+	lastHeartbeats map[string]time.Time
+	runStarts      map[string]time.Time
+	sockets        map[string]int
+	cli            *http.Client
+	store          storage.Storage
+	notifier       notifier.Notifier
+	queue          queue.Queue
+	concurrency    concurrency.Client
+	events         events.Emitter
+	clock          clock.Clock
+	inhibitRules   []config.InhibitRule
+	approvals      config.ApprovalsConfig
+	healthchecks   config.HealthchecksConfig
+	cronitor       config.CronitorConfig
+	policies       []config.PolicyConfig
+	egress         *egress.Policy
+	canary         *canary.Canary
+	forwarder      *forward.Forwarder
+	slackApp       *slackapp.App
+	links          *links.Links
+	webPush        *webpush.Pusher
+	meter          *usage.Meter
+	dumper         *debug.Dumper
+	readOnly       bool
+	primary        http.Handler
+	dedup          *heartbeatDedup
 }
 
-func New(ctx context.Context, listenAddress, username, password string, store storage.Storage, notifier notifier.Notifier) (*Server, error) {
+func New(ctx context.Context, listenAddress string, tls *config.TLSConfig, auth *auth.Chains, store storage.Storage, notifier notifier.Notifier, queue queue.Queue, concurrency concurrency.Client, events events.Emitter, clock clock.Clock, inhibitRules []config.InhibitRule, approvals config.ApprovalsConfig, healthchecks config.HealthchecksConfig, cronitor config.CronitorConfig, policies []config.PolicyConfig, egress *egress.Policy, canary *canary.Canary, slackApp *slackapp.App, links *links.Links, webPush *webpush.Pusher, meter *usage.Meter, dumper *debug.Dumper, readOnly *config.ReadOnlyConfig) (*Server, error) {
 	srv := &Server{
 		listenAddress:  listenAddress,
-		username:       username,
-		password:       password,
+		tls:            tls,
+		auth:           auth,
 		lastHeartbeats: make(map[string]time.Time),
+		runStarts:      make(map[string]time.Time),
+		sockets:        make(map[string]int),
 		cli: &http.Client{
 			Timeout: 5 * time.Second,
 		},
-		store:    store,
-		notifier: notifier,
+		store:        store,
+		notifier:     notifier,
+		queue:        queue,
+		concurrency:  concurrency,
+		events:       events,
+		clock:        clock,
+		inhibitRules: inhibitRules,
+		approvals:    approvals,
+		healthchecks: healthchecks,
+		cronitor:     cronitor,
+		policies:     policies,
+		egress:       egress,
+		canary:       canary,
+		forwarder:    forward.NewForwarder(ctx, egress),
+		slackApp:     slackApp,
+		links:        links,
+		webPush:      webPush,
+		meter:        meter,
+		dumper:       dumper,
+		dedup:        newHeartbeatDedup(),
+	}
+	go srv.flushDeduplicatedHeartbeats(ctx)
+	if dumper != nil {
+		dumper.Register("server", srv.debugState)
+	}
+	if readOnly != nil {
+		srv.readOnly = true
+		if readOnly.Primary != "" {
+			primary, err := newPrimaryProxy(readOnly.Primary)
+			if err != nil {
+				return nil, err
+			}
+			srv.primary = primary
+		}
 	}
 
 	return srv, nil
@@ -44,30 +120,202 @@ func New(ctx context.Context, listenAddress, username, password string, store st
 
 func (s *Server) Listen(ctx context.Context) (err error) {
 	router := chi.NewRouter()
-	router.HandleFunc("/ping/{serviceID}", s.handlePing)
+	if s.readOnly {
+		router.Use(s.readOnlyMode)
+	}
+	// service IDs are hierarchical, so they may contain slashes
+	router.With(s.pingAuth).HandleFunc("/ping/*", s.handlePing)
+	router.With(s.pingAuth).Get("/ws/*", s.handleHeartbeatSocket)
 	router.HandleFunc("/log", s.handleLog)
+	router.Get("/healthz", s.handleHealth)
+	router.Get("/readyz", s.handleReady)
+	if s.cronitor.APIKey != "" {
+		router.HandleFunc("/p/{apiKey}/*", s.handleCronitorPing)
+	}
+	// every route group may have its own chain of auth providers
+	adminAuth := s.auth.Middleware
+	router.With(adminAuth).Get("/metrics", s.handleMetrics)
 	router.Route("/config", func(r chi.Router) {
-		r.Use(middleware.BasicAuth("deadman-switch", map[string]string{
-			s.username: s.password,
-		}))
+		r.Use(adminAuth)
 		r.Get("/", s.handleListConfigs)
 		r.Post("/", s.handleCreateConfig)
-		r.Delete("/{serviceID}", s.handleDeleteConfig)
+		r.Post("/dry-run", s.handleConfigDryRun)
+		r.Get("/*", s.handleListConfigVersions)
+		r.Post("/*", s.handleConfigRollback)
+		r.Delete("/*", s.handleDeleteConfig)
+	})
+	router.Route("/quarantine", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListQuarantine)
+		r.Delete("/*", s.handleDeleteQuarantined)
+	})
+	router.Route("/archive", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListArchive)
+		r.Get("/*", s.handleGetArchived)
+		r.Post("/*", s.handleRestoreArchived)
+		r.Delete("/*", s.handleDeleteArchived)
 	})
+	router.Route("/contacts", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListContacts)
+		r.Post("/", s.handleSaveContact)
+		r.Get("/{contactID}", s.handleGetContact)
+		r.Delete("/{contactID}", s.handleDeleteContact)
+	})
+	router.Route("/incidents", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListIncidents)
+		r.Get("/{incidentID}", s.handleGetIncident)
+	})
+	router.Route("/services", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/*", s.handleServiceRequest)
+	})
+	router.With(adminAuth).Get(replication.SnapshotPath, s.handleReplicationSnapshot)
+	router.Route("/status", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListStatus)
+		// the summary takes precedence over a service with that ID, which is still available below /services
+		r.Get("/summary", s.handleStatusSummary)
+		r.Get("/*", s.handleStatus)
+	})
+	if s.meter != nil {
+		router.With(adminAuth).Get("/usage/", s.handleUsage)
+	}
+	if s.dumper != nil {
+		router.Route("/debug", func(r chi.Router) {
+			r.Use(adminAuth)
+			r.Get("/state", s.handleDebugState)
+			r.Get("/state/{section}", s.handleDebugSection)
+		})
+	}
+	if s.queue != nil {
+		router.Route("/queue", func(r chi.Router) {
+			r.Use(adminAuth)
+			r.Get("/", s.handleQueueStats)
+			r.Get("/items", s.handleListQueueItems)
+			r.Delete("/items", s.handlePurgeQueue)
+			r.Delete("/items/*", s.handleRemoveQueueItem)
+			r.Get("/dead-letters", s.handleListDeadLetters)
+			r.Delete("/dead-letters", s.handlePurgeDeadLetters)
+			r.Get("/dead-letters/{letterID}", s.handleGetDeadLetter)
+			r.Delete("/dead-letters/{letterID}", s.handleDeleteDeadLetter)
+		})
+	}
+	router.Route("/breakers", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListBreakers)
+		r.Delete("/{target}", s.handleResetBreaker)
+	})
+	if s.slackApp != nil {
+		router.Route("/slack", func(r chi.Router) {
+			r.Get("/install", s.handleSlackInstall)
+			r.Get("/oauth", s.handleSlackOAuth)
+			r.With(adminAuth).Get("/workspaces/", s.handleListSlackWorkspaces)
+			r.With(adminAuth).Delete("/workspaces/{teamID}", s.handleDeleteSlackWorkspace)
+			// slash commands are signed by Slack, so they don't need the admin credentials
+			if s.slackApp.Commands() {
+				r.Post("/commands", s.handleSlackCommand)
+			}
+		})
+	}
+	if s.canary != nil {
+		router.Route("/canary", func(r chi.Router) {
+			r.With(adminAuth).Get("/", s.handleListCanaryChannels)
+			r.Post("/{name}/{nonce}", s.handleCanaryEcho)
+		})
+	}
+	router.Route("/actions", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListActionRuns)
+		r.Get("/*", s.handleGetActionRun)
+	})
+	router.Route("/approvals", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListApprovals)
+		r.Get("/{approvalID}", s.handleGetApproval)
+	})
+	// approval links are signed, so they don't need the admin credentials
+	router.Get("/approve/{approvalID}", s.handleApprovalPage)
+	router.Post("/approve/{approvalID}", s.handleApprove)
+	// short links are signed as well and only show the state of a service
+	if s.links != nil {
+		router.Get("/a/{linkID}", s.handleLink)
+	}
+	if s.healthchecks.APIKey != "" {
+		for _, version := range []string{"v1", "v2", "v3"} {
+			router.Route("/api/"+version+"/checks", s.healthchecksRoutes)
+		}
+	}
+	router.With(adminAuth).Post("/simulate", s.handleSimulate)
+	router.With(adminAuth).Post("/render", s.handleRender)
+	router.Route("/clock", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleGetClock)
+		r.Post("/", s.handleAdvanceClock)
+	})
+	router.Route("/silences", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", s.handleListSilences)
+		r.Post("/", s.handleCreateSilence)
+		r.Get("/{silenceID}", s.handleGetSilence)
+		r.Delete("/{silenceID}", s.handleDeleteSilence)
+	})
+	if s.webPush != nil {
+		router.Route("/app", func(r chi.Router) {
+			r.With(adminAuth).Get("/", s.handleAppPage)
+			// the browser fetches these without asking for credentials, they contain nothing secret
+			r.Get("/manifest.webmanifest", s.handleAppManifest)
+			r.Get("/sw.js", s.handleAppServiceWorker)
+			r.Get("/icon-{size}.png", s.handleAppIcon)
+		})
+		router.Route("/push", func(r chi.Router) {
+			r.Use(adminAuth)
+			r.Get("/key", s.handlePushKey)
+			r.Get("/subscriptions/", s.handleListPushSubscriptions)
+			r.Post("/subscriptions/", s.handleCreatePushSubscription)
+			r.Delete("/subscriptions/{subscriptionID}", s.handleDeletePushSubscription)
+			r.Post("/subscriptions/{subscriptionID}/test", s.handleTestPushSubscription)
+		})
+	}
+	router.Route("/ack", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Post("/*", s.handleAck)
+	})
+	router.Route("/alarms", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Post("/*", s.handleForceAlarm)
+		r.Delete("/*", s.handleClearAlarm)
+	})
+	router.With(adminAuth).Get("/audit", s.handleListAudit)
 
 	srv := &http.Server{
 		Addr:    s.listenAddress,
 		Handler: router,
 	}
 
-	go func() {
-		err = srv.ListenAndServe()
+	listenErr := make(chan error, 1)
+	if s.tls != nil {
+		srv.TLSConfig, err = serverTLSConfig(*s.tls)
 		if err != nil {
-			log.Error().Err(err).Msg("failed to listen")
+			return err
 		}
-	}()
+		go func() {
+			listenErr <- srv.ListenAndServeTLS(s.tls.CertFile, s.tls.KeyFile)
+		}()
+	} else {
+		go func() {
+			listenErr <- srv.ListenAndServe()
+		}()
+	}
 
-	<-ctx.Done()
+	select {
+	case err = <-listenErr:
+		log.Error().Err(err).Msg("failed to listen")
+		return err
+	case <-ctx.Done():
+	}
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	shutdownErr := srv.Shutdown(shutdownCtx)
@@ -75,29 +323,125 @@ func (s *Server) Listen(ctx context.Context) (err error) {
 		log.Error().Err(shutdownErr).Msg("failed to shutdown the server")
 	}
 
-	return err
+	return nil
 }
 
+// handlePing handles the pings which passed pingAuth
 func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
-	serviceID := chi.URLParam(r, "serviceID")
-	svcConfig, err := s.store.GetServiceConfig(r.Context(), serviceID)
+	target := r.Context().Value(pingTargetKey{}).(pingTarget)
+	if target.state {
+		s.handlePingState(w, r, target.svc)
+		return
+	}
+	now := s.clock.Now()
+	if target.signal != "" && target.signal != "0" {
+		s.handleHealthchecksSignal(w, r, target.svc, target.signal, now)
+		return
+	}
+	s.acceptHeartbeat(w, r, target.svc, target.replica, now, r.URL.Query().Get("rid"))
+}
+
+// acceptHeartbeat runs the heartbeat hook, records the heartbeat and writes the ping response.
+// The heartbeat of a replica only counts for the service while enough replicas are alive.
+func (s *Server) acceptHeartbeat(w http.ResponseWriter, r *http.Request, svc config.ServiceConfig, replica string, now time.Time, rid string) {
+	if svc.Replicas != nil && replica == "" {
+		http.Error(w, fmt.Sprintf("%s expects the pings of its replicas at /ping/%s/<replica>", svc.ID, svc.ID), http.StatusUnprocessableEntity)
+		return
+	}
+	if s.meter != nil {
+		if ok, retry := s.meter.AllowPing(svc.ID, now); !ok {
+			log.Warn().Str("service", svc.ID).Msg("heartbeat rejected by the ping quota of the tenant")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Sub(now).Seconds()))))
+			http.Error(w, "the tenant exceeded its ping quota", http.StatusTooManyRequests)
+			return
+		}
+	}
+	var payload []byte
+	if svc.Forward != nil {
+		var err error
+		payload, err = readForwardPayload(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	// batch jobs may push Prometheus metrics with the heartbeat
+	format, hasMetrics := pushmetrics.Format(r.Header)
+	var metrics string
+	if hasMetrics {
+		var err error
+		metrics, err = pushmetrics.Parse(http.MaxBytesReader(w, r.Body, pushmetrics.MaxBodySize), format, svc)
+		if err != nil {
+			log.Warn().Str("service", svc.ID).Err(err).Msg("failed to parse pushed metrics")
+			http.Error(w, "invalid metrics: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	accept, err := hooks.Heartbeat(r.Context(), svc, hooks.HeartbeatInfo{
+		Time:    now,
+		Query:   withoutToken(r.URL.Query()),
+		Headers: r.Header,
+	})
 	if err != nil {
-		log.Error().Str("service", serviceID).Err(err).Msg("failed to load service config")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("nice to meet you stranger"))
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to run heartbeat hook")
+	}
+	if !accept {
+		log.Info().Str("service", svc.ID).Msg("heartbeat rejected by hook")
+		http.Error(w, "heartbeat rejected by hook", http.StatusUnprocessableEntity)
 		return
 	}
-	if svcConfig.Token != "" {
-		if r.URL.Query().Get("token") != svcConfig.Token {
-			log.Warn().Str("service", serviceID).Msg("failed to validate token")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("you might wish to supply a correct token for this request"))
+	// pings with metrics or a run ID carry more than the heartbeat, replicas are counted in the storage
+	if svc.MinHeartbeatInterval > 0 && svc.Replicas == nil && !hasMetrics && rid == "" &&
+		s.dedup.drop(svc.ID, time.Duration(svc.MinHeartbeatInterval), now) {
+		log.Debug().Str("service", svc.ID).Msg("dropped heartbeat within the minimum interval")
+		// only the storage is spared, the heartbeat is forwarded and published like the stored ones
+		if svc.Forward != nil {
+			s.forwarder.Forward(*svc.Forward, forward.NewHeartbeat(svc.ID, replica, now, withoutToken(r.URL.Query()), payload))
+		}
+		event, err := events.NewHeartbeatEvent(now, events.Heartbeat{Service: svc.ID, Labels: svc.Labels})
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+		} else {
+			s.events.Emit(r.Context(), event)
+		}
+		writePingResponse(w, svc, now, time.Time{})
+		return
+	}
+	source := heartbeatSource(r, now)
+	log.Info().Str("service", svc.ID).Str("replica", replica).Str("source", source.RemoteAddr).Msg("received heartbeat")
+	err = s.recordHeartbeatSource(r.Context(), svc.ID, source)
+	if err != nil {
+		log.Error().Str("service", svc.ID).Err(err).Msg("failed to record heartbeat source")
+	}
+	s.finishRun(svc.ID, rid, now)
+	if svc.Forward != nil {
+		s.forwarder.Forward(*svc.Forward, forward.NewHeartbeat(svc.ID, replica, now, withoutToken(r.URL.Query()), payload))
+	}
+	if hasMetrics {
+		err = s.store.SaveServiceMetrics(r.Context(), storage.ServiceMetrics{
+			Service:  svc.ID,
+			PushedAt: now,
+			Text:     metrics,
+		})
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to save pushed metrics")
+		}
+	}
+	if svc.Replicas != nil {
+		replicas, err := s.recordReplicaHeartbeat(r.Context(), svc, replica, now)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Str("service", svc.ID).Str("replica", replica).Err(err).Msg("failed to record replica heartbeat")
+			return
+		}
+		if replicas.Alive < replicas.Min {
+			log.Info().Str("service", svc.ID).Int("alive", replicas.Alive).Int("min", replicas.Min).Msg("too few replicas are alive")
+			writeReplicaResponse(w, replica, replicas)
 			return
 		}
 	}
-	log.Info().Str("service", serviceID).Msg("received heartbeat")
-	s.updateLastHeartbeat(r.Context(), svcConfig)
-	w.Write([]byte(fmt.Sprintf("got it %s, you are still alive", serviceID)))
+	alarmActiveSince := s.updateLastHeartbeat(r.Context(), svc, now)
+	writePingResponse(w, svc, now, alarmActiveSince)
 }
 
 func (s *Server) handleLog(w http.ResponseWriter, r *http.Request) {
@@ -105,7 +449,7 @@ func (s *Server) handleLog(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleDeleteConfig(w http.ResponseWriter, r *http.Request) {
-	service := chi.URLParam(r, "serviceID")
+	service := chi.URLParam(r, "*")
 	err := s.store.DeleteServiceConfig(r.Context(), service)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
@@ -114,8 +458,35 @@ func (s *Server) handleDeleteConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListConfigs(w http.ResponseWriter, r *http.Request) {
-	var configs []config.ServiceConfig
-	configChan, errChan := s.store.GetServiceConfigs(r.Context())
+	// ?match=team/** restricts the list to matching service IDs
+	pattern := r.URL.Query().Get("match")
+	// ?raw=true returns the configs as they are stored, without the per-prefix defaults
+	store := s.store
+	if r.URL.Query().Get("raw") == "true" {
+		store = storage.Unwrap(store)
+	}
+	version, err := store.GetServiceConfigsVersion(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get service configs version")
+		return
+	}
+	// ?format=ndjson streams the configs line by line instead of collecting them into one array
+	stream := wantsNDJSON(r)
+	if stream {
+		version += "-ndjson"
+	}
+	w.Header().Set("Vary", "Accept")
+	if notModified(w, r, version) {
+		return
+	}
+	configs := []config.ServiceConfig{}
+	var out *ndjsonWriter
+	if stream {
+		out = newNDJSONWriter(w)
+		defer out.Flush()
+	}
+	configChan, errChan := store.GetServiceConfigs(r.Context())
 loop:
 	for {
 		select {
@@ -125,16 +496,33 @@ loop:
 			if !ok {
 				break loop
 			}
-			configs = append(configs, cfg)
+			if !config.MatchServiceID(pattern, cfg.ID) {
+				continue
+			}
+			if !stream {
+				configs = append(configs, cfg)
+				continue
+			}
+			err = out.Write(cfg)
+			if err != nil {
+				log.Error().Err(err).Msg("failed encode and send config")
+				return
+			}
 		case err := <-errChan:
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				// once the stream started the status is sent already, the client sees a truncated stream
+				if out == nil || out.lines == 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
 				log.Error().Err(err).Msg("failed to list service configs")
 				return
 			}
 		}
 	}
-	err := json.NewEncoder(w).Encode(configs)
+	if stream {
+		return
+	}
+	err = json.NewEncoder(w).Encode(configs)
 	if err != nil {
 		log.Error().Err(err).Msg("failed encode and send configs")
 	}
@@ -149,6 +537,16 @@ func (s *Server) handleCreateConfig(w http.ResponseWriter, r *http.Request) {
 		log.Error().Err(err).Msg("failed to decode service config")
 		return
 	}
+	cfg, err = s.prepareServiceConfig(cfg)
+	if err != nil {
+		s.writeConfigError(w, err)
+		return
+	}
+	err = s.checkServiceQuota(r.Context(), cfg.ID)
+	if err != nil {
+		writeQuotaError(w, err)
+		return
+	}
 	err = s.store.SaveServiceConfig(r.Context(), cfg)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -158,20 +556,156 @@ func (s *Server) handleCreateConfig(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
-func (s *Server) updateLastHeartbeat(ctx context.Context, svc config.ServiceConfig) {
-	err := s.store.SetLastHeartbeat(ctx, svc.ID, time.Now())
+// ValidateServiceConfig checks everything about a service config which can't be fixed at runtime
+func ValidateServiceConfig(cfg config.ServiceConfig) error {
+	err := config.ValidateServiceID(cfg.ID)
+	if err != nil {
+		return err
+	}
+	err = hooks.Validate(cfg)
+	if err != nil {
+		return err
+	}
+	err = cfg.Escalation.Validate()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Escalation) > 0 && cfg.EscalationPolicy != "" {
+		return errors.New("a service has either escalation tiers or an escalation policy")
+	}
+	if cfg.PingAuth != nil {
+		err = cfg.PingAuth.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Replicas != nil {
+		err = cfg.Replicas.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.OneShot != nil {
+		err = cfg.OneShot.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Forward != nil {
+		err = cfg.Forward.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.ActiveHours != nil {
+		err = cfg.ActiveHours.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Countdown != nil {
+		err = cfg.Countdown.Validate(cfg.Timeout)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MinHeartbeatInterval < 0 {
+		return fmt.Errorf("the minimum heartbeat interval must not be negative")
+	}
+	if cfg.MinHeartbeatInterval > 0 && cfg.Timeout > 0 && cfg.MinHeartbeatInterval >= cfg.Timeout {
+		return fmt.Errorf("the minimum heartbeat interval %s must be shorter than the timeout %s", time.Duration(cfg.MinHeartbeatInterval), time.Duration(cfg.Timeout))
+	}
+	if cfg.RepeatInterval < 0 || cfg.MaxRepeats < 0 {
+		return fmt.Errorf("the repeat interval and the maximum repeats must not be negative")
+	}
+	if cfg.MaxRepeats > 0 && cfg.RepeatInterval == 0 {
+		return fmt.Errorf("the maximum repeats need a repeat interval")
+	}
+	if cfg.PingResponse != nil {
+		return cfg.PingResponse.Validate()
+	}
+	return nil
+}
+
+// updateLastHeartbeat records the heartbeat and resolves an active alarm.
+// It returns since when the alarm was active, or the zero time if there was none.
+func (s *Server) updateLastHeartbeat(ctx context.Context, svc config.ServiceConfig, now time.Time) time.Time {
+	err := s.store.SetLastHeartbeat(ctx, svc.ID, now)
 	if err != nil {
 		log.Error().Str("service", svc.ID).Err(err).Msg("failed to update timestamp")
+	} else {
+		event, err := events.NewHeartbeatEvent(now, events.Heartbeat{Service: svc.ID, Labels: svc.Labels})
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+		} else {
+			s.events.Emit(ctx, event)
+		}
+	}
+	if svc.EarlyWarning != nil {
+		err = s.recordHeartbeat(ctx, svc, now)
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to update heartbeat history")
+		}
 	}
-	_, err = s.store.GetAlarmActiveSince(ctx, svc.ID)
+	activeSince, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
 	if err == nil {
 		err = s.store.ClearAlarm(ctx, svc.ID)
 		if err != nil {
 			log.Error().Str("service", svc.ID).Err(err).Msg("failed to clear alarm timestamp")
 		}
+		err = s.store.ClearAlarmAcknowledgement(ctx, svc.ID)
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to clear alarm acknowledgement")
+		}
+		resolvedAt := s.clock.Now().UTC()
+		event, err := events.NewAlarmEvent(events.AlarmResolved, resolvedAt, events.Alarm{
+			Service:     svc.ID,
+			Labels:      svc.Labels,
+			ActiveSince: activeSince,
+			ResolvedAt:  &resolvedAt,
+		})
+		if err != nil {
+			log.Error().Str("service", svc.ID).Err(err).Msg("failed to create event")
+		} else {
+			s.events.Emit(ctx, event)
+		}
+		// alerts may have been inhibited or debounced during the whole alarm, nobody expects a recovery then
+		lastMessage, err := s.store.GetLastMessageSendTimestamp(ctx, svc.ID)
+		if err == storage.ErrNotFound || (err == nil && lastMessage.Before(activeSince)) {
+			log.Info().Str("service", svc.ID).Msg("no alerts were sent during the alarm, skip recovery notifications")
+			return activeSince
+		}
 		err = s.notifier.SendRecoveryNotifications(ctx, svc)
 		if err != nil {
 			log.Error().Str("service", svc.ID).Err(err).Msg("failed to send recovery notifications")
 		}
+		return activeSince
+	}
+	return time.Time{}
+}
+
+// recordHeartbeat adds the heartbeat to the history which is needed for the early warning
+func (s *Server) recordHeartbeat(ctx context.Context, svc config.ServiceConfig, t time.Time) error {
+	history, err := s.store.GetHeartbeatHistory(ctx, svc.ID)
+	if err == storage.ErrNotFound {
+		history = storage.HeartbeatHistory{Since: t}
+	} else if err != nil {
+		return err
+	}
+	// only keep the heartbeats which are needed to evaluate the warning
+	start := t.Add(-svc.EarlyWarning.Retention())
+	heartbeats := history.Heartbeats[:0]
+	for _, heartbeat := range history.Heartbeats {
+		if heartbeat.After(start) {
+			heartbeats = append(heartbeats, heartbeat)
+		}
 	}
+	history.Heartbeats = append(heartbeats, t)
+	return s.store.SaveHeartbeatHistory(ctx, svc.ID, history)
+}
+
+// withoutToken returns the query without the secret token, so it isn't handed to hooks
+func withoutToken(query url.Values) url.Values {
+	query.Del("token")
+	return query
 }
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultServiceEventsLimit = 100
+	maxServiceEventsLimit     = 1000
+)
+
+// handleServiceEvents returns the event log of a service, the newest event first. ?limit=100 is the size of a page,
+// the next page starts ?before=<id> of the last event and is linked in the Link header. The log of a deleted
+// service stays available.
+func (s *Server) handleServiceEvents(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	limit := defaultServiceEventsLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxServiceEventsLimit {
+			http.Error(w, fmt.Sprintf("invalid limit, expected 1 to %d", maxServiceEventsLimit), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	before := query.Get("before")
+	events, err := s.store.GetServiceEvents(r.Context(), id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get service events")
+		return
+	}
+	page := []storage.ServiceEvent{}
+	for i := len(events) - 1; i >= 0 && len(page) < limit; i-- {
+		if before == "" || events[i].ID < before {
+			page = append(page, events[i])
+		}
+	}
+	// there are older events unless the page ends with the oldest one
+	if len(page) == limit && page[len(page)-1].ID != events[0].ID {
+		next := url.Values{"before": {page[len(page)-1].ID}, "limit": {strconv.Itoa(limit)}}
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	s.writeList(w, r, page)
+}
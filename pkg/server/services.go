@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+type serviceState string
+
+const (
+	// serviceStateOK means the service sends heartbeats in time
+	serviceStateOK serviceState = "ok"
+	// serviceStateAlarm means the alarm of the service is active
+	serviceStateAlarm serviceState = "alarm"
+	// serviceStateUnknown means the service never sent a heartbeat or is overdue but not checked yet
+	serviceStateUnknown serviceState = "unknown"
+
+	defaultWaitTimeout = time.Minute
+	maxWaitTimeout     = 10 * time.Minute
+	waitPollInterval   = time.Second
+)
+
+type serviceStatus struct {
+	Service          string       `json:"service"`
+	State            serviceState `json:"state"`
+	LastHeartbeat    *time.Time   `json:"lastHeartbeat,omitempty"`
+	AlarmActiveSince *time.Time   `json:"alarmActiveSince,omitempty"`
+	// Deadline and RemainingSeconds are left out of the summary, so it only changes with the states
+	Deadline         *time.Time `json:"deadline,omitempty"`
+	RemainingSeconds *float64   `json:"remainingSeconds,omitempty"`
+	// LastSource and Replicas are only set for single services, not in the summary
+	LastSource *storage.HeartbeatSource `json:"lastSource,omitempty"`
+	Replicas   *replicasStatus          `json:"replicas,omitempty"`
+}
+
+// handleServiceRequest dispatches the requests below /services/<id>/, because service IDs may contain slashes
+func (s *Server) handleServiceRequest(w http.ResponseWriter, r *http.Request) {
+	path := chi.URLParam(r, "*")
+	switch {
+	case path == "":
+		s.handleSearch(w, r)
+	case strings.HasSuffix(path, "/wait"):
+		s.handleWait(w, r, strings.TrimSuffix(path, "/wait"))
+	case strings.HasSuffix(path, "/heartbeats"):
+		s.handleHeartbeatSources(w, r, strings.TrimSuffix(path, "/heartbeats"))
+	case strings.HasSuffix(path, "/events"):
+		s.handleServiceEvents(w, r, strings.TrimSuffix(path, "/events"))
+	default:
+		s.handleServiceStatus(w, r, path)
+	}
+}
+
+// handleServiceStatus returns the current status of a single service
+func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request, id string) {
+	svc, err := s.store.GetServiceConfig(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to load service config")
+		return
+	}
+	status, err := s.serviceStatus(r.Context(), svc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get service status")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, status)
+}
+
+// handleWait blocks until the service reaches the requested state or the timeout passed
+func (s *Server) handleWait(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	want := serviceState(query.Get("state"))
+	if want == "" {
+		want = serviceStateOK
+	}
+	if want != serviceStateOK && want != serviceStateAlarm {
+		http.Error(w, fmt.Sprintf("unknown state %q, use %q or %q", want, serviceStateOK, serviceStateAlarm), http.StatusUnprocessableEntity)
+		return
+	}
+	timeout := defaultWaitTimeout
+	if value := query.Get("timeout"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			http.Error(w, "invalid timeout", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+	svc, err := s.store.GetServiceConfig(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to load service config")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// heartbeats may be received by other servers of a cluster, so watch the storage.
+	// The polls follow the clock of the server, so a simulated clock moves the waits with its deadlines.
+	ticker := s.clock.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := s.serviceStatus(r.Context(), svc)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Str("service", id).Err(err).Msg("failed to get service status")
+			return
+		}
+		if status.State == want {
+			w.Header().Set("X-Deadman-Wait-Reached", "true")
+			s.writeJSON(w, http.StatusOK, status)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			if r.Context().Err() != nil {
+				return
+			}
+			// a timeout isn't an error of the request, clients and proxies would retry a 408
+			w.Header().Set("X-Deadman-Wait-Reached", "false")
+			s.writeJSON(w, http.StatusOK, status)
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (s *Server) serviceStatus(ctx context.Context, svc config.ServiceConfig) (serviceStatus, error) {
+	var lastHeartbeat, activeSince *time.Time
+	since, err := s.store.GetAlarmActiveSince(ctx, svc.ID)
+	if err == nil {
+		activeSince = &since
+	} else if err != storage.ErrNotFound {
+		return serviceStatus{}, err
+	}
+	heartbeat, err := s.store.GetLastHeartbeat(ctx, svc.ID)
+	if err == nil {
+		lastHeartbeat = &heartbeat
+	} else if err != storage.ErrNotFound {
+		return serviceStatus{}, err
+	}
+	now := s.clock.Now()
+	status := newServiceStatus(svc, lastHeartbeat, activeSince, now)
+	status.setDeadline(svc, now)
+	if svc.Replicas != nil {
+		replicas, err := s.replicasStatus(ctx, svc, now)
+		if err != nil {
+			return serviceStatus{}, err
+		}
+		status.Replicas = &replicas
+	}
+	sources, err := s.store.GetHeartbeatSources(ctx, svc.ID)
+	if err == nil && len(sources) > 0 {
+		status.LastSource = &sources[0]
+	} else if err != nil && err != storage.ErrNotFound {
+		return serviceStatus{}, err
+	}
+	return status, nil
+}
+
+// newServiceStatus derives the state of the service from its last heartbeat and active alarm, both may be nil
+func newServiceStatus(svc config.ServiceConfig, lastHeartbeat, activeSince *time.Time, now time.Time) serviceStatus {
+	status := serviceStatus{
+		Service:          svc.ID,
+		State:            serviceStateUnknown,
+		LastHeartbeat:    lastHeartbeat,
+		AlarmActiveSince: activeSince,
+	}
+	switch {
+	case activeSince != nil:
+		status.State = serviceStateAlarm
+	case lastHeartbeat != nil && !now.After(svc.Deadline(*lastHeartbeat)):
+		status.State = serviceStateOK
+	}
+	return status
+}
+
+// setDeadline adds the time the next heartbeat is due and the seconds until then, which are negative once it passed.
+// A one-shot service is due at its deadline until its heartbeat arrived, it has none afterwards.
+func (status *serviceStatus) setDeadline(svc config.ServiceConfig, now time.Time) {
+	var deadline time.Time
+	switch {
+	case svc.OneShot != nil:
+		if status.LastHeartbeat != nil || svc.OneShot.Deadline.IsZero() {
+			return
+		}
+		deadline = svc.OneShot.Deadline
+	case status.LastHeartbeat != nil:
+		deadline = svc.Deadline(*status.LastHeartbeat)
+	default:
+		return
+	}
+	remaining := math.Round(deadline.Sub(now).Seconds()*1000) / 1000
+	status.Deadline = &deadline
+	status.RemainingSeconds = &remaining
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/events"
+	"github.com/trusch/deadman-switch/pkg/ids"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// silenceRequest is a silence to create, it ends at endsAt or after duration
+type silenceRequest struct {
+	storage.Silence
+	Duration config.Duration `json:"duration"`
+}
+
+// handleListSilences returns the silences ordered by their start, ?active=true only returns the active ones
+func (s *Server) handleListSilences(w http.ResponseWriter, r *http.Request) {
+	silences, err := s.store.GetSilences(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list silences")
+		return
+	}
+	if r.URL.Query().Get("active") == "true" {
+		now := s.clock.Now()
+		active := []storage.Silence{}
+		for _, silence := range silences {
+			if silence.Active(now) {
+				active = append(active, silence)
+			}
+		}
+		silences = active
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].StartsAt.Before(silences[j].StartsAt) })
+	s.writeList(w, r, silences)
+}
+
+func (s *Server) handleGetSilence(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "silenceID")
+	silence, err := s.store.GetSilence(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("silence", id).Err(err).Msg("failed to get silence")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, silence)
+}
+
+func (s *Server) handleCreateSilence(w http.ResponseWriter, r *http.Request) {
+	var req silenceRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		log.Error().Err(err).Msg("failed to decode silence")
+		return
+	}
+	silence := req.Silence
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = s.clock.Now()
+	}
+	if silence.EndsAt.IsZero() && req.Duration > 0 {
+		silence.EndsAt = silence.StartsAt.Add(time.Duration(req.Duration))
+	}
+	if silence.CreatedBy == "" {
+		silence.CreatedBy = caller(r)
+	}
+	err = validateSilence(silence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	silence, err = s.createSilence(r.Context(), silence)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to save silence")
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, silence)
+}
+
+// handleDeleteSilence expires a silence right away. It is kept until it is pruned, so the list still shows it.
+func (s *Server) handleDeleteSilence(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "silenceID")
+	silence, err := s.store.GetSilence(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("silence", id).Err(err).Msg("failed to get silence")
+		return
+	}
+	now := s.clock.Now().UTC()
+	if !silence.Ended(now) {
+		silence.EndsAt = now
+		if silence.StartsAt.After(now) {
+			silence.StartsAt = now
+		}
+		silence.UpdatedAt = now
+		err = s.store.SaveSilence(r.Context(), silence)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Str("silence", id).Err(err).Msg("failed to expire silence")
+			return
+		}
+	}
+	log.Info().Str("silence", id).Msg("expired silence")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateSilence(silence storage.Silence) error {
+	if silence.Recurrence != nil {
+		if !silence.EndsAt.IsZero() && !silence.EndsAt.After(silence.StartsAt) {
+			return errors.New("the silence needs an end after its start")
+		}
+		return silence.Recurrence.Validate()
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return errors.New("the silence needs an end after its start, set endsAt or duration")
+	}
+	return nil
+}
+
+// createSilence saves a new, valid silence and emits a silence.created event
+func (s *Server) createSilence(ctx context.Context, silence storage.Silence) (storage.Silence, error) {
+	now := s.clock.Now().UTC()
+	id, err := ids.Sortable(now)
+	if err != nil {
+		return silence, err
+	}
+	silence.ID = id
+	silence.StartsAt = silence.StartsAt.UTC()
+	silence.EndsAt = silence.EndsAt.UTC()
+	silence.CreatedAt = now
+	silence.UpdatedAt = now
+	err = s.store.SaveSilence(ctx, silence)
+	if err != nil {
+		return silence, err
+	}
+	log.Info().
+		Str("silence", silence.ID).
+		Str("match", silence.Services.Match).
+		Time("ends", silence.EndsAt).
+		Str("by", silence.CreatedBy).
+		Msg("created silence")
+	event, err := events.NewSilenceEvent(events.SilenceCreated, now, events.Silence{
+		ID:        silence.ID,
+		Match:     silence.Services.Match,
+		Labels:    silence.Services.Labels,
+		StartsAt:  silence.StartsAt,
+		EndsAt:    silence.EndsAt,
+		CreatedBy: silence.CreatedBy,
+		Comment:   silence.Comment,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create event")
+	} else {
+		s.events.Emit(ctx, event)
+	}
+	return silence, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/simulation"
+)
+
+type simulationRequest struct {
+	// From and To are the outage window
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Match selects the services affected by the outage, all by default
+	Match string `json:"match"`
+	// Services are hypothetical configs which replace the stored ones with the same ID
+	Services []config.ServiceConfig `json:"services"`
+	// InhibitRules replace the configured rules if set
+	InhibitRules []config.InhibitRule `json:"inhibitRules"`
+}
+
+// handleSimulate predicts the alarms and notifications of a hypothetical outage, nothing is sent or stored
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	var req simulationRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if req.From.IsZero() || !req.To.After(req.From) {
+		http.Error(w, "from and to are required and to must be after from", http.StatusUnprocessableEntity)
+		return
+	}
+	for _, svc := range req.Services {
+		err = config.ValidateServiceID(svc.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	configs, err := s.serviceConfigs(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	byID := make(map[string]config.ServiceConfig, len(configs)+len(req.Services))
+	for _, svc := range configs {
+		byID[svc.ID] = svc
+	}
+	for _, svc := range req.Services {
+		byID[svc.ID] = svc
+	}
+	var affected []config.ServiceConfig
+	for _, svc := range byID {
+		if config.MatchServiceID(req.Match, svc.ID) {
+			affected = append(affected, svc)
+		}
+	}
+	rules := s.inhibitRules
+	if req.InhibitRules != nil {
+		rules = req.InhibitRules
+	}
+	s.writeJSON(w, http.StatusOK, simulation.Run(r.Context(), s.notifier, affected, rules, req.From, req.To))
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/slackapp"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+var slackInstalledPage = template.Must(template.New("slack-installed").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Slack connected</title></head>
+<body>
+<h1>Slack workspace {{.TeamName}} is connected</h1>
+<p>Use it in Slack notifications with <code>workspace: {{.TeamName}}</code> and a channel name instead of a token.</p>
+</body>
+</html>
+`))
+
+// slackWorkspace is a workspace as it is listed, without its tokens
+type slackWorkspace struct {
+	TeamID      string    `json:"teamID"`
+	TeamName    string    `json:"teamName"`
+	BotUserID   string    `json:"botUserID"`
+	Scope       string    `json:"scope"`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
+	InstalledBy string    `json:"installedBy"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// handleSlackInstall sends the user to Slack to install the app. It needs no credentials,
+// Slack asks the user for the permission to install the app into the workspace.
+func (s *Server) handleSlackInstall(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, s.slackApp.InstallURL(time.Now()), http.StatusFound)
+}
+
+// handleSlackOAuth completes the installation when Slack redirects the user back
+func (s *Server) handleSlackOAuth(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "the installation was canceled: "+reason, http.StatusBadRequest)
+		return
+	}
+	workspace, err := s.slackApp.Complete(r.Context(), query.Get("code"), query.Get("state"), time.Now())
+	if errors.Is(err, slackapp.ErrInvalidState) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to install the slack app", http.StatusBadGateway)
+		log.Error().Err(err).Msg("failed to complete slack app installation")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = slackInstalledPage.Execute(w, workspace)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to render slack installed page")
+	}
+}
+
+func (s *Server) handleListSlackWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := s.store.GetSlackWorkspaces(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list slack workspaces")
+		return
+	}
+	list := make([]slackWorkspace, len(workspaces))
+	for i, workspace := range workspaces {
+		list[i] = slackWorkspace{
+			TeamID:      workspace.TeamID,
+			TeamName:    workspace.TeamName,
+			BotUserID:   workspace.BotUserID,
+			Scope:       workspace.Scope,
+			ExpiresAt:   workspace.ExpiresAt,
+			InstalledBy: workspace.InstalledBy,
+			InstalledAt: workspace.InstalledAt,
+		}
+	}
+	s.writeList(w, r, list)
+}
+
+// handleDeleteSlackWorkspace forgets the tokens of a workspace, the app stays installed in Slack until it is removed there
+func (s *Server) handleDeleteSlackWorkspace(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	err := s.store.DeleteSlackWorkspace(r.Context(), teamID)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("team", teamID).Err(err).Msg("failed to delete slack workspace")
+		return
+	}
+	log.Info().Str("team", teamID).Msg("deleted slack workspace")
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const maxSlackCommandSize = 64 << 10
+
+// slackCommandResponse is the answer to a slash command, ephemeral answers are only shown to the user
+type slackCommandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// handleSlackCommand runs a /deadman slash command. Slack signs the request instead of sending credentials,
+// changes are recorded with the name of the Slack user.
+func (s *Server) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSlackCommandSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = s.slackApp.VerifyRequest(r.Header, body, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	user := form.Get("user_name")
+	if user == "" {
+		user = form.Get("user_id")
+	}
+	args := strings.Fields(form.Get("text"))
+	log.Info().Str("user", user).Strs("args", args).Msg("got slack command")
+	// everyone in the workspace who can use the command is an operator
+	answer := s.ChatCommand(r.Context(), user, args, true)
+	resp := slackCommandResponse{ResponseType: "ephemeral", Text: answer.Text}
+	if answer.Public {
+		resp.ResponseType = "in_channel"
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
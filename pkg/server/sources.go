@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	// keepHeartbeatSources is the number of heartbeat sources kept per service
+	keepHeartbeatSources = 10
+	maxMetadataEntries   = 16
+	maxMetadataLength    = 256
+
+	metadataHeaderPrefix = "X-Deadman-Meta-"
+	metadataQueryPrefix  = "meta."
+)
+
+// heartbeatSource describes the sender of the request.
+// Clients add metadata with X-Deadman-Meta-<key> headers or meta.<key> query parameters.
+func heartbeatSource(r *http.Request, now time.Time) storage.HeartbeatSource {
+	source := storage.HeartbeatSource{
+		Time:         now.UTC(),
+		RemoteAddr:   r.RemoteAddr,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		UserAgent:    truncate(r.UserAgent(), maxMetadataLength),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		source.RemoteAddr = host
+	}
+	metadata := make(map[string]string)
+	add := func(key, value string) {
+		if key != "" && len(metadata) < maxMetadataEntries {
+			metadata[strings.ToLower(truncate(key, maxMetadataLength))] = truncate(value, maxMetadataLength)
+		}
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, metadataHeaderPrefix) && len(values) > 0 {
+			add(strings.TrimPrefix(name, metadataHeaderPrefix), values[0])
+		}
+	}
+	for name, values := range r.URL.Query() {
+		if strings.HasPrefix(name, metadataQueryPrefix) && len(values) > 0 {
+			add(strings.TrimPrefix(name, metadataQueryPrefix), values[0])
+		}
+	}
+	if len(metadata) > 0 {
+		source.Metadata = metadata
+	}
+	return source
+}
+
+// recordHeartbeatSource adds the source to the recent sources of the service
+func (s *Server) recordHeartbeatSource(ctx context.Context, id string, source storage.HeartbeatSource) error {
+	sources, err := s.store.GetHeartbeatSources(ctx, id)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	sources = append([]storage.HeartbeatSource{source}, sources...)
+	if len(sources) > keepHeartbeatSources {
+		sources = sources[:keepHeartbeatSources]
+	}
+	return s.store.SaveHeartbeatSources(ctx, id, sources)
+}
+
+// handleHeartbeatSources lists who sent the recent heartbeats of a service, the newest first
+func (s *Server) handleHeartbeatSources(w http.ResponseWriter, r *http.Request, id string) {
+	_, err := s.store.GetServiceConfig(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to load service config")
+		return
+	}
+	sources, err := s.store.GetHeartbeatSources(r.Context(), id)
+	if err != nil && err != storage.ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get heartbeat sources")
+		return
+	}
+	if sources == nil {
+		sources = []storage.HeartbeatSource{}
+	}
+	s.writeJSON(w, http.StatusOK, sources)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
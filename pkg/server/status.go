@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const defaultWorstOffenders = 10
+
+type statusSummary struct {
+	Total          int                  `json:"total"`
+	Counts         map[serviceState]int `json:"counts"`
+	WorstOffenders []offender           `json:"worstOffenders"`
+	Groups         []statusGroup        `json:"groups,omitempty"`
+}
+
+// offender is a service which is not ok, Since is the start of the alarm or the time the heartbeat was due.
+// It has no duration, so the summary only changes when a state changes and polling clients get 304s.
+type offender struct {
+	serviceStatus
+	Since *time.Time `json:"since,omitempty"`
+}
+
+type statusGroup struct {
+	Labels map[string]string    `json:"labels"`
+	Total  int                  `json:"total"`
+	Counts map[serviceState]int `json:"counts"`
+}
+
+// handleListStatus returns the status of all services ordered by ID, with the time remaining until their deadlines.
+// Like the summary it reads all heartbeats and alarms at once, so the sources and replicas are left out.
+func (s *Server) handleListStatus(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// ?match=team/** restricts the list to matching service IDs, ?state=alarm to services in the state
+	pattern := query.Get("match")
+	state := serviceState(query.Get("state"))
+	switch state {
+	case "", serviceStateOK, serviceStateAlarm, serviceStateUnknown:
+	default:
+		http.Error(w, fmt.Sprintf("unknown state %q, use %q, %q or %q", state, serviceStateOK, serviceStateAlarm, serviceStateUnknown), http.StatusUnprocessableEntity)
+		return
+	}
+
+	ctx := r.Context()
+	configs, err := s.serviceConfigs(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	heartbeats, err := s.store.GetLastHeartbeats(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load heartbeats")
+		return
+	}
+	alarms, err := s.store.GetActiveAlarms(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load alarms")
+		return
+	}
+
+	now := s.clock.Now()
+	statuses := []serviceStatus{}
+	for _, svc := range configs {
+		if !config.MatchServiceID(pattern, svc.ID) {
+			continue
+		}
+		var lastHeartbeat, activeSince *time.Time
+		if t, ok := heartbeats[svc.ID]; ok {
+			lastHeartbeat = &t
+		}
+		if t, ok := alarms[svc.ID]; ok {
+			activeSince = &t
+		}
+		status := newServiceStatus(svc, lastHeartbeat, activeSince, now)
+		if state != "" && status.State != state {
+			continue
+		}
+		status.setDeadline(svc, now)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Service < statuses[j].Service
+	})
+	s.writeList(w, r, statuses)
+}
+
+// handleStatus returns the status of a single service, like /services/<id>
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.handleServiceStatus(w, r, chi.URLParam(r, "*"))
+}
+
+// handleStatusSummary aggregates the state of all services for wallboards.
+// It reads all heartbeats and alarms at once instead of loading them per service.
+func (s *Server) handleStatusSummary(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// ?match=team/** restricts the summary to matching service IDs
+	pattern := query.Get("match")
+	var groupBy []string
+	if value := query.Get("groupBy"); value != "" {
+		groupBy = strings.Split(value, ",")
+	}
+	limit := defaultWorstOffenders
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	configs, err := s.serviceConfigs(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to list service configs")
+		return
+	}
+	heartbeats, err := s.store.GetLastHeartbeats(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load heartbeats")
+		return
+	}
+	alarms, err := s.store.GetActiveAlarms(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to load alarms")
+		return
+	}
+
+	now := s.clock.Now()
+	summary := statusSummary{
+		Counts:         map[serviceState]int{},
+		WorstOffenders: []offender{},
+	}
+	groups := map[string]*statusGroup{}
+	var groupKeys []string
+	for _, svc := range configs {
+		if !config.MatchServiceID(pattern, svc.ID) {
+			continue
+		}
+		var lastHeartbeat, activeSince *time.Time
+		if t, ok := heartbeats[svc.ID]; ok {
+			lastHeartbeat = &t
+		}
+		if t, ok := alarms[svc.ID]; ok {
+			activeSince = &t
+		}
+		status := newServiceStatus(svc, lastHeartbeat, activeSince, now)
+		summary.Total++
+		summary.Counts[status.State]++
+		if status.State != serviceStateOK {
+			summary.WorstOffenders = append(summary.WorstOffenders, newOffender(status, svc))
+		}
+
+		if len(groupBy) > 0 {
+			labels := make(map[string]string, len(groupBy))
+			parts := make([]string, len(groupBy))
+			for i, label := range groupBy {
+				labels[label] = svc.Labels[label]
+				parts[i] = svc.Labels[label]
+			}
+			key := strings.Join(parts, "\x00")
+			group, ok := groups[key]
+			if !ok {
+				group = &statusGroup{Labels: labels, Counts: map[serviceState]int{}}
+				groups[key] = group
+				groupKeys = append(groupKeys, key)
+			}
+			group.Total++
+			group.Counts[status.State]++
+		}
+	}
+
+	// services without a known due time (never seen) sort after the ones overdue the longest
+	sort.SliceStable(summary.WorstOffenders, func(i, j int) bool {
+		a, b := summary.WorstOffenders[i].Since, summary.WorstOffenders[j].Since
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.Before(*b)
+	})
+	if len(summary.WorstOffenders) > limit {
+		summary.WorstOffenders = summary.WorstOffenders[:limit]
+	}
+	sort.Strings(groupKeys)
+	for _, key := range groupKeys {
+		summary.Groups = append(summary.Groups, *groups[key])
+	}
+	s.writeJSONWithETag(w, r, summary)
+}
+
+func newOffender(status serviceStatus, svc config.ServiceConfig) offender {
+	o := offender{serviceStatus: status}
+	switch {
+	case status.AlarmActiveSince != nil:
+		o.Since = status.AlarmActiveSince
+	case status.LastHeartbeat != nil:
+		due := svc.Deadline(*status.LastHeartbeat)
+		o.Since = &due
+	}
+	return o
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/usage"
+)
+
+// handleUsage serves GET /usage/?from=2024-01-01&to=2024-01-31, the report of the tenants for the days
+// from and to (inclusive). Without them it covers the current month.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	now := s.clock.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+	for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			http.Error(w, param+" must be a day like 2006-01-02", http.StatusBadRequest)
+			return
+		}
+		*target = day
+	}
+	if to.Before(from) || to.Sub(from) > usage.MaxDays*24*time.Hour {
+		http.Error(w, "the report covers 1 to 366 days", http.StatusBadRequest)
+		return
+	}
+	report, err := s.meter.Usage(r.Context(), from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Err(err).Msg("failed to get usage")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, report)
+}
+
+// checkServiceQuota returns a *usage.QuotaError if the service is new and doesn't fit into the quota of its tenant
+func (s *Server) checkServiceQuota(ctx context.Context, id string) error {
+	if s.meter == nil {
+		return nil
+	}
+	_, err := s.store.GetServiceConfig(ctx, id)
+	if err == nil {
+		return nil
+	}
+	if err != storage.ErrNotFound {
+		return err
+	}
+	return s.meter.CheckServices(ctx, []string{id}, nil)
+}
+
+// writeQuotaError writes the error of checkServiceQuota
+func writeQuotaError(w http.ResponseWriter, err error) {
+	var quotaErr *usage.QuotaError
+	if errors.As(err, &quotaErr) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	log.Error().Err(err).Msg("failed to check the service quota")
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// prepareServiceConfig validates a config which is created or updated through the API, checks it with the
+// defaults applied against the policies and the egress policy and returns it with its notifications in their
+// canonical form.
+// Invalid notifications are reported as config.FieldErrors.
+func (s *Server) prepareServiceConfig(cfg config.ServiceConfig) (config.ServiceConfig, error) {
+	err := ValidateServiceConfig(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	cfg, err = normalizeNotifications(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.OneShot != nil {
+		oneShot := cfg.OneShot.Resolve(s.clock.Now())
+		cfg.OneShot = &oneShot
+		// the timeout of a one-shot service is the time it has for its heartbeat, policies bound it like any other
+		if cfg.Timeout == 0 {
+			cfg.Timeout = config.Duration(oneShot.Deadline.Sub(oneShot.CreatedAt))
+		}
+	}
+	applied := storage.ApplyDefaults(s.store, cfg)
+	// a policy has tiers, so the ones of a known policy are resolved
+	if cfg.EscalationPolicy != "" && len(applied.Escalation) == 0 {
+		return cfg, config.FieldErrors{{Field: "escalationPolicy", Error: fmt.Sprintf("unknown escalation policy %q", cfg.EscalationPolicy)}}
+	}
+	err = config.CheckPolicies(s.policies, applied)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, s.egress.CheckService(applied)
+}
+
+// PrepareReplicatedConfig checks a config of the replication peer like one created through the API: it is
+// validated, normalized and checked against the policies, the egress policy and the quota of its tenant
+func (s *Server) PrepareReplicatedConfig(ctx context.Context, cfg config.ServiceConfig) (config.ServiceConfig, error) {
+	cfg, err := s.prepareServiceConfig(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, s.checkServiceQuota(ctx, cfg.ID)
+}
+
+// ValidateNotifications checks all notifications of the service like the API does
+func ValidateNotifications(cfg config.ServiceConfig) error {
+	_, err := normalizeNotifications(cfg)
+	return err
+}
+
+// normalizeNotifications normalizes all notifications of the service and collects the errors of all of them
+func normalizeNotifications(cfg config.ServiceConfig) (config.ServiceConfig, error) {
+	var errs config.FieldErrors
+	normalize := func(path string, notifications []config.NotificationConfig) []config.NotificationConfig {
+		if notifications == nil {
+			return nil
+		}
+		normalized := make([]config.NotificationConfig, len(notifications))
+		for i, notification := range notifications {
+			var fieldErrs config.FieldErrors
+			normalized[i], fieldErrs = notifier.NormalizeNotification(notification)
+			errs = append(errs, fieldErrs.Prefixed(fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return normalized
+	}
+	cfg.AlertNotifications = normalize("alertNotifications", cfg.AlertNotifications)
+	cfg.RecoveryNotifications = normalize("recoveryNotifications", cfg.RecoveryNotifications)
+	if cfg.Escalation != nil {
+		escalation := make(config.Escalation, len(cfg.Escalation))
+		for i, tier := range cfg.Escalation {
+			tier.AlertNotifications = normalize(fmt.Sprintf("escalation[%d].alertNotifications", i), tier.AlertNotifications)
+			tier.RecoveryNotifications = normalize(fmt.Sprintf("escalation[%d].recoveryNotifications", i), tier.RecoveryNotifications)
+			escalation[i] = tier
+		}
+		cfg.Escalation = escalation
+	}
+	if cfg.EarlyWarning != nil {
+		earlyWarning := *cfg.EarlyWarning
+		earlyWarning.Notifications = normalize("earlyWarning.notifications", earlyWarning.Notifications)
+		cfg.EarlyWarning = &earlyWarning
+	}
+	if cfg.Countdown != nil {
+		countdown := *cfg.Countdown
+		countdown.Notifications = normalize("countdown.notifications", countdown.Notifications)
+		cfg.Countdown = &countdown
+	}
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// writeConfigError answers 422 with the invalid fields as JSON or, for other errors, the message
+func (s *Server) writeConfigError(w http.ResponseWriter, err error) {
+	if fields, ok := err.(config.FieldErrors); ok {
+		s.writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  err.Error(),
+			"fields": fields,
+		})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// handleListConfigVersions serves GET /config/<id>/versions, the newest version first
+func (s *Server) handleListConfigVersions(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(chi.URLParam(r, "*"), "/versions")
+	if id == chi.URLParam(r, "*") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	history, err := s.store.GetServiceConfigHistory(r.Context(), id)
+	if err == storage.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get service config history")
+		return
+	}
+	versions := make([]storage.ServiceConfigVersion, 0, len(history.Versions))
+	for i := len(history.Versions) - 1; i >= 0; i-- {
+		versions = append(versions, history.Versions[i])
+	}
+	s.writeJSON(w, http.StatusOK, versions)
+}
+
+// handleConfigRollback serves POST /config/<id>/rollback/<version>.
+// The old config is saved as a new version, so the rollback itself can be rolled back.
+func (s *Server) handleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "*")
+	i := strings.LastIndex(param, "/rollback/")
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := param[:i]
+	version, err := strconv.Atoi(param[i+len("/rollback/"):])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	history, err := s.store.GetServiceConfigHistory(r.Context(), id)
+	if err != nil && err != storage.ErrNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to get service config history")
+		return
+	}
+	target, err := history.Get(version)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if target.Deleted {
+		http.Error(w, fmt.Sprintf("version %d is the deletion of the service", version), http.StatusUnprocessableEntity)
+		return
+	}
+	cfg, err := s.prepareServiceConfig(*target.Config)
+	if err != nil {
+		s.writeConfigError(w, err)
+		return
+	}
+	// rolling back the deletion of a service creates it again
+	err = s.checkServiceQuota(r.Context(), id)
+	if err != nil {
+		writeQuotaError(w, err)
+		return
+	}
+	err = s.store.SaveServiceConfig(r.Context(), cfg)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Error().Str("service", id).Err(err).Msg("failed to save service config")
+		return
+	}
+	log.Info().Str("service", id).Int("version", version).Msg("rolled back service config")
+	s.writeJSON(w, http.StatusOK, target.Config)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	// socketPingInterval is the interval of the pings which keep idle heartbeat connections alive
+	socketPingInterval = 30 * time.Second
+	// socketReadTimeout drops connections which neither sent a frame nor answered a ping for this long
+	socketReadTimeout  = 2 * socketPingInterval
+	socketWriteTimeout = 5 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// heartbeats are authenticated with the token or the ping credentials, not with cookies
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleHeartbeatSocket serves /ws/<id>, every frame a service sends on the connection is a heartbeat.
+// The server answers each frame with the ping response, so long running services can keep a single
+// connection open instead of sending a request per heartbeat.
+func (s *Server) handleHeartbeatSocket(w http.ResponseWriter, r *http.Request) {
+	target := r.Context().Value(pingTargetKey{}).(pingTarget)
+	if target.signal != "" {
+		http.Error(w, "signals are not supported on heartbeat connections", http.StatusUnprocessableEntity)
+		return
+	}
+	if target.state {
+		http.Error(w, "the state is served at /ping/"+target.svc.ID+"/state", http.StatusNotFound)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader wrote the error response already
+		log.Warn().Str("service", target.svc.ID).Err(err).Msg("failed to upgrade heartbeat connection")
+		return
+	}
+	defer conn.Close()
+	svc := target.svc
+	log.Info().Str("service", svc.ID).Str("replica", target.replica).Str("source", r.RemoteAddr).Msg("heartbeat connection opened")
+	s.socketOpened(svc.ID)
+
+	done := make(chan struct{})
+	defer close(done)
+	go keepSocketAlive(conn, done)
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	rid := r.URL.Query().Get("rid")
+	for {
+		err = conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+		if err != nil {
+			break
+		}
+		var frame []byte
+		_, frame, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		// the frame is the body of the heartbeat, e.g. the payload which is forwarded
+		req := r.Clone(r.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(frame))
+		response := newFrameResponse()
+		s.acceptHeartbeat(response, req, svc, target.replica, s.clock.Now(), rid)
+		if response.status >= 400 && response.body.Len() == 0 {
+			response.body.WriteString(http.StatusText(response.status))
+		}
+		err = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if err == nil {
+			err = conn.WriteMessage(websocket.TextMessage, response.body.Bytes())
+		}
+		if err != nil {
+			break
+		}
+	}
+	clean := websocket.IsCloseError(err, websocket.CloseNormalClosure)
+	log.Info().Str("service", svc.ID).Str("replica", target.replica).Bool("clean", clean).Err(err).Msg("heartbeat connection closed")
+	s.socketClosed(svc, clean, s.clock.Now())
+}
+
+// keepSocketAlive pings the client until done is closed, WriteControl may be used next to the reading loop
+func keepSocketAlive(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) socketOpened(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sockets[id]++
+}
+
+// socketClosed raises the alarm of a service with alarmOnDisconnect when its last connection dropped
+// and it didn't reconnect or send a heartbeat within the grace period
+func (s *Server) socketClosed(svc config.ServiceConfig, clean bool, droppedAt time.Time) {
+	s.mutex.Lock()
+	s.sockets[svc.ID]--
+	if s.sockets[svc.ID] <= 0 {
+		delete(s.sockets, svc.ID)
+	}
+	s.mutex.Unlock()
+	if clean || svc.WebSocket == nil || !svc.WebSocket.AlarmOnDisconnect {
+		return
+	}
+	time.AfterFunc(time.Duration(svc.WebSocket.Grace), func() {
+		s.alarmDroppedService(context.Background(), svc.ID, droppedAt)
+	})
+}
+
+func (s *Server) alarmDroppedService(ctx context.Context, id string, droppedAt time.Time) {
+	s.mutex.RLock()
+	connected := s.sockets[id] > 0
+	s.mutex.RUnlock()
+	if connected {
+		return
+	}
+	svc, err := s.store.GetServiceConfig(ctx, id)
+	if err == storage.ErrNotFound {
+		return
+	}
+	if err != nil {
+		log.Error().Str("service", id).Err(err).Msg("failed to load service config")
+		return
+	}
+	if svc.WebSocket == nil || !svc.WebSocket.AlarmOnDisconnect {
+		return
+	}
+	lastHeartbeat, err := s.store.GetLastHeartbeat(ctx, id)
+	if err != nil && err != storage.ErrNotFound {
+		log.Error().Str("service", id).Err(err).Msg("failed to get last heartbeat")
+		return
+	}
+	if lastHeartbeat.After(droppedAt) {
+		return
+	}
+	log.Info().Str("service", id).Msg("heartbeat connection dropped, raising the alarm")
+	s.failService(ctx, svc, s.clock.Now())
+}
+
+// frameResponse collects the ping response, which is sent back as a frame
+type frameResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newFrameResponse() *frameResponse {
+	return &frameResponse{header: make(http.Header)}
+}
+
+func (f *frameResponse) Header() http.Header {
+	return f.header
+}
+
+func (f *frameResponse) Write(b []byte) (int, error) {
+	return f.body.Write(b)
+}
+
+func (f *frameResponse) WriteHeader(status int) {
+	f.status = status
+}
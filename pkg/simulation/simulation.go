@@ -0,0 +1,193 @@
+// Package simulation predicts which alarms and notifications a hypothetical outage would cause,
+// so routing and inhibition changes can be validated before they are applied.
+//
+// The model is simple: every affected service sends its last heartbeat when the outage starts
+// and the next one when it ends. Debouncing, acknowledgements and alarm hooks are not considered.
+package simulation
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+)
+
+// Channel is the destination of a notification, without its secrets
+type Channel struct {
+	Type   config.NotificationType `json:"type"`
+	Target string                  `json:"target,omitempty"`
+}
+
+func (c Channel) String() string {
+	if c.Target == "" {
+		return string(c.Type)
+	}
+	return string(c.Type) + ":" + c.Target
+}
+
+// ServiceResult is the predicted outcome of the outage for one service
+type ServiceResult struct {
+	Service string `json:"service"`
+	Alarm   bool   `json:"alarm"`
+	// AlarmAt is the time the service becomes overdue, RecoveryAt the end of the outage
+	AlarmAt     *time.Time `json:"alarmAt,omitempty"`
+	RecoveryAt  *time.Time `json:"recoveryAt,omitempty"`
+	InhibitedBy string     `json:"inhibitedBy,omitempty"`
+	ActionPlan  string     `json:"actionPlan,omitempty"`
+	Alerts      []Channel  `json:"alerts,omitempty"`
+	Recoveries  []Channel  `json:"recoveries,omitempty"`
+}
+
+// Result is the predicted outcome of the outage
+type Result struct {
+	From          time.Time       `json:"from"`
+	To            time.Time       `json:"to"`
+	Services      []ServiceResult `json:"services"`
+	Alarms        int             `json:"alarms"`
+	Inhibited     int             `json:"inhibited"`
+	Notifications int             `json:"notifications"`
+	// Channels counts the notifications per channel
+	Channels map[string]int `json:"channels"`
+}
+
+// Run simulates an outage of the given services between from and to
+func Run(ctx context.Context, n notifier.Notifier, services []config.ServiceConfig, inhibitRules []config.InhibitRule, from, to time.Time) Result {
+	result := Result{
+		From:     from,
+		To:       to,
+		Services: []ServiceResult{},
+		Channels: map[string]int{},
+	}
+	alarmAt := make(map[string]time.Time)
+	for _, svc := range services {
+		at := from.Add(time.Duration(svc.Timeout))
+		if !at.After(to) {
+			alarmAt[svc.ID] = at
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+
+	for _, svc := range services {
+		res := ServiceResult{Service: svc.ID}
+		at, ok := alarmAt[svc.ID]
+		if !ok {
+			result.Services = append(result.Services, res)
+			continue
+		}
+		recoveryAt := to
+		res.Alarm = true
+		res.AlarmAt = &at
+		res.RecoveryAt = &recoveryAt
+		res.ActionPlan = svc.ActionPlan
+		result.Alarms++
+		// all alarms end together, so a source which alarms first inhibits the alerts for the whole outage
+		if source, ok := inhibitedBy(svc, at, services, alarmAt, inhibitRules); ok {
+			res.InhibitedBy = source
+			result.Inhibited++
+			result.Services = append(result.Services, res)
+			continue
+		}
+		res.Alerts = channels(n.PlanAlerts(ctx, svc, at))
+		// recoveries are only sent if alerts were
+		if len(res.Alerts) > 0 {
+			res.Recoveries = channels(n.PlanRecoveryNotifications(ctx, svc, recoveryAt))
+		}
+		for _, c := range append(append([]Channel{}, res.Alerts...), res.Recoveries...) {
+			result.Channels[c.String()]++
+			result.Notifications++
+		}
+		result.Services = append(result.Services, res)
+	}
+	return result
+}
+
+func inhibitedBy(svc config.ServiceConfig, at time.Time, services []config.ServiceConfig, alarmAt map[string]time.Time, rules []config.InhibitRule) (string, bool) {
+	for _, rule := range rules {
+		for _, source := range services {
+			sourceAt, alarming := alarmAt[source.ID]
+			if alarming && !sourceAt.After(at) && rule.Inhibits(source, svc) {
+				return source.ID, true
+			}
+		}
+	}
+	return "", false
+}
+
+func channels(notifications []config.NotificationConfig) []Channel {
+	result := make([]Channel, 0, len(notifications))
+	for _, notification := range notifications {
+		result = append(result, Channel{
+			Type:   notification.Type,
+			Target: target(notification),
+		})
+	}
+	return result
+}
+
+// target describes where a notification goes, as far as the type is known
+func target(notification config.NotificationConfig) string {
+	switch notification.Type {
+	case config.NotificationTypeWebhook:
+		if cfg, err := notification.GetWebhookConfig(); err == nil {
+			return redactURL(cfg.URL)
+		}
+	case config.NotificationTypeSlack:
+		if cfg, err := notification.GetSlackConfig(); err == nil {
+			return cfg.Channel
+		}
+	case config.NotificationTypeCallback:
+		if cfg, err := notification.GetCallbackConfig(); err == nil {
+			return redactURL(cfg.URL)
+		}
+	case config.NotificationTypeMQTT:
+		if cfg, err := notification.GetMQTTConfig(); err == nil {
+			return redactURL(cfg.Broker) + " " + cfg.Topic
+		}
+	case config.NotificationTypeNATS:
+		if cfg, err := notification.GetNATSConfig(); err == nil {
+			return redactURL(cfg.URL) + " " + cfg.Subject
+		}
+	case config.NotificationTypeZabbix:
+		if cfg, err := notification.GetZabbixConfig(); err == nil {
+			return cfg.Server + " " + cfg.Host
+		}
+	case config.NotificationTypeNSCA:
+		if cfg, err := notification.GetNSCAConfig(); err == nil {
+			return cfg.Server + " " + cfg.Host
+		}
+	case config.NotificationTypeEventBridge:
+		if cfg, err := notification.GetEventBridgeConfig(); err == nil {
+			return cfg.Region + " " + cfg.EventBus
+		}
+	case config.NotificationTypePubSub:
+		if cfg, err := notification.GetPubSubConfig(); err == nil {
+			return cfg.Topic
+		}
+	case config.NotificationTypeKafka:
+		if cfg, err := notification.GetKafkaConfig(); err == nil {
+			return strings.Join(cfg.Brokers, ",") + " " + cfg.Topic
+		}
+	case config.NotificationTypeTwilio:
+		if cfg, err := notification.GetTwilioConfig(); err == nil {
+			return strings.Join(cfg.To, ", ")
+		}
+	}
+	return ""
+}
+
+// redactURL drops the credentials and the query, which often carry tokens
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
@@ -0,0 +1,314 @@
+// Package slackapp implements the OAuth installation of the deadman switch Slack app into workspaces.
+//
+// The bot token of every workspace is kept in the storage and refreshed before it expires when the app
+// uses token rotation, so Slack notifications only name the workspace and a channel instead of a token.
+package slackapp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	authorizeURL = "https://slack.com/oauth/v2/authorize"
+	accessURL    = "https://slack.com/api/oauth.v2.access"
+	// stateTTL is the time a user has to complete the installation
+	stateTTL = 10 * time.Minute
+	// refreshBefore refreshes rotating tokens this long before they expire
+	refreshBefore = 5 * time.Minute
+	// maxRequestAge rejects replayed slash command requests
+	maxRequestAge = 5 * time.Minute
+)
+
+var (
+	DefaultScopes = []string{"chat:write", "chat:write.public", "channels:read", "groups:read", "channels:history"}
+
+	ErrInvalidState     = errors.New("invalid or expired installation state, please start the installation again")
+	ErrNotInstalled     = errors.New("the slack app isn't installed in the workspace")
+	ErrInvalidSignature = errors.New("invalid slack request signature")
+)
+
+// App installs the Slack app and provides the tokens and channels of the workspaces
+type App struct {
+	cfg   config.SlackAppConfig
+	store storage.Storage
+	cli   *http.Client
+	// mutex serializes token refreshes, channels caches the channel IDs by workspace and name
+	mutex    sync.Mutex
+	channels map[string]map[string]string
+}
+
+func NewApp(cfg config.SlackAppConfig, store storage.Storage) *App {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	return &App{
+		cfg:   cfg,
+		store: store,
+		cli: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		channels: make(map[string]map[string]string),
+	}
+}
+
+func (a *App) redirectURL() string {
+	return strings.TrimSuffix(a.cfg.URL, "/") + "/slack/oauth"
+}
+
+// InstallURL returns the Slack page which asks the user to install the app, the state is signed
+// with the client secret, so every instance of a cluster can complete the installation
+func (a *App) InstallURL(now time.Time) string {
+	expires := strconv.FormatInt(now.Add(stateTTL).Unix(), 10)
+	query := url.Values{}
+	query.Set("client_id", a.cfg.ClientID)
+	query.Set("scope", strings.Join(a.cfg.Scopes, ","))
+	query.Set("redirect_uri", a.redirectURL())
+	query.Set("state", expires+"."+a.sign(expires))
+	return authorizeURL + "?" + query.Encode()
+}
+
+func (a *App) verifyState(state string, now time.Time) error {
+	parts := strings.SplitN(state, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(a.sign(parts[0])), []byte(parts[1])) {
+		return ErrInvalidState
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return ErrInvalidState
+	}
+	return nil
+}
+
+func (a *App) sign(expires string) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.ClientSecret))
+	mac.Write([]byte("slack-install\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Commands reports whether the slash commands are enabled
+func (a *App) Commands() bool {
+	return a.cfg.SigningSecret != ""
+}
+
+// VerifyRequest checks the signature Slack puts on the requests of slash commands, see
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func (a *App) VerifyRequest(header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(a.cfg.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Complete exchanges the code of the redirect for the bot token and saves the workspace
+func (a *App) Complete(ctx context.Context, code, state string, now time.Time) (storage.SlackWorkspace, error) {
+	err := a.verifyState(state, now)
+	if err != nil {
+		return storage.SlackWorkspace{}, err
+	}
+	resp, err := a.access(ctx, url.Values{
+		"code":         {code},
+		"redirect_uri": {a.redirectURL()},
+	})
+	if err != nil {
+		return storage.SlackWorkspace{}, err
+	}
+	workspace := storage.SlackWorkspace{
+		TeamID:      resp.Team.ID,
+		TeamName:    resp.Team.Name,
+		BotUserID:   resp.BotUserID,
+		Scope:       resp.Scope,
+		InstalledBy: resp.AuthedUser.ID,
+		InstalledAt: now.UTC(),
+	}
+	resp.apply(&workspace, now)
+	err = a.store.SaveSlackWorkspace(ctx, workspace)
+	if err != nil {
+		return workspace, err
+	}
+	log.Info().Str("team", workspace.TeamID).Str("name", workspace.TeamName).Str("user", workspace.InstalledBy).Msg("installed slack app")
+	return workspace, nil
+}
+
+// Token returns the bot token of a workspace, given by team ID or name, and refreshes it if it is about to expire
+func (a *App) Token(ctx context.Context, workspace string) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	w, err := a.workspace(ctx, workspace)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if w.ExpiresAt.IsZero() || w.RefreshToken == "" || now.Add(refreshBefore).Before(w.ExpiresAt) {
+		return w.AccessToken, nil
+	}
+	resp, err := a.access(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {w.RefreshToken},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh the token of the slack workspace %s: %w", w.TeamName, err)
+	}
+	resp.apply(&w, now)
+	err = a.store.SaveSlackWorkspace(ctx, w)
+	if err != nil {
+		return "", err
+	}
+	log.Info().Str("team", w.TeamID).Time("expires", w.ExpiresAt).Msg("refreshed slack token")
+	return w.AccessToken, nil
+}
+
+// workspace looks up a workspace by team ID and then by name
+func (a *App) workspace(ctx context.Context, workspace string) (storage.SlackWorkspace, error) {
+	w, err := a.store.GetSlackWorkspace(ctx, workspace)
+	if err != storage.ErrNotFound {
+		return w, err
+	}
+	workspaces, err := a.store.GetSlackWorkspaces(ctx)
+	if err != nil {
+		return w, err
+	}
+	for _, w := range workspaces {
+		if strings.EqualFold(w.TeamName, workspace) {
+			return w, nil
+		}
+	}
+	return w, fmt.Errorf("%w: %s", ErrNotInstalled, workspace)
+}
+
+// ChannelID returns the ID of a channel of a workspace. Channel IDs and user IDs for direct messages are
+// returned as they are, names with or without # are looked up once and cached.
+func (a *App) ChannelID(ctx context.Context, workspace, channel string) (string, error) {
+	name := strings.TrimPrefix(channel, "#")
+	if name == channel && isID(channel) {
+		return channel, nil
+	}
+	a.mutex.Lock()
+	id, ok := a.channels[workspace][name]
+	a.mutex.Unlock()
+	if ok {
+		return id, nil
+	}
+	token, err := a.Token(ctx, workspace)
+	if err != nil {
+		return "", err
+	}
+	api := slack.New(token)
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: "true",
+		Limit:           1000,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+	ids := make(map[string]string)
+	for {
+		channels, cursor, err := api.GetConversationsContext(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range channels {
+			ids[c.Name] = c.ID
+		}
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	a.mutex.Lock()
+	a.channels[workspace] = ids
+	a.mutex.Unlock()
+	id, ok = ids[name]
+	if !ok {
+		return "", fmt.Errorf("the slack workspace %s has no channel %s the app can see", workspace, name)
+	}
+	return id, nil
+}
+
+// isID reports whether the channel looks like a channel, group, direct message or user ID
+func isID(channel string) bool {
+	if len(channel) < 9 || !strings.ContainsAny(channel[:1], "CGDUW") {
+		return false
+	}
+	return strings.ToUpper(channel) == channel
+}
+
+// accessResponse is the answer of oauth.v2.access, the refresh fields are only set with token rotation
+type accessResponse struct {
+	OK           bool   `json:"ok"`
+	Error        string `json:"error"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	BotUserID    string `json:"bot_user_id"`
+	Team         struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"team"`
+	AuthedUser struct {
+		ID string `json:"id"`
+	} `json:"authed_user"`
+}
+
+func (r accessResponse) apply(w *storage.SlackWorkspace, now time.Time) {
+	w.AccessToken = r.AccessToken
+	if r.RefreshToken != "" {
+		w.RefreshToken = r.RefreshToken
+	}
+	w.ExpiresAt = time.Time{}
+	if r.ExpiresIn > 0 {
+		w.ExpiresAt = now.Add(time.Duration(r.ExpiresIn) * time.Second).UTC()
+	}
+}
+
+func (a *App) access(ctx context.Context, values url.Values) (accessResponse, error) {
+	var resp accessResponse
+	values.Set("client_id", a.cfg.ClientID)
+	values.Set("client_secret", a.cfg.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, accessURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := a.cli.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		return resp, err
+	}
+	if !resp.OK {
+		return resp, fmt.Errorf("slack answered: %s", resp.Error)
+	}
+	return resp, nil
+}
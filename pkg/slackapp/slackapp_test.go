@@ -0,0 +1,89 @@
+package slackapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+func slackSignature(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyRequest(t *testing.T) {
+	app := NewApp(config.SlackAppConfig{SigningSecret: "signing-secret"}, nil)
+	now := time.Unix(1600000000, 0)
+	body := "command=%2Fdeadman&text=status+backup"
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	for _, test := range []struct {
+		name      string
+		timestamp string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{"valid", timestamp, slackSignature("signing-secret", timestamp, body), body, true},
+		{"slightly in the future", "1600000060", slackSignature("signing-secret", "1600000060", body), body, true},
+		{"wrong secret", timestamp, slackSignature("other-secret", timestamp, body), body, false},
+		{"changed body", timestamp, slackSignature("signing-secret", timestamp, body), body + "&x=1", false},
+		{"changed timestamp", "1599999999", slackSignature("signing-secret", timestamp, body), body, false},
+		{"replayed", "1599999000", slackSignature("signing-secret", "1599999000", body), body, false},
+		{"too far in the future", "1600001000", slackSignature("signing-secret", "1600001000", body), body, false},
+		{"missing timestamp", "", slackSignature("signing-secret", "", body), body, false},
+		{"missing signature", timestamp, "", body, false},
+		{"other version", timestamp, "v1=" + slackSignature("signing-secret", timestamp, body)[3:], body, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("X-Slack-Request-Timestamp", test.timestamp)
+			header.Set("X-Slack-Signature", test.signature)
+			err := app.VerifyRequest(header, []byte(test.body), now)
+			if test.valid && err != nil {
+				t.Fatalf("expected a valid request, got %v", err)
+			}
+			if !test.valid && err != ErrInvalidSignature {
+				t.Fatalf("expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInstallState(t *testing.T) {
+	app := NewApp(config.SlackAppConfig{ClientID: "client", ClientSecret: "client-secret", URL: "https://deadman.example.com"}, nil)
+	now := time.Unix(1600000000, 0)
+	install, err := url.Parse(app.InstallURL(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := install.Query().Get("state")
+	other := NewApp(config.SlackAppConfig{ClientSecret: "other-secret"}, nil)
+	for _, test := range []struct {
+		name  string
+		app   *App
+		state string
+		at    time.Time
+		valid bool
+	}{
+		{"valid", app, state, now.Add(time.Minute), true},
+		{"expired", app, state, now.Add(stateTTL + time.Second), false},
+		{"other secret", other, state, now, false},
+		{"changed expiry", app, "1700000000" + state[len("1600000600"):], now, false},
+		{"no signature", app, "1600000600", now, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.app.verifyState(test.state, test.at)
+			if test.valid != (err == nil) {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
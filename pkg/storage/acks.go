@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// Acknowledgement records that somebody is taking care of an active alarm
+type Acknowledgement struct {
+	By      string    `json:"by"`
+	Comment string    `json:"comment"`
+	Time    time.Time `json:"time"`
+}
+
+func (o objects) SetAlarmAcknowledgement(ctx context.Context, key string, ack Acknowledgement) error {
+	return o.putObject(ctx, path.Join("acks", key), ack)
+}
+
+func (o objects) GetAlarmAcknowledgement(ctx context.Context, key string) (ack Acknowledgement, err error) {
+	err = o.getObject(ctx, path.Join("acks", key), &ack)
+	return ack, err
+}
+
+func (o objects) ClearAlarmAcknowledgement(ctx context.Context, key string) error {
+	err := o.kv.delete(ctx, path.Join("acks", key))
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
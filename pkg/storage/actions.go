@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+type ActionRunStatus string
+
+const (
+	ActionRunStatusRunning          ActionRunStatus = "running"
+	ActionRunStatusAwaitingApproval ActionRunStatus = "awaiting-approval"
+	ActionRunStatusNotApproved      ActionRunStatus = "not-approved"
+	ActionRunStatusSucceeded        ActionRunStatus = "succeeded"
+	ActionRunStatusFailed           ActionRunStatus = "failed"
+	ActionRunStatusRolledBack       ActionRunStatus = "rolled-back"
+)
+
+// ActionRun is the last run of the action plan of a service
+type ActionRun struct {
+	Service string          `json:"service"`
+	Plan    string          `json:"plan"`
+	Status  ActionRunStatus `json:"status"`
+	// Approval is the ID of the approval of a destructive plan
+	Approval   string         `json:"approval,omitempty"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+	Steps      []ActionResult `json:"steps"`
+	Rollback   []ActionResult `json:"rollback,omitempty"`
+}
+
+type ActionResult struct {
+	Name       string    `json:"name"`
+	Success    bool      `json:"success"`
+	Output     string    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (o objects) GetActionRuns(ctx context.Context) ([]ActionRun, error) {
+	runs := []ActionRun{}
+	err := o.listObjects(ctx, "actions", func(key string, value []byte) error {
+		var run ActionRun
+		err := json.Unmarshal(value, &run)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		return nil
+	})
+	return runs, err
+}
+
+func (o objects) GetActionRun(ctx context.Context, service string) (run ActionRun, err error) {
+	err = o.getObject(ctx, path.Join("actions", service), &run)
+	return run, err
+}
+
+func (o objects) SaveActionRun(ctx context.Context, run ActionRun) error {
+	return o.putObject(ctx, path.Join("actions", run.Service), run)
+}
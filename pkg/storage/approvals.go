@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+type ApprovalStatus string
+
+const (
+	ApprovalStatusPending   ApprovalStatus = "pending"
+	ApprovalStatusApproved  ApprovalStatus = "approved"
+	ApprovalStatusExpired   ApprovalStatus = "expired"
+	ApprovalStatusCancelled ApprovalStatus = "cancelled"
+)
+
+// Approval is the approval of a destructive action plan run, it is kept for auditing
+type Approval struct {
+	ID          string         `json:"id"`
+	Service     string         `json:"service"`
+	Plan        string         `json:"plan"`
+	Status      ApprovalStatus `json:"status"`
+	RequestedAt time.Time      `json:"requestedAt"`
+	ExpiresAt   time.Time      `json:"expiresAt"`
+	DecidedAt   *time.Time     `json:"decidedAt,omitempty"`
+	// ApprovedBy describes who clicked the link, as far as the server can tell
+	ApprovedBy string `json:"approvedBy,omitempty"`
+}
+
+func (o objects) GetApprovals(ctx context.Context) ([]Approval, error) {
+	approvals := []Approval{}
+	err := o.listObjects(ctx, "approvals", func(key string, value []byte) error {
+		var approval Approval
+		err := json.Unmarshal(value, &approval)
+		if err != nil {
+			return err
+		}
+		approvals = append(approvals, approval)
+		return nil
+	})
+	return approvals, err
+}
+
+func (o objects) GetApproval(ctx context.Context, id string) (approval Approval, err error) {
+	err = o.getObject(ctx, path.Join("approvals", id), &approval)
+	return approval, err
+}
+
+func (o objects) SaveApproval(ctx context.Context, approval Approval) error {
+	return o.putObject(ctx, path.Join("approvals", approval.ID), approval)
+}
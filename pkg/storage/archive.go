@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// ArchivedService is a service which was taken out of the checks. It keeps the config as it was stored.
+type ArchivedService struct {
+	ID         string               `json:"id"`
+	Config     config.ServiceConfig `json:"config"`
+	Reason     string               `json:"reason"`
+	ArchivedAt time.Time            `json:"archivedAt"`
+	// LastHeartbeat is the time of the last heartbeat before the service was archived, if it had one
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+}
+
+// Archive moves a service out of the checks into the archive and clears its alarm
+func Archive(ctx context.Context, store Storage, id, reason string, now time.Time) (ArchivedService, error) {
+	// archive the config without the defaults, they are applied again when it is restored
+	svc, err := Unwrap(store).GetServiceConfig(ctx, id)
+	if err != nil {
+		return ArchivedService{}, err
+	}
+	archived := ArchivedService{
+		ID:         id,
+		Config:     svc,
+		Reason:     reason,
+		ArchivedAt: now.UTC(),
+	}
+	archived.LastHeartbeat, err = store.GetLastHeartbeat(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return archived, err
+	}
+	err = store.SaveArchivedService(ctx, archived)
+	if err != nil {
+		return archived, err
+	}
+	err = store.DeleteServiceConfig(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return archived, err
+	}
+	for _, clear := range []func(context.Context, string) error{store.ClearAlarm, store.ClearAlarmAcknowledgement} {
+		err = clear(ctx, id)
+		if err != nil && err != ErrNotFound {
+			return archived, err
+		}
+	}
+	log.Info().Str("service", id).Str("reason", reason).Msg("archived service")
+	return archived, nil
+}
+
+func (o objects) GetArchivedServices(ctx context.Context) ([]ArchivedService, error) {
+	services := []ArchivedService{}
+	err := o.listObjects(ctx, "archive/services", func(key string, value []byte) error {
+		var archived ArchivedService
+		err := json.Unmarshal(value, &archived)
+		if err != nil {
+			return err
+		}
+		services = append(services, archived)
+		return nil
+	})
+	return services, err
+}
+
+func (o objects) GetArchivedService(ctx context.Context, id string) (ArchivedService, error) {
+	var archived ArchivedService
+	err := o.getObject(ctx, path.Join("archive/services", id), &archived)
+	return archived, err
+}
+
+func (o objects) SaveArchivedService(ctx context.Context, archived ArchivedService) error {
+	return o.putObject(ctx, path.Join("archive/services", archived.ID), archived)
+}
+
+func (o objects) DeleteArchivedService(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("archive/services", id))
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// AuditEntry records a manual intervention of an operator, like forcing or clearing an alarm
+type AuditEntry struct {
+	// ID sorts by time
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	By      string    `json:"by"`
+	Action  string    `json:"action"`
+	Service string    `json:"service,omitempty"`
+	Reason  string    `json:"reason"`
+	// Note is free text of the caller, By is always the authenticated caller
+	Note string `json:"note,omitempty"`
+}
+
+// GetAuditEntries returns the entries ordered by their ID
+func (o objects) GetAuditEntries(ctx context.Context) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := o.listObjects(ctx, "audit", func(key string, value []byte) error {
+		var entry AuditEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func (o objects) SaveAuditEntry(ctx context.Context, entry AuditEntry) error {
+	return o.putObject(ctx, path.Join("audit", entry.ID), entry)
+}
+
+func (o objects) DeleteAuditEntry(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("audit", id))
+}
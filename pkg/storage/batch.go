@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+)
+
+// GetLastHeartbeats returns the last heartbeats of all services in one read
+func (o objects) GetLastHeartbeats(ctx context.Context) (map[string]time.Time, error) {
+	return o.listTimestamps(ctx, "heartbeats")
+}
+
+// GetActiveAlarms returns since when the alarms of all alarming services are active in one read
+func (o objects) GetActiveAlarms(ctx context.Context) (map[string]time.Time, error) {
+	return o.listTimestamps(ctx, "alarms")
+}
+
+func (o objects) listTimestamps(ctx context.Context, prefix string) (map[string]time.Time, error) {
+	timestamps := make(map[string]time.Time)
+	err := o.listObjects(ctx, prefix, func(key string, value []byte) error {
+		t, err := time.Parse(time.RFC3339, string(value))
+		if err != nil {
+			return err
+		}
+		timestamps[strings.TrimPrefix(key, path.Clean(prefix)+"/")] = t
+		return nil
+	})
+	return timestamps, err
+}
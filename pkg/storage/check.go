@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// ConfigCheck is the result of CheckServiceConfigs
+type ConfigCheck struct {
+	Valid int `json:"valid"`
+	// Migrated are the configs which were stored in an older format and rewritten
+	Migrated []string `json:"migrated,omitempty"`
+	// UnknownFields are the configs with fields of a newer version, they are kept as they are
+	UnknownFields []string `json:"unknownFields,omitempty"`
+	// Invalid are the configs which can't be decoded or don't pass the validation
+	Invalid []QuarantinedConfig `json:"invalid,omitempty"`
+}
+
+// QuarantinedConfig is a stored service config which was moved out of the way because it is invalid
+type QuarantinedConfig struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	// Raw is the stored value, which may not be valid JSON
+	Raw           string    `json:"raw"`
+	QuarantinedAt time.Time `json:"quarantinedAt"`
+}
+
+// rawServiceConfigs is implemented by the backends, which return the stored service configs keyed by ID
+type rawServiceConfigs interface {
+	rawServiceConfigs(ctx context.Context) ([]kvPair, error)
+}
+
+func (o objects) rawServiceConfigs(ctx context.Context) ([]kvPair, error) {
+	var pairs []kvPair
+	err := o.listObjects(ctx, "services", func(key string, value []byte) error {
+		pairs = append(pairs, kvPair{strings.TrimPrefix(key, "services/"), value})
+		return nil
+	})
+	return pairs, err
+}
+
+// CheckServiceConfigs validates all stored service configs against the current schema.
+// Configs which can't be decoded or are invalid are reported and, with quarantine set, moved
+// to the quarantine. With migrate set, configs in an older format are saved in the current one.
+func CheckServiceConfigs(ctx context.Context, store Storage, validate func(config.ServiceConfig) error, cfg config.StorageCheckConfig) (ConfigCheck, error) {
+	var check ConfigCheck
+	raw, ok := store.(rawServiceConfigs)
+	if !ok {
+		return check, fmt.Errorf("storage %T doesn't support the check", store)
+	}
+	pairs, err := raw.rawServiceConfigs(ctx)
+	if err != nil {
+		return check, err
+	}
+	now := time.Now().UTC()
+	for _, pair := range pairs {
+		id := pair.key
+		svc, strict, err := decodeStoredConfig(pair.value)
+		if err == nil && svc.ID != id {
+			err = fmt.Errorf("config of %q is stored as %q", svc.ID, id)
+		}
+		if err == nil {
+			err = validate(svc)
+		}
+		if err != nil {
+			log.Warn().Str("service", id).Err(err).Msg("invalid stored service config")
+			quarantined := QuarantinedConfig{ID: id, Error: err.Error(), Raw: string(pair.value), QuarantinedAt: now}
+			check.Invalid = append(check.Invalid, quarantined)
+			if cfg.Quarantine {
+				err = quarantine(ctx, store, quarantined)
+				if err != nil {
+					return check, err
+				}
+			}
+			continue
+		}
+		check.Valid++
+		if !strict {
+			log.Warn().Str("service", id).Msg("stored service config has unknown fields, it is kept as it is")
+			check.UnknownFields = append(check.UnknownFields, id)
+			continue
+		}
+		current, err := encodeServiceConfig(svc)
+		if err != nil {
+			return check, err
+		}
+		if bytes.Equal(current, pair.value) {
+			continue
+		}
+		check.Migrated = append(check.Migrated, id)
+		if cfg.Migrate {
+			err = store.SaveServiceConfig(ctx, svc)
+			if err != nil {
+				return check, err
+			}
+			log.Info().Str("service", id).Msg("migrated stored service config")
+		}
+	}
+	return check, nil
+}
+
+// decodeStoredConfig decodes a stored config, strict is false if it has unknown fields
+func decodeStoredConfig(value []byte) (svc config.ServiceConfig, strict bool, err error) {
+	svc, err = decodeServiceConfig(value)
+	if err != nil {
+		return svc, false, err
+	}
+	value, err = upgradeServiceConfig(value)
+	if err != nil {
+		return svc, false, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	var discard config.ServiceConfig
+	return svc, decoder.Decode(&discard) == nil, nil
+}
+
+func quarantine(ctx context.Context, store Storage, quarantined QuarantinedConfig) error {
+	err := store.SaveQuarantinedConfig(ctx, quarantined)
+	if err != nil {
+		return err
+	}
+	err = store.DeleteServiceConfig(ctx, quarantined.ID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	log.Warn().Str("service", quarantined.ID).Msg("quarantined stored service config")
+	return nil
+}
+
+func (o objects) GetQuarantinedConfigs(ctx context.Context) ([]QuarantinedConfig, error) {
+	configs := []QuarantinedConfig{}
+	err := o.listObjects(ctx, "quarantine/services", func(key string, value []byte) error {
+		var quarantined QuarantinedConfig
+		err := json.Unmarshal(value, &quarantined)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, quarantined)
+		return nil
+	})
+	return configs, err
+}
+
+func (o objects) SaveQuarantinedConfig(ctx context.Context, quarantined QuarantinedConfig) error {
+	return o.putObject(ctx, path.Join("quarantine/services", quarantined.ID), quarantined)
+}
+
+func (o objects) DeleteQuarantinedConfig(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("quarantine/services", id))
+}
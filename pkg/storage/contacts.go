@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+func (o objects) GetContacts(ctx context.Context) ([]config.Contact, error) {
+	contacts := []config.Contact{}
+	err := o.listObjects(ctx, "contacts", func(key string, value []byte) error {
+		var contact config.Contact
+		err := json.Unmarshal(value, &contact)
+		if err != nil {
+			return err
+		}
+		contacts = append(contacts, contact)
+		return nil
+	})
+	return contacts, err
+}
+
+func (o objects) GetContact(ctx context.Context, id string) (contact config.Contact, err error) {
+	err = o.getObject(ctx, path.Join("contacts", id), &contact)
+	return contact, err
+}
+
+func (o objects) SaveContact(ctx context.Context, contact config.Contact) error {
+	return o.putObject(ctx, path.Join("contacts", contact.ID), contact)
+}
+
+func (o objects) DeleteContact(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("contacts", id))
+}
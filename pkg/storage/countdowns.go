@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// Countdown is the last countdown warning sent for a service, a heartbeat moves the deadline and starts a new countdown
+type Countdown struct {
+	Service  string        `json:"service"`
+	Deadline time.Time     `json:"deadline"`
+	LeadTime time.Duration `json:"leadTime"`
+}
+
+func (o objects) GetCountdown(ctx context.Context, service string) (Countdown, error) {
+	var countdown Countdown
+	err := o.getObject(ctx, path.Join("countdowns", service), &countdown)
+	return countdown, err
+}
+
+func (o objects) SaveCountdown(ctx context.Context, countdown Countdown) error {
+	return o.putObject(ctx, path.Join("countdowns", countdown.Service), countdown)
+}
+
+func (o objects) DeleteCountdown(ctx context.Context, service string) error {
+	return o.kv.delete(ctx, path.Join("countdowns", service))
+}
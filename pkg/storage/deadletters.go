@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// DeadLetter is a queued notification which was given up on, it is quarantined for inspection
+type DeadLetter struct {
+	ID string `json:"id"`
+	// Item is the queue item, items which are no valid JSON are kept as a string
+	Item json.RawMessage `json:"item"`
+	// Service and NotificationType are unknown for items which can't be decoded
+	Service          string `json:"service,omitempty"`
+	NotificationType string `json:"notificationType,omitempty"`
+	Error            string `json:"error"`
+	// Errors are the errors of all attempts, oldest first
+	Errors   []string  `json:"errors,omitempty"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+func (o objects) GetDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := o.listObjects(ctx, "deadletters", func(key string, value []byte) error {
+		var letter DeadLetter
+		err := json.Unmarshal(value, &letter)
+		if err != nil {
+			return err
+		}
+		letters = append(letters, letter)
+		return nil
+	})
+	return letters, err
+}
+
+func (o objects) GetDeadLetter(ctx context.Context, id string) (letter DeadLetter, err error) {
+	err = o.getObject(ctx, path.Join("deadletters", id), &letter)
+	return letter, err
+}
+
+func (o objects) SaveDeadLetter(ctx context.Context, letter DeadLetter) error {
+	return o.putObject(ctx, path.Join("deadletters", letter.ID), letter)
+}
+
+func (o objects) DeleteDeadLetter(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("deadletters", id))
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"strconv"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// WithDefaults wraps a storage so all service configs it returns have the
+// per-prefix defaults applied and their escalation policies resolved. The stored
+// configs themselves stay untouched, so changing the defaults affects all existing services.
+func WithDefaults(store Storage, defaults []config.DefaultsConfig, escalations []config.EscalationPolicyConfig) Storage {
+	if len(defaults) == 0 && len(escalations) == 0 {
+		return store
+	}
+	bs, _ := json.Marshal([]interface{}{defaults, escalations})
+	hash := fnv.New64a()
+	hash.Write(bs)
+	return &defaultsStorage{store, defaults, escalations, strconv.FormatUint(hash.Sum64(), 36)}
+}
+
+// Unwrap returns the underlying storage of WithDefaults which returns the service configs as they are stored.
+// Changes made through it are still recorded by WithConfigVersions.
+func Unwrap(store Storage) Storage {
+	if s, ok := store.(*defaultsStorage); ok {
+		return s.Storage
+	}
+	return store
+}
+
+// ApplyDefaults returns the config like a storage wrapped by WithDefaults would return it
+func ApplyDefaults(store Storage, svc config.ServiceConfig) config.ServiceConfig {
+	if s, ok := store.(*defaultsStorage); ok {
+		return s.apply(svc)
+	}
+	return svc
+}
+
+type defaultsStorage struct {
+	Storage
+	defaults    []config.DefaultsConfig
+	escalations []config.EscalationPolicyConfig
+	// defaultsHash is part of the configs version, because the returned configs change with the defaults
+	defaultsHash string
+}
+
+// apply resolves the escalation policy of the service before the defaults, so it beats their escalation tiers
+func (s *defaultsStorage) apply(svc config.ServiceConfig) config.ServiceConfig {
+	return svc.WithEscalationPolicy(s.escalations).WithDefaults(s.defaults).WithEscalationPolicy(s.escalations)
+}
+
+func (s *defaultsStorage) GetServiceConfig(ctx context.Context, id string) (config.ServiceConfig, error) {
+	svc, err := s.Storage.GetServiceConfig(ctx, id)
+	if err != nil {
+		return svc, err
+	}
+	return s.apply(svc), nil
+}
+
+func (s *defaultsStorage) GetServiceConfigs(ctx context.Context) (chan config.ServiceConfig, chan error) {
+	configs, errs := s.Storage.GetServiceConfigs(ctx)
+	configChannel := make(chan config.ServiceConfig, 32)
+	go func() {
+		defer close(configChannel)
+		for svc := range configs {
+			select {
+			case <-ctx.Done():
+				return
+			case configChannel <- s.apply(svc):
+			}
+		}
+	}()
+	return configChannel, errs
+}
+
+func (s *defaultsStorage) GetServiceConfigsVersion(ctx context.Context) (string, error) {
+	version, err := s.Storage.GetServiceConfigsVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return version + "-" + s.defaultsHash, nil
+}
+
+func (s *defaultsStorage) Close() error {
+	if closer, ok := s.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// Escalation records how many escalation tiers of an alarm were notified
+type Escalation struct {
+	// AlarmActiveSince identifies the alarm, the escalation of an older alarm doesn't count
+	AlarmActiveSince time.Time `json:"alarmActiveSince"`
+	Level            int       `json:"level"`
+}
+
+func (o objects) SetEscalation(ctx context.Context, key string, escalation Escalation) error {
+	return o.putObject(ctx, path.Join("escalations", key), escalation)
+}
+
+func (o objects) GetEscalation(ctx context.Context, key string) (escalation Escalation, err error) {
+	err = o.getObject(ctx, path.Join("escalations", key), &escalation)
+	return escalation, err
+}
+
+func (o objects) ClearEscalation(ctx context.Context, key string) error {
+	err := o.kv.delete(ctx, path.Join("escalations", key))
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -2,9 +2,9 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -31,14 +31,32 @@ func NewEtcdStorage(ctx context.Context, cli *clientv3.Client, prefix string) (S
 			}
 		}
 	}()
-	return &etcdStorage{
+	s := &etcdStorage{
 		client: cli,
 		prefix: prefix,
 		lease:  lease.ID,
-	}, nil
+	}
+	s.objects = objects{s}
+	err = runMigrations(ctx, s, s.migrations())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrations upgrade the objects of an older version, see SchemaVersion.
+// All nodes run them on start, the first one to record the new version wins.
+func (s *etcdStorage) migrations() []migration {
+	return []migration{
+		{2, "index service labels", func(ctx context.Context) error {
+			return s.buildLabelIndex(ctx, s)
+		}},
+		{3, "index open incidents", s.indexOpenIncidents},
+	}
 }
 
 type etcdStorage struct {
+	objects
 	client *clientv3.Client
 	prefix string
 	lease  clientv3.LeaseID
@@ -107,23 +125,112 @@ func (s *etcdStorage) GetLastMessageSendTimestamp(ctx context.Context, key strin
 }
 
 func (s *etcdStorage) SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error {
-	bs, err := json.Marshal(svc)
+	_, err := s.updateServiceConfigs(ctx, []config.ServiceConfig{svc}, nil)
+	return err
+}
+
+func (s *etcdStorage) DeleteServiceConfig(ctx context.Context, id string) error {
+	found, err := s.updateServiceConfigs(ctx, nil, []string{id})
 	if err != nil {
 		return err
 	}
-	_, err = s.client.KV.Put(ctx, filepath.Join(s.prefix, "services", svc.ID), string(bs))
-	if err != nil {
-		return err
+	if found == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
 
-func (s *etcdStorage) DeleteServiceConfig(ctx context.Context, id string) error {
-	_, err := s.client.KV.Delete(ctx, filepath.Join(s.prefix, "services", id))
+// ApplyServiceConfigs runs all changes in one transaction, which is limited
+// to the --max-txn-ops of the etcd cluster (128 by default). Every changed label
+// of a service takes an operation of its own.
+func (s *etcdStorage) ApplyServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) error {
+	if len(save)+len(remove) == 0 {
+		return nil
+	}
+	_, err := s.updateServiceConfigs(ctx, save, remove)
+	return err
+}
+
+// maxConfigUpdateAttempts limits the retries of config updates which raced with another instance
+const maxConfigUpdateAttempts = 5
+
+// updateServiceConfigs saves and deletes configs together with their label index entries in one transaction.
+// The transaction only succeeds if none of the configs changed since they were read for the index, otherwise it is retried.
+// It returns how many of the removed configs existed.
+func (s *etcdStorage) updateServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) (int, error) {
+	for attempt := 0; attempt < maxConfigUpdateAttempts; attempt++ {
+		var (
+			compares []clientv3.Cmp
+			ops      []clientv3.Op
+			found    int
+		)
+		for _, id := range remove {
+			old, exists, cmp, err := s.readServiceConfig(ctx, id)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				found++
+			}
+			compares = append(compares, cmp)
+			ops = append(ops, s.labelIndexOps(old, nil)...)
+			ops = append(ops, clientv3.OpDelete(filepath.Join(s.prefix, "services", id)))
+		}
+		for i := range save {
+			old, _, cmp, err := s.readServiceConfig(ctx, save[i].ID)
+			if err != nil {
+				return 0, err
+			}
+			bs, err := encodeServiceConfig(save[i])
+			if err != nil {
+				return 0, err
+			}
+			compares = append(compares, cmp)
+			ops = append(ops, s.labelIndexOps(old, &save[i])...)
+			ops = append(ops, clientv3.OpPut(filepath.Join(s.prefix, "services", save[i].ID), string(bs)))
+		}
+		resp, err := s.client.KV.Txn(ctx).If(compares...).Then(ops...).Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return found, nil
+		}
+	}
+	return 0, fmt.Errorf("service configs changed concurrently %d times", maxConfigUpdateAttempts)
+}
+
+// readServiceConfig returns the stored config and a comparison which fails once it changed.
+// The config is nil if it doesn't exist or can't be decoded.
+func (s *etcdStorage) readServiceConfig(ctx context.Context, id string) (*config.ServiceConfig, bool, clientv3.Cmp, error) {
+	key := filepath.Join(s.prefix, "services", id)
+	resp, err := s.client.KV.Get(ctx, key)
 	if err != nil {
-		return err
+		return nil, false, clientv3.Cmp{}, err
 	}
-	return nil
+	if len(resp.Kvs) == 0 {
+		return nil, false, clientv3.Compare(clientv3.ModRevision(key), "=", 0), nil
+	}
+	cmp := clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+	svc, err := decodeServiceConfig(resp.Kvs[0].Value)
+	if err != nil {
+		// the labels of a corrupt config are unknown, it is replaced or deleted anyway
+		log.Warn().Str("service", id).Err(err).Msg("replacing service config which can't be decoded")
+		return nil, true, cmp, nil
+	}
+	return &svc, true, cmp, nil
+}
+
+func (s *etcdStorage) labelIndexOps(old, updated *config.ServiceConfig) []clientv3.Op {
+	remove, add := labelIndexChanges(old, updated)
+	ops := make([]clientv3.Op, 0, len(remove)+len(add))
+	for _, key := range remove {
+		ops = append(ops, clientv3.OpDelete(filepath.Join(s.prefix, key)))
+	}
+	for _, key := range add {
+		ops = append(ops, clientv3.OpPut(filepath.Join(s.prefix, key), ""))
+	}
+	return ops
 }
 
 func (s *etcdStorage) GetServiceConfig(ctx context.Context, id string) (cfg config.ServiceConfig, err error) {
@@ -132,13 +239,28 @@ func (s *etcdStorage) GetServiceConfig(ctx context.Context, id string) (cfg conf
 		return cfg, err
 	}
 	if len(resp.Kvs) < 1 {
-		return cfg, errors.New("not found")
+		return cfg, ErrNotFound
 	}
-	err = json.Unmarshal(resp.Kvs[0].Value, &cfg)
+	return decodeServiceConfig(resp.Kvs[0].Value)
+}
+
+// GetServiceConfigsVersion combines the latest modification revision and the number of the service configs.
+// Every save raises the revision and every delete without a save lowers the count.
+func (s *etcdStorage) GetServiceConfigsVersion(ctx context.Context) (string, error) {
+	resp, err := s.client.KV.Get(ctx, filepath.Join(s.prefix, "services")+"/",
+		clientv3.WithPrefix(),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend),
+		clientv3.WithLimit(1),
+	)
 	if err != nil {
-		return cfg, err
+		return "", err
+	}
+	var revision int64
+	if len(resp.Kvs) > 0 {
+		revision = resp.Kvs[0].ModRevision
 	}
-	return cfg, nil
+	return fmt.Sprintf("%d-%d", revision, resp.Count), nil
 }
 
 // GetServiceConfigs implements `config.Provider`
@@ -150,7 +272,7 @@ func (s *etcdStorage) GetServiceConfigs(ctx context.Context) (configChannel chan
 			defer close(configChannel)
 			defer close(errorChannel)
 		}()
-		resp, err := s.client.KV.Get(ctx, filepath.Join(s.prefix, "services"), clientv3.WithPrefix())
+		resp, err := s.client.KV.Get(ctx, filepath.Join(s.prefix, "services")+"/", clientv3.WithPrefix())
 		if err != nil {
 			errorChannel <- err
 			return
@@ -161,12 +283,11 @@ func (s *etcdStorage) GetServiceConfigs(ctx context.Context) (configChannel chan
 				errorChannel <- ctx.Err()
 				return
 			default:
-				var cfg config.ServiceConfig
-				err = json.Unmarshal(val.Value, &cfg)
+				cfg, err := decodeServiceConfig(val.Value)
 				if err != nil {
-					log.Error().Err(err).Str("data", string(val.Value)).Msg("failed to unmarshal")
-					errorChannel <- err
-					return
+					// a corrupt config must not stop the checks of all other services, see CheckServiceConfigs
+					log.Error().Err(err).Str("key", string(val.Key)).Msg("skipping service config which can't be decoded")
+					continue
 				}
 				log.Debug().Str("key", string(val.Key)).Msg("read config from etcd")
 				configChannel <- cfg
@@ -175,3 +296,45 @@ func (s *etcdStorage) GetServiceConfigs(ctx context.Context) (configChannel chan
 	}()
 	return
 }
+
+func (s *etcdStorage) put(ctx context.Context, key string, value []byte) error {
+	_, err := s.client.KV.Put(ctx, filepath.Join(s.prefix, key), string(value))
+	return err
+}
+
+func (s *etcdStorage) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.KV.Get(ctx, filepath.Join(s.prefix, key))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNotFound
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (s *etcdStorage) delete(ctx context.Context, key string) error {
+	resp, err := s.client.KV.Delete(ctx, filepath.Join(s.prefix, key))
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *etcdStorage) list(ctx context.Context, prefix string) ([]kvPair, error) {
+	resp, err := s.client.KV.Get(ctx, filepath.Join(s.prefix, prefix)+"/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]kvPair, 0, len(resp.Kvs))
+	for _, val := range resp.Kvs {
+		pairs = append(pairs, kvPair{
+			key:   strings.TrimPrefix(string(val.Key), s.prefix+"/"),
+			value: val.Value,
+		})
+	}
+	return pairs, nil
+}
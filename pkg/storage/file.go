@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -23,7 +24,12 @@ func NewFileStorage(cfg config.ServerConfig) (Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	store := &fileStorage{db: db}
+	store := &fileStorage{db: db, version: newVersionCounter()}
+	store.objects = objects{store}
+	err = runMigrations(context.Background(), store, store.migrations())
+	if err != nil {
+		return nil, err
+	}
 	for _, svc := range cfg.Services {
 		err := store.SaveServiceConfig(context.Background(), svc)
 		if err != nil {
@@ -33,8 +39,22 @@ func NewFileStorage(cfg config.ServerConfig) (Storage, error) {
 	return store, nil
 }
 
+// migrations upgrade the database of an older version, see SchemaVersion
+func (s *fileStorage) migrations() []migration {
+	return []migration{
+		{2, "index service labels", func(ctx context.Context) error {
+			return s.buildLabelIndex(ctx, s)
+		}},
+		{3, "index open incidents", s.indexOpenIncidents},
+	}
+}
+
 type fileStorage struct {
+	objects
 	db *leveldb.DB
+	// configMutex serializes the changes of service configs, which read the old config to update the label index
+	configMutex sync.Mutex
+	version     *versionCounter
 }
 
 func (s *fileStorage) SetLastHeartbeat(ctx context.Context, key string, t time.Time) error {
@@ -48,7 +68,7 @@ func (s *fileStorage) SetLastHeartbeat(ctx context.Context, key string, t time.T
 func (s *fileStorage) GetLastHeartbeat(ctx context.Context, key string) (time.Time, error) {
 	resp, err := s.db.Get([]byte(filepath.Join("heartbeats", key)), nil)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, mapFileError(err)
 	}
 	return time.Parse(time.RFC3339, string(resp))
 }
@@ -64,7 +84,7 @@ func (s *fileStorage) SetAlarmActiveSince(ctx context.Context, key string, t tim
 func (s *fileStorage) GetAlarmActiveSince(ctx context.Context, key string) (time.Time, error) {
 	resp, err := s.db.Get([]byte(filepath.Join("alarms", key)), nil)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, mapFileError(err)
 	}
 	return time.Parse(time.RFC3339, string(resp))
 }
@@ -85,41 +105,96 @@ func (s *fileStorage) SetLastMessageSendTimestamp(ctx context.Context, key strin
 func (s *fileStorage) GetLastMessageSendTimestamp(ctx context.Context, key string) (time.Time, error) {
 	resp, err := s.db.Get([]byte(filepath.Join("lastMessage", key)), nil)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, mapFileError(err)
 	}
 	return time.Parse(time.RFC3339, string(resp))
 }
 
 func (s *fileStorage) SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error {
-	bs, err := json.Marshal(svc)
+	return s.ApplyServiceConfigs(ctx, []config.ServiceConfig{svc}, nil)
+}
+
+func (s *fileStorage) DeleteServiceConfig(ctx context.Context, id string) error {
+	ok, err := s.db.Has([]byte(filepath.Join("services", id)), nil)
 	if err != nil {
 		return err
 	}
-	err = s.db.Put([]byte(filepath.Join("services", svc.ID)), bs, nil)
+	if !ok {
+		return ErrNotFound
+	}
+	return s.ApplyServiceConfigs(ctx, nil, []string{id})
+}
+
+// ApplyServiceConfigs writes the configs and their label index entries in one batch
+func (s *fileStorage) ApplyServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) error {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	batch := new(leveldb.Batch)
+	for _, id := range remove {
+		err := s.batchServiceConfig(batch, id, nil)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range save {
+		err := s.batchServiceConfig(batch, save[i].ID, &save[i])
+		if err != nil {
+			return err
+		}
+	}
+	err := s.db.Write(batch, nil)
 	if err != nil {
 		return err
 	}
+	s.version.bump()
 	return nil
 }
 
-func (s *fileStorage) DeleteServiceConfig(ctx context.Context, id string) error {
-	err := s.db.Delete([]byte(filepath.Join("services", id)), nil)
+// batchServiceConfig adds the change of a config to the batch, svc is nil for a deletion
+func (s *fileStorage) batchServiceConfig(batch *leveldb.Batch, id string, svc *config.ServiceConfig) error {
+	var old *config.ServiceConfig
+	current, err := s.GetServiceConfig(context.Background(), id)
+	switch err.(type) {
+	case nil:
+		old = &current
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		// the labels of a corrupt config are unknown, it is replaced anyway
+		log.Warn().Str("service", id).Err(err).Msg("replacing service config which can't be decoded")
+	default:
+		if err != ErrNotFound {
+			return err
+		}
+	}
+	remove, add := labelIndexChanges(old, svc)
+	for _, key := range remove {
+		batch.Delete([]byte(key))
+	}
+	for _, key := range add {
+		batch.Put([]byte(key), nil)
+	}
+	key := []byte(filepath.Join("services", id))
+	if svc == nil {
+		batch.Delete(key)
+		return nil
+	}
+	bs, err := encodeServiceConfig(*svc)
 	if err != nil {
 		return err
 	}
+	batch.Put(key, bs)
 	return nil
 }
 
+func (s *fileStorage) GetServiceConfigsVersion(ctx context.Context) (string, error) {
+	return s.version.String(), nil
+}
+
 func (s *fileStorage) GetServiceConfig(ctx context.Context, id string) (cfg config.ServiceConfig, err error) {
 	resp, err := s.db.Get([]byte(filepath.Join("services", id)), nil)
 	if err != nil {
-		return cfg, err
+		return cfg, mapFileError(err)
 	}
-	err = json.Unmarshal(resp, &cfg)
-	if err != nil {
-		return cfg, err
-	}
-	return cfg, nil
+	return decodeServiceConfig(resp)
 }
 
 // GetServiceConfigs implements `config.Provider`
@@ -131,18 +206,76 @@ func (s *fileStorage) GetServiceConfigs(ctx context.Context) (configChannel chan
 			defer close(configChannel)
 			defer close(errorChannel)
 		}()
-		iterator := s.db.NewIterator(util.BytesPrefix([]byte("services")), nil)
+		iterator := s.db.NewIterator(util.BytesPrefix([]byte("services/")), nil)
+		defer iterator.Release()
 		for iterator.Next() {
-			var cfg config.ServiceConfig
-			err := json.Unmarshal(iterator.Value(), &cfg)
+			cfg, err := decodeServiceConfig(iterator.Value())
 			if err != nil {
-				log.Error().Err(err).Str("data", string(iterator.Value())).Msg("failed to unmarshal")
-				errorChannel <- err
-				return
+				// a corrupt config must not stop the checks of all other services, see CheckServiceConfigs
+				log.Error().Err(err).Str("key", string(iterator.Key())).Msg("skipping service config which can't be decoded")
+				continue
 			}
 			log.Debug().Str("key", string(iterator.Key())).Msg("read config from file")
-			configChannel <- cfg
+			select {
+			case <-ctx.Done():
+				errorChannel <- ctx.Err()
+				return
+			case configChannel <- cfg:
+			}
+		}
+		if err := iterator.Error(); err != nil {
+			errorChannel <- err
 		}
 	}()
 	return
 }
+
+func (s *fileStorage) put(ctx context.Context, key string, value []byte) error {
+	return s.db.Put([]byte(key), value, nil)
+}
+
+func (s *fileStorage) get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, mapFileError(err)
+	}
+	return value, nil
+}
+
+func (s *fileStorage) delete(ctx context.Context, key string) error {
+	ok, err := s.db.Has([]byte(key), nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return s.db.Delete([]byte(key), nil)
+}
+
+func (s *fileStorage) list(ctx context.Context, prefix string) ([]kvPair, error) {
+	iterator := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iterator.Release()
+	var pairs []kvPair
+	for iterator.Next() {
+		// the iterator reuses its buffers, so we need to copy
+		pairs = append(pairs, kvPair{
+			key:   string(iterator.Key()),
+			value: append([]byte(nil), iterator.Value()...),
+		})
+	}
+	return pairs, iterator.Error()
+}
+
+// Close closes the underlying database
+func (s *fileStorage) Close() error {
+	return s.db.Close()
+}
+
+// mapFileError translates leveldb errors into the errors of the storage package
+func mapFileError(err error) error {
+	if err == leveldb.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
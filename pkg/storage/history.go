@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// HeartbeatHistory holds the recent heartbeats of a service
+type HeartbeatHistory struct {
+	// Since is the time the history was started, older heartbeats are unknown
+	Since      time.Time   `json:"since"`
+	Heartbeats []time.Time `json:"heartbeats"`
+}
+
+func (o objects) GetHeartbeatHistory(ctx context.Context, key string) (history HeartbeatHistory, err error) {
+	err = o.getObject(ctx, path.Join("history", key), &history)
+	return history, err
+}
+
+func (o objects) SaveHeartbeatHistory(ctx context.Context, key string, history HeartbeatHistory) error {
+	return o.putObject(ctx, path.Join("history", key), history)
+}
+
+func (o objects) SetEarlyWarningActiveSince(ctx context.Context, key string, t time.Time) error {
+	return o.putObject(ctx, path.Join("warnings", key), t)
+}
+
+func (o objects) GetEarlyWarningActiveSince(ctx context.Context, key string) (t time.Time, err error) {
+	err = o.getObject(ctx, path.Join("warnings", key), &t)
+	return t, err
+}
+
+func (o objects) ClearEarlyWarning(ctx context.Context, key string) error {
+	err := o.kv.delete(ctx, path.Join("warnings", key))
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
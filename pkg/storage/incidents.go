@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+)
+
+// openIncidentsPrefix holds an empty entry indexes/open-incidents/<id> for every incident which isn't resolved,
+// so the open incidents are read without the resolved ones
+const openIncidentsPrefix = "indexes/open-incidents"
+
+type IncidentStatus string
+
+const (
+	IncidentStatusOpen         IncidentStatus = "open"
+	IncidentStatusAcknowledged IncidentStatus = "acknowledged"
+	IncidentStatusResolved     IncidentStatus = "resolved"
+)
+
+// Incident groups the alarms of related services
+type Incident struct {
+	ID     string            `json:"id"`
+	Status IncidentStatus    `json:"status"`
+	Labels map[string]string `json:"labels"`
+	// Alarms maps the IDs of the affected services to whether their alarm is still active
+	Alarms     map[string]bool         `json:"alarms"`
+	CreatedAt  time.Time               `json:"createdAt"`
+	ResolvedAt *time.Time              `json:"resolvedAt,omitempty"`
+	Timeline   []IncidentTimelineEntry `json:"timeline"`
+}
+
+type IncidentTimelineEntry struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Service string    `json:"service"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (o objects) GetIncidents(ctx context.Context) ([]Incident, error) {
+	incidents := []Incident{}
+	err := o.listObjects(ctx, "incidents", func(key string, value []byte) error {
+		var incident Incident
+		err := json.Unmarshal(value, &incident)
+		if err != nil {
+			return err
+		}
+		incidents = append(incidents, incident)
+		return nil
+	})
+	return incidents, err
+}
+
+// GetOpenIncidents returns the incidents which aren't resolved
+func (o objects) GetOpenIncidents(ctx context.Context) ([]Incident, error) {
+	incidents := []Incident{}
+	err := o.listObjects(ctx, openIncidentsPrefix, func(key string, _ []byte) error {
+		incident, err := o.GetIncident(ctx, strings.TrimPrefix(key, openIncidentsPrefix+"/"))
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the entry of an incident which was resolved is deleted after the incident was saved
+		if incident.Status != IncidentStatusResolved {
+			incidents = append(incidents, incident)
+		}
+		return nil
+	})
+	return incidents, err
+}
+
+func (o objects) GetIncident(ctx context.Context, id string) (incident Incident, err error) {
+	err = o.getObject(ctx, path.Join("incidents", id), &incident)
+	return incident, err
+}
+
+// SaveIncident saves the incident and its entry in the index of the open incidents. The entry of an open incident
+// is written first and the one of a resolved incident deleted last, so an open incident is never missing from it.
+func (o objects) SaveIncident(ctx context.Context, incident Incident) error {
+	if incident.Status != IncidentStatusResolved {
+		err := o.kv.put(ctx, path.Join(openIncidentsPrefix, incident.ID), nil)
+		if err != nil {
+			return err
+		}
+	}
+	err := o.putObject(ctx, path.Join("incidents", incident.ID), incident)
+	if err != nil || incident.Status != IncidentStatusResolved {
+		return err
+	}
+	err = o.kv.delete(ctx, path.Join(openIncidentsPrefix, incident.ID))
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (o objects) DeleteIncident(ctx context.Context, id string) error {
+	err := o.kv.delete(ctx, path.Join(openIncidentsPrefix, id))
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	return o.kv.delete(ctx, path.Join("incidents", id))
+}
+
+// indexOpenIncidents indexes the incidents which aren't resolved, it is the migration to schema version 3
+func (o objects) indexOpenIncidents(ctx context.Context) error {
+	incidents, err := o.GetIncidents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, incident := range incidents {
+		if incident.Status == IncidentStatusResolved {
+			continue
+		}
+		err = o.kv.put(ctx, path.Join(openIncidentsPrefix, incident.ID), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
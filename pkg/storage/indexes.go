@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+const (
+	// labelIndexPrefix holds an empty entry indexes/labels/<key>=<value>/<service> for every label of every stored service.
+	// The entries are written together with the service configs, so the index never disagrees with them.
+	labelIndexPrefix = "indexes/labels"
+)
+
+// labelIndexSegment escapes key and value, so neither of them can contain a slash or the separator
+func labelIndexSegment(key, value string) string {
+	return url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// labelIndexKeys returns the index entries of a service, svc may be nil
+func labelIndexKeys(svc *config.ServiceConfig) map[string]bool {
+	keys := make(map[string]bool)
+	if svc == nil {
+		return keys
+	}
+	for key, value := range svc.Labels {
+		keys[path.Join(labelIndexPrefix, labelIndexSegment(key, value), svc.ID)] = true
+	}
+	return keys
+}
+
+// labelIndexChanges returns the index entries to delete and to add when old is replaced by updated.
+// Either of them is nil when the service is created or deleted.
+func labelIndexChanges(old, updated *config.ServiceConfig) (remove, add []string) {
+	before, after := labelIndexKeys(old), labelIndexKeys(updated)
+	for key := range before {
+		if !after[key] {
+			remove = append(remove, key)
+		}
+	}
+	for key := range after {
+		if !before[key] {
+			add = append(add, key)
+		}
+	}
+	return remove, add
+}
+
+// GetServicesWithLabel returns the IDs of the stored services which have the label, ordered by ID
+func (o objects) GetServicesWithLabel(ctx context.Context, key, value string) ([]string, error) {
+	prefix := path.Join(labelIndexPrefix, labelIndexSegment(key, value))
+	ids := []string{}
+	err := o.listObjects(ctx, prefix, func(key string, _ []byte) error {
+		ids = append(ids, strings.TrimPrefix(key, prefix+"/"))
+		return nil
+	})
+	return ids, err
+}
+
+// buildLabelIndex indexes the labels of all stored services, it is the migration to schema version 2
+func (o objects) buildLabelIndex(ctx context.Context, store Storage) error {
+	configs, errs := store.GetServiceConfigs(ctx)
+	for svc := range configs {
+		svc := svc
+		for key := range labelIndexKeys(&svc) {
+			err := o.kv.put(ctx, key, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return <-errs
+}
+
+// GetServicesWithLabel uses the index of the stored labels, unless defaults set the label,
+// then the services are filtered after applying the defaults
+func (s *defaultsStorage) GetServicesWithLabel(ctx context.Context, key, value string) ([]string, error) {
+	defaulted := false
+	for _, defaults := range s.defaults {
+		_, ok := defaults.Labels[key]
+		defaulted = defaulted || ok
+	}
+	if !defaulted {
+		return s.Storage.GetServicesWithLabel(ctx, key, value)
+	}
+	ids := []string{}
+	configs, errs := s.GetServiceConfigs(ctx)
+	for svc := range configs {
+		if current, ok := svc.Labels[key]; ok && current == value {
+			ids = append(ids, svc.ID)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
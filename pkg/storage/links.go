@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// Link is a short link which opens the read-only page of a service until it expires
+type Link struct {
+	ID        string    `json:"id"`
+	Service   string    `json:"service"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (o objects) GetLinks(ctx context.Context) ([]Link, error) {
+	links := []Link{}
+	err := o.listObjects(ctx, "links", func(key string, value []byte) error {
+		var link Link
+		err := json.Unmarshal(value, &link)
+		if err != nil {
+			return err
+		}
+		links = append(links, link)
+		return nil
+	})
+	return links, err
+}
+
+func (o objects) GetLink(ctx context.Context, id string) (Link, error) {
+	var link Link
+	err := o.getObject(ctx, path.Join("links", id), &link)
+	return link, err
+}
+
+func (o objects) SaveLink(ctx context.Context, link Link) error {
+	return o.putObject(ctx, path.Join("links", link.ID), link)
+}
+
+func (o objects) DeleteLink(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("links", id))
+}
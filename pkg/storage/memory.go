@@ -2,34 +2,102 @@ package storage
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/trusch/deadman-switch/pkg/config"
 )
 
-func NewMemoryStorage(cfg config.ServerConfig) Storage {
-	return &memoryStorage{
-		cfg:         cfg,
-		heartbeats:  make(map[string]time.Time),
-		active:      make(map[string]time.Time),
-		lastMessage: make(map[string]time.Time),
+const defaultSnapshotInterval = 10 * time.Second
+
+func NewMemoryStorage(ctx context.Context, cfg config.ServerConfig) (Storage, error) {
+	var memCfg config.MemoryStorageConfig
+	err := config.Decode(cfg.Storage.Config, &memCfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &memoryStorage{
+		snapshotFile: memCfg.SnapshotFile,
+		services:     make(map[string]config.ServiceConfig),
+		heartbeats:   make(map[string]time.Time),
+		active:       make(map[string]time.Time),
+		lastMessage:  make(map[string]time.Time),
+		kvs:          make(map[string][]byte),
+		version:      newVersionCounter(),
+	}
+	s.objects = objects{s}
+	if s.snapshotFile != "" {
+		err = s.restoreSnapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = runMigrations(ctx, s, s.migrations())
+	if err != nil {
+		return nil, err
+	}
+	// static configs win over the ones from the snapshot
+	for _, svc := range cfg.Services {
+		s.services[svc.ID] = svc
+	}
+	s.rebuildLabelIndex()
+	if s.snapshotFile != "" {
+		interval := time.Duration(memCfg.SnapshotInterval)
+		if interval <= 0 {
+			interval = defaultSnapshotInterval
+		}
+		go s.backupSnapshots(ctx, interval)
+	}
+	return s, nil
+}
+
+// migrations upgrade the objects of a snapshot of an older version, see SchemaVersion.
+// The label index is rebuilt on every start, it needs no migration.
+func (s *memoryStorage) migrations() []migration {
+	return []migration{
+		{3, "index open incidents", s.indexOpenIncidents},
 	}
 }
 
 type memoryStorage struct {
-	cfg         config.ServerConfig
-	heartbeats  map[string]time.Time
-	active      map[string]time.Time
-	lastMessage map[string]time.Time
+	objects
+	mutex        sync.RWMutex
+	snapshotFile string
+	services     map[string]config.ServiceConfig
+	heartbeats   map[string]time.Time
+	active       map[string]time.Time
+	lastMessage  map[string]time.Time
+	kvs          map[string][]byte
+	version      *versionCounter
+}
+
+// memorySnapshot is the on-disk format of the memory storage snapshots
+type memorySnapshot struct {
+	// Services are encoded like in the other backends, so they are upgraded the same way
+	Services    map[string]json.RawMessage `json:"services"`
+	Heartbeats  map[string]time.Time       `json:"heartbeats"`
+	Active      map[string]time.Time       `json:"active"`
+	LastMessage map[string]time.Time       `json:"lastMessage"`
+	Objects     map[string]json.RawMessage `json:"objects"`
 }
 
-func (s memoryStorage) SetLastHeartbeat(ctx context.Context, key string, t time.Time) error {
+func (s *memoryStorage) SetLastHeartbeat(ctx context.Context, key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.heartbeats[key] = t
 	return nil
 }
 
-func (s memoryStorage) GetLastHeartbeat(ctx context.Context, key string) (time.Time, error) {
+func (s *memoryStorage) GetLastHeartbeat(ctx context.Context, key string) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	t, ok := s.heartbeats[key]
 	if !ok {
 		return t, ErrNotFound
@@ -37,12 +105,16 @@ func (s memoryStorage) GetLastHeartbeat(ctx context.Context, key string) (time.T
 	return t, nil
 }
 
-func (s memoryStorage) SetAlarmActiveSince(ctx context.Context, key string, t time.Time) error {
+func (s *memoryStorage) SetAlarmActiveSince(ctx context.Context, key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.active[key] = t
 	return nil
 }
 
-func (s memoryStorage) GetAlarmActiveSince(ctx context.Context, key string) (time.Time, error) {
+func (s *memoryStorage) GetAlarmActiveSince(ctx context.Context, key string) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	t, ok := s.active[key]
 	if !ok {
 		return t, ErrNotFound
@@ -50,12 +122,16 @@ func (s memoryStorage) GetAlarmActiveSince(ctx context.Context, key string) (tim
 	return t, nil
 }
 
-func (s memoryStorage) SetLastMessageSendTimestamp(ctx context.Context, key string, t time.Time) error {
+func (s *memoryStorage) SetLastMessageSendTimestamp(ctx context.Context, key string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.lastMessage[key] = t
 	return nil
 }
 
-func (s memoryStorage) GetLastMessageSendTimestamp(ctx context.Context, key string) (time.Time, error) {
+func (s *memoryStorage) GetLastMessageSendTimestamp(ctx context.Context, key string) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	t, ok := s.lastMessage[key]
 	if !ok {
 		return t, ErrNotFound
@@ -63,36 +139,47 @@ func (s memoryStorage) GetLastMessageSendTimestamp(ctx context.Context, key stri
 	return t, nil
 }
 
-func (s memoryStorage) ClearAlarm(ctx context.Context, key string) error {
+func (s *memoryStorage) ClearAlarm(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	delete(s.active, key)
 	return nil
 }
 
 func (s *memoryStorage) GetServiceConfig(ctx context.Context, id string) (config.ServiceConfig, error) {
-	for _, svc := range s.cfg.Services {
-		if svc.ID == id {
-			return svc, nil
-		}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	svc, ok := s.services[id]
+	if !ok {
+		return config.ServiceConfig{}, ErrNotFound
 	}
-	return config.ServiceConfig{}, errors.New("not found")
+	return svc, nil
 }
 
-// GetServiceConfigs implements `Provider` for the ServerConfig itself to serve static service configs
+// GetServiceConfigs implements `config.Provider`
 func (s *memoryStorage) GetServiceConfigs(ctx context.Context) (configChannel chan config.ServiceConfig, errorChannel chan error) {
 	configChannel = make(chan config.ServiceConfig, 32)
 	errorChannel = make(chan error, 32)
+
+	// copy the configs, so we don't hold the lock while the consumer is reading
+	s.mutex.RLock()
+	services := make([]config.ServiceConfig, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, svc)
+	}
+	s.mutex.RUnlock()
+
 	go func() {
 		defer func() {
 			defer close(configChannel)
 			defer close(errorChannel)
 		}()
-		for _, val := range s.cfg.Services {
+		for _, val := range services {
 			select {
 			case <-ctx.Done():
 				errorChannel <- ctx.Err()
 				return
-			default:
-				configChannel <- val
+			case configChannel <- val:
 			}
 		}
 	}()
@@ -100,16 +187,258 @@ func (s *memoryStorage) GetServiceConfigs(ctx context.Context) (configChannel ch
 }
 
 func (s *memoryStorage) SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error {
-	s.cfg.Services = append(s.cfg.Services, svc)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.saveServiceConfig(svc)
+	s.version.bump()
 	return nil
 }
 
 func (s *memoryStorage) DeleteServiceConfig(ctx context.Context, id string) error {
-	for idx, val := range s.cfg.Services {
-		if val.ID == id {
-			s.cfg.Services = append(s.cfg.Services[:idx], s.cfg.Services[idx+1:]...)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.services[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteServiceConfig(id)
+	s.version.bump()
+	return nil
+}
+
+func (s *memoryStorage) ApplyServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range remove {
+		s.deleteServiceConfig(id)
+	}
+	for _, svc := range save {
+		s.saveServiceConfig(svc)
+	}
+	s.version.bump()
+	return nil
+}
+
+// rawServiceConfigs encodes the configs, they are kept decoded in memory
+func (s *memoryStorage) rawServiceConfigs(ctx context.Context) ([]kvPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	pairs := make([]kvPair, 0, len(s.services))
+	for id, svc := range s.services {
+		bs, err := encodeServiceConfig(svc)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, kvPair{id, bs})
+	}
+	sortPairs(pairs)
+	return pairs, nil
+}
+
+// saveServiceConfig stores the config and updates the label index, the caller holds the lock
+func (s *memoryStorage) saveServiceConfig(svc config.ServiceConfig) {
+	var old *config.ServiceConfig
+	if current, ok := s.services[svc.ID]; ok {
+		old = &current
+	}
+	s.indexLabels(old, &svc)
+	s.services[svc.ID] = svc
+}
+
+// deleteServiceConfig removes the config and its label index entries, the caller holds the lock
+func (s *memoryStorage) deleteServiceConfig(id string) {
+	if current, ok := s.services[id]; ok {
+		s.indexLabels(&current, nil)
+	}
+	delete(s.services, id)
+}
+
+func (s *memoryStorage) indexLabels(old, updated *config.ServiceConfig) {
+	remove, add := labelIndexChanges(old, updated)
+	for _, key := range remove {
+		delete(s.kvs, key)
+	}
+	for _, key := range add {
+		s.kvs[key] = nil
+	}
+}
+
+// rebuildLabelIndex indexes the services from the snapshot and the config file
+func (s *memoryStorage) rebuildLabelIndex() {
+	for key := range s.kvs {
+		if strings.HasPrefix(key, labelIndexPrefix+"/") {
+			delete(s.kvs, key)
+		}
+	}
+	for id := range s.services {
+		svc := s.services[id]
+		s.indexLabels(nil, &svc)
+	}
+}
+
+func (s *memoryStorage) GetServiceConfigsVersion(ctx context.Context) (string, error) {
+	return s.version.String(), nil
+}
+
+func (s *memoryStorage) put(ctx context.Context, key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.kvs[key] = value
+	return nil
+}
+
+func (s *memoryStorage) get(ctx context.Context, key string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	value, ok := s.kvs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStorage) delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.kvs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.kvs, key)
+	return nil
+}
+
+func (s *memoryStorage) list(ctx context.Context, prefix string) ([]kvPair, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var pairs []kvPair
+	for key, value := range s.kvs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, kvPair{key, value})
+		}
+	}
+	sortPairs(pairs)
+	return pairs, nil
+}
+
+func (s *memoryStorage) backupSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.writeSnapshot()
+			if err != nil {
+				log.Error().Err(err).Str("file", s.snapshotFile).Msg("failed to write memory snapshot")
+			}
+		}
+	}
+}
+
+// Close writes a last snapshot, so nothing gets lost on a clean shutdown
+func (s *memoryStorage) Close() error {
+	if s.snapshotFile == "" {
+		return nil
+	}
+	return s.writeSnapshot()
+}
+
+func (s *memoryStorage) writeSnapshot() error {
+	s.mutex.RLock()
+	services := make(map[string]json.RawMessage, len(s.services))
+	for id, svc := range s.services {
+		bs, err := encodeServiceConfig(svc)
+		if err != nil {
+			s.mutex.RUnlock()
+			return err
+		}
+		services[id] = bs
+	}
+	objects := make(map[string]json.RawMessage, len(s.kvs))
+	for key, value := range s.kvs {
+		objects[key] = value
+	}
+	bs, err := json.Marshal(memorySnapshot{
+		Services:    services,
+		Heartbeats:  s.heartbeats,
+		Active:      s.active,
+		LastMessage: s.lastMessage,
+		Objects:     objects,
+	})
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so a crash never leaves a truncated snapshot behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.snapshotFile), filepath.Base(s.snapshotFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(bs)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.snapshotFile)
+}
+
+func (s *memoryStorage) restoreSnapshot() error {
+	bs, err := ioutil.ReadFile(s.snapshotFile)
+	if err != nil {
+		if os.IsNotExist(err) {
 			return nil
 		}
+		return err
+	}
+	var snapshot memorySnapshot
+	err = json.Unmarshal(bs, &snapshot)
+	if err != nil {
+		return err
+	}
+	for id, value := range snapshot.Services {
+		svc, err := decodeServiceConfig(value)
+		if err != nil {
+			return fmt.Errorf("service %s in snapshot: %w", id, err)
+		}
+		s.services[id] = svc
+	}
+	for key, t := range snapshot.Heartbeats {
+		s.heartbeats[key] = t
+	}
+	for key, t := range snapshot.Active {
+		s.active[key] = t
+	}
+	for key, t := range snapshot.LastMessage {
+		s.lastMessage[key] = t
+	}
+	for key, value := range snapshot.Objects {
+		s.kvs[key] = value
+	}
+	log.Info().Str("file", s.snapshotFile).Int("services", len(s.services)).Msg("restored memory snapshot")
+	return nil
+}
+
+func (s *memoryStorage) GetLastHeartbeats(ctx context.Context) (map[string]time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return copyTimestamps(s.heartbeats), nil
+}
+
+func (s *memoryStorage) GetActiveAlarms(ctx context.Context) (map[string]time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return copyTimestamps(s.active), nil
+}
+
+func copyTimestamps(timestamps map[string]time.Time) map[string]time.Time {
+	result := make(map[string]time.Time, len(timestamps))
+	for key, t := range timestamps {
+		result[key] = t
 	}
-	return errors.New("not found")
+	return result
 }
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// ServiceMetrics are the metrics pushed with the last heartbeat of a service
+type ServiceMetrics struct {
+	Service  string    `json:"service"`
+	PushedAt time.Time `json:"pushedAt"`
+	// Text holds the metric families in the Prometheus text format, already labeled with the service
+	Text string `json:"text"`
+}
+
+func (o objects) GetServiceMetrics(ctx context.Context) ([]ServiceMetrics, error) {
+	metrics := []ServiceMetrics{}
+	err := o.listObjects(ctx, "metrics", func(key string, value []byte) error {
+		var m ServiceMetrics
+		err := json.Unmarshal(value, &m)
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, m)
+		return nil
+	})
+	return metrics, err
+}
+
+func (o objects) SaveServiceMetrics(ctx context.Context, metrics ServiceMetrics) error {
+	return o.putObject(ctx, path.Join("metrics", metrics.Service), metrics)
+}
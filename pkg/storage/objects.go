@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+)
+
+// kv is the minimal key value interface every backend implements.
+// It is used to store plain JSON objects without implementing them for every backend.
+type kv interface {
+	put(ctx context.Context, key string, value []byte) error
+	// get returns ErrNotFound for unknown keys
+	get(ctx context.Context, key string) ([]byte, error)
+	// delete returns ErrNotFound for unknown keys
+	delete(ctx context.Context, key string) error
+	// list returns all values below prefix ordered by key
+	list(ctx context.Context, prefix string) ([]kvPair, error)
+}
+
+type kvPair struct {
+	key   string
+	value []byte
+}
+
+// objects implements the parts of Storage which are plain JSON objects on top of kv
+type objects struct {
+	kv kv
+}
+
+func (o objects) putObject(ctx context.Context, key string, obj interface{}) error {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return o.kv.put(ctx, key, bs)
+}
+
+func (o objects) getObject(ctx context.Context, key string, obj interface{}) error {
+	bs, err := o.kv.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, obj)
+}
+
+// listObjects calls fn with the raw value of every object below prefix
+func (o objects) listObjects(ctx context.Context, prefix string, fn func(key string, value []byte) error) error {
+	pairs, err := o.kv.list(ctx, path.Clean(prefix)+"/")
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		err = fn(pair.key, pair.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortPairs sorts kv pairs by key, for backends without ordered iteration
+func sortPairs(pairs []kvPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].key < pairs[j].key
+	})
+}
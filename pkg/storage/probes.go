@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// Probe is written and read back by the watchdog of a server to measure the round trip of the storage
+type Probe struct {
+	Instance  string    `json:"instance"`
+	Nonce     string    `json:"nonce"`
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+func (o objects) GetProbe(ctx context.Context, instance string) (Probe, error) {
+	var probe Probe
+	err := o.getObject(ctx, path.Join("probes", instance), &probe)
+	return probe, err
+}
+
+func (o objects) SaveProbe(ctx context.Context, probe Probe) error {
+	return o.putObject(ctx, path.Join("probes", probe.Instance), probe)
+}
+
+func (o objects) DeleteProbe(ctx context.Context, instance string) error {
+	return o.kv.delete(ctx, path.Join("probes", instance))
+}
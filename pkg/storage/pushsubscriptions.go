@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// PushSubscription is a browser which subscribed to push notifications through the app
+type PushSubscription struct {
+	// ID is derived from the endpoint, so a browser which subscribes again replaces its subscription
+	ID       string `json:"id"`
+	User     string `json:"user"`
+	Endpoint string `json:"endpoint"`
+	// P256dh and Auth are the keys of the browser to encrypt the messages
+	P256dh    string    `json:"p256dh"`
+	Auth      string    `json:"auth"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (o objects) GetPushSubscriptions(ctx context.Context) ([]PushSubscription, error) {
+	subscriptions := []PushSubscription{}
+	err := o.listObjects(ctx, "push-subscriptions", func(key string, value []byte) error {
+		var subscription PushSubscription
+		err := json.Unmarshal(value, &subscription)
+		if err != nil {
+			return err
+		}
+		subscriptions = append(subscriptions, subscription)
+		return nil
+	})
+	return subscriptions, err
+}
+
+func (o objects) GetPushSubscription(ctx context.Context, id string) (PushSubscription, error) {
+	var subscription PushSubscription
+	err := o.getObject(ctx, path.Join("push-subscriptions", id), &subscription)
+	return subscription, err
+}
+
+func (o objects) SavePushSubscription(ctx context.Context, subscription PushSubscription) error {
+	return o.putObject(ctx, path.Join("push-subscriptions", subscription.ID), subscription)
+}
+
+func (o objects) DeletePushSubscription(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("push-subscriptions", id))
+}
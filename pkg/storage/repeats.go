@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// AlertRepeats records the repeated alerts of an alarm
+type AlertRepeats struct {
+	// AlarmActiveSince identifies the alarm, the repeats of an older alarm don't count
+	AlarmActiveSince time.Time `json:"alarmActiveSince"`
+	// LastAlertAt is when the alert was sent the last time
+	LastAlertAt time.Time `json:"lastAlertAt"`
+	// Repeats counts the alerts after the first one
+	Repeats int `json:"repeats"`
+}
+
+func (o objects) SetAlertRepeats(ctx context.Context, key string, repeats AlertRepeats) error {
+	return o.putObject(ctx, path.Join("repeats", key), repeats)
+}
+
+func (o objects) GetAlertRepeats(ctx context.Context, key string) (repeats AlertRepeats, err error) {
+	err = o.getObject(ctx, path.Join("repeats", key), &repeats)
+	return repeats, err
+}
+
+func (o objects) ClearAlertRepeats(ctx context.Context, key string) error {
+	err := o.kv.delete(ctx, path.Join("repeats", key))
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
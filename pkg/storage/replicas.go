@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+)
+
+func (o objects) SetReplicaHeartbeat(ctx context.Context, key, replica string, t time.Time) error {
+	return o.putObject(ctx, path.Join("replicas", key, replica), t)
+}
+
+// GetReplicaHeartbeats returns the last heartbeats of all replicas of a service by replica ID
+func (o objects) GetReplicaHeartbeats(ctx context.Context, key string) (map[string]time.Time, error) {
+	prefix := path.Join("replicas", key) + "/"
+	heartbeats := make(map[string]time.Time)
+	err := o.listObjects(ctx, prefix, func(k string, value []byte) error {
+		replica := strings.TrimPrefix(k, prefix)
+		// replicas of a service below this one
+		if strings.Contains(replica, "/") {
+			return nil
+		}
+		var t time.Time
+		err := json.Unmarshal(value, &t)
+		if err != nil {
+			return err
+		}
+		heartbeats[replica] = t
+		return nil
+	})
+	return heartbeats, err
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// SchemaVersion is the version of the stored objects written by this version.
+// Raise it together with a migration of every backend or an upgrade of the service configs.
+//
+//	1: everything before the schema was versioned
+//	2: index of the service labels
+//	3: index of the open incidents
+const SchemaVersion = 3
+
+// schemaVersionKey holds the version of the stored objects of a backend
+const schemaVersionKey = "schema/version"
+
+// migration upgrades the stored objects of a backend from the previous version to version.
+// Nodes sharing an etcd may run a migration at the same time, so it must be idempotent.
+type migration struct {
+	version     int
+	description string
+	migrate     func(ctx context.Context) error
+}
+
+// runMigrations runs the migrations newer than the stored schema version in order and records the version after each
+func runMigrations(ctx context.Context, store kv, migrations []migration) error {
+	current, err := storedSchemaVersion(ctx, store)
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		log.Warn().Int("stored", current).Int("supported", SchemaVersion).Msg("storage was written by a newer version")
+		return nil
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Info().Int("version", m.version).Str("migration", m.description).Msg("migrate storage")
+		err = m.migrate(ctx)
+		if err != nil {
+			return fmt.Errorf("migration to schema version %d (%s): %w", m.version, m.description, err)
+		}
+		current = m.version
+		err = store.put(ctx, schemaVersionKey, []byte(strconv.Itoa(current)))
+		if err != nil {
+			return err
+		}
+	}
+	if current < SchemaVersion {
+		return store.put(ctx, schemaVersionKey, []byte(strconv.Itoa(SchemaVersion)))
+	}
+	return nil
+}
+
+// storedSchemaVersion returns the version of the stored objects, stores from before the versioning are version 1
+func storedSchemaVersion(ctx context.Context, store kv) (int, error) {
+	value, err := store.get(ctx, schemaVersionKey)
+	if err == ErrNotFound {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+// serviceConfigUpgrades change the stored JSON of a service config from the previous version to the version of the key.
+// They work on the raw fields, so renamed or restructured fields can be carried over before the config is decoded.
+var serviceConfigUpgrades = map[int]func(fields map[string]json.RawMessage) error{}
+
+// encodeServiceConfig encodes a config for the storage, stamped with the current schema version
+func encodeServiceConfig(svc config.ServiceConfig) ([]byte, error) {
+	svc.SchemaVersion = SchemaVersion
+	return json.Marshal(svc)
+}
+
+// decodeServiceConfig decodes a stored config, configs written with an older schema are upgraded first
+func decodeServiceConfig(value []byte) (svc config.ServiceConfig, err error) {
+	value, err = upgradeServiceConfig(value)
+	if err != nil {
+		return svc, err
+	}
+	err = json.Unmarshal(value, &svc)
+	svc.SchemaVersion = 0
+	return svc, err
+}
+
+// upgradeServiceConfig returns the stored JSON of a config in the current schema
+func upgradeServiceConfig(value []byte) ([]byte, error) {
+	if len(serviceConfigUpgrades) == 0 {
+		return value, nil
+	}
+	var stored struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	err := json.Unmarshal(value, &stored)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	for v := stored.SchemaVersion + 1; v <= SchemaVersion; v++ {
+		upgrade, ok := serviceConfigUpgrades[v]
+		if !ok {
+			continue
+		}
+		if fields == nil {
+			err = json.Unmarshal(value, &fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = upgrade(fields)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade service config to schema version %d: %w", v, err)
+		}
+	}
+	if fields == nil {
+		return value, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/ids"
+)
+
+// keepServiceEvents is the number of events kept per service
+const keepServiceEvents = 1000
+
+// ServiceEventType is what happened to a service
+type ServiceEventType string
+
+const (
+	ServiceEventHeartbeatMissed ServiceEventType = "heartbeat.missed"
+	ServiceEventAlertSent       ServiceEventType = "alert.sent"
+	ServiceEventRecoverySent    ServiceEventType = "recovery.sent"
+	ServiceEventAcknowledged    ServiceEventType = "acknowledged"
+	ServiceEventConfigChanged   ServiceEventType = "config.changed"
+	ServiceEventConfigDeleted   ServiceEventType = "config.deleted"
+)
+
+// ServiceEvent is an entry of the event log of a service
+type ServiceEvent struct {
+	// ID sorts by time, it is set by AppendServiceEvent
+	ID      string           `json:"id"`
+	Time    time.Time        `json:"time"`
+	Service string           `json:"service"`
+	Type    ServiceEventType `json:"type"`
+	By      string           `json:"by,omitempty"`
+	Details string           `json:"details,omitempty"`
+}
+
+// AppendServiceEvent adds the event to the log of its service and drops the oldest events beyond keepServiceEvents
+func (o objects) AppendServiceEvent(ctx context.Context, event ServiceEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	suffix, err := ids.Random(8)
+	if err != nil {
+		return err
+	}
+	// the events of a service may follow each other within a second
+	event.ID = event.Time.UTC().Format("20060102T150405.000000000Z") + "-" + suffix
+	err = o.putObject(ctx, path.Join("service-events", event.Service, event.ID), event)
+	if err != nil {
+		return err
+	}
+	keys, err := o.serviceEventKeys(ctx, event.Service)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(keys)-keepServiceEvents; i++ {
+		err = o.kv.delete(ctx, keys[i])
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetServiceEvents returns the event log of a service, the oldest event first
+func (o objects) GetServiceEvents(ctx context.Context, service string) ([]ServiceEvent, error) {
+	events := []ServiceEvent{}
+	err := o.listServiceEvents(ctx, service, func(key string, value []byte) error {
+		var event ServiceEvent
+		err := json.Unmarshal(value, &event)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+// DeleteServiceEvents drops the event log of a service
+func (o objects) DeleteServiceEvents(ctx context.Context, service string) error {
+	keys, err := o.serviceEventKeys(ctx, service)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = o.kv.delete(ctx, key)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o objects) serviceEventKeys(ctx context.Context, service string) ([]string, error) {
+	var keys []string
+	err := o.listServiceEvents(ctx, service, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// listServiceEvents lists the events of the service, without the ones of the services below its ID
+func (o objects) listServiceEvents(ctx context.Context, service string, fn func(key string, value []byte) error) error {
+	prefix := path.Join("service-events", service) + "/"
+	return o.listObjects(ctx, prefix, func(key string, value []byte) error {
+		if strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+			return nil
+		}
+		return fn(key, value)
+	})
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// configSilencePrefix is the prefix of the IDs of the silences of the config file
+const configSilencePrefix = "config-"
+
+// Silence suppresses the alerts of the selected services from StartsAt until EndsAt
+type Silence struct {
+	ID       string          `json:"id"`
+	Services config.Selector `json:"services"`
+	StartsAt time.Time       `json:"startsAt"`
+	// EndsAt is zero for recurring silences which recur until they are expired
+	EndsAt time.Time `json:"endsAt"`
+	// Recurrence limits the silence to its windows, like a weekly maintenance
+	Recurrence *config.SilenceRecurrence `json:"recurrence,omitempty"`
+	CreatedBy  string                    `json:"createdBy"`
+	Comment    string                    `json:"comment,omitempty"`
+	CreatedAt  time.Time                 `json:"createdAt"`
+	// UpdatedAt is the time of the last change, like the expiry, it decides between the versions of two replicas
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Modified returns the time of the last change, silences from before UpdatedAt was added only have CreatedAt
+func (s Silence) Modified() time.Time {
+	if s.UpdatedAt.IsZero() {
+		return s.CreatedAt
+	}
+	return s.UpdatedAt
+}
+
+// Active reports whether the silence applies at t, a recurring one only within its windows
+func (s Silence) Active(t time.Time) bool {
+	if t.Before(s.StartsAt) || s.Ended(t) {
+		return false
+	}
+	if s.Recurrence == nil {
+		return true
+	}
+	_, ok := s.Recurrence.Window(t)
+	return ok
+}
+
+// ActiveUntil returns the end of the silence, or of the current window of a recurring one
+func (s Silence) ActiveUntil(t time.Time) time.Time {
+	if s.Recurrence == nil {
+		return s.EndsAt
+	}
+	start, _ := s.Recurrence.Window(t)
+	end := start.Add(time.Duration(s.Recurrence.Duration))
+	if !s.EndsAt.IsZero() && s.EndsAt.Before(end) {
+		return s.EndsAt
+	}
+	return end
+}
+
+// Ended reports whether the silence is over at t, a recurring silence without an end never is
+func (s Silence) Ended(t time.Time) bool {
+	return !s.EndsAt.IsZero() && !t.Before(s.EndsAt)
+}
+
+// Silences reports whether the silence suppresses the alerts of the service at t
+func (s Silence) Silences(svc config.ServiceConfig, t time.Time) bool {
+	return s.Active(t) && s.Services.Matches(svc)
+}
+
+func (o objects) GetSilences(ctx context.Context) ([]Silence, error) {
+	silences := []Silence{}
+	err := o.listObjects(ctx, "silences", func(key string, value []byte) error {
+		var silence Silence
+		err := json.Unmarshal(value, &silence)
+		if err != nil {
+			return err
+		}
+		silences = append(silences, silence)
+		return nil
+	})
+	return silences, err
+}
+
+func (o objects) GetSilence(ctx context.Context, id string) (Silence, error) {
+	var silence Silence
+	err := o.getObject(ctx, path.Join("silences", id), &silence)
+	return silence, err
+}
+
+func (o objects) SaveSilence(ctx context.Context, silence Silence) error {
+	return o.putObject(ctx, path.Join("silences", silence.ID), silence)
+}
+
+func (o objects) DeleteSilence(ctx context.Context, id string) error {
+	return o.kv.delete(ctx, path.Join("silences", id))
+}
+
+// SaveConfigSilences saves the silences of the config file with the IDs config-<name>. Unchanged ones are left
+// alone, so they keep their modification time, the ones which were removed from the config are expired.
+func SaveConfigSilences(ctx context.Context, store Storage, silences []config.SilenceConfig, now time.Time) error {
+	stored, err := store.GetSilences(ctx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]Silence, len(stored))
+	for _, silence := range stored {
+		existing[silence.ID] = silence
+	}
+	now = now.UTC()
+	configured := make(map[string]bool, len(silences))
+	for _, cfg := range silences {
+		silence := Silence{
+			ID:         configSilencePrefix + cfg.Name,
+			Services:   cfg.Services,
+			StartsAt:   cfg.StartsAt.UTC(),
+			EndsAt:     cfg.EndsAt.UTC(),
+			Recurrence: cfg.Recurrence,
+			CreatedBy:  "config",
+			Comment:    cfg.Comment,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		configured[silence.ID] = true
+		if current, ok := existing[silence.ID]; ok {
+			silence.CreatedAt = current.CreatedAt
+			current.UpdatedAt = now
+			if a, b := mustJSON(current), mustJSON(silence); a != "" && a == b {
+				continue
+			}
+		}
+		err = store.SaveSilence(ctx, silence)
+		if err != nil {
+			return err
+		}
+	}
+	for id, silence := range existing {
+		if !strings.HasPrefix(id, configSilencePrefix) || configured[id] || silence.Ended(now) {
+			continue
+		}
+		silence.EndsAt, silence.UpdatedAt = now, now
+		if silence.StartsAt.After(now) {
+			silence.StartsAt = now
+		}
+		err = store.SaveSilence(ctx, silence)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mustJSON(v interface{}) string {
+	bs, _ := json.Marshal(v)
+	return string(bs)
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// SlackWorkspace is a Slack workspace the Slack app was installed in, see package slackapp
+type SlackWorkspace struct {
+	TeamID    string `json:"teamID"`
+	TeamName  string `json:"teamName"`
+	BotUserID string `json:"botUserID"`
+	Scope     string `json:"scope"`
+	// AccessToken is the bot token. With token rotation it expires at ExpiresAt and is refreshed with RefreshToken.
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	// InstalledBy is the ID of the Slack user who installed the app
+	InstalledBy string    `json:"installedBy"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+func (o objects) GetSlackWorkspaces(ctx context.Context) ([]SlackWorkspace, error) {
+	workspaces := []SlackWorkspace{}
+	err := o.listObjects(ctx, "slack/workspaces", func(key string, value []byte) error {
+		var workspace SlackWorkspace
+		err := json.Unmarshal(value, &workspace)
+		if err != nil {
+			return err
+		}
+		workspaces = append(workspaces, workspace)
+		return nil
+	})
+	return workspaces, err
+}
+
+func (o objects) GetSlackWorkspace(ctx context.Context, teamID string) (SlackWorkspace, error) {
+	var workspace SlackWorkspace
+	err := o.getObject(ctx, path.Join("slack/workspaces", teamID), &workspace)
+	return workspace, err
+}
+
+func (o objects) SaveSlackWorkspace(ctx context.Context, workspace SlackWorkspace) error {
+	return o.putObject(ctx, path.Join("slack/workspaces", workspace.TeamID), workspace)
+}
+
+func (o objects) DeleteSlackWorkspace(ctx context.Context, teamID string) error {
+	return o.kv.delete(ctx, path.Join("slack/workspaces", teamID))
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+// HeartbeatSource describes who sent a heartbeat
+type HeartbeatSource struct {
+	Time         time.Time `json:"time"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	ForwardedFor string    `json:"forwardedFor,omitempty"`
+	UserAgent    string    `json:"userAgent,omitempty"`
+	// Metadata is supplied by the client, like the host name of the replica
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// GetHeartbeatSources returns the sources of the recent heartbeats, the newest first
+func (o objects) GetHeartbeatSources(ctx context.Context, key string) (sources []HeartbeatSource, err error) {
+	err = o.getObject(ctx, path.Join("sources", key), &sources)
+	return sources, err
+}
+
+func (o objects) SaveHeartbeatSources(ctx context.Context, key string, sources []HeartbeatSource) error {
+	return o.putObject(ctx, path.Join("sources", key), sources)
+}
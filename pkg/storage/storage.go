@@ -20,6 +20,32 @@ type Storage interface {
 	GetAlarmActiveSince(ctx context.Context, key string) (time.Time, error)
 	ClearAlarm(ctx context.Context, key string) error
 
+	// GetLastHeartbeats and GetActiveAlarms read the state of all services at once
+	GetLastHeartbeats(ctx context.Context) (map[string]time.Time, error)
+	GetActiveAlarms(ctx context.Context) (map[string]time.Time, error)
+
+	SetAlarmAcknowledgement(ctx context.Context, key string, ack Acknowledgement) error
+	GetAlarmAcknowledgement(ctx context.Context, key string) (Acknowledgement, error)
+	ClearAlarmAcknowledgement(ctx context.Context, key string) error
+
+	SetEscalation(ctx context.Context, key string, escalation Escalation) error
+	GetEscalation(ctx context.Context, key string) (Escalation, error)
+	ClearEscalation(ctx context.Context, key string) error
+
+	SetAlertRepeats(ctx context.Context, key string, repeats AlertRepeats) error
+	GetAlertRepeats(ctx context.Context, key string) (AlertRepeats, error)
+	ClearAlertRepeats(ctx context.Context, key string) error
+
+	GetHeartbeatHistory(ctx context.Context, key string) (HeartbeatHistory, error)
+	SaveHeartbeatHistory(ctx context.Context, key string, history HeartbeatHistory) error
+	SetReplicaHeartbeat(ctx context.Context, key, replica string, t time.Time) error
+	GetReplicaHeartbeats(ctx context.Context, key string) (map[string]time.Time, error)
+	GetHeartbeatSources(ctx context.Context, key string) ([]HeartbeatSource, error)
+	SaveHeartbeatSources(ctx context.Context, key string, sources []HeartbeatSource) error
+	SetEarlyWarningActiveSince(ctx context.Context, key string, t time.Time) error
+	GetEarlyWarningActiveSince(ctx context.Context, key string) (time.Time, error)
+	ClearEarlyWarning(ctx context.Context, key string) error
+
 	SetLastMessageSendTimestamp(ctx context.Context, key string, t time.Time) error
 	GetLastMessageSendTimestamp(ctx context.Context, key string) (time.Time, error)
 
@@ -27,4 +53,104 @@ type Storage interface {
 	GetServiceConfig(ctx context.Context, id string) (config.ServiceConfig, error)
 	SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error
 	DeleteServiceConfig(ctx context.Context, id string) error
+	// ApplyServiceConfigs saves and deletes service configs in one atomic step.
+	// An ID must not be saved and deleted at once, deleting an unknown ID is no error.
+	ApplyServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) error
+	// GetServiceConfigHistory returns the recorded versions of a service config, see WithConfigVersions
+	GetServiceConfigHistory(ctx context.Context, id string) (ServiceConfigHistory, error)
+	SaveServiceConfigHistory(ctx context.Context, id string, history ServiceConfigHistory) error
+	// GetServiceConfigHistories returns the histories of all services by ID, including the deleted ones
+	GetServiceConfigHistories(ctx context.Context) (map[string]ServiceConfigHistory, error)
+	// GetServiceConfigsVersion returns a token which changes whenever a service config is saved or deleted
+	GetServiceConfigsVersion(ctx context.Context) (string, error)
+	// GetServicesWithLabel returns the IDs of the services with the label, from an index kept together with the configs
+	GetServicesWithLabel(ctx context.Context, key, value string) ([]string, error)
+
+	GetContacts(ctx context.Context) ([]config.Contact, error)
+	GetContact(ctx context.Context, id string) (config.Contact, error)
+	SaveContact(ctx context.Context, contact config.Contact) error
+	DeleteContact(ctx context.Context, id string) error
+
+	GetIncidents(ctx context.Context) ([]Incident, error)
+	// GetOpenIncidents returns the incidents which aren't resolved, without reading the resolved ones
+	GetOpenIncidents(ctx context.Context) ([]Incident, error)
+	GetIncident(ctx context.Context, id string) (Incident, error)
+	SaveIncident(ctx context.Context, incident Incident) error
+	DeleteIncident(ctx context.Context, id string) error
+
+	GetActionRuns(ctx context.Context) ([]ActionRun, error)
+	GetActionRun(ctx context.Context, service string) (ActionRun, error)
+	SaveActionRun(ctx context.Context, run ActionRun) error
+
+	GetApprovals(ctx context.Context) ([]Approval, error)
+	GetApproval(ctx context.Context, id string) (Approval, error)
+	SaveApproval(ctx context.Context, approval Approval) error
+
+	GetServiceMetrics(ctx context.Context) ([]ServiceMetrics, error)
+	SaveServiceMetrics(ctx context.Context, metrics ServiceMetrics) error
+
+	GetDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id string) (DeadLetter, error)
+	SaveDeadLetter(ctx context.Context, letter DeadLetter) error
+	DeleteDeadLetter(ctx context.Context, id string) error
+
+	// GetQuarantinedConfigs returns the stored service configs which were moved out of the way by CheckServiceConfigs
+	GetQuarantinedConfigs(ctx context.Context) ([]QuarantinedConfig, error)
+	SaveQuarantinedConfig(ctx context.Context, quarantined QuarantinedConfig) error
+	DeleteQuarantinedConfig(ctx context.Context, id string) error
+
+	// GetArchivedServices returns the services which were moved out of the checks by Archive
+	GetArchivedServices(ctx context.Context) ([]ArchivedService, error)
+	GetArchivedService(ctx context.Context, id string) (ArchivedService, error)
+	SaveArchivedService(ctx context.Context, archived ArchivedService) error
+	DeleteArchivedService(ctx context.Context, id string) error
+
+	// GetSlackWorkspaces returns the workspaces the Slack app was installed in, keyed by their team ID
+	GetSlackWorkspaces(ctx context.Context) ([]SlackWorkspace, error)
+	GetSlackWorkspace(ctx context.Context, teamID string) (SlackWorkspace, error)
+	SaveSlackWorkspace(ctx context.Context, workspace SlackWorkspace) error
+	DeleteSlackWorkspace(ctx context.Context, teamID string) error
+
+	// GetSilences returns all silences, including the ones which ended and weren't pruned yet
+	GetSilences(ctx context.Context) ([]Silence, error)
+	GetSilence(ctx context.Context, id string) (Silence, error)
+	SaveSilence(ctx context.Context, silence Silence) error
+	DeleteSilence(ctx context.Context, id string) error
+
+	// GetAuditEntries returns the recorded manual interventions ordered by their ID
+	GetAuditEntries(ctx context.Context) ([]AuditEntry, error)
+	SaveAuditEntry(ctx context.Context, entry AuditEntry) error
+	DeleteAuditEntry(ctx context.Context, id string) error
+
+	// AppendServiceEvent records what happened to a service, GetServiceEvents returns its events ordered by their ID
+	AppendServiceEvent(ctx context.Context, event ServiceEvent) error
+	GetServiceEvents(ctx context.Context, service string) ([]ServiceEvent, error)
+	DeleteServiceEvents(ctx context.Context, service string) error
+
+	// GetCountdown returns the last countdown warning of a service
+	GetCountdown(ctx context.Context, service string) (Countdown, error)
+	SaveCountdown(ctx context.Context, countdown Countdown) error
+	DeleteCountdown(ctx context.Context, service string) error
+
+	// GetLinks returns all short links, including the expired ones which weren't pruned yet
+	GetLinks(ctx context.Context) ([]Link, error)
+	GetLink(ctx context.Context, id string) (Link, error)
+	SaveLink(ctx context.Context, link Link) error
+	DeleteLink(ctx context.Context, id string) error
+
+	GetPushSubscriptions(ctx context.Context) ([]PushSubscription, error)
+	GetPushSubscription(ctx context.Context, id string) (PushSubscription, error)
+	SavePushSubscription(ctx context.Context, subscription PushSubscription) error
+	DeletePushSubscription(ctx context.Context, id string) error
+
+	// GetUsage returns the usage records of a day (2006-01-02)
+	GetUsage(ctx context.Context, day string) ([]Usage, error)
+	SaveUsage(ctx context.Context, record Usage) error
+	// DeleteUsageBefore deletes the usage records of the days before day (2006-01-02)
+	DeleteUsageBefore(ctx context.Context, day string) error
+
+	// GetProbe returns the probe of the watchdog of a server, see Probe
+	GetProbe(ctx context.Context, instance string) (Probe, error)
+	SaveProbe(ctx context.Context, probe Probe) error
+	DeleteProbe(ctx context.Context, instance string) error
 }
@@ -0,0 +1,76 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+	"github.com/trusch/deadman-switch/pkg/storage/storagetest"
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewMemoryStorage(ctx, config.ServerConfig{
+		Storage: config.StorageConfig{Type: config.StorageTypeMemory},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storagetest.TestStorage(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStorage(config.ServerConfig{
+		Storage: config.StorageConfig{
+			Type:   config.StorageTypeFile,
+			Config: map[string]interface{}{"file": filepath.Join(t.TempDir(), "db")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if err := storagetest.TestStorage(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEtcdStorage runs against the etcd cluster in DEADMAN_SWITCH_TEST_ETCD, e.g. localhost:2379
+func TestEtcdStorage(t *testing.T) {
+	endpoints := os.Getenv("DEADMAN_SWITCH_TEST_ETCD")
+	if endpoints == "" {
+		t.Skip("set DEADMAN_SWITCH_TEST_ETCD to the endpoints of an etcd cluster")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	prefix := fmt.Sprintf("/deadman-switch-storagetest-%d", time.Now().UnixNano())
+	defer cli.Delete(context.Background(), prefix, clientv3.WithPrefix())
+	store, err := storage.NewEtcdStorage(ctx, cli, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storagetest.TestStorage(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+}
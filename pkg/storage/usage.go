@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// Usage counts what a tenant used on a day (UTC) on one server, every server of a cluster has its own record
+// under its node ID
+type Usage struct {
+	Day           string `json:"day"`
+	Tenant        string `json:"tenant"`
+	Instance      string `json:"instance"`
+	Pings         int64  `json:"pings"`
+	RejectedPings int64  `json:"rejectedPings"`
+	Notifications int64  `json:"notifications"`
+}
+
+// GetUsage returns the records of all tenants and servers of the day
+func (o objects) GetUsage(ctx context.Context, day string) ([]Usage, error) {
+	records := []Usage{}
+	err := o.listObjects(ctx, path.Join("usage", day), func(key string, value []byte) error {
+		var record Usage
+		err := json.Unmarshal(value, &record)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func (o objects) SaveUsage(ctx context.Context, record Usage) error {
+	return o.putObject(ctx, path.Join("usage", record.Day, record.Tenant, record.Instance), record)
+}
+
+// DeleteUsageBefore deletes the records of all days before day
+func (o objects) DeleteUsageBefore(ctx context.Context, day string) error {
+	var keys []string
+	err := o.listObjects(ctx, "usage", func(key string, value []byte) error {
+		if strings.SplitN(strings.TrimPrefix(key, "usage/"), "/", 2)[0] < day {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = o.kv.delete(ctx, key)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// versionCounter is the change token of backends without revisions of their own.
+// The epoch makes the tokens of different processes distinct, so a restart never
+// returns a token a client already saw for other content.
+type versionCounter struct {
+	epoch   string
+	counter uint64
+}
+
+func newVersionCounter() *versionCounter {
+	return &versionCounter{epoch: strconv.FormatInt(time.Now().UnixNano(), 36)}
+}
+
+func (v *versionCounter) bump() {
+	atomic.AddUint64(&v.counter, 1)
+}
+
+func (v *versionCounter) String() string {
+	return v.epoch + "-" + strconv.FormatUint(atomic.LoadUint64(&v.counter), 10)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+)
+
+// keepConfigVersions is the number of versions kept per service
+const keepConfigVersions = 20
+
+// ServiceConfigVersion is a config a service had at some point
+type ServiceConfigVersion struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Deleted marks the deletion of the service, Config is nil then
+	Deleted bool                  `json:"deleted,omitempty"`
+	Config  *config.ServiceConfig `json:"config,omitempty"`
+}
+
+// ServiceConfigHistory holds the recent versions of a service config, the oldest first
+type ServiceConfigHistory struct {
+	Versions []ServiceConfigVersion `json:"versions"`
+}
+
+// Latest returns the current version, ok is false for an empty history
+func (h ServiceConfigHistory) Latest() (version ServiceConfigVersion, ok bool) {
+	if len(h.Versions) == 0 {
+		return version, false
+	}
+	return h.Versions[len(h.Versions)-1], true
+}
+
+// Get returns the given version
+func (h ServiceConfigHistory) Get(version int) (ServiceConfigVersion, error) {
+	for _, v := range h.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return ServiceConfigVersion{}, ErrNotFound
+}
+
+// add appends a version unless it equals the latest one and reports whether it did
+func (h *ServiceConfigHistory) add(svc *config.ServiceConfig, now time.Time) bool {
+	deleted := svc == nil
+	latest, ok := h.Latest()
+	if ok && latest.Deleted == deleted && sameConfig(latest.Config, svc) {
+		return false
+	}
+	h.Versions = append(h.Versions, ServiceConfigVersion{
+		Version:   latest.Version + 1,
+		CreatedAt: now,
+		Deleted:   deleted,
+		Config:    svc,
+	})
+	if len(h.Versions) > keepConfigVersions {
+		h.Versions = h.Versions[len(h.Versions)-keepConfigVersions:]
+	}
+	return true
+}
+
+func sameConfig(a, b *config.ServiceConfig) bool {
+	bsA, errA := json.Marshal(a)
+	bsB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(bsA, bsB)
+}
+
+func (o objects) GetServiceConfigHistory(ctx context.Context, id string) (history ServiceConfigHistory, err error) {
+	err = o.getObject(ctx, path.Join("config-versions", id), &history)
+	return history, err
+}
+
+func (o objects) GetServiceConfigHistories(ctx context.Context) (map[string]ServiceConfigHistory, error) {
+	histories := make(map[string]ServiceConfigHistory)
+	err := o.listObjects(ctx, "config-versions", func(key string, value []byte) error {
+		var history ServiceConfigHistory
+		err := json.Unmarshal(value, &history)
+		if err != nil {
+			return err
+		}
+		histories[strings.TrimPrefix(key, "config-versions/")] = history
+		return nil
+	})
+	return histories, err
+}
+
+func (o objects) SaveServiceConfigHistory(ctx context.Context, id string, history ServiceConfigHistory) error {
+	return o.putObject(ctx, path.Join("config-versions", id), history)
+}
+
+// WithConfigVersions wraps a storage so every change of a service config is recorded in its history.
+// A config which was changed without the wrapper, like the ones from the config file, is recorded
+// before it is changed the next time.
+func WithConfigVersions(store Storage) Storage {
+	return &versionsStorage{store}
+}
+
+type versionsStorage struct {
+	Storage
+}
+
+func (s *versionsStorage) SaveServiceConfig(ctx context.Context, svc config.ServiceConfig) error {
+	history, err := s.history(ctx, svc.ID)
+	if err != nil {
+		return err
+	}
+	err = s.Storage.SaveServiceConfig(ctx, svc)
+	if err != nil {
+		return err
+	}
+	return s.saveHistory(ctx, svc.ID, history, &svc, time.Now().UTC())
+}
+
+func (s *versionsStorage) DeleteServiceConfig(ctx context.Context, id string) error {
+	history, err := s.history(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = s.Storage.DeleteServiceConfig(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.saveHistory(ctx, id, history, nil, time.Now().UTC())
+}
+
+func (s *versionsStorage) ApplyServiceConfigs(ctx context.Context, save []config.ServiceConfig, remove []string) error {
+	histories := make(map[string]ServiceConfigHistory)
+	for _, svc := range save {
+		history, err := s.history(ctx, svc.ID)
+		if err != nil {
+			return err
+		}
+		histories[svc.ID] = history
+	}
+	for _, id := range remove {
+		history, err := s.history(ctx, id)
+		if err != nil {
+			return err
+		}
+		histories[id] = history
+	}
+	err := s.Storage.ApplyServiceConfigs(ctx, save, remove)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i := range save {
+		err = s.saveHistory(ctx, save[i].ID, histories[save[i].ID], &save[i], now)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range remove {
+		err = s.saveHistory(ctx, id, histories[id], nil, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveHistory adds the config to the history of the service and records the change in its event log, a nil
+// config is a deletion
+func (s *versionsStorage) saveHistory(ctx context.Context, id string, history ServiceConfigHistory, svc *config.ServiceConfig, now time.Time) error {
+	added := history.add(svc, now)
+	err := s.SaveServiceConfigHistory(ctx, id, history)
+	if err != nil || !added {
+		return err
+	}
+	latest, _ := history.Latest()
+	event := ServiceEvent{
+		Time:    now,
+		Service: id,
+		Type:    ServiceEventConfigChanged,
+		Details: fmt.Sprintf("version %d", latest.Version),
+	}
+	if latest.Deleted {
+		event.Type = ServiceEventConfigDeleted
+	}
+	return s.AppendServiceEvent(ctx, event)
+}
+
+// history returns the history of a service, including its currently stored config
+func (s *versionsStorage) history(ctx context.Context, id string) (ServiceConfigHistory, error) {
+	history, err := s.GetServiceConfigHistory(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return history, err
+	}
+	current, err := s.Storage.GetServiceConfig(ctx, id)
+	if err == ErrNotFound {
+		return history, nil
+	}
+	if err != nil {
+		return history, err
+	}
+	history.add(&current, time.Now().UTC())
+	return history, nil
+}
+
+func (s *versionsStorage) Close() error {
+	if closer, ok := s.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+// Package usage meters the pings, services and notifications of the tenants of a shared deployment
+// and enforces their quotas.
+//
+// Every server counts in memory and adds its counts to its daily records in the storage in an interval, the usage
+// report sums the records of all servers. The records are kept under the node ID of the server, so they continue
+// after a restart, and are deleted after MaxDays. The ping rate is limited by every server on its own.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/clock"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	flushInterval = 30 * time.Second
+	dayLayout     = "2006-01-02"
+)
+
+// MaxDays is the number of days a usage report may cover and the records are kept
+const MaxDays = 366
+
+// Meter counts the usage of the tenants of this server
+type Meter struct {
+	tenants []config.TenantConfig
+	store   storage.Storage
+	clock   clock.Clock
+	node    string
+
+	mutex sync.Mutex
+	// counts are the counts since the last flush by tenant and day
+	counts map[dayKey]*storage.Usage
+	// windows are the pings of the current minute by tenant
+	windows map[string]*pingWindow
+
+	// flushMutex serializes the flushes, which read and update the records of this server
+	flushMutex sync.Mutex
+	// pruned is the day the old records were deleted last
+	pruned string
+}
+
+type dayKey struct {
+	tenant, day string
+}
+
+type pingWindow struct {
+	start time.Time
+	pings int
+}
+
+// TenantUsage is the usage of a tenant in the requested days together with its quotas
+type TenantUsage struct {
+	Tenant            string     `json:"tenant"`
+	Prefix            string     `json:"prefix"`
+	Services          int        `json:"services"`
+	MaxServices       int        `json:"maxServices,omitempty"`
+	MaxPingsPerMinute int        `json:"maxPingsPerMinute,omitempty"`
+	Pings             int64      `json:"pings"`
+	RejectedPings     int64      `json:"rejectedPings"`
+	Notifications     int64      `json:"notifications"`
+	Days              []DayUsage `json:"days"`
+}
+
+// DayUsage is the usage of a tenant on a day, summed over all servers
+type DayUsage struct {
+	Day           string `json:"day"`
+	Pings         int64  `json:"pings"`
+	RejectedPings int64  `json:"rejectedPings"`
+	Notifications int64  `json:"notifications"`
+}
+
+// NewMeter returns a meter which keeps the records of this server under the node ID
+func NewMeter(tenants []config.TenantConfig, store storage.Storage, clock clock.Clock, node string) *Meter {
+	return &Meter{
+		tenants: tenants,
+		store:   store,
+		clock:   clock,
+		node:    node,
+		counts:  make(map[dayKey]*storage.Usage),
+		windows: make(map[string]*pingWindow),
+	}
+}
+
+// AllowPing counts a ping of the service and reports whether its tenant is within its ping rate.
+// If not, it returns the time the tenant may ping again.
+func (m *Meter) AllowPing(serviceID string, now time.Time) (bool, time.Time) {
+	tenant, ok := config.TenantOf(m.tenants, serviceID)
+	if !ok {
+		return true, time.Time{}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	counts := m.countsOf(tenant.Name, now)
+	if tenant.MaxPingsPerMinute > 0 {
+		start := now.Truncate(time.Minute)
+		window, ok := m.windows[tenant.Name]
+		if !ok || !window.start.Equal(start) {
+			window = &pingWindow{start: start}
+			m.windows[tenant.Name] = window
+		}
+		if window.pings >= tenant.MaxPingsPerMinute {
+			counts.RejectedPings++
+			return false, start.Add(time.Minute)
+		}
+		window.pings++
+	}
+	counts.Pings++
+	return true, time.Time{}
+}
+
+// CountNotification counts a notification about the service
+func (m *Meter) CountNotification(serviceID string) {
+	tenant, ok := config.TenantOf(m.tenants, serviceID)
+	if !ok {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.countsOf(tenant.Name, m.clock.Now()).Notifications++
+}
+
+// countsOf returns the counts of the tenant for the day of now, the mutex must be held
+func (m *Meter) countsOf(tenant string, now time.Time) *storage.Usage {
+	key := dayKey{tenant: tenant, day: now.UTC().Format(dayLayout)}
+	counts, ok := m.counts[key]
+	if !ok {
+		counts = &storage.Usage{Day: key.day, Tenant: tenant, Instance: m.node}
+		m.counts[key] = counts
+	}
+	return counts
+}
+
+// QuotaError is returned when a service doesn't fit into the service quota of its tenant
+type QuotaError struct {
+	Service     string
+	Tenant      string
+	MaxServices int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s exceeds the quota of tenant %s of %d services", e.Service, e.Tenant, e.MaxServices)
+}
+
+// CheckServices returns a *QuotaError if the services which are created don't fit into the quotas of their tenants,
+// after the services which are deleted are gone. Tenants which are over their quota already may still delete services.
+func (m *Meter) CheckServices(ctx context.Context, created, deleted []string) error {
+	if len(created) == 0 {
+		return nil
+	}
+	services, err := m.countServices(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range deleted {
+		if tenant, ok := config.TenantOf(m.tenants, id); ok {
+			services[tenant.Name]--
+		}
+	}
+	for _, id := range created {
+		tenant, ok := config.TenantOf(m.tenants, id)
+		if !ok {
+			continue
+		}
+		if tenant.MaxServices > 0 && services[tenant.Name] >= tenant.MaxServices {
+			return &QuotaError{Service: id, Tenant: tenant.Name, MaxServices: tenant.MaxServices}
+		}
+		services[tenant.Name]++
+	}
+	return nil
+}
+
+func (m *Meter) countServices(ctx context.Context) (map[string]int, error) {
+	services := make(map[string]int)
+	configs, errs := m.store.GetServiceConfigs(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case svc, ok := <-configs:
+			if !ok {
+				return services, nil
+			}
+			if tenant, ok := config.TenantOf(m.tenants, svc.ID); ok {
+				services[tenant.Name]++
+			}
+		case err := <-errs:
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Backend saves the counts in an interval until the context is done, and once more afterwards.
+// Once a day it deletes the records which are older than MaxDays.
+func (m *Meter) Backend(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			m.flush(shutdownCtx)
+			return
+		case <-ticker.C:
+			m.flush(ctx)
+			m.prune(ctx)
+		}
+	}
+}
+
+// flush adds the counts since the last flush to the records of this server. Counts which can't be saved are
+// kept for the next flush.
+func (m *Meter) flush(ctx context.Context) {
+	m.flushMutex.Lock()
+	defer m.flushMutex.Unlock()
+	m.mutex.Lock()
+	pending := m.counts
+	m.counts = make(map[dayKey]*storage.Usage)
+	m.mutex.Unlock()
+	stored := make(map[string][]storage.Usage)
+	for _, counts := range pending {
+		records, ok := stored[counts.Day]
+		if !ok {
+			var err error
+			records, err = m.store.GetUsage(ctx, counts.Day)
+			if err != nil {
+				log.Error().Str("day", counts.Day).Err(err).Msg("failed to read usage")
+				m.keep(counts)
+				continue
+			}
+			stored[counts.Day] = records
+		}
+		record := *counts
+		for _, r := range records {
+			if r.Tenant == record.Tenant && r.Instance == record.Instance {
+				record.Pings += r.Pings
+				record.RejectedPings += r.RejectedPings
+				record.Notifications += r.Notifications
+			}
+		}
+		err := m.store.SaveUsage(ctx, record)
+		if err != nil {
+			log.Error().Str("tenant", record.Tenant).Err(err).Msg("failed to save usage")
+			m.keep(counts)
+		}
+	}
+}
+
+// keep adds counts which couldn't be saved to the counts of the next flush
+func (m *Meter) keep(counts *storage.Usage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := dayKey{tenant: counts.Tenant, day: counts.Day}
+	current, ok := m.counts[key]
+	if !ok {
+		m.counts[key] = counts
+		return
+	}
+	current.Pings += counts.Pings
+	current.RejectedPings += counts.RejectedPings
+	current.Notifications += counts.Notifications
+}
+
+// prune deletes the records of all servers which are older than MaxDays, once a day
+func (m *Meter) prune(ctx context.Context) {
+	today := m.clock.Now().UTC()
+	if m.pruned == today.Format(dayLayout) {
+		return
+	}
+	err := m.store.DeleteUsageBefore(ctx, today.AddDate(0, 0, -MaxDays).Format(dayLayout))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prune usage")
+		return
+	}
+	m.pruned = today.Format(dayLayout)
+}
+
+// Usage sums the records of all servers for the days from and to (inclusive), the services are counted now.
+// The counts of the last interval of this server are included, the ones of other servers may be missing.
+func (m *Meter) Usage(ctx context.Context, from, to time.Time) ([]TenantUsage, error) {
+	m.flush(ctx)
+	services, err := m.countServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := make([]TenantUsage, 0, len(m.tenants))
+	byTenant := make(map[string]*TenantUsage)
+	for _, tenant := range m.tenants {
+		report = append(report, TenantUsage{
+			Tenant:            tenant.Name,
+			Prefix:            tenant.Prefix,
+			Services:          services[tenant.Name],
+			MaxServices:       tenant.MaxServices,
+			MaxPingsPerMinute: tenant.MaxPingsPerMinute,
+			Days:              []DayUsage{},
+		})
+	}
+	for i := range report {
+		byTenant[report[i].Tenant] = &report[i]
+	}
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		records, err := m.store.GetUsage(ctx, day.Format(dayLayout))
+		if err != nil {
+			return nil, err
+		}
+		days := make(map[string]*DayUsage)
+		for _, record := range records {
+			usage, ok := byTenant[record.Tenant]
+			if !ok {
+				// a tenant which was removed from the config
+				continue
+			}
+			usage.Pings += record.Pings
+			usage.RejectedPings += record.RejectedPings
+			usage.Notifications += record.Notifications
+			dayUsage, ok := days[record.Tenant]
+			if !ok {
+				dayUsage = &DayUsage{Day: record.Day}
+				days[record.Tenant] = dayUsage
+			}
+			dayUsage.Pings += record.Pings
+			dayUsage.RejectedPings += record.RejectedPings
+			dayUsage.Notifications += record.Notifications
+		}
+		for tenant, dayUsage := range days {
+			byTenant[tenant].Days = append(byTenant[tenant].Days, *dayUsage)
+		}
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Tenant < report[j].Tenant })
+	return report, nil
+}
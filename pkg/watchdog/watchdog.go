@@ -0,0 +1,306 @@
+// Package watchdog detects when the deadman switch itself can't do its job and reports it through
+// the meta notifications, which are sent directly and don't depend on the storage or the queue.
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/concurrency"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/notifier"
+	"github.com/trusch/deadman-switch/pkg/queue"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultInterval      = 30 * time.Second
+	defaultStalledCycles = 3
+	defaultQueueStall    = 5 * time.Minute
+	probeTimeout         = 10 * time.Second
+
+	defaultBackendThreshold = time.Second
+	defaultBackendFailures  = 3
+
+	// probeService is looked up to check that the storage answers
+	probeService = "deadman-switch/watchdog"
+)
+
+// Problems the watchdog reports
+const (
+	ProblemStorage = "storage"
+	ProblemChecker = "checker"
+	ProblemQueue   = "queue"
+	ProblemBackend = "backend"
+)
+
+// Checker reports the progress of the deadline checks
+type Checker interface {
+	LastCycle() time.Time
+}
+
+// clusterProblems are shared by all instances, only the leader checks them so a problem is reported once
+var clusterProblems = []string{ProblemStorage, ProblemQueue}
+
+// Watchdog checks the checker and the storage backend of this instance in an interval, the leader checks the
+// storage and the queue consumer as well
+type Watchdog struct {
+	store         storage.Storage
+	queue         queue.Queue
+	concurrency   concurrency.Client
+	checker       Checker
+	checkInterval time.Duration
+	notifier      notifier.Notifier
+	cfg           config.MetaAlertsConfig
+	// failing holds the errors of the problems which were reported and not resolved yet
+	mutex   sync.Mutex
+	failing map[string]string
+	started time.Time
+	// instance identifies the probe of this process, degraded counts the consecutive degraded probes
+	instance     string
+	degraded     int
+	lastProbe    time.Duration
+	lastProbeErr string
+}
+
+// NewWatchdog returns a watchdog, queue and checker may be nil to skip their checks. Without a concurrency
+// client the instance checks everything.
+func NewWatchdog(store storage.Storage, queue queue.Queue, concurrency concurrency.Client, checker Checker, checkInterval time.Duration, notifier notifier.Notifier, cfg config.MetaAlertsConfig) *Watchdog {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	if cfg.StalledCycles <= 0 {
+		cfg.StalledCycles = defaultStalledCycles
+	}
+	if cfg.QueueStall <= 0 {
+		cfg.QueueStall = config.Duration(defaultQueueStall)
+	}
+	if cfg.Backend != nil {
+		backend := *cfg.Backend
+		if backend.Threshold <= 0 {
+			backend.Threshold = config.Duration(defaultBackendThreshold)
+		}
+		if backend.Failures <= 0 {
+			backend.Failures = defaultBackendFailures
+		}
+		cfg.Backend = &backend
+	}
+	return &Watchdog{
+		store:         store,
+		queue:         queue,
+		concurrency:   concurrency,
+		checker:       checker,
+		checkInterval: checkInterval,
+		notifier:      notifier,
+		cfg:           cfg,
+		failing:       make(map[string]string),
+		instance:      uuid.New().String(),
+	}
+}
+
+// Backend runs the checks until the context is done. It uses the wall clock, the checks are about this process.
+func (w *Watchdog) Backend(ctx context.Context) {
+	w.started = time.Now()
+	ticker := time.NewTicker(time.Duration(w.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx, time.Now())
+		}
+	}
+}
+
+func (w *Watchdog) check(ctx context.Context, now time.Time) {
+	var storageErr error
+	if w.isLeader(ctx) {
+		storageErr = w.checkStorage(ctx)
+		w.report(ctx, ProblemStorage, storageErr)
+		if w.queue != nil {
+			w.report(ctx, ProblemQueue, w.checkQueue(ctx, now))
+		}
+	} else {
+		w.forget(clusterProblems)
+	}
+	// an unreachable storage is reported already, the probe would fail as well
+	if w.cfg.Backend != nil && storageErr == nil {
+		w.report(ctx, ProblemBackend, w.checkBackend(ctx))
+	}
+	if w.checker != nil {
+		w.report(ctx, ProblemChecker, w.checkChecker(now))
+	}
+}
+
+// isLeader reports whether this instance checks the cluster-wide problems. Without a working election every
+// instance checks them, duplicate alerts are better than none.
+func (w *Watchdog) isLeader(ctx context.Context) bool {
+	if w.concurrency == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	leader, err := w.concurrency.IsLeader(ctx, "/deadman-switch/check-leader")
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to check the leadership, the watchdog checks the cluster")
+		return true
+	}
+	return leader
+}
+
+// forget drops the problems without a recovery after the leadership moved, the new leader reports them
+func (w *Watchdog) forget(problems []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, problem := range problems {
+		delete(w.failing, problem)
+	}
+}
+
+// checkStorage reads a key which usually doesn't exist, any answer but an error means the storage is reachable
+func (w *Watchdog) checkStorage(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	_, err := w.store.GetLastHeartbeat(ctx, probeService)
+	if err != nil && err != storage.ErrNotFound {
+		return fmt.Errorf("the storage is unreachable: %w", err)
+	}
+	return nil
+}
+
+// checkBackend writes a probe, reads it back and deletes it. It reports the backend as degraded after
+// the configured number of consecutive probes which failed or were slower than the threshold.
+func (w *Watchdog) checkBackend(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	err := w.probeBackend(ctx)
+	roundTrip := time.Since(start)
+	if err == nil && roundTrip > time.Duration(w.cfg.Backend.Threshold) {
+		err = fmt.Errorf("the storage round trip took %s, more than %s", roundTrip.Round(time.Microsecond), time.Duration(w.cfg.Backend.Threshold))
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.lastProbe = roundTrip
+	w.lastProbeErr = ""
+	if err == nil {
+		w.degraded = 0
+		return nil
+	}
+	w.lastProbeErr = err.Error()
+	w.degraded++
+	if w.degraded < w.cfg.Backend.Failures {
+		log.Warn().Err(err).Int("degraded", w.degraded).Msg("storage backend probe degraded")
+		return nil
+	}
+	return fmt.Errorf("the storage backend is degraded for %d probes: %w", w.degraded, err)
+}
+
+func (w *Watchdog) probeBackend(ctx context.Context) error {
+	probe := storage.Probe{Instance: w.instance, Nonce: uuid.New().String(), WrittenAt: time.Now().UTC()}
+	err := w.store.SaveProbe(ctx, probe)
+	if err != nil {
+		return fmt.Errorf("failed to write the probe: %w", err)
+	}
+	read, err := w.store.GetProbe(ctx, w.instance)
+	if err != nil {
+		return fmt.Errorf("failed to read the probe: %w", err)
+	}
+	if read.Nonce != probe.Nonce {
+		return errors.New("the storage returned a stale probe")
+	}
+	err = w.store.DeleteProbe(ctx, w.instance)
+	if err != nil {
+		return fmt.Errorf("failed to delete the probe: %w", err)
+	}
+	return nil
+}
+
+func (w *Watchdog) checkChecker(now time.Time) error {
+	last := w.checker.LastCycle()
+	since := w.started
+	if last.After(since) {
+		since = last
+	}
+	limit := time.Duration(w.cfg.StalledCycles) * w.checkInterval
+	if now.Sub(since) > limit {
+		if last.IsZero() {
+			return fmt.Errorf("the checker hasn't completed a cycle since the start %s ago", now.Sub(since).Round(time.Second))
+		}
+		return fmt.Errorf("the checker hasn't completed a cycle for %s, the last one completed at %s", now.Sub(since).Round(time.Second), last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkQueue reports a stalled consumer if a due notification waits for too long.
+// Retried notifications are due in the future and don't count.
+func (w *Watchdog) checkQueue(ctx context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	items, err := w.queue.List(ctx)
+	if err != nil {
+		return fmt.Errorf("the notification queue can't be read: %w", err)
+	}
+	var oldest time.Duration
+	for _, item := range items {
+		if item.DueAt.IsZero() || item.DueAt.After(now) {
+			continue
+		}
+		if age := now.Sub(item.DueAt); age > oldest {
+			oldest = age
+		}
+	}
+	if oldest > time.Duration(w.cfg.QueueStall) {
+		return fmt.Errorf("the notification queue consumer is stalled, a notification is waiting for %s", oldest.Round(time.Second))
+	}
+	return nil
+}
+
+// report sends a meta alert when a problem starts and a recovery when it is resolved
+func (w *Watchdog) report(ctx context.Context, problem string, err error) {
+	if err != nil {
+		log.Error().Str("problem", problem).Err(err).Msg("deadman switch can't do its job")
+		w.mutex.Lock()
+		_, reported := w.failing[problem]
+		w.failing[problem] = err.Error()
+		w.mutex.Unlock()
+		if reported {
+			return
+		}
+		_ = w.notifier.SendMetaNotifications(ctx, w.cfg.Notifications, problem, false, err.Error())
+		return
+	}
+	w.mutex.Lock()
+	_, reported := w.failing[problem]
+	delete(w.failing, problem)
+	w.mutex.Unlock()
+	if !reported {
+		return
+	}
+	log.Info().Str("problem", problem).Msg("deadman switch problem resolved")
+	_ = w.notifier.SendMetaNotifications(ctx, w.cfg.Notifications, problem, true, problem+" works again")
+}
+
+// BackendProbe returns the round trip and the error of the last probe of the storage backend
+func (w *Watchdog) BackendProbe() (time.Duration, string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lastProbe, w.lastProbeErr
+}
+
+// Failing returns the errors of the problems which are not resolved yet
+func (w *Watchdog) Failing() map[string]string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	failing := make(map[string]string, len(w.failing))
+	for problem, err := range w.failing {
+		failing[problem] = err
+	}
+	return failing
+}
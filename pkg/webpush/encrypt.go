@@ -0,0 +1,88 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// recordSize of the encrypted content, a message is sent in a single record
+	recordSize = 4096
+	// MaxPayloadSize is the largest payload which fits into a record with the delimiter and the tag
+	MaxPayloadSize = recordSize - 1 - 16
+)
+
+// encrypt encrypts the payload for the subscription with the aes128gcm content coding (RFC 8291 and RFC 8188)
+// with a random salt and an ephemeral key pair
+func encrypt(payload []byte, uaPublic, authSecret []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, err
+	}
+	asPrivate, _, _, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return encryptWith(payload, uaPublic, authSecret, salt, asPrivate)
+}
+
+// encryptWith encrypts the payload with the given salt and private key, the result carries the salt
+// and the public key in its header
+func encryptWith(payload, uaPublic, authSecret, salt, asPrivate []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, errors.New("the payload is too large")
+	}
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid p256dh key of the subscription")
+	}
+	asX, asY := curve.ScalarBaseMult(asPrivate)
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := make([]byte, 32)
+	sharedX.FillBytes(ecdhSecret)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	// 0x02 delimits the last record
+	plaintext := append(append([]byte{}, payload...), 2)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// hkdf derives a key of up to 32 bytes from the input keying material (RFC 5869)
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
@@ -0,0 +1,33 @@
+package webpush
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// TestEncryptRFC8291 encrypts the example of RFC 8291, section 5
+func TestEncryptRFC8291(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	var (
+		plaintext  = []byte("When I grow up, I want to be a watermelon")
+		asPrivate  = decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+		uaPublic   = decode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+		authSecret = decode("BTBZMqHH6r4Tts7J_aSIgg")
+		salt       = decode("DGv6ra1nlYgDCS1FRnbzlw")
+		expected   = decode("DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	)
+	body, err := encryptWith(plaintext, uaPublic, authSecret, salt, asPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, expected) {
+		t.Fatalf("want\n%s\ngot\n%s", base64.RawURLEncoding.EncodeToString(expected), base64.RawURLEncoding.EncodeToString(body))
+	}
+}
@@ -0,0 +1,218 @@
+// Package webpush delivers notifications to the browsers which subscribed through the app of the server.
+//
+// Messages are encrypted for the subscription (RFC 8291) and sent to its push service with a VAPID
+// authorization (RFC 8292), so no third party alerting service is needed.
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/egress"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+const (
+	defaultTTL = 24 * time.Hour
+	// vapidExpiry is the lifetime of the VAPID tokens, push services accept up to 24h
+	vapidExpiry = 12 * time.Hour
+)
+
+// ErrGone is returned by Send if the push service doesn't know the subscription anymore
+var ErrGone = errors.New("the subscription is gone")
+
+// Notification is shown by the service worker of the app
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// URL is opened when the notification is clicked
+	URL string `json:"url,omitempty"`
+	// Tag replaces older notifications with the same tag
+	Tag string `json:"tag,omitempty"`
+	// Urgent notifications stay until they are clicked or dismissed
+	Urgent bool `json:"urgent,omitempty"`
+}
+
+// GenerateKeys returns a new VAPID key pair, encoded like the keys in the config
+func GenerateKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	private := make([]byte, 32)
+	key.D.FillBytes(private)
+	public := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+	return base64.RawURLEncoding.EncodeToString(public), base64.RawURLEncoding.EncodeToString(private), nil
+}
+
+// Pusher sends notifications to the stored subscriptions
+type Pusher struct {
+	cfg    config.WebPushConfig
+	store  storage.Storage
+	key    *ecdsa.PrivateKey
+	cli    *http.Client
+	egress *egress.Policy
+}
+
+// NewPusher creates the pusher, the endpoints of the subscriptions are checked against the egress policy,
+// which may be nil
+func NewPusher(cfg config.WebPushConfig, store storage.Storage, egress *egress.Policy) (*Pusher, error) {
+	private, err := base64.RawURLEncoding.DecodeString(cfg.PrivateKey)
+	if err != nil || len(private) != 32 {
+		return nil, errors.New("the private key must be 32 bytes in unpadded base64url")
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(private)}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(private)
+	if base64.RawURLEncoding.EncodeToString(elliptic.Marshal(curve, key.X, key.Y)) != cfg.PublicKey {
+		return nil, errors.New("the public key doesn't belong to the private key")
+	}
+	return &Pusher{
+		cfg:    cfg,
+		store:  store,
+		key:    key,
+		egress: egress,
+		cli: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: egress.Transport(),
+		},
+	}, nil
+}
+
+// PublicKey is the application server key the browsers subscribe with
+func (p *Pusher) PublicKey() string {
+	return p.cfg.PublicKey
+}
+
+// Push sends the notification to all subscriptions of the users, or to all subscriptions without users.
+// Subscriptions which are gone are deleted.
+func (p *Pusher) Push(ctx context.Context, users []string, notification Notification, ttl time.Duration) error {
+	subscriptions, err := p.store.GetPushSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool)
+	for _, user := range users {
+		wanted[user] = true
+	}
+	var failed error
+	sent := 0
+	for _, subscription := range subscriptions {
+		if len(users) > 0 && !wanted[subscription.User] {
+			continue
+		}
+		err = p.Send(ctx, subscription, notification, ttl)
+		if err == ErrGone {
+			log.Info().Str("user", subscription.User).Str("subscription", subscription.ID).Msg("deleting expired push subscription")
+			err = p.store.DeletePushSubscription(ctx, subscription.ID)
+		}
+		if err != nil {
+			log.Error().Str("user", subscription.User).Str("subscription", subscription.ID).Err(err).Msg("failed to push notification")
+			failed = errors.New("failed to push to some subscriptions")
+			continue
+		}
+		sent++
+	}
+	if sent == 0 && failed == nil {
+		return errors.New("no push subscriptions for the users")
+	}
+	return failed
+}
+
+// Send encrypts the notification and sends it to the push service of the subscription
+func (p *Pusher) Send(ctx context.Context, subscription storage.PushSubscription, notification Notification, ttl time.Duration) error {
+	// the subscriptions were checked when they were stored, the policy may have changed since
+	err := p.egress.CheckURL(ctx, subscription.Endpoint)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	uaPublic, err := base64.RawURLEncoding.DecodeString(subscription.P256dh)
+	if err != nil {
+		return fmt.Errorf("invalid p256dh key of the subscription: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(subscription.Auth)
+	if err != nil {
+		return fmt.Errorf("invalid auth secret of the subscription: %w", err)
+	}
+	body, err := encrypt(payload, uaPublic, authSecret)
+	if err != nil {
+		return err
+	}
+	token, err := p.vapidToken(subscription.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, p.cfg.PublicKey))
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl/time.Second)))
+	if notification.Urgent {
+		req.Header.Set("Urgency", "high")
+	}
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("the push service answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// vapidToken signs a JWT for the origin of the push service (RFC 8292)
+func (p *Pusher) vapidToken(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidExpiry).Unix(),
+		"sub": p.cfg.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
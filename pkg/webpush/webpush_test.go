@@ -0,0 +1,125 @@
+package webpush
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trusch/deadman-switch/pkg/config"
+	"github.com/trusch/deadman-switch/pkg/storage"
+)
+
+// TestSend decrypts a pushed notification with the user agent key of RFC 8291 and checks the VAPID token
+func TestSend(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	uaPrivate := decode("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")
+	uaPublic := "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	authSecret := "BTBZMqHH6r4Tts7J_aSIgg"
+
+	var (
+		header http.Header
+		body   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	public, private, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher, err := NewPusher(config.WebPushConfig{PublicKey: public, PrivateKey: private, Subject: "mailto:ops@example.com"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification := Notification{Title: "backup", Body: "missed its deadline", Urgent: true}
+	err = pusher.Send(context.Background(), storage.PushSubscription{Endpoint: srv.URL + "/push/1", P256dh: uaPublic, Auth: authSecret}, notification, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Content-Encoding") != "aes128gcm" || header.Get("TTL") != "60" || header.Get("Urgency") != "high" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+
+	// RFC 8188: salt, record size, key ID (the public key of the application server) and one record
+	if len(body) < 21 || binary.BigEndian.Uint32(body[16:20]) != recordSize || int(body[20]) != 65 || len(body) < 21+65 {
+		t.Fatalf("invalid header of the body %x", body)
+	}
+	salt, asPublic, record := body[:16], body[21:21+65], body[21+65:]
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sharedX, _ := curve.ScalarMult(asX, asY, uaPrivate)
+	ecdhSecret := make([]byte, 32)
+	sharedX.FillBytes(ecdhSecret)
+	keyInfo := append(append([]byte("WebPush: info\x00"), decode(uaPublic)...), asPublic...)
+	ikm := hkdf(decode(authSecret), ecdhSecret, keyInfo, 32)
+	block, err := aes.NewCipher(hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12), record, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt the body: %v", err)
+	}
+	if plaintext[len(plaintext)-1] != 2 {
+		t.Fatalf("the record isn't delimited as the last one: %x", plaintext)
+	}
+	var got Notification
+	err = json.Unmarshal(plaintext[:len(plaintext)-1], &got)
+	if err != nil || got != notification {
+		t.Fatalf("want %+v, got %+v (%v)", notification, got, err)
+	}
+
+	// RFC 8292: vapid t=<ES256 JWT for the origin>, k=<public key>
+	var token, key string
+	_, err = fmt.Sscanf(strings.Replace(header.Get("Authorization"), ",", "", 1), "vapid t=%s k=%s", &token, &key)
+	if err != nil || key != public {
+		t.Fatalf("invalid authorization %q", header.Get("Authorization"))
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid token %q", token)
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	err = json.Unmarshal(decode(parts[1]), &claims)
+	if err != nil || claims.Aud != srv.URL || claims.Sub != "mailto:ops@example.com" || claims.Exp <= time.Now().Unix() {
+		t.Fatalf("unexpected claims %+v (%v)", claims, err)
+	}
+	x, y := elliptic.Unmarshal(curve, decode(public))
+	signature := decode(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(signature) != 64 || !ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Fatal("invalid signature of the token")
+	}
+}
@@ -0,0 +1,129 @@
+// Package zabbix sends values to trapper items of a Zabbix server or proxy with the sender protocol, like
+// zabbix_sender does.
+package zabbix
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// DefaultPort is the port of the trapper of Zabbix servers and proxies
+	DefaultPort = "10051"
+	// maxResponse limits the response of the server, it is a short JSON object
+	maxResponse = 64 * 1024
+)
+
+// header starts every message of the protocol, followed by the flags and the length of the data
+var header = []byte("ZBXD")
+
+// failed is the count of rejected values in the info of the response
+var failed = regexp.MustCompile(`failed: (\d+)`)
+
+// Value is sent to the trapper item with the key on the host
+type Value struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock,omitempty"`
+}
+
+// Address returns the server address with the default port if it has none
+func Address(server string) (string, error) {
+	if server == "" {
+		return "", errors.New("the server address is empty")
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// no port
+		host, port = server, DefaultPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid server address %q", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Send sends the values and returns an error if the server rejected any of them, which happens if the host or
+// the trapper item don't exist. control is called with the resolved address before connecting, see net.Dialer,
+// it may be nil.
+func Send(ctx context.Context, server string, values []Value, control func(network, address string, c syscall.RawConn) error) error {
+	address, err := Address(server)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(struct {
+		Request string  `json:"request"`
+		Data    []Value `json:"data"`
+	}{"sender data", values})
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline, Control: control}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	var msg bytes.Buffer
+	msg.Write(header)
+	msg.WriteByte(0x01)
+	_ = binary.Write(&msg, binary.LittleEndian, uint64(len(data)))
+	msg.Write(data)
+	_, err = conn.Write(msg.Bytes())
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, 13)
+	_, err = io.ReadFull(conn, head)
+	if err != nil {
+		return fmt.Errorf("no response from the server: %w", err)
+	}
+	if !bytes.Equal(head[:4], header) {
+		return errors.New("malformed response, is this a zabbix trapper?")
+	}
+	length := binary.LittleEndian.Uint64(head[5:])
+	if length > maxResponse {
+		return fmt.Errorf("response of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return fmt.Errorf("malformed response: %w", err)
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("the server answered %s: %s", resp.Response, resp.Info)
+	}
+	if match := failed.FindStringSubmatch(resp.Info); match != nil {
+		if n, _ := strconv.Atoi(match[1]); n > 0 {
+			return fmt.Errorf("the server rejected %d of %d values, check the host and the trapper items: %s", n, len(values), resp.Info)
+		}
+	}
+	return nil
+}